@@ -0,0 +1,230 @@
+/*
+File Username:  Admin.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Remote administration over Peernet messages. This allows an operator to manage their own headless nodes without exposing an HTTP API.
+Requests are authenticated by the regular packet signature; only requests from public keys listed in the config setting AdminPublicKeys are accepted.
+The message timestamp is checked against the local time to limit replay of captured requests. Within the accepted time drift, the nonce of each
+request is remembered and requests with a known nonce are rejected.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// adminMaxTimeDrift is the max accepted difference between the timestamp of an admin request and the local time.
+const adminMaxTimeDrift = 60 * time.Second
+
+// adminNonces contains the nonces of accepted admin requests until their timestamp is outside the accepted time drift.
+type adminNonces struct {
+	seen map[adminNonceKey]time.Time // Expiry by sender and nonce
+	sync.Mutex
+}
+
+type adminNonceKey struct {
+	publicKey [btcec.PubKeyBytesLenCompressed]byte
+	nonce     uint64
+}
+
+func (backend *Backend) initAdmin() {
+	backend.adminNonces = &adminNonces{seen: make(map[adminNonceKey]time.Time)}
+}
+
+// adminNonceUse records the nonce of the request. It returns false if the request is a replay.
+func (backend *Backend) adminNonceUse(publicKey *btcec.PublicKey, nonce uint64, timestamp time.Time) bool {
+	key := adminNonceKey{nonce: nonce}
+	copy(key.publicKey[:], publicKey.SerializeCompressed())
+
+	nonces := backend.adminNonces
+	now := time.Now()
+
+	nonces.Lock()
+	defer nonces.Unlock()
+
+	for key, expires := range nonces.seen {
+		if now.After(expires) {
+			delete(nonces.seen, key)
+		}
+	}
+
+	if _, ok := nonces.seen[key]; ok {
+		return false
+	}
+
+	// Timestamps have a resolution of seconds, therefore the nonce is kept one second longer than the time drift.
+	nonces.seen[key] = timestamp.Add(adminMaxTimeDrift + time.Second)
+
+	return true
+}
+
+// SetLogTarget changes the target for log messages. See Config.LogTarget. The change is not stored in the config.
+func (backend *Backend) SetLogTarget(target int) {
+	atomic.StoreInt32(&backend.logTarget, int32(target))
+}
+
+// AdminStatus is the response data to the admin status action.
+type AdminStatus struct {
+	Version           string // Core library version
+	UserAgent         string // User Agent of the node
+	PeerCount         int    // Count of peers in the peer list
+	BlockchainHeight  uint64 // Height of the user's blockchain
+	BlockchainVersion uint64 // Version of the user's blockchain
+	LogTarget         int    // Current log target
+	MemoryAlloc       uint64 // Allocated heap memory in bytes
+	Goroutines        int    // Count of goroutines
+}
+
+// isAdmin checks if the public key is authorized to send admin requests.
+func (backend *Backend) isAdmin(publicKey *btcec.PublicKey) bool {
+	keyHex := hex.EncodeToString(publicKey.SerializeCompressed())
+
+	for _, admin := range backend.Config.AdminPublicKeys {
+		if admin == keyHex {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminStatus returns the current status of the node.
+func (backend *Backend) adminStatus() (status AdminStatus) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	_, height, version := backend.UserBlockchain.Header()
+
+	return AdminStatus{
+		Version:           Version,
+		UserAgent:         backend.userAgent,
+		PeerCount:         backend.PeerlistCount(),
+		BlockchainHeight:  height,
+		BlockchainVersion: version,
+		LogTarget:         int(atomic.LoadInt32(&backend.logTarget)),
+		MemoryAlloc:       memStats.Alloc,
+		Goroutines:        runtime.NumGoroutine(),
+	}
+}
+
+// cmdAdmin handles an incoming admin request
+func (peer *PeerInfo) cmdAdmin(msg *protocol.MessageAdmin, connection *Connection) {
+	backend := peer.Backend
+
+	// If remote administration is disabled, requests are dropped silently to not reveal that the node supports it.
+	if len(backend.Config.AdminPublicKeys) == 0 {
+		return
+	} else if !backend.isAdmin(peer.PublicKey) {
+		backend.LogError("cmdAdmin", "unauthorized admin request from peer %s via %s\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()), connection.Address.String())
+		peer.sendAdmin(protocol.AdminControlResponse, msg.Action, protocol.AdminStatusUnauthorized, nil, msg.Sequence)
+		return
+	} else if drift := time.Since(msg.Timestamp); drift > adminMaxTimeDrift || drift < -adminMaxTimeDrift {
+		backend.LogError("cmdAdmin", "admin request from peer %s rejected due to time drift %s\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()), drift.String())
+		return
+	} else if !backend.adminNonceUse(peer.PublicKey, msg.Nonce, msg.Timestamp) {
+		backend.LogError("cmdAdmin", "replayed admin request from peer %s via %s rejected\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()), connection.Address.String())
+		return
+	}
+
+	var data []byte
+
+	switch msg.Action {
+	case protocol.AdminActionStatus:
+		data, _ = json.Marshal(backend.adminStatus())
+
+	case protocol.AdminActionReannounce:
+		for _, peer2 := range backend.PeerlistGet() {
+			peer2.sendAnnouncement(true, false, nil, nil, nil, nil)
+		}
+
+	case protocol.AdminActionGC:
+		runtime.GC()
+		debug.FreeOSMemory()
+
+	case protocol.AdminActionLogTarget:
+		if len(msg.Data) != 1 || msg.Data[0] > 3 {
+			peer.sendAdmin(protocol.AdminControlResponse, msg.Action, protocol.AdminStatusInvalid, nil, msg.Sequence)
+			return
+		}
+		backend.SetLogTarget(int(msg.Data[0]))
+
+	default:
+		peer.sendAdmin(protocol.AdminControlResponse, msg.Action, protocol.AdminStatusInvalid, nil, msg.Sequence)
+		return
+	}
+
+	backend.LogError("cmdAdmin", "admin action %d performed by peer %s\n", msg.Action, hex.EncodeToString(peer.PublicKey.SerializeCompressed()))
+
+	peer.sendAdmin(protocol.AdminControlResponse, msg.Action, protocol.AdminStatusOK, data, msg.Sequence)
+}
+
+// cmdAdminResponse handles an incoming admin response. The waiting requester is notified via the channel stored in the sequence data.
+func (peer *PeerInfo) cmdAdminResponse(msg *protocol.MessageAdmin) {
+	if msg.SequenceInfo == nil {
+		return
+	}
+
+	if responseChan, ok := msg.SequenceInfo.Data.(chan *protocol.MessageAdmin); ok {
+		select {
+		case responseChan <- msg:
+		default:
+		}
+	}
+}
+
+// sendAdmin sends an admin message
+func (peer *PeerInfo) sendAdmin(control, action, status uint8, data []byte, sequence uint32) (err error) {
+	packet, err := protocol.EncodeAdmin(control, action, status, data)
+	if err != nil {
+		return err
+	}
+
+	return peer.send(&protocol.PacketRaw{Command: protocol.CommandAdmin, Payload: packet, Sequence: sequence})
+}
+
+// AdminRequest sends an admin request to the remote peer and waits for the response. The remote peer must have the local peer ID listed in its AdminPublicKeys setting.
+func (backend *Backend) AdminRequest(peer *PeerInfo, action uint8, data []byte, timeout time.Duration) (status uint8, response []byte, err error) {
+	responseChan := make(chan *protocol.MessageAdmin, 1)
+	sequence := backend.networks.Sequences.NewSequence(peer.PublicKey, &peer.messageSequence, responseChan)
+
+	if err = peer.sendAdmin(protocol.AdminControlRequest, action, 0, data, sequence.SequenceNumber); err != nil {
+		return 0, nil, err
+	}
+
+	select {
+	case msg := <-responseChan:
+		return msg.Status, msg.Data, nil
+	case <-time.After(timeout):
+		backend.networks.Sequences.InvalidateSequence(peer.PublicKey, sequence.SequenceNumber, false)
+		return 0, nil, errors.New("timeout")
+	}
+}
+
+// AdminRequestStatus queries the status of a remote node.
+func (backend *Backend) AdminRequestStatus(peer *PeerInfo, timeout time.Duration) (status *AdminStatus, err error) {
+	code, data, err := backend.AdminRequest(peer, protocol.AdminActionStatus, nil, timeout)
+	if err != nil {
+		return nil, err
+	} else if code != protocol.AdminStatusOK {
+		return nil, errors.New("admin request refused")
+	}
+
+	status = &AdminStatus{}
+	if err = json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
@@ -0,0 +1,84 @@
+/*
+File Username:  Announcement Piggyback.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Small INFO_STORE announcements are not sent immediately. They are queued per peer and piggybacked onto the next outgoing pong or response
+message to that peer if space permits. This reduces the packet count for chatty peers. If no pong or response is sent within a short delay,
+the pending records are sent via a regular announcement.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// piggybackDelay is the max time pending INFO_STORE records wait for an outgoing pong or response.
+const piggybackDelay = 2 * time.Second
+
+// piggybackQueue contains INFO_STORE records pending to be sent to a peer.
+type piggybackQueue struct {
+	files []protocol.InfoStore // Pending records.
+	timer *time.Timer          // Timer to flush the records via a regular announcement.
+	sync.Mutex
+}
+
+// piggybackInfoStore queues an INFO_STORE record to be piggybacked.
+func (peer *PeerInfo) piggybackInfoStore(file protocol.InfoStore) {
+	peer.piggyback.Lock()
+	defer peer.piggyback.Unlock()
+
+	peer.piggyback.files = append(peer.piggyback.files, file)
+
+	if peer.piggyback.timer == nil {
+		peer.piggyback.timer = time.AfterFunc(piggybackDelay, peer.piggybackFlush)
+	}
+}
+
+// piggybackTake passes the pending records to the encode function, which returns the count of records it included. Those are removed from the queue.
+func (peer *PeerInfo) piggybackTake(encode func(files []protocol.InfoStore) (count int)) {
+	peer.piggyback.Lock()
+	defer peer.piggyback.Unlock()
+
+	if len(peer.piggyback.files) == 0 {
+		return
+	}
+
+	count := encode(peer.piggyback.files)
+	peer.piggyback.files = peer.piggyback.files[count:]
+
+	if len(peer.piggyback.files) == 0 && peer.piggyback.timer != nil {
+		peer.piggyback.timer.Stop()
+		peer.piggyback.timer = nil
+	}
+}
+
+// piggybackFlush sends all pending records via a regular announcement.
+func (peer *PeerInfo) piggybackFlush() {
+	peer.piggyback.Lock()
+	files := peer.piggyback.files
+	peer.piggyback.files = nil
+	peer.piggyback.timer = nil
+	peer.piggyback.Unlock()
+
+	if len(files) > 0 {
+		peer.sendAnnouncement(false, false, nil, nil, files, nil)
+	}
+}
+
+// piggybackIncoming handles INFO_STORE records piggybacked on an incoming pong or response message.
+func (peer *PeerInfo) piggybackIncoming(files []protocol.InfoStore) {
+	if len(files) == 0 {
+		return
+	}
+
+	for n := range files {
+		peer.Backend.Filters.IncomingRequest(peer, protocol.ActionInfoStore, files[n].ID.Hash, &files[n])
+	}
+
+	peer.announcementStore(files)
+}
@@ -0,0 +1,141 @@
+/*
+File Username:  Announcement SLA.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Per-peer tracking of the time between sending an Announcement and receiving its first Response. The last slaSamples response times are kept to
+calculate percentiles. Announcements whose sequence expired without any Response are counted as missed. Responses that arrive after the sequence
+expired but before it was deleted are counted as late and are still recorded as sample, so that the expiry window grows for slow links.
+
+The expiry window of outgoing Announcements is derived from the distribution instead of using the global ReplyTimeout for all peers: It is
+slaExpiryFactor times the 95th percentile, limited to slaExpiryMin and slaExpiryMax. Peers with fewer than slaMinSamples samples use ReplyTimeout.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// slaSamples is the count of most recent response times kept per peer.
+const slaSamples = 32
+
+// slaMinSamples is the min count of samples required for an adaptive expiry window.
+const slaMinSamples = 8
+
+// slaExpiryFactor is the multiple of the 95th percentile response time used as expiry window.
+const slaExpiryFactor = 3
+
+// slaExpiryMin is the shortest expiry window. It absorbs jitter of peers that usually respond fast.
+const slaExpiryMin = 3 * time.Second
+
+// slaExpiryMax is the longest expiry window for peers on slow links.
+const slaExpiryMax = 3 * ReplyTimeout * time.Second
+
+// announcementSLA is the response time state of a peer.
+type announcementSLA struct {
+	samples  [slaSamples]time.Duration // Ring buffer of response times.
+	count    int                       // Count of valid samples in the ring buffer.
+	next     int                       // Next index to write.
+	sent     uint64                    // Count of Announcements sent.
+	answered uint64                    // Count of Announcements answered in time.
+	late     uint64                    // Count of Announcements answered after the sequence expired.
+	missed   uint64                    // Count of Announcements that expired without a Response.
+	sync.Mutex
+}
+
+// AnnouncementSLA contains the Announcement response time statistics of a peer.
+type AnnouncementSLA struct {
+	Samples  int           // Count of response times the percentiles are based on.
+	P50      time.Duration // Median response time.
+	P95      time.Duration // 95th percentile response time.
+	P99      time.Duration // 99th percentile response time.
+	Expiry   time.Duration // Current expiry window of Announcements sent to the peer.
+	Sent     uint64        // Count of Announcements sent.
+	Answered uint64        // Count of Announcements answered in time.
+	Late     uint64        // Count of Announcements answered after the expiry window.
+	Missed   uint64        // Count of Announcements that were not answered.
+}
+
+// announcementSequence returns a new sequence for an outgoing Announcement to the peer, using the adaptive expiry window of the peer.
+func (peer *PeerInfo) announcementSequence(data interface{}) (info *protocol.SequenceExpiry) {
+	peer.sla.Lock()
+	peer.sla.sent++
+	expiry := peer.sla.expiry()
+	peer.sla.Unlock()
+
+	return peer.Backend.networks.Sequences.NewSequenceTimeout(peer.PublicKey, &peer.messageSequence, data, expiry, peer.slaMissed)
+}
+
+// slaRecord records the response time of an Announcement. Late is true if the sequence was already expired.
+func (peer *PeerInfo) slaRecord(rtt time.Duration, late bool) {
+	if rtt <= 0 {
+		return
+	}
+
+	peer.sla.Lock()
+	defer peer.sla.Unlock()
+
+	peer.sla.samples[peer.sla.next] = rtt
+	peer.sla.next = (peer.sla.next + 1) % slaSamples
+	if peer.sla.count < slaSamples {
+		peer.sla.count++
+	}
+
+	if late {
+		peer.sla.late++
+	} else {
+		peer.sla.answered++
+	}
+}
+
+// slaMissed records an Announcement that expired without a Response.
+func (peer *PeerInfo) slaMissed() {
+	peer.sla.Lock()
+	peer.sla.missed++
+	peer.sla.Unlock()
+}
+
+// sorted returns the samples in ascending order. The caller must hold the lock.
+func (sla *announcementSLA) sorted() (samples []time.Duration) {
+	samples = make([]time.Duration, sla.count)
+	copy(samples, sla.samples[:sla.count])
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples
+}
+
+// expiry returns the expiry window for a new Announcement. The caller must hold the lock.
+func (sla *announcementSLA) expiry() (expiry time.Duration) {
+	if sla.count < slaMinSamples {
+		return ReplyTimeout * time.Second
+	}
+
+	expiry = slaExpiryFactor * percentile(sla.sorted(), 95)
+	if expiry < slaExpiryMin {
+		expiry = slaExpiryMin
+	} else if expiry > slaExpiryMax {
+		expiry = slaExpiryMax
+	}
+
+	return expiry
+}
+
+// AnnouncementSLA returns the Announcement response time statistics of the peer.
+func (peer *PeerInfo) AnnouncementSLA() (stats AnnouncementSLA) {
+	peer.sla.Lock()
+	defer peer.sla.Unlock()
+
+	stats = AnnouncementSLA{Samples: peer.sla.count, Expiry: peer.sla.expiry(), Sent: peer.sla.sent, Answered: peer.sla.answered, Late: peer.sla.late, Missed: peer.sla.missed}
+
+	if samples := peer.sla.sorted(); len(samples) > 0 {
+		stats.P50 = percentile(samples, 50)
+		stats.P95 = percentile(samples, 95)
+		stats.P99 = percentile(samples, 99)
+	}
+
+	return stats
+}
@@ -0,0 +1,479 @@
+/*
+File Username:  Ban List.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The ban list contains peer IDs and IP addresses (or CIDR ranges) that are blocked. Incoming packets from banned peers or addresses are dropped,
+and banned peers are removed from the peer list. The list is stored in the config setting BanList. Entries may expire.
+
+Automatic bans (for example by the User Agent policy) are temporary bans of peers. Since peer IDs cost nothing to create, they are only kept
+in memory and never stored in the config, and their count is limited by banTemporaryMax.
+
+Operators of multiple nodes can share the list: BanListExport returns a JSON document with all active entries signed by the peer's private key.
+BanListImport merges such a document if it is signed by a peer listed in the config setting BanTrusted (or by the peer itself). Merge semantics:
+* Expired entries in the document are ignored.
+* New entries are added with the signer as source.
+* Local entries (no source) are never changed by imports.
+* Existing imported entries are replaced if the new entry expires later. Nothing is removed by an import.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// banListFileVersion is the version of the exported ban list document.
+const banListFileVersion = 1
+
+// banListExpiryInterval is the interval to remove expired entries.
+const banListExpiryInterval = 10 * time.Minute
+
+// banTemporaryMax is the max count of temporary bans. If exceeded, the ban expiring first is dropped.
+const banTemporaryMax = 10000
+
+// BanEntry is a banned peer or IP address. Exactly one of PublicKey and IP is set.
+type BanEntry struct {
+	PublicKey string    `yaml:"PublicKey,omitempty" json:"publickey,omitempty"` // Banned peer ID, hex encoded.
+	IP        string    `yaml:"IP,omitempty" json:"ip,omitempty"`               // Banned IP address or CIDR range.
+	Reason    string    `yaml:"Reason,omitempty" json:"reason,omitempty"`       // Reason, optional.
+	Expires   time.Time `yaml:"Expires,omitempty" json:"expires"`               // Expiry. Zero = never.
+	Source    string    `yaml:"Source,omitempty" json:"source,omitempty"`       // Peer ID of the signer of the imported list. Empty for local entries.
+}
+
+// BanListFile is the signed document used to share ban lists. The signature covers the JSON encoding of the document with an empty signature.
+type BanListFile struct {
+	Version   int        `json:"version"`   // Version of the document, see banListFileVersion.
+	Signer    string     `json:"signer"`    // Peer ID of the signer, hex encoded.
+	Created   time.Time  `json:"created"`   // Time the document was created.
+	Entries   []BanEntry `json:"entries"`   // Banned peers and IP addresses. The source is not included.
+	Signature string     `json:"signature"` // Compact secp256k1 signature of the blake3 hash of the document, hex encoded.
+}
+
+// BanImportResult is the result of merging an imported ban list.
+type BanImportResult struct {
+	Added   int `json:"added"`   // Count of new entries.
+	Updated int `json:"updated"` // Count of existing imported entries replaced because they expire later.
+	Skipped int `json:"skipped"` // Count of entries that were invalid, expired, or are local entries.
+}
+
+type banList struct {
+	keys map[[btcec.PubKeyBytesLenCompressed]byte]time.Time // Banned peer IDs and expiry.
+	ips  map[string]time.Time                               // Banned IP addresses and expiry.
+	nets []banNet                                           // Banned CIDR ranges.
+	sync.RWMutex
+
+	temporary map[[btcec.PubKeyBytesLenCompressed]byte]banTemporary // Temporary automatic bans of peers. Not stored in the config.
+}
+
+type banTemporary struct {
+	reason  string
+	expires time.Time
+}
+
+type banNet struct {
+	network *net.IPNet
+	expires time.Time
+}
+
+func (backend *Backend) initBanList() {
+	backend.banList = &banList{temporary: make(map[[btcec.PubKeyBytesLenCompressed]byte]banTemporary)}
+
+	var entries []BanEntry
+	for _, entry := range backend.Config.BanList {
+		if err := entry.normalize(); err != nil {
+			backend.LogError("initBanList", "invalid ban list entry '%s%s': %v\n", entry.PublicKey, entry.IP, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	backend.Config.BanList = entries
+
+	backend.banList.index(entries)
+}
+
+// normalize validates the entry and converts the target into its canonical form.
+func (entry *BanEntry) normalize() (err error) {
+	switch {
+	case entry.PublicKey != "" && entry.IP != "":
+		return errors.New("only one of public key and IP allowed")
+
+	case entry.PublicKey != "":
+		publicKey, err := PublicKeyFromPeerID(entry.PublicKey)
+		if err != nil {
+			return err
+		}
+		entry.PublicKey = hex.EncodeToString(publicKey.SerializeCompressed())
+
+	case strings.Contains(entry.IP, "/"):
+		_, network, err := net.ParseCIDR(entry.IP)
+		if err != nil {
+			return err
+		}
+		entry.IP = network.String()
+
+	case entry.IP != "":
+		ip := net.ParseIP(entry.IP)
+		if ip == nil {
+			return errors.New("invalid IP")
+		}
+		entry.IP = ip.String()
+
+	default:
+		return errors.New("public key or IP required")
+	}
+
+	return nil
+}
+
+// target returns the banned peer ID or IP address. It identifies the entry.
+func (entry *BanEntry) target() string {
+	if entry.PublicKey != "" {
+		return entry.PublicKey
+	}
+	return entry.IP
+}
+
+// isExpired checks if the entry is expired.
+func (entry *BanEntry) isExpired(now time.Time) bool {
+	return !entry.Expires.IsZero() && !entry.Expires.After(now)
+}
+
+// index rebuilds the lookup maps from the normalized entries. The caller must hold the lock, or it is called during init.
+func (list *banList) index(entries []BanEntry) {
+	list.keys = make(map[[btcec.PubKeyBytesLenCompressed]byte]time.Time)
+	list.ips = make(map[string]time.Time)
+	list.nets = nil
+
+	for _, entry := range entries {
+		if entry.PublicKey != "" {
+			if publicKey, err := PublicKeyFromPeerID(entry.PublicKey); err == nil {
+				list.keys[publicKey2Compressed(publicKey)] = entry.Expires
+			}
+		} else if _, network, err := net.ParseCIDR(entry.IP); err == nil {
+			list.nets = append(list.nets, banNet{network: network, expires: entry.Expires})
+		} else {
+			list.ips[entry.IP] = entry.Expires
+		}
+	}
+}
+
+// IsBanned checks if the peer or the IP address is banned. Either parameter may be nil.
+func (backend *Backend) IsBanned(publicKey *btcec.PublicKey, ip net.IP) bool {
+	list := backend.banList
+	now := time.Now()
+	active := func(expires time.Time) bool { return expires.IsZero() || expires.After(now) }
+
+	list.RLock()
+	defer list.RUnlock()
+
+	if publicKey != nil {
+		key := publicKey2Compressed(publicKey)
+		if expires, ok := list.keys[key]; ok && active(expires) {
+			return true
+		}
+		if temporary, ok := list.temporary[key]; ok && active(temporary.expires) {
+			return true
+		}
+	}
+
+	if ip != nil {
+		if expires, ok := list.ips[ip.String()]; ok && active(expires) {
+			return true
+		}
+		for _, banned := range list.nets {
+			if banned.network.Contains(ip) && active(banned.expires) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// BanList returns all entries of the ban list including expired ones that were not removed yet.
+func (backend *Backend) BanList() (entries []BanEntry) {
+	backend.banList.RLock()
+	defer backend.banList.RUnlock()
+
+	return append([]BanEntry{}, backend.Config.BanList...)
+}
+
+// BanListTemporary returns the temporary automatic bans including expired ones that were not removed yet.
+func (backend *Backend) BanListTemporary() (entries []BanEntry) {
+	backend.banList.RLock()
+	defer backend.banList.RUnlock()
+
+	for key, temporary := range backend.banList.temporary {
+		entries = append(entries, BanEntry{PublicKey: hex.EncodeToString(key[:]), Reason: temporary.reason, Expires: temporary.expires})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].PublicKey < entries[j].PublicKey })
+
+	return entries
+}
+
+// banTemporary bans the peer temporarily and removes it from the peer list. The ban is only kept in memory. An existing temporary ban is
+// extended if the new one expires later.
+func (backend *Backend) banTemporary(publicKey *btcec.PublicKey, duration time.Duration, reason string) {
+	key := publicKey2Compressed(publicKey)
+	expires := time.Now().Add(duration)
+
+	list := backend.banList
+	list.Lock()
+	if existing, ok := list.temporary[key]; !ok || expires.After(existing.expires) {
+		if !ok && len(list.temporary) >= banTemporaryMax {
+			list.dropTemporary()
+		}
+		list.temporary[key] = banTemporary{reason: reason, expires: expires}
+	}
+	list.Unlock()
+
+	if peer := backend.PeerlistLookup(publicKey); peer != nil {
+		backend.PeerlistRemove(peer)
+	}
+}
+
+// dropTemporary removes the temporary ban expiring first. The caller must hold the lock.
+func (list *banList) dropTemporary() {
+	var first [btcec.PubKeyBytesLenCompressed]byte
+	var firstExpires time.Time
+
+	for key, temporary := range list.temporary {
+		if firstExpires.IsZero() || temporary.expires.Before(firstExpires) {
+			first, firstExpires = key, temporary.expires
+		}
+	}
+
+	delete(list.temporary, first)
+}
+
+// BanAdd adds a local entry to the ban list and stores it in the config. An existing entry for the same target is replaced.
+func (backend *Backend) BanAdd(entry BanEntry) (err error) {
+	if err = entry.normalize(); err != nil {
+		return err
+	}
+	entry.Source = ""
+
+	backend.banListUpdate(func(entries []BanEntry) []BanEntry {
+		for n := range entries {
+			if entries[n].target() == entry.target() {
+				entries[n] = entry
+				return entries
+			}
+		}
+		return append(entries, entry)
+	})
+
+	return nil
+}
+
+// BanRemove removes the entry for the peer ID or IP address (or CIDR range) from the ban list. It returns false if there is no entry.
+func (backend *Backend) BanRemove(target string) (removed bool) {
+	entry := BanEntry{PublicKey: target}
+	if _, err := PublicKeyFromPeerID(target); err != nil {
+		entry = BanEntry{IP: target}
+	}
+	if entry.normalize() != nil {
+		return false
+	}
+
+	backend.banListUpdate(func(entries []BanEntry) (kept []BanEntry) {
+		for _, existing := range entries {
+			if existing.target() == entry.target() {
+				removed = true
+				continue
+			}
+			kept = append(kept, existing)
+		}
+		return kept
+	})
+
+	return removed
+}
+
+// banListUpdate changes the entries, rebuilds the index, removes banned peers from the peer list, and stores the config.
+func (backend *Backend) banListUpdate(update func(entries []BanEntry) []BanEntry) {
+	backend.banList.Lock()
+	backend.Config.BanList = update(append([]BanEntry{}, backend.Config.BanList...))
+	sort.Slice(backend.Config.BanList, func(i, j int) bool { return backend.Config.BanList[i].target() < backend.Config.BanList[j].target() })
+	backend.banList.index(backend.Config.BanList)
+	backend.banList.Unlock()
+
+	backend.SaveConfig()
+
+	for _, peer := range backend.PeerlistGet() {
+		if backend.IsBanned(peer.PublicKey, nil) {
+			backend.PeerlistRemove(peer)
+		}
+	}
+}
+
+// expireBanList removes expired entries and temporary bans.
+func (backend *Backend) expireBanList() error {
+	now := time.Now()
+	expired := false
+
+	backend.banList.Lock()
+	for key, temporary := range backend.banList.temporary {
+		if !temporary.expires.After(now) {
+			delete(backend.banList.temporary, key)
+		}
+	}
+	backend.banList.Unlock()
+
+	backend.banList.RLock()
+	for _, entry := range backend.Config.BanList {
+		expired = expired || entry.isExpired(now)
+	}
+	backend.banList.RUnlock()
+
+	if !expired {
+		return nil
+	}
+
+	backend.banListUpdate(func(entries []BanEntry) (kept []BanEntry) {
+		for _, entry := range entries {
+			if !entry.isExpired(now) {
+				kept = append(kept, entry)
+			}
+		}
+		return kept
+	})
+
+	return nil
+}
+
+// signatureHash returns the hash of the document that is signed.
+func (file BanListFile) signatureHash() (hash []byte, err error) {
+	file.Signature = ""
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return protocol.HashData(data), nil
+}
+
+// BanListExport returns all active entries of the ban list as JSON document signed by the peer's private key. See BanListFile.
+func (backend *Backend) BanListExport() (data []byte, err error) {
+	now := time.Now()
+	file := BanListFile{Version: banListFileVersion, Signer: hex.EncodeToString(backend.PeerPublicKey.SerializeCompressed()), Created: now.UTC(), Entries: []BanEntry{}}
+
+	for _, entry := range backend.BanList() {
+		if !entry.isExpired(now) {
+			entry.Source = ""
+			file.Entries = append(file.Entries, entry)
+		}
+	}
+
+	hash, err := file.signatureHash()
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := btcec.SignCompact(btcec.S256(), backend.PeerPrivateKey, hash, true)
+	if err != nil {
+		return nil, err
+	}
+	file.Signature = hex.EncodeToString(signature)
+
+	return json.MarshalIndent(file, "", "    ")
+}
+
+// BanListImport verifies the signed JSON document and merges its entries into the ban list. The signer must be listed in the config setting
+// BanTrusted or be the peer itself.
+func (backend *Backend) BanListImport(data []byte) (result BanImportResult, err error) {
+	var file BanListFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return result, err
+	} else if file.Version != banListFileVersion {
+		return result, errors.New("unsupported ban list version")
+	}
+
+	signer, err := PublicKeyFromPeerID(file.Signer)
+	if err != nil {
+		return result, errors.New("invalid signer")
+	}
+
+	signature, err := hex.DecodeString(file.Signature)
+	if err != nil {
+		return result, errors.New("invalid signature")
+	}
+
+	hash, err := file.signatureHash()
+	if err != nil {
+		return result, err
+	}
+
+	if recovered, _, err := btcec.RecoverCompact(btcec.S256(), signature, hash); err != nil || !recovered.IsEqual(signer) {
+		return result, errors.New("invalid signature")
+	}
+
+	if !backend.banTrusted(signer) {
+		return result, errors.New("signer not trusted")
+	}
+
+	source := hex.EncodeToString(signer.SerializeCompressed())
+	now := time.Now()
+
+	backend.banListUpdate(func(entries []BanEntry) []BanEntry {
+		existing := make(map[string]int)
+		for n, entry := range entries {
+			existing[entry.target()] = n
+		}
+
+		for _, entry := range file.Entries {
+			if entry.normalize() != nil || entry.isExpired(now) {
+				result.Skipped++
+				continue
+			}
+			entry.Source = source
+
+			n, ok := existing[entry.target()]
+			switch {
+			case !ok:
+				existing[entry.target()] = len(entries)
+				entries = append(entries, entry)
+				result.Added++
+
+			case entries[n].Source != "" && !entries[n].Expires.IsZero() && (entry.Expires.IsZero() || entry.Expires.After(entries[n].Expires)):
+				entries[n] = entry
+				result.Updated++
+
+			default:
+				result.Skipped++
+			}
+		}
+
+		return entries
+	})
+
+	return result, nil
+}
+
+// banTrusted checks if ban lists signed by the peer may be imported.
+func (backend *Backend) banTrusted(signer *btcec.PublicKey) bool {
+	if signer.IsEqual(backend.PeerPublicKey) {
+		return true
+	}
+
+	for _, peerID := range backend.Config.BanTrusted {
+		if trusted, err := PublicKeyFromPeerID(peerID); err == nil && trusted.IsEqual(signer) {
+			return true
+		}
+	}
+
+	return false
+}
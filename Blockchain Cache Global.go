@@ -7,8 +7,11 @@ Author:     Peter Kleissner
 package core
 
 import (
+	"time"
+
 	"github.com/PeernetOfficial/core/blockchain"
 	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/store"
 	"github.com/enfipy/locker"
 )
 
@@ -27,24 +30,24 @@ type BlockchainCache struct {
 }
 
 func (backend *Backend) initBlockchainCache() {
-	if backend.Config.BlockchainGlobal == "" {
+	if backend.DataLayout.BlockchainGlobal == "" {
 		return
 	}
 
 	backend.GlobalBlockchainCache = &BlockchainCache{
 		backend:             backend,
-		BlockchainDirectory: backend.Config.BlockchainGlobal,
+		BlockchainDirectory: backend.DataLayout.BlockchainGlobal,
 		MaxBlockSize:        backend.Config.CacheMaxBlockSize,
 		MaxBlockCount:       backend.Config.CacheMaxBlockCount,
 		LimitTotalRecords:   backend.Config.LimitTotalRecords,
 	}
 
-	var err error
-	backend.GlobalBlockchainCache.Store, err = blockchain.InitMultiStore(backend.Config.BlockchainGlobal)
+	database, err := store.Open(backend.Config.StoreBackend, backend.DataLayout.BlockchainGlobal)
 	if err != nil {
-		backend.LogError("initBlockchainCache", "initializing database '%s': %s", backend.Config.BlockchainGlobal, err.Error())
+		backend.LogError("initBlockchainCache", "initializing database '%s': %s", backend.DataLayout.BlockchainGlobal, err.Error())
 		return
 	}
+	backend.GlobalBlockchainCache.Store = blockchain.InitMultiStoreWithStore(database, backend.DataLayout.BlockchainGlobal)
 
 	backend.GlobalBlockchainCache.peerLock = locker.Initialize()
 
@@ -64,21 +67,60 @@ func (cache *BlockchainCache) SeenBlockchainVersion(peer *PeerInfo) {
 	defer cache.peerLock.Unlock(string(peer.PublicKey.SerializeCompressed()))
 
 	// intermediate function to download and process blocks
+	// Connected supernodes are preferred as mirrors. Blocks not returned by them are downloaded from the peer.
 	downloadAndProcessBlocks := func(peer *PeerInfo, header *blockchain.MultiBlockchainHeader, offset, limit uint64) {
 		if limit > cache.MaxBlockCount {
 			limit = cache.MaxBlockCount
 		}
 
-		peer.BlockDownload(peer.PublicKey, cache.MaxBlockCount, cache.MaxBlockSize, []protocol.BlockRange{{Offset: offset, Limit: limit}}, func(data []byte, targetBlock protocol.BlockRange, blockSize uint64, availability uint8) {
-			if availability != protocol.GetBlockStatusAvailable {
-				return
-			}
+		received := make(map[uint64]struct{})
 
+		processBlock := func(data []byte, targetBlock protocol.BlockRange) {
 			if decoded, _ := cache.Store.IngestBlock(header, targetBlock.Offset, data, true); decoded != nil {
 				// index it for search
 				cache.backend.SearchIndex.IndexNewBlockDecoded(peer.PublicKey, peer.BlockchainVersion, targetBlock.Offset, decoded.RecordsDecoded)
+
+				cache.backend.webhookNewContent(peer.PublicKey, peer.BlockchainVersion, targetBlock.Offset, decoded.RecordsDecoded)
+
+				cache.backend.displayNameSeenBlock(peer.PublicKey, decoded.RecordsDecoded)
+
+				cache.backend.hashtagsSeenBlock(peer.PublicKey, peer.BlockchainVersion, targetBlock.Offset, decoded.RecordsDecoded)
+
+				cache.backend.metadataSeenBlock(peer, decoded.RecordsDecoded)
+			}
+		}
+
+		for _, mirror := range cache.backend.blockMirrors(peer.PublicKey) {
+			ranges := missingBlockRanges(offset, limit, received)
+			if len(ranges) == 0 {
+				return
 			}
-		})
+
+			mirror.BlockDownload(peer.PublicKey, cache.MaxBlockCount, cache.MaxBlockSize, ranges, func(data []byte, targetBlock protocol.BlockRange, blockSize uint64, availability uint8) {
+				if availability != protocol.GetBlockStatusAvailable {
+					return
+				} else if !validMirroredBlock(data, peer.PublicKey, header.Version, targetBlock.Offset) {
+					mirror.protocolError(ProtocolErrorSignature)
+					return
+				}
+
+				processBlock(data, targetBlock)
+				received[targetBlock.Offset] = struct{}{}
+			})
+		}
+
+		if ranges := missingBlockRanges(offset, limit, received); len(ranges) > 0 {
+			peer.BlockDownload(peer.PublicKey, cache.MaxBlockCount, cache.MaxBlockSize, ranges, func(data []byte, targetBlock protocol.BlockRange, blockSize uint64, availability uint8) {
+				if availability != protocol.GetBlockStatusAvailable {
+					return
+				} else if !validMirroredBlock(data, peer.PublicKey, header.Version, targetBlock.Offset) {
+					peer.protocolError(ProtocolErrorSignature)
+					return
+				}
+
+				processBlock(data, targetBlock)
+			})
+		}
 	}
 
 	// get the old header
@@ -129,11 +171,24 @@ func (cache *BlockchainCache) SeenBlockchainVersion(peer *PeerInfo) {
 	}
 }
 
+// blockchainCacheRefreshInterval is the interval to check the cached blockchains of connected peers.
+const blockchainCacheRefreshInterval = 10 * time.Minute
+
+// refreshBlockchainCache checks the cached blockchains of all connected peers. It catches up on blockchains that were not fully cached, for example due to failed block downloads.
+// It is run by the scheduler.
+func (backend *Backend) refreshBlockchainCache() (err error) {
+	for _, peer := range backend.PeerlistGet() {
+		peer.remoteBlockchainUpdate()
+	}
+
+	return nil
+}
+
 // remoteBlockchainUpdate shall be called to indicate a potential update of the remotes blockchain.
 // It will use the blockchain version and height to update the data lake as appropriate.
 // This function is called in the Go routine of the packet worker and therefore must not stall.
 func (peer *PeerInfo) remoteBlockchainUpdate() {
-	if peer.Backend.GlobalBlockchainCache == nil || peer.Backend.GlobalBlockchainCache.ReadOnly || peer.BlockchainVersion == 0 && peer.BlockchainHeight == 0 {
+	if peer.Backend.GlobalBlockchainCache == nil || peer.Backend.GlobalBlockchainCache.ReadOnly || peer.BlockchainVersion == 0 && peer.BlockchainHeight == 0 || !peer.Backend.metadataOnlySync(peer) {
 		return
 	}
 
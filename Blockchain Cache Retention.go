@@ -0,0 +1,148 @@
+/*
+File Username:  Blockchain Cache Retention.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Retention rules of the global blockchain cache, so that long-running nodes do not grow unbounded from exploring the network:
+
+* Max age: Blockchains whose last block was added more than CacheMaxAge hours ago are deleted, unless the peer is currently in the peer list.
+  Blockchains of connected peers are kept up to date anyway, deleting them would only cause them to be downloaded again.
+* Max total size: If the blocks of all cached blockchains exceed CacheMaxSize MB, blockchains are deleted until the limit is met. Blockchains of
+  peers that are not in the peer list are deleted first, each group in the order of the last block added.
+* Keep followed: If CacheKeepFollowed is set, blockchains of followed peers are never deleted. Followed peers are the ones listed in the config
+  setting CacheFollowed and watched peers (see WatchPeer).
+
+The rules are enforced in the background every blockchainCacheRetentionInterval.
+*/
+
+package core
+
+import (
+	"sort"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// blockchainCacheRetentionInterval is the interval to enforce the retention rules of the global blockchain cache.
+const blockchainCacheRetentionInterval = time.Hour
+
+// CacheRetentionResult is the result of enforcing the retention rules of the global blockchain cache.
+type CacheRetentionResult struct {
+	Blockchains int    // Count of cached blockchains after enforcement.
+	Size        uint64 // Size of all cached blocks in bytes after enforcement.
+	DeletedAge  int    // Count of blockchains deleted because they exceeded the max age.
+	DeletedSize int    // Count of blockchains deleted to meet the max total size.
+}
+
+type cacheRetentionEntry struct {
+	header   *blockchain.MultiBlockchainHeader
+	size     uint64 // Size of all stored blocks.
+	online   bool   // Whether the peer is in the peer list.
+	followed bool   // Whether the peer is followed and kept if CacheKeepFollowed is set.
+}
+
+// enforceBlockchainCacheRetention enforces the retention rules of the global blockchain cache. It is run by the scheduler.
+func (backend *Backend) enforceBlockchainCacheRetention() (err error) {
+	if backend.GlobalBlockchainCache == nil {
+		return nil
+	}
+
+	backend.GlobalBlockchainCache.EnforceRetention()
+
+	return nil
+}
+
+// isFollowed checks if the peer is followed: Listed in the config setting CacheFollowed or watched.
+func (backend *Backend) isFollowed(publicKey *btcec.PublicKey) bool {
+	for _, peerID := range backend.Config.CacheFollowed {
+		if followed, err := PublicKeyFromPeerID(peerID); err == nil && followed.IsEqual(publicKey) {
+			return true
+		}
+	}
+
+	backend.peerWatch.RLock()
+	_, watched := backend.peerWatch.peers[publicKey2Compressed(publicKey)]
+	backend.peerWatch.RUnlock()
+
+	return watched
+}
+
+// EnforceRetention deletes cached blockchains according to the retention rules CacheMaxAge, CacheMaxSize and CacheKeepFollowed.
+func (cache *BlockchainCache) EnforceRetention() (result CacheRetentionResult) {
+	config := cache.backend.Config
+	maxAge := time.Duration(config.CacheMaxAge) * time.Hour
+	maxSize := config.CacheMaxSize * 1024 * 1024
+
+	var entries []*cacheRetentionEntry
+
+	cache.Store.IterateBlockchains(func(header *blockchain.MultiBlockchainHeader) {
+		entry := &cacheRetentionEntry{header: header, online: cache.backend.PeerlistLookup(header.PublicKey) != nil}
+		entry.followed = config.CacheKeepFollowed && cache.backend.isFollowed(header.PublicKey)
+
+		for _, blockN := range header.ListBlocks {
+			if raw, found := cache.Store.ReadBlock(header.PublicKey, header.Version, blockN); found {
+				entry.size += uint64(len(raw))
+			}
+		}
+
+		entries = append(entries, entry)
+	})
+
+	// Oldest first, blockchains of peers in the peer list last.
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].online != entries[j].online {
+			return !entries[i].online
+		}
+		return entries[i].header.DateLastBlockAdded.Before(entries[j].header.DateLastBlockAdded)
+	})
+
+	var kept []*cacheRetentionEntry
+
+	for _, entry := range entries {
+		if maxAge > 0 && !entry.followed && !entry.online && time.Since(entry.header.DateLastBlockAdded) > maxAge {
+			cache.deleteBlockchain(entry.header.PublicKey)
+			result.DeletedAge++
+			continue
+		}
+
+		kept = append(kept, entry)
+		result.Size += entry.size
+	}
+
+	for _, entry := range kept {
+		if maxSize == 0 || result.Size <= maxSize {
+			break
+		} else if entry.followed {
+			continue
+		}
+
+		cache.deleteBlockchain(entry.header.PublicKey)
+		result.DeletedSize++
+		result.Size -= entry.size
+	}
+
+	result.Blockchains = len(kept) - result.DeletedSize
+
+	if cache.LimitTotalRecords > 0 {
+		cache.ReadOnly = cache.Store.Database.Count() >= cache.LimitTotalRecords
+	}
+
+	return result
+}
+
+// deleteBlockchain deletes the cached blockchain of the peer and removes it from the search index.
+func (cache *BlockchainCache) deleteBlockchain(publicKey *btcec.PublicKey) {
+	cache.peerLock.Lock(string(publicKey.SerializeCompressed()))
+	defer cache.peerLock.Unlock(string(publicKey.SerializeCompressed()))
+
+	header, found, err := cache.Store.ReadBlockchainHeader(publicKey)
+	if !found || err != nil {
+		return
+	}
+
+	cache.Store.DeleteBlockchain(header)
+
+	cache.backend.SearchIndex.UnindexBlockchain(publicKey)
+}
@@ -8,27 +8,41 @@ package core
 
 import (
 	"os"
+	"time"
 
 	"github.com/PeernetOfficial/core/blockchain"
 	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/store"
 	"github.com/google/uuid"
 )
 
 // initUserBlockchain initializes the users blockchain. It creates the blockchain file if it does not exist already.
 // If it is corrupted, it will log the error and exit the process.
+// Observers use a read-only blockchain that is only kept in memory.
 func (backend *Backend) initUserBlockchain() {
 	var err error
-	backend.UserBlockchain, err = blockchain.Init(backend.PeerPrivateKey, backend.Config.BlockchainMain)
+	if backend.Config.Observer {
+		backend.UserBlockchain, err = blockchain.InitReadOnly(backend.PeerPrivateKey)
+	} else {
+		var database store.Store
+		if database, err = store.Open(backend.Config.StoreBackend, backend.DataLayout.BlockchainMain); err == nil {
+			backend.UserBlockchain, err = blockchain.InitWithStore(backend.PeerPrivateKey, database, backend.DataLayout.BlockchainMain)
+		}
+	}
 
 	if err != nil {
 		backend.LogError("initUserBlockchain", "error: %s\n", err.Error())
 		os.Exit(ExitBlockchainCorrupt)
 	}
+
+	backend.UserBlockchain.BatchWindow = time.Duration(backend.Config.BlockchainBatchWindow) * time.Millisecond
 }
 
 // Index the user's blockchain each time there is an update.
 func (backend *Backend) userBlockchainUpdateSearchIndex() {
 	backend.UserBlockchain.BlockchainUpdate = func(blockchainU *blockchain.Blockchain, oldHeight, oldVersion, newHeight, newVersion uint64) {
+		// the profile name may have changed
+		backend.displayNameSelfUpdate()
 
 		if newVersion != oldVersion || newHeight < oldHeight {
 			// invalidate search index data for the user's blockchain
@@ -106,13 +106,13 @@ func (peer *rootPeer) contact() {
 
 	for _, address := range peer.addresses {
 		// Port internal is always set to 0 for root peers. It disables NAT detection and will not send out a Traverse message.
-		peer.backend.contactArbitraryPeer(peer.publicKey, address, 0, false)
+		peer.backend.contactArbitraryPeer(peer.publicKey, address, 0, 0)
 	}
 }
 
 // bootstrap connects to the initial set of peers.
 func (backend *Backend) bootstrap() {
-	go resetRecentContacts()
+	backend.scheduleTask("recent-contacts-expiry", bootstrapRecentContact*time.Second, bootstrapRecentContact*time.Second, expireRecentContacts)
 
 	if len(rootPeers) == 0 {
 		backend.LogError("bootstrap", "warning: Empty list of root peers. Connectivity relies on local peer discovery and incoming connections.\n")
@@ -166,26 +166,39 @@ func (backend *Backend) bootstrap() {
 	backend.LogError("bootstrap", "unable to connect to at least 2 root peers, aborting\n")
 }
 
-func (nets *Networks) autoMulticastBroadcast() {
-	sendMulticastBroadcast := func() {
-		nets.RLock()
-		defer nets.RUnlock()
+// Rediscover re-runs local peer discovery and contacts root peers that are not connected. It is intended to be called after the system resumed
+// from sleep, so that the node does not need to wait for the regular intervals to reconnect.
+func (backend *Backend) Rediscover() {
+	go backend.networks.sendMulticastBroadcast()
 
-		for _, network := range nets.networks6 {
-			if err := network.MulticastIPv6Send(); err != nil {
-				nets.backend.LogError("autoMulticastBroadcast", "multicast from network address '%s': %v\n", network.address.IP.String(), err.Error())
-			}
+	for _, peer := range rootPeers {
+		go peer.contact()
+	}
+}
+
+// sendMulticastBroadcast sends out the IPv6 multicast and IPv4 broadcast announcements on all networks.
+func (nets *Networks) sendMulticastBroadcast() (err error) {
+	nets.RLock()
+	defer nets.RUnlock()
+
+	for _, network := range nets.networks6 {
+		if err = network.MulticastIPv6Send(); err != nil {
+			nets.backend.LogError("sendMulticastBroadcast", "multicast from network address '%s': %v\n", network.address.IP.String(), err.Error())
 		}
+	}
 
-		for _, network := range nets.networks4 {
-			if err := network.BroadcastIPv4Send(); err != nil {
-				nets.backend.LogError("autoMulticastBroadcast", "broadcast from network address '%s': %v\n", network.address.IP.String(), err.Error())
-			}
+	for _, network := range nets.networks4 {
+		if err = network.BroadcastIPv4Send(); err != nil {
+			nets.backend.LogError("sendMulticastBroadcast", "broadcast from network address '%s': %v\n", network.address.IP.String(), err.Error())
 		}
 	}
 
+	return err
+}
+
+func (nets *Networks) autoMulticastBroadcast() {
 	// Send out multicast/broadcast immediately.
-	sendMulticastBroadcast()
+	nets.sendMulticastBroadcast()
 
 	// Phase 1: Resend every 10 seconds until at least 1 peer in the peer list.
 	for {
@@ -195,21 +208,18 @@ func (nets *Networks) autoMulticastBroadcast() {
 			break
 		}
 
-		sendMulticastBroadcast()
+		nets.sendMulticastBroadcast()
 	}
 
-	// Phase 2: Every 10 minutes.
-	for {
-		time.Sleep(time.Minute * 10)
-		sendMulticastBroadcast()
-	}
+	// Phase 2: Every 10 minutes as scheduled task, scaled by the network size.
+	nets.backend.scheduleAdaptiveTask("multicast-broadcast", time.Minute*10, time.Minute*10, nets.sendMulticastBroadcast)
 }
 
 // contactArbitraryPeer contacts a new arbitrary peer for the first time.
-func (backend *Backend) contactArbitraryPeer(publicKey *btcec.PublicKey, address *net.UDPAddr, receiverPortInternal uint16, receiverFirewall bool) (contacted bool) {
-	findSelf := ShouldSendFindSelf()
+func (backend *Backend) contactArbitraryPeer(publicKey *btcec.PublicKey, address *net.UDPAddr, receiverPortInternal uint16, receiverFeatures uint8) (contacted bool) {
+	findSelf := backend.ShouldSendFindSelf()
 	_, blockchainHeight, blockchainVersion := backend.UserBlockchain.Header()
-	packets := protocol.EncodeAnnouncement(true, findSelf, nil, nil, nil, backend.FeatureSupport(), blockchainHeight, blockchainVersion, backend.userAgent)
+	packets := protocol.EncodeAnnouncement(true, findSelf, nil, nil, nil, backend.FeatureSupport(), backend.FeatureSupportExt(), blockchainHeight, blockchainVersion, backend.userAgent)
 	if len(packets) == 0 {
 		return false
 	}
@@ -217,7 +227,7 @@ func (backend *Backend) contactArbitraryPeer(publicKey *btcec.PublicKey, address
 
 	backend.Filters.MessageOutAnnouncement(publicKey, nil, raw, findSelf, nil, nil, nil)
 
-	backend.networks.sendAllNetworks(publicKey, raw, address, receiverPortInternal, receiverFirewall, nil, &bootstrapFindSelf{})
+	backend.networks.sendAllNetworks(publicKey, raw, address, receiverPortInternal, receiverFeatures, nil, &bootstrapFindSelf{})
 
 	return true
 }
@@ -259,17 +269,11 @@ func (peer *PeerInfo) cmdResponseBootstrapFindSelf(msg *protocol.MessageResponse
 			}
 
 			// Initiate contact. Once a response comes back, the peer will be actually added to the peer list.
-			peer.Backend.contactArbitraryPeer(closePeer.PublicKey, &net.UDPAddr{IP: address.IP, Port: int(address.Port)}, address.PortInternal, closePeer.Features&(1<<protocol.FeatureFirewall) > 0)
+			peer.Backend.contactArbitraryPeer(closePeer.PublicKey, &net.UDPAddr{IP: address.IP, Port: int(address.Port)}, address.PortInternal, closePeer.Features)
 		}
 	}
 }
 
-// ShouldSendFindSelf checks if FIND_SELF should be send
-func ShouldSendFindSelf() bool {
-	// TODO
-	return true
-}
-
 // isReturnedPeerBadQuality checks if the returned peer record is bad quality and should be discarded
 func (backend *Backend) isReturnedPeerBadQuality(record *protocol.PeerRecord) bool {
 	isIPv4 := record.IPv4 != nil && !record.IPv4.IsUnspecified()
@@ -336,21 +340,20 @@ var (
 	recentContactsMutex sync.RWMutex
 )
 
-func resetRecentContacts() {
-	for {
-		time.Sleep(bootstrapRecentContact * time.Second)
-		threshold := time.Now().Add(-bootstrapRecentContact * time.Second)
+// expireRecentContacts deletes expired recent contacts. It is run by the scheduler.
+func expireRecentContacts() (err error) {
+	threshold := time.Now().Add(-bootstrapRecentContact * time.Second)
 
-		recentContactsMutex.Lock()
+	recentContactsMutex.Lock()
+	defer recentContactsMutex.Unlock()
 
-		for key, recent := range recentContacts {
-			if recent.added.Before(threshold) {
-				delete(recentContacts, key)
-			}
+	for key, recent := range recentContacts {
+		if recent.added.Before(threshold) {
+			delete(recentContacts, key)
 		}
-
-		recentContactsMutex.Unlock()
 	}
+
+	return nil
 }
 
 // isReturnedPeerRecent checks if the peer is blacklisted related to the origin peer due to recent contact. It will create a "recent contact" if none exists.
@@ -0,0 +1,141 @@
+/*
+File Username:  Clock Sync.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Network time estimate. Peers append their clock to response messages (see protocol.ResponseAppendTimestamp). Each response yields a sample
+of the offset of the peer's clock: the remote time minus the local time at the middle of the round-trip. Responses with an RTT above
+clockSampleMaxRTT are ignored, since the middle of the round-trip becomes imprecise.
+
+The network time offset is the median of the latest sample of each peer, which a minority of peers with wrong or manipulated clocks cannot
+shift. It requires samples of at least clockMinPeers peers, otherwise the local clock is used. Samples expire after clockSampleExpiry.
+
+Anyone can set arbitrary dates in records of their blockchain. Dates later than the network time plus clockFutureTolerance are considered
+future-dated (see IsFutureDated). The webapi annotates them, and they are not trusted for sort orders and "recent" views.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+const clockSampleMaxRTT = 2 * time.Second      // Max RTT of a response to be used as sample.
+const clockSampleExpiry = time.Hour            // Time after which samples are discarded.
+const clockMinPeers = 3                        // Min count of peers with samples for a valid estimate.
+const clockMaxPeers = 1024                     // Max count of peers whose samples are kept.
+const clockEstimateInterval = 10 * time.Second // Interval to recalculate the estimate.
+const clockFutureTolerance = 10 * time.Minute  // Tolerance for dates in the future.
+const clockCleanup = 10 * time.Minute          // Interval to remove expired samples.
+
+// NetworkClock is the estimated offset of the local clock to the network.
+type NetworkClock struct {
+	Offset time.Duration // Network time minus local time. Positive if the local clock is behind. 0 if not valid.
+	Peers  int           // Count of peers with recent samples.
+	Valid  bool          // Whether enough peers were sampled.
+}
+
+type clockSample struct {
+	offset   time.Duration // Offset of the peer's clock to the local clock.
+	received time.Time     // When the sample was taken.
+}
+
+type clockSync struct {
+	samples   map[[btcec.PubKeyBytesLenCompressed]byte]clockSample
+	estimate  NetworkClock // Cached estimate.
+	estimated time.Time    // When the estimate was calculated.
+	sync.Mutex
+}
+
+func (backend *Backend) initClockSync() {
+	backend.clockSync = &clockSync{
+		samples: make(map[[btcec.PubKeyBytesLenCompressed]byte]clockSample),
+	}
+
+	backend.scheduleTask("clock-sync-cleanup", clockCleanup, clockCleanup, func() error {
+		backend.clockSync.cleanup()
+		return nil
+	})
+}
+
+// clockSampleIncoming records the clock of the peer as received in a response. RTT is the round-trip time of the announcement.
+func (peer *PeerInfo) clockSampleIncoming(remote time.Time, rtt time.Duration) {
+	if remote.IsZero() || rtt <= 0 || rtt > clockSampleMaxRTT {
+		return
+	}
+
+	offset := remote.Sub(time.Now().Add(-rtt / 2))
+
+	clock := peer.Backend.clockSync
+	clock.Lock()
+	defer clock.Unlock()
+
+	key := publicKey2Compressed(peer.PublicKey)
+	if _, ok := clock.samples[key]; !ok && len(clock.samples) >= clockMaxPeers {
+		return
+	}
+
+	clock.samples[key] = clockSample{offset: offset, received: time.Now()}
+}
+
+// cleanup removes expired samples.
+func (clock *clockSync) cleanup() {
+	clock.Lock()
+	defer clock.Unlock()
+
+	for key, sample := range clock.samples {
+		if time.Since(sample.received) >= clockSampleExpiry {
+			delete(clock.samples, key)
+		}
+	}
+}
+
+// NetworkClock returns the estimated offset of the local clock to the network.
+func (backend *Backend) NetworkClock() (estimate NetworkClock) {
+	clock := backend.clockSync
+	clock.Lock()
+	defer clock.Unlock()
+
+	if time.Since(clock.estimated) < clockEstimateInterval {
+		return clock.estimate
+	}
+
+	var offsets []time.Duration
+	for _, sample := range clock.samples {
+		if time.Since(sample.received) < clockSampleExpiry {
+			offsets = append(offsets, sample.offset)
+		}
+	}
+
+	estimate.Peers = len(offsets)
+
+	if estimate.Valid = len(offsets) >= clockMinPeers; estimate.Valid {
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+		if middle := len(offsets) / 2; len(offsets)%2 == 0 {
+			estimate.Offset = (offsets[middle-1] + offsets[middle]) / 2
+		} else {
+			estimate.Offset = offsets[middle]
+		}
+	}
+
+	clock.estimate = estimate
+	clock.estimated = time.Now()
+
+	return estimate
+}
+
+// NetworkTime returns the current time adjusted by the estimated offset to the network. It is the local time if there is no valid estimate.
+func (backend *Backend) NetworkTime() time.Time {
+	return time.Now().Add(backend.NetworkClock().Offset)
+}
+
+// IsFutureDated checks if the date is later than the network time, with a tolerance of clockFutureTolerance.
+// Such dates are either set by peers with a wrong clock or to abuse sort orders.
+func (backend *Backend) IsFutureDated(date time.Time) bool {
+	return date.After(backend.NetworkTime().Add(clockFutureTolerance))
+}
@@ -18,6 +18,7 @@ import (
 func (peer *PeerInfo) cmdTraverseForward(msg *protocol.MessageTraverse) {
 	// Verify the signature. This makes sure that a fowarded message cannot be replayed by others.
 	if !msg.SignerPublicKey.IsEqual(peer.PublicKey) || !msg.SignerPublicKey.IsEqual(msg.SenderPublicKey) {
+		peer.protocolError(ProtocolErrorSignature)
 		return
 	}
 
@@ -125,6 +126,7 @@ func (peer *PeerInfo) cmdTraverseReceive(msg *protocol.MessageTraverse) {
 				peerV.UserAgent = announce.UserAgent
 			}
 			peerV.Features = announce.Features
+			peerV.FeaturesExt = announce.FeaturesExt
 
 			peerV.cmdAnouncement(announce, nil)
 		}
@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/PeernetOfficial/core/dht"
 	"github.com/PeernetOfficial/core/protocol"
@@ -17,20 +18,50 @@ import (
 	"github.com/google/uuid"
 )
 
-// respondClosesContactsCount is the number of closest contact to respond.
+// respondClosesContactsCount is the default number of closest contact to respond.
 // Each peer record will take 70 bytes. Overhead is 77 + 20 payload header + UA length + 6 + 34 = 137 bytes without UA.
 // It makes sense to stay below 508 bytes (no fragmentation). Reporting back 5 contacts for FIND_SELF requests should do the magic.
 const respondClosesContactsCount = 5
 
-// cmdAnouncement handles an incoming announcement. Connection may be nil for traverse relayed messages.
-func (peer *PeerInfo) cmdAnouncement(msg *protocol.MessageAnnouncement, connection *Connection) {
-	// Filter function to only share peers that are "connectable" to the remote one. It checks IPv4, IPv6, and local connection.
-	filterFunc := func(allowLocal, allowIPv4, allowIPv6 bool) dht.NodeFilterFunc {
-		return func(node *dht.Node) (accept bool) {
-			return node.Info.(*PeerInfo).IsConnectable(allowLocal, allowIPv4, allowIPv6)
-		}
+// Sizes to calculate the number of closest contacts that fit into a response, see respondClosesContactsCount.
+const (
+	respondPeerRecordSize = 70
+	respondOverheadSize   = 137
+	respondSizeSafe       = 508           // UDP packet size that is never fragmented.
+	respondSizeLarge      = 1280 - 8 - 40 // UDP packet size that is safe on IPv6 and local paths. Same as the internet safe MTU of the protocol package.
+)
+
+// respondContactsCount returns the number of closest contacts to respond to the peer via the connection.
+// Local and IPv6 paths carry at least respondSizeLarge. On other paths, the largest packet received via the connection proves the path carries that size.
+func (peer *PeerInfo) respondContactsCount(connection *Connection) (count int) {
+	if peer.Backend.Config.ResponseContacts > 0 {
+		return peer.Backend.Config.ResponseContacts
+	} else if connection == nil {
+		return respondClosesContactsCount
+	}
+
+	size := respondSizeSafe
+	if connection.IsLocal() || !connection.IsIPv4() {
+		size = respondSizeLarge
+	} else if observed := int(atomic.LoadInt32(&connection.sizeMaxIn)); observed > size {
+		size = observed
+	}
+	if size > respondSizeLarge {
+		size = respondSizeLarge
+	}
+
+	count = (size - respondOverheadSize - len(peer.Backend.userAgent)) / respondPeerRecordSize
+	if count < respondClosesContactsCount {
+		return respondClosesContactsCount
+	} else if count > bucketSize {
+		return bucketSize
 	}
 
+	return count
+}
+
+// cmdAnouncement handles an incoming announcement. Connection may be nil for traverse relayed messages.
+func (peer *PeerInfo) cmdAnouncement(msg *protocol.MessageAnnouncement, connection *Connection) {
 	allowIPv4 := msg.Features&(1<<protocol.FeatureIPv4Listen) > 0
 	allowIPv6 := msg.Features&(1<<protocol.FeatureIPv6Listen) > 0
 
@@ -45,7 +76,7 @@ func (peer *PeerInfo) cmdAnouncement(msg *protocol.MessageAnnouncement, connecti
 		selfD := protocol.Hash2Peer{ID: protocol.KeyHash{Hash: peer.NodeID}}
 
 		// do not respond the caller's own peer (add to ignore list)
-		for _, node := range peer.Backend.nodesDHT.GetClosestContacts(respondClosesContactsCount, peer.NodeID, filterFunc(connection.IsLocal(), allowIPv4, allowIPv6), peer.NodeID) {
+		for _, node := range peer.Backend.nodesDHT.GetClosestContacts(peer.respondContactsCount(connection), peer.NodeID, NodeFilterConnectable(connection.IsLocal(), allowIPv4, allowIPv6), peer.NodeID) {
 			if info := node.Info.(*PeerInfo).peer2Record(connection.IsLocal(), allowIPv4, allowIPv6); info != nil {
 				selfD.Closest = append(selfD.Closest, *info)
 			}
@@ -66,7 +97,7 @@ func (peer *PeerInfo) cmdAnouncement(msg *protocol.MessageAnnouncement, connecti
 			details := protocol.Hash2Peer{ID: findPeer}
 
 			// Same as before, put self as ignoredNodes.
-			for _, node := range peer.Backend.nodesDHT.GetClosestContacts(respondClosesContactsCount, findPeer.Hash, filterFunc(connection.IsLocal(), allowIPv4, allowIPv6), peer.NodeID) {
+			for _, node := range peer.Backend.nodesDHT.GetClosestContacts(peer.respondContactsCount(connection), findPeer.Hash, NodeFilterConnectable(connection.IsLocal(), allowIPv4, allowIPv6), peer.NodeID) {
 				if info := node.Info.(*PeerInfo).peer2Record(connection.IsLocal(), allowIPv4, allowIPv6); info != nil {
 					details.Closest = append(details.Closest, *info)
 				}
@@ -85,12 +116,22 @@ func (peer *PeerInfo) cmdAnouncement(msg *protocol.MessageAnnouncement, connecti
 		for _, findHash := range msg.FindDataKeys {
 			peer.Backend.Filters.IncomingRequest(peer, protocol.ActionFindValue, findHash.Hash, nil)
 
+			// Hashes denied by a subscribed denial list are neither served nor referred.
+			if peer.Backend.IsHashDenied(findHash.Hash) {
+				hashesNotFound = append(hashesNotFound, findHash.Hash)
+				continue
+			}
+
 			stored, data := peer.announcementGetData(findHash.Hash)
+			peer.Backend.fileStatsFindValue(findHash.Hash, stored)
+
 			if stored && len(data) > 0 {
 				filesEmbed = append(filesEmbed, protocol.EmbeddedFileData{ID: findHash, Data: data})
 			} else if stored {
 				selfRecord := peer.Backend.selfPeerRecord()
 				hash2Peers = append(hash2Peers, protocol.Hash2Peer{ID: findHash, Storing: []protocol.PeerRecord{selfRecord}})
+			} else if storing := peer.infoStoreRecords(findHash.Hash, connection.IsLocal(), allowIPv4, allowIPv6); len(storing) > 0 {
+				hash2Peers = append(hash2Peers, protocol.Hash2Peer{ID: findHash, Storing: storing})
 			} else {
 				hashesNotFound = append(hashesNotFound, findHash.Hash)
 			}
@@ -140,13 +181,26 @@ func (peer *PeerInfo) peer2Record(allowLocal, allowIPv4, allowIPv6 bool) (result
 	return result
 }
 
-// cmdResponse handles the response to the announcement
+// cmdResponse handles the response to the announcement. The connection is nil if the response was relayed.
 func (peer *PeerInfo) cmdResponse(msg *protocol.MessageResponse, connection *Connection) {
+	// Embedded files with invalid data are never accepted. Repeated failures block the responder.
+	if msg.FilesEmbedInvalid > 0 {
+		peer.Backend.LogError("cmdResponse", "%d embedded files with invalid data received from peer %s\n", msg.FilesEmbedInvalid, hex.EncodeToString(peer.PublicKey.SerializeCompressed()))
+
+		if peer.embeddedHashFailure(msg.FilesEmbedInvalid) {
+			peer.Backend.PeerlistRemove(peer)
+			return
+		}
+	}
+
+	peer.piggybackIncoming(msg.InfoStoreFiles)
+	peer.latencyIncoming(msg.LatencyRecords)
+
 	// The sequence data is used to correlate this response with the announcement.
 	if msg.SequenceInfo == nil || msg.SequenceInfo.Data == nil {
 		// If there is no sequence data but there were results returned, it means we received unsolicited response data. It will be rejected.
 		if len(msg.HashesNotFound) > 0 || len(msg.Hash2Peers) > 0 || len(msg.FilesEmbed) > 0 {
-			peer.Backend.LogError("cmdResponse", "unsolicited response data received from %s\n", connection.Address.String())
+			peer.Backend.LogError("cmdResponse", "unsolicited response data received from peer %s\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()))
 		}
 
 		return
@@ -157,7 +211,7 @@ func (peer *PeerInfo) cmdResponse(msg *protocol.MessageResponse, connection *Con
 		for _, hash2Peer := range msg.Hash2Peers {
 			// Make sure no garbage is returned. The key must be self and only Closest is expected.
 			if !bytes.Equal(hash2Peer.ID.Hash, peer.Backend.nodeID) || len(hash2Peer.Closest) == 0 {
-				peer.Backend.LogError("cmdResponse", "incoming response to bootstrap FIND_SELF contains invalid data from %s\n", connection.Address.String())
+				peer.Backend.LogError("cmdResponse", "incoming response to bootstrap FIND_SELF contains invalid data from peer %s\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()))
 				return
 			}
 
@@ -186,6 +240,10 @@ func (peer *PeerInfo) cmdResponse(msg *protocol.MessageResponse, connection *Con
 		}
 
 		for _, file := range msg.FilesEmbed {
+			if !peer.embeddedAccept(msg.SequenceInfo, len(file.Data)) {
+				continue
+			}
+
 			info.QueueResult(&dht.NodeMessage{SenderID: peer.NodeID, Data: file.Data})
 
 			info.Done()
@@ -198,20 +256,28 @@ func (peer *PeerInfo) cmdResponse(msg *protocol.MessageResponse, connection *Con
 func (peer *PeerInfo) cmdPing(msg *protocol.MessageRaw, connection *Connection) {
 	// If PortInternal is 0, it means no incoming announcement or response message was received on that connection.
 	// This means the ping is unexpected. In that case for security reasons the remote peer is not asked for FIND_SELF.
-	if connection.PortInternal == 0 {
+	// A valid session ticket for the peer and IP allows to resume the connection without the Announcement/Response exchange.
+	if connection.PortInternal == 0 && !peer.sessionResumeConnection(connection) {
 		peer.sendAnnouncement(true, false, nil, nil, nil, nil)
 		return
 	}
 
 	raw := &protocol.PacketRaw{Command: protocol.CommandPong, Sequence: msg.Sequence}
 
+	peer.piggybackTake(func(files []protocol.InfoStore) (count int) {
+		raw.Payload, count = protocol.EncodePong(files)
+		return count
+	})
+
 	peer.Backend.Filters.MessageOutPong(peer, raw)
 
-	peer.send(raw)
+	// Reply via the same connection, so that the sender can measure the path it probes.
+	peer.sendConnection(raw, connection)
 }
 
 // cmdPong handles an incoming pong message
-func (peer *PeerInfo) cmdPong(msg *protocol.MessageRaw, connection *Connection) {
+func (peer *PeerInfo) cmdPong(msg *protocol.MessagePong, connection *Connection) {
+	peer.piggybackIncoming(msg.InfoStoreFiles)
 }
 
 // cmdChat handles a chat message [debug]
@@ -229,7 +295,7 @@ func (peer *PeerInfo) cmdLocalDiscovery(msg *protocol.MessageAnnouncement, conne
 	//	return
 	//}
 
-	peer.sendAnnouncement(true, ShouldSendFindSelf(), nil, nil, nil, &bootstrapFindSelf{})
+	peer.sendAnnouncement(true, peer.Backend.ShouldSendFindSelf(), nil, nil, nil, &bootstrapFindSelf{})
 }
 
 // SendChatAll sends a text message to all peers
@@ -248,9 +314,15 @@ func (peer *PeerInfo) cmdTransfer(msg *protocol.MessageTransfer, connection *Con
 
 	switch msg.Control {
 	case protocol.TransferControlRequestStart:
-		// First check if the file available in the warehouse.
+		// Benchmark transfers are served with generated data.
+		if bytes.Equal(msg.Hash, protocol.TransferHashBenchmark) {
+			go peer.startBenchmarkTransfer(msg.Limit, msg.Sequence, msg.TransferID)
+			return
+		}
+
+		// First check if the file available in the warehouse. Files denied by a subscribed denial list are not served.
 		_, fileSize, status, _ := peer.Backend.UserWarehouse.FileExists(msg.Hash)
-		if status != warehouse.StatusOK {
+		if status != warehouse.StatusOK || peer.Backend.IsHashDenied(msg.Hash) {
 			// File not available.
 			peer.sendTransfer(nil, protocol.TransferControlNotAvailable, msg.TransferProtocol, msg.Hash, 0, 0, msg.Sequence, uuid.UUID{}, false)
 			return
@@ -259,8 +331,19 @@ func (peer *PeerInfo) cmdTransfer(msg *protocol.MessageTransfer, connection *Con
 			return
 		}
 
+		peer.Backend.fileStatsTransferStart(msg.Hash)
+
 		// Create a local UDT client to connect to the remote UDT server and serve the file!
-		go peer.startFileTransferUDT(msg.Hash, fileSize, msg.Offset, msg.Limit, msg.Sequence, msg.TransferID, msg.TransferProtocol)
+		// Peers deprioritized by the credit policy wait for a free upload slot. If none becomes available, the request is discarded.
+		go func() {
+			acquired, release := peer.Backend.creditAcquireSlot(peer)
+			if !acquired {
+				return
+			}
+			defer release()
+
+			peer.startFileTransferUDT(msg.Hash, fileSize, msg.Offset, msg.Limit, msg.Sequence, msg.TransferID, msg.TransferProtocol)
+		}()
 
 	case protocol.TransferControlActive:
 		if v, ok := msg.SequenceInfo.Data.(*VirtualPacketConn); ok {
@@ -287,10 +370,15 @@ func (peer *PeerInfo) cmdTransfer(msg *protocol.MessageTransfer, connection *Con
 func (peer *PeerInfo) cmdGetBlock(msg *protocol.MessageGetBlock, connection *Connection) {
 	switch msg.Control {
 	case protocol.GetBlockControlRequestStart:
-		// Currently only support the local blockchain.
+		// Other blockchains are only mirrored by supernodes from the global blockchain cache.
 		if !msg.BlockchainPublicKey.IsEqual(peer.Backend.PeerPublicKey) {
-			peer.sendGetBlock(nil, protocol.GetBlockControlNotAvailable, msg.BlockchainPublicKey, 0, 0, nil, msg.Sequence, uuid.UUID{}, false)
-			return
+			if peer.Backend.mirrorBlockchainHeader(msg.BlockchainPublicKey) == nil {
+				peer.sendGetBlock(nil, protocol.GetBlockControlNotAvailable, msg.BlockchainPublicKey, 0, 0, nil, msg.Sequence, uuid.UUID{}, false)
+				return
+			} else if msg.LimitBlockCount == 0 {
+				peer.sendGetBlock(nil, protocol.GetBlockControlTerminate, msg.BlockchainPublicKey, 0, 0, nil, msg.Sequence, uuid.UUID{}, false)
+				return
+			}
 		} else if _, height, _ := peer.Backend.UserBlockchain.Header(); height == 0 {
 			peer.sendGetBlock(nil, protocol.GetBlockControlEmpty, msg.BlockchainPublicKey, 0, 0, nil, msg.Sequence, uuid.UUID{}, false)
 			return
@@ -12,7 +12,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
+	"sync/atomic"
 
 	"gopkg.in/yaml.v3"
 )
@@ -22,7 +23,7 @@ const Version = "Alpha 9/01.11.2022"
 
 // Config defines the minimum required config for a Peernet client.
 type Config struct {
-	// Locations of important files and folders
+	// Locations of important files and folders. Relative locations are relative to DataFolder, see DataLayout.
 	LogFile          string `yaml:"LogFile"`          // Log file. It contains informational and error messages.
 	BlockchainMain   string `yaml:"BlockchainMain"`   // Blockchain main stores the end-users blockchain data. It contains meta data of shared files, profile data, and social interactions.
 	BlockchainGlobal string `yaml:"BlockchainGlobal"` // Blockchain global caches blockchain data from global users. Empty to disable.
@@ -30,8 +31,23 @@ type Config struct {
 	SearchIndex      string `yaml:"SearchIndex"`      // Local search index of blockchain records. Empty to disable.
 	GeoIPDatabase    string `yaml:"GeoIPDatabase"`    // GeoLite2 City database to provide GeoIP information.
 	DataFolder       string `yaml:"DataFolder"`       // Data folder.
+	DownloadFolder   string `yaml:"DownloadFolder"`   // Default folder for completed downloads if no target path is specified. Empty = the path is required.
+	DownloadTemp     string `yaml:"DownloadTemp"`     // Folder for incomplete downloads. Empty = next to the target.
+	TransferHistory  string `yaml:"TransferHistory"`  // Ledger of completed and failed file transfers. Empty to disable.
 
-	// Target for the log messages: 0 = Log file,  1 = Stdout, 2 = Log file + Stdout, 3 = None
+	// DataLayoutVersion is the version of the data directory layout. 0 = Legacy, locations are relative to the working directory. Upgraded automatically.
+	DataLayoutVersion int `yaml:"DataLayoutVersion"`
+
+	// StoreBackend is the key-value store used for the user's blockchain, the global blockchain cache, and the transfer history: "pogreb" or "memory". Empty = pogreb.
+	// Embedders may register other stores (for example bbolt or LevelDB) via store.Register before calling Init.
+	StoreBackend string `yaml:"StoreBackend"`
+
+	// Placement of completed downloads, see Download Placement.go. If DownloadTypeFolders is set, downloads into DownloadFolder are placed into a
+	// subfolder per file type. DownloadCollision is the policy if the target exists: "rename" (default), "overwrite", or "fail".
+	DownloadTypeFolders bool   `yaml:"DownloadTypeFolders"`
+	DownloadCollision   string `yaml:"DownloadCollision"`
+
+	// Target for the log messages: 0 = Log file,  1 = Stdout, 2 = Log file + Stdout, 3 = None. Use SetLogTarget to change it at runtime.
 	LogTarget int `yaml:"LogTarget"`
 
 	// Listen settings
@@ -42,6 +58,15 @@ type Config struct {
 	// User specific settings
 	PrivateKey string `yaml:"PrivateKey"` // The Private Key, hex encoded so it can be copied manually
 
+	// Private key recovered via ImportMnemonic, hex encoded. Once confirmed via ConfirmMnemonicImport, it replaces PrivateKey at the next start.
+	// The previous key and its blockchain are moved into a backup folder.
+	PrivateKeyImport          string `yaml:"PrivateKeyImport"`
+	PrivateKeyImportConfirmed bool   `yaml:"PrivateKeyImportConfirmed"`
+
+	// Observer mode for monitoring and search gateway nodes. The node participates in the DHT and fetches blocks, but never publishes.
+	// It uses an ephemeral key instead of PrivateKey. The user's blockchain and warehouse are not persisted.
+	Observer bool `yaml:"Observer"`
+
 	// Initial peer seed list
 	SeedList           []PeerSeed `yaml:"SeedList"`
 	AutoUpdateSeedList bool       `yaml:"AutoUpdateSeedList"`
@@ -55,10 +80,142 @@ type Config struct {
 	// If this setting is invalid, it will prohibit other peers from connecting. If set, it automatically disables UPnP.
 	PortForward uint16 `yaml:"PortForward"`
 
+	// Lookup privacy
+	LookupPrivacy bool `yaml:"LookupPrivacy"` // Use ephemeral identities for DHT FIND_VALUE lookups. Contacts are queried via a relay.
+	LookupRelay   bool `yaml:"LookupRelay"`   // Relay DHT lookups of ephemeral identities for other peers. Only to peers in the peer list.
+
+	// Onion routing
+	OnionHops  int  `yaml:"OnionHops"`  // Count of hops (2-3) for onion routed DHT FIND_VALUE lookups. 0 = disabled.
+	OnionRelay bool `yaml:"OnionRelay"` // Act as hop for onion routed messages of other peers. Opt-in.
+
+	// HolePunchRelay forwards the traffic of peers for which hole punching coordinated by this peer failed (relay-only mode). The traffic is limited per session. Opt-in.
+	HolePunchRelay bool `yaml:"HolePunchRelay"`
+
+	// Traffic relay: Advertise and forward the traffic of peers that cannot connect directly, including UDT transfers. The bandwidth is capped per peer and globally.
+	TrafficRelay         bool `yaml:"TrafficRelay"`
+	TrafficRelayRate     int  `yaml:"TrafficRelayRate"`     // Max KB/s forwarded for all peers combined. 0 = default 1024.
+	TrafficRelayRatePeer int  `yaml:"TrafficRelayRatePeer"` // Max KB/s forwarded per peer. 0 = default 128.
+
+	// Supernode role for high-capacity nodes: Mirror blocks of cached blockchains and accept more INFO_STORE records.
+	Supernode             bool `yaml:"Supernode"`             // Advertise and act as supernode.
+	SupernodeMaxTransfers int  `yaml:"SupernodeMaxTransfers"` // Max count of concurrent block mirror transfers before shedding load. 0 = default 32.
+
+	// Protocol errors (malformed messages, signature failures, sequence violations) per peer within 10 minutes, after which the peer is banned.
+	ProtocolErrorThreshold int `yaml:"ProtocolErrorThreshold"` // Count of errors. 0 = default 100, negative = disabled.
+	ProtocolErrorBan       int `yaml:"ProtocolErrorBan"`       // Ban duration in minutes. 0 = default 60.
+
+	// InfoStoreProof challenges peers that announce files via INFO_STORE to prove that they store them: 0 = Disabled, 1 = Verify, 2 = Require.
+	InfoStoreProof int `yaml:"InfoStoreProof"`
+
+	// KeywordIndex publishes the keywords of shared files to the DHT and looks up search terms in it. Requires BlockchainGlobal and SearchIndex for searching.
+	KeywordIndex bool `yaml:"KeywordIndex"`
+
+	// Iterative DHT lookups
+	LookupAlpha int `yaml:"LookupAlpha"` // Max count of parallel requests in iterative DHT lookups. 0 = default 5.
+	LookupBeta  int `yaml:"LookupBeta"`  // Count of closest nodes that must have replied for an iterative DHT lookup to converge. 0 = default 20.
+
+	// ResponseContacts is the count of closest contacts returned per FIND_SELF and FIND_PEER request. 0 = adaptive to the path of the requesting peer.
+	ResponseContacts int `yaml:"ResponseContacts"`
+
+	// MultipathMode for file transfer packets to peers reachable via multiple local network adapters: 0 = Disabled, 1 = Stripe, 2 = Duplicate.
+	MultipathMode int `yaml:"MultipathMode"`
+
+	// CongestionControl slows down announcement and maintenance traffic if response timeouts and RTT inflation across peers indicate a saturated uplink.
+	CongestionControl bool `yaml:"CongestionControl"`
+
+	// AdaptiveIntervals scales maintenance traffic (FIND_SELF, multicast/broadcast, bucket refresh, blockchain refresh announcements) by the
+	// network size estimated from the DHT buckets: More frequent in small networks, less frequent in large ones.
+	AdaptiveIntervals bool `yaml:"AdaptiveIntervals"`
+
+	// ControlSocket binds a second socket to each listening address reserved for control traffic (announcements, responses, UDT ACK/NAK). Transfer
+	// data is sent via the main socket and strictly yields to control packets. Linux, macOS and FreeBSD only.
+	ControlSocket bool `yaml:"ControlSocket"`
+
+	// BlockchainBatchWindow is the time in milliseconds that records published by multiple operations are collected to be written as a single block
+	// of the user's blockchain. 0 = disabled, each operation creates its own block.
+	BlockchainBatchWindow int `yaml:"BlockchainBatchWindow"`
+
 	// Global blockchain cache limits
 	CacheMaxBlockSize  uint64 `yaml:"CacheMaxBlockSize"`  // Max block size to accept in bytes.
 	CacheMaxBlockCount uint64 `yaml:"CacheMaxBlockCount"` // Max block count to cache per peer.
 	LimitTotalRecords  uint64 `yaml:"LimitTotalRecords"`  // Record count limit. 0 = unlimited. Max Records * Max Block Size = Size Limit.
+
+	// Retention of the global blockchain cache. Blockchains of peers not in the peer list are deleted if their last block was added more than CacheMaxAge
+	// hours ago. If all cached blocks exceed CacheMaxSize MB, the least recently updated blockchains are deleted. 0 = unlimited.
+	// If CacheKeepFollowed is set, blockchains of followed peers are never deleted: Peer IDs (hex encoded public keys) listed in CacheFollowed and watched peers.
+	CacheMaxAge       int      `yaml:"CacheMaxAge"`
+	CacheMaxSize      uint64   `yaml:"CacheMaxSize"`
+	CacheKeepFollowed bool     `yaml:"CacheKeepFollowed"`
+	CacheFollowed     []string `yaml:"CacheFollowed"`
+
+	// MetadataOnly syncs only the blockchains and small files of followed peers and never fetches bulk content automatically. For constrained connections.
+	MetadataOnly bool `yaml:"MetadataOnly"`
+
+	// UserAgentPolicy is a list of rules applied to User Agents reported by remote peers. The first matching rule wins.
+	UserAgentPolicy []UserAgentRule `yaml:"UserAgentPolicy"`
+
+	// AdminPublicKeys is a list of hex encoded peer IDs that are allowed to remotely administer this node via admin messages. Empty to disable.
+	AdminPublicKeys []string `yaml:"AdminPublicKeys"`
+
+	// Webhooks are called via HTTP POST for node events. WebhookLowDisk is the free disk space in MB of the warehouse drive below which the low-disk event is sent. 0 = disabled.
+	Webhooks       []WebhookConfig `yaml:"Webhooks"`
+	WebhookLowDisk uint64          `yaml:"WebhookLowDisk"`
+
+	// FileTypes are custom rules to detect the File Type and File Format of published files, in addition to the default ones. See FileTypeRule.
+	FileTypes []FileTypeRule `yaml:"FileTypes"`
+
+	// TextExcerptSize is the max size in bytes of the text excerpt extracted from published files for full-text search. 0 = disabled.
+	TextExcerptSize int `yaml:"TextExcerptSize"`
+
+	// WarehouseGCInterval is the interval in hours to delete warehouse files not referenced by the user's blockchain. 0 = disabled.
+	WarehouseGCInterval int `yaml:"WarehouseGCInterval"`
+
+	// WarehouseMaxSize is the max size of the warehouse in MB. 0 = unlimited. If exceeded, files not shared by the user are evicted according to
+	// WarehouseEviction ("lru" or "lfu"). WarehousePinned are hex encoded hashes of files that are never evicted.
+	WarehouseMaxSize  uint64   `yaml:"WarehouseMaxSize"`
+	WarehouseEviction string   `yaml:"WarehouseEviction"`
+	WarehousePinned   []string `yaml:"WarehousePinned"`
+
+	// WarehouseScrubInterval is the interval in hours to verify all warehouse files against their hash. 0 = disabled.
+	// WarehouseScrubRate is the max read rate in MB/s while verifying. 0 = unlimited.
+	WarehouseScrubInterval int    `yaml:"WarehouseScrubInterval"`
+	WarehouseScrubRate     uint64 `yaml:"WarehouseScrubRate"`
+
+	// SyncFolders are local folders synced in both directions with trusted peers.
+	SyncFolders []SyncFolderConfig `yaml:"SyncFolders"`
+
+	// StorageAgreements are remote peers that agreed to store files of this peer. They are regularly challenged to prove that they still store the files.
+	StorageAgreements []StorageAgreementConfig `yaml:"StorageAgreements"`
+
+	// Tit-for-tat policy for file transfers. Peers that consumed more than CreditMaxDebt MB than they served are deprioritized and share CreditLowPrioritySlots concurrent uploads. 0 = disabled.
+	CreditMaxDebt          uint64 `yaml:"CreditMaxDebt"`
+	CreditLowPrioritySlots int    `yaml:"CreditLowPrioritySlots"`
+
+	// TransferHistoryMaxAge is the max age in days of records in the transfer history. 0 = records are kept forever.
+	TransferHistoryMaxAge int `yaml:"TransferHistoryMaxAge"`
+
+	// Software update channel. UpdatePublisher is the peer ID (hex encoded public key) that publishes release manifests on its blockchain. Empty = disabled.
+	// UpdateCheckInterval is the interval in hours to check for updates. 0 = only check on request.
+	UpdatePublisher     string `yaml:"UpdatePublisher"`
+	UpdateCheckInterval int    `yaml:"UpdateCheckInterval"`
+
+	// Denial lists are opt-in. DenialLists are the peer IDs (hex encoded public keys) of maintainers that publish denial lists on their blockchain.
+	// Listed hashes are neither served nor displayed. DenialListInterval is the interval in hours to refresh the lists. 0 = only refresh on request.
+	// DenialAllow and DenialDeny are hex encoded hashes that override the lists locally.
+	DenialLists        []string `yaml:"DenialLists"`
+	DenialListInterval int      `yaml:"DenialListInterval"`
+	DenialAllow        []string `yaml:"DenialAllow"`
+	DenialDeny         []string `yaml:"DenialDeny"`
+
+	// BanList contains banned peer IDs and IP addresses (or CIDR ranges). Packets from them are dropped. BanTrusted are the peer IDs (hex encoded
+	// public keys) of operators whose signed ban lists may be imported.
+	BanList    []BanEntry `yaml:"BanList"`
+	BanTrusted []string   `yaml:"BanTrusted"`
+
+	// Message-level tracing for debugging. TraceLog writes spans to the log. TraceExport is the OTLP/HTTP endpoint spans are exported to, for example
+	// "http://localhost:4318/v1/traces". Empty = disabled.
+	TraceLog    bool   `yaml:"TraceLog"`
+	TraceExport string `yaml:"TraceExport"`
 }
 
 // PeerSeed is a singl peer entry from the config's seed list
@@ -158,11 +315,11 @@ func (backend *Backend) configUpdateSeedList() {
 // InitLog redirects subsequent log messages into the default log file specified in the configuration
 func (backend *Backend) initLog() (err error) {
 	// create the directory to the log file if specified
-	if directory, _ := path.Split(backend.Config.LogFile); directory != "" {
+	if directory, _ := filepath.Split(backend.DataLayout.LogFile); directory != "" {
 		os.MkdirAll(directory, os.ModePerm)
 	}
 
-	logFile, err := os.OpenFile(backend.Config.LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666) // 666 : All uses can read/write
+	logFile, err := os.OpenFile(backend.DataLayout.LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666) // 666 : All uses can read/write
 	if err != nil {
 		return err
 	}
@@ -176,7 +333,7 @@ func (backend *Backend) initLog() (err error) {
 
 // Logs an error message.
 func (backend *Backend) LogError(function, format string, v ...interface{}) {
-	switch backend.Config.LogTarget {
+	switch atomic.LoadInt32(&backend.logTarget) {
 	case 0:
 		log.Printf("["+function+"] "+format, v...)
 
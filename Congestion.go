@@ -0,0 +1,132 @@
+/*
+File Username:  Congestion.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Network-wide congestion estimate. When bulk transfers saturate the own uplink, the replies of all peers are delayed by the same queue and pings
+start to go unanswered. Both signals are aggregated across all Internet connections: The RTT inflation is the ratio of each measured RTT to the
+base RTT of its connection, and the loss is the ratio of unanswered pings. A single slow or lossy peer barely moves the averages; only a shared
+bottleneck does.
+
+If enabled via the config setting CongestionControl, the resulting factor stretches the intervals of announcement and maintenance traffic:
+Pings, blockchain refresh announcements, connection probes, and maintenance tasks such as bucket refreshes. The connection invalidation
+thresholds are stretched by the same factor, so that connections are not dropped because their pongs are queued behind the bulk data.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// congestionMinSamples is the count of RTT samples required before the RTT inflation is considered.
+const congestionMinSamples = 32
+
+// congestionRTTMargin is the RTT increase over the base RTT that is tolerated as jitter. Samples within the margin count as no inflation.
+const congestionRTTMargin = 25 * time.Millisecond
+
+// congestionInflationStart is the smoothed RTT inflation at which intervals start to be stretched. The factor grows proportionally above it.
+const congestionInflationStart = 1.5
+
+// congestionLossStart is the smoothed ping loss at which intervals start to be stretched. The factor grows proportionally above it.
+const congestionLossStart = 0.05
+
+// congestionMaxFactor is the max factor by which intervals are stretched.
+const congestionMaxFactor = 4
+
+// congestionInflationCap limits the inflation of a single sample, so that a single outlier cannot dominate the average.
+const congestionInflationCap = 10
+
+// Smoothing weights of new samples.
+const (
+	congestionWeightRTT  = 1.0 / 32
+	congestionWeightLoss = 1.0 / 16
+)
+
+// pathBaseDecay is the divisor by which the base RTT of a connection approaches higher samples. It allows the base to adapt to route changes.
+const pathBaseDecay = 256
+
+// CongestionStatus is the current congestion estimate.
+type CongestionStatus struct {
+	Enabled   bool    // Whether intervals are stretched based on the estimate. See config setting CongestionControl.
+	Samples   uint64  // Count of RTT samples the estimate is based on.
+	Inflation float64 // Smoothed ratio of measured RTT to base RTT across all connections. 1 = no inflation.
+	Loss      float64 // Smoothed ratio of unanswered pings across all connections, between 0 and 1.
+	Factor    float64 // Factor by which announcement and maintenance intervals are stretched. 1 = not congested.
+}
+
+type congestionState struct {
+	samples   uint64
+	inflation float64
+	loss      float64
+	sync.Mutex
+}
+
+func (backend *Backend) initCongestion() {
+	backend.congestion = &congestionState{inflation: 1}
+}
+
+// recordRTT records an RTT sample of a connection with the given base RTT.
+func (state *congestionState) recordRTT(rtt, base time.Duration) {
+	inflation := 1.0
+	if base > 0 && rtt > base+congestionRTTMargin {
+		if inflation = float64(rtt) / float64(base); inflation > congestionInflationCap {
+			inflation = congestionInflationCap
+		}
+	}
+
+	state.Lock()
+	state.samples++
+	state.inflation += (inflation - state.inflation) * congestionWeightRTT
+	state.Unlock()
+}
+
+// recordPing records whether a ping was answered or lost.
+func (state *congestionState) recordPing(lost bool) {
+	sample := 0.0
+	if lost {
+		sample = 1
+	}
+
+	state.Lock()
+	state.loss += (sample - state.loss) * congestionWeightLoss
+	state.Unlock()
+}
+
+// Congestion returns the current congestion estimate.
+func (backend *Backend) Congestion() (status CongestionStatus) {
+	state := backend.congestion
+
+	state.Lock()
+	status.Samples = state.samples
+	status.Inflation = state.inflation
+	status.Loss = state.loss
+	state.Unlock()
+
+	status.Enabled = backend.Config.CongestionControl
+	status.Factor = 1
+
+	if status.Samples >= congestionMinSamples {
+		if factor := status.Inflation / congestionInflationStart; factor > status.Factor {
+			status.Factor = factor
+		}
+	}
+	if factor := status.Loss / congestionLossStart; factor > status.Factor {
+		status.Factor = factor
+	}
+	if status.Factor > congestionMaxFactor {
+		status.Factor = congestionMaxFactor
+	}
+
+	return status
+}
+
+// congestionScale stretches the interval of announcement or maintenance traffic by the current congestion factor. It returns the interval unchanged if disabled.
+func (backend *Backend) congestionScale(interval time.Duration) time.Duration {
+	if !backend.Config.CongestionControl {
+		return interval
+	}
+
+	return time.Duration(float64(interval) * backend.Congestion().Factor)
+}
@@ -0,0 +1,229 @@
+/*
+File Username:  Connection Path Stats.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Rolling path quality statistics per connection. The last pathStatsSamples round-trip times measured via Response, Pong and other replies are kept
+to calculate percentiles, and pings are counted to estimate the packet loss. A ping counts as lost if the next ping is sent before its pong arrived.
+The counters are halved when they exceed pathStatsLossWindow, so the loss reflects the recent history.
+
+The statistics are used to avoid slow or lossy peers as relays, and to rank peers as transfer sources via SortPeersByPath.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// pathStatsSamples is the count of most recent RTT samples kept per connection.
+const pathStatsSamples = 64
+
+// pathStatsLossWindow is the count of pings after which the loss counters are halved.
+const pathStatsLossWindow = 128
+
+// pathRelayMaxLoss is the max packet loss of a peer to be selected as relay if enough other candidates are available.
+const pathRelayMaxLoss = 0.25
+
+// pathRelayMaxP95 is the max 95th percentile RTT of a peer to be selected as relay if enough other candidates are available.
+const pathRelayMaxP95 = 2 * time.Second
+
+// pathStats is the rolling RTT and loss state of a connection.
+type pathStats struct {
+	samples     [pathStatsSamples]time.Duration // Ring buffer of RTT samples.
+	count       int                             // Count of valid samples in the ring buffer.
+	next        int                             // Next index to write.
+	pingPending bool                            // Whether a ping was sent that was not answered yet.
+	pingsTotal  uint32                          // Count of pings that were answered or lost.
+	pingsLost   uint32                          // Count of pings that were lost.
+	rttBase     time.Duration                   // Base RTT of the path without queuing delay. See pathBaseDecay.
+	sync.Mutex
+}
+
+// PathStats contains the path quality statistics of a connection.
+type PathStats struct {
+	Samples int           // Count of RTT samples the statistics are based on. 0 if no RTT was measured yet.
+	RTTLast time.Duration // Most recent RTT.
+	RTTMin  time.Duration // Lowest RTT.
+	RTTP50  time.Duration // Median RTT.
+	RTTP95  time.Duration // 95th percentile RTT.
+	Pings   uint32        // Count of recent pings that were answered or lost.
+	Loss    float64       // Ratio of recent pings that were lost, between 0 and 1.
+}
+
+// recordRTT records the round-trip time of a reply received on the connection.
+func (c *Connection) recordRTT(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+
+	c.RoundTripTime = rtt
+
+	c.path.Lock()
+	c.path.samples[c.path.next] = rtt
+	c.path.next = (c.path.next + 1) % pathStatsSamples
+	if c.path.count < pathStatsSamples {
+		c.path.count++
+	}
+
+	if c.path.rttBase == 0 || rtt < c.path.rttBase {
+		c.path.rttBase = rtt
+	} else {
+		c.path.rttBase += (rtt - c.path.rttBase) / pathBaseDecay
+	}
+	base := c.path.rttBase
+	c.path.Unlock()
+
+	// Local connections do not share the uplink to the Internet.
+	if !c.IsLocal() {
+		c.backend.congestion.recordRTT(rtt, base)
+	}
+}
+
+// pingSent records an outgoing ping. A previous ping that was not answered is counted as lost.
+func (stats *pathStats) pingSent() (lost bool) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	if stats.pingPending {
+		stats.pingResolved(true)
+		lost = true
+	}
+	stats.pingPending = true
+
+	return lost
+}
+
+// pingAnswered records the pong of the pending ping.
+func (stats *pathStats) pingAnswered() (answered bool) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	if stats.pingPending {
+		stats.pingPending = false
+		stats.pingResolved(false)
+		return true
+	}
+
+	return false
+}
+
+func (stats *pathStats) pingResolved(lost bool) {
+	stats.pingsTotal++
+	if lost {
+		stats.pingsLost++
+	}
+
+	if stats.pingsTotal >= pathStatsLossWindow {
+		stats.pingsTotal /= 2
+		stats.pingsLost /= 2
+	}
+}
+
+// PathStats returns the rolling path quality statistics of the connection.
+func (c *Connection) PathStats() (stats PathStats) {
+	c.path.Lock()
+	samples := make([]time.Duration, c.path.count)
+	copy(samples, c.path.samples[:c.path.count])
+	if c.path.count > 0 {
+		stats.RTTLast = c.path.samples[(c.path.next+pathStatsSamples-1)%pathStatsSamples]
+	}
+	stats.Pings = c.path.pingsTotal
+	if c.path.pingsTotal > 0 {
+		stats.Loss = float64(c.path.pingsLost) / float64(c.path.pingsTotal)
+	}
+	c.path.Unlock()
+
+	stats.Samples = len(samples)
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	stats.RTTMin = samples[0]
+	stats.RTTP50 = percentile(samples, 50)
+	stats.RTTP95 = percentile(samples, 95)
+
+	return stats
+}
+
+// percentile returns the percentile of the sorted samples using the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// PathStats returns the path quality statistics of the most recent active connection. If it has no measurements, another active connection is used.
+func (peer *PeerInfo) PathStats() (stats PathStats) {
+	peer.Lock()
+	defer peer.Unlock()
+
+	if peer.connectionLatest != nil {
+		if stats = peer.connectionLatest.PathStats(); stats.Samples > 0 {
+			return stats
+		}
+	}
+
+	for _, connection := range peer.connectionActive {
+		if connection == peer.connectionLatest {
+			continue
+		}
+		if other := connection.PathStats(); other.Samples > 0 {
+			return other
+		}
+	}
+
+	return stats
+}
+
+// pathScore returns a score for ranking the path quality. Lower is better. Peers without measurements are ranked last.
+func (stats *PathStats) pathScore() float64 {
+	if stats.Samples == 0 {
+		return -1
+	}
+
+	// The loss is weighted heavily since every lost packet costs at least one retransmission timeout.
+	return stats.RTTP95.Seconds() * (1 + 4*stats.Loss)
+}
+
+// SortPeersByPath sorts the peers by path quality, best first. It considers the 95th percentile RTT and the packet loss. Peers without measurements are last.
+// This is intended for choosing transfer sources among multiple peers that share the same file.
+func SortPeersByPath(peers []*PeerInfo) {
+	scores := make(map[*PeerInfo]float64, len(peers))
+	for _, peer := range peers {
+		stats := peer.PathStats()
+		scores[peer] = stats.pathScore()
+	}
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		scoreI, scoreJ := scores[peers[i]], scores[peers[j]]
+		if scoreI < 0 || scoreJ < 0 {
+			return scoreJ < 0 && scoreI >= 0
+		}
+		return scoreI < scoreJ
+	})
+}
+
+// pathRelayFilter removes peers with a lossy or slow path from the relay candidates. Peers without measurements are kept.
+// If fewer than minCount candidates would remain, the candidates are returned unchanged.
+func pathRelayFilter(candidates []*PeerInfo, minCount int) (filtered []*PeerInfo) {
+	for _, peer := range candidates {
+		stats := peer.PathStats()
+		if stats.Samples > 0 && (stats.Loss > pathRelayMaxLoss || stats.RTTP95 > pathRelayMaxP95) {
+			continue
+		}
+		filtered = append(filtered, peer)
+	}
+
+	if len(filtered) < minCount {
+		return candidates
+	}
+
+	return filtered
+}
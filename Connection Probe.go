@@ -0,0 +1,131 @@
+/*
+File Username:  Connection Probe.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Health probing of redundant connections. For peers with multiple active connections, all paths are pinged at a low rate and the replies
+are used to track a smoothed round-trip time and the count of unanswered pings per connection. Ping/Pong on redundant connections do not
+change the latest connection. Instead, a redundant connection is promoted when the latest connection degrades (unanswered pings or
+significantly higher RTT), rather than waiting for a hard send failure.
+*/
+
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// probeInterval is the interval in which each active connection of a peer with multiple active connections is pinged.
+const probeInterval = 15 * time.Second
+
+// probeLossThreshold is the count of consecutive unanswered pings after which a connection is considered degraded.
+const probeLossThreshold = 2
+
+// probeRTTFactor is the factor by which the smoothed RTT of the latest connection must exceed the one of a redundant connection for promotion.
+const probeRTTFactor = 2
+
+// probeRTTMargin is the minimum absolute RTT difference for promotion. It prevents flapping between paths with small RTTs.
+const probeRTTMargin = 20 * time.Millisecond
+
+// probeState is the per-connection probing state.
+type probeState struct {
+	lastProbe   time.Time // Last probe sent.
+	unanswered  uint32    // Count of consecutive pings without pong.
+	rttSmoothed int64     // Smoothed RTT in nanoseconds. 0 if not yet measured.
+}
+
+// ProbeStats returns the smoothed round-trip time and the count of consecutive unanswered pings of the connection.
+func (c *Connection) ProbeStats() (rttSmoothed time.Duration, unanswered uint32) {
+	return time.Duration(atomic.LoadInt64(&c.probe.rttSmoothed)), atomic.LoadUint32(&c.probe.unanswered)
+}
+
+// probeSent records an outgoing ping on the connection.
+func (c *Connection) probeSent() {
+	atomic.AddUint32(&c.probe.unanswered, 1)
+	if c.path.pingSent() && !c.IsLocal() {
+		c.backend.congestion.recordPing(true)
+	}
+}
+
+// probeReply records an incoming pong on the connection. The RTT is smoothed the same way as TCP does (7/8 old, 1/8 new).
+func (c *Connection) probeReply(rtt time.Duration) {
+	atomic.StoreUint32(&c.probe.unanswered, 0)
+	if c.path.pingAnswered() && !c.IsLocal() {
+		c.backend.congestion.recordPing(false)
+	}
+
+	if rtt <= 0 {
+		return
+	}
+
+	old := atomic.LoadInt64(&c.probe.rttSmoothed)
+	if old == 0 {
+		atomic.StoreInt64(&c.probe.rttSmoothed, int64(rtt))
+	} else {
+		atomic.StoreInt64(&c.probe.rttSmoothed, old-old/8+int64(rtt)/8)
+	}
+}
+
+// probeConnections pings the active connections of the peer that were not probed recently and promotes a redundant connection if the latest one degraded.
+// Nothing is done if the peer has only one active connection; the regular ping handles it.
+func (peer *PeerInfo) probeConnections() {
+	connections := peer.GetConnections(true)
+	if len(connections) < 2 {
+		return
+	}
+
+	threshold := time.Now().Add(-peer.Backend.congestionScale(probeInterval))
+
+	for _, connection := range connections {
+		if connection.probe.lastProbe.Before(threshold) && connection.LastPingOut.Before(threshold) {
+			connection.probe.lastProbe = time.Now()
+			peer.pingConnection(connection)
+		}
+	}
+
+	peer.probePromote()
+}
+
+// probePromote promotes the healthiest redundant connection if the latest connection degraded.
+func (peer *PeerInfo) probePromote() {
+	peer.Lock()
+	defer peer.Unlock()
+
+	latest := peer.connectionLatest
+	if latest == nil {
+		return
+	}
+
+	var candidate *Connection
+	var candidateRTT time.Duration
+
+	for _, connection := range peer.connectionActive {
+		if connection == latest {
+			continue
+		}
+
+		rtt, unanswered := connection.ProbeStats()
+		if unanswered > 0 || rtt == 0 {
+			continue
+		}
+
+		if candidate == nil || rtt < candidateRTT {
+			candidate, candidateRTT = connection, rtt
+		}
+	}
+
+	if candidate == nil {
+		return
+	}
+
+	latestRTT, latestUnanswered := latest.ProbeStats()
+
+	degraded := latestUnanswered >= probeLossThreshold ||
+		latestRTT > candidateRTT*probeRTTFactor && latestRTT-candidateRTT > probeRTTMargin
+
+	if degraded {
+		candidate.Status = ConnectionActive
+		peer.setConnectionLatest(candidate)
+	}
+}
@@ -19,18 +19,24 @@ import (
 // Connection is an established connection between a remote IP address and a local network adapter.
 // New connections may only be created in case of successful INCOMING packets.
 type Connection struct {
-	Network       *Network      // Network which received the packet.
-	Address       *net.UDPAddr  // Address of the remote peer.
-	PortInternal  uint16        // Internal listening port reported by remote peer. 0 if no Announcement/Response message was yet received.
-	PortExternal  uint16        // External listening port reported by remote peer. 0 if not known by the peer.
-	LastPacketIn  time.Time     // Last time an incoming packet was received.
-	LastPacketOut time.Time     // Last time an outgoing packet was attempted to send.
-	LastPingOut   time.Time     // Last ping out.
-	Expires       time.Time     // Inactive connections only: Expiry date. If it does not become active by that date, it will be considered expired and removed.
-	Status        int           // 0 = Active established connection, 1 = Inactive, 2 = Removed, 3 = Redundant
-	RoundTripTime time.Duration // Full round-trip time of last reply.
-	Firewall      bool          // Whether the remote peer indicates a potential firewall. This means a Traverse message shall be sent to establish a connection.
-	traversePeer  *PeerInfo     // Temporary peer that may act as proxy for a Traverse message used for the first packet. This is used to establish this Connection to a peer that is behind a NAT or firewall.
+	Network       *Network       // Network which received the packet.
+	Address       *net.UDPAddr   // Address of the remote peer.
+	PortInternal  uint16         // Internal listening port reported by remote peer. 0 if no Announcement/Response message was yet received.
+	PortExternal  uint16         // External listening port reported by remote peer. 0 if not known by the peer.
+	LastPacketIn  time.Time      // Last time an incoming packet was received.
+	LastPacketOut time.Time      // Last time an outgoing packet was attempted to send.
+	LastPingOut   time.Time      // Last ping out.
+	Expires       time.Time      // Inactive connections only: Expiry date. If it does not become active by that date, it will be considered expired and removed.
+	Status        int            // 0 = Active established connection, 1 = Inactive, 2 = Removed, 3 = Redundant
+	RoundTripTime time.Duration  // Full round-trip time of last reply.
+	Firewall      bool           // Whether the remote peer indicates a potential firewall. This means a Traverse message shall be sent to establish a connection.
+	traversePeer  *PeerInfo      // Temporary peer that may act as proxy for a Traverse message used for the first packet. This is used to establish this Connection to a peer that is behind a NAT or firewall.
+	multipath     multipathState // Multipath: Send statistics and backoff of this path.
+	probe         probeState     // Health probing state of this path.
+	path          pathStats      // Rolling RTT and loss statistics of this path.
+	sizeMaxIn     int32          // Largest packet received via this path. Atomic access.
+	features      uint8          // Feature bits of the remote peer. Only set for connections used for first contact; used to decide the NAT traversal strategy.
+	relay         *relayPath     // Relay-only mode: Packets are forwarded by a relay because hole punching failed. Nil for direct connections.
 	backend       *Backend
 }
 
@@ -44,7 +50,7 @@ const (
 
 // Equal checks if the connection was established other the same network adapter using the same IP address. Port is intentionally not checked.
 func (c *Connection) Equal(other *Connection) bool {
-	return c.Address.IP.Equal(other.Address.IP) && c.Network.address.IP.Equal(other.Network.address.IP)
+	return c.Address.IP.Equal(other.Address.IP) && c.Network.address.IP.Equal(other.Network.address.IP) && (c.relay == nil) == (other.relay == nil)
 }
 
 // IsLocal checks if the connection is a local network one (LAN)
@@ -52,6 +58,16 @@ func (c *Connection) IsLocal() bool {
 	return c != nil && IsIPLocal(c.Address.IP)
 }
 
+// observePacketSize records the size of a packet received via the connection.
+func (c *Connection) observePacketSize(size int) {
+	for {
+		current := atomic.LoadInt32(&c.sizeMaxIn)
+		if int32(size) <= current || atomic.CompareAndSwapInt32(&c.sizeMaxIn, current, int32(size)) {
+			return
+		}
+	}
+}
+
 // IsIPv4 checks if the connection is using IPv4
 func (c *Connection) IsIPv4() bool {
 	return IsIPv4(c.Address.IP)
@@ -106,7 +122,7 @@ func (peer *PeerInfo) IsConnectable(allowLocal, allowIPv4, allowIPv6 bool) bool
 		// If the internal port is not known, which happens if no Announcement or Response was returned, do not share the peer details.
 		// This can happen if only other messages such as Ping/Pong were received, or the protocol implementation is not compatible. The external port is also likely not available.
 		// In this case sharing the peer would be bad, since the receiving peer could not use internal/external port to detemine the NAT status and port forwarding.
-		if connection.PortInternal == 0 {
+		if connection.PortInternal == 0 || connection.relay != nil {
 			continue
 		}
 
@@ -131,12 +147,12 @@ func (peer *PeerInfo) GetConnection2Share(allowLocal, allowIPv4, allowIPv6 bool)
 	defer peer.RUnlock()
 
 	if peer.connectionLatest != nil && !(!allowLocal && peer.connectionLatest.IsLocal()) &&
-		(IsIPv4(peer.connectionLatest.Address.IP) && allowIPv4 || IsIPv6(peer.connectionLatest.Address.IP) && allowIPv6) && peer.connectionLatest.PortInternal > 0 {
+		(IsIPv4(peer.connectionLatest.Address.IP) && allowIPv4 || IsIPv6(peer.connectionLatest.Address.IP) && allowIPv6) && peer.connectionLatest.PortInternal > 0 && peer.connectionLatest.relay == nil {
 		return peer.connectionLatest
 	}
 
 	for _, connection := range peer.connectionActive {
-		if (IsIPv4(connection.Address.IP) && allowIPv4 || IsIPv6(connection.Address.IP) && allowIPv6) && !(!allowLocal && connection.IsLocal()) && connection.PortInternal > 0 {
+		if (IsIPv4(connection.Address.IP) && allowIPv4 || IsIPv6(connection.Address.IP) && allowIPv6) && !(!allowLocal && connection.IsLocal()) && connection.PortInternal > 0 && connection.relay == nil {
 			return connection
 		}
 	}
@@ -145,7 +161,8 @@ func (peer *PeerInfo) GetConnection2Share(allowLocal, allowIPv4, allowIPv6 bool)
 }
 
 // registerConnection registers an incoming connection for an existing peer. If new, it will add to the list. If previously inactive, it will elevate.
-func (peer *PeerInfo) registerConnection(incoming *Connection) (result *Connection) {
+// isProbe indicates a Ping/Pong message. Those do not promote redundant connections, which is instead done based on probing results.
+func (peer *PeerInfo) registerConnection(incoming *Connection, isProbe bool) (result *Connection) {
 	peer.Lock()
 	defer peer.Unlock()
 
@@ -158,6 +175,10 @@ func (peer *PeerInfo) registerConnection(incoming *Connection) (result *Connecti
 				connection.Address.Port = incoming.Address.Port
 			}
 
+			if isProbe && connection.Status == ConnectionRedundant && peer.connectionLatest != nil {
+				return connection
+			}
+
 			connection.Status = ConnectionActive
 			peer.setConnectionLatest(connection)
 			return connection
@@ -352,12 +373,28 @@ func (c *Connection) send(packet *protocol.PacketRaw, receiverPublicKey *btcec.P
 	}
 
 	c.LastPacketOut = time.Now()
+	c.backend.captureOut(packet, len(raw), receiverPublicKey, c)
 
-	err = c.Network.send(c.Address.IP, c.Address.Port, raw)
+	if c.relay != nil {
+		return c.relay.send(raw)
+	}
 
 	// Send Traverse message if the peer is behind a NAT or firewall and this is the first message. Only for Announcement.
-	if err == nil && isFirstPacket && (c.IsBehindNAT() || c.Firewall) && c.traversePeer != nil && packet.Command == protocol.CommandAnnouncement {
-		err = c.traversePeer.sendTraverse(packet, receiverPublicKey)
+	// The NAT types and the success rates of previous attempts determine whether the direct packet and the Traverse message are sent.
+	strategy := natStrategyDirect
+	if isFirstPacket && c.traversePeer != nil && packet.Command == protocol.CommandAnnouncement {
+		strategy = c.natStrategySelect(receiverPublicKey)
+		c.natAttemptStart(receiverPublicKey, strategy)
+	}
+
+	if strategy != natStrategyRelay {
+		err = c.Network.send(c.Address.IP, c.Address.Port, raw)
+	}
+
+	if err == nil && strategy != natStrategyDirect {
+		relay := c.backend.latencyTraverseRelay(receiverPublicKey, c.traversePeer)
+		err = relay.sendTraverse(packet, receiverPublicKey)
+		c.backend.holePunchAuto(receiverPublicKey, relay)
 	}
 
 	return err
@@ -367,7 +404,7 @@ func (c *Connection) send(packet *protocol.PacketRaw, receiverPublicKey *btcec.P
 func (peer *PeerInfo) send(packet *protocol.PacketRaw) (err error) {
 	if peer.isVirtual { // special case for peers that were not contacted before
 		for _, address := range peer.targetAddresses {
-			peer.Backend.networks.sendAllNetworks(peer.PublicKey, packet, &net.UDPAddr{IP: address.IP, Port: int(address.Port)}, address.PortInternal, peer.Features&(1<<protocol.FeatureFirewall) > 0, peer.traversePeer, nil)
+			peer.Backend.networks.sendAllNetworks(peer.PublicKey, packet, &net.UDPAddr{IP: address.IP, Port: int(address.Port)}, address.PortInternal, peer.Features, peer.traversePeer, nil)
 		}
 		return
 	}
@@ -422,14 +459,14 @@ func (peer *PeerInfo) sendConnection(packet *protocol.PacketRaw, connection *Con
 }
 
 // sendAllNetworks sends a raw packet via all networks. It assigns a new sequence for each sent packet.
-// receiverPortInternal is important for NAT detection and sending the traverse message. Firewall indicates whether the remote peer was reported to be behind a firewall.
-func (nets *Networks) sendAllNetworks(receiverPublicKey *btcec.PublicKey, packet *protocol.PacketRaw, remote *net.UDPAddr, receiverPortInternal uint16, receiverFirewall bool, traversePeer *PeerInfo, sequenceData interface{}) (err error) {
+// receiverPortInternal is important for NAT detection and sending the traverse message. receiverFeatures are the feature bits reported for the remote peer, including the firewall and NAT type.
+func (nets *Networks) sendAllNetworks(receiverPublicKey *btcec.PublicKey, packet *protocol.PacketRaw, remote *net.UDPAddr, receiverPortInternal uint16, receiverFeatures uint8, traversePeer *PeerInfo, sequenceData interface{}) (err error) {
 	nets.RLock()
 	defer nets.RUnlock()
 
 	networksTarget := nets.networks4
 	if IsIPv6(remote.IP.To16()) {
-		networksTarget = nets.networks6
+		networksTarget = nets.networksCurrent(nets.networks6)
 	}
 
 	successCount := 0
@@ -444,7 +481,7 @@ func (nets *Networks) sendAllNetworks(receiverPublicKey *btcec.PublicKey, packet
 		if sequenceData != nil {
 			packet.Sequence = nets.Sequences.ArbitrarySequence(receiverPublicKey, sequenceData).SequenceNumber
 		}
-		err = (&Connection{backend: nets.backend, Network: network, Address: remote, PortInternal: receiverPortInternal, traversePeer: traversePeer, Firewall: receiverFirewall}).send(packet, receiverPublicKey, isFirstPacket)
+		err = (&Connection{backend: nets.backend, Network: network, Address: remote, PortInternal: receiverPortInternal, traversePeer: traversePeer, Firewall: receiverFeatures&(1<<protocol.FeatureFirewall) > 0, features: receiverFeatures}).send(packet, receiverPublicKey, isFirstPacket)
 		isFirstPacket = false
 
 		if err == nil {
@@ -459,8 +496,14 @@ func (nets *Networks) sendAllNetworks(receiverPublicKey *btcec.PublicKey, packet
 	return nil
 }
 
-// send sends a raw packet to the peer. Only uses active connections.
-func (peer *PeerInfo) sendLite(raw []byte) (err error) {
+// isTransferControl checks if the data of a lite packet is a UDT control packet (for example ACK or NAK). They have the highest bit set.
+func isTransferControl(data []byte) bool {
+	return len(data) > 0 && data[0]&0x80 != 0
+}
+
+// sendLite sends a lite packet to the peer. Only uses active connections. Control indicates that it carries a UDT control packet; otherwise
+// it is sent via the data path of the network, see Network.sendData.
+func (peer *PeerInfo) sendLite(raw []byte, control bool) (err error) {
 	if peer.isVirtual { // special case for peers that were not contacted before
 		return errors.New("cannot send lite packet to virtual peer")
 	} else if len(peer.connectionActive) == 0 {
@@ -472,10 +515,17 @@ func (peer *PeerInfo) sendLite(raw []byte) (err error) {
 	// always count as one sent packet even if sent via broadcast
 	atomic.AddUint64(&peer.StatsPacketSent, 1)
 
+	// Multipath: Use all local network adapters via which the peer is reachable.
+	if mode := peer.Backend.Config.MultipathMode; mode != MultipathDisabled {
+		if paths := peer.multipathPaths(); len(paths) > 1 {
+			return peer.sendMultipath(paths, raw, mode, control)
+		}
+	}
+
 	// Send out the wire. Use connectionLatest if available.
 	cLatest := peer.connectionLatest
 	if cLatest != nil {
-		if err := cLatest.Network.send(cLatest.Address.IP, cLatest.Address.Port, raw); err == nil {
+		if err := cLatest.sendLite(raw, control); err == nil {
 			return nil
 		} else if IsNetworkErrorFatal(err) {
 			// Invalid connection, immediately invalidate. Fallback to broadcast to all other active ones.
@@ -490,10 +540,20 @@ func (peer *PeerInfo) sendLite(raw []byte) (err error) {
 			continue
 		}
 
-		if err := c.Network.send(c.Address.IP, c.Address.Port, raw); err != nil && IsNetworkErrorFatal(err) {
+		if err := c.sendLite(raw, control); err != nil && IsNetworkErrorFatal(err) {
 			peer.invalidateActiveConnection(c)
 		}
 	}
 
 	return nil // on broadcast no error is known and returned
 }
+
+// sendLite sends a lite packet via the connection. Data packets use the data path of the network.
+func (c *Connection) sendLite(raw []byte, control bool) (err error) {
+	if c.relay != nil {
+		return c.relay.send(raw)
+	} else if control {
+		return c.Network.send(c.Address.IP, c.Address.Port, raw)
+	}
+	return c.Network.sendData(c.Address.IP, c.Address.Port, raw)
+}
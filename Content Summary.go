@@ -0,0 +1,221 @@
+/*
+File Username:  Content Summary.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Content summaries are bloom filters of the hashes stored in the local DHT store and the user's Warehouse. They are periodically exchanged between connected peers.
+Value lookups first query directly connected peers whose summary indicates they likely have the data, before doing a full DHT walk.
+Summaries may contain false positives; a miss simply falls back to the regular DHT lookup.
+*/
+
+package core
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/dht"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// contentSummaryInterval is the interval in which the local content summary is rebuilt.
+const contentSummaryInterval = 5 * time.Minute
+
+// contentSummaryCheckInterval is the interval in which the current summary is sent to connected peers that have not received it yet.
+const contentSummaryCheckInterval = time.Minute
+
+// contentSummaryExpiry is the time a remote summary remains valid after it was received.
+const contentSummaryExpiry = 3 * contentSummaryInterval
+
+// contentSummaryMaxCandidates is the max count of directly connected peers queried before the DHT lookup.
+const contentSummaryMaxCandidates = 5
+
+// contentSummaryQueryTimeout is the timeout for querying directly connected peers.
+const contentSummaryQueryTimeout = 2 * time.Second
+
+// contentSummaryRemote is a summary received from a remote peer.
+type contentSummaryRemote struct {
+	peer     *PeerInfo
+	summary  *protocol.ContentSummary
+	received time.Time
+}
+
+// contentSummaries keeps track of the local summary and the ones received from peers.
+type contentSummaries struct {
+	local   []byte                                                         // Encoded local summary.
+	version uint64                                                         // Version of the local summary. Increased on every change.
+	sent    map[[btcec.PubKeyBytesLenCompressed]byte]uint64                // Version of the local summary last sent to the peer.
+	remote  map[[btcec.PubKeyBytesLenCompressed]byte]*contentSummaryRemote // Summaries received from peers.
+	sync.Mutex
+}
+
+func (backend *Backend) initContentSummary() {
+	backend.contentSummaries = &contentSummaries{
+		sent:   make(map[[btcec.PubKeyBytesLenCompressed]byte]uint64),
+		remote: make(map[[btcec.PubKeyBytesLenCompressed]byte]*contentSummaryRemote),
+	}
+}
+
+// scheduleContentSummary rebuilds the local summary regularly and sends it to connected peers.
+func (backend *Backend) scheduleContentSummary() {
+	backend.scheduleTask("content-summary-build", 0, contentSummaryInterval, func() error {
+		backend.contentSummaryBuild()
+		return nil
+	})
+
+	backend.scheduleMaintenanceTask("content-summary-send", contentSummaryCheckInterval, contentSummaryCheckInterval, func() error {
+		backend.contentSummarySend()
+		backend.contentSummaryExpire()
+		return nil
+	})
+}
+
+// contentSummaryBuild builds the summary of locally stored hashes. The version is only increased if the summary changed.
+func (backend *Backend) contentSummaryBuild() {
+	var hashes [][]byte
+
+	backend.dhtStore.Iterate(func(key, value []byte) {
+		hashes = append(hashes, key)
+	})
+
+	if backend.UserWarehouse != nil {
+		backend.UserWarehouse.IterateFiles(func(hash []byte, size int64) bool {
+			hashes = append(hashes, hash)
+			return true
+		})
+	}
+
+	summary := protocol.NewContentSummary(len(hashes))
+	for _, hash := range hashes {
+		summary.Add(hash)
+	}
+
+	raw, err := protocol.EncodeContentSummary(summary)
+	if err != nil {
+		backend.LogError("contentSummaryBuild", "encoding summary: %s", err.Error())
+		return
+	}
+
+	backend.contentSummaries.Lock()
+	if !bytes.Equal(raw, backend.contentSummaries.local) {
+		backend.contentSummaries.local = raw
+		backend.contentSummaries.version++
+	}
+	backend.contentSummaries.Unlock()
+}
+
+// contentSummarySend sends the current summary to all connected peers that have not received it yet.
+func (backend *Backend) contentSummarySend() {
+	peers := backend.PeerlistGet()
+	connected := make(map[[btcec.PubKeyBytesLenCompressed]byte]struct{})
+
+	backend.contentSummaries.Lock()
+	raw := backend.contentSummaries.local
+	version := backend.contentSummaries.version
+
+	var receivers []*PeerInfo
+	for _, peer := range peers {
+		key := publicKey2Compressed(peer.PublicKey)
+		connected[key] = struct{}{}
+
+		if raw != nil && backend.contentSummaries.sent[key] != version {
+			backend.contentSummaries.sent[key] = version
+			receivers = append(receivers, peer)
+		}
+	}
+
+	// Forget peers that are no longer connected, so they receive the summary again on reconnect.
+	for key := range backend.contentSummaries.sent {
+		if _, ok := connected[key]; !ok {
+			delete(backend.contentSummaries.sent, key)
+		}
+	}
+	backend.contentSummaries.Unlock()
+
+	for _, peer := range receivers {
+		peer.send(&protocol.PacketRaw{Command: protocol.CommandContentSummary, Payload: raw})
+	}
+}
+
+// contentSummaryExpire deletes expired remote summaries.
+func (backend *Backend) contentSummaryExpire() {
+	backend.contentSummaries.Lock()
+	defer backend.contentSummaries.Unlock()
+
+	for key, remote := range backend.contentSummaries.remote {
+		if time.Since(remote.received) > contentSummaryExpiry {
+			delete(backend.contentSummaries.remote, key)
+		}
+	}
+}
+
+// cmdContentSummary handles an incoming content summary from a peer.
+func (peer *PeerInfo) cmdContentSummary(msg *protocol.MessageContentSummary) {
+	summaries := peer.Backend.contentSummaries
+
+	summaries.Lock()
+	summaries.remote[publicKey2Compressed(peer.PublicKey)] = &contentSummaryRemote{peer: peer, summary: msg.Summary, received: time.Now()}
+	summaries.Unlock()
+}
+
+// contentSummaryCandidates returns connected peers whose summary indicates they likely have the hash.
+func (backend *Backend) contentSummaryCandidates(hash []byte) (peers []*PeerInfo) {
+	backend.contentSummaries.Lock()
+	var candidates []*PeerInfo
+	for _, remote := range backend.contentSummaries.remote {
+		if time.Since(remote.received) <= contentSummaryExpiry && remote.summary.Test(hash) {
+			candidates = append(candidates, remote.peer)
+		}
+	}
+	backend.contentSummaries.Unlock()
+
+	for _, peer := range candidates {
+		// The peer must still be in the peer list. A new PeerInfo is created on reconnect.
+		if backend.PeerlistLookup(peer.PublicKey) != peer {
+			continue
+		}
+
+		peers = append(peers, peer)
+		if len(peers) >= contentSummaryMaxCandidates {
+			break
+		}
+	}
+
+	return peers
+}
+
+// getDataContentSummary queries directly connected peers that likely have the data according to their content summary.
+func (backend *Backend) getDataContentSummary(hash []byte) (data []byte, senderNodeID []byte, found bool) {
+	peers := backend.contentSummaryCandidates(hash)
+	if len(peers) == 0 {
+		return nil, nil, false
+	}
+
+	var nodes []*dht.Node
+	for _, peer := range peers {
+		nodes = append(nodes, &dht.Node{ID: peer.NodeID, Info: peer})
+	}
+
+	info := backend.nodesDHT.NewInformationRequest(dht.ActionFindValue, hash, nodes)
+	defer info.Terminate()
+
+	backend.nodesDHT.SendRequestFindValue(info)
+
+	timeout := time.After(contentSummaryQueryTimeout)
+
+	for {
+		select {
+		case result, ok := <-info.ResultChan:
+			if !ok {
+				return nil, nil, false
+			} else if result.Data != nil {
+				return result.Data, result.SenderID, true
+			}
+
+		case <-timeout:
+			return nil, nil, false
+		}
+	}
+}
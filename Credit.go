@@ -0,0 +1,139 @@
+/*
+File Username:  Credit.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Per-peer byte credit of file transfers. Served bytes are the file data sent to the peer, consumed bytes are the data received from the peer (including transfer overhead).
+The balance is consumed minus served; peers that only download have a negative balance.
+If enabled via CreditMaxDebt, a tit-for-tat policy deprioritizes transfer requests from peers whose debt exceeds the limit: they share a small number of upload slots.
+Credits are kept in memory. If the transfer history is enabled, they are restored from it at startup (see Transfer History.go), otherwise they reset
+when the process restarts.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// PeerCredit contains the byte credit of a single peer.
+type PeerCredit struct {
+	Served     uint64           // Bytes of file data served to the peer.
+	Consumed   uint64           // Bytes received from the peer via file transfers.
+	PublicKey  *btcec.PublicKey // Public key of the peer.
+	LastUpdate time.Time        // Last time the credit changed.
+}
+
+// Balance returns the consumed minus served bytes. A negative balance means the peer downloaded more than it provided.
+func (credit *PeerCredit) Balance() int64 {
+	return int64(credit.Consumed) - int64(credit.Served)
+}
+
+// creditSlotWait is the max time a transfer request of a deprioritized peer waits for a free upload slot. Afterwards it is discarded.
+const creditSlotWait = 10 * time.Second
+
+type peerCredits struct {
+	peers map[[btcec.PubKeyBytesLenCompressed]byte]*PeerCredit
+	slots chan struct{} // Upload slots for deprioritized peers
+	sync.Mutex
+}
+
+func (backend *Backend) initCredits() {
+	backend.credits = &peerCredits{peers: make(map[[btcec.PubKeyBytesLenCompressed]byte]*PeerCredit)}
+
+	slots := backend.Config.CreditLowPrioritySlots
+	if slots <= 0 {
+		slots = 1
+	}
+	backend.credits.slots = make(chan struct{}, slots)
+}
+
+// creditGet returns the credit of the peer. It is created if necessary.
+func (backend *Backend) creditGet(publicKey *btcec.PublicKey) (credit *PeerCredit) {
+	var key [btcec.PubKeyBytesLenCompressed]byte
+	copy(key[:], publicKey.SerializeCompressed())
+
+	backend.credits.Lock()
+	defer backend.credits.Unlock()
+
+	if credit = backend.credits.peers[key]; credit == nil {
+		credit = &PeerCredit{PublicKey: publicKey}
+		backend.credits.peers[key] = credit
+	}
+	credit.LastUpdate = time.Now()
+
+	return credit
+}
+
+// creditServed records file data served to the peer.
+func (backend *Backend) creditServed(peer *PeerInfo, bytes uint64) {
+	atomic.AddUint64(&backend.creditGet(peer.PublicKey).Served, bytes)
+}
+
+// creditConsumed records data received from the peer.
+func (backend *Backend) creditConsumed(peer *PeerInfo, bytes uint64) {
+	atomic.AddUint64(&backend.creditGet(peer.PublicKey).Consumed, bytes)
+}
+
+// PeerCreditGet returns the credit of the peer. Found is false if no data was exchanged with the peer since the start.
+func (backend *Backend) PeerCreditGet(publicKey *btcec.PublicKey) (credit PeerCredit, found bool) {
+	var key [btcec.PubKeyBytesLenCompressed]byte
+	copy(key[:], publicKey.SerializeCompressed())
+
+	backend.credits.Lock()
+	defer backend.credits.Unlock()
+
+	existing := backend.credits.peers[key]
+	if existing == nil {
+		return PeerCredit{PublicKey: publicKey}, false
+	}
+
+	return existing.load(), true
+}
+
+// PeerCredits returns the credits of all peers that data was exchanged with, sorted by balance (lowest first).
+func (backend *Backend) PeerCredits() (credits []PeerCredit) {
+	backend.credits.Lock()
+	for _, credit := range backend.credits.peers {
+		credits = append(credits, credit.load())
+	}
+	backend.credits.Unlock()
+
+	sort.Slice(credits, func(i, j int) bool { return credits[i].Balance() < credits[j].Balance() })
+
+	return credits
+}
+
+func (credit *PeerCredit) load() PeerCredit {
+	return PeerCredit{PublicKey: credit.PublicKey, Served: atomic.LoadUint64(&credit.Served), Consumed: atomic.LoadUint64(&credit.Consumed), LastUpdate: credit.LastUpdate}
+}
+
+// CreditDeprioritized checks if transfer requests from the peer are deprioritized by the tit-for-tat policy.
+func (backend *Backend) CreditDeprioritized(publicKey *btcec.PublicKey) bool {
+	if backend.Config.CreditMaxDebt == 0 {
+		return false
+	}
+
+	credit, found := backend.PeerCreditGet(publicKey)
+	return found && credit.Balance() < -int64(backend.Config.CreditMaxDebt)*1024*1024
+}
+
+// creditAcquireSlot waits for a free upload slot if the peer is deprioritized. It returns false if no slot became available in time.
+// If a slot was acquired, release must be called when the transfer ends.
+func (backend *Backend) creditAcquireSlot(peer *PeerInfo) (acquired bool, release func()) {
+	if !backend.CreditDeprioritized(peer.PublicKey) {
+		return true, func() {}
+	}
+
+	select {
+	case backend.credits.slots <- struct{}{}:
+		return true, func() { <-backend.credits.slots }
+	case <-time.After(creditSlotWait):
+		return false, nil
+	}
+}
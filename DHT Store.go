@@ -7,6 +7,11 @@ Author:     Peter Kleissner
 package core
 
 import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
 	"github.com/PeernetOfficial/core/protocol"
 	"github.com/PeernetOfficial/core/store"
 )
@@ -32,17 +37,257 @@ func (peer *PeerInfo) announcementGetData(hash []byte) (stored bool, data []byte
 	return true, nil
 }
 
+// INFO_STORE records are announcements by other peers that they store a file. They are kept in memory and returned as storing peers
+// in responses to FIND_VALUE requests. Since anyone can announce arbitrary hashes, records are only accepted if they are useful and within limits:
+// * The hash must be close to this node, i.e. there are fewer than bucketSize known nodes closer to it. Otherwise no FIND_VALUE request would reach this node.
+// * The size must be plausible and the type known. Keyword postings (see Keyword Index.go) are accepted like files, but are not challenged.
+// * Per-peer, per-network (IPv4 /24, IPv6 /48) and global record limits. Once reached, new records are dropped; existing ones are refreshed.
+// Records expire unless they are announced again.
+const (
+	infoStoreMaxPerPeer    = 1000             // Max count of records per announcing peer.
+	infoStoreMaxPerNetwork = 5000             // Max count of records per network of the announcing peer.
+	infoStoreMaxTotal      = 100000           // Max count of records in total.
+	infoStoreMaxPeers      = bucketSize       // Max count of storing peers kept per hash.
+	infoStoreMaxFileSize   = 1 << 40          // Max plausible file size (1 TB).
+	infoStoreExpiry        = 2 * time.Hour    // Records expire if not announced again.
+	infoStoreExpireCheck   = 10 * time.Minute // Interval to remove expired records.
+)
+
+type infoStoreKey [btcec.PubKeyBytesLenCompressed]byte
+
+type infoStoreRecord struct {
+	publicKey *btcec.PublicKey // Peer storing the file.
+	network   string           // Network of the peer, used for the per-network limit.
+	size      uint64           // Size of the file as announced. For keyword postings the count of matching files.
+	expires   time.Time        // Expiration of the record.
+	keyword   bool             // Whether the record is a keyword posting. It cannot be proven and is never challenged.
+
+	proof       int  // Proof state, see infoStoreProofX.
+	proofQueued bool // Whether the record is queued to be challenged.
+}
+
+// infoStore contains the INFO_STORE records by hash and the counters for the limits.
+type infoStore struct {
+	records    map[string]map[infoStoreKey]*infoStoreRecord // Records by hash and peer.
+	perPeer    map[infoStoreKey]int                         // Count of records per peer.
+	perNetwork map[string]int                               // Count of records per network.
+	total      int                                          // Count of all records.
+	dropped    uint64                                       // Count of records dropped due to limits or checks.
+
+	// INFO_STORE proofs, see initInfoStoreProof
+	proofQueue            chan infoStoreProofTask    // Records to challenge. Nil if disabled.
+	proofSlots            chan struct{}              // Challenges currently answered.
+	proofFailures         map[infoStoreKey]int       // Count of failed challenges per peer.
+	proofExcluded         map[infoStoreKey]time.Time // Peers excluded until the time.
+	proofUnsupported      map[infoStoreKey]time.Time // Peers that do not support proofs, until the time.
+	proofPassed           uint64                     // Count of passed challenges.
+	proofFailed           uint64                     // Count of failed challenges.
+	proofUnsupportedCount uint64                     // Count of challenges to peers that do not support proofs.
+	proofUnreachable      uint64                     // Count of challenges that could not be sent.
+
+	sync.Mutex
+}
+
+func (backend *Backend) initInfoStore() {
+	backend.infoStore = &infoStore{
+		records:    make(map[string]map[infoStoreKey]*infoStoreRecord),
+		perPeer:    make(map[infoStoreKey]int),
+		perNetwork: make(map[string]int),
+	}
+
+	backend.initInfoStoreProof()
+
+	backend.scheduleTask("info-store-expiry", infoStoreExpireCheck, infoStoreExpireCheck, backend.expireInfoStore)
+}
+
 // announcementStore handles an incoming announcement by another peer about storing data
+// Supernodes accept records regardless of the distance to the hash and with raised limits.
 func (peer *PeerInfo) announcementStore(records []protocol.InfoStore) {
-	// TODO: Only store the other peers data if certain conditions are met:
-	// - enough storage available
-	// - not exceeding record count per peer
-	// - not exceeding total record count limit
-	// - not exceeding record count per CIDR
-	//for _, record := range records {
-	//fmt.Printf("Remote node %s stores hash %s (size %d type %d)\n", hex.EncodeToString(peer.NodeID), hex.EncodeToString(record.ID.Hash), record.Size, record.Type)
-	//Warehouse.Store(record.ID.Hash, )
-	// TODO: Request data from remote node.
-	//peer.sendAnnouncement(false, false, nil, []KeyHash{record.ID}, nil, nil)
-	//}
+	network := peerNetworkPrefix(peer)
+	key := infoStoreKey(publicKey2Compressed(peer.PublicKey))
+	expires := time.Now().Add(infoStoreExpiry)
+
+	factor := 1
+	if peer.Backend.supernodeAvailable() {
+		factor = supernodeInfoStoreFactor
+	}
+
+	for _, record := range records {
+		valid := len(record.ID.Hash) == protocol.HashSize && record.Size > 0 && record.Size <= infoStoreMaxFileSize && record.Type <= protocol.InfoStoreTypeKeyword
+		if !valid || factor == 1 && !peer.Backend.infoStoreUseful(record.ID.Hash) {
+			peer.Backend.infoStore.drop()
+			continue
+		}
+
+		if peer.Backend.infoStore.add(record.ID.Hash, key, &infoStoreRecord{publicKey: peer.PublicKey, network: network, size: record.Size, expires: expires, keyword: record.Type == protocol.InfoStoreTypeKeyword}, factor) {
+			peer.Backend.infoStoreProofEnqueue(infoStoreProofTask{hash: record.ID.Hash, key: key, publicKey: peer.PublicKey, size: record.Size})
+		}
+	}
+}
+
+// infoStoreUseful checks if FIND_VALUE requests for the hash would reach this node, i.e. there are fewer than bucketSize known nodes closer to it.
+func (backend *Backend) infoStoreUseful(hash []byte) bool {
+	closest := backend.nodesDHT.GetClosestContacts(bucketSize, hash, nil)
+	if len(closest) < bucketSize {
+		return true
+	}
+
+	return isCloserXOR(hash, backend.nodeID, closest[len(closest)-1].ID)
+}
+
+// add adds or refreshes the record, unless a limit multiplied by the factor is exceeded or the peer is excluded.
+// If INFO_STORE proofs are enabled, it returns true if the record is pending and must be challenged.
+func (store *infoStore) add(hash []byte, key infoStoreKey, record *infoStoreRecord, factor int) (challenge bool) {
+	store.Lock()
+	defer store.Unlock()
+
+	if _, excluded := store.proofExcluded[key]; excluded {
+		store.dropped++
+		return false
+	}
+
+	peers := store.records[string(hash)]
+	if existing := peers[key]; existing != nil {
+		if existing.size != record.size {
+			existing.proof = infoStoreProofPending
+		}
+		existing.size = record.size
+		existing.expires = record.expires
+		return store.proofNeeded(existing)
+	}
+
+	if store.total >= infoStoreMaxTotal*factor || store.perPeer[key] >= infoStoreMaxPerPeer*factor || store.perNetwork[record.network] >= infoStoreMaxPerNetwork*factor || len(peers) >= infoStoreMaxPeers*factor {
+		store.dropped++
+		return false
+	}
+
+	if peers == nil {
+		peers = make(map[infoStoreKey]*infoStoreRecord)
+		store.records[string(hash)] = peers
+	}
+
+	peers[key] = record
+	store.perPeer[key]++
+	store.perNetwork[record.network]++
+	store.total++
+
+	if _, unsupported := store.proofUnsupported[key]; unsupported {
+		record.proof = infoStoreProofUnsupported
+	}
+
+	return store.proofNeeded(record)
+}
+
+func (store *infoStore) drop() {
+	store.Lock()
+	store.dropped++
+	store.Unlock()
+}
+
+// remove removes the record. The caller must hold the lock.
+func (store *infoStore) remove(hash string, key infoStoreKey, record *infoStoreRecord) {
+	delete(store.records[hash], key)
+	if len(store.records[hash]) == 0 {
+		delete(store.records, hash)
+	}
+
+	if store.perPeer[key]--; store.perPeer[key] <= 0 {
+		delete(store.perPeer, key)
+	}
+	if store.perNetwork[record.network]--; store.perNetwork[record.network] <= 0 {
+		delete(store.perNetwork, record.network)
+	}
+	store.total--
+}
+
+// expireInfoStore removes expired INFO_STORE records.
+func (backend *Backend) expireInfoStore() error {
+	store := backend.infoStore
+	now := time.Now()
+
+	store.Lock()
+	defer store.Unlock()
+
+	for hash, peers := range store.records {
+		for key, record := range peers {
+			if record.expires.Before(now) {
+				store.remove(hash, key, record)
+			}
+		}
+	}
+
+	store.proofExpire(now)
+
+	return nil
+}
+
+// infoStorePeers returns the peers storing the file according to INFO_STORE records. Peers that are no longer in the peer list are skipped.
+// If INFO_STORE proofs are required, only records that passed the challenge are used.
+func (backend *Backend) infoStorePeers(hash []byte, exclude *btcec.PublicKey) (peers []*PeerInfo) {
+	var publicKeys []*btcec.PublicKey
+	now := time.Now()
+	require := backend.Config.InfoStoreProof == InfoStoreProofRequire
+
+	backend.infoStore.Lock()
+	for _, record := range backend.infoStore.records[string(hash)] {
+		if record.expires.After(now) && !record.publicKey.IsEqual(exclude) && (!require || record.keyword || record.proof == infoStoreProofPassed) {
+			publicKeys = append(publicKeys, record.publicKey)
+		}
+	}
+	backend.infoStore.Unlock()
+
+	for _, publicKey := range publicKeys {
+		if peer := backend.PeerlistLookup(publicKey); peer != nil {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+// infoStoreRecords returns the records of peers storing the file to respond to the peer's FIND_VALUE request. The requesting peer itself is excluded.
+func (peer *PeerInfo) infoStoreRecords(hash []byte, allowLocal, allowIPv4, allowIPv6 bool) (records []protocol.PeerRecord) {
+	for _, storing := range peer.Backend.infoStorePeers(hash, peer.PublicKey) {
+		if record := storing.peer2Record(allowLocal, allowIPv4, allowIPv6); record != nil {
+			records = append(records, *record)
+		}
+		if len(records) >= respondClosesContactsCount {
+			break
+		}
+	}
+
+	return records
+}
+
+// InfoStoreStats returns the count of INFO_STORE records kept, the count of distinct hashes, and the count of records dropped due to limits or checks.
+func (backend *Backend) InfoStoreStats() (records, hashes int, dropped uint64) {
+	backend.infoStore.Lock()
+	defer backend.infoStore.Unlock()
+
+	return backend.infoStore.total, len(backend.infoStore.records), backend.infoStore.dropped
+}
+
+// peerNetworkPrefix returns the network of the peer's latest connection: /24 for IPv4 and /48 for IPv6. Empty if the peer has no active connection.
+func peerNetworkPrefix(peer *PeerInfo) string {
+	connections := peer.GetConnections(true)
+	if len(connections) == 0 {
+		return ""
+	}
+
+	if IP := connections[0].Address.IP.To4(); IP != nil {
+		return IP.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return connections[0].Address.IP.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// isCloserXOR checks if a is closer to the target than b by XOR distance.
+func isCloserXOR(target, a, b []byte) bool {
+	for n := 0; n < len(target) && n < len(a) && n < len(b); n++ {
+		distanceA, distanceB := target[n]^a[n], target[n]^b[n]
+		if distanceA != distanceB {
+			return distanceA < distanceB
+		}
+	}
+
+	return false
 }
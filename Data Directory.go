@@ -0,0 +1,318 @@
+/*
+File Username:  Data Directory.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The data directory contains all files and folders written by the core. Relative locations in the config (log file, blockchains, warehouse,
+search index, GeoIP database) are relative to the config setting DataFolder, so the entire installation can be relocated by changing that one setting
+and moving the folder. Absolute locations are used as they are.
+
+Configs created before the layout was introduced (DataLayoutVersion 0) use locations relative to the working directory. They are upgraded at startup
+without moving any data: locations inside the data folder are rewritten relative to it, and locations outside are made absolute.
+MigrateDataFolder moves the data folder of an existing installation to a new location and updates the config. The node must not be running.
+*/
+
+package core
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dataLayoutVersion is the current version of the data directory layout.
+const dataLayoutVersion = 1
+
+// DataLayout contains the resolved locations of the data files and folders. Empty locations are disabled.
+type DataLayout struct {
+	DataFolder       string // Data folder.
+	LogFile          string // Log file.
+	BlockchainMain   string // User's blockchain.
+	BlockchainGlobal string // Cache of blockchains of other peers.
+	WarehouseMain    string // User's warehouse.
+	SearchIndex      string // Search index.
+	GeoIPDatabase    string // GeoIP database.
+	DownloadFolder   string // Default folder for completed downloads.
+	DownloadTemp     string // Folder for incomplete downloads.
+	TransferHistory  string // Ledger of file transfers.
+}
+
+// Layout returns the resolved locations of the data files and folders based on the config.
+func (config *Config) Layout() (layout DataLayout) {
+	resolve := func(location string) string {
+		if location == "" || config.DataLayoutVersion < 1 || filepath.IsAbs(location) {
+			return location
+		}
+		return filepath.Join(config.DataFolder, location)
+	}
+
+	return DataLayout{
+		DataFolder:       config.DataFolder,
+		LogFile:          resolve(config.LogFile),
+		BlockchainMain:   resolve(config.BlockchainMain),
+		BlockchainGlobal: resolve(config.BlockchainGlobal),
+		WarehouseMain:    resolve(config.WarehouseMain),
+		SearchIndex:      resolve(config.SearchIndex),
+		GeoIPDatabase:    resolve(config.GeoIPDatabase),
+		DownloadFolder:   resolve(config.DownloadFolder),
+		DownloadTemp:     resolve(config.DownloadTemp),
+		TransferHistory:  resolve(config.TransferHistory),
+	}
+}
+
+// upgradeLayout upgrades the config to the current layout version without changing the resolved locations. It returns true if the config was changed.
+func (config *Config) upgradeLayout() (changed bool) {
+	if config.DataLayoutVersion >= dataLayoutVersion {
+		return false
+	}
+
+	for _, location := range config.layoutFields() {
+		*location = legacyLocationToLayout(*location, config.DataFolder)
+	}
+
+	config.DataLayoutVersion = dataLayoutVersion
+	return true
+}
+
+func (config *Config) layoutFields() []*string {
+	return []*string{&config.LogFile, &config.BlockchainMain, &config.BlockchainGlobal, &config.WarehouseMain, &config.SearchIndex, &config.GeoIPDatabase, &config.DownloadFolder, &config.DownloadTemp, &config.TransferHistory}
+}
+
+// legacyLocationToLayout converts a location relative to the working directory into one relative to the data folder.
+// Locations outside the data folder are made absolute. Trailing slashes are kept.
+func legacyLocationToLayout(location, dataFolder string) string {
+	if location == "" || filepath.IsAbs(location) {
+		return location
+	}
+
+	trailing := ""
+	if strings.HasSuffix(location, "/") || strings.HasSuffix(location, string(filepath.Separator)) {
+		trailing = "/"
+	}
+
+	if dataFolder != "" {
+		if relative, err := filepath.Rel(dataFolder, location); err == nil && relative != "." && !strings.HasPrefix(relative, "..") {
+			return filepath.ToSlash(relative) + trailing
+		}
+	}
+
+	if absolute, err := filepath.Abs(location); err == nil {
+		return absolute + trailing
+	}
+
+	return location
+}
+
+// initDataLayout upgrades the config if needed and resolves the data locations. It must be called before any data is accessed.
+func (backend *Backend) initDataLayout() {
+	if backend.Config.upgradeLayout() {
+		if err := configSetFields(backend.ConfigFilename, layoutConfigFields(backend.Config)); err != nil {
+			backend.LogError("initDataLayout", "upgrading config '%s': %v\n", backend.ConfigFilename, err)
+		}
+	}
+
+	backend.DataLayout = backend.Config.Layout()
+}
+
+// layoutConfigFields returns the config settings that define the layout.
+func layoutConfigFields(config *Config) map[string]interface{} {
+	return map[string]interface{}{
+		"DataFolder":        config.DataFolder,
+		"DataLayoutVersion": config.DataLayoutVersion,
+		"LogFile":           config.LogFile,
+		"BlockchainMain":    config.BlockchainMain,
+		"BlockchainGlobal":  config.BlockchainGlobal,
+		"WarehouseMain":     config.WarehouseMain,
+		"SearchIndex":       config.SearchIndex,
+		"GeoIPDatabase":     config.GeoIPDatabase,
+		"DownloadFolder":    config.DownloadFolder,
+		"DownloadTemp":      config.DownloadTemp,
+		"TransferHistory":   config.TransferHistory,
+	}
+}
+
+// MigrateDataFolder moves the data folder of the installation to the new folder and updates the config file. The node must not be running.
+// Locations outside the data folder are not moved. The new folder must not exist or be empty. If the move fails, the data remains in the old folder.
+func MigrateDataFolder(configFilename, newFolder string) (err error) {
+	stats, err := os.Stat(configFilename)
+	if err != nil || stats.Size() == 0 {
+		return errors.New("config file not found")
+	}
+
+	var config Config
+	if _, err = LoadConfig(configFilename, &config); err != nil {
+		return err
+	}
+
+	config.upgradeLayout()
+
+	if config.DataFolder == "" || newFolder == "" {
+		return errors.New("data folder not set")
+	}
+
+	oldAbsolute, err1 := filepath.Abs(config.DataFolder)
+	newAbsolute, err2 := filepath.Abs(newFolder)
+	if err1 != nil || err2 != nil {
+		return errors.New("invalid data folder")
+	} else if oldAbsolute == newAbsolute {
+		return errors.New("data folder unchanged")
+	} else if strings.HasPrefix(newAbsolute+string(filepath.Separator), oldAbsolute+string(filepath.Separator)) {
+		return errors.New("new data folder is inside the current one")
+	}
+
+	if entries, err := ioutil.ReadDir(newAbsolute); err == nil && len(entries) > 0 {
+		return errors.New("new data folder is not empty")
+	} else if err == nil {
+		os.Remove(newAbsolute)
+	}
+
+	if _, err := os.Stat(oldAbsolute); err == nil {
+		if err = moveFolder(oldAbsolute, newAbsolute); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	config.DataFolder = newFolder
+	if !strings.HasSuffix(config.DataFolder, "/") && !strings.HasSuffix(config.DataFolder, string(filepath.Separator)) {
+		config.DataFolder += "/"
+	}
+
+	if err = configSetFields(configFilename, layoutConfigFields(&config)); err != nil {
+		// Move the data back so that it matches the unchanged config.
+		if errBack := moveFolder(newAbsolute, oldAbsolute); errBack != nil {
+			return errors.New("updating config failed and data could not be moved back: " + err.Error())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// moveFolder moves the folder. If it cannot be renamed (for example across drives), it is copied and the source is deleted after the copy succeeded.
+func moveFolder(source, target string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err = os.Rename(source, target); err == nil {
+		return nil
+	}
+
+	if err = copyFolder(source, target); err != nil {
+		os.RemoveAll(target)
+		return err
+	}
+
+	return os.RemoveAll(source)
+}
+
+// copyFolder copies all files and folders. File sizes are verified.
+func copyFolder(source, target string) (err error) {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(target, relative)
+
+		if info.IsDir() {
+			return os.MkdirAll(targetPath, info.Mode().Perm()|0700)
+		} else if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return copyFile(path, targetPath, info)
+	})
+}
+
+func copyFile(source, target string, info os.FileInfo) (err error) {
+	fileSource, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fileSource.Close()
+
+	fileTarget, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(fileTarget, fileSource)
+	if err == nil {
+		err = fileTarget.Sync()
+	}
+	if errClose := fileTarget.Close(); err == nil {
+		err = errClose
+	}
+
+	if err == nil && written != info.Size() {
+		err = errors.New("copied file size mismatch: " + source)
+	}
+
+	return err
+}
+
+// configSetFields sets top-level settings in the config file. Other settings and comments are kept.
+func configSetFields(filename string, fields map[string]interface{}) (err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var document yaml.Node
+	if err = yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+
+	if document.Kind == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return errors.New("invalid config structure")
+	}
+	mapping := document.Content[0]
+
+	for key, value := range fields {
+		var valueNode yaml.Node
+		if err = valueNode.Encode(value); err != nil {
+			return err
+		}
+
+		found := false
+		for n := 0; n+1 < len(mapping.Content); n += 2 {
+			if mapping.Content[n].Value == key {
+				valueNode.LineComment = mapping.Content[n+1].LineComment
+				*mapping.Content[n+1] = valueNode
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &valueNode)
+		}
+	}
+
+	if data, err = yaml.Marshal(&document); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that the config is never left half-written.
+	temp := filename + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, filename)
+}
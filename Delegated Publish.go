@@ -0,0 +1,96 @@
+/*
+File Username:  Delegated Publish.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Delegated publishing allows automated publishers such as server-side bots to publish records on the blockchain of a user without holding the
+user's private key. The user authorizes the peer ID of the bot's node via a delegation record (blockchain.DelegationAdd) with the record types
+it may publish and an expiry. The bot signs the records with its own peer key and sends them to the user's node via the stream service
+"delegated-publish/1", see DelegatedPublish. The user's node appends them if authorized, see blockchain.DelegatedAppend.
+Other peers can attribute the records to the delegate via BlockRecordRaw.Delegate.
+*/
+
+package core
+
+import (
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+)
+
+// delegatedPublishStreamService is the name of the stream service for delegated publishing.
+const delegatedPublishStreamService = "delegated-publish/1"
+
+// delegatedPublishTimeout is the timeout for a delegated publish request.
+const delegatedPublishTimeout = 30 * time.Second
+
+func (backend *Backend) initDelegatedPublish() {
+	backend.RegisterStreamService(delegatedPublishStreamService, backend.delegatedPublishStreamHandler)
+}
+
+// delegatedPublishStreamHandler appends records sent by a delegate to the user's blockchain.
+func (backend *Backend) delegatedPublishStreamHandler(peer *PeerInfo, conn *udt.UDTSocket) {
+	conn.SetDeadline(time.Now().Add(delegatedPublishTimeout))
+
+	recordsRaw, err := protocol.DelegatedPublishRequestRead(conn)
+	if err != nil {
+		return
+	}
+
+	var records []blockchain.BlockRecordRaw
+
+	for _, raw := range recordsRaw {
+		record, err := blockchain.DecodeDelegatedRecord(raw, backend.PeerPublicKey)
+		if err != nil {
+			protocol.DelegatedPublishResponseWrite(conn, blockchain.StatusNotAuthorized, 0, 0)
+			return
+		}
+		records = append(records, record)
+	}
+
+	newHeight, newVersion, status := backend.UserBlockchain.DelegatedAppend(records)
+
+	protocol.DelegatedPublishResponseWrite(conn, uint8(status), newHeight, newVersion)
+}
+
+// DelegatedPublish signs the records with the peer's key and sends them to the owner to be appended to the owner's blockchain. This peer must be
+// authorized by a delegation record of the owner. Status is blockchain.StatusX as returned by the owner.
+// It returns ErrStreamServiceNotAvailable if the owner does not support delegated publishing.
+func (backend *Backend) DelegatedPublish(owner *PeerInfo, records []blockchain.BlockRecordRaw) (newHeight, newVersion uint64, status int, err error) {
+	var recordsRaw [][]byte
+
+	for _, record := range records {
+		signed, err := blockchain.DelegatedRecordSign(backend.PeerPrivateKey, owner.PublicKey, record)
+		if err != nil {
+			return 0, 0, blockchain.StatusCorruptBlockRecord, err
+		}
+
+		raw, err := blockchain.EncodeDelegatedRecord(signed)
+		if err != nil {
+			return 0, 0, blockchain.StatusCorruptBlockRecord, err
+		}
+
+		recordsRaw = append(recordsRaw, raw)
+	}
+
+	conn, err := owner.OpenStream(delegatedPublishStreamService, delegatedPublishTimeout)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(delegatedPublishTimeout))
+
+	if err = protocol.DelegatedPublishRequestWrite(conn, recordsRaw); err != nil {
+		return 0, 0, 0, err
+	}
+
+	statusR, newHeight, newVersion, err := protocol.DelegatedPublishResponseRead(conn)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return newHeight, newVersion, int(statusR), nil
+}
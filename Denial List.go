@@ -0,0 +1,355 @@
+/*
+File Username:  Denial List.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Denial lists are lists of hashes of known-bad content (for example malware) published by list maintainers on their blockchain via
+Blockchain.DenialPublish. Subscribing is opt-in via the config setting DenialLists. Subscribed nodes read the maintainer's blocks regularly and
+refuse to serve listed hashes (file transfers, FIND_VALUE responses and the gateway) and exclude them from search and explore results.
+Blocks not signed by the maintainer are ignored. The user can override the lists locally per hash via DenialOverrideSet, which is stored in
+the config settings DenialAllow and DenialDeny. Local overrides take precedence over all lists.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// denialMaxBlocks is the max count of the maintainer's latest blocks read.
+const denialMaxBlocks = 4096
+
+// denialMaxBlockSize is the max size of a block of the maintainer's blockchain.
+const denialMaxBlockSize = 64 * 1024
+
+// denialMaxEntries is the max count of hashes kept per list.
+const denialMaxEntries = 100000
+
+// denialFindTimeout is the timeout to find the maintainer via the DHT.
+const denialFindTimeout = 10 * time.Second
+
+// Local overrides of denial lists
+const (
+	DenialOverrideNone  = 0 // No override. The lists apply.
+	DenialOverrideAllow = 1 // The hash is allowed, even if listed.
+	DenialOverrideDeny  = 2 // The hash is denied, even if not listed.
+)
+
+// DenialListStatus is the status of a subscribed denial list.
+type DenialListStatus struct {
+	Maintainer *btcec.PublicKey // Public key of the list maintainer.
+	Entries    int              // Count of denied hashes.
+	Version    uint64           // Version of the maintainer's blockchain that was read.
+	Height     uint64           // Height of the maintainer's blockchain that was read.
+	Refreshed  time.Time        // Time of the last refresh. Zero if not refreshed yet.
+	Error      string           // Error of the last refresh, if any.
+}
+
+// DenialCheckResult is the result of checking a hash against the denial lists.
+type DenialCheckResult struct {
+	Denied     bool             // Whether the hash is denied, including the local override.
+	Override   int              // Local override, see DenialOverrideX.
+	Maintainer *btcec.PublicKey // Maintainer of the first list that contains the hash. Nil if not listed.
+	Reason     string           // Reason provided by the maintainer.
+}
+
+type denialList struct {
+	status DenialListStatus
+	hashes map[[protocol.HashSize]byte]string // Denied hashes and their reason
+}
+
+type denialLists struct {
+	lists     []*denialList
+	overrides map[[protocol.HashSize]byte]int
+	refresh   sync.Mutex // Serializes refreshes.
+	sync.RWMutex
+}
+
+func (backend *Backend) initDenialLists() {
+	backend.denialLists = &denialLists{overrides: make(map[[protocol.HashSize]byte]int)}
+
+	for _, peerID := range backend.Config.DenialLists {
+		maintainer, err := PublicKeyFromPeerID(peerID)
+		if err != nil {
+			backend.LogError("initDenialLists", "invalid denial list maintainer '%s': %v\n", peerID, err)
+			continue
+		}
+
+		backend.denialLists.lists = append(backend.denialLists.lists, &denialList{status: DenialListStatus{Maintainer: maintainer}, hashes: make(map[[protocol.HashSize]byte]string)})
+	}
+
+	for override, hashes := range map[int][]string{DenialOverrideAllow: backend.Config.DenialAllow, DenialOverrideDeny: backend.Config.DenialDeny} {
+		for _, hashA := range hashes {
+			hash, err := hex.DecodeString(hashA)
+			if err != nil || len(hash) != protocol.HashSize {
+				backend.LogError("initDenialLists", "invalid denial override hash '%s'\n", hashA)
+				continue
+			}
+
+			var key [protocol.HashSize]byte
+			copy(key[:], hash)
+			backend.denialLists.overrides[key] = override
+		}
+	}
+}
+
+// scheduleDenialLists refreshes the subscribed denial lists regularly.
+func (backend *Backend) scheduleDenialLists() {
+	if len(backend.denialLists.lists) == 0 || backend.Config.DenialListInterval <= 0 {
+		return
+	}
+
+	interval := time.Duration(backend.Config.DenialListInterval) * time.Hour
+
+	backend.scheduleTask("denial-lists", time.Minute, interval, backend.DenialListsRefresh)
+}
+
+// DenialListsRefresh reads the latest blocks of all subscribed denial lists. It returns the first error, but continues with the other lists.
+func (backend *Backend) DenialListsRefresh() (err error) {
+	lists := backend.denialLists
+	lists.refresh.Lock()
+	defer lists.refresh.Unlock()
+
+	for _, list := range lists.lists {
+		if errList := backend.denialListRefresh(list); errList != nil && err == nil {
+			err = errList
+		}
+	}
+
+	return err
+}
+
+// denialListRefresh reads the maintainer's blockchain. Only new blocks are read if the blockchain version did not change.
+func (backend *Backend) denialListRefresh(list *denialList) (err error) {
+	maintainer := backend.denialListStatus(list).Maintainer
+
+	var denials []blockchain.BlockRecordDenial
+	var version, height uint64
+	incremental := false
+
+	if maintainer.IsEqual(backend.PeerPublicKey) {
+		var status int
+		if denials, status = backend.UserBlockchain.DenialList(); status != blockchain.StatusOK {
+			err = errors.New("reading blockchain failed")
+		}
+		_, height, version = backend.UserBlockchain.Header()
+	} else {
+		denials, version, height, incremental, err = backend.denialListDownload(maintainer, backend.denialListStatus(list))
+	}
+
+	backend.denialLists.Lock()
+	defer backend.denialLists.Unlock()
+
+	list.status.Refreshed = time.Now()
+	list.status.Error = ""
+
+	if err != nil {
+		list.status.Error = err.Error()
+		return err
+	}
+
+	if !incremental {
+		list.hashes = make(map[[protocol.HashSize]byte]string)
+	}
+
+	for _, denial := range denials {
+		var key [protocol.HashSize]byte
+		copy(key[:], denial.Hash)
+
+		switch denial.Action {
+		case blockchain.DenialAdd:
+			if _, ok := list.hashes[key]; ok || len(list.hashes) < denialMaxEntries {
+				list.hashes[key] = denial.Reason
+			}
+		case blockchain.DenialRemove:
+			delete(list.hashes, key)
+		}
+	}
+
+	list.status.Entries = len(list.hashes)
+	list.status.Version = version
+	list.status.Height = height
+
+	return nil
+}
+
+// denialListDownload downloads the denial records from the maintainer's blocks. If the blockchain version is the same as read before, only new blocks
+// are downloaded and incremental is true. All blocks must be signed by the maintainer. The records are returned in the order they were published.
+func (backend *Backend) denialListDownload(maintainer *btcec.PublicKey, status DenialListStatus) (denials []blockchain.BlockRecordDenial, version, height uint64, incremental bool, err error) {
+	_, peer, err := backend.FindNode(protocol.PublicKey2NodeID(maintainer), denialFindTimeout)
+	if err != nil {
+		return nil, 0, 0, false, err
+	} else if peer == nil {
+		return nil, 0, 0, false, errors.New("maintainer not found")
+	}
+
+	version, height = peer.BlockchainVersion, peer.BlockchainHeight
+
+	offset := uint64(0)
+	if !status.Refreshed.IsZero() && status.Error == "" && status.Version == version && status.Height <= height {
+		offset = status.Height
+		incremental = true
+	} else if height > denialMaxBlocks {
+		offset = height - denialMaxBlocks
+	}
+
+	if offset >= height {
+		return nil, version, height, incremental, nil
+	}
+
+	blocks := make(map[uint64][]blockchain.BlockRecordDenial)
+	var mutex sync.Mutex
+
+	err = peer.BlockDownload(maintainer, height-offset, denialMaxBlockSize, []protocol.BlockRange{{Offset: offset, Limit: height - offset}}, func(data []byte, targetBlock protocol.BlockRange, blockSize uint64, availability uint8) {
+		if availability != protocol.GetBlockStatusAvailable {
+			return
+		}
+
+		decoded, status, err := blockchain.DecodeBlockRaw(data)
+		if err != nil || status != blockchain.StatusOK || !decoded.Block.OwnerPublicKey.IsEqual(maintainer) || decoded.Block.Number != targetBlock.Offset {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		blocks[targetBlock.Offset] = nil
+		for _, record := range decoded.RecordsDecoded {
+			if denial, ok := record.(blockchain.BlockRecordDenial); ok {
+				blocks[targetBlock.Offset] = append(blocks[targetBlock.Offset], denial)
+			}
+		}
+	})
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+
+	// Only complete updates are applied, so that no block is skipped by the next incremental refresh.
+	if incremental && uint64(len(blocks)) != height-offset {
+		return nil, 0, 0, false, errors.New("incomplete download")
+	}
+
+	numbers := make([]uint64, 0, len(blocks))
+	for number := range blocks {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	for _, number := range numbers {
+		denials = append(denials, blocks[number]...)
+	}
+
+	return denials, version, height, incremental, nil
+}
+
+func (backend *Backend) denialListStatus(list *denialList) DenialListStatus {
+	backend.denialLists.RLock()
+	defer backend.denialLists.RUnlock()
+
+	return list.status
+}
+
+// DenialListsStatus returns the status of all subscribed denial lists.
+func (backend *Backend) DenialListsStatus() (status []DenialListStatus) {
+	backend.denialLists.RLock()
+	defer backend.denialLists.RUnlock()
+
+	for _, list := range backend.denialLists.lists {
+		status = append(status, list.status)
+	}
+
+	return status
+}
+
+// DenialCheck checks the hash against the local overrides and the subscribed denial lists.
+func (backend *Backend) DenialCheck(hash []byte) (result DenialCheckResult) {
+	if len(hash) != protocol.HashSize {
+		return result
+	}
+
+	var key [protocol.HashSize]byte
+	copy(key[:], hash)
+
+	backend.denialLists.RLock()
+	defer backend.denialLists.RUnlock()
+
+	result.Override = backend.denialLists.overrides[key]
+
+	for _, list := range backend.denialLists.lists {
+		if reason, ok := list.hashes[key]; ok {
+			result.Maintainer = list.status.Maintainer
+			result.Reason = reason
+			break
+		}
+	}
+
+	result.Denied = result.Override == DenialOverrideDeny || (result.Override != DenialOverrideAllow && result.Maintainer != nil)
+
+	return result
+}
+
+// IsHashDenied checks if the hash is denied by a subscribed denial list or the local override. Denied hashes must not be served or displayed.
+func (backend *Backend) IsHashDenied(hash []byte) bool {
+	return backend.DenialCheck(hash).Denied
+}
+
+// DenialOverrideSet sets the local override for the hash (see DenialOverrideX) and stores it in the config.
+func (backend *Backend) DenialOverrideSet(hash []byte, override int) (err error) {
+	if len(hash) != protocol.HashSize {
+		return errors.New("invalid hash")
+	} else if override != DenialOverrideNone && override != DenialOverrideAllow && override != DenialOverrideDeny {
+		return errors.New("invalid override")
+	}
+
+	var key [protocol.HashSize]byte
+	copy(key[:], hash)
+
+	backend.denialLists.Lock()
+
+	if override == DenialOverrideNone {
+		delete(backend.denialLists.overrides, key)
+	} else {
+		backend.denialLists.overrides[key] = override
+	}
+
+	var allow, deny []string
+	for key, override := range backend.denialLists.overrides {
+		if override == DenialOverrideAllow {
+			allow = append(allow, hex.EncodeToString(key[:]))
+		} else {
+			deny = append(deny, hex.EncodeToString(key[:]))
+		}
+	}
+	sort.Strings(allow)
+	sort.Strings(deny)
+
+	backend.Config.DenialAllow = allow
+	backend.Config.DenialDeny = deny
+
+	backend.denialLists.Unlock()
+
+	backend.SaveConfig()
+
+	return nil
+}
+
+// DenialOverrides returns all local overrides as hex encoded hash and override (see DenialOverrideX).
+func (backend *Backend) DenialOverrides() (overrides map[string]int) {
+	backend.denialLists.RLock()
+	defer backend.denialLists.RUnlock()
+
+	overrides = make(map[string]int)
+	for key, override := range backend.denialLists.overrides {
+		overrides[hex.EncodeToString(key[:])] = override
+	}
+
+	return overrides
+}
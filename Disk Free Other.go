@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+/*
+File Username:  Disk Free Other.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+// diskFree is not supported on this platform.
+func diskFree(path string) (free uint64, ok bool) {
+	return 0, false
+}
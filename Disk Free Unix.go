@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+/*
+File Username:  Disk Free Unix.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import "golang.org/x/sys/unix"
+
+// diskFree returns the free disk space in bytes available to the user for the drive of the path.
+func diskFree(path string) (free uint64, ok bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, false
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true
+}
@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+/*
+File Username:  Disk Free Windows.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import "golang.org/x/sys/windows"
+
+// diskFree returns the free disk space in bytes available to the user for the drive of the path.
+func diskFree(path string) (free uint64, ok bool) {
+	pathW, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+
+	if err := windows.GetDiskFreeSpaceEx(pathW, &free, nil, nil); err != nil {
+		return 0, false
+	}
+
+	return free, true
+}
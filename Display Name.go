@@ -0,0 +1,215 @@
+/*
+File Username:  Display Name.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Display names (the profile name) are arbitrary and not unique. Anyone can claim the name of another user to impersonate them.
+This local registry keeps track of the names seen for each public key, so that clients can warn the user if multiple keys claim the same name.
+Names are compared in a normalized form that ignores case, whitespace, punctuation and common lookalike characters.
+The registry is kept in memory only and filled from cached blockchains and profiles read via the API.
+*/
+
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// DisplayNameClaim is a display name claimed by a peer.
+type DisplayNameClaim struct {
+	PublicKey *btcec.PublicKey // Public key of the peer.
+	Name      string           // Name as set in the profile.
+	LastSeen  time.Time        // When the name was last seen.
+}
+
+// displayNameExpiry is the time after which claims are forgotten if not seen again.
+const displayNameExpiry = 30 * 24 * time.Hour
+
+// displayNameMaxClaims is the max count of claims kept. If exceeded, the oldest claims are removed.
+const displayNameMaxClaims = 100000
+
+type displayNames struct {
+	byKey  map[[btcec.PubKeyBytesLenCompressed]byte]*displayNameClaim
+	byName map[string]map[[btcec.PubKeyBytesLenCompressed]byte]struct{} // Keys by normalized name
+	sync.Mutex
+}
+
+type displayNameClaim struct {
+	DisplayNameClaim
+	normalized string
+}
+
+func (backend *Backend) initDisplayNames() {
+	backend.displayNames = &displayNames{
+		byKey:  make(map[[btcec.PubKeyBytesLenCompressed]byte]*displayNameClaim),
+		byName: make(map[string]map[[btcec.PubKeyBytesLenCompressed]byte]struct{}),
+	}
+
+	backend.displayNameSelfUpdate()
+}
+
+// displayNameSelfUpdate registers the name of the current user. Other peers claiming the same name are reported as conflicts.
+func (backend *Backend) displayNameSelfUpdate() {
+	name, _ := backend.UserBlockchain.ProfileReadField(blockchain.ProfileName)
+	backend.DisplayNameSeen(backend.PeerPublicKey, string(name))
+}
+
+// DisplayNameSeen records the name claimed by the peer. It replaces any previous claim of the peer. An empty name removes the claim.
+func (backend *Backend) DisplayNameSeen(publicKey *btcec.PublicKey, name string) {
+	var key [btcec.PubKeyBytesLenCompressed]byte
+	copy(key[:], publicKey.SerializeCompressed())
+	normalized := normalizeDisplayName(name)
+
+	names := backend.displayNames
+	names.Lock()
+	defer names.Unlock()
+
+	if existing := names.byKey[key]; existing != nil {
+		if existing.normalized == normalized {
+			existing.Name = name
+			existing.LastSeen = time.Now()
+			return
+		}
+		names.remove(key, existing)
+	}
+
+	if normalized == "" {
+		return
+	}
+
+	if len(names.byKey) >= displayNameMaxClaims {
+		names.prune()
+	}
+
+	names.byKey[key] = &displayNameClaim{DisplayNameClaim: DisplayNameClaim{PublicKey: publicKey, Name: name, LastSeen: time.Now()}, normalized: normalized}
+
+	keys := names.byName[normalized]
+	if keys == nil {
+		keys = make(map[[btcec.PubKeyBytesLenCompressed]byte]struct{})
+		names.byName[normalized] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// DisplayNameClaims returns all peers that claim the name or a lookalike of it, sorted by last seen descending.
+func (backend *Backend) DisplayNameClaims(name string) (claims []DisplayNameClaim) {
+	normalized := normalizeDisplayName(name)
+	if normalized == "" {
+		return nil
+	}
+
+	names := backend.displayNames
+	names.Lock()
+	defer names.Unlock()
+
+	for key := range names.byName[normalized] {
+		claim := names.byKey[key]
+		if time.Since(claim.LastSeen) > displayNameExpiry && !claim.PublicKey.IsEqual(backend.PeerPublicKey) {
+			continue
+		}
+		claims = append(claims, claim.DisplayNameClaim)
+	}
+
+	sort.Slice(claims, func(i, j int) bool { return claims[i].LastSeen.After(claims[j].LastSeen) })
+
+	return claims
+}
+
+// DisplayNameConflict checks if any other peer claims the same name or a lookalike of it. This may indicate an impersonation attempt.
+func (backend *Backend) DisplayNameConflict(publicKey *btcec.PublicKey, name string) (conflict bool) {
+	for _, claim := range backend.DisplayNameClaims(name) {
+		if !claim.PublicKey.IsEqual(publicKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// displayNameSeenBlock records the name if the block contains a profile record setting it.
+func (backend *Backend) displayNameSeenBlock(publicKey *btcec.PublicKey, recordsDecoded []interface{}) {
+	for _, record := range recordsDecoded {
+		if fields, ok := record.([]blockchain.BlockRecordProfile); ok {
+			for _, field := range fields {
+				if field.Type == blockchain.ProfileName {
+					backend.DisplayNameSeen(publicKey, field.Text())
+				}
+			}
+		}
+	}
+}
+
+func (names *displayNames) remove(key [btcec.PubKeyBytesLenCompressed]byte, claim *displayNameClaim) {
+	delete(names.byKey, key)
+
+	if keys := names.byName[claim.normalized]; keys != nil {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(names.byName, claim.normalized)
+		}
+	}
+}
+
+// prune removes expired claims. If none expired, the oldest 10% are removed.
+func (names *displayNames) prune() {
+	type keyClaim struct {
+		key   [btcec.PubKeyBytesLenCompressed]byte
+		claim *displayNameClaim
+	}
+	var list []keyClaim
+
+	for key, claim := range names.byKey {
+		list = append(list, keyClaim{key: key, claim: claim})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].claim.LastSeen.Before(list[j].claim.LastSeen) })
+
+	for n, item := range list {
+		if time.Since(item.claim.LastSeen) <= displayNameExpiry && n >= len(list)/10 {
+			break
+		}
+		names.remove(item.key, item.claim)
+	}
+}
+
+// displayNameLookalikes maps characters that look alike to a common character. Cyrillic and Greek letters are commonly used to spoof Latin names.
+var displayNameLookalikes = map[rune]rune{
+	'0': 'o', '1': 'l', '3': 'e', '5': 's', '7': 't', 'i': 'l', '|': 'l',
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'l', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ո': 'n',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	'ı': 'l', 'ł': 'l', 'ø': 'o', 'ß': 's',
+}
+
+// normalizeDisplayName returns the name in a form that is equal for lookalike names. Fullwidth forms are mapped to ASCII, case is ignored,
+// lookalike characters are mapped to a common character, and anything that is not a letter or digit (whitespace, punctuation, invisible characters) is removed.
+func normalizeDisplayName(name string) string {
+	var builder strings.Builder
+
+	for _, r := range name {
+		if r >= 0xFF01 && r <= 0xFF5E { // fullwidth ASCII
+			r -= 0xFEE0
+		}
+
+		r = unicode.ToLower(r)
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if r != '|' {
+				continue
+			}
+		}
+
+		if mapped, ok := displayNameLookalikes[r]; ok {
+			r = mapped
+		}
+
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,184 @@
+/*
+File Username:  Download Placement.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Placement rules for downloaded files. Incomplete downloads are written to the temporary folder (config setting DownloadTemp) and only moved to
+the target once complete, so that other applications never see partial files:
+* If no target path is specified, the file is placed into the folder DownloadFolder. If DownloadTypeFolders is enabled, it is placed into a
+  subfolder per file type (for example "Pictures") detected from the data and the file name.
+* If the target exists, DownloadCollision defines the policy: "rename" appends " (n)" to the file name, "overwrite" replaces the existing
+  file, and "fail" keeps the existing file and fails the download.
+* The file is moved via rename, which is atomic on the same drive. If the temporary folder is on a different drive, it is copied instead.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PeernetOfficial/core/sanitize"
+)
+
+// Policies for downloads whose target already exists. See config setting DownloadCollision.
+const (
+	DownloadCollisionRename    = "rename"    // Append " (n)" to the file name. Default.
+	DownloadCollisionOverwrite = "overwrite" // Replace the existing file.
+	DownloadCollisionFail      = "fail"      // Keep the existing file and fail the download.
+)
+
+// ErrDownloadTargetExists is returned if the target exists and the collision policy is DownloadCollisionFail.
+var ErrDownloadTargetExists = errors.New("download target already exists")
+
+// downloadTypeFolders are the subfolders of the download folder per File Type.
+var downloadTypeFolders = map[uint16]string{
+	TypeBinary:     "Other",
+	TypeText:       "Text",
+	TypePicture:    "Pictures",
+	TypeVideo:      "Videos",
+	TypeAudio:      "Audio",
+	TypeDocument:   "Documents",
+	TypeExecutable: "Programs",
+	TypeContainer:  "Archives",
+	TypeCompressed: "Archives",
+	TypeFolder:     "Other",
+	TypeEbook:      "Ebooks",
+}
+
+// DownloadTarget returns the target path for a download without a path specified by the caller. The file name is sanitized.
+// TypeFolder indicates whether the file shall be placed into a subfolder per file type when complete, see DownloadPlace.
+func (backend *Backend) DownloadTarget(fileName string) (target string, typeFolder bool, err error) {
+	if backend.DataLayout.DownloadFolder == "" {
+		return "", false, errors.New("no download folder configured")
+	}
+
+	if fileName = sanitize.PathFile(filepath.Base(fileName)); fileName == "" || fileName == "." || fileName == ".." {
+		return "", false, errors.New("invalid file name")
+	}
+
+	return filepath.Join(backend.DataLayout.DownloadFolder, fileName), backend.Config.DownloadTypeFolders, nil
+}
+
+// DownloadCheckTarget checks if the target may be used according to the collision policy.
+func (backend *Backend) DownloadCheckTarget(target string) (err error) {
+	if backend.Config.DownloadCollision != DownloadCollisionFail {
+		return nil
+	} else if _, err := os.Stat(target); err == nil {
+		return ErrDownloadTargetExists
+	}
+
+	return nil
+}
+
+// DownloadTempFile creates the temporary file for an incomplete download. The ID must be unique per download. If no temporary folder
+// is configured, the file is created next to the target.
+func (backend *Backend) DownloadTempFile(id, target string) (file *os.File, err error) {
+	folder := backend.DataLayout.DownloadTemp
+	if folder == "" {
+		folder = filepath.Dir(target)
+	}
+
+	if err = os.MkdirAll(folder, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(filepath.Join(folder, id+".download"), os.O_RDWR|os.O_CREATE, 0666) // 666 : All uses can read/write
+}
+
+// DownloadPlace moves the completed download from the temporary file to the target according to the placement rules. The temporary file must be closed.
+// It returns the final path. In case of error, the temporary file is not deleted.
+func (backend *Backend) DownloadPlace(tempFile, target string, typeFolder bool) (final string, err error) {
+	if typeFolder {
+		// The temporary file has no meaningful name, therefore the file name of the target is used for detection.
+		header := make([]byte, FileTypeHeaderSize)
+		n := 0
+		if file, err := os.Open(tempFile); err == nil {
+			n, _ = file.ReadAt(header, 0)
+			file.Close()
+		}
+
+		fileType, _ := backend.DetectFileType(header[:n], target)
+
+		folder, ok := downloadTypeFolders[fileType]
+		if !ok {
+			folder = downloadTypeFolders[TypeBinary]
+		}
+		target = filepath.Join(filepath.Dir(target), folder, filepath.Base(target))
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		switch backend.Config.DownloadCollision {
+		case DownloadCollisionFail:
+			return "", ErrDownloadTargetExists
+
+		case DownloadCollisionOverwrite:
+			if err = os.Remove(target); err != nil {
+				return "", err
+			}
+
+		default:
+			if target, err = downloadFreeName(target); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err = os.Rename(tempFile, target); err == nil {
+		return target, nil
+	}
+
+	// Rename fails across drives. Copy the file instead.
+	if err = downloadCopyFile(tempFile, target); err != nil {
+		os.Remove(target)
+		return "", err
+	}
+
+	os.Remove(tempFile)
+
+	return target, nil
+}
+
+// downloadFreeName returns the first name "name (n).ext" that does not exist.
+func downloadFreeName(target string) (free string, err error) {
+	extension := filepath.Ext(target)
+	base := strings.TrimSuffix(target, extension)
+
+	for n := 1; n < 10000; n++ {
+		free = fmt.Sprintf("%s (%d)%s", base, n, extension)
+		if _, err := os.Stat(free); os.IsNotExist(err) {
+			return free, nil
+		}
+	}
+
+	return "", ErrDownloadTargetExists
+}
+
+// downloadCopyFile copies the file. The target must not exist.
+func downloadCopyFile(source, target string) (err error) {
+	fileS, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fileS.Close()
+
+	fileT, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(fileT, fileS); err != nil {
+		fileT.Close()
+		return err
+	}
+
+	return fileT.Close()
+}
@@ -0,0 +1,190 @@
+/*
+File Username:  Embedded File Guard.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Protection against abuse of embedded files in responses. Any responder to FIND_VALUE may embed up to EmbeddedFileSizeMax bytes per file, which the
+receiver has to hash. The accepted embedded data is limited per sequence and per peer within a time window; data exceeding the limits is discarded.
+Embedded files whose data does not match the hash are dropped while decoding. Since packets are authenticated, such data cannot be the result of
+corruption in transit. Responders that repeatedly send invalid data are removed from the peer list and all their packets are ignored for a while.
+
+The counters are kept per peer and are available for reputation decisions via EmbeddedFileStats.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// embeddedSequenceMax is the max size of embedded file data accepted via a single sequence.
+const embeddedSequenceMax = 4 * protocol.EmbeddedFileSizeMax
+
+// embeddedPeerMax is the max size of embedded file data accepted from a single peer within embeddedPeerWindow.
+const embeddedPeerMax = 1024 * 1024
+
+// embeddedPeerWindow is the time window for embeddedPeerMax.
+const embeddedPeerWindow = time.Minute
+
+// embeddedFailureThreshold is the count of hash validation failures within embeddedFailureWindow after which the responder is blocked.
+const embeddedFailureThreshold = 3
+
+// embeddedFailureWindow is the time window for counting hash validation failures.
+const embeddedFailureWindow = time.Hour
+
+// embeddedBlockDuration is how long packets from a blocked responder are ignored.
+const embeddedBlockDuration = 24 * time.Hour
+
+// embeddedGuardCleanup is the interval to remove inactive peers.
+const embeddedGuardCleanup = 10 * time.Minute
+
+// EmbeddedFileStats contains the embedded file counters of a responder.
+type EmbeddedFileStats struct {
+	PublicKey    *btcec.PublicKey // Public key of the responder.
+	Accepted     uint64           // Size of accepted embedded file data.
+	Rejected     uint64           // Size of embedded file data discarded because a limit was exceeded.
+	HashFailures uint64           // Count of embedded files with data not matching the hash.
+	Blocks       uint64           // Count of times the responder was blocked.
+	BlockedUntil time.Time        // Until when packets from the responder are ignored. Zero if never blocked.
+	LastActivity time.Time        // Last time embedded file data was received.
+}
+
+type embeddedFileGuard struct {
+	peers   map[[btcec.PubKeyBytesLenCompressed]byte]*embeddedPeerState
+	blocked map[[btcec.PubKeyBytesLenCompressed]byte]time.Time // Blocked responders and when the block ends
+	sync.RWMutex
+}
+
+type embeddedPeerState struct {
+	stats        EmbeddedFileStats
+	windowStart  time.Time // Start of the current embeddedPeerWindow.
+	windowSize   int       // Size accepted within the current window.
+	failureStart time.Time // Start of the current embeddedFailureWindow.
+	failures     int       // Hash validation failures within the current window.
+}
+
+func (backend *Backend) initEmbeddedFileGuard() {
+	backend.embeddedFileGuard = &embeddedFileGuard{
+		peers:   make(map[[btcec.PubKeyBytesLenCompressed]byte]*embeddedPeerState),
+		blocked: make(map[[btcec.PubKeyBytesLenCompressed]byte]time.Time),
+	}
+
+	backend.scheduleTask("embedded-guard-cleanup", embeddedGuardCleanup, embeddedGuardCleanup, func() error {
+		backend.embeddedFileGuard.cleanup()
+		return nil
+	})
+}
+
+// embeddedIsBlocked checks if packets from the peer are ignored.
+func (backend *Backend) embeddedIsBlocked(publicKey *btcec.PublicKey) bool {
+	guard := backend.embeddedFileGuard
+	guard.RLock()
+	defer guard.RUnlock()
+
+	if len(guard.blocked) == 0 {
+		return false
+	}
+
+	until, ok := guard.blocked[publicKey2Compressed(publicKey)]
+	return ok && time.Now().Before(until)
+}
+
+// embeddedAccept checks whether the embedded file data is within the limits of the sequence and the peer, and accounts it.
+func (peer *PeerInfo) embeddedAccept(sequence *protocol.SequenceExpiry, size int) bool {
+	guard := peer.Backend.embeddedFileGuard
+	guard.Lock()
+	defer guard.Unlock()
+
+	state := guard.state(peer.PublicKey)
+	now := time.Now()
+	state.stats.LastActivity = now
+
+	if now.Sub(state.windowStart) >= embeddedPeerWindow {
+		state.windowStart = now
+		state.windowSize = 0
+	}
+
+	if state.windowSize+size > embeddedPeerMax || !sequence.ReserveEmbeddedSize(size, embeddedSequenceMax) {
+		state.stats.Rejected += uint64(size)
+		return false
+	}
+
+	state.windowSize += size
+	state.stats.Accepted += uint64(size)
+	return true
+}
+
+// embeddedHashFailure records embedded files with invalid data sent by the peer. It returns true if the peer is blocked as result.
+func (peer *PeerInfo) embeddedHashFailure(count int) (blocked bool) {
+	guard := peer.Backend.embeddedFileGuard
+	guard.Lock()
+	defer guard.Unlock()
+
+	state := guard.state(peer.PublicKey)
+	now := time.Now()
+	state.stats.LastActivity = now
+	state.stats.HashFailures += uint64(count)
+
+	if now.Sub(state.failureStart) >= embeddedFailureWindow {
+		state.failureStart = now
+		state.failures = 0
+	}
+
+	if state.failures += count; state.failures < embeddedFailureThreshold {
+		return false
+	}
+
+	state.failures = 0
+	state.stats.Blocks++
+	state.stats.BlockedUntil = now.Add(embeddedBlockDuration)
+	guard.blocked[publicKey2Compressed(peer.PublicKey)] = state.stats.BlockedUntil
+
+	return true
+}
+
+// state returns the state of the peer. It is created if it does not exist. The guard must be locked.
+func (guard *embeddedFileGuard) state(publicKey *btcec.PublicKey) (state *embeddedPeerState) {
+	key := publicKey2Compressed(publicKey)
+	if state = guard.peers[key]; state == nil {
+		state = &embeddedPeerState{stats: EmbeddedFileStats{PublicKey: publicKey}}
+		guard.peers[key] = state
+	}
+	return state
+}
+
+// cleanup removes expired blocks and peers without recent activity. Peers with hash failures are kept as long as they are blocked.
+func (guard *embeddedFileGuard) cleanup() {
+	guard.Lock()
+	defer guard.Unlock()
+
+	now := time.Now()
+
+	for key, until := range guard.blocked {
+		if now.After(until) {
+			delete(guard.blocked, key)
+		}
+	}
+
+	for key, state := range guard.peers {
+		if now.Sub(state.stats.LastActivity) >= embeddedFailureWindow && now.After(state.stats.BlockedUntil) {
+			delete(guard.peers, key)
+		}
+	}
+}
+
+// EmbeddedFileStats returns the embedded file counters of recently active and blocked responders.
+func (backend *Backend) EmbeddedFileStats() (stats []EmbeddedFileStats) {
+	guard := backend.embeddedFileGuard
+	guard.RLock()
+	defer guard.RUnlock()
+
+	for _, state := range guard.peers {
+		stats = append(stats, state.stats)
+	}
+
+	return stats
+}
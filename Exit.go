@@ -20,5 +20,6 @@ const (
 	ExitBlockchainCorrupt  = 8          // Blockchain is corrupt.
 	ExitGraceful           = 9          // Graceful shutdown.
 	ExitParamApiKeyInvalid = 10         // API key parameter is invalid.
+	ExitSelfTestFailed     = 11         // Startup self-test failed. The error lists the failures, see SelfTestError.
 	STATUS_CONTROL_C_EXIT  = 0xC000013A // The application terminated as a result of a CTRL+C. This is a Windows NTSTATUS value.
 )
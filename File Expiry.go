@@ -0,0 +1,39 @@
+/*
+File Username:  File Expiry.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Files published with an expiration date (tag TagDateExpires) are temporary shares.
+The owner's node automatically deletes expired files from the blockchain, which creates a new blockchain version that other peers pick up.
+*/
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+)
+
+// fileExpiryCheckInterval is the interval to check the user's blockchain for expired files.
+const fileExpiryCheckInterval = time.Minute
+
+// deleteExpiredFiles deletes expired files from the user's blockchain. The files are deleted from the Warehouse if there are no other references.
+// It is run by the scheduler.
+func (backend *Backend) deleteExpiredFiles() (err error) {
+	_, _, deletedFiles, status := backend.UserBlockchain.DeleteExpiredFiles()
+	if status != blockchain.StatusOK {
+		return fmt.Errorf("deleting expired files status %d", status)
+	} else if backend.UserWarehouse == nil {
+		return nil
+	}
+
+	for n := range deletedFiles {
+		if files, status := backend.UserBlockchain.FileExists(deletedFiles[n].Hash); status == blockchain.StatusOK && len(files) == 0 {
+			backend.UserWarehouse.DeleteFile(deletedFiles[n].Hash)
+		}
+	}
+
+	return nil
+}
@@ -47,4 +47,87 @@ const (
 	FormatAPK                  // APK
 	FormatISO                  // ISO
 	FormatPeernetSearch        // Peernet Search
+	FormatDirectory            // Directory manifest, see warehouse.DirectoryEntry
 )
+
+// fileTypeFormat is a combination of File Type and File Format.
+type fileTypeFormat struct {
+	Type   uint16
+	Format uint16
+}
+
+// fileExtensions maps lowercase file extensions to the File Type and File Format.
+var fileExtensions = map[string]fileTypeFormat{
+	"txt": {TypeText, FormatText}, "log": {TypeText, FormatText}, "ini": {TypeText, FormatText}, "json": {TypeText, FormatText}, "md": {TypeText, FormatText},
+	"csv": {TypeText, FormatCSV}, "tsv": {TypeText, FormatCSV},
+	"html": {TypeText, FormatHTML}, "htm": {TypeText, FormatHTML},
+	"doc": {TypeDocument, FormatWord}, "docx": {TypeDocument, FormatWord}, "rtf": {TypeDocument, FormatWord}, "odt": {TypeDocument, FormatWord},
+	"pdf": {TypeDocument, FormatPDF},
+	"xls": {TypeDocument, FormatExcel}, "xlsx": {TypeDocument, FormatExcel}, "ods": {TypeDocument, FormatExcel},
+	"ppt": {TypeDocument, FormatPowerpoint}, "pptx": {TypeDocument, FormatPowerpoint}, "odp": {TypeDocument, FormatPowerpoint},
+	"gif": {TypePicture, FormatPicture}, "jpg": {TypePicture, FormatPicture}, "jpeg": {TypePicture, FormatPicture}, "png": {TypePicture, FormatPicture}, "svg": {TypePicture, FormatPicture},
+	"bmp": {TypePicture, FormatPicture}, "tif": {TypePicture, FormatPicture}, "tiff": {TypePicture, FormatPicture}, "jfif": {TypePicture, FormatPicture}, "webp": {TypePicture, FormatPicture},
+	"heic": {TypePicture, FormatPicture}, "heif": {TypePicture, FormatPicture},
+	"mp4": {TypeVideo, FormatVideo}, "m4v": {TypeVideo, FormatVideo}, "flv": {TypeVideo, FormatVideo}, "avi": {TypeVideo, FormatVideo}, "mov": {TypeVideo, FormatVideo},
+	"mpg": {TypeVideo, FormatVideo}, "mpeg": {TypeVideo, FormatVideo}, "h264": {TypeVideo, FormatVideo}, "3g2": {TypeVideo, FormatVideo}, "3gp": {TypeVideo, FormatVideo},
+	"mkv": {TypeVideo, FormatVideo}, "wmv": {TypeVideo, FormatVideo}, "webm": {TypeVideo, FormatVideo}, "ts": {TypeVideo, FormatVideo}, "ogv": {TypeVideo, FormatVideo},
+	"mp3": {TypeAudio, FormatAudio}, "ogg": {TypeAudio, FormatAudio}, "oga": {TypeAudio, FormatAudio}, "opus": {TypeAudio, FormatAudio}, "flac": {TypeAudio, FormatAudio},
+	"wav": {TypeAudio, FormatAudio}, "m4a": {TypeAudio, FormatAudio}, "aac": {TypeAudio, FormatAudio}, "wma": {TypeAudio, FormatAudio},
+	"zip": {TypeContainer, FormatContainer}, "rar": {TypeContainer, FormatContainer}, "7z": {TypeContainer, FormatContainer}, "tar": {TypeContainer, FormatContainer},
+	"epub": {TypeEbook, FormatEbook}, "mobi": {TypeEbook, FormatEbook}, "prc": {TypeEbook, FormatEbook}, "azw": {TypeEbook, FormatEbook}, "azw3": {TypeEbook, FormatEbook},
+	"gz": {TypeCompressed, FormatCompressed}, "tgz": {TypeCompressed, FormatCompressed}, "bz": {TypeCompressed, FormatCompressed}, "bz2": {TypeCompressed, FormatCompressed}, "xz": {TypeCompressed, FormatCompressed},
+	"sql": {TypeText, FormatDatabase}, "sqlite": {TypeBinary, FormatDatabase}, "db": {TypeBinary, FormatDatabase},
+	"eml": {TypeText, FormatEmail}, "mbox": {TypeText, FormatEmail},
+	"exe": {TypeExecutable, FormatExecutable}, "sys": {TypeExecutable, FormatExecutable}, "dll": {TypeExecutable, FormatExecutable}, "cmd": {TypeExecutable, FormatExecutable}, "bat": {TypeExecutable, FormatExecutable},
+	"msi": {TypeExecutable, FormatInstaller}, "apk": {TypeExecutable, FormatAPK}, "iso": {TypeContainer, FormatISO}, "pnsearch": {TypeText, FormatPeernetSearch},
+}
+
+// fileSignatures are the magic bytes of file formats. More specific signatures come first.
+// Files with one of the listed extensions are classified by the extension, for example a ZIP file with the extension docx is a Word document.
+// The listed extensions are only trusted if the file data matches the signature.
+var fileSignatures = []fileSignature{
+	{[]fileMagic{{0, "%PDF-"}}, fileTypeFormat{TypeDocument, FormatPDF}, []string{"pdf"}},
+	{[]fileMagic{{0, "\x89PNG\r\n\x1a\n"}}, fileTypeFormat{TypePicture, FormatPicture}, []string{"png"}},
+	{[]fileMagic{{0, "\xff\xd8\xff"}}, fileTypeFormat{TypePicture, FormatPicture}, []string{"jpg", "jpeg", "jfif"}},
+	{[]fileMagic{{0, "GIF87a"}}, fileTypeFormat{TypePicture, FormatPicture}, []string{"gif"}},
+	{[]fileMagic{{0, "GIF89a"}}, fileTypeFormat{TypePicture, FormatPicture}, []string{"gif"}},
+	{[]fileMagic{{0, "II*\x00"}}, fileTypeFormat{TypePicture, FormatPicture}, []string{"tif", "tiff"}},
+	{[]fileMagic{{0, "MM\x00*"}}, fileTypeFormat{TypePicture, FormatPicture}, []string{"tif", "tiff"}},
+	{[]fileMagic{{0, "RIFF"}, {8, "WEBP"}}, fileTypeFormat{TypePicture, FormatPicture}, []string{"webp"}},
+	{[]fileMagic{{0, "RIFF"}, {8, "WAVE"}}, fileTypeFormat{TypeAudio, FormatAudio}, []string{"wav"}},
+	{[]fileMagic{{0, "RIFF"}, {8, "AVI "}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"avi"}},
+	{[]fileMagic{{0, "PK\x03\x04"}, {30, "mimetypeapplication/epub+zip"}}, fileTypeFormat{TypeEbook, FormatEbook}, []string{"epub"}},
+	{[]fileMagic{{0, "PK\x03\x04"}, {30, "mimetypeapplication/vnd.oasis.opendocument.text"}}, fileTypeFormat{TypeDocument, FormatWord}, []string{"odt"}},
+	{[]fileMagic{{0, "PK\x03\x04"}, {30, "mimetypeapplication/vnd.oasis.opendocument.spreadsheet"}}, fileTypeFormat{TypeDocument, FormatExcel}, []string{"ods"}},
+	{[]fileMagic{{0, "PK\x03\x04"}, {30, "mimetypeapplication/vnd.oasis.opendocument.presentation"}}, fileTypeFormat{TypeDocument, FormatPowerpoint}, []string{"odp"}},
+	{[]fileMagic{{0, "PK\x03\x04"}}, fileTypeFormat{TypeContainer, FormatContainer}, []string{"zip", "docx", "xlsx", "pptx", "epub", "odt", "ods", "odp", "apk"}},
+	{[]fileMagic{{0, "PK\x05\x06"}}, fileTypeFormat{TypeContainer, FormatContainer}, []string{"zip"}},
+	{[]fileMagic{{0, "Rar!\x1a\x07"}}, fileTypeFormat{TypeContainer, FormatContainer}, []string{"rar"}},
+	{[]fileMagic{{0, "7z\xbc\xaf\x27\x1c"}}, fileTypeFormat{TypeContainer, FormatContainer}, []string{"7z"}},
+	{[]fileMagic{{257, "ustar"}}, fileTypeFormat{TypeContainer, FormatContainer}, []string{"tar"}},
+	{[]fileMagic{{0x8001, "CD001"}}, fileTypeFormat{TypeContainer, FormatISO}, []string{"iso"}},
+	{[]fileMagic{{0, "\x1f\x8b"}}, fileTypeFormat{TypeCompressed, FormatCompressed}, []string{"gz", "tgz"}},
+	{[]fileMagic{{0, "BZh"}}, fileTypeFormat{TypeCompressed, FormatCompressed}, []string{"bz", "bz2"}},
+	{[]fileMagic{{0, "\xfd7zXZ\x00"}}, fileTypeFormat{TypeCompressed, FormatCompressed}, []string{"xz"}},
+	{[]fileMagic{{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"}}, fileTypeFormat{TypeDocument, FormatWord}, []string{"doc", "xls", "ppt", "msi"}},
+	{[]fileMagic{{0, "{\\rtf"}}, fileTypeFormat{TypeDocument, FormatWord}, []string{"rtf"}},
+	{[]fileMagic{{0, "MZ"}}, fileTypeFormat{TypeExecutable, FormatExecutable}, []string{"exe", "dll", "sys"}},
+	{[]fileMagic{{0, "\x7fELF"}}, fileTypeFormat{TypeExecutable, FormatExecutable}, nil},
+	{[]fileMagic{{0, "\xcf\xfa\xed\xfe"}}, fileTypeFormat{TypeExecutable, FormatExecutable}, nil},
+	{[]fileMagic{{0, "\xce\xfa\xed\xfe"}}, fileTypeFormat{TypeExecutable, FormatExecutable}, nil},
+	{[]fileMagic{{0, "ID3"}}, fileTypeFormat{TypeAudio, FormatAudio}, []string{"mp3"}},
+	{[]fileMagic{{0, "\xff\xfb"}}, fileTypeFormat{TypeAudio, FormatAudio}, []string{"mp3"}},
+	{[]fileMagic{{0, "\xff\xf3"}}, fileTypeFormat{TypeAudio, FormatAudio}, []string{"mp3"}},
+	{[]fileMagic{{0, "\xff\xf1"}}, fileTypeFormat{TypeAudio, FormatAudio}, []string{"aac"}},
+	{[]fileMagic{{0, "OggS"}}, fileTypeFormat{TypeAudio, FormatAudio}, []string{"ogg", "oga", "opus", "ogv"}},
+	{[]fileMagic{{0, "fLaC"}}, fileTypeFormat{TypeAudio, FormatAudio}, []string{"flac"}},
+	{[]fileMagic{{4, "ftyp"}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"mp4", "m4v", "mov", "3gp", "3g2", "m4a", "heic", "heif"}},
+	{[]fileMagic{{0, "\x1a\x45\xdf\xa3"}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"mkv", "webm"}},
+	{[]fileMagic{{0, "FLV"}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"flv"}},
+	{[]fileMagic{{0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11"}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"wmv", "wma"}},
+	{[]fileMagic{{0, "\x00\x00\x01\xba"}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"mpg", "mpeg"}},
+	{[]fileMagic{{0, "\x00\x00\x01\xb3"}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"mpg", "mpeg"}},
+	{[]fileMagic{{0, "\x47"}, {188, "\x47"}}, fileTypeFormat{TypeVideo, FormatVideo}, []string{"ts"}},
+	{[]fileMagic{{0, "SQLite format 3\x00"}}, fileTypeFormat{TypeBinary, FormatDatabase}, []string{"sqlite", "db"}},
+	{[]fileMagic{{60, "BOOKMOBI"}}, fileTypeFormat{TypeEbook, FormatEbook}, []string{"mobi", "prc", "azw", "azw3"}},
+}
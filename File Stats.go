@@ -0,0 +1,122 @@
+/*
+File Username:  File Stats.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Per-file access statistics for files stored in the user's Warehouse or the local DHT store. They give publishers feedback like download counts.
+Statistics are kept in memory only and reset when the process restarts.
+*/
+
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// FileAccessStats contains access statistics of a single file.
+type FileAccessStats struct {
+	Hash               []byte    // Hash of the file.
+	FindValueHits      uint64    // Count of FIND_VALUE requests for the hash.
+	TransferStarts     uint64    // Count of transfers started.
+	TransfersCompleted uint64    // Count of transfers that served the entire requested range.
+	BytesServed        uint64    // Total bytes served via transfers.
+	LastAccess         time.Time // Last time the file was requested.
+}
+
+// fileStats keeps track of access statistics per file hash.
+type fileStats struct {
+	files map[[protocol.HashSize]byte]*FileAccessStats
+	sync.Mutex
+}
+
+func (backend *Backend) initFileStats() {
+	backend.fileStats = &fileStats{files: make(map[[protocol.HashSize]byte]*FileAccessStats)}
+}
+
+// fileStatsGet returns the statistics for the hash. They are created if necessary.
+func (backend *Backend) fileStatsGet(hash []byte) (stats *FileAccessStats) {
+	if len(hash) != protocol.HashSize {
+		return nil
+	}
+
+	var key [protocol.HashSize]byte
+	copy(key[:], hash)
+
+	backend.fileStats.Lock()
+	defer backend.fileStats.Unlock()
+
+	if stats = backend.fileStats.files[key]; stats == nil {
+		stats = &FileAccessStats{Hash: key[:]}
+		backend.fileStats.files[key] = stats
+	}
+	stats.LastAccess = time.Now()
+
+	return stats
+}
+
+// fileStatsFindValue records a FIND_VALUE request. Only hashes that are stored locally are counted.
+func (backend *Backend) fileStatsFindValue(hash []byte, stored bool) {
+	if !stored {
+		if backend.UserWarehouse == nil {
+			return
+		} else if _, _, status, _ := backend.UserWarehouse.FileExists(hash); status != warehouse.StatusOK {
+			return
+		}
+	}
+
+	if stats := backend.fileStatsGet(hash); stats != nil {
+		atomic.AddUint64(&stats.FindValueHits, 1)
+	}
+}
+
+// fileStatsTransferStart records the start of a file transfer.
+func (backend *Backend) fileStatsTransferStart(hash []byte) {
+	if stats := backend.fileStatsGet(hash); stats != nil {
+		atomic.AddUint64(&stats.TransferStarts, 1)
+	}
+}
+
+// fileStatsTransferEnd records the bytes served by a file transfer.
+func (backend *Backend) fileStatsTransferEnd(hash []byte, bytesServed uint64, completed bool) {
+	stats := backend.fileStatsGet(hash)
+	if stats == nil {
+		return
+	}
+
+	atomic.AddUint64(&stats.BytesServed, bytesServed)
+	if completed {
+		atomic.AddUint64(&stats.TransfersCompleted, 1)
+	}
+}
+
+// FileStats returns the access statistics of a file. Found is false if the file was not requested since the start.
+func (backend *Backend) FileStats(hash []byte) (stats FileAccessStats, found bool) {
+	if len(hash) != protocol.HashSize {
+		return stats, false
+	}
+
+	var key [protocol.HashSize]byte
+	copy(key[:], hash)
+
+	backend.fileStats.Lock()
+	defer backend.fileStats.Unlock()
+
+	existing := backend.fileStats.files[key]
+	if existing == nil {
+		return stats, false
+	}
+
+	return FileAccessStats{
+		Hash:               existing.Hash,
+		FindValueHits:      atomic.LoadUint64(&existing.FindValueHits),
+		TransferStarts:     atomic.LoadUint64(&existing.TransferStarts),
+		TransfersCompleted: atomic.LoadUint64(&existing.TransfersCompleted),
+		BytesServed:        atomic.LoadUint64(&existing.BytesServed),
+		LastAccess:         existing.LastAccess,
+	}, true
+}
@@ -0,0 +1,193 @@
+/*
+File Username:  File Type Detection.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Detection of the File Type and File Format of files based on the file data (magic bytes) and the file extension. The data takes precedence:
+An extension is only trusted for formats without signature, or if the data matches the signature of the format. Files without known signature
+are classified as text or binary based on the data. The config setting FileTypes adds rules for extensions and signatures not covered by default.
+Custom rules take precedence over the default ones and custom extensions are trusted regardless of the data.
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// FileTypeHeaderSize is the count of bytes at the beginning of a file used for detection. It covers the ISO 9660 volume descriptor.
+const FileTypeHeaderSize = 0x8006
+
+// FileTypeRule is a custom rule from the config to detect the File Type and File Format.
+// If Signature is set, the rule matches files with the hex encoded signature at the offset (and the extension, if set). Otherwise it maps the extension.
+type FileTypeRule struct {
+	Extension string `yaml:"Extension"` // File extension without dot, case insensitive.
+	Signature string `yaml:"Signature"` // Hex encoded magic bytes. Optional.
+	Offset    int    `yaml:"Offset"`    // Offset of the signature.
+	Type      uint16 `yaml:"Type"`      // File Type, see TypeX.
+	Format    uint16 `yaml:"Format"`    // File Format, see FormatX.
+}
+
+type fileMagic struct {
+	offset int
+	magic  string
+}
+
+type fileSignature struct {
+	magic      []fileMagic    // All must match.
+	result     fileTypeFormat // Type and format of files matching the signature.
+	extensions []string       // Extensions that refine the result.
+}
+
+func (signature *fileSignature) match(header []byte) bool {
+	for _, magic := range signature.magic {
+		if len(header) < magic.offset+len(magic.magic) || string(header[magic.offset:magic.offset+len(magic.magic)]) != magic.magic {
+			return false
+		}
+	}
+	return true
+}
+
+// fileSignatureExtensions contains all extensions listed in fileSignatures. They are only trusted if the signature matches.
+var fileSignatureExtensions = func() (extensions map[string]struct{}) {
+	extensions = make(map[string]struct{})
+	for _, signature := range fileSignatures {
+		for _, extension := range signature.extensions {
+			extensions[extension] = struct{}{}
+		}
+	}
+	return extensions
+}()
+
+// FileExtension returns the lowercase file extension of the filename without dot. It is empty if there is none.
+func FileExtension(filename string) (extension string) {
+	_, filename = path.Split(strings.ReplaceAll(filename, "\\", "/"))
+	if index := strings.LastIndexByte(filename, '.'); index > 0 {
+		return strings.ToLower(filename[index+1:])
+	}
+	return ""
+}
+
+// FileTypeFromExtension translates the extension to the File Type and File Format using the default mapping. It does not check the file data.
+func FileTypeFromExtension(extension string) (fileType, fileFormat uint16, valid bool) {
+	result, valid := fileExtensions[strings.ToLower(extension)]
+	return result.Type, result.Format, valid
+}
+
+// DetectFileType detects the File Type and File Format based on the header (the first FileTypeHeaderSize bytes of the file data) and the filename.
+// The filename is optional. Custom rules from the config take precedence over the default ones.
+func (backend *Backend) DetectFileType(header []byte, filename string) (fileType, fileFormat uint16) {
+	extension := FileExtension(filename)
+
+	// custom signatures
+	for _, rule := range backend.Config.FileTypes {
+		if rule.Signature == "" || (rule.Extension != "" && !strings.EqualFold(rule.Extension, extension)) {
+			continue
+		}
+		if magic, err := hex.DecodeString(rule.Signature); err == nil && len(magic) > 0 && rule.Offset >= 0 && len(header) >= rule.Offset+len(magic) && bytes.Equal(header[rule.Offset:rule.Offset+len(magic)], magic) {
+			return rule.Type, rule.Format
+		}
+	}
+
+	// custom extensions, for example to classify ZIP files with a specific extension
+	if custom, ok := backend.fileTypeCustomExtension(extension); ok {
+		return custom.Type, custom.Format
+	}
+
+	for n := range fileSignatures {
+		if !fileSignatures[n].match(header) {
+			continue
+		}
+
+		for _, refine := range fileSignatures[n].extensions {
+			if refine == extension {
+				result := fileExtensions[extension]
+				return result.Type, result.Format
+			}
+		}
+
+		return fileSignatures[n].result.Type, fileSignatures[n].result.Format
+	}
+
+	// No known signature. The extension is trusted unless the format has a signature which did not match.
+	if _, signatureRequired := fileSignatureExtensions[extension]; !signatureRequired {
+		if result, ok := fileExtensions[extension]; ok && (len(header) == 0 || result.Type != TypeText || isTextData(header)) {
+			return result.Type, result.Format
+		}
+	}
+
+	if len(header) > 0 && isTextData(header) {
+		if strings.HasPrefix(http.DetectContentType(header), "text/html") {
+			return TypeText, FormatHTML
+		}
+		return TypeText, FormatText
+	}
+
+	return TypeBinary, FormatBinary
+}
+
+// fileTypeCustomExtension returns the custom extension mapping from the config, if any.
+func (backend *Backend) fileTypeCustomExtension(extension string) (result fileTypeFormat, valid bool) {
+	if extension == "" {
+		return result, false
+	}
+
+	for _, rule := range backend.Config.FileTypes {
+		if rule.Signature == "" && strings.EqualFold(rule.Extension, extension) {
+			return fileTypeFormat{rule.Type, rule.Format}, true
+		}
+	}
+
+	return result, false
+}
+
+// isTextData checks if the data is likely text. It must be valid UTF-8 (except a character cut off at the end) without control characters other than whitespace.
+func isTextData(data []byte) bool {
+	if len(data) > 512 {
+		data = data[:512]
+	}
+
+	for len(data) > 0 {
+		char, size := utf8.DecodeRune(data)
+		if char == utf8.RuneError && size <= 1 {
+			return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+		} else if char < 0x20 && char != '\t' && char != '\n' && char != '\r' && char != '\f' {
+			return false
+		}
+		data = data[size:]
+	}
+
+	return true
+}
+
+// DetectFileTypeFile detects the File Type and File Format of a file on disk.
+func (backend *Backend) DetectFileTypeFile(filePath string) (fileType, fileFormat uint16, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return TypeBinary, FormatBinary, err
+	}
+	defer file.Close()
+
+	header := make([]byte, FileTypeHeaderSize)
+	n, _ := file.ReadAt(header, 0)
+
+	fileType, fileFormat = backend.DetectFileType(header[:n], filePath)
+	return fileType, fileFormat, nil
+}
+
+// DetectFileTypeWarehouse detects the File Type and File Format of a file stored in the user's warehouse. The filename is optional.
+func (backend *Backend) DetectFileTypeWarehouse(hash []byte, filename string) (fileType, fileFormat uint16, err error) {
+	var header bytes.Buffer
+	if _, _, err = backend.UserWarehouse.ReadFile(hash, 0, FileTypeHeaderSize, &header); err != nil {
+		return TypeBinary, FormatBinary, err
+	}
+
+	fileType, fileFormat = backend.DetectFileType(header.Bytes(), filename)
+	return fileType, fileFormat, nil
+}
@@ -47,7 +47,7 @@ type Filters struct {
 	// IPv4 broadcast, IPv6 multicast, and Traverse messages are not covered.
 	PacketOut func(packet *protocol.PacketRaw, receiverPublicKey *btcec.PublicKey, connection *Connection)
 
-	// MessageIn is a high-level filter for decoded incoming messages. message is of type nil, MessageAnnouncement, MessageResponse, or MessageTraverse
+	// MessageIn is a high-level filter for decoded incoming messages. message is of type nil, MessageAnnouncement, MessageResponse, MessagePong, MessageTraverse, MessageAdmin, MessageLookupRelay, MessageOnion, MessageContentSummary, or MessageNATProbe
 	MessageIn func(peer *PeerInfo, raw *protocol.MessageRaw, message interface{})
 
 	// MessageOutAnnouncement is a high-level filter for outgoing announcements. Peer is nil on first contact.
@@ -66,6 +66,13 @@ type Filters struct {
 	// MessageOutPong is a high-level filter for outgoing pongs.
 	MessageOutPong func(peer *PeerInfo, packet *protocol.PacketRaw)
 
+	// PeerOnline is called when a watched peer comes online, see WatchPeer. It is called after NewPeer.
+	PeerOnline func(peer *PeerInfo)
+
+	// WarehouseCorrupt is called when the warehouse scrubber finds a corrupt file, see WarehouseScrub. Path is the file in the quarantine folder.
+	// Refetched indicates whether the file was downloaded again from other peers.
+	WarehouseCorrupt func(hash []byte, path string, refetched bool)
+
 	// Called when the statistics change of a single blockchain in the cache. Must be set on init.
 	GlobalBlockchainCacheStatistic func(multi *blockchain.MultiStore, header *blockchain.MultiBlockchainHeader, statsOld blockchain.BlockchainStats)
 
@@ -119,6 +126,12 @@ func (backend *Backend) initFilters() {
 	if backend.Filters.MessageOutPong == nil {
 		backend.Filters.MessageOutPong = func(peer *PeerInfo, packet *protocol.PacketRaw) {}
 	}
+	if backend.Filters.PeerOnline == nil {
+		backend.Filters.PeerOnline = func(peer *PeerInfo) {}
+	}
+	if backend.Filters.WarehouseCorrupt == nil {
+		backend.Filters.WarehouseCorrupt = func(hash []byte, path string, refetched bool) {}
+	}
 }
 
 // MultiWriter code that allows to subscribe/unsubscribe.
@@ -0,0 +1,530 @@
+/*
+File Username:  Folder Sync.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Folder sync keeps a local folder in sync with trusted peers in both directions. Both peers must configure the folder with the same name and list each other.
+A sync session exchanges the manifests (hash, size, modification time, path) of both sides via the stream service "sync/1". Each side then computes the diff and downloads changed files from the other side.
+Files are added to the warehouse when the manifest is created, and downloaded via regular file transfers.
+
+Each folder stores its state in the file ".peernet-sync" in the folder root: a cache of file hashes, and the hash of each file at the last sync with each peer.
+The manifest includes the last synced hash of each file as known by the sender, and files deleted since the last sync.
+Based on the last synced hash, each side detects whether a file was changed or deleted locally or remotely. If both sides changed a file, the newer version wins and
+the older one is kept as conflict copy. Conflict copies are synced like any other file.
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// SyncFolderConfig is a folder to sync with trusted peers.
+type SyncFolderConfig struct {
+	Name  string   `yaml:"Name"`  // Name identifying the folder between peers. Max 255 bytes.
+	Path  string   `yaml:"Path"`  // Local directory.
+	Peers []string `yaml:"Peers"` // Peer IDs (hex encoded) of trusted peers to sync with.
+}
+
+// syncStreamService is the name of the stream service for folder sync.
+const syncStreamService = "sync/1"
+
+// syncStateFilename is the name of the file in the folder root storing the sync state. It and any temporary files starting with the same name are excluded from syncing.
+const syncStateFilename = ".peernet-sync"
+
+// syncInterval is the interval to sync each folder with all connected peers.
+const syncInterval = 5 * time.Minute
+
+// syncStreamTimeout is the timeout for opening the stream and exchanging manifests.
+const syncStreamTimeout = 30 * time.Second
+
+// Errors for folder sync
+var (
+	ErrSyncFolderNotFound = errors.New("sync folder not found")
+	ErrSyncNotAvailable   = errors.New("folder not shared by the remote peer")
+	ErrSyncBusy           = errors.New("folder is currently syncing")
+)
+
+// SyncResult is the result of a sync session.
+type SyncResult struct {
+	Downloaded int // Count of files downloaded from the remote peer.
+	Deleted    int // Count of local files deleted because they were deleted by the remote peer.
+	Conflicts  int // Count of conflict copies created.
+	Failed     int // Count of files that could not be downloaded.
+	Deferred   int // Count of files not downloaded in metadata-only mode. They are synced once fetched via FetchFile.
+}
+
+// syncFolder is the runtime state of a sync folder.
+type syncFolder struct {
+	config *SyncFolderConfig
+	sync.Mutex
+}
+
+// syncState is stored in the state file.
+type syncState struct {
+	Files  map[string]*syncStateFile    `json:"files"`  // Hash cache of local files by path.
+	Synced map[string]map[string]string `json:"synced"` // Hash (hex encoded) of each file at the last sync by peer ID and path.
+}
+
+type syncStateFile struct {
+	Hash     []byte `json:"hash"`
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"` // Unix time in nanoseconds
+}
+
+func (backend *Backend) initFolderSync() {
+	backend.syncFolders = make(map[string]*syncFolder)
+
+	// Syncing requires the warehouse which is disabled for observers.
+	if backend.Config.Observer {
+		if len(backend.Config.SyncFolders) > 0 {
+			backend.LogError("initFolderSync", "sync folders are ignored in observer mode\n")
+		}
+		return
+	}
+
+	for n := range backend.Config.SyncFolders {
+		config := &backend.Config.SyncFolders[n]
+		if config.Name == "" || len(config.Name) > 255 || config.Path == "" {
+			backend.LogError("initFolderSync", "invalid sync folder '%s'\n", config.Name)
+			continue
+		}
+
+		backend.syncFolders[config.Name] = &syncFolder{config: config}
+	}
+
+	if len(backend.syncFolders) > 0 {
+		backend.RegisterStreamService(syncStreamService, backend.syncStreamHandler)
+	}
+}
+
+// scheduleFolderSync syncs all folders regularly with all connected peers.
+func (backend *Backend) scheduleFolderSync() {
+	for _, folder := range backend.syncFolders {
+		folder := folder
+
+		backend.scheduleTask("folder-sync "+folder.config.Name, time.Minute, syncInterval, func() error {
+			for _, peerID := range folder.config.Peers {
+				publicKey, err := PublicKeyFromPeerID(peerID)
+				if err != nil {
+					continue
+				}
+
+				peer := backend.PeerlistLookup(publicKey)
+				if peer == nil {
+					continue
+				}
+
+				if _, err := backend.SyncFolder(folder.config.Name, peer); err != nil && err != ErrSyncBusy {
+					backend.LogError("scheduleFolderSync", "syncing folder '%s' with peer %s: %v\n", folder.config.Name, peerID, err)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// isTrusted checks if the peer is allowed to sync the folder.
+func (folder *syncFolder) isTrusted(peer *PeerInfo) bool {
+	peerID := hex.EncodeToString(peer.PublicKey.SerializeCompressed())
+
+	for _, trusted := range folder.config.Peers {
+		if strings.EqualFold(trusted, peerID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SyncFolder syncs the folder with the remote peer. Only changes in the local folder are made; the remote peer downloads its changes independently.
+// It returns ErrSyncBusy if the folder is already syncing.
+func (backend *Backend) SyncFolder(name string, peer *PeerInfo) (result SyncResult, err error) {
+	folder := backend.syncFolders[name]
+	if folder == nil || !folder.isTrusted(peer) {
+		return result, ErrSyncFolderNotFound
+	}
+
+	if !folder.TryLock() {
+		return result, ErrSyncBusy
+	}
+	defer folder.Unlock()
+
+	state := folder.loadState()
+
+	local, err := backend.syncManifest(folder, state)
+	if err != nil {
+		return result, err
+	}
+
+	// exchange the manifests
+	conn, err := peer.OpenStream(syncStreamService, syncStreamTimeout)
+	if err != nil {
+		return result, err
+	}
+
+	if err = protocol.SyncMessageWrite(conn, &protocol.SyncMessage{Type: protocol.SyncRequest, Folder: name, Files: state.manifestFor(peer, local)}); err != nil {
+		conn.Close()
+		return result, err
+	}
+
+	response, err := protocol.SyncMessageRead(conn)
+	conn.Close()
+	if err != nil {
+		return result, err
+	}
+
+	switch response.Type {
+	case protocol.SyncResponse:
+	case protocol.SyncBusy:
+		return result, ErrSyncBusy
+	default:
+		return result, ErrSyncNotAvailable
+	}
+
+	result = backend.syncApply(folder, state, peer, local, response.Files)
+
+	return result, folder.saveState(state)
+}
+
+// syncStreamHandler answers an incoming sync request with the local manifest and then applies the changes from the remote peer.
+func (backend *Backend) syncStreamHandler(peer *PeerInfo, conn *udt.UDTSocket) {
+	request, err := protocol.SyncMessageRead(conn)
+	if err != nil || request.Type != protocol.SyncRequest {
+		return
+	}
+
+	folder := backend.syncFolders[request.Folder]
+	if folder == nil || !folder.isTrusted(peer) {
+		protocol.SyncMessageWrite(conn, &protocol.SyncMessage{Type: protocol.SyncNotAvailable, Folder: request.Folder})
+		return
+	}
+
+	// If the folder is syncing, possibly with the same peer initiating at the same time, the requester tries again later.
+	if !folder.TryLock() {
+		protocol.SyncMessageWrite(conn, &protocol.SyncMessage{Type: protocol.SyncBusy, Folder: request.Folder})
+		return
+	}
+
+	state := folder.loadState()
+
+	local, err := backend.syncManifest(folder, state)
+	if err != nil {
+		folder.Unlock()
+		backend.LogError("syncStreamHandler", "creating manifest of folder '%s': %v\n", request.Folder, err)
+		protocol.SyncMessageWrite(conn, &protocol.SyncMessage{Type: protocol.SyncBusy, Folder: request.Folder})
+		return
+	}
+
+	if err = protocol.SyncMessageWrite(conn, &protocol.SyncMessage{Type: protocol.SyncResponse, Folder: request.Folder, Files: state.manifestFor(peer, local)}); err != nil {
+		folder.Unlock()
+		return
+	}
+
+	// Downloading may take a while. The stream is no longer needed.
+	go func() {
+		defer folder.Unlock()
+
+		backend.syncApply(folder, state, peer, local, request.Files)
+
+		if err := folder.saveState(state); err != nil {
+			backend.LogError("syncStreamHandler", "saving state of folder '%s': %v\n", request.Folder, err)
+		}
+	}()
+}
+
+func (folder *syncFolder) loadState() (state *syncState) {
+	state = &syncState{}
+
+	if data, err := os.ReadFile(filepath.Join(folder.config.Path, syncStateFilename)); err == nil {
+		json.Unmarshal(data, state)
+	}
+
+	if state.Files == nil {
+		state.Files = make(map[string]*syncStateFile)
+	}
+	if state.Synced == nil {
+		state.Synced = make(map[string]map[string]string)
+	}
+
+	return state
+}
+
+func (folder *syncFolder) saveState(state *syncState) (err error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first to not corrupt the state
+	target := filepath.Join(folder.config.Path, syncStateFilename)
+	if err = os.WriteFile(target+".tmp", data, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(target+".tmp", target)
+}
+
+// syncManifest lists all files in the folder. New and changed files are added to the warehouse.
+func (backend *Backend) syncManifest(folder *syncFolder, state *syncState) (files []protocol.SyncFile, err error) {
+	if err = os.MkdirAll(folder.config.Path, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]struct{})
+
+	err = filepath.WalkDir(folder.config.Path, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), syncStateFilename) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		relative, err := filepath.Rel(folder.config.Path, fullPath)
+		if err != nil {
+			return nil
+		}
+		relative = filepath.ToSlash(relative)
+
+		// Only hash the file if it changed since the last time.
+		cached := state.Files[relative]
+		if cached == nil || cached.Size != info.Size() || cached.Modified != info.ModTime().UnixNano() || !backend.warehouseHasFile(cached.Hash) {
+			hash, status, err := backend.UserWarehouse.CreateFileFromPath(fullPath)
+			if status != warehouse.StatusOK {
+				backend.LogError("syncManifest", "adding file '%s' to the warehouse status %d: %v\n", fullPath, status, err)
+				return nil
+			}
+
+			cached = &syncStateFile{Hash: hash, Size: info.Size(), Modified: info.ModTime().UnixNano()}
+			state.Files[relative] = cached
+		}
+
+		existing[relative] = struct{}{}
+		files = append(files, protocol.SyncFile{Hash: cached.Hash, Size: uint64(cached.Size), Modified: time.Unix(0, cached.Modified), Path: relative})
+
+		return nil
+	})
+
+	// remove deleted files from the cache
+	for relative := range state.Files {
+		if _, ok := existing[relative]; !ok {
+			delete(state.Files, relative)
+		}
+	}
+
+	return files, err
+}
+
+// isValidSyncPath checks if the path provided by the remote peer is relative and stays within the folder.
+func isValidSyncPath(relative string) bool {
+	return relative != "" && relative != "." && !strings.Contains(relative, "\\") && path.Clean(relative) == relative && !path.IsAbs(relative) &&
+		relative != ".." && !strings.HasPrefix(relative, "../") && !strings.HasPrefix(path.Base(relative), syncStateFilename)
+}
+
+// manifestFor returns the manifest for the peer. It adds the hashes at the last sync with the peer, and files deleted since then.
+func (state *syncState) manifestFor(peer *PeerInfo, local []protocol.SyncFile) (files []protocol.SyncFile) {
+	synced := state.Synced[hex.EncodeToString(peer.PublicKey.SerializeCompressed())]
+	existing := make(map[string]struct{})
+
+	for _, file := range local {
+		if base, err := hex.DecodeString(synced[file.Path]); err == nil && len(base) == protocol.HashSize {
+			file.Base = base
+		}
+		existing[file.Path] = struct{}{}
+		files = append(files, file)
+	}
+
+	for relative, last := range synced {
+		if _, ok := existing[relative]; ok {
+			continue
+		}
+		if base, err := hex.DecodeString(last); err == nil && len(base) == protocol.HashSize {
+			files = append(files, protocol.SyncFile{Base: base, Path: relative})
+		}
+	}
+
+	return files
+}
+
+// syncApply downloads, deletes, and renames local files based on the diff between the local and remote manifest.
+// A file was changed on a side if its hash differs from the hash at the last sync. Both sides record the last synced hash; either record is used.
+func (backend *Backend) syncApply(folder *syncFolder, state *syncState, peer *PeerInfo, localFiles, remoteFiles []protocol.SyncFile) (result SyncResult) {
+	peerID := hex.EncodeToString(peer.PublicKey.SerializeCompressed())
+
+	synced := state.Synced[peerID]
+	if synced == nil {
+		synced = make(map[string]string)
+		state.Synced[peerID] = synced
+	}
+
+	local := make(map[string]*protocol.SyncFile)
+	remote := make(map[string]*protocol.SyncFile)
+	paths := make(map[string]struct{})
+
+	for n := range localFiles {
+		local[localFiles[n].Path] = &localFiles[n]
+		paths[localFiles[n].Path] = struct{}{}
+	}
+	for n := range remoteFiles {
+		if isValidSyncPath(remoteFiles[n].Path) {
+			remote[remoteFiles[n].Path] = &remoteFiles[n]
+			paths[remoteFiles[n].Path] = struct{}{}
+		}
+	}
+	for relative := range synced {
+		paths[relative] = struct{}{}
+	}
+
+	for relative := range paths {
+		l, r := local[relative], remote[relative]
+
+		var remoteBase []byte
+		if r != nil {
+			remoteBase = r.Base
+		}
+
+		// isSynced checks if the hash was the state at the last sync, according to either side.
+		isSynced := func(hash []byte) bool {
+			return synced[relative] == hex.EncodeToString(hash) || bytes.Equal(remoteBase, hash)
+		}
+
+		if r != nil && r.Hash == nil { // deleted remotely
+			r = nil
+		}
+
+		switch {
+		case l == nil && r == nil:
+			delete(synced, relative)
+
+		case l != nil && r != nil && bytes.Equal(l.Hash, r.Hash):
+			synced[relative] = hex.EncodeToString(l.Hash)
+
+		case r == nil:
+			// Deleted remotely if unchanged locally since the last sync. Otherwise the remote peer downloads it.
+			if isSynced(l.Hash) {
+				if err := os.Remove(filepath.Join(folder.config.Path, filepath.FromSlash(relative))); err == nil || os.IsNotExist(err) {
+					delete(synced, relative)
+					delete(state.Files, relative)
+					result.Deleted++
+				}
+			}
+
+		case l == nil:
+			// Deleted locally if unchanged remotely since the last sync. Otherwise download it.
+			if !isSynced(r.Hash) {
+				backend.syncDownload(folder, state, peer, r, false, &result)
+			}
+
+		case isSynced(l.Hash) && !isSynced(r.Hash):
+			// changed only remotely
+			backend.syncDownload(folder, state, peer, r, false, &result)
+
+		case isSynced(r.Hash) && !isSynced(l.Hash):
+			// changed only locally, the remote peer downloads it
+
+		default:
+			// Changed on both sides. The newer file wins. Both sides come to the same conclusion.
+			remoteWins := r.Modified.After(l.Modified) || r.Modified.Equal(l.Modified) && bytes.Compare(r.Hash, l.Hash) > 0
+			if remoteWins {
+				backend.syncDownload(folder, state, peer, r, true, &result)
+			}
+		}
+	}
+
+	return result
+}
+
+// syncDownload downloads the remote file into the folder. If conflict is true, the existing local file is kept as conflict copy.
+func (backend *Backend) syncDownload(folder *syncFolder, state *syncState, peer *PeerInfo, file *protocol.SyncFile, conflict bool, result *SyncResult) {
+	if !backend.warehouseHasFile(file.Hash) {
+		// Bulk content is never fetched automatically in metadata-only mode.
+		if backend.Config.MetadataOnly && !metadataSmallFile(file.Size) {
+			result.Deferred++
+			return
+		}
+
+		if err := backend.warehouseDownload(peer, file.Hash, file.Size); err != nil {
+			backend.LogError("syncDownload", "downloading file '%s' of folder '%s': %v\n", file.Path, folder.config.Name, err)
+			result.Failed++
+			return
+		}
+	}
+
+	target := filepath.Join(folder.config.Path, filepath.FromSlash(file.Path))
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		result.Failed++
+		return
+	}
+
+	// Write to a temporary file first, so the existing file remains intact if anything fails.
+	temp := filepath.Join(filepath.Dir(target), syncStateFilename+"-"+filepath.Base(target))
+	os.Remove(temp)
+	if status, _, err := backend.UserWarehouse.ReadFileToDisk(file.Hash, 0, 0, temp); status != warehouse.StatusOK {
+		backend.LogError("syncDownload", "writing file '%s' status %d: %v\n", temp, status, err)
+		os.Remove(temp)
+		result.Failed++
+		return
+	}
+
+	if conflict {
+		if err := os.Rename(target, syncConflictName(target, peer)); err == nil {
+			result.Conflicts++
+		}
+	}
+
+	if err := os.Rename(temp, target); err != nil {
+		os.Remove(temp)
+		result.Failed++
+		return
+	}
+
+	// keep the modification time, so both sides compare the same times in case of conflicts
+	os.Chtimes(target, time.Now(), file.Modified)
+
+	if info, err := os.Stat(target); err == nil {
+		state.Files[file.Path] = &syncStateFile{Hash: file.Hash, Size: info.Size(), Modified: info.ModTime().UnixNano()}
+	}
+
+	state.Synced[hex.EncodeToString(peer.PublicKey.SerializeCompressed())][file.Path] = hex.EncodeToString(file.Hash)
+	result.Downloaded++
+}
+
+// syncConflictName returns the name of the conflict copy, for example "report.sync-conflict-20220131-154500-0263df54.txt".
+func syncConflictName(target string, peer *PeerInfo) string {
+	extension := filepath.Ext(target)
+	base := strings.TrimSuffix(target, extension)
+
+	return base + ".sync-conflict-" + time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(peer.PublicKey.SerializeCompressed()[1:5]) + extension
+}
+
+// syncReferencedFiles returns the hashes of all files in sync folders. They must be kept in the warehouse.
+func (backend *Backend) syncReferencedFiles() (hashes [][]byte) {
+	for _, folder := range backend.syncFolders {
+		folder.Lock()
+		state := folder.loadState()
+		folder.Unlock()
+
+		for _, file := range state.Files {
+			hashes = append(hashes, file.Hash)
+		}
+	}
+
+	return hashes
+}
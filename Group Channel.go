@@ -0,0 +1,532 @@
+/*
+File Username:  Group Channel.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Group channels are end-to-end encrypted channels between multiple members.
+The owner defines the group and its members via a group record on the owner's blockchain. Receivers verify membership against that record.
+
+Each member encrypts messages with its own random sender key. The sender key is distributed pairwise to all other members, encrypted to their public key.
+A new sender key is created whenever the membership changes, so removed members cannot read new messages and new members cannot read old ones.
+Since all members know the sender key, every frame is additionally signed by the sender.
+
+Frames are exchanged via the stream service "group/1". Members that are not directly reachable by the sender receive messages via other members:
+Each member forwards new messages once to the members it is connected to. Sender keys are forwarded only to their recipient.
+Received messages are kept in memory only.
+*/
+
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// groupStreamService is the name of the stream service to exchange group frames.
+const groupStreamService = "group/1"
+
+// groupMessagesMax is the max count of messages kept in memory per group. Older messages are discarded.
+const groupMessagesMax = 1000
+
+// groupPendingMax is the max count of messages per group waiting for the sender key.
+const groupPendingMax = 100
+
+// groupMaxHops is the max count of relays a frame passes.
+const groupMaxHops = 1
+
+// groupStreamTimeout is the timeout for opening a stream to a member.
+const groupStreamTimeout = 10 * time.Second
+
+// groupSeenExpiry is the time frame IDs are remembered to detect duplicates.
+const groupSeenExpiry = 10 * time.Minute
+
+// Errors for group channels
+var (
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrGroupNotMember = errors.New("not a member of the group")
+)
+
+// GroupMessage is a message in a group channel.
+type GroupMessage struct {
+	Index  uint64           // Sequential index of the message in the group, starting at 0.
+	ID     uuid.UUID        // Message ID
+	Sender *btcec.PublicKey // Sender
+	Date   time.Time        // Date created by the sender
+	Text   string           // Message text
+}
+
+// GroupInfo is a group channel known to this peer.
+type GroupInfo struct {
+	Owner *btcec.PublicKey // Owner of the group
+	blockchain.BlockRecordGroup
+}
+
+type groupKey struct {
+	owner [btcec.PubKeyBytesLenCompressed]byte
+	id    uuid.UUID
+}
+
+type groupChannel struct {
+	GroupInfo
+
+	senderKey     []byte          // Current sender key of this peer
+	senderKeyID   uint32          // Key ID of the current sender key
+	senderKeyHash []byte          // Hash of the members at the time the sender key was created
+	keyDelivered  map[string]bool // Members that received the current sender key directly. Key is the compressed public key.
+
+	senderKeys map[string]map[uint32][]byte // Sender keys of other members by public key and key ID
+	pending    []*protocol.GroupFrame       // Messages waiting for the sender key
+
+	messages  []GroupMessage // Received and sent messages
+	nextIndex uint64         // Index of the next message
+}
+
+type groupChannels struct {
+	groups map[groupKey]*groupChannel // Groups by owner and ID
+	seen   map[uuid.UUID]time.Time    // Frame IDs already processed
+	sync.Mutex
+}
+
+func (backend *Backend) initGroupChannels() {
+	backend.groupChannels = &groupChannels{groups: make(map[groupKey]*groupChannel), seen: make(map[uuid.UUID]time.Time)}
+
+	backend.RegisterStreamService(groupStreamService, backend.groupStreamHandler)
+}
+
+func newGroupKey(owner *btcec.PublicKey, id uuid.UUID) (key groupKey) {
+	copy(key.owner[:], owner.SerializeCompressed())
+	key.id = id
+	return key
+}
+
+// isGroupMember checks if the public key is the owner or a member of the group.
+func (group *GroupInfo) isGroupMember(publicKey *btcec.PublicKey) bool {
+	return group.Owner.IsEqual(publicKey) || group.IsMember(publicKey)
+}
+
+// groupRecord reads the group record from the owner's blockchain. For remote owners the global blockchain cache is used.
+func (backend *Backend) groupRecord(owner *btcec.PublicKey, id uuid.UUID) (group *blockchain.BlockRecordGroup) {
+	if owner.IsEqual(backend.PeerPublicKey) {
+		group, _ = backend.UserBlockchain.GroupRead(id)
+		return group
+	} else if backend.GlobalBlockchainCache == nil {
+		return nil
+	}
+
+	header, found, err := backend.GlobalBlockchainCache.Store.ReadBlockchainHeader(owner)
+	if !found || err != nil {
+		return nil
+	}
+
+	// If there are multiple records for the same group, the latest one is valid.
+	for _, blockN := range header.ListBlocks {
+		blockDecoded, _, found, _ := backend.ReadBlock(owner, header.Version, blockN)
+		if !found {
+			continue
+		}
+
+		for _, record := range blockDecoded.RecordsDecoded {
+			if record, ok := record.(blockchain.BlockRecordGroup); ok && record.ID == id {
+				group = &record
+			}
+		}
+	}
+
+	return group
+}
+
+// groupChannel returns the channel for the group and updates its membership. It must be called with the lock held.
+func (channels *groupChannels) groupChannel(owner *btcec.PublicKey, record *blockchain.BlockRecordGroup) (channel *groupChannel) {
+	key := newGroupKey(owner, record.ID)
+
+	if channel = channels.groups[key]; channel == nil {
+		channel = &groupChannel{keyDelivered: make(map[string]bool), senderKeys: make(map[string]map[uint32][]byte)}
+		channels.groups[key] = channel
+	}
+
+	channel.GroupInfo = GroupInfo{Owner: owner, BlockRecordGroup: *record}
+
+	return channel
+}
+
+// membersHash returns a hash of the owner and all members, used to detect membership changes.
+func (group *GroupInfo) membersHash() []byte {
+	var keys [][]byte
+	for _, member := range group.Members {
+		keys = append(keys, member.SerializeCompressed())
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	return protocol.HashData(append(group.Owner.SerializeCompressed(), bytes.Join(keys, nil)...))
+}
+
+// recipients returns the owner and all members except this peer.
+func (group *GroupInfo) recipients(self *btcec.PublicKey) (recipients []*btcec.PublicKey) {
+	if !group.Owner.IsEqual(self) {
+		recipients = append(recipients, group.Owner)
+	}
+	for _, member := range group.Members {
+		if !member.IsEqual(self) {
+			recipients = append(recipients, member)
+		}
+	}
+
+	return recipients
+}
+
+// addMessage adds a message to the group. It must be called with the lock held.
+func (channel *groupChannel) addMessage(message GroupMessage) {
+	message.Index = channel.nextIndex
+	channel.nextIndex++
+
+	channel.messages = append(channel.messages, message)
+	if len(channel.messages) > groupMessagesMax {
+		channel.messages = channel.messages[len(channel.messages)-groupMessagesMax:]
+	}
+}
+
+// Groups returns all groups owned by this peer and all groups of remote owners that this peer received frames for.
+func (backend *Backend) Groups() (groups []GroupInfo) {
+	owned, _ := backend.UserBlockchain.GroupList()
+	for _, record := range owned {
+		groups = append(groups, GroupInfo{Owner: backend.PeerPublicKey, BlockRecordGroup: record})
+	}
+
+	backend.groupChannels.Lock()
+	defer backend.groupChannels.Unlock()
+
+	for _, channel := range backend.groupChannels.groups {
+		if !channel.Owner.IsEqual(backend.PeerPublicKey) {
+			groups = append(groups, channel.GroupInfo)
+		}
+	}
+
+	return groups
+}
+
+// GroupForget removes the group and its messages from memory. Groups owned by this peer must be deleted from the blockchain separately.
+func (backend *Backend) GroupForget(owner *btcec.PublicKey, id uuid.UUID) {
+	backend.groupChannels.Lock()
+	delete(backend.groupChannels.groups, newGroupKey(owner, id))
+	backend.groupChannels.Unlock()
+}
+
+// GroupMessages returns the messages of the group starting at the index.
+func (backend *Backend) GroupMessages(owner *btcec.PublicKey, id uuid.UUID, offset uint64) (messages []GroupMessage, err error) {
+	backend.groupChannels.Lock()
+	defer backend.groupChannels.Unlock()
+
+	channel := backend.groupChannels.groups[newGroupKey(owner, id)]
+	if channel == nil {
+		if owner.IsEqual(backend.PeerPublicKey) && backend.groupRecord(owner, id) != nil {
+			return nil, nil
+		}
+		return nil, ErrGroupNotFound
+	}
+
+	for _, message := range channel.messages {
+		if message.Index >= offset {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+// GroupSend sends a message to all members of the group. Delivery happens in the background.
+func (backend *Backend) GroupSend(owner *btcec.PublicKey, id uuid.UUID, text string) (message GroupMessage, err error) {
+	message, senderKeyID, recipients, messageRaw, keyFrames, err := backend.groupPrepare(owner, id, text)
+	if err != nil {
+		return message, err
+	}
+
+	go backend.groupDeliver(owner, id, senderKeyID, recipients, messageRaw, keyFrames)
+
+	return message, nil
+}
+
+// groupPrepare encrypts the message with the current sender key and creates the sender key frames for members that did not receive it yet.
+// The sender key is rotated if the membership changed.
+func (backend *Backend) groupPrepare(owner *btcec.PublicKey, id uuid.UUID, text string) (message GroupMessage, senderKeyID uint32, recipients []*btcec.PublicKey, messageRaw []byte, keyFrames map[string][]byte, err error) {
+	record := backend.groupRecord(owner, id)
+	if record == nil {
+		return message, 0, nil, nil, nil, ErrGroupNotFound
+	}
+
+	backend.groupChannels.Lock()
+
+	channel := backend.groupChannels.groupChannel(owner, record)
+	if !channel.isGroupMember(backend.PeerPublicKey) {
+		backend.groupChannels.Unlock()
+		return message, 0, nil, nil, nil, ErrGroupNotMember
+	}
+
+	// new sender key if not yet created or the membership changed
+	if membersHash := channel.membersHash(); channel.senderKey == nil || !bytes.Equal(membersHash, channel.senderKeyHash) {
+		channel.senderKey = make([]byte, chacha20poly1305.KeySize)
+		if _, err = rand.Read(channel.senderKey); err != nil {
+			backend.groupChannels.Unlock()
+			return message, 0, nil, nil, nil, err
+		}
+		channel.senderKeyID++
+		channel.senderKeyHash = membersHash
+		channel.keyDelivered = make(map[string]bool)
+	}
+
+	message = GroupMessage{ID: uuid.New(), Sender: backend.PeerPublicKey, Date: time.Now(), Text: text}
+
+	frame := &protocol.GroupFrame{Type: protocol.GroupFrameMessage, GroupID: id, Owner: owner, ID: message.ID, KeyID: channel.senderKeyID, Date: message.Date, Nonce: make([]byte, chacha20poly1305.NonceSizeX)}
+	rand.Read(frame.Nonce)
+
+	aead, _ := chacha20poly1305.NewX(channel.senderKey)
+	frame.Data = aead.Seal(nil, frame.Nonce, []byte(text), nil)
+
+	if messageRaw, err = protocol.EncodeGroupFrame(backend.PeerPrivateKey, frame); err != nil {
+		backend.groupChannels.Unlock()
+		return message, 0, nil, nil, nil, err
+	}
+
+	// Sender key frames for all members that did not receive the current key yet.
+	keyFrames = make(map[string][]byte)
+	recipients = channel.recipients(backend.PeerPublicKey)
+
+	for _, recipient := range recipients {
+		recipientID := string(recipient.SerializeCompressed())
+		if channel.keyDelivered[recipientID] {
+			continue
+		}
+
+		keyEncrypted, err := btcec.Encrypt(recipient, channel.senderKey)
+		if err != nil {
+			continue
+		}
+
+		keyFrame := &protocol.GroupFrame{Type: protocol.GroupFrameSenderKey, GroupID: id, Owner: owner, ID: uuid.New(), KeyID: channel.senderKeyID, Date: message.Date, Recipient: recipient, Data: keyEncrypted}
+		if raw, err := protocol.EncodeGroupFrame(backend.PeerPrivateKey, keyFrame); err == nil {
+			keyFrames[recipientID] = raw
+		}
+	}
+
+	channel.addMessage(message)
+	senderKeyID = channel.senderKeyID
+
+	backend.groupChannels.Unlock()
+
+	return message, senderKeyID, recipients, messageRaw, keyFrames, nil
+}
+
+// groupDeliver sends the message to all reachable recipients. Sender keys of unreachable recipients are sent via the reachable ones.
+func (backend *Backend) groupDeliver(owner *btcec.PublicKey, id uuid.UUID, senderKeyID uint32, recipients []*btcec.PublicKey, messageRaw []byte, keyFrames map[string][]byte) {
+	var reachable []*PeerInfo
+	var relayKeys [][]byte
+
+	for _, recipient := range recipients {
+		if peer := backend.PeerlistLookup(recipient); peer != nil {
+			reachable = append(reachable, peer)
+		} else if raw := keyFrames[string(recipient.SerializeCompressed())]; raw != nil {
+			relayKeys = append(relayKeys, raw)
+		}
+	}
+
+	for _, peer := range reachable {
+		go func(peer *PeerInfo) {
+			var frames [][]byte
+			keyFrame := keyFrames[string(peer.PublicKey.SerializeCompressed())]
+			if keyFrame != nil {
+				frames = append(frames, keyFrame)
+			}
+			frames = append(frames, relayKeys...)
+			frames = append(frames, messageRaw)
+
+			if err := peer.groupSendFrames(frames); err != nil || keyFrame == nil {
+				return
+			}
+
+			// Only mark the key as delivered if the sender key was not changed in the meantime.
+			backend.groupChannels.Lock()
+			if channel := backend.groupChannels.groups[newGroupKey(owner, id)]; channel != nil && channel.senderKeyID == senderKeyID {
+				channel.keyDelivered[string(peer.PublicKey.SerializeCompressed())] = true
+			}
+			backend.groupChannels.Unlock()
+		}(peer)
+	}
+}
+
+// groupSendFrames opens a stream to the peer and sends the frames.
+func (peer *PeerInfo) groupSendFrames(frames [][]byte) (err error) {
+	conn, err := peer.OpenStream(groupStreamService, groupStreamTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, frame := range frames {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(frame)))
+
+		if _, err = conn.Write(append(size[:], frame...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupStreamHandler reads incoming frames from a stream.
+func (backend *Backend) groupStreamHandler(peer *PeerInfo, conn *udt.UDTSocket) {
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+
+		frameSize := binary.LittleEndian.Uint32(size[:])
+		if frameSize > protocol.GroupFrameMaxSize {
+			return
+		}
+
+		raw := make([]byte, frameSize)
+		if _, err := io.ReadFull(conn, raw); err != nil {
+			return
+		}
+
+		backend.groupReceiveFrame(peer, raw)
+	}
+}
+
+// groupReceiveFrame processes an incoming frame. Peer is the peer that sent or relayed the frame.
+func (backend *Backend) groupReceiveFrame(peer *PeerInfo, raw []byte) {
+	frame, err := protocol.DecodeGroupFrame(raw)
+	if err != nil || frame.Sender.IsEqual(backend.PeerPublicKey) {
+		return
+	}
+
+	backend.groupChannels.Lock()
+	_, seen := backend.groupChannels.seen[frame.ID]
+	backend.groupChannels.seen[frame.ID] = time.Now()
+	backend.groupChannels.Unlock()
+
+	if seen {
+		return
+	}
+
+	record := backend.groupRecord(frame.Owner, frame.GroupID)
+	if record == nil {
+		// The owner's blockchain might not be cached yet or is outdated.
+		if ownerPeer := backend.PeerlistLookup(frame.Owner); ownerPeer != nil {
+			go ownerPeer.remoteBlockchainUpdate()
+		}
+		backend.groupChannels.Lock()
+		delete(backend.groupChannels.seen, frame.ID) // allow retries
+		backend.groupChannels.Unlock()
+		return
+	}
+
+	group := GroupInfo{Owner: frame.Owner, BlockRecordGroup: *record}
+	if !group.isGroupMember(backend.PeerPublicKey) || !group.isGroupMember(frame.Sender) {
+		return
+	}
+
+	var forwardTo []*btcec.PublicKey
+
+	backend.groupChannels.Lock()
+	channel := backend.groupChannels.groupChannel(frame.Owner, record)
+
+	switch frame.Type {
+	case protocol.GroupFrameSenderKey:
+		if frame.Recipient.IsEqual(backend.PeerPublicKey) {
+			key, err := btcec.Decrypt(backend.PeerPrivateKey, frame.Data)
+			if err != nil || len(key) != chacha20poly1305.KeySize {
+				break
+			}
+
+			senderID := string(frame.Sender.SerializeCompressed())
+			if channel.senderKeys[senderID] == nil {
+				channel.senderKeys[senderID] = make(map[uint32][]byte)
+			}
+			channel.senderKeys[senderID][frame.KeyID] = key
+
+			// decrypt any pending messages
+			pending := channel.pending
+			channel.pending = nil
+			for _, pendingFrame := range pending {
+				channel.decryptMessage(pendingFrame)
+			}
+		} else if group.isGroupMember(frame.Recipient) {
+			forwardTo = append(forwardTo, frame.Recipient)
+		}
+
+	case protocol.GroupFrameMessage:
+		channel.decryptMessage(frame)
+
+		for _, recipient := range group.recipients(backend.PeerPublicKey) {
+			if !recipient.IsEqual(frame.Sender) && !recipient.IsEqual(peer.PublicKey) {
+				forwardTo = append(forwardTo, recipient)
+			}
+		}
+	}
+
+	backend.groupChannels.Unlock()
+
+	if frame.Hops < groupMaxHops && len(forwardTo) > 0 {
+		relayed := make([]byte, len(raw))
+		copy(relayed, raw)
+		protocol.SetGroupFrameHops(relayed, frame.Hops+1)
+
+		for _, recipient := range forwardTo {
+			if recipientPeer := backend.PeerlistLookup(recipient); recipientPeer != nil {
+				go recipientPeer.groupSendFrames([][]byte{relayed})
+			}
+		}
+	}
+}
+
+// decryptMessage decrypts the message and adds it to the group. If the sender key is not available, the message is kept as pending. It must be called with the lock held.
+func (channel *groupChannel) decryptMessage(frame *protocol.GroupFrame) {
+	key := channel.senderKeys[string(frame.Sender.SerializeCompressed())][frame.KeyID]
+	if key == nil {
+		if len(channel.pending) < groupPendingMax {
+			channel.pending = append(channel.pending, frame)
+		}
+		return
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return
+	}
+
+	text, err := aead.Open(nil, frame.Nonce, frame.Data, nil)
+	if err != nil {
+		return
+	}
+
+	channel.addMessage(GroupMessage{ID: frame.ID, Sender: frame.Sender, Date: frame.Date, Text: string(text)})
+}
+
+// expireGroupSeen removes expired frame IDs.
+func (backend *Backend) expireGroupSeen() (err error) {
+	backend.groupChannels.Lock()
+	defer backend.groupChannels.Unlock()
+
+	for id, received := range backend.groupChannels.seen {
+		if time.Since(received) > groupSeenExpiry {
+			delete(backend.groupChannels.seen, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+/*
+File Username:  Hashtags.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Hashtags are extracted from the name and description of files in blockchains synced to the global blockchain cache. For each hashtag the most
+recent files are kept, which allows hashtag-scoped exploration, and a trending score is maintained. The score increases by 1 for each new file
+and decays with a half-life of hashtagHalfLife. To prevent a single peer from pushing a hashtag, each peer increases the score of a hashtag at
+most once per hashtagPeerInterval. The statistics are kept in memory only.
+*/
+
+package core
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/google/uuid"
+)
+
+// hashtagMinLength and hashtagMaxLength are the min and max length of a hashtag in characters, excluding the '#'.
+const (
+	hashtagMinLength = 2
+	hashtagMaxLength = 50
+)
+
+// hashtagHalfLife is the half-life of the trending score.
+const hashtagHalfLife = 12 * time.Hour
+
+// hashtagPeerInterval is the min interval in which a single peer increases the score of a hashtag.
+const hashtagPeerInterval = time.Hour
+
+// hashtagMaxTags is the max count of hashtags kept. If exceeded, the hashtag with the lowest score is removed.
+const hashtagMaxTags = 10000
+
+// hashtagMaxFiles is the max count of recent files kept per hashtag.
+const hashtagMaxFiles = 100
+
+// hashtagMaxPeers is the max count of peers tracked per hashtag for hashtagPeerInterval.
+const hashtagMaxPeers = 1000
+
+// hashtagExpiry is the time after which hashtags are removed if not seen again.
+const hashtagExpiry = 7 * 24 * time.Hour
+
+// HashtagStat contains the statistics of a hashtag.
+type HashtagStat struct {
+	Tag       string    // Hashtag in lowercase without the '#'.
+	Score     float64   // Trending score.
+	Files     uint64    // Count of files seen with the hashtag.
+	FirstSeen time.Time // When the hashtag was first seen.
+	LastSeen  time.Time // When the hashtag was last seen.
+}
+
+// HashtagFile refers to a file with a hashtag. Use ReadBlock to read the file record.
+type HashtagFile struct {
+	PublicKey         *btcec.PublicKey // Public key of the blockchain owner.
+	BlockchainVersion uint64           // Blockchain version.
+	BlockNumber       uint64           // Block number containing the file.
+	FileID            uuid.UUID        // File ID.
+	Seen              time.Time        // When the file was seen.
+}
+
+type hashtags struct {
+	tags map[string]*hashtagEntry
+	sync.RWMutex
+}
+
+type hashtagEntry struct {
+	stat    HashtagStat
+	updated time.Time                                          // When the score was last updated. The score decays from this time.
+	peers   map[[btcec.PubKeyBytesLenCompressed]byte]time.Time // Last time each peer increased the score.
+	files   []HashtagFile                                      // Most recent files, newest last.
+}
+
+func (backend *Backend) initHashtags() {
+	backend.hashtags = &hashtags{tags: make(map[string]*hashtagEntry)}
+
+	backend.scheduleTask("hashtags-prune", time.Hour, time.Hour, func() error {
+		backend.hashtags.prune()
+		return nil
+	})
+}
+
+// ExtractHashtags returns the unique hashtags in the text in lowercase without the '#'. A hashtag consists of letters, digits, and underscores
+// and must contain at least one letter. It must start at the beginning of the text or after a character that is not a letter or digit.
+func ExtractHashtags(text string) (tags []string) {
+	runes := []rune(text)
+	unique := make(map[string]struct{})
+
+	for n := 0; n < len(runes); n++ {
+		if runes[n] != '#' || (n > 0 && isHashtagRune(runes[n-1])) {
+			continue
+		}
+
+		end := n + 1
+		hasLetter := false
+		for ; end < len(runes) && isHashtagRune(runes[end]); end++ {
+			hasLetter = hasLetter || unicode.IsLetter(runes[end])
+		}
+
+		if length := end - n - 1; hasLetter && length >= hashtagMinLength && length <= hashtagMaxLength {
+			tag := strings.ToLower(string(runes[n+1 : end]))
+			if _, ok := unique[tag]; !ok {
+				unique[tag] = struct{}{}
+				tags = append(tags, tag)
+			}
+		}
+
+		n = end - 1
+	}
+
+	return tags
+}
+
+func isHashtagRune(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_'
+}
+
+// hashtagsSeenBlock records the hashtags of all files in the block.
+func (backend *Backend) hashtagsSeenBlock(publicKey *btcec.PublicKey, version, blockNumber uint64, recordsDecoded []interface{}) {
+	now := time.Now()
+
+	for _, record := range recordsDecoded {
+		file, ok := record.(blockchain.BlockRecordFile)
+		if !ok || file.IsExpired() {
+			continue
+		}
+
+		var text string
+		for _, tag := range file.Tags {
+			if tag.Type == blockchain.TagName || tag.Type == blockchain.TagDescription {
+				text += tag.Text() + "\n"
+			}
+		}
+
+		for _, tag := range ExtractHashtags(text) {
+			backend.hashtags.seen(tag, HashtagFile{PublicKey: publicKey, BlockchainVersion: version, BlockNumber: blockNumber, FileID: file.ID, Seen: now})
+		}
+	}
+}
+
+// seen records a file with the hashtag.
+func (hashtags *hashtags) seen(tag string, file HashtagFile) {
+	hashtags.Lock()
+	defer hashtags.Unlock()
+
+	entry := hashtags.tags[tag]
+	if entry == nil {
+		if len(hashtags.tags) >= hashtagMaxTags {
+			hashtags.evictLowest(file.Seen)
+		}
+
+		entry = &hashtagEntry{stat: HashtagStat{Tag: tag, FirstSeen: file.Seen}, updated: file.Seen, peers: make(map[[btcec.PubKeyBytesLenCompressed]byte]time.Time)}
+		hashtags.tags[tag] = entry
+	}
+
+	// The same file may be seen again, for example if the blockchain is synced again after a new version.
+	for n := range entry.files {
+		if entry.files[n].FileID == file.FileID {
+			entry.files[n] = file
+			return
+		}
+	}
+
+	entry.stat.Files++
+	entry.stat.LastSeen = file.Seen
+	if entry.files = append(entry.files, file); len(entry.files) > hashtagMaxFiles {
+		entry.files = entry.files[1:]
+	}
+
+	key := publicKey2Compressed(file.PublicKey)
+	if last, ok := entry.peers[key]; ok && file.Seen.Sub(last) < hashtagPeerInterval {
+		return
+	} else if !ok && len(entry.peers) >= hashtagMaxPeers {
+		return
+	}
+	entry.peers[key] = file.Seen
+
+	entry.stat.Score = entry.score(file.Seen) + 1
+	entry.updated = file.Seen
+}
+
+// score returns the decayed score at the given time.
+func (entry *hashtagEntry) score(now time.Time) float64 {
+	return entry.stat.Score * math.Pow(0.5, now.Sub(entry.updated).Hours()/hashtagHalfLife.Hours())
+}
+
+// evictLowest removes the hashtag with the lowest score. The hashtags must be locked.
+func (hashtags *hashtags) evictLowest(now time.Time) {
+	var lowestTag string
+	lowestScore := math.MaxFloat64
+
+	for tag, entry := range hashtags.tags {
+		if score := entry.score(now); score < lowestScore {
+			lowestTag, lowestScore = tag, score
+		}
+	}
+
+	delete(hashtags.tags, lowestTag)
+}
+
+// prune removes expired hashtags and peers that no longer limit the score.
+func (hashtags *hashtags) prune() {
+	hashtags.Lock()
+	defer hashtags.Unlock()
+
+	now := time.Now()
+
+	for tag, entry := range hashtags.tags {
+		if now.Sub(entry.stat.LastSeen) >= hashtagExpiry {
+			delete(hashtags.tags, tag)
+			continue
+		}
+
+		for key, last := range entry.peers {
+			if now.Sub(last) >= hashtagPeerInterval {
+				delete(entry.peers, key)
+			}
+		}
+	}
+}
+
+// HashtagsTrending returns the hashtags with the highest score, up to the limit (0 = all).
+func (backend *Backend) HashtagsTrending(limit int) (stats []HashtagStat) {
+	hashtags := backend.hashtags
+	hashtags.RLock()
+	defer hashtags.RUnlock()
+
+	now := time.Now()
+
+	for _, entry := range hashtags.tags {
+		stat := entry.stat
+		stat.Score = entry.score(now)
+		stats = append(stats, stat)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Score == stats[j].Score {
+			return stats[i].Tag < stats[j].Tag
+		}
+		return stats[i].Score > stats[j].Score
+	})
+
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+
+	return stats
+}
+
+// HashtagFiles returns the most recent files seen with the hashtag, newest first. The hashtag is case insensitive and may start with '#'.
+func (backend *Backend) HashtagFiles(tag string) (files []HashtagFile) {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+
+	hashtags := backend.hashtags
+	hashtags.RLock()
+	defer hashtags.RUnlock()
+
+	entry := hashtags.tags[tag]
+	if entry == nil {
+		return nil
+	}
+
+	for n := len(entry.files) - 1; n >= 0; n-- {
+		files = append(files, entry.files[n])
+	}
+
+	return files
+}
@@ -0,0 +1,649 @@
+/*
+File Username:  Hole Punch.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Coordinated UDP hole punching between 2 peers that are both behind NATs. The Traverse message only relays the first packet, which fails if the
+reply of the target peer is dropped by the NAT of the initiator. Instead, a relay that is connected to both peers coordinates a simultaneous open:
+
+1. The initiator sends a Request to the relay.
+2. The relay sends an Introduce message to both peers with the address of the other peer as observed by the relay. The peer with the lower RTT
+   to the relay delays punching by half the RTT difference, so that both start at the same time. The Introduce to the target includes the
+   address report of the initiator. The target replies with its own address report, which the relay forwards to the initiator.
+3. Both peers send Punch messages directly to the other one with exponentially increasing intervals, until a Punch or Punch acknowledge
+   arrives or holePunchTimeout elapses. The first packet that arrives adds the other peer with a direct connection. Peers only punch the
+   address supplied by the relay if the other peer reported the IP itself (signed address report), so that a relay cannot abuse peers to
+   send packets to arbitrary addresses.
+4. If punching fails and the relay offered it (config setting HolePunchRelay or TrafficRelay, both opt-in), both peers fall back to relay-only mode: All packets to the
+   other peer, including lite packets of transfers, are embedded in Relay messages and forwarded by the relay. The relay limits the traffic per session, or per peer if it opted-in as traffic relay (see Traffic Relay.go).
+
+Requests handled as relay are rate limited per initiator and per target, Introduce messages handled as target per relay and per introduced peer.
+
+Hole punching is started automatically in addition to the Traverse message when contacting a peer behind a NAT for the first time, if the local
+NAT filters unsolicited incoming packets. It can also be started explicitly via HolePunch.
+*/
+
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+const holePunchIntroduceTimeout = 5 * time.Second // Time to wait for the Introduce message from the relay.
+const holePunchTimeout = 10 * time.Second         // Time after the introduction within which punching must succeed.
+const holePunchInterval = 50 * time.Millisecond   // Interval between the first 2 punches. It doubles after each punch.
+const holePunchAttempts = 7                       // Max count of punches per session.
+const holePunchRequestLimit = 10                  // Max count of Request messages handled as relay per minute, per initiator and per target.
+const holePunchIntroduceLimit = 10                // Max count of Introduce messages handled as target per minute, per relay and per introduced peer.
+const holePunchIdle = 5 * time.Minute             // Sessions expire if there was no activity.
+const holePunchRelayBytes = 4 * 1024 * 1024       // Max bytes forwarded per relay-only session per minute.
+const holePunchExpiryInterval = holePunchIdle / 5 // Interval to expire sessions.
+
+// Status of a hole punching session
+const (
+	holePunchPending   = iota // Waiting for the introduction or punching.
+	holePunchSucceeded        // A direct connection was established.
+	holePunchRelaying         // Punching failed, the traffic is relayed.
+	holePunchFailed           // Punching failed without fallback.
+)
+
+// holePunchSession is a hole punching session of this peer as initiator or target.
+type holePunchSession struct {
+	id         uint64
+	other      *btcec.PublicKey // Other peer.
+	relay      *PeerInfo        // Relay coordinating the session.
+	initiator  bool             // Whether this peer initiated the session.
+	address    *net.UDPAddr     // Address of the other peer as observed by the relay. Nil until introduced.
+	delay      time.Duration    // Delay before punching.
+	relayOnly  bool             // Whether the relay forwards the traffic if punching fails.
+	status     int              // Status, see holePunchX.
+	introduced chan struct{}    // Initiator only: Closed when introduced by the relay.
+	reported   chan struct{}    // Initiator only: Closed when the address report of the other peer was forwarded by the relay.
+	verified   bool             // Whether the other peer reported the IP of the address itself. Punching requires it.
+	done       chan struct{}    // Closed when punching succeeded.
+	lastActive time.Time        // Last activity.
+}
+
+// holePunchRelaySession is a session coordinated by this peer as relay.
+type holePunchRelaySession struct {
+	initiator  *btcec.PublicKey
+	target     *btcec.PublicKey
+	relayOnly  bool      // Whether the traffic is forwarded if punching fails.
+	reported   bool      // Whether the address report of the target was forwarded to the initiator.
+	bytes      int       // Bytes forwarded in the current minute.
+	bytesReset time.Time // When bytes is reset.
+	lastActive time.Time // Last activity.
+}
+
+// relayPath is the path of a connection in relay-only mode.
+type relayPath struct {
+	relay   *PeerInfo        // Relay forwarding the packets.
+	session uint64           // Session ID.
+	peer    *btcec.PublicKey // Remote peer.
+}
+
+// HolePunchStats contains the counters of hole punching sessions.
+type HolePunchStats struct {
+	Attempts     uint64 // Count of sessions as initiator or target.
+	Succeeded    uint64 // Count of sessions that established a direct connection.
+	RelayOnly    uint64 // Count of sessions that fell back to relay-only mode.
+	Failed       uint64 // Count of sessions that failed, including the ones not introduced by the relay.
+	Coordinated  uint64 // Count of sessions coordinated as relay.
+	RelayedBytes uint64 // Count of bytes forwarded as relay in relay-only mode.
+}
+
+type holePunch struct {
+	sessions   map[uint64]*holePunchSession      // Own sessions by ID.
+	relayed    map[uint64]*holePunchRelaySession // Sessions coordinated as relay by ID.
+	counts     map[holePunchCountKey]int         // Count of Request and Introduce messages handled in the current minute per peer.
+	countReset time.Time                         // When the counts are reset.
+	stats      HolePunchStats
+	sync.Mutex
+}
+
+// holePunchCountKey identifies a rate limit counter of a peer.
+type holePunchCountKey struct {
+	request bool // Request handled as relay, otherwise Introduce handled as target.
+	peer    [btcec.PubKeyBytesLenCompressed]byte
+}
+
+func (backend *Backend) initHolePunch() {
+	backend.holePunch = &holePunch{
+		sessions: make(map[uint64]*holePunchSession),
+		relayed:  make(map[uint64]*holePunchRelaySession),
+		counts:   make(map[holePunchCountKey]int),
+	}
+}
+
+var errHolePunchActive = errors.New("hole punching to the peer is already in progress")
+var errHolePunchIntroduce = errors.New("relay did not introduce the peer")
+var errHolePunchFailed = errors.New("hole punching failed")
+
+// HolePunch establishes a connection to the target peer via hole punching coordinated by the relay, which must be connected to both peers.
+// If punching fails and the relay offers it, the traffic is relayed (relay-only mode). It blocks until the peer is connected or it failed.
+func (backend *Backend) HolePunch(target *btcec.PublicKey, relay *PeerInfo) (peer *PeerInfo, relayOnly bool, err error) {
+	if peer = backend.PeerlistLookup(target); peer != nil && peer.isConnectedDirect() {
+		return peer, false, nil
+	}
+
+	session := &holePunchSession{id: rand.Uint64(), other: target, relay: relay, initiator: true, introduced: make(chan struct{}), reported: make(chan struct{}), done: make(chan struct{}), lastActive: time.Now()}
+	if !backend.holePunchAdd(session) {
+		return nil, false, errHolePunchActive
+	}
+
+	payload, _ := protocol.EncodeHolePunch(protocol.HolePunchRequest, session.id, target, nil, 0, 0, false, backend.holePunchReport(session.id))
+	relay.send(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload})
+
+	select {
+	case <-session.introduced:
+	case <-time.After(holePunchIntroduceTimeout):
+		backend.holePunchFallback(session, false)
+		return nil, false, errHolePunchIntroduce
+	}
+
+	// Punching requires the address report of the target. Without it, only relay-only mode is possible.
+	select {
+	case <-session.reported:
+	case <-time.After(holePunchIntroduceTimeout):
+	}
+
+	backend.holePunch.Lock()
+	verified := session.verified
+	backend.holePunch.Unlock()
+
+	if verified && backend.holePunchRun(session) {
+		return backend.PeerlistLookup(target), false, nil
+	}
+
+	switch backend.holePunchFallback(session, session.relayOnly) {
+	case holePunchSucceeded:
+		return backend.PeerlistLookup(target), false, nil
+	case holePunchRelaying:
+		peer, err = backend.holePunchRelayConnect(session)
+		return peer, true, err
+	default:
+		return nil, false, errHolePunchFailed
+	}
+}
+
+// holePunchAuto starts hole punching in the background when contacting a peer behind a NAT for the first time, if the local NAT filters
+// unsolicited incoming packets. The reply to the Traverse message would be dropped in this case.
+func (backend *Backend) holePunchAuto(target *btcec.PublicKey, relay *PeerInfo) {
+	if natType, _, _, _ := backend.NATType(); natType != NATRestricted && natType != NATSymmetric {
+		return
+	}
+
+	go backend.HolePunch(target, relay)
+}
+
+// holePunchAdd adds a new session. It fails if the ID is already used or if there is already a pending session to the same peer.
+func (backend *Backend) holePunchAdd(session *holePunchSession) (added bool) {
+	hp := backend.holePunch
+	hp.Lock()
+	defer hp.Unlock()
+
+	if _, ok := hp.sessions[session.id]; ok {
+		return false
+	}
+	for _, existing := range hp.sessions {
+		if existing.status == holePunchPending && existing.other.IsEqual(session.other) {
+			return false
+		}
+	}
+
+	hp.sessions[session.id] = session
+	hp.stats.Attempts++
+
+	return true
+}
+
+// holePunchRun sends the punches to the other peer after the delay. It returns true if punching succeeded within holePunchTimeout.
+func (backend *Backend) holePunchRun(session *holePunchSession) (success bool) {
+	deadline := time.After(session.delay + holePunchTimeout)
+
+	select {
+	case <-session.done:
+		return true
+	case <-time.After(session.delay):
+	}
+
+	interval := holePunchInterval
+	for n := 0; ; n++ {
+		if n < holePunchAttempts {
+			payload, _ := protocol.EncodeHolePunch(protocol.HolePunchPunch, session.id, nil, nil, 0, 0, false, nil)
+			backend.networks.sendAllNetworks(session.other, &protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload}, session.address, 0, 0, nil, nil)
+		}
+
+		select {
+		case <-session.done:
+			return true
+		case <-deadline:
+			return false
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+	}
+}
+
+// holePunchFallback ends a session for which punching failed. Relay-only mode is used if allowed. It returns the new status, which is
+// holePunchSucceeded if punching succeeded in the meantime.
+func (backend *Backend) holePunchFallback(session *holePunchSession, relayOnly bool) (status int) {
+	hp := backend.holePunch
+	hp.Lock()
+	defer hp.Unlock()
+
+	if session.status != holePunchPending {
+		return session.status
+	}
+
+	if relayOnly {
+		session.status = holePunchRelaying
+		hp.stats.RelayOnly++
+	} else {
+		session.status = holePunchFailed
+		hp.stats.Failed++
+	}
+	session.lastActive = time.Now()
+
+	return session.status
+}
+
+// holePunchSucceeded marks the session as succeeded if the packet came from the other peer of the session.
+func (backend *Backend) holePunchSucceeded(id uint64, other *btcec.PublicKey) (session *holePunchSession) {
+	hp := backend.holePunch
+	hp.Lock()
+	defer hp.Unlock()
+
+	session = hp.sessions[id]
+	if session == nil || !session.other.IsEqual(other) || session.address == nil {
+		return nil
+	}
+	session.lastActive = time.Now()
+
+	if session.status == holePunchPending || session.status == holePunchRelaying {
+		session.status = holePunchSucceeded
+		hp.stats.Succeeded++
+		close(session.done)
+	}
+
+	return session
+}
+
+// holePunchRelayConnect adds a connection in relay-only mode to the other peer of the session and sends an announcement via it.
+func (backend *Backend) holePunchRelayConnect(session *holePunchSession) (peer *PeerInfo, err error) {
+	connections := session.relay.GetConnections(true)
+	if len(connections) == 0 {
+		return nil, errors.New("no connection to relay")
+	}
+
+	connection := &Connection{backend: backend, Network: connections[0].Network, Address: &net.UDPAddr{IP: connections[0].Address.IP, Port: connections[0].Address.Port}, Status: ConnectionActive, relay: &relayPath{relay: session.relay, session: session.id, peer: session.other}}
+
+	peer, added := backend.PeerlistAdd(session.other, connection)
+	if !added {
+		connection = peer.registerConnection(connection, false)
+	}
+
+	peer.pingConnectionAnnouncement(connection)
+
+	return peer, nil
+}
+
+// holePunchAllowed checks the rate limit for Request messages handled as relay (per initiator and target) and Introduce messages handled as
+// target (per relay and introduced peer). The message is counted for all peers if it is allowed.
+func (backend *Backend) holePunchAllowed(request bool, peers ...*btcec.PublicKey) bool {
+	limit := holePunchIntroduceLimit
+	if request {
+		limit = holePunchRequestLimit
+	}
+
+	hp := backend.holePunch
+	hp.Lock()
+	defer hp.Unlock()
+
+	if now := time.Now(); now.After(hp.countReset) {
+		hp.counts = make(map[holePunchCountKey]int)
+		hp.countReset = now.Add(time.Minute)
+	}
+
+	for _, peer := range peers {
+		if hp.counts[holePunchCountKey{request: request, peer: publicKey2Compressed(peer)}] >= limit {
+			return false
+		}
+	}
+
+	for _, peer := range peers {
+		hp.counts[holePunchCountKey{request: request, peer: publicKey2Compressed(peer)}]++
+	}
+
+	return true
+}
+
+// holePunchReport returns the address report of this peer for the session. The IP is the external one detected via NAT detection. Nil if unknown.
+func (backend *Backend) holePunchReport(sessionID uint64) (report []byte) {
+	_, externalIP, _, _ := backend.NATType()
+	if externalIP == nil || externalIP.IsUnspecified() {
+		return nil
+	}
+
+	report, _ = protocol.EncodeHolePunchReport(backend.PeerPrivateKey, sessionID, externalIP)
+	return report
+}
+
+// isConnectedDirect checks if the peer has an active connection that is not in relay-only mode.
+func (peer *PeerInfo) isConnectedDirect() bool {
+	peer.RLock()
+	defer peer.RUnlock()
+
+	for _, connection := range peer.connectionActive {
+		if connection.relay == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cmdHolePunch handles an incoming hole punch message.
+func (peer *PeerInfo) cmdHolePunch(msg *protocol.MessageHolePunch, connection *Connection) {
+	switch msg.Action {
+	case protocol.HolePunchRequest:
+		peer.holePunchCoordinate(msg, connection)
+
+	case protocol.HolePunchIntroduce:
+		peer.holePunchIntroduced(msg)
+
+	case protocol.HolePunchReport:
+		peer.holePunchReported(msg)
+
+	case protocol.HolePunchPunch:
+		if connection.relay != nil {
+			return
+		}
+		if session := peer.Backend.holePunchSucceeded(msg.SessionID, peer.PublicKey); session != nil {
+			payload, _ := protocol.EncodeHolePunch(protocol.HolePunchAck, msg.SessionID, nil, nil, 0, 0, false, nil)
+			peer.sendConnection(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload}, connection)
+		}
+
+	case protocol.HolePunchAck:
+		if connection.relay != nil {
+			return
+		}
+		if session := peer.Backend.holePunchSucceeded(msg.SessionID, peer.PublicKey); session != nil && session.initiator {
+			peer.pingConnectionAnnouncement(connection)
+		}
+
+	case protocol.HolePunchRelay:
+		peer.holePunchForward(msg)
+
+	case protocol.HolePunchRelayed:
+		peer.holePunchReceiveRelayed(msg, connection)
+	}
+}
+
+// holePunchCoordinate handles a Request as relay. Both peers are introduced to each other.
+func (peer *PeerInfo) holePunchCoordinate(msg *protocol.MessageHolePunch, connection *Connection) {
+	backend := peer.Backend
+	if msg.Peer.IsEqual(backend.PeerPublicKey) || msg.Peer.IsEqual(peer.PublicKey) || connection.relay != nil {
+		return
+	}
+
+	target := backend.PeerlistLookup(msg.Peer)
+	if target == nil {
+		return
+	}
+
+	isIPv4 := connection.IsIPv4()
+	connectionTarget := target.GetConnection2Share(false, isIPv4, !isIPv4)
+	if connectionTarget == nil || !backend.holePunchAllowed(true, peer.PublicKey, target.PublicKey) {
+		return
+	}
+
+	relayOnly := backend.Config.HolePunchRelay || backend.Config.TrafficRelay
+
+	hp := backend.holePunch
+	hp.Lock()
+	if existing := hp.relayed[msg.SessionID]; existing != nil && !(existing.initiator.IsEqual(peer.PublicKey) && existing.target.IsEqual(target.PublicKey)) {
+		hp.Unlock()
+		return
+	}
+	hp.relayed[msg.SessionID] = &holePunchRelaySession{initiator: peer.PublicKey, target: target.PublicKey, relayOnly: relayOnly, lastActive: time.Now()}
+	hp.stats.Coordinated++
+	hp.Unlock()
+
+	// The Introduce messages arrive after half the RTT. The peer with the lower RTT waits for the difference.
+	var delayInitiator, delayTarget time.Duration
+	if rttInitiator, rttTarget := connection.RoundTripTime, connectionTarget.RoundTripTime; rttInitiator < rttTarget {
+		delayInitiator = (rttTarget - rttInitiator) / 2
+	} else {
+		delayTarget = (rttInitiator - rttTarget) / 2
+	}
+
+	payload, _ := protocol.EncodeHolePunch(protocol.HolePunchIntroduce, msg.SessionID, target.PublicKey, connectionTarget.Address.IP, uint16(connectionTarget.Address.Port), delayInitiator, relayOnly, nil)
+	peer.sendConnection(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload}, connection)
+
+	// The address report of the initiator is forwarded to the target.
+	payload, _ = protocol.EncodeHolePunch(protocol.HolePunchIntroduce, msg.SessionID, peer.PublicKey, connection.Address.IP, uint16(connection.Address.Port), delayTarget, relayOnly, msg.Embedded)
+	target.sendConnection(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload}, connectionTarget)
+}
+
+// holePunchReported handles a Report message. As relay, the address report of the target is forwarded once to the initiator. As initiator,
+// the address report of the target is verified against the address supplied by the relay.
+func (peer *PeerInfo) holePunchReported(msg *protocol.MessageHolePunch) {
+	backend := peer.Backend
+
+	hp := backend.holePunch
+	hp.Lock()
+
+	if session := hp.sessions[msg.SessionID]; session != nil {
+		if session.initiator && session.address != nil && session.relay.PublicKey.IsEqual(peer.PublicKey) && session.other.IsEqual(msg.Peer) {
+			select {
+			case <-session.reported:
+			default:
+				ip, valid := protocol.VerifyHolePunchReport(msg.Embedded, session.id, session.other)
+				session.verified = valid && ip.Equal(session.address.IP)
+				session.lastActive = time.Now()
+				close(session.reported)
+			}
+		}
+		hp.Unlock()
+		return
+	}
+
+	relayed := hp.relayed[msg.SessionID]
+	if relayed == nil || relayed.reported || !relayed.target.IsEqual(peer.PublicKey) || !relayed.initiator.IsEqual(msg.Peer) {
+		hp.Unlock()
+		return
+	}
+	relayed.reported = true
+	relayed.lastActive = time.Now()
+	hp.Unlock()
+
+	if initiator := backend.PeerlistLookup(msg.Peer); initiator != nil {
+		payload, _ := protocol.EncodeHolePunch(protocol.HolePunchReport, msg.SessionID, peer.PublicKey, nil, 0, 0, false, msg.Embedded)
+		initiator.send(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload})
+	}
+}
+
+// holePunchIntroduced handles an Introduce message from the relay. The initiator continues its session, the target starts a new one.
+func (peer *PeerInfo) holePunchIntroduced(msg *protocol.MessageHolePunch) {
+	backend := peer.Backend
+	if msg.Peer.IsEqual(backend.PeerPublicKey) || msg.Peer.IsEqual(peer.PublicKey) || msg.Port == 0 {
+		return
+	}
+
+	address := &net.UDPAddr{IP: msg.IP, Port: int(msg.Port)}
+	if IPv4 := msg.IP.To4(); IPv4 != nil {
+		address.IP = IPv4
+	}
+
+	hp := backend.holePunch
+	hp.Lock()
+	if session := hp.sessions[msg.SessionID]; session != nil {
+		// Only the relay that was asked can introduce the target peer to the initiator.
+		if session.initiator && session.address == nil && session.relay.PublicKey.IsEqual(peer.PublicKey) && session.other.IsEqual(msg.Peer) {
+			session.address = address
+			session.delay = msg.Delay
+			session.relayOnly = msg.RelayOnly
+			session.lastActive = time.Now()
+			close(session.introduced)
+		}
+		hp.Unlock()
+		return
+	}
+	hp.Unlock()
+
+	// Target: Not needed if the other peer is already connected directly.
+	if other := backend.PeerlistLookup(msg.Peer); other != nil && other.isConnectedDirect() {
+		return
+	} else if !backend.holePunchAllowed(false, peer.PublicKey, msg.Peer) {
+		return
+	}
+
+	// Only the address reported by the initiator itself is punched.
+	ip, verified := protocol.VerifyHolePunchReport(msg.Embedded, msg.SessionID, msg.Peer)
+	verified = verified && ip.Equal(address.IP)
+
+	session := &holePunchSession{id: msg.SessionID, other: msg.Peer, relay: peer, address: address, delay: msg.Delay, relayOnly: msg.RelayOnly, verified: verified, done: make(chan struct{}), lastActive: time.Now()}
+	if !backend.holePunchAdd(session) {
+		return
+	}
+
+	// The own address report is sent to the initiator via the relay.
+	payload, _ := protocol.EncodeHolePunch(protocol.HolePunchReport, msg.SessionID, msg.Peer, nil, 0, 0, false, backend.holePunchReport(msg.SessionID))
+	peer.send(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload})
+
+	go func() {
+		if !verified || !backend.holePunchRun(session) {
+			backend.holePunchFallback(session, session.relayOnly)
+		}
+	}()
+}
+
+// holePunchForward forwards a packet of a relay-only session as relay to the other peer.
+func (peer *PeerInfo) holePunchForward(msg *protocol.MessageHolePunch) {
+	backend := peer.Backend
+
+	hp := backend.holePunch
+	hp.Lock()
+	session := hp.relayed[msg.SessionID]
+	if session == nil || !session.relayOnly ||
+		!(session.initiator.IsEqual(peer.PublicKey) && session.target.IsEqual(msg.Peer) || session.target.IsEqual(peer.PublicKey) && session.initiator.IsEqual(msg.Peer)) {
+		hp.Unlock()
+		return
+	}
+
+	// Peers that opted-in as traffic relay enforce the bandwidth caps per peer instead of the limit per session.
+	now := time.Now()
+	if !backend.Config.TrafficRelay {
+		if now.After(session.bytesReset) {
+			session.bytes = 0
+			session.bytesReset = now.Add(time.Minute)
+		}
+		if session.bytes+len(msg.Embedded) > holePunchRelayBytes {
+			hp.Unlock()
+			return
+		}
+		session.bytes += len(msg.Embedded)
+	}
+	session.lastActive = now
+	hp.Unlock()
+
+	other := backend.PeerlistLookup(msg.Peer)
+	if other == nil || !backend.trafficRelayAccount(peer.PublicKey, len(msg.Embedded)) {
+		return
+	}
+
+	hp.Lock()
+	hp.stats.RelayedBytes += uint64(len(msg.Embedded))
+	hp.Unlock()
+
+	if payload, err := protocol.EncodeHolePunch(protocol.HolePunchRelayed, msg.SessionID, peer.PublicKey, nil, 0, 0, false, msg.Embedded); err == nil {
+		other.send(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload})
+	}
+}
+
+// holePunchReceiveRelayed handles a packet forwarded by the relay of a relay-only session. It is processed like any packet received via network.
+func (peer *PeerInfo) holePunchReceiveRelayed(msg *protocol.MessageHolePunch, connection *Connection) {
+	backend := peer.Backend
+
+	hp := backend.holePunch
+	hp.Lock()
+	session := hp.sessions[msg.SessionID]
+	valid := session != nil && session.relayOnly && session.status != holePunchFailed && session.relay.PublicKey.IsEqual(peer.PublicKey) && session.other.IsEqual(msg.Peer)
+	if valid {
+		session.lastActive = time.Now()
+	}
+	hp.Unlock()
+
+	if !valid || connection.relay != nil {
+		return
+	}
+
+	nets := backend.networks
+	wire := networkWire{network: connection.Network, sender: &net.UDPAddr{IP: connection.Address.IP, Port: connection.Address.Port}, raw: msg.Embedded, receiverPublicKey: backend.PeerPublicKey, unicast: true, relay: &relayPath{relay: peer, session: msg.SessionID, peer: msg.Peer}}
+
+	// The channels are processed by the packet workers which may be the caller. Packets are dropped instead of blocking.
+	if isLite, err := nets.LiteRouter.IsPacketLite(msg.Embedded); isLite {
+		if err == nil {
+			select {
+			case nets.litePacketsIncoming <- wire:
+			default:
+			}
+		}
+		return
+	}
+
+	select {
+	case nets.rawPacketsIncoming <- wire:
+	default:
+	}
+}
+
+// send embeds the raw packet into a Relay message to the relay.
+func (path *relayPath) send(raw []byte) (err error) {
+	payload, err := protocol.EncodeHolePunch(protocol.HolePunchRelay, path.session, path.peer, nil, 0, 0, false, raw)
+	if err != nil {
+		return err
+	}
+
+	return path.relay.send(&protocol.PacketRaw{Command: protocol.CommandHolePunch, Payload: payload})
+}
+
+// expireHolePunch deletes sessions without recent activity. It is run by the scheduler.
+func (backend *Backend) expireHolePunch() (err error) {
+	threshold := time.Now().Add(-holePunchIdle)
+
+	hp := backend.holePunch
+	hp.Lock()
+	defer hp.Unlock()
+
+	for id, session := range hp.sessions {
+		if session.status != holePunchPending && session.lastActive.Before(threshold) {
+			delete(hp.sessions, id)
+		}
+	}
+
+	for id, session := range hp.relayed {
+		if session.lastActive.Before(threshold) {
+			delete(hp.relayed, id)
+		}
+	}
+
+	return nil
+}
+
+// HolePunchStats returns the counters of hole punching sessions.
+func (backend *Backend) HolePunchStats() (stats HolePunchStats) {
+	backend.holePunch.Lock()
+	defer backend.holePunch.Unlock()
+
+	return backend.holePunch.stats
+}
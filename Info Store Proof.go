@@ -0,0 +1,314 @@
+/*
+File Username:  Info Store Proof.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+INFO_STORE proofs deter peers from announcing files they do not store. If enabled via the config setting InfoStoreProof, each new INFO_STORE record
+is challenged via the stream service "info-store-proof/1": The receiver picks a random blake3 chunk (1 KB) of the file, and the announcing peer must
+return the chunk together with the chaining values of the sibling subtrees. The receiver verifies the proof against the hash without having the file.
+
+* Verify mode (1): Records that fail the challenge are removed. Records of peers that do not support proofs are kept.
+* Require mode (2): Only records that passed the challenge are returned in responses to FIND_VALUE requests. Records of peers that do not support proofs are removed.
+
+Records stay pending if the announcing peer is not reachable; they are challenged again when announced again. A peer that fails infoStoreProofMaxFailures
+challenges is excluded: all of its records are removed and new records are ignored for infoStoreProofExclusion. In require mode, peers that do not support
+proofs are excluded as well.
+*/
+
+package core
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// infoStoreProofStreamService is the name of the stream service for INFO_STORE proofs.
+const infoStoreProofStreamService = "info-store-proof/1"
+
+// Modes of the config setting InfoStoreProof
+const (
+	InfoStoreProofDisabled = 0 // Records are not challenged.
+	InfoStoreProofVerify   = 1 // Records are challenged. Failed records are removed, peers that do not support proofs are kept.
+	InfoStoreProofRequire  = 2 // Only records that passed the challenge are used. Peers that do not support proofs are excluded.
+)
+
+const (
+	infoStoreProofTimeout     = 30 * time.Second // Timeout for opening the stream and receiving the proof.
+	infoStoreProofQueue       = 1000             // Max count of records waiting to be challenged.
+	infoStoreProofWorkers     = 2                // Count of records challenged in parallel.
+	infoStoreProofSlots       = 2                // Max count of challenges answered in parallel.
+	infoStoreProofMaxFailures = 3                // Count of failed challenges after which the peer is excluded.
+	infoStoreProofExclusion   = 24 * time.Hour   // Duration that excluded peers are ignored.
+)
+
+// Proof state of an INFO_STORE record
+const (
+	infoStoreProofPending     = iota // Not challenged yet, or the peer was not reachable.
+	infoStoreProofPassed             // The peer proved storing the file.
+	infoStoreProofUnsupported        // The peer does not support proofs.
+)
+
+// infoStoreProofTask is a record to challenge.
+type infoStoreProofTask struct {
+	hash      []byte
+	key       infoStoreKey
+	publicKey *btcec.PublicKey
+	size      uint64
+}
+
+// InfoStoreProofStats contains the results of INFO_STORE challenges.
+type InfoStoreProofStats struct {
+	Mode        int    // Config setting InfoStoreProof.
+	Passed      uint64 // Count of passed challenges.
+	Failed      uint64 // Count of failed challenges.
+	Unsupported uint64 // Count of challenges to peers that do not support proofs.
+	Unreachable uint64 // Count of challenges that could not be sent. The records stay pending.
+	Pending     int    // Count of records not verified yet.
+	Excluded    int    // Count of peers currently excluded.
+}
+
+// initInfoStoreProof registers the stream service to answer challenges and starts the workers to challenge new records, if enabled.
+func (backend *Backend) initInfoStoreProof() {
+	store := backend.infoStore
+	store.proofSlots = make(chan struct{}, infoStoreProofSlots)
+	store.proofFailures = make(map[infoStoreKey]int)
+	store.proofExcluded = make(map[infoStoreKey]time.Time)
+	store.proofUnsupported = make(map[infoStoreKey]time.Time)
+
+	backend.RegisterStreamService(infoStoreProofStreamService, backend.infoStoreProofStreamHandler)
+
+	if backend.Config.InfoStoreProof == InfoStoreProofDisabled {
+		return
+	}
+
+	store.proofQueue = make(chan infoStoreProofTask, infoStoreProofQueue)
+	for n := 0; n < infoStoreProofWorkers; n++ {
+		go backend.infoStoreProofWorker()
+	}
+}
+
+// infoStoreProofEnqueue queues the record to be challenged. If the queue is full, the record stays pending.
+func (backend *Backend) infoStoreProofEnqueue(task infoStoreProofTask) {
+	select {
+	case backend.infoStore.proofQueue <- task:
+	default:
+		backend.infoStore.proofDequeued(task)
+	}
+}
+
+func (backend *Backend) infoStoreProofWorker() {
+	for task := range backend.infoStore.proofQueue {
+		result := StorageChallengeUnreachable
+		unsupported := false
+
+		if peer := backend.PeerlistLookup(task.publicKey); peer != nil {
+			result, unsupported = backend.infoStoreChallenge(peer, task.hash, task.size)
+		}
+
+		backend.infoStore.proofResult(task, result, unsupported, backend.Config.InfoStoreProof)
+	}
+}
+
+// infoStoreChallenge challenges the peer to prove that it stores the file. It reuses the StorageChallengeX results.
+// Unsupported is set if the peer does not provide the stream service.
+func (backend *Backend) infoStoreChallenge(peer *PeerInfo, hash []byte, size uint64) (result int, unsupported bool) {
+	challenge := &protocol.InfoStoreChallenge{Hash: hash, Size: size}
+	if chunks := (size + protocol.Blake3ChunkSize - 1) / protocol.Blake3ChunkSize; chunks > 1 {
+		challenge.Chunk = uint64(rand.Int63n(int64(chunks)))
+	}
+
+	conn, err := peer.OpenStream(infoStoreProofStreamService, infoStoreProofTimeout)
+	if err == ErrStreamServiceNotAvailable {
+		return StorageChallengeUnreachable, true
+	} else if err != nil {
+		return StorageChallengeUnreachable, false
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(infoStoreProofTimeout))
+
+	if err = protocol.InfoStoreChallengeWrite(conn, challenge); err != nil {
+		return StorageChallengeUnreachable, false
+	}
+
+	response, err := protocol.InfoStoreProofRead(conn)
+	if err != nil || response.Status == protocol.InfoStoreProofBusy {
+		return StorageChallengeUnreachable, false
+	} else if response.Status != protocol.InfoStoreProofOK || !protocol.Blake3ProofVerify(hash, size, challenge.Chunk, response.Data, response.Siblings) {
+		return StorageChallengeFailed, false
+	}
+
+	return StorageChallengePassed, false
+}
+
+// infoStoreProofStreamHandler answers a challenge for a file in the DHT store or the warehouse.
+func (backend *Backend) infoStoreProofStreamHandler(peer *PeerInfo, conn *udt.UDTSocket) {
+	conn.SetDeadline(time.Now().Add(infoStoreProofTimeout))
+
+	challenge, err := protocol.InfoStoreChallengeRead(conn)
+	if err != nil {
+		return
+	}
+
+	select {
+	case backend.infoStore.proofSlots <- struct{}{}:
+		defer func() { <-backend.infoStore.proofSlots }()
+	default:
+		protocol.InfoStoreProofWrite(conn, &protocol.InfoStoreProofResponse{Status: protocol.InfoStoreProofBusy})
+		return
+	}
+
+	var reader io.ReaderAt
+	var size uint64
+
+	if data, found := backend.dhtStore.Get(challenge.Hash); found {
+		reader, size = bytes.NewReader(data), uint64(len(data))
+	} else if path, fileSize, status, _ := backend.UserWarehouse.FileExists(challenge.Hash); status == warehouse.StatusOK {
+		file, err := os.Open(path)
+		if err != nil {
+			protocol.InfoStoreProofWrite(conn, &protocol.InfoStoreProofResponse{Status: protocol.InfoStoreProofNotFound})
+			return
+		}
+		defer file.Close()
+		reader, size = file, fileSize
+	} else {
+		protocol.InfoStoreProofWrite(conn, &protocol.InfoStoreProofResponse{Status: protocol.InfoStoreProofNotFound})
+		return
+	}
+
+	if size != challenge.Size {
+		protocol.InfoStoreProofWrite(conn, &protocol.InfoStoreProofResponse{Status: protocol.InfoStoreProofInvalidChunk})
+		return
+	}
+
+	data, siblings, err := protocol.Blake3ProofCreate(reader, size, challenge.Chunk)
+	if err != nil {
+		protocol.InfoStoreProofWrite(conn, &protocol.InfoStoreProofResponse{Status: protocol.InfoStoreProofInvalidChunk})
+		return
+	}
+
+	protocol.InfoStoreProofWrite(conn, &protocol.InfoStoreProofResponse{Status: protocol.InfoStoreProofOK, Data: data, Siblings: siblings})
+}
+
+// proofNeeded checks if the record must be challenged and marks it as queued. The caller must hold the lock.
+func (store *infoStore) proofNeeded(record *infoStoreRecord) bool {
+	if store.proofQueue == nil || record.keyword || record.proof != infoStoreProofPending || record.proofQueued {
+		return false
+	}
+
+	record.proofQueued = true
+	return true
+}
+
+// proofDequeued marks the record as no longer queued, so it is challenged again when announced again.
+func (store *infoStore) proofDequeued(task infoStoreProofTask) {
+	store.Lock()
+	defer store.Unlock()
+
+	if record := store.records[string(task.hash)][task.key]; record != nil {
+		record.proofQueued = false
+	}
+}
+
+// proofResult records the result of a challenge.
+func (store *infoStore) proofResult(task infoStoreProofTask, result int, unsupported bool, mode int) {
+	store.Lock()
+	defer store.Unlock()
+
+	record := store.records[string(task.hash)][task.key]
+	if record != nil {
+		record.proofQueued = false
+	}
+
+	switch {
+	case unsupported:
+		store.proofUnsupportedCount++
+		if mode == InfoStoreProofRequire {
+			store.proofExclude(task.key)
+			return
+		}
+
+		store.proofUnsupported[task.key] = time.Now().Add(infoStoreProofExclusion)
+		for _, peers := range store.records {
+			if record := peers[task.key]; record != nil {
+				record.proof = infoStoreProofUnsupported
+			}
+		}
+
+	case result == StorageChallengeUnreachable:
+		store.proofUnreachable++
+
+	case result == StorageChallengePassed:
+		store.proofPassed++
+		if record != nil {
+			record.proof = infoStoreProofPassed
+		}
+
+	case result == StorageChallengeFailed:
+		store.proofFailed++
+		if record != nil {
+			store.remove(string(task.hash), task.key, record)
+		}
+
+		if store.proofFailures[task.key]++; store.proofFailures[task.key] >= infoStoreProofMaxFailures {
+			store.proofExclude(task.key)
+		}
+	}
+}
+
+// proofExclude removes all records of the peer and ignores new ones for infoStoreProofExclusion. The caller must hold the lock.
+func (store *infoStore) proofExclude(key infoStoreKey) {
+	store.proofExcluded[key] = time.Now().Add(infoStoreProofExclusion)
+	delete(store.proofFailures, key)
+
+	for hash, peers := range store.records {
+		if record := peers[key]; record != nil {
+			store.remove(hash, key, record)
+		}
+	}
+}
+
+// proofExpire removes expired exclusions. The caller must hold the lock.
+func (store *infoStore) proofExpire(now time.Time) {
+	for key, until := range store.proofExcluded {
+		if until.Before(now) {
+			delete(store.proofExcluded, key)
+		}
+	}
+	for key, until := range store.proofUnsupported {
+		if until.Before(now) {
+			delete(store.proofUnsupported, key)
+		}
+	}
+}
+
+// InfoStoreProofStats returns the results of INFO_STORE challenges.
+func (backend *Backend) InfoStoreProofStats() (stats InfoStoreProofStats) {
+	store := backend.infoStore
+
+	store.Lock()
+	defer store.Unlock()
+
+	stats = InfoStoreProofStats{Mode: backend.Config.InfoStoreProof, Passed: store.proofPassed, Failed: store.proofFailed, Unsupported: store.proofUnsupportedCount,
+		Unreachable: store.proofUnreachable, Excluded: len(store.proofExcluded)}
+
+	if stats.Mode != InfoStoreProofDisabled {
+		for _, peers := range store.records {
+			for _, record := range peers {
+				if !record.keyword && record.proof == infoStoreProofPending {
+					stats.Pending++
+				}
+			}
+		}
+	}
+
+	return stats
+}
@@ -52,25 +52,39 @@ func (backend *Backend) initKademlia() {
 	// SendRequestFindValue sends an information request to find data. nodes are the nodes to send the request to.
 	backend.nodesDHT.SendRequestFindValue = func(request *dht.InformationRequest) {
 		for _, node := range request.Nodes {
-			node.Info.(*PeerInfo).sendAnnouncementFindValue(request)
+			if backend.Config.OnionHops > 0 {
+				node.Info.(*PeerInfo).sendAnnouncementFindValueOnion(request)
+			} else if backend.Config.LookupPrivacy {
+				node.Info.(*PeerInfo).sendAnnouncementFindValuePrivate(request)
+			} else {
+				node.Info.(*PeerInfo).sendAnnouncementFindValue(request)
+			}
 		}
 	}
 
 	backend.nodesDHT.FilterSearchStatus = backend.Filters.DHTSearchStatus
+	backend.nodesDHT.LookupAlpha = backend.Config.LookupAlpha
+	backend.nodesDHT.LookupBeta = backend.Config.LookupBeta
 }
 
-// autoBucketRefresh refreshes buckets every 5 minutes to meet the alpha nodes per bucket target. Force full refresh every hour.
-func (backend *Backend) autoBucketRefresh() {
-	for minute := 5; ; minute += 5 {
-		time.Sleep(time.Minute * 5)
+// bucketRefreshInterval is the interval to refresh buckets. Every 12th refresh (each hour) is a full refresh.
+const bucketRefreshInterval = time.Minute * 5
+
+// scheduleBucketRefresh refreshes buckets every 5 minutes (scaled by the network size) to meet the alpha nodes per bucket target. Force full refresh every 12th time.
+func (backend *Backend) scheduleBucketRefresh() {
+	count := 0
+
+	backend.scheduleAdaptiveTask("dht-bucket-refresh", bucketRefreshInterval, bucketRefreshInterval, func() error {
+		count++
 
 		target := alpha
-		if minute%60 == 0 {
+		if count%12 == 0 {
 			target = 0
 		}
 
 		backend.nodesDHT.RefreshBuckets(target)
-	}
+		return nil
+	})
 }
 
 // bootstrapKademlia bootstraps the Kademlia bucket list
@@ -118,8 +132,9 @@ func (peer *PeerInfo) sendAnnouncementFindValue(request *dht.InformationRequest)
 	peer.sendAnnouncement(false, findSelf, findPeer, findValue, nil, request)
 }
 
+// sendAnnouncementStore informs the peer about storing the file. The record is piggybacked on the next pong or response message if possible.
 func (peer *PeerInfo) sendAnnouncementStore(fileHash []byte, fileSize uint64) {
-	peer.sendAnnouncement(false, false, nil, nil, []protocol.InfoStore{{ID: protocol.KeyHash{Hash: fileHash}, Size: fileSize, Type: 0}}, nil)
+	peer.piggybackInfoStore(protocol.InfoStore{ID: protocol.KeyHash{Hash: fileHash}, Size: fileSize, Type: 0})
 }
 
 // ---- CORE DATA FUNCTIONS ----
@@ -143,25 +158,78 @@ func (backend *Backend) GetDataLocal(hash []byte) (data []byte, found bool) {
 	return backend.dhtStore.Get(hash)
 }
 
-// GetDataDHT requests data via DHT
+// GetDataDHT requests data via DHT. Directly connected peers that likely have the data according to their content summary are queried first.
 func (backend *Backend) GetDataDHT(hash []byte) (data []byte, senderNodeID []byte, found bool) {
+	if data, senderNodeID, found = backend.getDataContentSummary(hash); found {
+		return data, senderNodeID, found
+	}
+
 	data, senderNodeID, found, _ = backend.nodesDHT.Get(hash)
 	return data, senderNodeID, found
 }
 
+// FindStoringPeers queries the closest nodes, the closest supernodes, and directly connected peers that likely have the data for peers storing it.
+// If a peer returns the data embedded (small files), it is returned directly. Storing peers may be temporary PeerInfo structures without an active connection.
+func (backend *Backend) FindStoringPeers(hash []byte, timeout time.Duration) (peers []*PeerInfo, data []byte) {
+	var nodes []*dht.Node
+	queried := make(map[string]struct{})
+
+	for _, peer := range append(backend.contentSummaryCandidates(hash), backend.closestSupernodes(hash)...) {
+		if _, ok := queried[string(peer.NodeID)]; !ok {
+			queried[string(peer.NodeID)] = struct{}{}
+			nodes = append(nodes, &dht.Node{ID: peer.NodeID, Info: peer})
+		}
+	}
+	for _, node := range backend.nodesDHT.GetClosestContacts(bucketSize, hash, nil) {
+		if _, ok := queried[string(node.ID)]; !ok {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+
+	info := backend.nodesDHT.NewInformationRequest(dht.ActionFindValue, hash, nodes)
+	defer info.Terminate()
+
+	backend.nodesDHT.SendRequestFindValue(info)
+
+	unique := make(map[string]struct{})
+
+	for _, result := range info.CollectResults(timeout) {
+		if len(result.Data) > 0 && bytes.Equal(protocol.HashData(result.Data), hash) {
+			return nil, result.Data
+		}
+
+		for _, node := range result.Storing {
+			if _, ok := unique[string(node.ID)]; !ok {
+				unique[string(node.ID)] = struct{}{}
+				peers = append(peers, node.Info.(*PeerInfo))
+			}
+		}
+	}
+
+	return peers, nil
+}
+
 // StoreDataLocal stores data into the local warehouse.
 func (backend *Backend) StoreDataLocal(data []byte) error {
 	key := protocol.HashData(data)
 	return backend.dhtStore.Set(key, data)
 }
 
-// StoreDataDHT stores data locally and informs closestCount peers in the DHT about it.
+// StoreDataDHT stores data locally and informs closestCount peers in the DHT and the closest supernodes about it.
 // Remote peers may choose to keep a record (in case another peers asks) or mirror the full data.
 func (backend *Backend) StoreDataDHT(data []byte, closestCount int) error {
 	key := protocol.HashData(data)
 	if err := backend.dhtStore.Set(key, data); err != nil {
 		return err
 	}
+
+	for _, peer := range backend.closestSupernodes(key) {
+		peer.sendAnnouncementStore(key, uint64(len(data)))
+	}
+
 	return backend.nodesDHT.Store(key, uint64(len(data)), closestCount)
 }
 
@@ -193,6 +261,31 @@ func (backend *Backend) FindNode(nodeID []byte, Timeout time.Duration) (node *dh
 	return node, node.Info.(*PeerInfo), err
 }
 
+// ---- Iterative Lookup ----
+
+// IterativeFindNode looks up the node via an iterative Kademlia lookup. Blocking! See dht.IterativeLookup.
+func (backend *Backend) IterativeFindNode(nodeID []byte) (result *dht.LookupResult, err error) {
+	return backend.nodesDHT.IterativeFindNode(nodeID)
+}
+
+// IterativeFindValue looks up the value via an iterative Kademlia lookup. Blocking! See dht.IterativeLookup.
+// If the data is not returned directly, the peers storing it are returned in the result.
+func (backend *Backend) IterativeFindValue(hash []byte) (result *dht.LookupResult, err error) {
+	return backend.nodesDHT.IterativeFindValue(hash)
+}
+
+// IterativeLookup performs an iterative Kademlia lookup with custom parallelism. Alpha and beta of 0 use the config settings LookupAlpha and LookupBeta.
+func (backend *Backend) IterativeLookup(Action int, Key []byte, alpha, beta int) (result *dht.LookupResult, err error) {
+	if alpha <= 0 {
+		alpha = backend.Config.LookupAlpha
+	}
+	if beta <= 0 {
+		beta = backend.Config.LookupBeta
+	}
+
+	return backend.nodesDHT.IterativeLookup(Action, Key, alpha, beta)
+}
+
 // ---- Asynchronous Search ----
 
 // AsyncSearch creates an async search for the given key in the DHT.
@@ -201,3 +294,29 @@ func (backend *Backend) FindNode(nodeID []byte, Timeout time.Duration) (node *dh
 func (backend *Backend) AsyncSearch(Action int, Key []byte, Timeout, TimeoutIR time.Duration, Alpha int) (client *dht.SearchClient) {
 	return backend.nodesDHT.NewSearch(Action, Key, Timeout, TimeoutIR, Alpha)
 }
+
+// NodeFilterFeatures returns a DHT node filter that accepts peers advertising all required features and none of the excluded ones.
+// Both are bit arrays of protocol.FeatureX, for example 1<<protocol.FeatureLookupRelay to only select relays or 1<<protocol.FeatureFirewall as excluded to skip firewalled peers.
+func NodeFilterFeatures(required, excluded uint8) dht.NodeFilterFunc {
+	return func(node *dht.Node) (accept bool) {
+		features := node.Info.(*PeerInfo).Features
+		return features&required == required && features&excluded == 0
+	}
+}
+
+// NodeFilterConnectable returns a DHT node filter that only accepts peers that are connectable via the given IP versions. See IsConnectable.
+func NodeFilterConnectable(allowLocal, allowIPv4, allowIPv6 bool) dht.NodeFilterFunc {
+	return func(node *dht.Node) (accept bool) {
+		return node.Info.(*PeerInfo).IsConnectable(allowLocal, allowIPv4, allowIPv6)
+	}
+}
+
+// ClosestPeers returns up to count peers from the routing table that are closest to the target and accepted by all filters.
+// Using a random target returns random peers, which is useful for selecting relays.
+func (backend *Backend) ClosestPeers(target []byte, count int, filters ...dht.NodeFilterFunc) (peers []*PeerInfo) {
+	for _, node := range backend.nodesDHT.GetClosestContacts(count, target, dht.FilterAll(filters...)) {
+		peers = append(peers, node.Info.(*PeerInfo))
+	}
+
+	return peers
+}
@@ -0,0 +1,204 @@
+/*
+File Username:  Keyword Index.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Distributed keyword index. Without it, searches only find files in the local search index, i.e. of blockchains that happen to be cached.
+If enabled via the config setting KeywordIndex:
+* Publishing: The keywords of the user's shared files are hashed the same way as by the local search index (see search.FileKeywords) and
+  mapped to DHT keys (see search.KeywordKey). For each keyword, an INFO_STORE record of type keyword posting is sent to the closest nodes
+  and supernodes. The record states that this peer shares files matching the keyword. It is republished before the records expire.
+* Searching: The keywords of the search term are looked up via FIND_VALUE. The blockchains of the returned peers are fetched into the global
+  blockchain cache, which indexes them in the local search index. The regular local search then returns their matching files. In metadata-only
+  mode, only blockchains of followed peers are fetched.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/search"
+)
+
+const (
+	keywordIndexInterval    = time.Hour        // Interval to republish keyword postings. Must be less than infoStoreExpiry.
+	keywordIndexDelay       = 2 * time.Minute  // Delay of the first publishing after start, to allow the routing table to fill.
+	keywordIndexMaxKeywords = 500              // Max count of keywords published. Keywords matching the most files are preferred.
+	keywordIndexReplicas    = 5                // Count of closest nodes that receive the posting of a keyword.
+	keywordIndexMaxTerms    = 5                // Max count of keywords of a search term that are looked up.
+	keywordIndexMaxPeers    = 10               // Max count of peers whose blockchain is fetched per search.
+	keywordIndexTimeout     = 5 * time.Second  // Timeout to look up a keyword and to connect to a peer.
+	keywordIndexFetchWait   = 20 * time.Second // Max time to wait for the blockchains of the found peers.
+)
+
+// scheduleKeywordIndex schedules publishing the keywords of the user's files.
+func (backend *Backend) scheduleKeywordIndex() {
+	if !backend.Config.KeywordIndex || backend.Config.Observer {
+		return
+	}
+
+	backend.scheduleMaintenanceTask("keyword-index-publish", keywordIndexDelay, keywordIndexInterval, backend.keywordIndexPublish)
+}
+
+// keywordIndexPublish publishes the keyword postings of the user's files. It is run by the scheduler.
+func (backend *Backend) keywordIndexPublish() error {
+	files, _ := backend.UserBlockchain.ListFiles()
+
+	// count of files per keyword
+	counts := make(map[[32]byte]uint64)
+	for _, file := range files {
+		if file.IsExpired() {
+			continue
+		}
+
+		for hash := range search.FileKeywords(file) {
+			counts[hash]++
+		}
+	}
+
+	keywords := make([][32]byte, 0, len(counts))
+	for hash := range counts {
+		keywords = append(keywords, hash)
+	}
+	sort.Slice(keywords, func(i, j int) bool { return counts[keywords[i]] > counts[keywords[j]] })
+
+	if len(keywords) > keywordIndexMaxKeywords {
+		keywords = keywords[:keywordIndexMaxKeywords]
+	}
+
+	for _, hash := range keywords {
+		key := search.KeywordKey(hash[:])
+		record := protocol.InfoStore{ID: protocol.KeyHash{Hash: key}, Size: counts[hash], Type: protocol.InfoStoreTypeKeyword}
+
+		informed := make(map[string]struct{})
+		for _, peer := range backend.closestSupernodes(key) {
+			informed[string(peer.NodeID)] = struct{}{}
+			peer.piggybackInfoStore(record)
+		}
+		for _, node := range backend.nodesDHT.GetClosestContacts(keywordIndexReplicas, key, nil) {
+			if _, ok := informed[string(node.ID)]; !ok {
+				node.Info.(*PeerInfo).piggybackInfoStore(record)
+			}
+		}
+	}
+
+	return nil
+}
+
+// KeywordLookup looks up the keywords of the search term in the distributed keyword index. It returns the peers sharing files matching
+// any of the keywords, ordered by the count of matching keywords. Peers may be temporary PeerInfo structures without an active connection.
+func (backend *Backend) KeywordLookup(term string, timeout time.Duration) (peers []*PeerInfo) {
+	keywords := search.TermKeywords(term)
+	if len(keywords) > keywordIndexMaxTerms {
+		keywords = keywords[:keywordIndexMaxTerms]
+	}
+
+	type peerMatches struct {
+		peer    *PeerInfo
+		matches int
+	}
+	found := make(map[[btcec.PubKeyBytesLenCompressed]byte]*peerMatches)
+	var foundMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, hash := range keywords {
+		wg.Add(1)
+		go func(key []byte) {
+			defer wg.Done()
+
+			storing, _ := backend.FindStoringPeers(key, timeout)
+
+			foundMutex.Lock()
+			defer foundMutex.Unlock()
+
+			for _, peer := range storing {
+				if peer.PublicKey.IsEqual(backend.PeerPublicKey) {
+					continue
+				}
+
+				compressed := publicKey2Compressed(peer.PublicKey)
+				if existing := found[compressed]; existing != nil {
+					existing.matches++
+				} else {
+					found[compressed] = &peerMatches{peer: peer, matches: 1}
+				}
+			}
+		}(search.KeywordKey(hash))
+	}
+
+	wg.Wait()
+
+	list := make([]*peerMatches, 0, len(found))
+	for _, match := range found {
+		list = append(list, match)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].matches > list[j].matches })
+
+	for _, match := range list {
+		peers = append(peers, match.peer)
+	}
+
+	return peers
+}
+
+// KeywordSearchFetch looks up the search term in the distributed keyword index and fetches the blockchains of the found peers into the
+// global blockchain cache, which adds their files to the local search index. It returns the count of peers whose blockchain was fetched.
+// It blocks until all blockchains are fetched or keywordIndexFetchWait elapsed. It does nothing if the keyword index is disabled.
+func (backend *Backend) KeywordSearchFetch(term string) (fetched int) {
+	if !backend.Config.KeywordIndex || backend.GlobalBlockchainCache == nil || backend.SearchIndex == nil {
+		return 0
+	}
+
+	peers := backend.KeywordLookup(term, keywordIndexTimeout)
+	if len(peers) > keywordIndexMaxPeers {
+		peers = peers[:keywordIndexMaxPeers]
+	}
+
+	var fetchedMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer *PeerInfo) {
+			defer wg.Done()
+
+			// Storing peers may be temporary structures. The blockchain version and height are only known for connected peers.
+			if connected := backend.PeerlistLookup(peer.PublicKey); connected != nil {
+				peer = connected
+			} else if _, peer, _ = backend.FindNode(peer.NodeID, keywordIndexTimeout); peer == nil {
+				return
+			}
+
+			if peer.BlockchainVersion == 0 && peer.BlockchainHeight == 0 || backend.GlobalBlockchainCache.ReadOnly || !backend.metadataOnlySync(peer) {
+				return
+			}
+
+			backend.GlobalBlockchainCache.SeenBlockchainVersion(peer)
+
+			fetchedMutex.Lock()
+			fetched++
+			fetchedMutex.Unlock()
+		}(peer)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(keywordIndexFetchWait):
+	}
+
+	fetchedMutex.Lock()
+	defer fetchedMutex.Unlock()
+
+	return fetched
+}
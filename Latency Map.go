@@ -0,0 +1,282 @@
+/*
+File Username:  Latency Map.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Peer-to-peer latency map. Peers report the RTT they measured to some of their other peers via latency records appended to response messages
+(see protocol.ResponseAppendLatency), at most once per latencyReportInterval per peer. The reports are collected into a latency matrix that
+is combined with the own measurements to estimate the latency of relayed paths:
+
+* Traverse: Instead of always using the peer that returned the target, the relay with the lowest estimated latency to the target is used.
+* Onion routing: Multiple random paths are compared and the one with the lowest estimated latency is used. The hops remain random.
+
+Links without observation are assumed to have the latency latencyUnknown. Reports expire after latencyExpiry.
+*/
+
+package core
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// latencyReportInterval is the min interval to report latency records to the same peer.
+const latencyReportInterval = 5 * time.Minute
+
+// latencyExpiry is the time after which reported latencies are discarded.
+const latencyExpiry = 30 * time.Minute
+
+// latencyMaxReporters is the max count of peers whose reports are kept.
+const latencyMaxReporters = 1024
+
+// latencyMaxLinks is the max count of links kept per reporting peer. The oldest ones are replaced.
+const latencyMaxLinks = 64
+
+// latencyUnknown is the latency assumed for links without observation when comparing paths.
+const latencyUnknown = 250 * time.Millisecond
+
+// latencyPathCandidates is the count of random onion paths compared.
+const latencyPathCandidates = 8
+
+type latencyLink struct {
+	rtt      time.Duration // Reported RTT.
+	reported time.Time     // Time the RTT was reported.
+}
+
+type latencyMap struct {
+	links map[[btcec.PubKeyBytesLenCompressed]byte]map[[btcec.PubKeyBytesLenCompressed]byte]latencyLink // Reporting peer -> other peer -> RTT
+	sent  map[[btcec.PubKeyBytesLenCompressed]byte]time.Time                                            // Last time latency records were sent to the peer.
+	sync.Mutex
+}
+
+func (backend *Backend) initLatencyMap() {
+	backend.latencyMap = &latencyMap{
+		links: make(map[[btcec.PubKeyBytesLenCompressed]byte]map[[btcec.PubKeyBytesLenCompressed]byte]latencyLink),
+		sent:  make(map[[btcec.PubKeyBytesLenCompressed]byte]time.Time),
+	}
+}
+
+// latencyTake passes latency records of other peers to the encode function, which returns the count of records it included.
+// Nothing is done if records were sent to the peer recently.
+func (peer *PeerInfo) latencyTake(encode func(records []protocol.LatencyRecord) (count int)) {
+	latency := peer.Backend.latencyMap
+	key := publicKey2Compressed(peer.PublicKey)
+
+	latency.Lock()
+	sent := latency.sent[key]
+	latency.Unlock()
+
+	if time.Since(sent) < latencyReportInterval {
+		return
+	}
+
+	var records []protocol.LatencyRecord
+
+	peers := peer.Backend.PeerlistGet()
+	for _, n := range rand.Perm(len(peers)) {
+		if peers[n] == peer {
+			continue
+		}
+
+		if stats := peers[n].PathStats(); stats.Samples > 0 {
+			records = append(records, protocol.LatencyRecord{PublicKey: peers[n].PublicKey, RTT: stats.RTTP50})
+			if len(records) >= protocol.LatencyRecordsMax {
+				break
+			}
+		}
+	}
+
+	if len(records) == 0 || encode(records) == 0 {
+		return
+	}
+
+	latency.Lock()
+	latency.sent[key] = time.Now()
+	latency.Unlock()
+}
+
+// latencyIncoming stores the latency records reported by the peer.
+func (peer *PeerInfo) latencyIncoming(records []protocol.LatencyRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	latency := peer.Backend.latencyMap
+	reporter := publicKey2Compressed(peer.PublicKey)
+	now := time.Now()
+
+	latency.Lock()
+	defer latency.Unlock()
+
+	links := latency.links[reporter]
+	if links == nil {
+		if len(latency.links) >= latencyMaxReporters {
+			return
+		}
+		links = make(map[[btcec.PubKeyBytesLenCompressed]byte]latencyLink)
+		latency.links[reporter] = links
+	}
+
+	for _, record := range records {
+		if record.PublicKey.IsEqual(peer.PublicKey) {
+			continue
+		}
+
+		key := publicKey2Compressed(record.PublicKey)
+
+		if _, ok := links[key]; !ok && len(links) >= latencyMaxLinks {
+			var oldestKey [btcec.PubKeyBytesLenCompressed]byte
+			var oldest time.Time
+			for other, link := range links {
+				if oldest.IsZero() || link.reported.Before(oldest) {
+					oldestKey, oldest = other, link.reported
+				}
+			}
+			delete(links, oldestKey)
+		}
+
+		links[key] = latencyLink{rtt: record.RTT, reported: now}
+	}
+}
+
+// expireLatencyMap removes expired reports.
+func (backend *Backend) expireLatencyMap() error {
+	latency := backend.latencyMap
+	threshold := time.Now().Add(-latencyExpiry)
+
+	latency.Lock()
+	defer latency.Unlock()
+
+	for reporter, links := range latency.links {
+		for other, link := range links {
+			if link.reported.Before(threshold) {
+				delete(links, other)
+			}
+		}
+		if len(links) == 0 {
+			delete(latency.links, reporter)
+		}
+	}
+
+	for key, sent := range latency.sent {
+		if sent.Before(threshold) {
+			delete(latency.sent, key)
+		}
+	}
+
+	return nil
+}
+
+// Latency returns the RTT between two peers. If one of them is the current peer, the own measurement is used.
+// Otherwise the RTT reported by either peer is used. Ok is false if the latency is unknown.
+func (backend *Backend) Latency(from, to *btcec.PublicKey) (rtt time.Duration, ok bool) {
+	if from.IsEqual(backend.PeerPublicKey) {
+		from, to = to, from
+	}
+
+	if to.IsEqual(backend.PeerPublicKey) {
+		if peer := backend.PeerlistLookup(from); peer != nil {
+			if stats := peer.PathStats(); stats.Samples > 0 {
+				return stats.RTTP50, true
+			}
+		}
+		return 0, false
+	}
+
+	keyFrom, keyTo := publicKey2Compressed(from), publicKey2Compressed(to)
+	threshold := time.Now().Add(-latencyExpiry)
+
+	backend.latencyMap.Lock()
+	defer backend.latencyMap.Unlock()
+
+	var latest time.Time
+	for _, link := range []latencyLink{backend.latencyMap.links[keyFrom][keyTo], backend.latencyMap.links[keyTo][keyFrom]} {
+		if link.reported.After(threshold) && link.reported.After(latest) {
+			rtt, latest, ok = link.rtt, link.reported, true
+		}
+	}
+
+	return rtt, ok
+}
+
+// latencyPathCost estimates the latency of a path starting at the current peer. The target is optional and appended to the path.
+func (backend *Backend) latencyPathCost(path []*PeerInfo, target *btcec.PublicKey) (cost time.Duration) {
+	previous := backend.PeerPublicKey
+
+	keys := make([]*btcec.PublicKey, 0, len(path)+1)
+	for _, peer := range path {
+		keys = append(keys, peer.PublicKey)
+	}
+	if target != nil {
+		keys = append(keys, target)
+	}
+
+	for _, key := range keys {
+		rtt, ok := backend.Latency(previous, key)
+		if !ok {
+			rtt = latencyUnknown
+		}
+		cost += rtt
+		previous = key
+	}
+
+	return cost
+}
+
+// latencyPathSelect selects count random peers from the candidates as path to the optional target. Out of multiple random paths the one with
+// the lowest estimated latency is returned. The candidates must contain at least count peers.
+func (backend *Backend) latencyPathSelect(candidates []*PeerInfo, count int, target *btcec.PublicKey) (path []*PeerInfo) {
+	var pathCost time.Duration
+
+	for n := 0; n < latencyPathCandidates; n++ {
+		var option []*PeerInfo
+		for _, index := range rand.Perm(len(candidates))[:count] {
+			option = append(option, candidates[index])
+		}
+
+		if cost := backend.latencyPathCost(option, target); path == nil || cost < pathCost {
+			path, pathCost = option, cost
+		}
+	}
+
+	return path
+}
+
+// latencyTraverseRelay returns the relay for a Traverse message to the target. Peers that reported a latency to the target are considered
+// and the one with the lowest estimated latency is used, if it is lower than the one via the default relay.
+func (backend *Backend) latencyTraverseRelay(target *btcec.PublicKey, relayDefault *PeerInfo) (relay *PeerInfo) {
+	relay = relayDefault
+	relayCost := backend.latencyPathCost([]*PeerInfo{relayDefault}, target)
+
+	keyTarget := publicKey2Compressed(target)
+	threshold := time.Now().Add(-latencyExpiry)
+
+	var reporters []*btcec.PublicKey
+
+	backend.latencyMap.Lock()
+	for reporter, links := range backend.latencyMap.links {
+		if link, ok := links[keyTarget]; ok && link.reported.After(threshold) {
+			if publicKey, err := btcec.ParsePubKey(reporter[:], btcec.S256()); err == nil {
+				reporters = append(reporters, publicKey)
+			}
+		}
+	}
+	backend.latencyMap.Unlock()
+
+	for _, reporter := range reporters {
+		peer := backend.PeerlistLookup(reporter)
+		if peer == nil || peer == relayDefault || len(peer.GetConnections(true)) == 0 {
+			continue
+		}
+
+		if cost := backend.latencyPathCost([]*PeerInfo{peer}, target); cost < relayCost {
+			relay, relayCost = peer, cost
+		}
+	}
+
+	return relay
+}
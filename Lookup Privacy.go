@@ -0,0 +1,434 @@
+/*
+File Username:  Lookup Privacy.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Lookup privacy prevents that peers queried in a DHT FIND_VALUE lookup can trivially link the search interest to the peer ID of the searcher.
+It is enabled via the config setting LookupPrivacy.
+
+* Each information request uses a new short-lived ephemeral key pair to sign the Announcement. It does not contain a User Agent or blockchain details.
+* Peers in the own peer list (which includes the first hop of every lookup) know the IP address of the long-term identity. They are queried via
+  a relay peer that forwards the packet from its own address. The relay knows the searcher, but not which peers it queries otherwise.
+* Uncontacted peers returned during the lookup are queried directly.
+
+Responses are encrypted for the ephemeral identity. They are only decrypted if received from an address (or via a relay) that was queried before,
+and the signature must match the queried peer.
+If no relay is available, contacts are queried directly using the ephemeral identity.
+
+Relaying for other peers is opt-in via the config setting LookupRelay. A relay only forwards to peers in its own peer list via its own connection,
+so that it cannot be abused to send packets to arbitrary addresses.
+*/
+
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/dht"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// lookupPrivacyExpiry is the time an ephemeral identity or relayed lookup remains valid.
+const lookupPrivacyExpiry = time.Minute
+
+// lookupRelayMaxPerPeer is the max count of active relayed lookups per requesting peer.
+const lookupRelayMaxPerPeer = 32
+
+// lookupIdentity is an ephemeral identity used for a single information request.
+type lookupIdentity struct {
+	privateKey      *btcec.PrivateKey
+	publicKey       *btcec.PublicKey
+	messageSequence uint32                                            // Sequence for outgoing messages.
+	targets         map[[btcec.PubKeyBytesLenCompressed]byte]struct{} // Peers queried via a relay.
+	expires         time.Time
+}
+
+// lookupExpect is an expected incoming packet from a queried peer which is encrypted for an ephemeral identity.
+type lookupExpect struct {
+	ephemeralKey *btcec.PublicKey // Public key of the ephemeral identity.
+	target       *btcec.PublicKey // Queried peer.
+	identity     *lookupIdentity  // Own ephemeral identity. Nil if relayed for another peer.
+	requester    *PeerInfo        // Peer for which the lookup is relayed. Nil for own lookups.
+	circuit      *onionCircuitHop // Onion circuit if this peer is the exit hop.
+	expires      time.Time
+}
+
+// lookupPrivacy keeps track of ephemeral identities and expected incoming packets.
+type lookupPrivacy struct {
+	identities   map[*dht.InformationRequest]*lookupIdentity
+	expects      map[string][]*lookupExpect // Key is the IP:Port of the queried peer.
+	countExpects int32                      // Count of expects. Allows to skip the lookup for regular packets.
+	sync.Mutex
+}
+
+func (backend *Backend) initLookupPrivacy() {
+	backend.lookupPrivacy = &lookupPrivacy{
+		identities: make(map[*dht.InformationRequest]*lookupIdentity),
+		expects:    make(map[string][]*lookupExpect),
+	}
+}
+
+// expireLookupPrivacy deletes expired identities and expects. It is run by the scheduler.
+func (backend *Backend) expireLookupPrivacy() (err error) {
+	now := time.Now()
+
+	privacy := backend.lookupPrivacy
+	privacy.Lock()
+	defer privacy.Unlock()
+
+	for request, identity := range privacy.identities {
+		if identity.expires.Before(now) {
+			delete(privacy.identities, request)
+		}
+	}
+
+	count := 0
+	for address, expects := range privacy.expects {
+		var valid []*lookupExpect
+		for _, expect := range expects {
+			if expect.expires.After(now) {
+				valid = append(valid, expect)
+			}
+		}
+
+		if len(valid) == 0 {
+			delete(privacy.expects, address)
+		} else {
+			privacy.expects[address] = valid
+		}
+		count += len(valid)
+	}
+	atomic.StoreInt32(&privacy.countExpects, int32(count))
+
+	return nil
+}
+
+// lookupIdentityGet returns the ephemeral identity for the information request. A new one is created if necessary.
+func (backend *Backend) lookupIdentityGet(request *dht.InformationRequest) (identity *lookupIdentity, err error) {
+	privacy := backend.lookupPrivacy
+	privacy.Lock()
+	defer privacy.Unlock()
+
+	if identity = privacy.identities[request]; identity != nil {
+		return identity, nil
+	}
+
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+
+	identity = &lookupIdentity{
+		privateKey:      privateKey,
+		publicKey:       privateKey.PubKey(),
+		messageSequence: rand.Uint32(),
+		targets:         make(map[[btcec.PubKeyBytesLenCompressed]byte]struct{}),
+		expires:         time.Now().Add(lookupPrivacyExpiry),
+	}
+	privacy.identities[request] = identity
+
+	return identity, nil
+}
+
+// lookupExpectAdd registers an expected incoming packet from the address.
+func (backend *Backend) lookupExpectAdd(address *net.UDPAddr, expect *lookupExpect) bool {
+	privacy := backend.lookupPrivacy
+	privacy.Lock()
+	defer privacy.Unlock()
+
+	// Limit relayed lookups per requester.
+	if expect.requester != nil {
+		count := 0
+		for _, expects := range privacy.expects {
+			for _, existing := range expects {
+				if existing.requester == expect.requester {
+					count++
+				}
+			}
+		}
+		if count >= lookupRelayMaxPerPeer {
+			return false
+		}
+	}
+
+	expect.expires = time.Now().Add(lookupPrivacyExpiry)
+	privacy.expects[address.String()] = append(privacy.expects[address.String()], expect)
+	atomic.AddInt32(&privacy.countExpects, 1)
+
+	return true
+}
+
+// sendAnnouncementFindValuePrivate sends the FIND_VALUE request using an ephemeral identity.
+func (peer *PeerInfo) sendAnnouncementFindValuePrivate(request *dht.InformationRequest) {
+	identity, err := peer.Backend.lookupIdentityGet(request)
+	if err != nil {
+		return
+	}
+
+	raw, err := peer.lookupAnnouncementEncode(identity, request)
+	if err != nil {
+		return
+	}
+
+	// Uncontacted peers do not know the IP address of the long-term identity. Query directly.
+	if peer.isVirtual {
+		for _, address := range peer.targetAddresses {
+			remote := &net.UDPAddr{IP: address.IP, Port: int(address.Port)}
+			if peer.Backend.networks.sendRawAllNetworks(remote, raw) == nil {
+				peer.Backend.lookupExpectAdd(remote, &lookupExpect{ephemeralKey: identity.publicKey, target: peer.PublicKey, identity: identity})
+			}
+		}
+		return
+	}
+
+	connection := peer.GetConnection2Share(true, true, true)
+	if connection == nil {
+		return
+	}
+
+	// Contacts are queried via a relay. Peers in the local network are queried directly.
+	if relay := peer.Backend.lookupRelaySelect(peer); relay != nil && !IsIPLocal(connection.Address.IP) {
+		payload, err := protocol.EncodeLookupRelay(protocol.LookupRelayForward, peer.PublicKey, connection.Address.IP, uint16(connection.Address.Port), raw)
+		if err != nil {
+			return
+		}
+
+		peer.Backend.lookupPrivacy.Lock()
+		identity.targets[publicKey2Compressed(peer.PublicKey)] = struct{}{}
+		peer.Backend.lookupPrivacy.Unlock()
+
+		relay.send(&protocol.PacketRaw{Command: protocol.CommandLookupRelay, Payload: payload})
+		return
+	}
+
+	if connection.Network.send(connection.Address.IP, connection.Address.Port, raw) == nil {
+		peer.Backend.lookupExpectAdd(connection.Address, &lookupExpect{ephemeralKey: identity.publicKey, target: peer.PublicKey, identity: identity})
+	}
+}
+
+// lookupAnnouncementEncode encodes the FIND_VALUE request signed by the ephemeral identity.
+func (peer *PeerInfo) lookupAnnouncementEncode(identity *lookupIdentity, request *dht.InformationRequest) (raw []byte, err error) {
+	// No User Agent, features or blockchain details which could be used for fingerprinting.
+	builder := protocol.NewAnnouncementBuilder(0, 0, 0)
+	builder.AddFindValue(request.Key)
+
+	packets := builder.Finalize()
+	if len(packets) != 1 {
+		return nil, errors.New("invalid announcement")
+	}
+
+	packet := &protocol.PacketRaw{Protocol: protocol.ProtocolVersion, Command: protocol.CommandAnnouncement, Payload: packets[0], Sequence: peer.Backend.networks.Sequences.NewSequence(peer.PublicKey, &identity.messageSequence, request).SequenceNumber}
+
+	return protocol.PacketEncrypt(identity.privateKey, peer.PublicKey, packet)
+}
+
+// lookupRelaySelect selects a random peer from the routing table that supports relaying lookups. Peers with a lossy or slow path are avoided. The target itself is excluded.
+func (backend *Backend) lookupRelaySelect(target *PeerInfo) (relay *PeerInfo) {
+	eligible := func(node *dht.Node) (accept bool) {
+		peer := node.Info.(*PeerInfo)
+		return peer != target && len(peer.GetConnections(true)) > 0
+	}
+
+	// Peers closest to a random key are a random selection from the routing table.
+	randomKey := make([]byte, protocol.HashSize)
+	rand.Read(randomKey)
+
+	candidates := backend.ClosestPeers(randomKey, bucketSize, NodeFilterFeatures(1<<protocol.FeatureLookupRelay, 0), eligible)
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	candidates = pathRelayFilter(candidates, 1)
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// sendRawAllNetworks sends an already encrypted packet via all networks matching the IP version of the remote address.
+func (nets *Networks) sendRawAllNetworks(remote *net.UDPAddr, raw []byte) (err error) {
+	nets.RLock()
+	defer nets.RUnlock()
+
+	networksTarget := nets.networks4
+	if IsIPv6(remote.IP.To16()) {
+		networksTarget = nets.networks6
+	}
+
+	successCount := 0
+
+	for _, network := range networksTarget {
+		if network.iface != nil && remote.IP.IsLinkLocalUnicast() != network.address.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if network.send(remote.IP, remote.Port, raw) == nil {
+			successCount++
+		}
+	}
+
+	if successCount == 0 {
+		return errors.New("no successful send")
+	}
+
+	return nil
+}
+
+// cmdLookupRelay handles an incoming lookup relay message.
+func (peer *PeerInfo) cmdLookupRelay(msg *protocol.MessageLookupRelay, connection *Connection) {
+	switch msg.Action {
+	case protocol.LookupRelayForward:
+		if !peer.Backend.Config.LookupRelay || msg.Port == 0 || msg.Target.IsEqual(peer.Backend.PeerPublicKey) {
+			return
+		}
+
+		// Only forward valid Announcements to the target. The sender is the ephemeral identity.
+		decoded, ephemeralKey, err := protocol.PacketDecrypt(msg.EmbeddedPacketRaw, msg.Target)
+		if err != nil || decoded.Protocol != 0 || decoded.Command != protocol.CommandAnnouncement {
+			return
+		} else if ephemeralKey.IsEqual(peer.PublicKey) || ephemeralKey.IsEqual(peer.Backend.PeerPublicKey) {
+			return
+		}
+
+		connection := peer.Backend.lookupRelayConnection(msg.Target, msg.IP, msg.Port)
+		if connection == nil {
+			return
+		}
+
+		if !peer.Backend.lookupExpectAdd(connection.Address, &lookupExpect{ephemeralKey: ephemeralKey, target: msg.Target, requester: peer}) {
+			return
+		}
+
+		connection.Network.send(connection.Address.IP, connection.Address.Port, msg.EmbeddedPacketRaw)
+
+	case protocol.LookupRelayResponse:
+		peer.Backend.lookupRelayResponse(peer, msg)
+	}
+}
+
+// lookupRelayConnection returns the connection to forward a relayed lookup to the target. Only targets in the peer list are accepted. The address
+// provided by the requester is used if it is an active connection of the target. Connections via traffic relays are not used. Nil if the target
+// is not in the peer list or has no active connection.
+func (backend *Backend) lookupRelayConnection(target *btcec.PublicKey, ip net.IP, port uint16) (connection *Connection) {
+	peer := backend.PeerlistLookup(target)
+	if peer == nil {
+		return nil
+	}
+
+	for _, active := range peer.GetConnections(true) {
+		if active.relay != nil {
+			continue
+		} else if active.Address.IP.Equal(ip) && active.Address.Port == int(port) {
+			return active
+		} else if connection == nil {
+			connection = active
+		}
+	}
+
+	return connection
+}
+
+// lookupRelayResponse handles a packet for an own ephemeral identity that was forwarded by a relay.
+func (backend *Backend) lookupRelayResponse(relay *PeerInfo, msg *protocol.MessageLookupRelay) {
+	key := publicKey2Compressed(msg.Target)
+
+	var identities []*lookupIdentity
+	backend.lookupPrivacy.Lock()
+	for _, identity := range backend.lookupPrivacy.identities {
+		if _, ok := identity.targets[key]; ok {
+			identities = append(identities, identity)
+		}
+	}
+	backend.lookupPrivacy.Unlock()
+
+	for _, identity := range identities {
+		decoded, senderPublicKey, err := protocol.PacketDecrypt(msg.EmbeddedPacketRaw, identity.publicKey)
+		if err == nil && senderPublicKey.IsEqual(msg.Target) && decoded.Protocol == 0 {
+			backend.lookupResponse(decoded, senderPublicKey, nil)
+			return
+		}
+	}
+}
+
+// lookupPrivacyIncoming checks if the incoming packet is from a peer queried via an ephemeral identity. If so, it is handled and true is returned.
+func (backend *Backend) lookupPrivacyIncoming(packet networkWire) bool {
+	privacy := backend.lookupPrivacy
+	if atomic.LoadInt32(&privacy.countExpects) == 0 {
+		return false
+	}
+
+	privacy.Lock()
+	expects := privacy.expects[packet.sender.String()]
+	privacy.Unlock()
+
+	for _, expect := range expects {
+		if expect.expires.Before(time.Now()) {
+			continue
+		}
+
+		decoded, senderPublicKey, err := protocol.PacketDecrypt(packet.raw, expect.ephemeralKey)
+		if err != nil || !senderPublicKey.IsEqual(expect.target) || decoded.Protocol != 0 {
+			continue
+		}
+
+		if expect.identity != nil {
+			backend.lookupResponse(decoded, senderPublicKey, &Connection{backend: backend, Network: packet.network, Address: packet.sender, Status: ConnectionActive})
+			return true
+		}
+
+		if expect.circuit != nil {
+			backend.onionBackward(expect.circuit, packet.raw)
+			return true
+		}
+
+		// Forward to the requester.
+		if payload, err := protocol.EncodeLookupRelay(protocol.LookupRelayResponse, senderPublicKey, packet.sender.IP, uint16(packet.sender.Port), packet.raw); err == nil {
+			expect.requester.send(&protocol.PacketRaw{Command: protocol.CommandLookupRelay, Payload: payload})
+		}
+		return true
+	}
+
+	return false
+}
+
+// lookupResponse processes a Response received for an ephemeral identity. connection is nil if received via a relay.
+func (backend *Backend) lookupResponse(decoded *protocol.PacketRaw, senderPublicKey *btcec.PublicKey, connection *Connection) {
+	if decoded.Command != protocol.CommandResponse {
+		return
+	}
+
+	raw := &protocol.MessageRaw{SenderPublicKey: senderPublicKey, PacketRaw: *decoded}
+	response, _ := protocol.DecodeResponse(raw)
+	if response == nil {
+		return
+	}
+
+	// Validate sequence number which prevents unsolicited responses.
+	isLast := response.IsLast()
+	sequenceInfo, valid, rtt := backend.networks.Sequences.ValidateSequence(senderPublicKey, raw.Sequence, isLast, !isLast)
+	if !valid {
+		return
+	} else if _, ok := sequenceInfo.Data.(*dht.InformationRequest); !ok {
+		return
+	}
+	raw.SequenceInfo = sequenceInfo
+
+	peer := backend.PeerlistLookup(senderPublicKey)
+	if peer == nil {
+		peer = &PeerInfo{Backend: backend, PublicKey: senderPublicKey, NodeID: protocol.PublicKey2NodeID(senderPublicKey), messageSequence: rand.Uint32(), isVirtual: true, Features: response.Features}
+		if connection != nil {
+			peer.targetAddresses = []*peerAddress{{IP: connection.Address.IP, Port: uint16(connection.Address.Port), PortInternal: response.PortInternal}}
+		}
+	} else if connection != nil && rtt > 0 {
+		connection.recordRTT(rtt)
+	}
+
+	backend.Filters.MessageIn(peer, raw, response)
+
+	peer.cmdResponse(response, connection)
+}
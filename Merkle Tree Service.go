@@ -0,0 +1,87 @@
+/*
+File Username:  Merkle Tree Service.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The merkle tree stream service provides the merkle tree of files in the warehouse. Downloaders use it to verify each fragment independently,
+which allows downloading different fragments of the same file from multiple peers (swarm download).
+Merkle trees are only stored for files larger than merkle.MinimumFragmentSize.
+*/
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/PeernetOfficial/core/merkle"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// merkleTreeStreamService is the name of the stream service for merkle trees.
+const merkleTreeStreamService = "merkle-tree/1"
+
+// merkleTreeTimeout is the timeout for receiving or sending a merkle tree.
+const merkleTreeTimeout = 30 * time.Second
+
+// ErrMerkleTreeNotFound is returned if the peer does not store the merkle tree of the file.
+var ErrMerkleTreeNotFound = errors.New("merkle tree not found")
+
+func (backend *Backend) initMerkleTreeService() {
+	backend.RegisterStreamService(merkleTreeStreamService, backend.merkleTreeStreamHandler)
+}
+
+// merkleTreeStreamHandler answers a request for the merkle tree of a file in the warehouse.
+func (backend *Backend) merkleTreeStreamHandler(peer *PeerInfo, conn *udt.UDTSocket) {
+	conn.SetDeadline(time.Now().Add(merkleTreeTimeout))
+
+	hash, err := protocol.MerkleTreeRequestRead(conn)
+	if err != nil {
+		return
+	}
+
+	tree, status, _ := backend.UserWarehouse.ReadMerkleTree(hash, false)
+	if status != warehouse.StatusOK {
+		protocol.MerkleTreeResponseWrite(conn, protocol.MerkleTreeNotFound, nil)
+		return
+	}
+
+	protocol.MerkleTreeResponseWrite(conn, protocol.MerkleTreeOK, tree.Export())
+}
+
+// MerkleTreeRequest requests the merkle tree of a file from the peer. The file size must be known, since the tree is validated against it.
+// It returns ErrMerkleTreeNotFound if the peer does not store it, and ErrStreamServiceNotAvailable if the peer does not support the request.
+func (peer *PeerInfo) MerkleTreeRequest(hash []byte, fileSize uint64) (tree *merkle.MerkleTree, err error) {
+	conn, err := peer.OpenStream(merkleTreeStreamService, merkleTreeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(merkleTreeTimeout))
+
+	if err = protocol.MerkleTreeRequestWrite(conn, hash); err != nil {
+		return nil, err
+	}
+
+	status, data, err := protocol.MerkleTreeResponseRead(conn)
+	if err != nil {
+		return nil, err
+	} else if status != protocol.MerkleTreeOK {
+		return nil, ErrMerkleTreeNotFound
+	}
+
+	// Validate the header before importing. The fragment size is derived from the file size and must not be zero.
+	if len(data) < merkle.MerkleTreeFileHeaderSize || binary.LittleEndian.Uint64(data[0:8]) != fileSize || binary.LittleEndian.Uint64(data[8:16]) != merkle.CalculateFragmentSize(fileSize) {
+		return nil, errors.New("invalid merkle tree")
+	}
+
+	if tree = merkle.ImportMerkleTree(data); tree == nil {
+		return nil, errors.New("invalid merkle tree")
+	}
+
+	return tree, nil
+}
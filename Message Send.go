@@ -21,6 +21,7 @@ func (peer *PeerInfo) pingConnection(connection *Connection) {
 
 	err := peer.sendConnection(raw, connection)
 	connection.LastPingOut = time.Now()
+	connection.probeSent()
 
 	if (connection.Status == ConnectionActive || connection.Status == ConnectionRedundant) && IsNetworkErrorFatal(err) {
 		peer.invalidateActiveConnection(connection)
@@ -31,12 +32,12 @@ func (peer *PeerInfo) pingConnection(connection *Connection) {
 // It has the same effect as ping, but returns the blockchain version and height of the other peer in the Response message, which may be useful for keeping the global blockchain cache up to date.
 func (peer *PeerInfo) pingConnectionAnnouncement(connection *Connection) {
 	_, blockchainHeight, blockchainVersion := peer.Backend.UserBlockchain.Header()
-	packets := protocol.EncodeAnnouncement(false, false, nil, nil, nil, peer.Backend.FeatureSupport(), blockchainHeight, blockchainVersion, peer.Backend.userAgent)
+	packets := protocol.EncodeAnnouncement(false, false, nil, nil, nil, peer.Backend.FeatureSupport(), peer.Backend.FeatureSupportExt(), blockchainHeight, blockchainVersion, peer.Backend.userAgent)
 	if len(packets) != 1 {
 		return
 	}
 
-	raw := &protocol.PacketRaw{Command: protocol.CommandAnnouncement, Payload: packets[0], Sequence: peer.Backend.networks.Sequences.NewSequence(peer.PublicKey, &peer.messageSequence, nil).SequenceNumber}
+	raw := &protocol.PacketRaw{Command: protocol.CommandAnnouncement, Payload: packets[0], Sequence: peer.announcementSequence(nil).SequenceNumber}
 	peer.Backend.Filters.MessageOutAnnouncement(peer.PublicKey, peer, raw, false, nil, nil, nil)
 
 	err := peer.sendConnection(raw, connection)
@@ -61,10 +62,10 @@ func (peer *PeerInfo) Chat(text string) {
 // sendAnnouncement sends the announcement message. It acquires a new sequence for each message.
 func (peer *PeerInfo) sendAnnouncement(sendUA, findSelf bool, findPeer []protocol.KeyHash, findValue []protocol.KeyHash, files []protocol.InfoStore, sequenceData interface{}) {
 	_, blockchainHeight, blockchainVersion := peer.Backend.UserBlockchain.Header()
-	packets := protocol.EncodeAnnouncement(sendUA, findSelf, findPeer, findValue, files, peer.Backend.FeatureSupport(), blockchainHeight, blockchainVersion, peer.Backend.userAgent)
+	packets := protocol.EncodeAnnouncement(sendUA, findSelf, findPeer, findValue, files, peer.Backend.FeatureSupport(), peer.Backend.FeatureSupportExt(), blockchainHeight, blockchainVersion, peer.Backend.userAgent)
 
 	for _, packet := range packets {
-		raw := &protocol.PacketRaw{Command: protocol.CommandAnnouncement, Payload: packet, Sequence: peer.Backend.networks.Sequences.NewSequence(peer.PublicKey, &peer.messageSequence, sequenceData).SequenceNumber}
+		raw := &protocol.PacketRaw{Command: protocol.CommandAnnouncement, Payload: packet, Sequence: peer.announcementSequence(sequenceData).SequenceNumber}
 		peer.Backend.Filters.MessageOutAnnouncement(peer.PublicKey, peer, raw, findSelf, findPeer, findValue, files)
 		peer.send(raw)
 	}
@@ -73,7 +74,17 @@ func (peer *PeerInfo) sendAnnouncement(sendUA, findSelf bool, findPeer []protoco
 // sendResponse sends the response message
 func (peer *PeerInfo) sendResponse(sequence uint32, sendUA bool, hash2Peers []protocol.Hash2Peer, filesEmbed []protocol.EmbeddedFileData, hashesNotFound [][]byte) (err error) {
 	_, blockchainHeight, blockchainVersion := peer.Backend.UserBlockchain.Header()
-	packets, err := protocol.EncodeResponse(sendUA, hash2Peers, filesEmbed, hashesNotFound, peer.Backend.FeatureSupport(), blockchainHeight, blockchainVersion, peer.Backend.userAgent)
+	packets, err := protocol.EncodeResponse(sendUA, hash2Peers, filesEmbed, hashesNotFound, peer.Backend.FeatureSupport(), peer.Backend.FeatureSupportExt(), blockchainHeight, blockchainVersion, peer.Backend.userAgent)
+
+	peer.piggybackTake(func(files []protocol.InfoStore) (count int) {
+		return protocol.ResponseAppendInfoStore(packets, files)
+	})
+
+	peer.latencyTake(func(records []protocol.LatencyRecord) (count int) {
+		return protocol.ResponseAppendLatency(packets, records)
+	})
+
+	protocol.ResponseAppendTimestamp(packets, time.Now())
 
 	for _, packet := range packets {
 		raw := &protocol.PacketRaw{Command: protocol.CommandResponse, Payload: packet, Sequence: sequence}
@@ -111,11 +122,11 @@ func (peer *PeerInfo) sendTraverse(packet *protocol.PacketRaw, receiverEnd *btce
 func (peer *PeerInfo) sendTransfer(data []byte, control, transferProtocol uint8, hash []byte, offset, limit uint64, sequenceNumber uint32, transferID uuid.UUID, isLite bool) (err error) {
 	// Send optionally as lite packet. This bypasses the signing overhead of regular Peernet packets which is CPU intensive and a bottleneck.
 	if control == protocol.TransferControlActive && isLite {
-		raw, err := protocol.PacketLiteEncode(transferID, data)
+		raw, err := peer.Backend.networks.LiteRouter.PacketLiteEncode(transferID, data)
 		if err != nil {
 			return err
 		}
-		return peer.sendLite(raw)
+		return peer.sendLite(raw, isTransferControl(data))
 	}
 
 	packetRaw, err := protocol.EncodeTransfer(peer.Backend.PeerPrivateKey, data, control, transferProtocol, hash, offset, limit, transferID)
@@ -130,15 +141,34 @@ func (peer *PeerInfo) sendTransfer(data []byte, control, transferProtocol uint8,
 	return peer.send(raw)
 }
 
+// sendStream sends a stream message
+func (peer *PeerInfo) sendStream(data []byte, control uint8, service string, sequenceNumber uint32, transferID uuid.UUID, isLite bool) (err error) {
+	// Send optionally as lite packet. This bypasses the signing overhead of regular Peernet packets which is CPU intensive and a bottleneck.
+	if control == protocol.StreamControlActive && isLite {
+		raw, err := peer.Backend.networks.LiteRouter.PacketLiteEncode(transferID, data)
+		if err != nil {
+			return err
+		}
+		return peer.sendLite(raw, isTransferControl(data))
+	}
+
+	packetRaw, err := protocol.EncodeStream(data, control, transferID, service)
+	if err != nil {
+		return err
+	}
+
+	return peer.send(&protocol.PacketRaw{Command: protocol.CommandStream, Payload: packetRaw, Sequence: sequenceNumber})
+}
+
 // sendGetBlock sends a get block message
 func (peer *PeerInfo) sendGetBlock(data []byte, control uint8, blockchainPublicKey *btcec.PublicKey, limitBlockCount, maxBlockSize uint64, targetBlocks []protocol.BlockRange, sequenceNumber uint32, transferID uuid.UUID, isLite bool) (err error) {
 	// Send optionally as lite packet. This bypasses the signing overhead of regular Peernet packets which is CPU intensive and a bottleneck.
 	if control == protocol.GetBlockControlActive && isLite {
-		raw, err := protocol.PacketLiteEncode(transferID, data)
+		raw, err := peer.Backend.networks.LiteRouter.PacketLiteEncode(transferID, data)
 		if err != nil {
 			return err
 		}
-		return peer.sendLite(raw)
+		return peer.sendLite(raw, isTransferControl(data))
 	}
 
 	packetRaw, err := protocol.EncodeGetBlock(peer.Backend.PeerPrivateKey, data, control, blockchainPublicKey, limitBlockCount, maxBlockSize, targetBlocks, transferID)
@@ -0,0 +1,101 @@
+/*
+File Username:  Metadata Only.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Metadata-only mode for nodes on constrained connections (config setting MetadataOnly):
+
+* The global blockchain cache only syncs the blockchains of followed peers (see isFollowed). Other blockchains are not downloaded.
+* Small files of followed peers, which fit into a single packet like files embedded in responses (see protocol.EmbeddedFileSizeMax), are
+  downloaded into the warehouse in the background.
+* Bulk content is never fetched automatically. Folder sync skips larger files and counts them as deferred.
+* Files that are not stored in the warehouse are placeholders in search and explore results. They are fetched explicitly via FetchFile.
+*/
+
+package core
+
+import (
+	"math"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// metadataFetchQueueSize is the max count of small files queued for download. Files exceeding it are dropped and remain placeholders.
+const metadataFetchQueueSize = 256
+
+// metadataFetch is a small file queued for download.
+type metadataFetch struct {
+	peer *PeerInfo
+	hash []byte
+	size uint64
+}
+
+func (backend *Backend) initMetadataOnly() {
+	if !backend.Config.MetadataOnly {
+		return
+	}
+
+	backend.metadataFetchQueue = make(chan metadataFetch, metadataFetchQueueSize)
+
+	go func() {
+		for fetch := range backend.metadataFetchQueue {
+			if backend.warehouseHasFile(fetch.hash) {
+				continue
+			}
+			if err := backend.warehouseDownload(fetch.peer, fetch.hash, fetch.size); err != nil {
+				backend.LogError("metadataFetch", "downloading small file %x from %x: %v\n", fetch.hash, fetch.peer.PublicKey.SerializeCompressed(), err)
+			}
+		}
+	}()
+}
+
+// metadataOnlySync checks if the blockchain of the peer is synced into the global blockchain cache. In metadata-only mode only followed peers are synced.
+func (backend *Backend) metadataOnlySync(peer *PeerInfo) bool {
+	return !backend.Config.MetadataOnly || backend.isFollowed(peer.PublicKey)
+}
+
+// metadataSmallFile checks if the file is small enough to be downloaded automatically in metadata-only mode.
+func metadataSmallFile(size uint64) bool {
+	return size <= protocol.EmbeddedFileSizeMax
+}
+
+// metadataSeenBlock queues the small files of a block received from a followed peer for download. It must not block.
+func (backend *Backend) metadataSeenBlock(peer *PeerInfo, recordsDecoded []interface{}) {
+	if backend.metadataFetchQueue == nil {
+		return
+	}
+
+	for _, record := range recordsDecoded {
+		file, ok := record.(blockchain.BlockRecordFile)
+		if !ok || file.IsExpired() || file.Size == 0 || !metadataSmallFile(file.Size) || backend.IsHashDenied(file.Hash) || backend.warehouseHasFile(file.Hash) {
+			continue
+		}
+
+		select {
+		case backend.metadataFetchQueue <- metadataFetch{peer: peer, hash: file.Hash, size: file.Size}:
+		default:
+		}
+	}
+}
+
+// IsPlaceholder checks if a file shown in search or explore results is a placeholder. In metadata-only mode this is the case if the file is not
+// stored in the warehouse. It must be fetched explicitly via FetchFile.
+func (backend *Backend) IsPlaceholder(hash []byte) bool {
+	return backend.Config.MetadataOnly && !backend.warehouseHasFile(hash)
+}
+
+// FetchFile downloads the file from the peer into the warehouse. This is the explicit per-file fetch for placeholders in metadata-only mode.
+// The size is the expected file size; 0 if unknown. It does nothing if the file is already stored.
+func (backend *Backend) FetchFile(peer *PeerInfo, hash []byte, size uint64) (err error) {
+	if backend.warehouseHasFile(hash) {
+		return nil
+	}
+
+	maxSize := size
+	if maxSize == 0 {
+		maxSize = math.MaxUint64
+	}
+
+	return backend.warehouseDownload(peer, hash, maxSize)
+}
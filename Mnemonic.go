@@ -0,0 +1,259 @@
+/*
+File Username:  Mnemonic.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Backup and recovery of the peer private key via a BIP39 mnemonic (recovery phrase). The 32-byte private key is used directly as
+256-bit entropy, resulting in 24 words. This allows users to recover their identity and the ownership of their blockchain after device loss.
+
+If a passphrase is provided, the entropy is the private key XORed with a key derived from the passphrase (PBKDF2-HMAC-SHA512).
+The same passphrase is then required to recover the private key. A wrong passphrase results in a different (valid) private key,
+therefore VerifyMnemonic should be used before relying on a backup.
+*/
+
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const mnemonicWordCount = 24 // 256 bits entropy + 8 bits checksum = 24 words with 11 bits each.
+
+// mnemonicPassphraseKey derives the key used to protect the entropy from the passphrase. It returns nil if the passphrase is empty.
+func mnemonicPassphraseKey(passphrase string) (key []byte) {
+	if passphrase == "" {
+		return nil
+	}
+
+	return pbkdf2.Key([]byte(passphrase), []byte("peernet mnemonic"), 2048, btcec.PrivKeyBytesLen, sha512.New)
+}
+
+// PrivateKeyToMnemonic encodes the private key as 24-word BIP39 mnemonic. The passphrase is optional.
+func PrivateKeyToMnemonic(privateKey *btcec.PrivateKey, passphrase string) (mnemonic string, err error) {
+	if privateKey == nil {
+		return "", errors.New("no private key")
+	}
+
+	entropy := privateKey.Serialize()
+	if len(entropy) != btcec.PrivKeyBytesLen {
+		return "", errors.New("invalid private key length")
+	}
+
+	if key := mnemonicPassphraseKey(passphrase); key != nil {
+		for n := range entropy {
+			entropy[n] ^= key[n]
+		}
+	}
+
+	// The checksum is the first 8 bits of the SHA256 hash of the entropy, appended to the entropy.
+	checksum := sha256.Sum256(entropy)
+	data := new(big.Int).SetBytes(append(entropy, checksum[0]))
+
+	words := make([]string, mnemonicWordCount)
+	index := new(big.Int)
+	mask := big.NewInt(2047)
+
+	for n := mnemonicWordCount - 1; n >= 0; n-- {
+		index.And(data, mask)
+		words[n] = mnemonicWordlist[index.Int64()]
+		data.Rsh(data, 11)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToPrivateKey decodes the BIP39 mnemonic into the private key. The passphrase must match the one used for creating the mnemonic.
+func MnemonicToPrivateKey(mnemonic, passphrase string) (privateKey *btcec.PrivateKey, publicKey *btcec.PublicKey, err error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) != mnemonicWordCount {
+		return nil, nil, errors.New("invalid word count")
+	}
+
+	data := new(big.Int)
+
+	for _, word := range words {
+		index := mnemonicWordIndex(word)
+		if index < 0 {
+			return nil, nil, errors.New("unknown word '" + word + "'")
+		}
+
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	raw := make([]byte, btcec.PrivKeyBytesLen+1)
+	data.FillBytes(raw)
+
+	entropy := raw[:btcec.PrivKeyBytesLen]
+	if checksum := sha256.Sum256(entropy); checksum[0] != raw[btcec.PrivKeyBytesLen] {
+		return nil, nil, errors.New("invalid checksum")
+	}
+
+	if key := mnemonicPassphraseKey(passphrase); key != nil {
+		for n := range entropy {
+			entropy[n] ^= key[n]
+		}
+	}
+
+	// The private key must be in the range [1, N-1].
+	if d := new(big.Int).SetBytes(entropy); d.Sign() == 0 || d.Cmp(btcec.S256().N) >= 0 {
+		return nil, nil, errors.New("invalid private key")
+	}
+
+	privateKey, publicKey = btcec.PrivKeyFromBytes(btcec.S256(), entropy)
+
+	return privateKey, publicKey, nil
+}
+
+// mnemonicWordIndex returns the index of the word in the wordlist, or -1 if not found. The wordlist is sorted which allows binary search.
+func mnemonicWordIndex(word string) int {
+	low, high := 0, len(mnemonicWordlist)-1
+
+	for low <= high {
+		middle := (low + high) / 2
+
+		switch strings.Compare(mnemonicWordlist[middle], word) {
+		case 0:
+			return middle
+		case -1:
+			low = middle + 1
+		default:
+			high = middle - 1
+		}
+	}
+
+	return -1
+}
+
+// ExportMnemonic returns the recovery phrase of the peer private key. The passphrase is optional.
+func (backend *Backend) ExportMnemonic(passphrase string) (mnemonic string, err error) {
+	return PrivateKeyToMnemonic(backend.PeerPrivateKey, passphrase)
+}
+
+// VerifyMnemonic checks if the recovery phrase (and passphrase) re-derives the current peer private key.
+func (backend *Backend) VerifyMnemonic(mnemonic, passphrase string) (valid bool, err error) {
+	privateKey, _, err := MnemonicToPrivateKey(mnemonic, passphrase)
+	if err != nil {
+		return false, err
+	}
+
+	return privateKey.D.Cmp(backend.PeerPrivateKey.D) == 0, nil
+}
+
+// ImportMnemonic recovers the peer private key from the recovery phrase and stores it in the config as pending import. The returned public key
+// (peer ID) must be shown to the user, since a mistyped passphrase results in a different valid key. The import is only applied at the next start
+// after it is confirmed via ConfirmMnemonicImport. Until then the node keeps running with the current key and blockchain. Importing the current
+// key cancels a pending import.
+func (backend *Backend) ImportMnemonic(mnemonic, passphrase string) (publicKey *btcec.PublicKey, err error) {
+	if backend.Config.Observer {
+		return nil, errors.New("observers use an ephemeral key")
+	}
+
+	privateKey, publicKey, err := MnemonicToPrivateKey(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if privateKey.D.Cmp(backend.PeerPrivateKey.D) == 0 {
+		backend.Config.PrivateKeyImport = ""
+	} else {
+		backend.Config.PrivateKeyImport = hex.EncodeToString(privateKey.Serialize())
+	}
+	backend.Config.PrivateKeyImportConfirmed = false
+
+	backend.SaveConfig()
+
+	return publicKey, nil
+}
+
+// ConfirmMnemonicImport confirms the pending import. The peer ID must match the one returned by ImportMnemonic. The imported key replaces the
+// current one at the next start.
+func (backend *Backend) ConfirmMnemonicImport(peerID *btcec.PublicKey) (err error) {
+	publicKey, err := backend.pendingPrivateKeyImport()
+	if err != nil {
+		return err
+	} else if publicKey == nil {
+		return errors.New("no pending import")
+	} else if peerID == nil || !publicKey.IsEqual(peerID) {
+		return errors.New("peer ID does not match the pending import")
+	}
+
+	backend.Config.PrivateKeyImportConfirmed = true
+	backend.SaveConfig()
+
+	return nil
+}
+
+// pendingPrivateKeyImport returns the public key of the pending import, or nil if there is none.
+func (backend *Backend) pendingPrivateKeyImport() (publicKey *btcec.PublicKey, err error) {
+	if backend.Config.PrivateKeyImport == "" {
+		return nil, nil
+	}
+
+	privateKeyB, err := hex.DecodeString(backend.Config.PrivateKeyImport)
+	if err != nil || len(privateKeyB) != btcec.PrivKeyBytesLen {
+		return nil, errors.New("imported private key is corrupt")
+	}
+	_, publicKey = btcec.PrivKeyFromBytes(btcec.S256(), privateKeyB)
+
+	return publicKey, nil
+}
+
+// applyPrivateKeyImport replaces the private key with the one imported via ImportMnemonic, if the import was confirmed. It must be called at
+// startup before the blockchain is opened. The previous private key and the user blockchain (if it belongs to the previous key) are moved into
+// a timestamped backup folder in the data folder. Nothing is deleted.
+func (backend *Backend) applyPrivateKeyImport() (err error) {
+	if backend.Config.Observer {
+		return nil
+	}
+
+	publicKey, err := backend.pendingPrivateKeyImport()
+	if err != nil || publicKey == nil {
+		return err
+	} else if !backend.Config.PrivateKeyImportConfirmed {
+		backend.LogError("applyPrivateKeyImport", "import of peer ID %s is pending confirmation\n", hex.EncodeToString(publicKey.SerializeCompressed()))
+		return nil
+	}
+
+	backupFolder := filepath.Join(backend.DataLayout.DataFolder, "Backup "+time.Now().UTC().Format("2006-01-02 150405"))
+	if err = os.MkdirAll(backupFolder, 0700); err != nil {
+		return err
+	}
+
+	if backend.Config.PrivateKey != "" {
+		if err = ioutil.WriteFile(filepath.Join(backupFolder, "Private Key.txt"), []byte(backend.Config.PrivateKey), 0600); err != nil {
+			return err
+		}
+	}
+
+	owner, found, err := blockchain.ReadOwner(backend.Config.StoreBackend, backend.DataLayout.BlockchainMain)
+	if err != nil {
+		return err
+	} else if found && !owner.IsEqual(publicKey) {
+		if err = moveFolder(backend.DataLayout.BlockchainMain, filepath.Join(backupFolder, filepath.Base(filepath.Clean(backend.DataLayout.BlockchainMain)))); err != nil {
+			return err
+		}
+	}
+
+	backend.LogError("applyPrivateKeyImport", "private key replaced by imported key of peer ID %s, previous key moved to '%s'\n", hex.EncodeToString(publicKey.SerializeCompressed()), backupFolder)
+
+	backend.Config.PrivateKey = backend.Config.PrivateKeyImport
+	backend.Config.PrivateKeyImport = ""
+	backend.Config.PrivateKeyImportConfirmed = false
+	backend.SaveConfig()
+
+	return nil
+}
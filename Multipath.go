@@ -0,0 +1,216 @@
+/*
+File Username:  Multipath.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Multipath sending of lite packets (file transfer data) for peers that are reachable via multiple local network adapters (for example Ethernet and Wi-Fi).
+This is optional and configured via the config setting MultipathMode:
+
+* Stripe: Each packet is sent via a single path. Paths are selected by their recent load weighted by the median RTT and packet loss from the
+  rolling path statistics, which improves throughput. The load is a count of sent packets that decays with multipathLoadHalfLife, so the
+  selection follows current conditions instead of the lifetime history.
+* Duplicate: Each packet is sent via all paths which improves resilience. The receiver drops duplicates via the lite packet replay window.
+  Sending fails only if it failed on all paths.
+
+A path that fails to send (for example due to full send buffers) is put into exponential backoff and is not used until the backoff expires,
+unless all paths are in backoff. The backoff only reacts to local send errors; it is not a measurement of congestion on the path.
+*/
+
+package core
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Multipath modes
+const (
+	MultipathDisabled  = 0 // Only send via the latest connection.
+	MultipathStripe    = 1 // Distribute packets across paths.
+	MultipathDuplicate = 2 // Send each packet via all paths.
+)
+
+const (
+	multipathBackoffMin   = 250 * time.Millisecond // Initial backoff of a path after a send failure.
+	multipathBackoffMax   = 8 * time.Second        // Max backoff of a path.
+	multipathDefaultRTT   = 100 * time.Millisecond // RTT assumed for paths without measured RTT.
+	multipathLoadHalfLife = 2 * time.Second        // Half-life of the load of a path.
+)
+
+// multipathState is the per-path (connection) send statistics and backoff state. Paths are shared by all senders to the peer, therefore the
+// counters and the backoff are accessed atomically and the load is protected by the mutex.
+type multipathState struct {
+	packetsSent  uint64 // Count of lite packets sent via this path.
+	bytesSent    uint64 // Count of lite packet bytes sent via this path.
+	sendErrors   uint64 // Count of send errors on this path.
+	backoff      int64  // Current backoff as time.Duration. 0 if the path is healthy.
+	backoffUntil int64  // Path is not used until this time, in Unix nanoseconds.
+
+	load        float64   // Count of recently sent packets, decaying with multipathLoadHalfLife.
+	loadUpdated time.Time // Time the load was last decayed.
+	loadMutex   sync.Mutex
+}
+
+// MultipathStats returns the multipath statistics of the connection.
+func (c *Connection) MultipathStats() (packetsSent, bytesSent, sendErrors uint64) {
+	return atomic.LoadUint64(&c.multipath.packetsSent), atomic.LoadUint64(&c.multipath.bytesSent), atomic.LoadUint64(&c.multipath.sendErrors)
+}
+
+// multipathPaths returns one active connection per local network adapter. The latest connection is preferred for its adapter.
+func (peer *PeerInfo) multipathPaths() (paths []*Connection) {
+	peer.RLock()
+	defer peer.RUnlock()
+
+	adapters := make(map[string]int)
+
+	for _, c := range peer.connectionActive {
+		if c.relay != nil {
+			continue
+		}
+
+		adapter := c.Network.address.IP.String()
+		if c.Network.iface != nil {
+			adapter = c.Network.iface.Name
+		}
+
+		if index, ok := adapters[adapter]; ok {
+			if c == peer.connectionLatest {
+				paths[index] = c
+			}
+			continue
+		}
+
+		adapters[adapter] = len(paths)
+		paths = append(paths, c)
+	}
+
+	return paths
+}
+
+// sendMultipath sends the lite packet via multiple paths according to the mode.
+func (peer *PeerInfo) sendMultipath(paths []*Connection, raw []byte, mode int, control bool) (err error) {
+	now := time.Now().UnixNano()
+
+	var healthy []*Connection
+	for _, c := range paths {
+		if atomic.LoadInt64(&c.multipath.backoffUntil) < now {
+			healthy = append(healthy, c)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = paths
+	}
+
+	if mode == MultipathDuplicate {
+		var sent bool
+		for _, c := range healthy {
+			if errPath := peer.sendPath(c, raw, control); errPath == nil {
+				sent = true
+			} else {
+				err = errPath
+			}
+		}
+		if sent {
+			return nil
+		}
+		return err
+	}
+
+	// Stripe: Select the path with the lowest weighted load. The weight is the RTT and loss, so faster paths receive more packets.
+	var selected *Connection
+	var selectedScore float64
+
+	for _, c := range healthy {
+		score := (c.multipath.addLoad(0) + 1) * c.multipathWeight()
+		if selected == nil || score < selectedScore {
+			selected, selectedScore = c, score
+		}
+	}
+
+	if err = peer.sendPath(selected, raw, control); err != nil {
+		// Fallback to any other path.
+		for _, c := range healthy {
+			if c != selected && peer.sendPath(c, raw, control) == nil {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+// multipathWeight returns the weight of the path for striping based on the rolling path statistics. Lower is better.
+func (c *Connection) multipathWeight() float64 {
+	stats := c.PathStats()
+
+	rtt := stats.RTTP50
+	if stats.Samples == 0 {
+		rtt = c.RoundTripTime
+	}
+	if rtt <= 0 {
+		rtt = multipathDefaultRTT
+	}
+
+	// Same loss weighting as pathScore.
+	return rtt.Seconds() * (1 + 4*stats.Loss)
+}
+
+// addLoad decays the load of the path and adds the count of packets. It returns the new load.
+func (state *multipathState) addLoad(packets float64) (load float64) {
+	now := time.Now()
+
+	state.loadMutex.Lock()
+	defer state.loadMutex.Unlock()
+
+	if !state.loadUpdated.IsZero() {
+		state.load *= math.Exp2(-now.Sub(state.loadUpdated).Seconds() / multipathLoadHalfLife.Seconds())
+	}
+	state.loadUpdated = now
+	state.load += packets
+
+	return state.load
+}
+
+// sendPath sends the lite packet via the connection and updates the path statistics and backoff.
+func (peer *PeerInfo) sendPath(c *Connection, raw []byte, control bool) (err error) {
+	if err = c.sendLite(raw, control); err != nil {
+		atomic.AddUint64(&c.multipath.sendErrors, 1)
+
+		if IsNetworkErrorFatal(err) {
+			peer.invalidateActiveConnection(c)
+			return err
+		}
+
+		c.multipath.increaseBackoff()
+
+		return err
+	}
+
+	atomic.StoreInt64(&c.multipath.backoff, 0)
+	atomic.AddUint64(&c.multipath.packetsSent, 1)
+	atomic.AddUint64(&c.multipath.bytesSent, uint64(len(raw)))
+	c.multipath.addLoad(1)
+
+	return nil
+}
+
+// increaseBackoff doubles the backoff of the path within the limits and sets the time until the path is not used.
+func (state *multipathState) increaseBackoff() {
+	for {
+		old := atomic.LoadInt64(&state.backoff)
+
+		backoff := time.Duration(old) * 2
+		if backoff < multipathBackoffMin {
+			backoff = multipathBackoffMin
+		} else if backoff > multipathBackoffMax {
+			backoff = multipathBackoffMax
+		}
+
+		if atomic.CompareAndSwapInt64(&state.backoff, old, int64(backoff)) {
+			atomic.StoreInt64(&state.backoffUntil, time.Now().Add(backoff).UnixNano())
+			return
+		}
+	}
+}
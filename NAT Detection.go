@@ -0,0 +1,307 @@
+/*
+File Username:  NAT Detection.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Active NAT behavior tests classify the local NAT type using connected helper peers:
+
+1. Mapping: Multiple helper peers with different IPs report the IP:Port they observe. If it matches the local listening address, there is no NAT.
+   If the observed port differs between helpers, the NAT uses endpoint-dependent mapping (symmetric NAT).
+2. Filtering: A helper is asked to have another peer (which was never contacted) send an unsolicited reply to the observed address.
+   If it is received, the NAT does not filter incoming packets (full cone). Otherwise it is a restricted cone NAT.
+
+The detected type is advertised in the feature bits and used to decide between direct contact, Traverse, and relay-only strategies.
+*/
+
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// NAT types
+const (
+	NATUnknown    = iota // Not yet detected or not enough helper peers available.
+	NATNone              // No NAT, or the port is forwarded. Unsolicited incoming packets are received.
+	NATFullCone          // Endpoint-independent mapping and filtering. Unsolicited incoming packets are received.
+	NATRestricted        // Endpoint-independent mapping, incoming packets are filtered. Hole punching via Traverse works.
+	NATSymmetric         // Endpoint-dependent mapping. Hole punching is unlikely to succeed.
+)
+
+// NAT traversal strategies for contacting peers for the first time
+const (
+	natStrategyDirect   = iota // Send directly only.
+	natStrategyTraverse        // Send directly and via Traverse message.
+	natStrategyRelay           // Send only via Traverse message. Direct packets are dropped by the remote NAT.
+)
+
+const natDetectionStartDelay = 2 * time.Minute // Delay after start before the first detection, so that enough peers are connected.
+const natDetectionInterval = 30 * time.Minute  // Interval to repeat the detection. The NAT or network may change.
+const natProbeTimeout = 5 * time.Second        // Timeout for replies.
+const natProbeHelpers = 3                      // Max count of helper peers for the mapping test.
+const natProbeForwardLimit = 10                // Max count of forwarded probes handled per minute, which limits abuse for reflection.
+
+// natDetection contains the NAT detection results.
+type natDetection struct {
+	natType      int                             // Detected NAT type. See NATX.
+	externalIP   net.IP                          // External IP as observed by helper peers.
+	externalPort uint16                          // External port as observed by helper peers.
+	detected     time.Time                       // Time of the last detection.
+	pending      map[uint64]chan *natProbeResult // Pending probes by ID.
+	forwardCount int                             // Count of forwarded probes handled in the current minute.
+	forwardReset time.Time                       // When forwardCount is reset.
+	sync.Mutex
+}
+
+// natProbeResult is a reply to a probe.
+type natProbeResult struct {
+	ip     net.IP // Observed IP of the requester.
+	port   uint16 // Observed port of the requester.
+	sender net.IP // IP of the peer who sent the reply.
+}
+
+func (backend *Backend) initNATDetection() {
+	backend.natDetection = &natDetection{pending: make(map[uint64]chan *natProbeResult)}
+}
+
+// NATType returns the detected NAT type (see NATX) and the external address as observed by other peers.
+func (backend *Backend) NATType() (natType int, externalIP net.IP, externalPort uint16, detected time.Time) {
+	backend.natDetection.Lock()
+	defer backend.natDetection.Unlock()
+
+	return backend.natDetection.natType, backend.natDetection.externalIP, backend.natDetection.externalPort, backend.natDetection.detected
+}
+
+// natHelper is a peer used for testing, along with the connection.
+type natHelper struct {
+	peer       *PeerInfo
+	connection *Connection
+}
+
+// natHelpers returns helper peers with public IPv4 connections via the same local network and different remote IPs.
+func (backend *Backend) natHelpers() (helpers []natHelper) {
+	byNetwork := make(map[*Network][]natHelper)
+	ips := make(map[string]struct{})
+
+	for _, peer := range backend.PeerlistGet() {
+		connection := peer.GetConnection2Share(false, true, false)
+		if connection == nil {
+			continue
+		} else if _, ok := ips[connection.Address.IP.String()]; ok {
+			continue
+		}
+
+		ips[connection.Address.IP.String()] = struct{}{}
+		byNetwork[connection.Network] = append(byNetwork[connection.Network], natHelper{peer: peer, connection: connection})
+	}
+
+	for _, list := range byNetwork {
+		if len(list) > len(helpers) {
+			helpers = list
+		}
+	}
+
+	if len(helpers) > natProbeHelpers {
+		rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+		helpers = helpers[:natProbeHelpers]
+	}
+
+	return helpers
+}
+
+// natProbe sends a probe to the helper and waits for the reply. Nil if no reply was received.
+func (backend *Backend) natProbe(helper natHelper, action uint8) (result *natProbeResult) {
+	probeID := rand.Uint64()
+	resultChan := make(chan *natProbeResult, 1)
+
+	backend.natDetection.Lock()
+	backend.natDetection.pending[probeID] = resultChan
+	backend.natDetection.Unlock()
+
+	defer func() {
+		backend.natDetection.Lock()
+		delete(backend.natDetection.pending, probeID)
+		backend.natDetection.Unlock()
+	}()
+
+	helper.peer.sendConnection(&protocol.PacketRaw{Command: protocol.CommandNATProbe, Payload: protocol.EncodeNATProbe(action, probeID, nil, 0, nil)}, helper.connection)
+
+	select {
+	case result = <-resultChan:
+		return result
+	case <-time.After(natProbeTimeout):
+		return nil
+	}
+}
+
+var errNATHelpers = errors.New("not enough helper peers")
+
+// natDetect runs the NAT behavior tests and stores the result. If there are not enough helper peers, the previous result remains.
+// It is run by the scheduler.
+func (backend *Backend) natDetect() (err error) {
+	helpers := backend.natHelpers()
+	if len(helpers) < 2 {
+		return errNATHelpers
+	}
+
+	var results []*natProbeResult
+	for _, helper := range helpers {
+		if result := backend.natProbe(helper, protocol.NATProbeRequest); result != nil {
+			results = append(results, result)
+		}
+	}
+	if len(results) < 2 {
+		return errNATHelpers
+	}
+
+	natType := NATRestricted
+	network := helpers[0].connection.Network
+	_, portForward := network.SelfReportedPorts()
+
+	isLocal := true
+	isConsistent := true
+	for _, result := range results {
+		if !result.ip.Equal(network.address.IP) || int(result.port) != network.address.Port {
+			isLocal = false
+		}
+		if !result.ip.Equal(results[0].ip) || result.port != results[0].port {
+			isConsistent = false
+		}
+	}
+
+	switch {
+	case isLocal || (isConsistent && portForward > 0 && results[0].port == portForward):
+		natType = NATNone
+
+	case !isConsistent:
+		natType = NATSymmetric
+
+	default:
+		// Filtering test. Replies from peers with known IPs are not conclusive, since the NAT may have a mapping for them.
+		knownIPs := make(map[string]struct{})
+		for _, peer := range backend.PeerlistGet() {
+			for _, connection := range peer.GetConnections(true) {
+				knownIPs[connection.Address.IP.String()] = struct{}{}
+			}
+		}
+
+		if result := backend.natProbe(helpers[0], protocol.NATProbeRequestForward); result != nil {
+			if _, known := knownIPs[result.sender.String()]; !known {
+				natType = NATFullCone
+			}
+		}
+	}
+
+	backend.natDetection.Lock()
+	backend.natDetection.natType = natType
+	backend.natDetection.externalIP = results[0].ip
+	backend.natDetection.externalPort = results[0].port
+	backend.natDetection.detected = time.Now()
+	backend.natDetection.Unlock()
+
+	return nil
+}
+
+// natForwardAllowed checks the rate limit for forwarded probes.
+func (backend *Backend) natForwardAllowed() bool {
+	backend.natDetection.Lock()
+	defer backend.natDetection.Unlock()
+
+	if now := time.Now(); now.After(backend.natDetection.forwardReset) {
+		backend.natDetection.forwardCount = 0
+		backend.natDetection.forwardReset = now.Add(time.Minute)
+	}
+
+	backend.natDetection.forwardCount++
+	return backend.natDetection.forwardCount <= natProbeForwardLimit
+}
+
+// cmdNATProbe handles an incoming NAT probe message.
+func (peer *PeerInfo) cmdNATProbe(msg *protocol.MessageNATProbe, connection *Connection) {
+	backend := peer.Backend
+
+	switch msg.Action {
+	case protocol.NATProbeRequest:
+		payload := protocol.EncodeNATProbe(protocol.NATProbeReply, msg.ProbeID, connection.Address.IP, uint16(connection.Address.Port), nil)
+		peer.sendConnection(&protocol.PacketRaw{Command: protocol.CommandNATProbe, Payload: payload}, connection)
+
+	case protocol.NATProbeReply:
+		backend.natDetection.Lock()
+		resultChan := backend.natDetection.pending[msg.ProbeID]
+		backend.natDetection.Unlock()
+
+		if resultChan != nil {
+			select {
+			case resultChan <- &natProbeResult{ip: msg.IP, port: msg.Port, sender: connection.Address.IP}:
+			default:
+			}
+		}
+
+	case protocol.NATProbeRequestForward:
+		if !backend.natForwardAllowed() {
+			return
+		}
+
+		// Select another peer with a different IP using the same IP family. The observed address is used, which prevents sending replies to arbitrary targets.
+		isIPv4 := connection.IsIPv4()
+		for _, helper := range backend.PeerlistGet() {
+			if helper == peer {
+				continue
+			}
+
+			helperConnection := helper.GetConnection2Share(false, isIPv4, !isIPv4)
+			if helperConnection == nil || helperConnection.Address.IP.Equal(connection.Address.IP) {
+				continue
+			}
+
+			payload := protocol.EncodeNATProbe(protocol.NATProbeForwarded, msg.ProbeID, connection.Address.IP, uint16(connection.Address.Port), peer.PublicKey)
+			helper.sendConnection(&protocol.PacketRaw{Command: protocol.CommandNATProbe, Payload: payload}, helperConnection)
+			return
+		}
+
+	case protocol.NATProbeForwarded:
+		if !backend.natForwardAllowed() || msg.Port == 0 || msg.Requester.IsEqual(backend.PeerPublicKey) {
+			return
+		}
+
+		payload := protocol.EncodeNATProbe(protocol.NATProbeReply, msg.ProbeID, msg.IP, msg.Port, nil)
+		backend.networks.sendAllNetworks(msg.Requester, &protocol.PacketRaw{Command: protocol.CommandNATProbe, Payload: payload}, &net.UDPAddr{IP: msg.IP, Port: int(msg.Port)}, 0, 0, nil, nil)
+	}
+}
+
+// natFeatures returns the feature bits for the detected NAT type.
+func (backend *Backend) natFeatures() (feature byte) {
+	natType, _, _, _ := backend.NATType()
+
+	switch natType {
+	case NATFullCone:
+		feature |= 1 << protocol.FeatureNATFullCone
+	case NATSymmetric:
+		feature |= 1 << protocol.FeatureNATSymmetric
+	}
+
+	return feature
+}
+
+// natStrategy decides how to contact a peer for the first time based on its reported NAT features and the local NAT type.
+func (c *Connection) natStrategy() int {
+	if !c.IsBehindNAT() && !c.Firewall {
+		return natStrategyDirect
+	} else if c.features&(1<<protocol.FeatureNATFullCone) > 0 && !c.Firewall {
+		return natStrategyDirect
+	}
+
+	// If the remote NAT is symmetric, the direct packet is dropped since the remote NAT has no mapping for it.
+	// Only the Traverse message can instruct the remote peer to contact this one, which only works if the local NAT does not filter.
+	if c.features&(1<<protocol.FeatureNATSymmetric) > 0 {
+		return natStrategyRelay
+	}
+
+	return natStrategyTraverse
+}
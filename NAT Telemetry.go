@@ -0,0 +1,251 @@
+/*
+File Username:  NAT Telemetry.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Hole punching telemetry. Each first contact to a peer that may require NAT traversal is recorded as an attempt of the chosen strategy:
+Direct contact only, direct contact and Traverse message, or Traverse message only (the remote peer connects back via its own NAT).
+The attempt succeeds if the peer is added to the peer list within natAttemptTimeout. The success rates are kept per combination of the local
+NAT type and the NAT type of the remote peer (derived from its feature bits).
+
+Future first contacts use the strategy with the highest estimated success probability for the combination. The strategy chosen by the static
+rules in natStrategy has a higher prior, so it is used until enough attempts were recorded. If a strategy succeeded for the same peer before,
+it is used again.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// natAttemptTimeout is the time within which a contacted peer must be added to the peer list for the attempt to count as success.
+const natAttemptTimeout = 15 * time.Second
+
+// natTelemetryWindow is the count of attempts after which the counters of a strategy are halved, so the rates reflect the recent history.
+const natTelemetryWindow = 256
+
+// natTelemetryPeers is the max count of peers for which the last successful strategy is kept.
+const natTelemetryPeers = 4096
+
+// natTelemetryPeerExpiry is the time after which the last successful strategy for a peer is no longer used.
+const natTelemetryPeerExpiry = 24 * time.Hour
+
+// Prior successes out of natPriorAttempts for the strategy chosen by the static rules and for all others.
+const (
+	natPriorAttempts = 4
+	natPriorDefault  = 3
+	natPriorOther    = 1
+)
+
+// natStrategyCount is the count of NAT traversal strategies, see natStrategyX.
+const natStrategyCount = 3
+
+// natTypeCount is the count of NAT types, see NATX.
+const natTypeCount = 5
+
+// NATTelemetryStat contains the success rate of a NAT traversal strategy for a combination of local and remote NAT type.
+type NATTelemetryStat struct {
+	LocalNAT  int    // Local NAT type, see NATX.
+	RemoteNAT int    // NAT type of the remote peer as derived from its feature bits, see NATX. NATUnknown is not used.
+	Strategy  string // Strategy: "direct", "traverse" (direct and Traverse message), or "relay" (Traverse message only).
+	Attempts  uint32 // Count of recent attempts.
+	Successes uint32 // Count of recent successful attempts.
+}
+
+type natCounter struct {
+	attempts  uint32
+	successes uint32
+}
+
+type natAttempt struct {
+	strategy  int       // Strategy used.
+	localNAT  int       // Local NAT type at the time of the attempt.
+	remoteNAT int       // Remote NAT type.
+	sent      time.Time // When the first packet was sent.
+}
+
+type natPeerStrategy struct {
+	strategy  int       // Strategy that succeeded.
+	succeeded time.Time // When it succeeded.
+}
+
+type natTelemetry struct {
+	counters [natTypeCount][natTypeCount][natStrategyCount]natCounter // Local NAT type -> remote NAT type -> strategy
+	pending  map[[btcec.PubKeyBytesLenCompressed]byte]natAttempt      // Pending attempts by peer.
+	peers    map[[btcec.PubKeyBytesLenCompressed]byte]natPeerStrategy // Last successful strategy by peer.
+	sync.Mutex
+}
+
+func (backend *Backend) initNATTelemetry() {
+	backend.natTelemetry = &natTelemetry{
+		pending: make(map[[btcec.PubKeyBytesLenCompressed]byte]natAttempt),
+		peers:   make(map[[btcec.PubKeyBytesLenCompressed]byte]natPeerStrategy),
+	}
+}
+
+// natStrategyName returns the name of the strategy as used in NATTelemetryStat.
+func natStrategyName(strategy int) string {
+	switch strategy {
+	case natStrategyDirect:
+		return "direct"
+	case natStrategyTraverse:
+		return "traverse"
+	default:
+		return "relay"
+	}
+}
+
+// remoteNATType derives the NAT type of the remote peer from the reported feature bits and the connection details.
+func (c *Connection) remoteNATType() int {
+	switch {
+	case c.features&(1<<protocol.FeatureNATSymmetric) > 0:
+		return NATSymmetric
+	case c.features&(1<<protocol.FeatureNATFullCone) > 0:
+		return NATFullCone
+	case c.IsBehindNAT() || c.Firewall:
+		return NATRestricted
+	default:
+		return NATNone
+	}
+}
+
+// natStrategySelect returns the strategy with the highest estimated success probability to contact the peer for the first time.
+func (c *Connection) natStrategySelect(receiverPublicKey *btcec.PublicKey) (strategy int) {
+	strategyDefault := c.natStrategy()
+	localNAT, _, _, _ := c.backend.NATType()
+	remoteNAT := c.remoteNATType()
+	key := publicKey2Compressed(receiverPublicKey)
+
+	telemetry := c.backend.natTelemetry
+	telemetry.Lock()
+	defer telemetry.Unlock()
+
+	if known, ok := telemetry.peers[key]; ok && time.Since(known.succeeded) < natTelemetryPeerExpiry {
+		return known.strategy
+	}
+
+	strategy = strategyDefault
+	var bestScore float64
+
+	for option := 0; option < natStrategyCount; option++ {
+		prior := natPriorOther
+		if option == strategyDefault {
+			prior = natPriorDefault
+		}
+
+		counter := telemetry.counters[localNAT][remoteNAT][option]
+		score := float64(counter.successes+uint32(prior)) / float64(counter.attempts+natPriorAttempts)
+
+		if score > bestScore || (score == bestScore && option == strategyDefault) {
+			strategy, bestScore = option, score
+		}
+	}
+
+	return strategy
+}
+
+// natAttemptStart records the first contact to a peer using the strategy. Only the first packet of an attempt is recorded.
+func (c *Connection) natAttemptStart(receiverPublicKey *btcec.PublicKey, strategy int) {
+	localNAT, _, _, _ := c.backend.NATType()
+	key := publicKey2Compressed(receiverPublicKey)
+
+	telemetry := c.backend.natTelemetry
+	telemetry.Lock()
+	defer telemetry.Unlock()
+
+	if _, ok := telemetry.pending[key]; ok {
+		return
+	}
+
+	telemetry.pending[key] = natAttempt{strategy: strategy, localNAT: localNAT, remoteNAT: c.remoteNATType(), sent: time.Now()}
+}
+
+// natAttemptSuccess is called when a peer is added to the peer list. A pending attempt to contact it is recorded as successful.
+func (backend *Backend) natAttemptSuccess(publicKey *btcec.PublicKey) {
+	key := publicKey2Compressed(publicKey)
+
+	telemetry := backend.natTelemetry
+	telemetry.Lock()
+	defer telemetry.Unlock()
+
+	attempt, ok := telemetry.pending[key]
+	if !ok {
+		return
+	}
+	delete(telemetry.pending, key)
+
+	telemetry.record(attempt, true)
+
+	if _, ok := telemetry.peers[key]; !ok && len(telemetry.peers) >= natTelemetryPeers {
+		for other := range telemetry.peers {
+			delete(telemetry.peers, other)
+			break
+		}
+	}
+	telemetry.peers[key] = natPeerStrategy{strategy: attempt.strategy, succeeded: time.Now()}
+}
+
+// expireNATAttempts records pending attempts without success within natAttemptTimeout as failed.
+func (backend *Backend) expireNATAttempts() error {
+	threshold := time.Now().Add(-natAttemptTimeout)
+
+	telemetry := backend.natTelemetry
+	telemetry.Lock()
+	defer telemetry.Unlock()
+
+	for key, attempt := range telemetry.pending {
+		if attempt.sent.Before(threshold) {
+			delete(telemetry.pending, key)
+			telemetry.record(attempt, false)
+		}
+	}
+
+	for key, known := range telemetry.peers {
+		if time.Since(known.succeeded) >= natTelemetryPeerExpiry {
+			delete(telemetry.peers, key)
+		}
+	}
+
+	return nil
+}
+
+// record counts the attempt. The caller must hold the lock.
+func (telemetry *natTelemetry) record(attempt natAttempt, success bool) {
+	counter := &telemetry.counters[attempt.localNAT][attempt.remoteNAT][attempt.strategy]
+	counter.attempts++
+	if success {
+		counter.successes++
+	}
+
+	if counter.attempts >= natTelemetryWindow {
+		counter.attempts /= 2
+		counter.successes /= 2
+	}
+}
+
+// NATTelemetry returns the success rates of all strategies for all combinations of local and remote NAT types that were attempted.
+func (backend *Backend) NATTelemetry() (stats []NATTelemetryStat) {
+	telemetry := backend.natTelemetry
+	telemetry.Lock()
+	defer telemetry.Unlock()
+
+	for localNAT := 0; localNAT < natTypeCount; localNAT++ {
+		for remoteNAT := 0; remoteNAT < natTypeCount; remoteNAT++ {
+			for strategy := 0; strategy < natStrategyCount; strategy++ {
+				counter := telemetry.counters[localNAT][remoteNAT][strategy]
+				if counter.attempts == 0 {
+					continue
+				}
+
+				stats = append(stats, NATTelemetryStat{LocalNAT: localNAT, RemoteNAT: remoteNAT, Strategy: natStrategyName(strategy), Attempts: counter.attempts, Successes: counter.successes})
+			}
+		}
+	}
+
+	return stats
+}
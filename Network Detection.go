@@ -92,15 +92,26 @@ func IsNetworkErrorFatal(err error) bool {
 // changeMonitorFrequency is the frequency in seconds to check for a network change
 const changeMonitorFrequency = 10
 
-// networkChangeMonitor() monitors for network changes to act accordingly
+// networkChangeMonitor() monitors for network changes to act accordingly.
+// The network adapters are checked regularly, and immediately when the operating system reports a change (where supported, see networkEventsWatch).
 func (nets *Networks) networkChangeMonitor() {
 	// If manual IPs are entered, no need for monitoring for any network changes.
 	if len(nets.backend.Config.Listen) > 0 {
 		return
 	}
 
+	nets.changeEvents = make(chan struct{}, 1)
+	go nets.networkEventsWatch()
+
 	for {
-		time.Sleep(time.Second * changeMonitorFrequency)
+		select {
+		case <-time.After(time.Second * changeMonitorFrequency):
+		case <-nets.changeEvents:
+			// Wait shortly, since the operating system typically reports multiple events for a single change.
+			time.Sleep(time.Second)
+		}
+
+		changed := false
 
 		interfaceList, err := net.Interfaces()
 		if err != nil {
@@ -122,6 +133,7 @@ func (nets *Networks) networkChangeMonitor() {
 			addressesExist, ok := nets.ipListen.ifacesExist[iface.Name]
 			if !ok {
 				nets.networkChangeInterfaceNew(iface, addressesNew)
+				changed = true
 			} else {
 				// new IPs added for this interface?
 				for _, addr := range addressesNew {
@@ -135,6 +147,7 @@ func (nets *Networks) networkChangeMonitor() {
 
 					if !exists {
 						nets.networkChangeIPNew(iface, addr)
+						changed = true
 					}
 				}
 
@@ -150,6 +163,7 @@ func (nets *Networks) networkChangeMonitor() {
 
 					if removed {
 						nets.networkChangeIPRemove(iface, exist)
+						changed = true
 					}
 				}
 			}
@@ -159,10 +173,26 @@ func (nets *Networks) networkChangeMonitor() {
 		for ifaceExist, addressesExist := range nets.ipListen.ifacesExist {
 			if _, ok := ifacesNew[ifaceExist]; !ok {
 				nets.networkChangeInterfaceRemove(ifaceExist, addressesExist)
+				changed = true
 			}
 		}
 
 		nets.ipListen.ifacesExist = ifacesNew
+
+		nets.ipv6RotationRelease()
+
+		// Peers in the new network are discovered immediately. Root peers that are no longer connected are contacted again.
+		if changed {
+			nets.backend.Rediscover()
+		}
+	}
+}
+
+// networkChangeNotify is called when the operating system reports a network change. It triggers an immediate check of the network adapters.
+func (nets *Networks) networkChangeNotify() {
+	select {
+	case nets.changeEvents <- struct{}{}:
+	default:
 	}
 }
 
@@ -189,6 +219,7 @@ func (nets *Networks) networkChangeInterfaceRemove(iface string, addresses []net
 	for n, network := range nets.networks6 {
 		if network.iface != nil && network.iface.Name == iface {
 			network.Terminate()
+			go nets.migrateConnections(network, nil)
 
 			// remove from list
 			networksNew := nets.networks6[:n]
@@ -202,6 +233,7 @@ func (nets *Networks) networkChangeInterfaceRemove(iface string, addresses []net
 	for n, network := range nets.networks4 {
 		if network.iface != nil && network.iface.Name == iface {
 			network.Terminate()
+			go nets.migrateConnections(network, nil)
 
 			// remove from list
 			networksNew := nets.networks4[:n]
@@ -223,6 +255,10 @@ func (nets *Networks) networkChangeIPNew(iface net.Interface, address net.Addr)
 		go network.upnpAuto()
 	}
 
+	if len(networksNew) > 0 {
+		nets.ipv6RotationDetect(iface, address)
+	}
+
 	go nets.backend.nodesDHT.RefreshBuckets(0)
 }
 
@@ -236,6 +272,7 @@ func (nets *Networks) networkChangeIPRemove(iface net.Interface, address net.Add
 	for n, network := range nets.networks6 {
 		if network.address.IP.Equal(address.(*net.IPNet).IP) {
 			network.Terminate()
+			go nets.migrateConnections(network, nil)
 
 			// remove from list
 			networksNew := nets.networks6[:n]
@@ -249,6 +286,7 @@ func (nets *Networks) networkChangeIPRemove(iface net.Interface, address net.Add
 	for n, network := range nets.networks4 {
 		if network.address.IP.Equal(address.(*net.IPNet).IP) {
 			network.Terminate()
+			go nets.migrateConnections(network, nil)
 
 			// remove from list
 			networksNew := nets.networks4[:n]
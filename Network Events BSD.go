@@ -0,0 +1,44 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+/*
+File Username:  Network Events BSD.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import "golang.org/x/sys/unix"
+
+// networkEventsWatch listens for interface and address changes via a routing socket. If not available, the network adapters are only checked regularly.
+func (nets *Networks) networkEventsWatch() {
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+
+	buffer := make([]byte, 4096)
+
+	for {
+		n, err := unix.Read(fd, buffer)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return
+		}
+
+		// Routing message header: length (2 bytes), version (1 byte), type (1 byte). Route changes are ignored.
+		if n >= 4 {
+			switch buffer[3] {
+			case unix.RTM_NEWADDR, unix.RTM_DELADDR, unix.RTM_IFINFO:
+				nets.networkChangeNotify()
+			}
+		}
+	}
+}
+
+// resumeEventsWatch is not needed on this platform. Resume is detected via the clocks.
+func (backend *Backend) resumeEventsWatch() {
+}
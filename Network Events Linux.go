@@ -0,0 +1,46 @@
+//go:build linux
+// +build linux
+
+/*
+File Username:  Network Events Linux.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import "golang.org/x/sys/unix"
+
+// networkEventsWatch listens for link and address changes via netlink. If netlink is not available, the network adapters are only checked regularly.
+func (nets *Networks) networkEventsWatch() {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR}); err != nil {
+		return
+	}
+
+	buffer := make([]byte, 8192)
+
+	for {
+		n, _, err := unix.Recvfrom(fd, buffer, 0)
+		if err == unix.EINTR || err == unix.ENOBUFS {
+			// ENOBUFS indicates that messages were dropped, which means there were changes.
+			nets.networkChangeNotify()
+			continue
+		} else if err != nil {
+			return
+		}
+
+		if n > 0 {
+			nets.networkChangeNotify()
+		}
+	}
+}
+
+// resumeEventsWatch is not needed on Linux. Resume is detected via the clocks.
+func (backend *Backend) resumeEventsWatch() {
+}
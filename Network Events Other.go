@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+/*
+File Username:  Network Events Other.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+// networkEventsWatch is not supported on this platform. The network adapters are only checked regularly.
+func (nets *Networks) networkEventsWatch() {
+}
+
+// resumeEventsWatch is not supported on this platform. Resume is detected via the clocks.
+func (backend *Backend) resumeEventsWatch() {
+}
@@ -0,0 +1,79 @@
+//go:build windows
+// +build windows
+
+/*
+File Username:  Network Events Windows.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modIPHelper                                = windows.NewLazySystemDLL("iphlpapi.dll")
+	procNotifyAddrChange                       = modIPHelper.NewProc("NotifyAddrChange")
+	modPowerProfile                            = windows.NewLazySystemDLL("powrprof.dll")
+	procPowerRegisterSuspendResumeNotification = modPowerProfile.NewProc("PowerRegisterSuspendResumeNotification")
+)
+
+// Power event types. See https://learn.microsoft.com/en-us/windows/win32/power/wm-powerbroadcast.
+const (
+	pbtAPMResumeAutomatic = 0x0012 // Resumed after suspend.
+	deviceNotifyCallback  = 2      // DEVICE_NOTIFY_CALLBACK
+)
+
+// deviceNotifySubscribeParameters is the structure DEVICE_NOTIFY_SUBSCRIBE_PARAMETERS.
+type deviceNotifySubscribeParameters struct {
+	callback uintptr
+	context  uintptr
+}
+
+// networkEventsWatch waits for IPv4 address changes via NotifyAddrChange. If not available, the network adapters are only checked regularly.
+func (nets *Networks) networkEventsWatch() {
+	if procNotifyAddrChange.Find() != nil {
+		return
+	}
+
+	for {
+		// Called without handle and overlapped structure, NotifyAddrChange blocks until an address changes.
+		if result, _, _ := procNotifyAddrChange.Call(0, 0); result != 0 {
+			return
+		}
+
+		nets.networkChangeNotify()
+	}
+}
+
+// resumeEventsWatch registers for the power notification that the system resumed from sleep. The monotonic clock on Windows includes the sleep time,
+// therefore the clocks cannot be used to detect it.
+func (backend *Backend) resumeEventsWatch() {
+	if procPowerRegisterSuspendResumeNotification.Find() != nil {
+		return
+	}
+
+	callback := windows.NewCallback(func(context, changeType, setting uintptr) uintptr {
+		if changeType == pbtAPMResumeAutomatic {
+			go backend.NetworkResume()
+		}
+		return 0
+	})
+
+	parameters := &deviceNotifySubscribeParameters{callback: callback}
+	var handle uintptr
+
+	if result, _, _ := procPowerRegisterSuspendResumeNotification.Call(deviceNotifyCallback, uintptr(unsafe.Pointer(parameters)), uintptr(unsafe.Pointer(&handle))); result != 0 {
+		backend.LogError("resumeEventsWatch", "registering for power notifications failed: error %d\n", result)
+		return
+	}
+
+	// The parameters are used by the operating system for the lifetime of the registration and must not be garbage collected.
+	backend.networkResume.Lock()
+	backend.networkResume.notification = parameters
+	backend.networkResume.Unlock()
+}
@@ -0,0 +1,135 @@
+/*
+File Username:  Network IPv6 Rotation.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Operating systems regularly rotate IPv6 temporary (privacy) addresses. A new address in the same prefix is added, and the old one is deprecated
+and removed later. Long transfers would break if the old address is removed while in use.
+
+When a rotation is detected, the network of the old address is marked as deprecated:
+* New contacts are only made via the new address.
+* Peers with active transfers keep using the old address (pinned) while it is still available.
+* All other peers are migrated to the new address immediately. Once no transfers remain, the old network is closed.
+If the old address is removed by the OS, all remaining peers are migrated automatically.
+*/
+
+package core
+
+import (
+	"net"
+	"time"
+)
+
+// ipv6RotationPrefixBits is the prefix length that temporary addresses of the same network share.
+const ipv6RotationPrefixBits = 64
+
+// ipv6RotationDetect checks if the new IPv6 address is a rotation of an existing one on the same interface and marks the old network as deprecated.
+func (nets *Networks) ipv6RotationDetect(iface net.Interface, address net.Addr) {
+	ipnet, ok := address.(*net.IPNet)
+	if !ok || !IsIPv6(ipnet.IP) || ipnet.IP.IsLinkLocalUnicast() || ipnet.IP.IsLoopback() {
+		return
+	}
+
+	prefix := net.CIDRMask(ipv6RotationPrefixBits, 128)
+
+	nets.Lock()
+	defer nets.Unlock()
+
+	for _, network := range nets.networks6 {
+		if network.iface == nil || network.iface.Name != iface.Name || network.address.IP.Equal(ipnet.IP) || !network.deprecated.IsZero() {
+			continue
+		}
+
+		if network.address.IP.Mask(prefix).Equal(ipnet.IP.Mask(prefix)) {
+			network.deprecated = time.Now()
+			nets.backend.LogError("ipv6RotationDetect", "IPv6 address rotation on interface '%s': %s replaced by %s\n", iface.Name, network.address.IP.String(), ipnet.IP.String())
+		}
+	}
+}
+
+// networksCurrent returns the networks without deprecated ones, unless all are deprecated. New contacts shall only use current addresses.
+func (nets *Networks) networksCurrent(networks []*Network) (current []*Network) {
+	for _, network := range networks {
+		if network.deprecated.IsZero() {
+			current = append(current, network)
+		}
+	}
+
+	if len(current) == 0 {
+		return networks
+	}
+
+	return current
+}
+
+// ipv6RotationRelease migrates peers without active transfers away from deprecated networks and closes those networks once no transfers remain.
+func (nets *Networks) ipv6RotationRelease() {
+	nets.RLock()
+	var deprecated []*Network
+	for _, network := range nets.networks6 {
+		if !network.deprecated.IsZero() {
+			deprecated = append(deprecated, network)
+		}
+	}
+	nets.RUnlock()
+
+	if len(deprecated) == 0 {
+		return
+	}
+
+	pinned := nets.backend.peersActiveTransfer()
+
+	for _, network := range deprecated {
+		if nets.migrateConnections(network, pinned) > 0 {
+			continue
+		}
+
+		nets.backend.LogError("ipv6RotationRelease", "closing deprecated IPv6 address %s\n", network.address.IP.String())
+		network.Terminate()
+
+		nets.Lock()
+		for n := range nets.networks6 {
+			if nets.networks6[n] == network {
+				nets.networks6 = append(nets.networks6[:n:n], nets.networks6[n+1:]...)
+				break
+			}
+		}
+		nets.Unlock()
+	}
+}
+
+// peersActiveTransfer returns the peers with active file transfers or block transfers.
+func (backend *Backend) peersActiveTransfer() (peers map[*PeerInfo]struct{}) {
+	peers = make(map[*PeerInfo]struct{})
+
+	for _, session := range backend.LiteSessions() {
+		if virtualConn, ok := session.Data.(*VirtualPacketConn); ok && virtualConn.Peer != nil && !virtualConn.IsTerminated() {
+			peers[virtualConn.Peer] = struct{}{}
+		}
+	}
+
+	return peers
+}
+
+// migrateConnections invalidates all active connections via the network and contacts the affected peers via the other networks,
+// unless they have another active connection. Peers in the pinned list are skipped; the count of them using the network is returned.
+func (nets *Networks) migrateConnections(network *Network, pinned map[*PeerInfo]struct{}) (countPinned int) {
+	for _, peer := range nets.backend.PeerlistGet() {
+		for _, connection := range peer.GetConnections(true) {
+			if connection.Network != network {
+				continue
+			} else if _, ok := pinned[peer]; ok {
+				countPinned++
+				continue
+			}
+
+			peer.invalidateActiveConnection(connection)
+
+			if !peer.IsConnectionActive() {
+				nets.backend.contactArbitraryPeer(peer.PublicKey, &net.UDPAddr{IP: connection.Address.IP, Port: connection.Address.Port}, connection.PortInternal, peer.Features)
+			}
+		}
+	}
+
+	return countPinned
+}
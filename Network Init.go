@@ -28,6 +28,7 @@ type networkWire struct {
 	receiverPublicKey *btcec.PublicKey // public key associated with the receiver
 	raw               []byte           // buffer
 	unicast           bool             // True if the message was sent via unicast. False if sent via IPv4 broadcast or IPv6 multicast.
+	relay             *relayPath       // Relay-only mode: Path via which the packet was forwarded. Nil if received directly.
 }
 
 // initNetwork sets up the network configuration and starts listening.
@@ -0,0 +1,91 @@
+/*
+File Username:  Network Resume.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Sleep/wake resilience. After the system resumed from sleep, existing connections are likely dead (NAT mappings expired, the network changed)
+and UPnP port mappings may be lost. Instead of waiting for the ping timeouts, all connections are marked stale and pinged immediately, local peer
+discovery and the contact of root peers are re-run, the DHT buckets are refreshed, and UPnP port mappings are re-created.
+
+Resume is detected by comparing the wall clock with the monotonic clock, which does not advance while the system sleeps on Linux and macOS.
+On Windows the monotonic clock includes the sleep time; instead the power notification of the operating system is used (see "Network Events Windows.go").
+Applications that receive resume events themselves (for example a Windows service) may call NetworkResume directly.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	resumeCheckInterval = 10 * time.Second // Interval to compare the clocks.
+	resumeMinSleep      = 30 * time.Second // Min difference between the clocks to consider it a sleep.
+	resumeStaleGrace    = 5 * time.Second  // Time after resume within which connections must reply to a ping to remain active.
+	resumeDebounce      = 30 * time.Second // Resume events within this time after the last one are ignored, since multiple sources may report the same resume.
+)
+
+type networkResume struct {
+	last         time.Time   // Last time a resume was handled.
+	notification interface{} // Platform specific registration for power notifications. It must be kept referenced.
+	sync.Mutex
+}
+
+func (backend *Backend) initNetworkResume() {
+	backend.networkResume = &networkResume{}
+}
+
+// autoResumeDetect detects when the system resumed from sleep.
+func (backend *Backend) autoResumeDetect() {
+	backend.resumeEventsWatch()
+
+	last := time.Now()
+
+	for {
+		time.Sleep(resumeCheckInterval)
+		now := time.Now()
+
+		// Round(0) strips the monotonic clock reading, so that the difference is calculated using the wall clock.
+		if slept := now.Round(0).Sub(last.Round(0)) - now.Sub(last); slept > resumeMinSleep {
+			backend.LogError("autoResumeDetect", "system resumed after sleeping %s\n", slept.Round(time.Second).String())
+			backend.NetworkResume()
+		}
+
+		last = now
+	}
+}
+
+// NetworkResume shall be called when the system resumed from sleep. It marks all connections as stale, re-runs peer discovery and refreshes UPnP port mappings.
+func (backend *Backend) NetworkResume() {
+	backend.networkResume.Lock()
+	if time.Since(backend.networkResume.last) < resumeDebounce {
+		backend.networkResume.Unlock()
+		return
+	}
+	backend.networkResume.last = time.Now()
+	backend.networkResume.Unlock()
+
+	backend.connectionsMarkStale()
+	backend.Rediscover()
+	go backend.networks.upnpRefresh()
+	go backend.nodesDHT.RefreshBuckets(0)
+}
+
+// connectionsMarkStale pings all active connections immediately. Connections that do not reply within resumeStaleGrace are invalidated by autoPingAll.
+func (backend *Backend) connectionsMarkStale() {
+	staleActive := time.Now().Add(-connectionInvalidate*time.Second + resumeStaleGrace)
+	staleRedundant := time.Now().Add(-connectionInvalidate*time.Second*4 + resumeStaleGrace)
+
+	for _, peer := range backend.PeerlistGet() {
+		for _, connection := range peer.GetConnections(true) {
+			if connection.Status == ConnectionRedundant {
+				connection.LastPacketIn = staleRedundant
+			} else {
+				connection.LastPacketIn = staleActive
+			}
+
+			peer.pingConnection(connection)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+File Username:  Network Size.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Adaptive maintenance intervals. The size of the network is estimated from the density of the DHT buckets (see dht.EstimateSize). Small private
+networks converge quickly and benefit from frequent maintenance, while in the large public network the same frequency multiplied by the count
+of peers is spam. If enabled via the config setting AdaptiveIntervals, the network size scales the maintenance traffic by a factor between
+0.25 (few peers) and 4 (large network), which is 1 at networkSizeReference peers:
+* FIND_SELF: Sent on first contact only if the routing table is small or the last one was sent longer than the scaled interval ago.
+* Multicast/broadcast and bucket refresh intervals.
+* Blockchain refresh announcements (re-announce cadence) to connected peers.
+The factor is applied in addition to the congestion factor (see Congestion.go).
+*/
+
+package core
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// networkSizeReference is the estimated count of peers at which intervals are unchanged.
+const networkSizeReference = 1000
+
+// Limits of the factor by which intervals are scaled.
+const (
+	networkSizeMinFactor = 0.25
+	networkSizeMaxFactor = 4
+)
+
+// findSelfInterval is the unscaled min interval between FIND_SELF requests once the routing table is populated.
+const findSelfInterval = time.Minute
+
+// NetworkSizeStatus is the current network size estimate.
+type NetworkSizeStatus struct {
+	Enabled  bool    // Whether intervals are scaled based on the estimate. See config setting AdaptiveIntervals.
+	Nodes    int     // Count of nodes in the routing table.
+	Estimate uint64  // Estimated count of peers in the network including self.
+	Factor   float64 // Factor by which maintenance intervals are scaled. 1 = unchanged.
+}
+
+type networkSize struct {
+	lastFindSelf time.Time // Last time a FIND_SELF request was sent.
+	sync.Mutex
+}
+
+func (backend *Backend) initNetworkSize() {
+	backend.networkSize = &networkSize{}
+}
+
+// NetworkSize returns the current network size estimate.
+func (backend *Backend) NetworkSize() (status NetworkSizeStatus) {
+	status.Enabled = backend.Config.AdaptiveIntervals
+	status.Nodes = backend.nodesDHT.NumNodes()
+	status.Estimate = backend.nodesDHT.EstimateSize()
+
+	// The factor grows with the square root of the size, so that 16x more peers double the interval.
+	status.Factor = math.Sqrt(float64(status.Estimate) / networkSizeReference)
+	status.Factor = math.Max(networkSizeMinFactor, math.Min(networkSizeMaxFactor, status.Factor))
+
+	return status
+}
+
+// networkSizeScale scales the interval of maintenance traffic by the network size factor. It returns the interval unchanged if disabled.
+func (backend *Backend) networkSizeScale(interval time.Duration) time.Duration {
+	if !backend.Config.AdaptiveIntervals {
+		return interval
+	}
+
+	return time.Duration(float64(interval) * backend.NetworkSize().Factor)
+}
+
+// ShouldSendFindSelf checks if FIND_SELF should be sent with an Announcement on first contact. It is always sent while the routing table is
+// small. Otherwise it is sent at most once per scaled findSelfInterval.
+func (backend *Backend) ShouldSendFindSelf() bool {
+	if !backend.Config.AdaptiveIntervals || backend.nodesDHT.NumNodes() < bucketSize {
+		return true
+	}
+
+	interval := backend.congestionScale(backend.networkSizeScale(findSelfInterval))
+
+	backend.networkSize.Lock()
+	defer backend.networkSize.Unlock()
+
+	if time.Since(backend.networkSize.lastFindSelf) < interval {
+		return false
+	}
+
+	backend.networkSize.lastFindSelf = time.Now()
+
+	return true
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+/*
+File Username:  Network Socket Other.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import (
+	"errors"
+	"net"
+)
+
+// reusePortSupported indicates whether multiple sockets can be bound to the same UDP address.
+const reusePortSupported = false
+
+// listenUDPReusePort is not supported on this platform.
+func listenUDPReusePort(networkA string, address *net.UDPAddr) (socket *net.UDPConn, err error) {
+	return nil, errors.New("not supported")
+}
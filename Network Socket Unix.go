@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+/*
+File Username:  Network Socket Unix.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSupported indicates whether multiple sockets can be bound to the same UDP address.
+const reusePortSupported = true
+
+// listenUDPReusePort opens a UDP socket with SO_REUSEPORT, so that another socket of this process can be bound to the same address.
+func listenUDPReusePort(networkA string, address *net.UDPAddr) (socket *net.UDPConn, err error) {
+	config := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) (err error) {
+		if errC := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}); errC != nil {
+			return errC
+		}
+		return err
+	}}
+
+	conn, err := config.ListenPacket(context.Background(), networkA, address.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil
+}
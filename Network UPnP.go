@@ -116,29 +116,44 @@ func (network *Network) upnpAuto() {
 	go network.upnpMonitorPortForward()
 }
 
-// upnpMonitorPortForward monitors the port forwarding status
-func (network *Network) upnpMonitorPortForward() {
-	ticker := time.NewTicker(time.Second * 10)
+// upnpRefresh re-creates the port mappings on all eligible networks. Mappings may be lost while the system was sleeping, for example if the router restarted.
+func (nets *Networks) upnpRefresh() {
+	if !nets.backend.Config.EnableUPnP {
+		return
+	}
+
+	nets.RLock()
+	networks := append([]*Network{}, nets.networks4...)
+	nets.RUnlock()
 
-monitorLoop:
-	for {
-		select {
-		case <-ticker.C:
-		case <-network.terminateSignal:
-			// Remove port mapping. Note that in case the network is unavailable this is likely to fail.
-			network.nat.DeletePortMapping("UDP", network.portExternal)
+	for _, network := range networks {
+		if network.nat != nil && network.portExternal > 0 {
+			// Re-create the existing mapping with the same external port. If that fails, try a new one.
+			if _, err := network.nat.AddPortMapping("UDP", network.address.IP, uint16(network.address.Port), network.portExternal, "Peernet", 0); err != nil {
+				network.upnpTryPortForward()
+			}
+			continue
+		}
 
-			network.portExternal = 0
-			network.ipExternal = net.IP{}
+		network.networkGroup.upnpMutex.RLock()
+		_, active := network.networkGroup.upnpListInterfaces[network.GetAdapterName()]
+		network.networkGroup.upnpMutex.RUnlock()
 
-			break monitorLoop
+		if !active {
+			go network.upnpAuto()
 		}
+	}
+}
+
+// upnpMonitorPortForward monitors the port forwarding status via a scheduled task until it is invalidated or the network terminates.
+func (network *Network) upnpMonitorPortForward() {
+	taskName := "upnp-renewal " + network.address.String()
 
+	network.backend.scheduleTask(taskName, time.Second*10, time.Second*10, func() (err error) {
 		// 3 tries
-		var err error
 		for n := 0; n < 3; n++ {
 			if err = network.upnpValidate(); err == nil {
-				continue monitorLoop
+				return nil
 			}
 		}
 
@@ -148,11 +163,26 @@ monitorLoop:
 		network.portExternal = 0
 		network.ipExternal = net.IP{}
 
-		break
-	}
+		network.upnpMonitorEnd()
+
+		return errTaskStop
+	})
+
+	<-network.terminateSignal
+
+	if network.backend.unscheduleTask(taskName) {
+		// Remove port mapping. Note that in case the network is unavailable this is likely to fail.
+		network.nat.DeletePortMapping("UDP", network.portExternal)
 
-	ticker.Stop()
+		network.portExternal = 0
+		network.ipExternal = net.IP{}
+
+		network.upnpMonitorEnd()
+	}
+}
 
+// upnpMonitorEnd allows a new UPnP worker to register the adapter.
+func (network *Network) upnpMonitorEnd() {
 	network.networkGroup.upnpMutex.Lock()
 	delete(network.networkGroup.upnpListInterfaces, network.GetAdapterName())
 	network.networkGroup.upnpMutex.Unlock()
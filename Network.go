@@ -24,11 +24,15 @@ type Network struct {
 	ipnet           *net.IPNet       // IP network the listening address belongs to. May not be set.
 	address         *net.UDPAddr     // IP:Port where the server listens
 	socket          *net.UDPConn     // active socket for send/receive
+	socketControl   *net.UDPConn     // Socket reserved for control traffic, bound to the same address. Only set if the config setting ControlSocket is enabled.
+	queueControl    chan networkSend // Packets to send via the control socket. They are strictly prioritized over queueData.
+	queueData       chan networkSend // Lite packets carrying transfer data to send via the main socket.
 	multicastIP     net.IP           // Multicast IP, IPv6 only.
 	multicastSocket net.PacketConn   // Multicast socket, IPv6 only.
 	broadcastSocket net.PacketConn   // Broadcast socket, IPv4 only.
 	broadcastIPv4   []net.IP         // Broadcast IPs, IPv4 only.
 	portExternal    uint16           // External port. 0 if not known.
+	deprecated      time.Time        // IPv6 address rotation: When a newer address in the same prefix was detected. Zero if not deprecated.
 	ipExternal      net.IP           // External IP of the network. Usually not known.
 	nat             upnp.NAT         // UPnP: NAT information
 	isTerminated    bool             // If true, the network was signaled for termination
@@ -53,7 +57,7 @@ func (network *Network) AutoAssignPort(ip net.IP, port int) (err error) {
 	// Previously the algorithm retried up to n times, but this would unnecessarily delay startup in case the IP is actual unlistenable.
 	connectPortTry := func(port int) (address *net.UDPAddr, socket *net.UDPConn, err error) {
 		address = &net.UDPAddr{IP: ip, Port: port}
-		if socket, err = net.ListenUDP(networkA, address); err != nil {
+		if socket, err = network.listenUDP(networkA, address); err != nil {
 			return nil, nil, err
 		}
 
@@ -70,25 +74,116 @@ func (network *Network) AutoAssignPort(ip net.IP, port int) (err error) {
 
 	if port != 0 {
 		network.address, network.socket, err = connectPortTry(port)
+	} else if network.address, network.socket, err = connectPortTry(defaultPort); err != nil { // try default main port, then random
+		network.address, network.socket, err = connectPortTry(0)
+	}
+
+	if err != nil {
 		return err
 	}
 
-	// try default main port, then random
-	if network.address, network.socket, err = connectPortTry(defaultPort); err == nil {
-		return nil
+	network.openControlSocket(networkA)
+
+	return nil
+}
+
+// listenUDP opens a UDP socket. If the control socket is enabled, the port can be shared with it.
+func (network *Network) listenUDP(networkA string, address *net.UDPAddr) (socket *net.UDPConn, err error) {
+	if network.backend.Config.ControlSocket && reusePortSupported {
+		return listenUDPReusePort(networkA, address)
 	}
 
-	if network.address, network.socket, err = connectPortTry(0); err == nil {
-		return nil
+	return net.ListenUDP(networkA, address)
+}
+
+// openControlSocket binds the socket reserved for control traffic to the same address as the main socket, if enabled via the config setting ControlSocket.
+// Under heavy transfer load the control packets then do not queue behind transfer data in the socket send buffer.
+func (network *Network) openControlSocket(networkA string) {
+	if !network.backend.Config.ControlSocket {
+		return
+	} else if !reusePortSupported {
+		network.backend.LogError("openControlSocket", "control socket not supported on this platform\n")
+		return
 	}
 
-	return err
+	socket, err := listenUDPReusePort(networkA, network.address)
+	if err != nil {
+		network.backend.LogError("openControlSocket", "binding control socket to '%s': %v\n", network.address.String(), err)
+		return
+	}
+
+	network.socketControl = socket
+	network.queueControl = make(chan networkSend)
+	network.queueData = make(chan networkSend)
+
+	go network.sendWorker()
+}
+
+// networkSend is a packet queued for sending if the control socket is enabled.
+type networkSend struct {
+	address *net.UDPAddr
+	raw     []byte
+	result  chan error // Receives the result of sending.
 }
 
-// send sends a message
+// sendWorker sends all queued packets if the control socket is enabled. Queued control packets are always sent before transfer data.
+func (network *Network) sendWorker() {
+	for {
+		select {
+		case packet := <-network.queueControl:
+			_, err := network.socketControl.WriteTo(packet.raw, packet.address)
+			packet.result <- err
+			continue
+		default:
+		}
+
+		select {
+		case packet := <-network.queueControl:
+			_, err := network.socketControl.WriteTo(packet.raw, packet.address)
+			packet.result <- err
+
+		case packet := <-network.queueData:
+			_, err := network.socket.WriteTo(packet.raw, packet.address)
+			packet.result <- err
+
+		case <-network.terminateSignal:
+			return
+		}
+	}
+}
+
+// sendQueue queues the packet for the send worker and waits until it is sent.
+func (network *Network) sendQueue(queue chan networkSend, IP net.IP, port int, raw []byte) (err error) {
+	packet := networkSend{address: &net.UDPAddr{IP: IP, Port: port}, raw: raw, result: make(chan error, 1)}
+
+	select {
+	case queue <- packet:
+	case <-network.terminateSignal:
+		return errors.New("network terminated")
+	}
+
+	return <-packet.result
+}
+
+// send sends a message. If the control socket is enabled, it is used.
 func (network *Network) send(IP net.IP, port int, raw []byte) (err error) {
-	_, err = network.socket.WriteTo(raw, &net.UDPAddr{IP: IP, Port: port})
-	return err
+	if network.socketControl == nil {
+		_, err = network.socket.WriteTo(raw, &net.UDPAddr{IP: IP, Port: port})
+		return err
+	}
+
+	return network.sendQueue(network.queueControl, IP, port, raw)
+}
+
+// sendData sends a lite packet carrying transfer data via the main socket. If the control socket is enabled, control packets are strictly
+// prioritized: The packet is only sent while no control packet is queued.
+func (network *Network) sendData(IP net.IP, port int, raw []byte) (err error) {
+	if network.socketControl == nil {
+		_, err = network.socket.WriteTo(raw, &net.UDPAddr{IP: IP, Port: port})
+		return err
+	}
+
+	return network.sendQueue(network.queueData, IP, port, raw)
 }
 
 // Max packet size is 64 KB.
@@ -104,11 +199,20 @@ func (network *Network) Listen() {
 		}
 	}
 
+	if network.socketControl != nil {
+		go network.listenSocket(network.socketControl)
+	}
+
+	network.listenSocket(network.socket)
+}
+
+// listenSocket reads incoming packets from the socket until the network is terminated.
+func (network *Network) listenSocket(socket *net.UDPConn) {
 	for !network.isTerminated {
 		// Buffer: Must be created for each packet as it is passed as pointer.
 		// If the buffer is too small, ReadFromUDP only reads until its length and returns this error: "wsarecvfrom: A message sent on a datagram socket was larger than the internal message buffer or some other network limit, or the buffer used to receive a datagram into was smaller than the datagram itself."
 		buffer := make([]byte, maxPacketSize)
-		length, sender, err := network.socket.ReadFromUDP(buffer)
+		length, sender, err := socket.ReadFromUDP(buffer)
 
 		if err != nil {
 			// Exit on closed socket. Error will be "use of closed network connection".
@@ -142,6 +246,11 @@ func (network *Network) Listen() {
 // packetWorker handles incoming packets.
 func (nets *Networks) packetWorker() {
 	for packet := range nets.rawPacketsIncoming {
+		// Packets addressed to ephemeral lookup identities are encrypted differently.
+		if nets.backend.lookupPrivacyIncoming(packet) {
+			continue
+		}
+
 		decoded, senderPublicKey, err := protocol.PacketDecrypt(packet.raw, packet.receiverPublicKey)
 		if err != nil {
 			//LogError("packetWorker", "decrypting packet from '%s': %s\n", packet.sender.String(), err.Error())  // Only log for debug purposes.
@@ -153,38 +262,59 @@ func (nets *Networks) packetWorker() {
 			continue
 		}
 
+		// discard messages from banned peers and IP addresses
+		if nets.backend.IsBanned(senderPublicKey, packet.sender.IP) {
+			continue
+		}
+
+		// discard messages from responders blocked for sending invalid embedded files
+		if nets.backend.embeddedIsBlocked(senderPublicKey) {
+			continue
+		}
+
 		// supported protocol version
 		if decoded.Protocol != 0 {
 			continue
 		}
 
-		connection := &Connection{backend: nets.backend, Network: packet.network, Address: packet.sender, Status: ConnectionActive}
+		// Packets forwarded by a relay must be from the peer of the relay-only session.
+		if packet.relay != nil && !senderPublicKey.IsEqual(packet.relay.peer) {
+			continue
+		}
+
+		connection := &Connection{backend: nets.backend, Network: packet.network, Address: packet.sender, Status: ConnectionActive, relay: packet.relay}
 
 		nets.backend.Filters.PacketIn(decoded, senderPublicKey, connection)
+		nets.backend.captureIn(decoded, len(packet.raw), senderPublicKey, connection)
 
 		// A peer structure will always be returned, even if the peer won't be added to the peer list.
 		peer, added := nets.backend.PeerlistAdd(senderPublicKey, connection)
 		if !added {
-			connection = peer.registerConnection(connection)
+			connection = peer.registerConnection(connection, decoded.Command == protocol.CommandPing || decoded.Command == protocol.CommandPong)
 		}
 
 		atomic.AddUint64(&peer.StatsPacketReceived, 1)
 		connection.LastPacketIn = time.Now()
+		connection.observePacketSize(len(packet.raw))
 
 		// process the packet
 		raw := &protocol.MessageRaw{SenderPublicKey: senderPublicKey, PacketRaw: *decoded}
 
 		switch decoded.Command {
 		case protocol.CommandAnnouncement: // Announce
-			if announce, _ := protocol.DecodeAnnouncement(raw); announce != nil {
+			if announce, err := protocol.DecodeAnnouncement(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if announce != nil {
 				// Update known internal/external port and User Agent
 				connection.PortInternal = announce.PortInternal
 				connection.PortExternal = announce.PortExternal
 				connection.Firewall = announce.Features&(1<<protocol.FeatureFirewall) > 0
-				if len(announce.UserAgent) > 0 {
-					peer.UserAgent = announce.UserAgent
+				if !peer.setUserAgent(announce.UserAgent) {
+					nets.backend.PeerlistRemove(peer)
+					continue
 				}
 				peer.Features = announce.Features
+				peer.FeaturesExt = announce.FeaturesExt
 
 				isBlockchainUpdate := peer.BlockchainHeight != announce.BlockchainHeight || peer.BlockchainVersion != announce.BlockchainVersion
 				peer.BlockchainHeight = announce.BlockchainHeight
@@ -193,7 +323,10 @@ func (nets *Networks) packetWorker() {
 
 				nets.backend.Filters.MessageIn(peer, raw, announce)
 
+				start := time.Now()
 				peer.cmdAnouncement(announce, connection)
+				nets.backend.traceMessage("announcement", false, peer.PublicKey, raw.Sequence, false, start, time.Now(), nil)
+				peer.sessionTicketIssue(connection)
 
 				if isBlockchainUpdate {
 					peer.remoteBlockchainUpdate()
@@ -201,15 +334,22 @@ func (nets *Networks) packetWorker() {
 			}
 
 		case protocol.CommandResponse: // Response
-			if response, _ := protocol.DecodeResponse(raw); response != nil {
+			if response, err := protocol.DecodeResponse(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if response != nil {
 				// Validate sequence number which prevents unsolicited responses.
 				isLast := response.IsLast()
 				sequenceInfo, valid, rtt := nets.Sequences.ValidateSequence(raw.SenderPublicKey, raw.Sequence, isLast, !isLast)
 				if !valid {
 					//LogError("packetWorker", "message with invalid sequence %d command %d from %s\n", raw.Sequence, raw.Command, raw.connection.Address.String()) // Only log for debug purposes.
+					peer.slaRecord(rtt, true)
+					peer.protocolError(ProtocolErrorSequence)
 					continue
 				} else if rtt > 0 {
-					connection.RoundTripTime = rtt
+					connection.recordRTT(rtt)
+					peer.slaRecord(rtt, false)
+					nets.backend.traceMessage("announcement", true, peer.PublicKey, raw.Sequence, false, time.Now().Add(-rtt), time.Now(), nil)
+					peer.clockSampleIncoming(response.Timestamp, rtt)
 				}
 				raw.SequenceInfo = sequenceInfo
 
@@ -217,10 +357,12 @@ func (nets *Networks) packetWorker() {
 				connection.PortInternal = response.PortInternal
 				connection.PortExternal = response.PortExternal
 				connection.Firewall = response.Features&(1<<protocol.FeatureFirewall) > 0
-				if len(response.UserAgent) > 0 {
-					peer.UserAgent = response.UserAgent
+				if !peer.setUserAgent(response.UserAgent) {
+					nets.backend.PeerlistRemove(peer)
+					continue
 				}
 				peer.Features = response.Features
+				peer.FeaturesExt = response.FeaturesExt
 
 				isBlockchainUpdate := peer.BlockchainHeight != response.BlockchainHeight || peer.BlockchainVersion != response.BlockchainVersion
 				peer.BlockchainHeight = response.BlockchainHeight
@@ -230,6 +372,7 @@ func (nets *Networks) packetWorker() {
 				nets.backend.Filters.MessageIn(peer, raw, response)
 
 				peer.cmdResponse(response, connection)
+				peer.sessionTicketIssue(connection)
 
 				if isBlockchainUpdate {
 					peer.remoteBlockchainUpdate()
@@ -237,11 +380,15 @@ func (nets *Networks) packetWorker() {
 			}
 
 		case protocol.CommandLocalDiscovery: // Local discovery, sent via IPv4 broadcast and IPv6 multicast
-			if announce, _ := protocol.DecodeAnnouncement(raw); announce != nil {
-				if len(announce.UserAgent) > 0 {
-					peer.UserAgent = announce.UserAgent
+			if announce, err := protocol.DecodeAnnouncement(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if announce != nil {
+				if !peer.setUserAgent(announce.UserAgent) {
+					nets.backend.PeerlistRemove(peer)
+					continue
 				}
 				peer.Features = announce.Features
+				peer.FeaturesExt = announce.FeaturesExt
 
 				isBlockchainUpdate := peer.BlockchainHeight != announce.BlockchainHeight || peer.BlockchainVersion != announce.BlockchainVersion
 				peer.BlockchainHeight = announce.BlockchainHeight
@@ -259,29 +406,39 @@ func (nets *Networks) packetWorker() {
 
 		case protocol.CommandPing: // Ping
 			nets.backend.Filters.MessageIn(peer, raw, nil)
+			start := time.Now()
 			peer.cmdPing(raw, connection)
+			nets.backend.traceMessage("ping", false, peer.PublicKey, raw.Sequence, false, start, time.Now(), nil)
 
 		case protocol.CommandPong: // Ping
 			// Validate sequence number which prevents unsolicited responses.
 			sequenceInfo, valid, rtt := nets.Sequences.ValidateSequence(raw.SenderPublicKey, raw.Sequence, true, false)
 			if !valid {
 				//LogError("packetWorker", "message with invalid sequence %d command %d from %s\n", raw.Sequence, raw.Command, raw.connection.Address.String()) // Only log for debug purposes.
+				peer.protocolError(ProtocolErrorSequence)
 				continue
 			} else if rtt > 0 {
-				connection.RoundTripTime = rtt
+				connection.recordRTT(rtt)
+				nets.backend.traceMessage("ping", true, peer.PublicKey, raw.Sequence, false, time.Now().Add(-rtt), time.Now(), nil)
 			}
 			raw.SequenceInfo = sequenceInfo
+			connection.probeReply(rtt)
 
-			nets.backend.Filters.MessageIn(peer, raw, nil)
-
-			peer.cmdPong(raw, connection)
+			if pong, err := protocol.DecodePong(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if pong != nil {
+				nets.backend.Filters.MessageIn(peer, raw, pong)
+				peer.cmdPong(pong, connection)
+			}
 
 		case protocol.CommandChat: // Chat [debug]
 			nets.backend.Filters.MessageIn(peer, raw, nil)
 			peer.cmdChat(raw, connection)
 
 		case protocol.CommandTraverse:
-			if traverse, _ := protocol.DecodeTraverse(raw); traverse != nil {
+			if traverse, err := protocol.DecodeTraverse(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if traverse != nil {
 				nets.backend.Filters.MessageIn(peer, raw, traverse)
 				if traverse.TargetPeer.IsEqual(nets.backend.PeerPublicKey) && traverse.AuthorizedRelayPeer.IsEqual(peer.PublicKey) {
 					peer.cmdTraverseReceive(traverse)
@@ -291,7 +448,9 @@ func (nets *Networks) packetWorker() {
 			}
 
 		case protocol.CommandTransfer:
-			if msg, _ := protocol.DecodeTransfer(raw); msg != nil {
+			if msg, err := protocol.DecodeTransfer(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
 				// Validate sequence number which prevents unsolicited responses.
 				isLast := msg.IsLast()
 				sequenceInfo, valid, rtt := nets.Sequences.ValidateSequenceBi(raw.SenderPublicKey, raw.Sequence, isLast)
@@ -299,7 +458,7 @@ func (nets *Networks) packetWorker() {
 					//LogError("packetWorker", "message with invalid sequence %d command %d from %s\n", raw.Sequence, raw.Command, raw.connection.Address.String()) // Only log for debug purposes.
 					continue
 				} else if rtt > 0 {
-					connection.RoundTripTime = rtt
+					connection.recordRTT(rtt)
 				}
 				raw.SequenceInfo = sequenceInfo
 
@@ -307,7 +466,9 @@ func (nets *Networks) packetWorker() {
 			}
 
 		case protocol.CommandGetBlock:
-			if msg, _ := protocol.DecodeGetBlock(raw); msg != nil {
+			if msg, err := protocol.DecodeGetBlock(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
 				// Validate sequence number which prevents unsolicited responses.
 				isLast := msg.IsLast()
 				sequenceInfo, valid, rtt := nets.Sequences.ValidateSequenceBi(raw.SenderPublicKey, raw.Sequence, isLast)
@@ -315,13 +476,92 @@ func (nets *Networks) packetWorker() {
 					//LogError("packetWorker", "message with invalid sequence %d command %d from %s\n", raw.Sequence, raw.Command, raw.connection.Address.String()) // Only log for debug purposes.
 					continue
 				} else if rtt > 0 {
-					connection.RoundTripTime = rtt
+					connection.recordRTT(rtt)
 				}
 				raw.SequenceInfo = sequenceInfo
 
 				peer.cmdGetBlock(msg, connection)
 			}
 
+		case protocol.CommandAdmin:
+			if msg, err := protocol.DecodeAdmin(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
+				if msg.Control == protocol.AdminControlResponse {
+					// Validate sequence number which prevents unsolicited responses.
+					sequenceInfo, valid, rtt := nets.Sequences.ValidateSequence(raw.SenderPublicKey, raw.Sequence, true, false)
+					if !valid {
+						peer.protocolError(ProtocolErrorSequence)
+						continue
+					} else if rtt > 0 {
+						connection.recordRTT(rtt)
+					}
+					raw.SequenceInfo = sequenceInfo
+
+					nets.backend.Filters.MessageIn(peer, raw, msg)
+					peer.cmdAdminResponse(msg)
+				} else {
+					nets.backend.Filters.MessageIn(peer, raw, msg)
+					peer.cmdAdmin(msg, connection)
+				}
+			}
+
+		case protocol.CommandLookupRelay:
+			if msg, err := protocol.DecodeLookupRelay(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
+				nets.backend.Filters.MessageIn(peer, raw, msg)
+				peer.cmdLookupRelay(msg, connection)
+			}
+
+		case protocol.CommandOnion:
+			if msg, err := protocol.DecodeOnion(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
+				nets.backend.Filters.MessageIn(peer, raw, msg)
+				peer.cmdOnion(msg)
+			}
+
+		case protocol.CommandContentSummary:
+			if msg, err := protocol.DecodeContentSummary(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
+				nets.backend.Filters.MessageIn(peer, raw, msg)
+				peer.cmdContentSummary(msg)
+			}
+
+		case protocol.CommandNATProbe:
+			if msg, err := protocol.DecodeNATProbe(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
+				nets.backend.Filters.MessageIn(peer, raw, msg)
+				peer.cmdNATProbe(msg, connection)
+			}
+
+		case protocol.CommandStream:
+			if msg, err := protocol.DecodeStream(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
+				// Validate sequence number which prevents unsolicited responses.
+				sequenceInfo, valid, rtt := nets.Sequences.ValidateSequenceBi(raw.SenderPublicKey, raw.Sequence, msg.IsLast())
+				if msg.Control != protocol.StreamControlRequestStart && !valid {
+					continue
+				} else if rtt > 0 {
+					connection.recordRTT(rtt)
+				}
+				raw.SequenceInfo = sequenceInfo
+
+				peer.cmdStream(msg)
+			}
+
+		case protocol.CommandHolePunch:
+			if msg, err := protocol.DecodeHolePunch(raw); err != nil {
+				peer.protocolError(ProtocolErrorMalformed)
+			} else if msg != nil {
+				nets.backend.Filters.MessageIn(peer, raw, msg)
+				peer.cmdHolePunch(msg, connection)
+			}
+
 		default: // Unknown command
 			nets.backend.Filters.MessageIn(peer, raw, nil)
 
@@ -375,6 +615,9 @@ func (network *Network) Terminate() {
 	network.isTerminated = true
 	close(network.terminateSignal) // safety guaranteed via lock
 	network.socket.Close()         // Will stop the listener from blocking on network.socket.ReadFromUDP
+	if network.socketControl != nil {
+		network.socketControl.Close()
+	}
 
 	network.networkGroup.ipListen.Remove(network.address)
 }
@@ -410,6 +653,25 @@ func (backend *Backend) FeatureSupport() (feature byte) {
 	if backend.networks.localFirewall {
 		feature |= 1 << protocol.FeatureFirewall
 	}
+	if backend.Config.LookupRelay {
+		feature |= 1 << protocol.FeatureLookupRelay
+	}
+	if backend.Config.OnionRelay {
+		feature |= 1 << protocol.FeatureOnionRelay
+	}
+	if backend.supernodeAvailable() {
+		feature |= 1 << protocol.FeatureSupernode
+	}
+	feature |= backend.natFeatures()
+	return feature
+}
+
+// FeatureSupportExt returns the extended features supported by this peer
+func (backend *Backend) FeatureSupportExt() (feature byte) {
+	if backend.Config.TrafficRelay {
+		feature |= 1 << protocol.FeatureExtTrafficRelay
+	}
+	feature |= 1 << protocol.FeatureExtLiteReplay
 	return feature
 }
 
@@ -9,6 +9,7 @@ package core
 import (
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/PeernetOfficial/core/protocol"
 )
@@ -41,6 +42,9 @@ type Networks struct {
 	// localFirewall indicates if a local firewall may drop unsolicited incoming packets
 	localFirewall bool
 
+	// changeEvents signals network changes reported by the operating system.
+	changeEvents chan struct{}
+
 	// UPnP data
 	upnpListInterfaces map[string]struct{}
 	upnpMutex          sync.RWMutex
@@ -92,3 +96,14 @@ func (backend *Backend) firewallDetectIndicatorFile() {
 func (backend *Backend) LiteSessions() (sessions []*protocol.LiteID) {
 	return backend.networks.LiteRouter.All()
 }
+
+// liteReplay checks if the peer supports lite packets with replay protection.
+func (peer *PeerInfo) liteReplay() bool {
+	return peer.FeaturesExt&(1<<protocol.FeatureExtLiteReplay) != 0
+}
+
+// LiteReplayStats returns the counters of lite packets rejected by the replay protection.
+func (backend *Backend) LiteReplayStats() (rejectedReplay, rejectedTimestamp uint64) {
+	stats := &backend.networks.LiteRouter.Stats
+	return atomic.LoadUint64(&stats.RejectedReplay), atomic.LoadUint64(&stats.RejectedTimestamp)
+}
@@ -0,0 +1,221 @@
+/*
+File Username:  Node State.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Export and import of the node state relevant to connectivity. When migrating a node to a new machine or IP, importing the state retains the
+network position immediately instead of bootstrapping from the root peers. The node ID (and therefore the position in the DHT) is determined by
+the private key, which must be migrated separately. The document contains:
+* Peer list including the addresses of active and inactive connections. Whether the peer is in the routing table is indicated.
+* Ban list.
+* Recent contacts of the bootstrap, which prevent contacting inactive peers over and over again.
+
+Import semantics:
+* All peers (except self and banned ones) are contacted on all addresses. Responding peers are added to the peer list and routing table.
+* Ban list entries are added if no entry for the same target exists. Expired entries are ignored.
+* Recent contacts are added if not expired and not already known.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// nodeStateFileVersion is the version of the exported node state document.
+const nodeStateFileVersion = 1
+
+// NodeStateFile is the document used to migrate the node state.
+type NodeStateFile struct {
+	Version        int                `json:"version"`        // Version of the document, see nodeStateFileVersion.
+	PeerID         string             `json:"peerid"`         // Peer ID of the exporting node, hex encoded.
+	Created        time.Time          `json:"created"`        // Time the document was created.
+	Peers          []NodeStatePeer    `json:"peers"`          // Peer list.
+	BanList        []BanEntry         `json:"banlist"`        // Ban list.
+	RecentContacts []NodeStateContact `json:"recentcontacts"` // Recent contacts of the bootstrap.
+}
+
+// NodeStatePeer is a peer from the peer list.
+type NodeStatePeer struct {
+	PublicKey    string             `json:"publickey"`    // Peer ID, hex encoded.
+	Addresses    []NodeStateAddress `json:"addresses"`    // Addresses of active connections first, then of inactive ones.
+	Features     uint8              `json:"features"`     // Feature bit array, see protocol.FeatureX.
+	IsRootPeer   bool               `json:"isrootpeer"`   // Whether the peer is a root peer.
+	RoutingTable bool               `json:"routingtable"` // Whether the peer is in the routing table.
+	LastSeen     time.Time          `json:"lastseen"`     // Last time the peer was seen in the routing table. Zero if not in the routing table.
+}
+
+// NodeStateAddress is an address of a peer.
+type NodeStateAddress struct {
+	Address      string `json:"address"`      // IP:Port.
+	PortInternal uint16 `json:"portinternal"` // Internal port reported by the peer. 0 if not known.
+}
+
+// NodeStateContact is a recent contact of the bootstrap.
+type NodeStateContact struct {
+	PublicKey string    `json:"publickey"` // Peer ID, hex encoded.
+	Added     time.Time `json:"added"`     // When the contact was added.
+	Addresses []string  `json:"addresses"` // Contacted addresses as IP:Port.
+	Origin    []string  `json:"origin"`    // Node IDs of the peers who reported the contact, hex encoded.
+}
+
+// NodeStateImportResult is the result of importing the node state.
+type NodeStateImportResult struct {
+	PeersContacted int `json:"peerscontacted"` // Count of peers contacted.
+	BansAdded      int `json:"bansadded"`      // Count of ban list entries added.
+	ContactsAdded  int `json:"contactsadded"`  // Count of recent contacts added.
+	Skipped        int `json:"skipped"`        // Count of peers, ban list entries, and recent contacts that were invalid, expired, or already known.
+}
+
+// NodeStateExport returns the node state relevant to connectivity as JSON document. See NodeStateFile.
+func (backend *Backend) NodeStateExport() (data []byte, err error) {
+	file := NodeStateFile{Version: nodeStateFileVersion, PeerID: hex.EncodeToString(backend.PeerPublicKey.SerializeCompressed()), Created: time.Now().UTC(), Peers: []NodeStatePeer{}, BanList: backend.BanList(), RecentContacts: []NodeStateContact{}}
+
+	for _, peer := range backend.PeerlistGet() {
+		statePeer := NodeStatePeer{PublicKey: hex.EncodeToString(peer.PublicKey.SerializeCompressed()), Addresses: []NodeStateAddress{}, Features: peer.Features, IsRootPeer: peer.IsRootPeer}
+
+		for _, active := range []bool{true, false} {
+			for _, connection := range peer.GetConnections(active) {
+				if connection.relay != nil { // The address of relayed connections is not reachable directly.
+					continue
+				}
+				statePeer.Addresses = append(statePeer.Addresses, NodeStateAddress{Address: connection.Address.String(), PortInternal: connection.PortInternal})
+			}
+		}
+
+		if node := backend.nodesDHT.IsNodeContact(peer.NodeID); node != nil {
+			statePeer.RoutingTable = true
+			statePeer.LastSeen = node.LastSeen.UTC()
+		}
+
+		file.Peers = append(file.Peers, statePeer)
+	}
+
+	recentContactsMutex.RLock()
+	for key, recent := range recentContacts {
+		contact := NodeStateContact{PublicKey: hex.EncodeToString(key[:]), Added: recent.added.UTC(), Addresses: []string{}, Origin: []string{}}
+
+		recent.RLock()
+		for _, address := range recent.addresses {
+			contact.Addresses = append(contact.Addresses, net.JoinHostPort(address.IP.String(), strconv.Itoa(int(address.Port))))
+		}
+		for origin := range recent.origin {
+			contact.Origin = append(contact.Origin, hex.EncodeToString([]byte(origin)))
+		}
+		recent.RUnlock()
+
+		file.RecentContacts = append(file.RecentContacts, contact)
+	}
+	recentContactsMutex.RUnlock()
+
+	return json.MarshalIndent(file, "", "    ")
+}
+
+// NodeStateImport merges the node state from the JSON document. See NodeStateFile for the import semantics.
+// Peers are contacted asynchronously; they are added to the peer list when they respond.
+func (backend *Backend) NodeStateImport(data []byte) (result NodeStateImportResult, err error) {
+	var file NodeStateFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return result, err
+	} else if file.Version != nodeStateFileVersion {
+		return result, errors.New("unsupported node state version")
+	}
+
+	now := time.Now()
+
+	// ban list first, so that banned peers are not contacted
+	backend.banListUpdate(func(entries []BanEntry) []BanEntry {
+		existing := make(map[string]struct{})
+		for _, entry := range entries {
+			existing[entry.target()] = struct{}{}
+		}
+
+		for _, entry := range file.BanList {
+			if entry.normalize() != nil || entry.isExpired(now) {
+				result.Skipped++
+				continue
+			} else if _, ok := existing[entry.target()]; ok {
+				result.Skipped++
+				continue
+			}
+
+			existing[entry.target()] = struct{}{}
+			entries = append(entries, entry)
+			result.BansAdded++
+		}
+
+		return entries
+	})
+
+	// recent contacts
+	threshold := now.Add(-bootstrapRecentContact * time.Second)
+
+	recentContactsMutex.Lock()
+	for _, contact := range file.RecentContacts {
+		publicKey, err := PublicKeyFromPeerID(contact.PublicKey)
+		if err != nil || contact.Added.Before(threshold) {
+			result.Skipped++
+			continue
+		}
+
+		key := publicKey2Compressed(publicKey)
+		if _, ok := recentContacts[key]; ok {
+			result.Skipped++
+			continue
+		}
+
+		recent := &recentContactInfo{added: contact.Added, origin: make(map[string]struct{})}
+		for _, addressA := range contact.Addresses {
+			if address, err := parseAddress(addressA); err == nil {
+				recent.addresses = append(recent.addresses, &peerAddress{IP: address.IP, Port: uint16(address.Port)})
+			}
+		}
+		for _, originA := range contact.Origin {
+			if origin, err := hex.DecodeString(originA); err == nil {
+				recent.origin[string(origin)] = struct{}{}
+			}
+		}
+
+		recentContacts[key] = recent
+		result.ContactsAdded++
+	}
+	recentContactsMutex.Unlock()
+
+	// peers
+	for _, statePeer := range file.Peers {
+		publicKey, err := PublicKeyFromPeerID(statePeer.PublicKey)
+		if err != nil || publicKey.IsEqual(backend.PeerPublicKey) || backend.IsBanned(publicKey, nil) || backend.PeerlistLookup(publicKey) != nil {
+			result.Skipped++
+			continue
+		}
+
+		if backend.nodeStateContact(publicKey, statePeer) {
+			result.PeersContacted++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	return result, nil
+}
+
+// nodeStateContact contacts the imported peer on all of its addresses.
+func (backend *Backend) nodeStateContact(publicKey *btcec.PublicKey, statePeer NodeStatePeer) (contacted bool) {
+	for _, stateAddress := range statePeer.Addresses {
+		address, err := parseAddress(stateAddress.Address)
+		if err != nil || backend.IsBanned(nil, address.IP) {
+			continue
+		}
+
+		contacted = backend.contactArbitraryPeer(publicKey, address, stateAddress.PortInternal, statePeer.Features) || contacted
+	}
+
+	return contacted
+}
@@ -0,0 +1,413 @@
+/*
+File Username:  Onion Routing.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Onion routing sends sensitive messages via a circuit of 2-3 hops using layered encryption. Each hop only knows the previous and the next one.
+It is an opt-in privacy mode enabled via the config setting OnionHops.
+
+* DHT FIND_VALUE lookups use an ephemeral identity (see Lookup Privacy.go). The last hop (exit) sends the request to the queried peer and relays responses back.
+* Chat messages are delivered to the receiver as innermost onion layer. Only the receiver learns the sender.
+
+Hops are selected from peers that support relaying (FeatureOnionRelay) and are in the peer list for a minimum time, preferring the ones with the longest uptime.
+All onion messages are padded to a minimum size by each hop, which prevents hops from learning their position in the circuit.
+*/
+
+package core
+
+import (
+	cryptoRand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/dht"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// onionCircuitExpiry is the time an onion circuit remains valid.
+const onionCircuitExpiry = time.Minute
+
+// onionRelayMinUptime is the minimum time a peer must be in the peer list to be selected as hop.
+const onionRelayMinUptime = 10 * time.Minute
+
+// onionRelayPoolSize is the count of peers with the longest uptime from which hops are randomly selected.
+const onionRelayPoolSize = 10
+
+// onionCircuitMaxPerPeer is the max count of active relayed circuits per previous hop.
+const onionCircuitMaxPerPeer = 64
+
+// onionCircuitOwn is a circuit created by this peer.
+type onionCircuitOwn struct {
+	firstHop *btcec.PublicKey // First hop. Backward messages are only accepted from it.
+	keys     [][32]byte       // Backward keys of all hops, starting with the first one.
+	identity *lookupIdentity  // Ephemeral identity used for the embedded packet.
+	target   *btcec.PublicKey // Target peer of the embedded packet.
+	expires  time.Time
+}
+
+// onionCircuitHop is a circuit relayed by this peer.
+type onionCircuitHop struct {
+	previous   *PeerInfo        // Previous hop.
+	previousID uint64           // Circuit ID of the link to the previous hop.
+	next       *btcec.PublicKey // Next hop. Nil if this peer is the exit.
+	key        [32]byte         // Backward key.
+	expires    time.Time
+}
+
+// onionRouting keeps track of own and relayed circuits.
+type onionRouting struct {
+	own  map[uint64]*onionCircuitOwn // Key is the circuit ID of the link to the first hop.
+	hops map[uint64]*onionCircuitHop // Key is the circuit ID of the link to the next hop.
+	sync.Mutex
+}
+
+func (backend *Backend) initOnionRouting() {
+	backend.onionRouting = &onionRouting{
+		own:  make(map[uint64]*onionCircuitOwn),
+		hops: make(map[uint64]*onionCircuitHop),
+	}
+}
+
+// expireOnionRouting deletes expired circuits. It is run by the scheduler.
+func (backend *Backend) expireOnionRouting() (err error) {
+	now := time.Now()
+
+	routing := backend.onionRouting
+	routing.Lock()
+	defer routing.Unlock()
+
+	for id, circuit := range routing.own {
+		if circuit.expires.Before(now) {
+			delete(routing.own, id)
+		}
+	}
+	for id, hop := range routing.hops {
+		if hop.expires.Before(now) {
+			delete(routing.hops, id)
+		}
+	}
+
+	return nil
+}
+
+// onionHopsCount returns the count of hops to use for a circuit.
+func (backend *Backend) onionHopsCount() int {
+	switch {
+	case backend.Config.OnionHops <= 2:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// onionRelaySelect selects random hops from the peers in the routing table with the longest uptime. Peers with a lossy or slow path are avoided. The target is excluded.
+// Out of multiple random paths the one with the lowest estimated latency via the latency map is used.
+func (backend *Backend) onionRelaySelect(count int, target *btcec.PublicKey) (relays []*PeerInfo) {
+	eligible := func(node *dht.Node) (accept bool) {
+		peer := node.Info.(*PeerInfo)
+		_, _, ok := peer.onionAddress()
+		return ok && !peer.PublicKey.IsEqual(target) && time.Since(peer.added) >= onionRelayMinUptime
+	}
+
+	candidates := backend.ClosestPeers(backend.nodeID, backend.PeerlistCount(), NodeFilterFeatures(1<<protocol.FeatureOnionRelay, 0), eligible)
+
+	if len(candidates) < count {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].added.Before(candidates[j].added) })
+	if len(candidates) > onionRelayPoolSize {
+		candidates = candidates[:onionRelayPoolSize]
+	}
+	candidates = pathRelayFilter(candidates, count)
+
+	return backend.latencyPathSelect(candidates, count, target)
+}
+
+// onionAddress returns the external address of the peer which is shared with the previous hop.
+func (peer *PeerInfo) onionAddress() (IP net.IP, port uint16, ok bool) {
+	if peer.isVirtual {
+		for _, address := range peer.targetAddresses {
+			if !IsIPLocal(address.IP) {
+				return address.IP, address.Port, true
+			}
+		}
+		return nil, 0, false
+	}
+
+	if connection := peer.GetConnection2Share(false, true, true); connection != nil {
+		return connection.Address.IP, uint16(connection.Address.Port), true
+	}
+
+	return nil, 0, false
+}
+
+// onionSend sends the final layer via the path. The last peer in the path processes the final layer.
+// If identity is set, the circuit is registered to receive packets sent back.
+func (backend *Backend) onionSend(path []*PeerInfo, final *protocol.OnionLayer, identity *lookupIdentity) (err error) {
+	keys := make([][32]byte, len(path))
+	circuitIDs := make([]uint64, len(path))
+
+	for n := range path {
+		if _, err := cryptoRand.Read(keys[n][:]); err != nil {
+			return err
+		}
+		circuitIDs[n] = rand.Uint64()
+	}
+
+	final.BackwardKey = keys[len(path)-1]
+	data, err := protocol.EncodeOnionLayer(final, path[len(path)-1].PublicKey)
+	if err != nil {
+		return err
+	}
+
+	for n := len(path) - 2; n >= 0; n-- {
+		IP, port, ok := path[n+1].onionAddress()
+		if !ok {
+			return errors.New("no address for hop")
+		}
+
+		layer := &protocol.OnionLayer{Action: protocol.OnionActionRelay, BackwardKey: keys[n], CircuitID: circuitIDs[n+1], Peer: path[n+1].PublicKey, IP: IP, Port: port, Data: data}
+		if data, err = protocol.EncodeOnionLayer(layer, path[n].PublicKey); err != nil {
+			return err
+		}
+	}
+
+	payload, err := protocol.EncodeOnion(protocol.OnionForward, circuitIDs[0], data)
+	if err != nil {
+		return err
+	}
+
+	if identity != nil {
+		backend.onionRouting.Lock()
+		backend.onionRouting.own[circuitIDs[0]] = &onionCircuitOwn{firstHop: path[0].PublicKey, keys: keys, identity: identity, target: final.Peer, expires: time.Now().Add(onionCircuitExpiry)}
+		backend.onionRouting.Unlock()
+	}
+
+	return path[0].send(&protocol.PacketRaw{Command: protocol.CommandOnion, Payload: payload})
+}
+
+// sendAnnouncementFindValueOnion sends the FIND_VALUE request using an ephemeral identity via an onion circuit.
+// If not enough hops are available, the request is not sent.
+func (peer *PeerInfo) sendAnnouncementFindValueOnion(request *dht.InformationRequest) {
+	// Peers only reachable in the local network cannot be reached by the exit hop.
+	IP, port, ok := peer.onionAddress()
+	if !ok {
+		peer.sendAnnouncementFindValuePrivate(request)
+		return
+	}
+
+	relays := peer.Backend.onionRelaySelect(peer.Backend.onionHopsCount(), peer.PublicKey)
+	if len(relays) == 0 {
+		return
+	}
+
+	identity, err := peer.Backend.lookupIdentityGet(request)
+	if err != nil {
+		return
+	}
+
+	raw, err := peer.lookupAnnouncementEncode(identity, request)
+	if err != nil {
+		return
+	}
+
+	peer.Backend.onionSend(relays, &protocol.OnionLayer{Action: protocol.OnionActionExit, Peer: peer.PublicKey, IP: IP, Port: port, Data: raw}, identity)
+}
+
+// ChatOnion sends a text message via an onion circuit. Only the receiver learns the sender.
+func (peer *PeerInfo) ChatOnion(text string) (err error) {
+	if _, _, ok := peer.onionAddress(); !ok {
+		return errors.New("no external address for peer")
+	}
+
+	relays := peer.Backend.onionRelaySelect(peer.Backend.onionHopsCount(), peer.PublicKey)
+	if len(relays) == 0 {
+		return errors.New("not enough hops available")
+	}
+
+	raw, err := protocol.PacketEncrypt(peer.Backend.PeerPrivateKey, peer.PublicKey, &protocol.PacketRaw{Protocol: protocol.ProtocolVersion, Command: protocol.CommandChat, Payload: []byte(text)})
+	if err != nil {
+		return err
+	}
+
+	return peer.Backend.onionSend(append(relays, peer), &protocol.OnionLayer{Action: protocol.OnionActionDeliver, Data: raw}, nil)
+}
+
+// cmdOnion handles an incoming onion message
+func (peer *PeerInfo) cmdOnion(msg *protocol.MessageOnion) {
+	switch msg.Direction {
+	case protocol.OnionForward:
+		peer.cmdOnionForward(msg)
+	case protocol.OnionBackward:
+		peer.cmdOnionBackward(msg)
+	}
+}
+
+func (peer *PeerInfo) cmdOnionForward(msg *protocol.MessageOnion) {
+	backend := peer.Backend
+
+	layer, err := protocol.DecodeOnionLayer(msg.Data, backend.PeerPrivateKey)
+	if err != nil {
+		return
+	}
+
+	switch layer.Action {
+	case protocol.OnionActionRelay:
+		if !backend.Config.OnionRelay || layer.Port == 0 || layer.Peer.IsEqual(backend.PeerPublicKey) || layer.Peer.IsEqual(peer.PublicKey) {
+			return
+		}
+
+		if !backend.onionHopAdd(layer.CircuitID, &onionCircuitHop{previous: peer, previousID: msg.CircuitID, next: layer.Peer, key: layer.BackwardKey}) {
+			return
+		}
+
+		payload, err := protocol.EncodeOnion(protocol.OnionForward, layer.CircuitID, layer.Data)
+		if err != nil {
+			return
+		}
+
+		backend.onionSendTo(layer.Peer, &net.UDPAddr{IP: layer.IP, Port: int(layer.Port)}, payload)
+
+	case protocol.OnionActionExit:
+		if !backend.Config.OnionRelay || layer.Port == 0 || len(layer.Data) < protocol.PacketLengthMin || layer.Peer.IsEqual(backend.PeerPublicKey) {
+			return
+		}
+
+		// Only forward valid Announcements to the target. The sender is the ephemeral identity.
+		decoded, ephemeralKey, err := protocol.PacketDecrypt(layer.Data, layer.Peer)
+		if err != nil || decoded.Protocol != 0 || decoded.Command != protocol.CommandAnnouncement {
+			return
+		} else if ephemeralKey.IsEqual(peer.PublicKey) || ephemeralKey.IsEqual(backend.PeerPublicKey) {
+			return
+		}
+
+		hop := &onionCircuitHop{previous: peer, previousID: msg.CircuitID, key: layer.BackwardKey}
+		if !backend.onionHopAdd(rand.Uint64(), hop) {
+			return
+		}
+
+		remote := &net.UDPAddr{IP: layer.IP, Port: int(layer.Port)}
+		if !backend.lookupExpectAdd(remote, &lookupExpect{ephemeralKey: ephemeralKey, target: layer.Peer, requester: peer, circuit: hop}) {
+			return
+		}
+
+		backend.networks.sendRawAllNetworks(remote, layer.Data)
+
+	case protocol.OnionActionDeliver:
+		if len(layer.Data) < protocol.PacketLengthMin {
+			return
+		}
+
+		decoded, senderPublicKey, err := protocol.PacketDecrypt(layer.Data, backend.PeerPublicKey)
+		if err != nil || decoded.Protocol != 0 || senderPublicKey.IsEqual(backend.PeerPublicKey) {
+			return
+		}
+
+		switch decoded.Command {
+		case protocol.CommandChat:
+			fmt.Fprintf(backend.Stdout, "Chat from %s via onion circuit: %s\n", hex.EncodeToString(senderPublicKey.SerializeCompressed()), string(decoded.Payload))
+		}
+	}
+}
+
+func (peer *PeerInfo) cmdOnionBackward(msg *protocol.MessageOnion) {
+	routing := peer.Backend.onionRouting
+
+	routing.Lock()
+	own := routing.own[msg.CircuitID]
+	hop := routing.hops[msg.CircuitID]
+	routing.Unlock()
+
+	if own != nil && own.firstHop.IsEqual(peer.PublicKey) {
+		peer.Backend.onionReceive(own, msg.Data)
+	} else if hop != nil && hop.next != nil && hop.next.IsEqual(peer.PublicKey) {
+		peer.Backend.onionBackward(hop, msg.Data)
+	}
+}
+
+// onionHopAdd registers a relayed circuit. It fails if the circuit ID is already used or the previous hop exceeds the limit of circuits.
+func (backend *Backend) onionHopAdd(circuitID uint64, hop *onionCircuitHop) bool {
+	routing := backend.onionRouting
+	routing.Lock()
+	defer routing.Unlock()
+
+	if _, ok := routing.hops[circuitID]; ok {
+		return false
+	}
+
+	count := 0
+	for _, existing := range routing.hops {
+		if existing.previous == hop.previous {
+			count++
+		}
+	}
+	if count >= onionCircuitMaxPerPeer {
+		return false
+	}
+
+	hop.expires = time.Now().Add(onionCircuitExpiry)
+	routing.hops[circuitID] = hop
+
+	return true
+}
+
+// onionSendTo sends the onion message to the next hop. If the peer is not known, the message is sent to the provided address.
+func (backend *Backend) onionSendTo(receiver *btcec.PublicKey, remote *net.UDPAddr, payload []byte) {
+	packet := &protocol.PacketRaw{Protocol: protocol.ProtocolVersion, Command: protocol.CommandOnion, Payload: payload}
+
+	if peer := backend.PeerlistLookup(receiver); peer != nil {
+		peer.send(packet)
+		return
+	}
+
+	raw, err := protocol.PacketEncrypt(backend.PeerPrivateKey, receiver, packet)
+	if err != nil {
+		return
+	}
+
+	backend.networks.sendRawAllNetworks(remote, raw)
+}
+
+// onionBackward encrypts the data with the backward key and sends it to the previous hop.
+func (backend *Backend) onionBackward(hop *onionCircuitHop, data []byte) {
+	wrapped, err := protocol.OnionBackwardWrap(data, &hop.key)
+	if err != nil {
+		return
+	}
+
+	payload, err := protocol.EncodeOnion(protocol.OnionBackward, hop.previousID, wrapped)
+	if err != nil {
+		return
+	}
+
+	hop.previous.send(&protocol.PacketRaw{Command: protocol.CommandOnion, Payload: payload})
+}
+
+// onionReceive decrypts the data sent back through an own circuit and processes the embedded packet.
+func (backend *Backend) onionReceive(circuit *onionCircuitOwn, data []byte) {
+	var err error
+	for n := range circuit.keys {
+		if data, err = protocol.OnionBackwardUnwrap(data, &circuit.keys[n]); err != nil {
+			return
+		}
+	}
+
+	if len(data) < protocol.PacketLengthMin {
+		return
+	}
+
+	decoded, senderPublicKey, err := protocol.PacketDecrypt(data, circuit.identity.publicKey)
+	if err != nil || !senderPublicKey.IsEqual(circuit.target) || decoded.Protocol != 0 {
+		return
+	}
+
+	backend.lookupResponse(decoded, senderPublicKey, nil)
+}
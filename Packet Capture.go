@@ -0,0 +1,266 @@
+/*
+File Username:  Packet Capture.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Packet capture for debugging. While active, the metadata of decrypted incoming and outgoing packets (command, sequence, sizes, addresses, time)
+is recorded in a ring buffer per peer. The payload itself is never recorded. Optionally the records are also appended to a text file per peer.
+Capturing can be limited to a single peer. It is intended for protocol debugging in the field and disabled by default.
+
+Like the PacketIn and PacketOut filters, capturing covers regular packets only. Traverse, lookup relay and local discovery broadcasts are not recorded.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// captureDefaultRecords is the default count of records kept per peer.
+const captureDefaultRecords = 1000
+
+// captureMaxPeers is the max count of peers captured. Packets of additional peers are not recorded.
+const captureMaxPeers = 1000
+
+// CaptureOptions are the settings of a packet capture.
+type CaptureOptions struct {
+	Peer           *btcec.PublicKey // Only capture packets of this peer. Nil for all peers.
+	RecordsPerPeer int              // Max count of records kept in memory per peer. Older records are dropped. 0 for default.
+	Folder         string           // If set, the records are also appended to a text file per peer in this folder.
+}
+
+// CaptureRecord is the metadata of a captured packet.
+type CaptureRecord struct {
+	Time      time.Time        // Time the packet was received or sent.
+	Outgoing  bool             // Whether the packet was sent (true) or received (false).
+	PublicKey *btcec.PublicKey // Public key of the remote peer.
+	Local     string           // Local address as IP:Port.
+	Remote    string           // Remote address as IP:Port.
+	Command   uint8            // Command, see protocol.CommandX.
+	Sequence  uint32           // Sequence number.
+	Size      int              // Size of the decrypted payload.
+	SizeRaw   int              // Size of the encrypted packet on the wire.
+}
+
+// CaptureStatus is the status of the packet capture.
+type CaptureStatus struct {
+	Active  bool           // Whether capturing is active.
+	Started time.Time      // Start of the last capture. Zero if never started.
+	Stopped time.Time      // End of the last capture. Zero if active or never started.
+	Options CaptureOptions // Options of the last capture.
+	Peers   int            // Count of peers with records.
+	Records int            // Count of records in memory.
+	Dropped uint64         // Count of records dropped because a ring buffer was full or the max count of peers was reached.
+}
+
+type packetCapture struct {
+	active int32 // Atomic flag to avoid locking when capturing is disabled.
+	status CaptureStatus
+	peers  map[[btcec.PubKeyBytesLenCompressed]byte]*captureRing
+	files  map[[btcec.PubKeyBytesLenCompressed]byte]*os.File
+	sync.Mutex
+}
+
+// captureRing is the ring buffer of records of a single peer.
+type captureRing struct {
+	records []CaptureRecord
+	next    int
+}
+
+func (backend *Backend) initPacketCapture() {
+	backend.packetCapture = &packetCapture{}
+}
+
+// CaptureStart starts capturing packets. Records of a previous capture are discarded.
+func (backend *Backend) CaptureStart(options CaptureOptions) (err error) {
+	if options.RecordsPerPeer <= 0 {
+		options.RecordsPerPeer = captureDefaultRecords
+	}
+	if options.Folder != "" {
+		if err = os.MkdirAll(options.Folder, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	capture := backend.packetCapture
+	capture.Lock()
+	defer capture.Unlock()
+
+	if capture.status.Active {
+		return errors.New("capture already active")
+	}
+
+	capture.peers = make(map[[btcec.PubKeyBytesLenCompressed]byte]*captureRing)
+	capture.files = make(map[[btcec.PubKeyBytesLenCompressed]byte]*os.File)
+	capture.status = CaptureStatus{Active: true, Started: time.Now(), Options: options}
+
+	atomic.StoreInt32(&capture.active, 1)
+
+	return nil
+}
+
+// CaptureStop stops capturing packets. The records are kept until the next capture is started.
+func (backend *Backend) CaptureStop() {
+	capture := backend.packetCapture
+	capture.Lock()
+	defer capture.Unlock()
+
+	if !capture.status.Active {
+		return
+	}
+
+	atomic.StoreInt32(&capture.active, 0)
+	capture.status.Active = false
+	capture.status.Stopped = time.Now()
+
+	for _, file := range capture.files {
+		file.Close()
+	}
+	capture.files = nil
+}
+
+// CaptureStatus returns the status of the packet capture.
+func (backend *Backend) CaptureStatus() (status CaptureStatus) {
+	capture := backend.packetCapture
+	capture.Lock()
+	defer capture.Unlock()
+
+	status = capture.status
+	status.Peers = len(capture.peers)
+	for _, ring := range capture.peers {
+		status.Records += ring.count()
+	}
+
+	return status
+}
+
+// CaptureRecords returns the captured records sorted by time. If publicKey is not nil, only the records of that peer are returned.
+func (backend *Backend) CaptureRecords(publicKey *btcec.PublicKey) (records []CaptureRecord) {
+	capture := backend.packetCapture
+	capture.Lock()
+	defer capture.Unlock()
+
+	for key, ring := range capture.peers {
+		if publicKey != nil && key != publicKey2Compressed(publicKey) {
+			continue
+		}
+		records = append(records, ring.list()...)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	return records
+}
+
+// captureIn records an incoming decrypted packet.
+func (backend *Backend) captureIn(packet *protocol.PacketRaw, sizeRaw int, senderPublicKey *btcec.PublicKey, connection *Connection) {
+	if atomic.LoadInt32(&backend.packetCapture.active) == 0 {
+		return
+	}
+
+	backend.packetCapture.add(CaptureRecord{Time: time.Now(), PublicKey: senderPublicKey, Local: networkAddress(connection.Network), Remote: connection.Address.String(), Command: packet.Command, Sequence: packet.Sequence, Size: len(packet.Payload), SizeRaw: sizeRaw})
+}
+
+// captureOut records an outgoing packet.
+func (backend *Backend) captureOut(packet *protocol.PacketRaw, sizeRaw int, receiverPublicKey *btcec.PublicKey, connection *Connection) {
+	if atomic.LoadInt32(&backend.packetCapture.active) == 0 {
+		return
+	}
+
+	backend.packetCapture.add(CaptureRecord{Time: time.Now(), Outgoing: true, PublicKey: receiverPublicKey, Local: networkAddress(connection.Network), Remote: connection.Address.String(), Command: packet.Command, Sequence: packet.Sequence, Size: len(packet.Payload), SizeRaw: sizeRaw})
+}
+
+func (capture *packetCapture) add(record CaptureRecord) {
+	capture.Lock()
+	defer capture.Unlock()
+
+	if !capture.status.Active || capture.status.Options.Peer != nil && !capture.status.Options.Peer.IsEqual(record.PublicKey) {
+		return
+	}
+
+	key := publicKey2Compressed(record.PublicKey)
+
+	ring := capture.peers[key]
+	if ring == nil {
+		if len(capture.peers) >= captureMaxPeers {
+			capture.status.Dropped++
+			return
+		}
+		ring = &captureRing{}
+		capture.peers[key] = ring
+	}
+
+	if ring.add(record, capture.status.Options.RecordsPerPeer) {
+		capture.status.Dropped++
+	}
+
+	if capture.status.Options.Folder != "" {
+		capture.writeFile(key, record)
+	}
+}
+
+// writeFile appends the record to the capture file of the peer. The file is named after the peer ID.
+func (capture *packetCapture) writeFile(key [btcec.PubKeyBytesLenCompressed]byte, record CaptureRecord) {
+	file := capture.files[key]
+	if file == nil {
+		var err error
+		filename := filepath.Join(capture.status.Options.Folder, "capture "+hex.EncodeToString(key[:])+".txt")
+		if file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+			return
+		}
+		capture.files[key] = file
+	}
+
+	file.WriteString(record.String() + "\n")
+}
+
+// add adds the record. It returns true if the oldest record was overwritten.
+func (ring *captureRing) add(record CaptureRecord, max int) (overwritten bool) {
+	if len(ring.records) < max {
+		ring.records = append(ring.records, record)
+		return false
+	}
+
+	ring.records[ring.next] = record
+	ring.next = (ring.next + 1) % max
+	return true
+}
+
+func (ring *captureRing) count() int {
+	return len(ring.records)
+}
+
+// list returns the records in chronological order.
+func (ring *captureRing) list() (records []CaptureRecord) {
+	records = make([]CaptureRecord, 0, len(ring.records))
+	records = append(records, ring.records[ring.next:]...)
+	return append(records, ring.records[:ring.next]...)
+}
+
+// String returns the record as single line of text in the form: time direction local remote command sequence size raw-size.
+func (record CaptureRecord) String() string {
+	direction, from, to := "IN ", record.Remote, record.Local
+	if record.Outgoing {
+		direction, from, to = "OUT", record.Local, record.Remote
+	}
+
+	return fmt.Sprintf("%s %s %s -> %s %s seq=%d size=%d raw=%d", record.Time.UTC().Format("2006-01-02T15:04:05.000000Z"), direction, from, to, protocol.CommandName(record.Command), record.Sequence, record.Size, record.SizeRaw)
+}
+
+func networkAddress(network *Network) string {
+	if network == nil || network.address == nil {
+		return ""
+	}
+	return network.address.String()
+}
@@ -24,6 +24,17 @@ func (backend *Backend) initPeerID() {
 	backend.PeerList = make(map[[btcec.PubKeyBytesLenCompressed]byte]*PeerInfo)
 	backend.nodeList = make(map[[protocol.HashSize]byte]*PeerInfo)
 
+	// Observers use an ephemeral key that is never saved.
+	if backend.Config.Observer {
+		var err error
+		if backend.PeerPrivateKey, backend.PeerPublicKey, err = Secp256k1NewPrivateKey(); err != nil {
+			backend.LogError("initPeerID", "generating ephemeral public-private key pair: %s\n", err.Error())
+			os.Exit(ExitPrivateKeyCreate)
+		}
+		backend.nodeID = protocol.PublicKey2NodeID(backend.PeerPublicKey)
+		return
+	}
+
 	// load existing key from config, if available
 	if len(backend.Config.PrivateKey) > 0 {
 		configPK, err := hex.DecodeString(backend.Config.PrivateKey)
@@ -93,12 +104,16 @@ type PeerInfo struct {
 	IsRootPeer            bool             // Whether the peer is a trusted root peer.
 	UserAgent             string           // User Agent reported by remote peer. Empty if no Announcement/Response message was yet received.
 	Features              uint8            // Feature bit array. 0 = IPv4_LISTEN, 1 = IPv6_LISTEN, 1 = FIREWALL
+	FeaturesExt           uint8            // Extended feature bit array. See protocol.FeatureExtX.
 	isVirtual             bool             // Whether it is a virtual peer for establishing a connection.
 	targetAddresses       []*peerAddress   // Virtual peer: Addresses to send any replies.
 	traversePeer          *PeerInfo        // Virtual peer: Same field as in connection.
 	BlockchainHeight      uint64           // Blockchain height
 	BlockchainVersion     uint64           // Blockchain version
 	blockchainLastRefresh time.Time        // Last refresh of the blockchain info.
+	added                 time.Time        // When the peer was added to the peer list.
+	piggyback             piggybackQueue   // INFO_STORE records pending to be piggybacked on pong or response messages.
+	sla                   announcementSLA  // Response times of Announcements sent to the peer.
 
 	// statistics
 	StatsPacketSent     uint64 // Count of packets sent
@@ -128,8 +143,9 @@ func (backend *Backend) PeerlistAdd(PublicKey *btcec.PublicKey, connections ...*
 		return peer, false
 	}
 
-	peer = &PeerInfo{Backend: backend, PublicKey: PublicKey, connectionActive: connections, connectionLatest: connections[0], NodeID: protocol.PublicKey2NodeID(PublicKey), messageSequence: rand.Uint32()}
+	peer = &PeerInfo{Backend: backend, PublicKey: PublicKey, connectionActive: connections, connectionLatest: connections[0], NodeID: protocol.PublicKey2NodeID(PublicKey), messageSequence: rand.Uint32(), added: time.Now()}
 	_, peer.IsRootPeer = rootPeers[publicKeyCompressed]
+	peer.sessionResumePeer()
 
 	backend.PeerList[publicKeyCompressed] = peer
 
@@ -153,6 +169,8 @@ func (backend *Backend) PeerlistAdd(PublicKey *btcec.PublicKey, connections ...*
 
 	backend.Filters.NewPeer(peer, connections[0])
 	backend.Filters.NewPeerConnection(peer, connections[0])
+	peer.peerWatchAdded()
+	backend.natAttemptSuccess(PublicKey)
 
 	return peer, true
 }
@@ -171,6 +189,8 @@ func (backend *Backend) PeerlistRemove(peer *PeerInfo) {
 	copy(nodeID[:], peer.NodeID)
 
 	delete(backend.nodeList, nodeID)
+
+	peer.peerWatchRemoved()
 }
 
 // PeerlistGet returns the full peer list
@@ -284,8 +304,12 @@ func (backend *Backend) unregisterPeerMonitor(channel chan<- *PeerInfo) {
 	}
 }
 
-// DeleteAccount deletes the account
+// DeleteAccount deletes the account. Observers have no account to delete.
 func (backend *Backend) DeleteAccount() {
+	if backend.Config.Observer {
+		return
+	}
+
 	// delete the blockchain
 	backend.UserBlockchain.DeleteBlockchain()
 
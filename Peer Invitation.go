@@ -0,0 +1,239 @@
+/*
+File Username:  Peer Invitation.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Invitations bootstrap new nodes into private deployments without a seed list or local peer discovery. A node creates a time-limited
+invitation containing its peer ID and addresses, signed by its private key. The invitation is passed out-of-band (as text blob) to the new
+node which verifies it and contacts the inviting node. Through it, the new node learns about the other peers of the swarm via FIND_SELF.
+
+The invitation can optionally be persisted as seed list entry, so that the new node reconnects to the inviting node after a restart.
+In that case AutoUpdateSeedList is disabled, so that the entry is not overwritten by an update of the default seed list.
+*/
+
+package core
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// invitationVersion is the version of the invitation document.
+const invitationVersion = 1
+
+// invitationPrefix is the prefix of the encoded invitation blob.
+const invitationPrefix = "peernet-invite:"
+
+// InvitationValidityDefault is the default validity of invitations.
+const InvitationValidityDefault = 24 * time.Hour
+
+// Timings for contacting the inviting peer when accepting an invitation.
+const (
+	invitationContactTimeout = 10 * time.Second
+	invitationContactRetry   = 2 * time.Second
+)
+
+// Invitation is a signed document to bootstrap into a private swarm. The signature covers the JSON encoding of the document with an empty signature.
+type Invitation struct {
+	Version   int       `json:"version"`   // Version of the document, see invitationVersion.
+	PeerID    string    `json:"peerid"`    // Peer ID of the inviting peer, hex encoded.
+	Addresses []string  `json:"addresses"` // Addresses of the inviting peer in the form "IP:Port".
+	Created   time.Time `json:"created"`   // Time the invitation was created.
+	Expires   time.Time `json:"expires"`   // Time the invitation expires.
+	Signature string    `json:"signature"` // Compact secp256k1 signature of the blake3 hash of the document, hex encoded.
+}
+
+// signatureHash returns the hash of the invitation that is signed.
+func (invitation Invitation) signatureHash() (hash []byte, err error) {
+	invitation.Signature = ""
+
+	data, err := json.Marshal(invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	return protocol.HashData(data), nil
+}
+
+// invitationAddresses returns the addresses of all networks that other peers may connect to. Link-local and unspecified addresses are excluded.
+func (backend *Backend) invitationAddresses() (addresses []string) {
+	unique := make(map[string]struct{})
+	add := func(ip net.IP, port int) {
+		if ip == nil || port == 0 || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+			return
+		}
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		if _, ok := unique[address]; !ok {
+			unique[address] = struct{}{}
+			addresses = append(addresses, address)
+		}
+	}
+
+	for _, networks := range [][]*Network{backend.GetNetworks(4), backend.GetNetworks(6)} {
+		for _, network := range networks {
+			listen, _, _, ipExternal, portExternal := network.GetListen()
+			add(listen.IP, listen.Port)
+
+			if portExternal == 0 {
+				portExternal = uint16(listen.Port)
+			}
+			add(ipExternal, int(portExternal))
+		}
+	}
+
+	return addresses
+}
+
+// InvitationCreate creates an invitation signed by the peer's private key that is valid for the given duration. If no addresses are
+// provided, the addresses of the current networks are used. The returned blob is the text to pass to the invited node.
+func (backend *Backend) InvitationCreate(validity time.Duration, addresses []string) (blob string, invitation Invitation, err error) {
+	if validity <= 0 {
+		validity = InvitationValidityDefault
+	}
+
+	if len(addresses) == 0 {
+		addresses = backend.invitationAddresses()
+	}
+	for _, address := range addresses {
+		if _, err := parseAddress(address); err != nil {
+			return "", invitation, errors.New("invalid address '" + address + "'")
+		}
+	}
+	if len(addresses) == 0 {
+		return "", invitation, errors.New("no address available")
+	}
+
+	now := time.Now().UTC()
+	invitation = Invitation{Version: invitationVersion, PeerID: hex.EncodeToString(backend.PeerPublicKey.SerializeCompressed()), Addresses: addresses, Created: now, Expires: now.Add(validity)}
+
+	hash, err := invitation.signatureHash()
+	if err != nil {
+		return "", invitation, err
+	}
+
+	signature, err := btcec.SignCompact(btcec.S256(), backend.PeerPrivateKey, hash, true)
+	if err != nil {
+		return "", invitation, err
+	}
+	invitation.Signature = hex.EncodeToString(signature)
+
+	data, err := json.Marshal(invitation)
+	if err != nil {
+		return "", invitation, err
+	}
+
+	return invitationPrefix + base64.RawURLEncoding.EncodeToString(data), invitation, nil
+}
+
+// InvitationDecode decodes the invitation blob and verifies the signature and expiry.
+func InvitationDecode(blob string) (invitation Invitation, publicKey *btcec.PublicKey, addresses []*net.UDPAddr, err error) {
+	if len(blob) < len(invitationPrefix) || blob[:len(invitationPrefix)] != invitationPrefix {
+		return invitation, nil, nil, errors.New("invalid invitation")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(blob[len(invitationPrefix):])
+	if err != nil {
+		return invitation, nil, nil, errors.New("invalid invitation")
+	} else if err = json.Unmarshal(data, &invitation); err != nil {
+		return invitation, nil, nil, errors.New("invalid invitation")
+	} else if invitation.Version != invitationVersion {
+		return invitation, nil, nil, errors.New("unsupported invitation version")
+	}
+
+	if publicKey, err = PublicKeyFromPeerID(invitation.PeerID); err != nil {
+		return invitation, nil, nil, errors.New("invalid peer ID")
+	}
+
+	signature, err := hex.DecodeString(invitation.Signature)
+	if err != nil {
+		return invitation, nil, nil, errors.New("invalid signature")
+	}
+
+	hash, err := invitation.signatureHash()
+	if err != nil {
+		return invitation, nil, nil, err
+	}
+
+	if recovered, _, err := btcec.RecoverCompact(btcec.S256(), signature, hash); err != nil || !recovered.IsEqual(publicKey) {
+		return invitation, nil, nil, errors.New("invalid signature")
+	}
+
+	if time.Now().After(invitation.Expires) {
+		return invitation, nil, nil, errors.New("invitation expired")
+	}
+
+	for _, addressA := range invitation.Addresses {
+		address, err := parseAddress(addressA)
+		if err != nil {
+			return invitation, nil, nil, errors.New("invalid address '" + addressA + "'")
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		return invitation, nil, nil, errors.New("no address in invitation")
+	}
+
+	return invitation, publicKey, addresses, nil
+}
+
+// InvitationAccept verifies the invitation and contacts the inviting peer. It waits until the peer is connected or the contact times out.
+// If persist is true, the inviting peer is added to the seed list and AutoUpdateSeedList is disabled.
+func (backend *Backend) InvitationAccept(blob string, persist bool) (invitation Invitation, connected bool, err error) {
+	invitation, publicKey, addresses, err := InvitationDecode(blob)
+	if err != nil {
+		return invitation, false, err
+	} else if publicKey.IsEqual(backend.PeerPublicKey) {
+		return invitation, false, errors.New("invitation of self")
+	}
+
+	if persist {
+		backend.invitationPersist(invitation)
+	}
+
+	for start := time.Now(); time.Since(start) < invitationContactTimeout; {
+		if backend.PeerlistLookup(publicKey) != nil {
+			return invitation, true, nil
+		}
+
+		// Port internal is set to 0 same as for root peers. It disables NAT detection and will not send out a Traverse message.
+		for _, address := range addresses {
+			backend.contactArbitraryPeer(publicKey, address, 0, 0)
+		}
+
+		for n := 0; n < int(invitationContactRetry/(100*time.Millisecond)); n++ {
+			time.Sleep(100 * time.Millisecond)
+			if backend.PeerlistLookup(publicKey) != nil {
+				return invitation, true, nil
+			}
+		}
+	}
+
+	return invitation, false, nil
+}
+
+// invitationPersist adds the inviting peer to the seed list, replacing an existing entry of the same peer. It takes effect on the next start.
+func (backend *Backend) invitationPersist(invitation Invitation) {
+	seed := PeerSeed{PublicKey: invitation.PeerID, Address: invitation.Addresses}
+
+	replaced := false
+	for n := range backend.Config.SeedList {
+		if backend.Config.SeedList[n].PublicKey == seed.PublicKey {
+			backend.Config.SeedList[n] = seed
+			replaced = true
+		}
+	}
+	if !replaced {
+		backend.Config.SeedList = append(backend.Config.SeedList, seed)
+	}
+
+	backend.Config.AutoUpdateSeedList = false
+	backend.SaveConfig()
+}
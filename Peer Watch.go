@@ -0,0 +1,161 @@
+/*
+File Username:  Peer Watch.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Peer watch notifies when specific peers come online, for example to implement "notify me when this user is online". A watched peer is online
+while it is in the peer list. Peers are added to the peer list on incoming announcements and when they are found via DHT FIND_PEER searches.
+Offline watched peers are searched regularly in the DHT. When a watched peer becomes reachable, the filter PeerOnline is called and the webhook
+event peer-online is sent.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// peerWatchMax is the max count of watched peers.
+const peerWatchMax = 1000
+
+// peerWatchInterval is the interval to search offline watched peers in the DHT.
+const peerWatchInterval = 2 * time.Minute
+
+// peerWatchSearchTimeout is the timeout for a single search of a watched peer.
+const peerWatchSearchTimeout = 10 * time.Second
+
+// PeerWatchStatus is the status of a watched peer.
+type PeerWatchStatus struct {
+	PublicKey   *btcec.PublicKey // Public key of the watched peer.
+	Online      bool             // Whether the peer is currently in the peer list.
+	LastOnline  time.Time        // Last time the peer came online. Zero if not seen since it is watched.
+	LastOffline time.Time        // Last time the peer was removed from the peer list. Zero if not seen since it is watched.
+	Added       time.Time        // When the peer was added to the watch list.
+}
+
+// WebhookPeer is the data of the peer-online event.
+type WebhookPeer struct {
+	PeerID string `json:"peerid"` // Peer ID of the remote peer, hex encoded.
+	NodeID string `json:"nodeid"` // Node ID of the remote peer, hex encoded.
+}
+
+type peerWatch struct {
+	peers map[[btcec.PubKeyBytesLenCompressed]byte]*PeerWatchStatus
+	sync.RWMutex
+}
+
+func (backend *Backend) initPeerWatch() {
+	backend.peerWatch = &peerWatch{peers: make(map[[btcec.PubKeyBytesLenCompressed]byte]*PeerWatchStatus)}
+
+	backend.scheduleTask("peer-watch", peerWatchInterval, peerWatchInterval, func() error {
+		for _, status := range backend.WatchedPeers() {
+			if !status.Online {
+				backend.peerWatchSearch(status.PublicKey)
+			}
+		}
+		return nil
+	})
+}
+
+// WatchPeer adds the peer to the watch list. If the peer is offline, it is immediately searched in the DHT.
+// Watching an already watched peer does nothing.
+func (backend *Backend) WatchPeer(publicKey *btcec.PublicKey) (err error) {
+	key := publicKey2Compressed(publicKey)
+	online := backend.PeerlistLookup(publicKey) != nil
+
+	watch := backend.peerWatch
+	watch.Lock()
+
+	if _, ok := watch.peers[key]; ok {
+		watch.Unlock()
+		return nil
+	} else if len(watch.peers) >= peerWatchMax {
+		watch.Unlock()
+		return errors.New("too many watched peers")
+	}
+
+	watch.peers[key] = &PeerWatchStatus{PublicKey: publicKey, Online: online, Added: time.Now()}
+	watch.Unlock()
+
+	if !online {
+		go backend.peerWatchSearch(publicKey)
+	}
+
+	return nil
+}
+
+// UnwatchPeer removes the peer from the watch list. It returns false if the peer was not watched.
+func (backend *Backend) UnwatchPeer(publicKey *btcec.PublicKey) (removed bool) {
+	key := publicKey2Compressed(publicKey)
+
+	watch := backend.peerWatch
+	watch.Lock()
+	defer watch.Unlock()
+
+	if _, removed = watch.peers[key]; removed {
+		delete(watch.peers, key)
+	}
+
+	return removed
+}
+
+// WatchedPeers returns the status of all watched peers.
+func (backend *Backend) WatchedPeers() (peers []PeerWatchStatus) {
+	watch := backend.peerWatch
+	watch.RLock()
+	defer watch.RUnlock()
+
+	for _, status := range watch.peers {
+		peers = append(peers, *status)
+	}
+
+	return peers
+}
+
+// peerWatchSearch searches the peer in the DHT. If found, it is added to the peer list which triggers the online event.
+// If the peer is already in the peer list (it was added while the watch was set up), the online event is fired directly.
+func (backend *Backend) peerWatchSearch(publicKey *btcec.PublicKey) {
+	if peer := backend.PeerlistLookup(publicKey); peer != nil {
+		peer.peerWatchAdded()
+		return
+	}
+
+	backend.FindNode(protocol.PublicKey2NodeID(publicKey), peerWatchSearchTimeout)
+}
+
+// peerWatchAdded is called when the peer is added to the peer list. If the peer is watched and was offline, the online event is fired.
+func (peer *PeerInfo) peerWatchAdded() {
+	watch := peer.Backend.peerWatch
+	watch.Lock()
+
+	status := watch.peers[publicKey2Compressed(peer.PublicKey)]
+	if status == nil || status.Online {
+		watch.Unlock()
+		return
+	}
+
+	status.Online = true
+	status.LastOnline = time.Now()
+	watch.Unlock()
+
+	peer.Backend.Filters.PeerOnline(peer)
+	peer.Backend.SendWebhook(WebhookPeerOnline, peer.PublicKey, WebhookPeer{PeerID: hex.EncodeToString(peer.PublicKey.SerializeCompressed()), NodeID: hex.EncodeToString(peer.NodeID)})
+}
+
+// peerWatchRemoved is called when the peer is removed from the peer list.
+func (peer *PeerInfo) peerWatchRemoved() {
+	watch := peer.Backend.peerWatch
+	watch.Lock()
+	defer watch.Unlock()
+
+	if status := watch.peers[publicKey2Compressed(peer.PublicKey)]; status != nil && status.Online {
+		status.Online = false
+		status.LastOffline = time.Now()
+	}
+}
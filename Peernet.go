@@ -7,6 +7,7 @@ Author:     Peter Kleissner
 package core
 
 import (
+	"io"
 	"sync"
 
 	"github.com/PeernetOfficial/core/blockchain"
@@ -40,6 +41,8 @@ func Init(UserAgent string, ConfigFilename string, Filters *Filters, ConfigOut i
 	if status, err = LoadConfig(ConfigFilename, &backend.Config); status != ExitSuccess {
 		return nil, status, err
 	}
+	backend.logTarget = int32(backend.Config.LogTarget)
+
 	if ConfigOut != nil {
 		if status, err = LoadConfig(ConfigFilename, ConfigOut); status != ExitSuccess {
 			return nil, status, err
@@ -47,25 +50,82 @@ func Init(UserAgent string, ConfigFilename string, Filters *Filters, ConfigOut i
 		backend.ConfigClient = ConfigOut
 	}
 
+	backend.initDataLayout()
+
 	if err = backend.initLog(); err != nil {
 		return nil, ExitErrorLogInit, err
 	}
 
+	// A private key imported via recovery phrase is applied before the self-test, which checks that the blockchain matches the key.
+	if err = backend.applyPrivateKeyImport(); err != nil {
+		return nil, ExitBlockchainCorrupt, err
+	}
+
+	// The self-test reports all problems of the environment at once, instead of failing in the first init function that hits one.
+	for _, failure := range backend.selfTest() {
+		backend.LogError("Init", "self-test: %s\n", failure.String())
+		if failure.Fatal {
+			status = ExitSelfTestFailed
+		}
+		backend.selfTestFailures = append(backend.selfTestFailures, failure)
+	}
+	if status == ExitSelfTestFailed {
+		return nil, status, &SelfTestError{Failures: backend.selfTestFailures}
+	}
+
 	backend.initFilters()
+	backend.initScheduler()
+	backend.initAdmin()
+	backend.initCongestion()
+	backend.initNetworkSize()
+	backend.initLatencyMap()
+	backend.initClockSync()
 	backend.initPeerID()
 	backend.initUserBlockchain()
 	backend.initUserWarehouse()
+	backend.initMetadataOnly()
 	backend.initKademlia()
 	backend.initMessageSequence()
 	backend.initSeedList()
+	backend.initSessionTickets()
+	backend.initLookupPrivacy()
+	backend.initOnionRouting()
+	backend.initFileStats()
+	backend.initCredits()
+	backend.initTransferHistory()
+	backend.initContentSummary()
+	backend.initNATDetection()
+	backend.initNATTelemetry()
+	backend.initHolePunch()
+	backend.initTrafficRelay()
+	backend.initStreamServices()
+	backend.initGroupChannels()
+	backend.initDisplayNames()
+	backend.initHashtags()
+	backend.initSoftwareUpdate()
+	backend.initDenialLists()
+	backend.initBanList()
+	backend.initFolderSync()
+	backend.initStorageAgreements()
+	backend.initWebhooks()
+	backend.initTracing()
 	initMulticastIPv6()
 	initBroadcastIPv4()
 	backend.initStore()
+	backend.initInfoStore()
+	backend.initMerkleTreeService()
+	backend.initDelegatedPublish()
+	backend.initPacketCapture()
+	backend.initEmbeddedFileGuard()
+	backend.initProtocolErrors()
+	backend.initBenchmark()
+	backend.initPeerWatch()
 	backend.initNetwork()
+	backend.initNetworkResume()
 	backend.initBlockchainCache()
 
-	if backend.SearchIndex, err = search.InitSearchIndexStore(backend.Config.SearchIndex); err != nil {
-		backend.LogError("Init", "search index '%s' init: %s", backend.Config.SearchIndex, err.Error())
+	if backend.SearchIndex, err = search.InitSearchIndexStore(backend.DataLayout.SearchIndex); err != nil {
+		backend.LogError("Init", "search index '%s' init: %s", backend.DataLayout.SearchIndex, err.Error())
 	} else {
 		backend.userBlockchainUpdateSearchIndex()
 	}
@@ -80,8 +140,86 @@ func (backend *Backend) Connect() {
 	go backend.networks.autoMulticastBroadcast()
 	go backend.autoPingAll()
 	go backend.networks.networkChangeMonitor()
+	go backend.autoResumeDetect()
 	go backend.networks.startUPnP()
-	go backend.autoBucketRefresh()
+	go backend.autoWebhooks()
+	go backend.autoTraceExport()
+
+	// Regular tasks
+	backend.scheduleBucketRefresh()
+	backend.scheduleContentSummary()
+	backend.scheduleWarehouseGC()
+	backend.scheduleWarehouseQuota()
+	backend.scheduleWarehouseScrub()
+	backend.scheduleFolderSync()
+	backend.scheduleStorageChallenges()
+	backend.scheduleSoftwareUpdate()
+	backend.scheduleDenialLists()
+	backend.scheduleKeywordIndex()
+	backend.scheduleTask("session-ticket-expiry", sessionTicketExpiry, sessionTicketExpiry, backend.expireSessionTickets)
+	backend.scheduleTask("lookup-privacy-expiry", lookupPrivacyExpiry/4, lookupPrivacyExpiry/4, backend.expireLookupPrivacy)
+	backend.scheduleTask("onion-routing-expiry", onionCircuitExpiry/4, onionCircuitExpiry/4, backend.expireOnionRouting)
+	backend.scheduleTask("nat-detection", natDetectionStartDelay, natDetectionInterval, backend.natDetect)
+	backend.scheduleTask("nat-attempt-expiry", natAttemptTimeout, natAttemptTimeout, backend.expireNATAttempts)
+	backend.scheduleTask("hole-punch-expiry", holePunchExpiryInterval, holePunchExpiryInterval, backend.expireHolePunch)
+	backend.scheduleTask("traffic-relay-expiry", trafficRelayIdle/4, trafficRelayIdle/4, backend.expireTrafficRelay)
+	backend.scheduleTask("group-seen-expiry", groupSeenExpiry, groupSeenExpiry, backend.expireGroupSeen)
+	backend.scheduleTask("latency-map-expiry", latencyExpiry/4, latencyExpiry/4, backend.expireLatencyMap)
+	backend.scheduleTask("ban-list-expiry", banListExpiryInterval, banListExpiryInterval, backend.expireBanList)
+
+	if !backend.Config.Observer {
+		backend.scheduleTask("file-expiry", fileExpiryCheckInterval, fileExpiryCheckInterval, backend.deleteExpiredFiles)
+	}
+
+	if backend.GlobalBlockchainCache != nil {
+		backend.scheduleTask("blockchain-cache-refresh", blockchainCacheRefreshInterval, blockchainCacheRefreshInterval, backend.refreshBlockchainCache)
+		backend.scheduleMaintenanceTask("blockchain-cache-retention", blockchainCacheRetentionInterval, blockchainCacheRetentionInterval, backend.enforceBlockchainCacheRetention)
+	}
+}
+
+// Close shuts down the backend: It stops the scheduled tasks and the network listeners and closes the stores, so that all data is flushed.
+// The backend must not be used afterwards.
+func (backend *Backend) Close() {
+	// Scheduled tasks are stopped first, so that they do not access the stores while they are closed.
+	backend.scheduler.Lock()
+	for name, task := range backend.scheduler.tasks {
+		close(task.stop)
+		delete(backend.scheduler.tasks, name)
+	}
+	backend.scheduler.Unlock()
+
+	backend.networks.RLock()
+	networks := append(append([]*Network{}, backend.networks.networks4...), backend.networks.networks6...)
+	backend.networks.RUnlock()
+
+	for _, network := range networks {
+		network.Terminate()
+	}
+
+	closeStore := func(name string, database interface{}) {
+		if closer, ok := database.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				backend.LogError("Close", "closing %s: %v\n", name, err)
+			}
+		}
+	}
+
+	if backend.UserBlockchain != nil {
+		if err := backend.UserBlockchain.Close(); err != nil {
+			backend.LogError("Close", "closing user blockchain: %v\n", err)
+		}
+	}
+	if backend.GlobalBlockchainCache != nil {
+		closeStore("blockchain cache", backend.GlobalBlockchainCache.Store.Database)
+	}
+	if backend.SearchIndex != nil {
+		backend.SearchIndex.Lock()
+		closeStore("search index", backend.SearchIndex.Database)
+		backend.SearchIndex.Unlock()
+	}
+	if backend.transferHistory != nil {
+		closeStore("transfer history", backend.transferHistory.database)
+	}
 }
 
 // The Backend represents an instance of a Peernet client to be used by a frontend.
@@ -90,12 +228,14 @@ type Backend struct {
 	ConfigFilename        string                   // Filename of the configuration file.
 	Config                *Config                  // Core configuration
 	ConfigClient          interface{}              // Custom configuration from the client
+	DataLayout            DataLayout               // Resolved locations of data files and folders.
 	Filters               Filters                  // Filters allow to install hooks.
 	userAgent             string                   // User Agent
 	GlobalBlockchainCache *BlockchainCache         // Caches blockchains of other peers.
 	SearchIndex           *search.SearchIndexStore // Search index of blockchain records.
 	networks              *Networks                // All connected networks.
 	dhtStore              store.Store              // dhtStore contains all key-value data served via DHT
+	infoStore             *infoStore               // infoStore contains INFO_STORE records of files stored by other peers
 	UserBlockchain        *blockchain.Blockchain   // UserBlockchain is the user's blockchain and exports functions to directly read and write it
 	UserWarehouse         *warehouse.Warehouse     // UserWarehouse is the user's warehouse for storing files that are shared
 	nodesDHT              *dht.DHT                 // Nodes connected in the DHT.
@@ -117,6 +257,129 @@ type Backend struct {
 	// peerMonitor is a list of channels receiving information about new peers
 	peerMonitor []chan<- *PeerInfo
 
+	// peerWatch keeps track of watched peers to notify when they come online.
+	peerWatch *peerWatch
+
+	// textExtractor is the optional text extractor provided by the application, see SetTextExtractor.
+	textExtractor TextExtractor
+
+	// sessionTickets allow returning peers to resume their session without a full Announcement/Response exchange.
+	sessionTickets *sessionTickets
+
+	// lookupPrivacy keeps track of ephemeral lookup identities and relayed lookups.
+	lookupPrivacy *lookupPrivacy
+
+	// onionRouting keeps track of own and relayed onion circuits.
+	onionRouting *onionRouting
+
+	// supernodeTransfers is the count of active block mirror transfers served as supernode.
+	supernodeTransfers int32
+
+	// fileStats contains access statistics of locally stored files.
+	fileStats *fileStats
+
+	// credits contains the byte credit of peers for file transfers.
+	credits *peerCredits
+
+	// transferHistory is the ledger of file transfers.
+	transferHistory *transferHistory
+
+	// warehouseScrub is the status of the warehouse scrubber.
+	warehouseScrub *warehouseScrub
+
+	// contentSummaries contains the local content summary and the ones received from connected peers.
+	contentSummaries *contentSummaries
+
+	// natDetection contains the detected NAT type.
+	natDetection *natDetection
+
+	// natTelemetry contains the success rates of NAT traversal strategies.
+	natTelemetry *natTelemetry
+
+	// holePunch contains the hole punching sessions of this peer and the ones coordinated as relay.
+	holePunch *holePunch
+
+	// trafficRelay contains the accounting of traffic forwarded as relay.
+	trafficRelay *trafficRelay
+
+	// selfTestFailures contains the failures of the startup self-test.
+	selfTestFailures []SelfTestFailure
+
+	// metadataFetchQueue contains small files of followed peers to download in metadata-only mode. Nil if disabled.
+	metadataFetchQueue chan metadataFetch
+
+	// streamServices contains the handlers of services that remote peers can open streams to.
+	streamServices *streamServices
+
+	// groupChannels contains the state of group channels, including sender keys and received messages.
+	groupChannels *groupChannels
+
+	// displayNames contains the profile names seen for each peer to detect impersonation.
+	displayNames *displayNames
+
+	// hashtags contains the hashtags seen in cached blockchains and their trending score.
+	hashtags *hashtags
+
+	// softwareUpdate contains the publisher and the status of the software update channel.
+	softwareUpdate *softwareUpdate
+
+	// denialLists contains the subscribed denial lists and the local overrides.
+	denialLists *denialLists
+
+	// banList is the index of banned peers and IP addresses.
+	banList *banList
+
+	// networkResume keeps track of handling the resume from sleep.
+	networkResume *networkResume
+
+	// packetCapture records packet metadata for debugging.
+	packetCapture *packetCapture
+
+	// benchmark keeps track of benchmark transfers served to other peers.
+	benchmark *benchmarkServer
+
+	// embeddedFileGuard limits embedded file data accepted from responders and blocks responders sending invalid data.
+	embeddedFileGuard *embeddedFileGuard
+
+	// protocolErrors counts protocol errors per peer and bans peers exceeding the threshold.
+	protocolErrors *protocolErrors
+
+	// syncFolders are the folders synced with trusted peers, by name.
+	syncFolders map[string]*syncFolder
+
+	// storageProofs contains the results of proof-of-storage challenges sent to storage providers.
+	storageProofs *storageProofs
+
+	// scheduler runs regular background tasks.
+	scheduler *scheduler
+
+	// congestion is the network-wide congestion estimate used to slow down announcement and maintenance traffic.
+	congestion *congestionState
+
+	// networkSize contains the state for scaling maintenance traffic by the estimated network size.
+	networkSize *networkSize
+
+	// latencyMap contains the RTTs between other peers as reported by them. It is used to select relays.
+	latencyMap *latencyMap
+
+	// warehouseQuota contains the pinned files and eviction statistics of the warehouse.
+	warehouseQuota *warehouseQuota
+
+	// clockSync contains the clock offsets of peers to estimate the network time.
+	clockSync *clockSync
+
+	// webhookQueue contains events to be sent to the configured webhooks.
+	webhookQueue chan webhookDelivery
+
+	// traceQueue contains spans to be exported. Nil if export is disabled.
+	traceQueue chan TraceSpan
+
+	// logTarget is the current target for log messages, see Config.LogTarget. Atomic access.
+	logTarget int32
+
+	// adminNonces contains the nonces of recent admin requests to reject replays.
+	adminNonces *adminNonces
+
 	// Stdout bundles any output for the end-user. Writers may subscribe/unsubscribe.
 	Stdout *multiWriter
 }
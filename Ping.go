@@ -27,11 +27,14 @@ const connectionRemove = 2 * 60
 func (backend *Backend) autoPingAll() {
 	for {
 		time.Sleep(time.Second)
-		thresholdInvalidate1 := time.Now().Add(-connectionInvalidate * time.Second)
-		thresholdInvalidate2 := time.Now().Add(-connectionInvalidate * time.Second * 4)
-		thresholdPingOut1 := time.Now().Add(-pingTime * time.Second)
-		thresholdPingOut2 := time.Now().Add(-pingTime * time.Second * 4)
-		thresholdBlockchainRefresh := time.Now().Add(-thresholdBlockchainRefresh)
+
+		// If the uplink is congested, pings and announcements are sent less often and connections are invalidated later.
+		scale := backend.congestionScale
+		thresholdInvalidate1 := time.Now().Add(-scale(connectionInvalidate * time.Second))
+		thresholdInvalidate2 := time.Now().Add(-scale(connectionInvalidate * time.Second * 4))
+		thresholdPingOut1 := time.Now().Add(-scale(pingTime * time.Second))
+		thresholdPingOut2 := time.Now().Add(-scale(pingTime * time.Second * 4))
+		thresholdBlockchainRefresh := time.Now().Add(-scale(backend.networkSizeScale(thresholdBlockchainRefresh)))
 
 		for _, peer := range backend.PeerlistGet() {
 			// first handle active connections
@@ -60,6 +63,9 @@ func (backend *Backend) autoPingAll() {
 				}
 			}
 
+			// probe redundant connections
+			peer.probeConnections()
+
 			// handle inactive connections
 			for _, connection := range peer.GetConnections(false) {
 				// If the inactive connection is expired, remove it; although only if there is at least one active connection, or two other inactive ones.
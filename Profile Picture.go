@@ -0,0 +1,192 @@
+/*
+File Username:  Profile Picture.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Profile pictures are stored inline in the blockchain and are downloaded by every peer that shows the profile. Before writing, the picture is validated,
+downscaled to fit into ProfilePictureMaxDimension, and transcoded to a bounded JPEG (or PNG if it has transparency). Transcoding also strips any metadata.
+If the picture had to be downscaled, the original is stored in the warehouse and referenced by its hash via the ProfilePictureOriginal field.
+*/
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // register GIF decoder
+	"image/jpeg"
+	"image/png"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// Limits for profile pictures
+const (
+	ProfilePictureMaxInput     = 10 * 1024 * 1024 // Max size of the input image in bytes
+	ProfilePictureMaxPixels    = 25 * 1000 * 1000 // Max count of pixels of the input image. Protects against decompression bombs.
+	ProfilePictureMaxDimension = 256              // Max width and height of the stored picture in pixels
+	ProfilePictureMaxSize      = 32 * 1024        // Max size of the stored picture in bytes
+)
+
+// Errors for profile pictures
+var (
+	ErrPictureTooLarge = errors.New("picture exceeds the size limit")
+	ErrPictureFormat   = errors.New("unsupported picture format")
+)
+
+// jpegQualities are tried in order until the encoded picture fits into ProfilePictureMaxSize.
+var jpegQualities = []int{85, 75, 65, 50, 35}
+
+// ProfilePicturePrepare validates and transcodes the picture. It returns the bounded picture to store in the blockchain. If the picture was downscaled,
+// the original is stored in the warehouse and its hash is returned.
+func (backend *Backend) ProfilePicturePrepare(data []byte) (picture, originalHash []byte, err error) {
+	if len(data) > ProfilePictureMaxInput {
+		return nil, nil, ErrPictureTooLarge
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png" && format != "gif") {
+		return nil, nil, ErrPictureFormat
+	} else if config.Width <= 0 || config.Height <= 0 || uint64(config.Width)*uint64(config.Height) > ProfilePictureMaxPixels {
+		return nil, nil, ErrPictureTooLarge
+	}
+
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrPictureFormat
+	}
+
+	resized := resizePicture(source, ProfilePictureMaxDimension)
+
+	if picture, err = encodePicture(resized); err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the original if it was downscaled. If there is no warehouse, only the bounded picture is available.
+	if resized.Bounds().Dx() != config.Width || resized.Bounds().Dy() != config.Height {
+		if backend.UserWarehouse != nil {
+			hash, status, err := backend.UserWarehouse.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+			if status != warehouse.StatusOK {
+				return nil, nil, err
+			}
+			originalHash = hash
+		}
+	}
+
+	return picture, originalHash, nil
+}
+
+// ProfilePictureUpdates prepares the picture and returns the profile updates to write it. The reference to a previous original is deleted if there is no new one.
+func (backend *Backend) ProfilePictureUpdates(data []byte) (updates []blockchain.BlockRecordProfileUpdate, err error) {
+	picture, originalHash, err := backend.ProfilePicturePrepare(data)
+	if err != nil {
+		return nil, err
+	}
+
+	updates = append(updates, blockchain.BlockRecordProfileUpdate{Action: blockchain.ProfileUpdateSet, Type: blockchain.ProfilePicture, Data: picture})
+
+	if originalHash != nil {
+		updates = append(updates, blockchain.BlockRecordProfileUpdate{Action: blockchain.ProfileUpdateSet, Type: blockchain.ProfilePictureOriginal, Data: originalHash})
+	} else {
+		updates = append(updates, blockchain.BlockRecordProfileUpdate{Action: blockchain.ProfileUpdateDelete, Type: blockchain.ProfilePictureOriginal})
+	}
+
+	return updates, nil
+}
+
+// resizePicture downscales the image to fit into maxDimension x maxDimension, keeping the aspect ratio. Each target pixel is the average of the source
+// pixels it covers. Smaller images keep their size. The result is always premultiplied RGBA.
+func resizePicture(source image.Image, maxDimension int) (target *image.RGBA) {
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Convert to RGBA first. The draw package has fast paths for the common source formats.
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(rgba, rgba.Bounds(), source, bounds.Min, draw.Src)
+
+	if width <= maxDimension && height <= maxDimension {
+		return rgba
+	}
+
+	targetWidth, targetHeight := maxDimension, maxDimension
+	if width > height {
+		targetHeight = height * maxDimension / width
+	} else {
+		targetWidth = width * maxDimension / height
+	}
+	if targetWidth < 1 {
+		targetWidth = 1
+	}
+	if targetHeight < 1 {
+		targetHeight = 1
+	}
+
+	target = image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+
+	for y := 0; y < targetHeight; y++ {
+		y0, y1 := y*height/targetHeight, (y+1)*height/targetHeight
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < targetWidth; x++ {
+			x0, x1 := x*width/targetWidth, (x+1)*width/targetWidth
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride+x0*4 : sy*rgba.Stride+x1*4]
+				for n := 0; n < len(row); n += 4 {
+					sum[0] += uint64(row[n])
+					sum[1] += uint64(row[n+1])
+					sum[2] += uint64(row[n+2])
+					sum[3] += uint64(row[n+3])
+				}
+			}
+
+			count := uint64((y1 - y0) * (x1 - x0))
+			offset := y*target.Stride + x*4
+			for n := 0; n < 4; n++ {
+				target.Pix[offset+n] = uint8(sum[n] / count)
+			}
+		}
+	}
+
+	return target
+}
+
+// encodePicture encodes the picture as PNG if it has transparency, otherwise as JPEG. If the PNG is too large, the picture is flattened onto white
+// and encoded as JPEG. The JPEG quality is lowered until the picture fits into ProfilePictureMaxSize.
+func encodePicture(picture *image.RGBA) (data []byte, err error) {
+	if !picture.Opaque() {
+		var buffer bytes.Buffer
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		if err = encoder.Encode(&buffer, picture); err != nil {
+			return nil, err
+		} else if buffer.Len() <= ProfilePictureMaxSize {
+			return buffer.Bytes(), nil
+		}
+
+		flattened := image.NewRGBA(picture.Bounds())
+		draw.Draw(flattened, flattened.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flattened, flattened.Bounds(), picture, picture.Bounds().Min, draw.Over)
+		picture = flattened
+	}
+
+	for _, quality := range jpegQualities {
+		var buffer bytes.Buffer
+		if err = jpeg.Encode(&buffer, picture, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		} else if buffer.Len() <= ProfilePictureMaxSize {
+			return buffer.Bytes(), nil
+		}
+	}
+
+	return nil, ErrPictureTooLarge
+}
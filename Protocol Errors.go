@@ -0,0 +1,169 @@
+/*
+File Username:  Protocol Errors.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Protocol errors are counted per peer:
+* Malformed: Messages that fail to decode.
+* Signature: Signatures that do not match the peer, such as forwarded Traverse messages and blocks of its blockchain.
+* Sequence: Replies (Response, Pong, admin responses) with an unknown or expired sequence number. Packets of closed transfers and streams are
+  expected after closing and are not counted.
+
+If the count of errors within protocolErrorWindow reaches the config setting ProtocolErrorThreshold, the peer is marked as corrupted, its connections
+are dropped, and it is temporarily banned for ProtocolErrorBan minutes. The ban is only kept in memory and not stored in the config.
+Since late replies after a timeout are sequence violations as well, the threshold should not be set too low.
+
+The counters are available via ProtocolErrorStats.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// Protocol error types
+const (
+	ProtocolErrorMalformed = iota // Message failed to decode.
+	ProtocolErrorSignature        // Signature does not match the peer.
+	ProtocolErrorSequence         // Reply with invalid sequence number.
+)
+
+const protocolErrorThresholdDefault = 100        // Default count of errors within protocolErrorWindow after which the peer is banned.
+const protocolErrorBanDefault = 60               // Default ban duration in minutes.
+const protocolErrorWindow = 10 * time.Minute     // Time window for counting errors.
+const protocolErrorCleanup = 10 * time.Minute    // Interval to remove peers without recent errors.
+const protocolErrorBanReason = "protocol errors" // Reason of the temporary ban.
+
+// ProtocolErrorStats contains the protocol error counters of a peer.
+type ProtocolErrorStats struct {
+	PublicKey   *btcec.PublicKey // Public key of the peer.
+	Malformed   uint64           // Count of malformed messages.
+	Signature   uint64           // Count of signature failures.
+	Sequence    uint64           // Count of sequence violations.
+	Corrupted   bool             // Whether the peer exceeded the threshold and was banned.
+	CorruptedAt time.Time        // When the peer was marked as corrupted.
+	LastError   time.Time        // Last time an error was recorded.
+}
+
+type protocolErrors struct {
+	peers map[[btcec.PubKeyBytesLenCompressed]byte]*protocolErrorState
+	sync.Mutex
+}
+
+type protocolErrorState struct {
+	stats       ProtocolErrorStats
+	windowStart time.Time // Start of the current protocolErrorWindow.
+	errors      int       // Errors within the current window.
+}
+
+func (backend *Backend) initProtocolErrors() {
+	backend.protocolErrors = &protocolErrors{
+		peers: make(map[[btcec.PubKeyBytesLenCompressed]byte]*protocolErrorState),
+	}
+
+	backend.scheduleTask("protocol-errors-cleanup", protocolErrorCleanup, protocolErrorCleanup, func() error {
+		_, ban := backend.protocolErrorLimits()
+		backend.protocolErrors.cleanup(ban)
+		return nil
+	})
+}
+
+// protocolErrorLimits returns the threshold and the ban duration. A negative threshold disables banning.
+func (backend *Backend) protocolErrorLimits() (threshold int, ban time.Duration) {
+	threshold = backend.Config.ProtocolErrorThreshold
+	if threshold == 0 {
+		threshold = protocolErrorThresholdDefault
+	}
+
+	banMinutes := backend.Config.ProtocolErrorBan
+	if banMinutes <= 0 {
+		banMinutes = protocolErrorBanDefault
+	}
+
+	return threshold, time.Duration(banMinutes) * time.Minute
+}
+
+// protocolError records a protocol error of the peer. See ProtocolErrorX. If the threshold is reached, the peer is banned and true is returned.
+func (peer *PeerInfo) protocolError(errorType int) (banned bool) {
+	backend := peer.Backend
+	threshold, ban := backend.protocolErrorLimits()
+
+	guard := backend.protocolErrors
+	guard.Lock()
+
+	key := publicKey2Compressed(peer.PublicKey)
+	state := guard.peers[key]
+	if state == nil {
+		state = &protocolErrorState{stats: ProtocolErrorStats{PublicKey: peer.PublicKey}}
+		guard.peers[key] = state
+	}
+
+	now := time.Now()
+	state.stats.LastError = now
+
+	switch errorType {
+	case ProtocolErrorMalformed:
+		state.stats.Malformed++
+	case ProtocolErrorSignature:
+		state.stats.Signature++
+	case ProtocolErrorSequence:
+		state.stats.Sequence++
+	}
+
+	if now.Sub(state.windowStart) >= protocolErrorWindow {
+		state.windowStart = now
+		state.errors = 0
+	}
+
+	state.errors++
+	banned = threshold > 0 && state.errors >= threshold
+	if banned {
+		state.errors = 0
+		state.stats.Corrupted = true
+		state.stats.CorruptedAt = now
+	}
+
+	guard.Unlock()
+
+	if banned {
+		backend.LogError("protocolError", "peer %s banned for %s after %d protocol errors\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()), ban.String(), threshold)
+
+		for _, connection := range peer.GetConnections(true) {
+			peer.invalidateActiveConnection(connection)
+		}
+
+		backend.banTemporary(peer.PublicKey, ban, protocolErrorBanReason)
+	}
+
+	return banned
+}
+
+// cleanup removes peers without recent errors. Corrupted peers are kept as long as they are banned.
+func (guard *protocolErrors) cleanup(ban time.Duration) {
+	guard.Lock()
+	defer guard.Unlock()
+
+	for key, state := range guard.peers {
+		if time.Since(state.stats.LastError) >= protocolErrorWindow && (!state.stats.Corrupted || time.Since(state.stats.CorruptedAt) >= ban) {
+			delete(guard.peers, key)
+		}
+	}
+}
+
+// ProtocolErrorStats returns the protocol error counters of peers with recent errors and of peers marked as corrupted.
+func (backend *Backend) ProtocolErrorStats() (stats []ProtocolErrorStats) {
+	guard := backend.protocolErrors
+	guard.Lock()
+	defer guard.Unlock()
+
+	for _, state := range guard.peers {
+		stats = append(stats, state.stats)
+	}
+
+	return stats
+}
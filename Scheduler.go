@@ -0,0 +1,160 @@
+/*
+File Username:  Scheduler.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The scheduler runs regular background tasks such as expiring state, refreshing buckets, and renewing port forwardings.
+Each task runs in its own Go routine. Runs of the same task never overlap; the interval starts after the previous run finished.
+Maintenance tasks (see scheduleMaintenanceTask) have their interval stretched while the uplink is congested.
+Adaptive tasks (see scheduleAdaptiveTask) are maintenance tasks that also have their interval scaled by the network size.
+The status of all tasks is available via Tasks() for diagnostics.
+*/
+
+package core
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TaskStatus is the status of a scheduled task.
+type TaskStatus struct {
+	Name         string        // Name of the task.
+	Interval     time.Duration // Interval between runs.
+	Running      bool          // Whether the task is currently running.
+	RunCount     uint64        // Count of completed runs.
+	LastRun      time.Time     // Start of the last completed run. Zero if not run yet.
+	LastDuration time.Duration // Duration of the last completed run.
+	LastError    string        // Error returned by the last run. Empty if successful.
+	NextRun      time.Time     // Scheduled start of the next run.
+}
+
+type scheduledTask struct {
+	TaskStatus
+	run         func() error  // Task function.
+	stop        chan struct{} // Closed when the task is removed.
+	maintenance bool          // Whether the interval is stretched while the uplink is congested.
+	adaptive    bool          // Whether the interval is scaled by the network size.
+}
+
+type scheduler struct {
+	tasks map[string]*scheduledTask // Tasks by name.
+	sync.Mutex
+}
+
+// errTaskStop is returned by a task function to remove the task from the scheduler.
+var errTaskStop = errors.New("task stopped")
+
+func (backend *Backend) initScheduler() {
+	backend.scheduler = &scheduler{tasks: make(map[string]*scheduledTask)}
+}
+
+// scheduleTask runs the function regularly until the task is removed. The first run starts after the delay.
+// If a task with the same name exists, it is replaced. The function may return errTaskStop to remove the task.
+func (backend *Backend) scheduleTask(name string, delay, interval time.Duration, run func() error) {
+	backend.addTask(name, delay, interval, run, false, false)
+}
+
+// scheduleMaintenanceTask is the same as scheduleTask for tasks that send maintenance traffic. The interval is stretched while the uplink is congested.
+func (backend *Backend) scheduleMaintenanceTask(name string, delay, interval time.Duration, run func() error) {
+	backend.addTask(name, delay, interval, run, true, false)
+}
+
+// scheduleAdaptiveTask is the same as scheduleMaintenanceTask for tasks whose traffic shall scale with the network size. See networkSizeScale.
+func (backend *Backend) scheduleAdaptiveTask(name string, delay, interval time.Duration, run func() error) {
+	backend.addTask(name, delay, interval, run, true, true)
+}
+
+func (backend *Backend) addTask(name string, delay, interval time.Duration, run func() error, maintenance, adaptive bool) {
+	task := &scheduledTask{TaskStatus: TaskStatus{Name: name, Interval: interval, NextRun: time.Now().Add(delay)}, run: run, stop: make(chan struct{}), maintenance: maintenance, adaptive: adaptive}
+
+	backend.scheduler.Lock()
+	if existing := backend.scheduler.tasks[name]; existing != nil {
+		close(existing.stop)
+	}
+	backend.scheduler.tasks[name] = task
+	backend.scheduler.Unlock()
+
+	go backend.runTask(task, delay)
+}
+
+// unscheduleTask removes the task. A current run is not interrupted. It returns false if the task does not exist.
+func (backend *Backend) unscheduleTask(name string) (removed bool) {
+	backend.scheduler.Lock()
+	defer backend.scheduler.Unlock()
+
+	task := backend.scheduler.tasks[name]
+	if task == nil {
+		return false
+	}
+
+	close(task.stop)
+	delete(backend.scheduler.tasks, name)
+
+	return true
+}
+
+func (backend *Backend) runTask(task *scheduledTask, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-task.stop:
+			return
+		}
+
+		backend.scheduler.Lock()
+		task.Running = true
+		backend.scheduler.Unlock()
+
+		start := time.Now()
+		err := task.run()
+
+		backend.scheduler.Lock()
+		task.Running = false
+		task.RunCount++
+		task.LastRun = start
+		task.LastDuration = time.Since(start)
+		task.LastError = ""
+		if err != nil && err != errTaskStop {
+			task.LastError = err.Error()
+		}
+		interval := task.Interval
+		if task.adaptive {
+			interval = backend.networkSizeScale(interval)
+		}
+		if task.maintenance {
+			interval = backend.congestionScale(interval)
+		}
+		task.NextRun = time.Now().Add(interval)
+
+		if err == errTaskStop {
+			if backend.scheduler.tasks[task.Name] == task {
+				close(task.stop)
+				delete(backend.scheduler.tasks, task.Name)
+			}
+			backend.scheduler.Unlock()
+			return
+		}
+		backend.scheduler.Unlock()
+
+		timer.Reset(interval)
+	}
+}
+
+// Tasks returns the status of all scheduled tasks sorted by name.
+func (backend *Backend) Tasks() (tasks []TaskStatus) {
+	backend.scheduler.Lock()
+	for _, task := range backend.scheduler.tasks {
+		tasks = append(tasks, task.TaskStatus)
+	}
+	backend.scheduler.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+
+	return tasks
+}
@@ -0,0 +1,227 @@
+/*
+File Username:  Self Test.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The startup self-test runs during Init before any subsystem is initialized. It verifies the environment, so that misconfigurations and
+restricted platforms (such as unikernels without a writable filesystem or network adapters) are reported as a list of actionable failures
+instead of failing deep inside an init function:
+
+* Store: The folders of the blockchains and the search index are writable.
+* Warehouse: The warehouse folder can be created and is writable.
+* Key: The private key in the config is valid and matches the owner of the user's blockchain on disk.
+* Clock: The system time is plausible. Record dates and expiry depend on it.
+* Network: At least one UDP socket can be bound on a configured listen address or network adapter.
+
+Fatal failures abort Init with ExitSelfTestFailed and a SelfTestError. Other failures are logged and available via SelfTestResults.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// Checks of the self-test
+const (
+	SelfTestStore     = "store"
+	SelfTestWarehouse = "warehouse"
+	SelfTestKey       = "key"
+	SelfTestClock     = "clock"
+	SelfTestNetwork   = "network"
+)
+
+// The system time is considered invalid outside this range.
+var (
+	selfTestClockMin = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	selfTestClockMax = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// SelfTestFailure is a failed check of the startup self-test.
+type SelfTestFailure struct {
+	Check    string // Check that failed. See SelfTestX.
+	Location string // File, folder, or address concerned. Empty if none.
+	Err      error  // Underlying error.
+	Fatal    bool   // Whether the backend cannot start.
+	Action   string // Suggested action to resolve it.
+}
+
+func (failure SelfTestFailure) String() string {
+	text := failure.Check
+	if failure.Location != "" {
+		text += " '" + failure.Location + "'"
+	}
+	return text + ": " + failure.Err.Error() + ". " + failure.Action
+}
+
+// SelfTestError is returned by Init if the self-test found fatal failures. It lists all failures, including the non-fatal ones.
+type SelfTestError struct {
+	Failures []SelfTestFailure
+}
+
+func (err *SelfTestError) Error() string {
+	var lines []string
+	for _, failure := range err.Failures {
+		lines = append(lines, failure.String())
+	}
+	return "self-test failed:\n" + strings.Join(lines, "\n")
+}
+
+// selfTest runs all checks and returns the failures.
+func (backend *Backend) selfTest() (failures []SelfTestFailure) {
+	layout := backend.DataLayout
+
+	if !backend.Config.Observer {
+		if err := selfTestWritable(layout.BlockchainMain); err != nil {
+			failures = append(failures, SelfTestFailure{Check: SelfTestStore, Location: layout.BlockchainMain, Err: err, Fatal: true, Action: "Check the permissions or change the config setting BlockchainMain."})
+		}
+		if err := selfTestWritableFolder(layout.WarehouseMain); err != nil {
+			failures = append(failures, SelfTestFailure{Check: SelfTestWarehouse, Location: layout.WarehouseMain, Err: err, Fatal: true, Action: "Check the permissions or change the config setting WarehouseMain."})
+		}
+	}
+	if err := selfTestWritable(layout.BlockchainGlobal); err != nil {
+		failures = append(failures, SelfTestFailure{Check: SelfTestStore, Location: layout.BlockchainGlobal, Err: err, Action: "The blockchain cache is disabled. Check the permissions or change the config setting BlockchainGlobal."})
+	}
+	if err := selfTestWritable(layout.SearchIndex); err != nil {
+		failures = append(failures, SelfTestFailure{Check: SelfTestStore, Location: layout.SearchIndex, Err: err, Action: "Search is disabled. Check the permissions or change the config setting SearchIndex."})
+	}
+	if err := selfTestWritable(layout.TransferHistory); err != nil {
+		failures = append(failures, SelfTestFailure{Check: SelfTestStore, Location: layout.TransferHistory, Err: err, Action: "The transfer history is disabled. Check the permissions or change the config setting TransferHistory."})
+	}
+
+	failures = append(failures, backend.selfTestKey()...)
+
+	if now := time.Now(); now.Before(selfTestClockMin) || now.After(selfTestClockMax) {
+		failures = append(failures, SelfTestFailure{Check: SelfTestClock, Err: fmt.Errorf("system time %s is invalid", now.Format(time.RFC3339)), Action: "Set the system time. Dates of shared files and expiry of records will be wrong."})
+	}
+
+	if location, err := backend.selfTestNetwork(); err != nil {
+		failures = append(failures, SelfTestFailure{Check: SelfTestNetwork, Location: location, Err: err, Fatal: true, Action: "Make sure a network adapter is available or change the config setting Listen."})
+	}
+
+	return failures
+}
+
+// selfTestKey verifies the private key in the config and that it matches the owner of the user's blockchain.
+func (backend *Backend) selfTestKey() (failures []SelfTestFailure) {
+	if backend.Config.Observer || backend.Config.PrivateKey == "" {
+		return nil // Observers use an ephemeral key. Otherwise a new key is created.
+	}
+
+	privateKeyB, err := hex.DecodeString(backend.Config.PrivateKey)
+	if err == nil && len(privateKeyB) != btcec.PrivKeyBytesLen {
+		err = fmt.Errorf("invalid length %d", len(privateKeyB))
+	}
+	if err != nil {
+		return []SelfTestFailure{{Check: SelfTestKey, Location: backend.ConfigFilename, Err: fmt.Errorf("private key is corrupt: %w", err), Fatal: true, Action: "Restore the config setting PrivateKey from a backup or the recovery phrase."}}
+	}
+
+	_, publicKey := btcec.PrivKeyFromBytes(btcec.S256(), privateKeyB)
+
+	owner, found, err := blockchain.ReadOwner(backend.Config.StoreBackend, backend.DataLayout.BlockchainMain)
+	if err != nil {
+		return []SelfTestFailure{{Check: SelfTestKey, Location: backend.DataLayout.BlockchainMain, Err: fmt.Errorf("reading blockchain header: %w", err), Fatal: true, Action: "The blockchain is corrupt or in use by another process."}}
+	} else if found && !owner.IsEqual(publicKey) {
+		return []SelfTestFailure{{Check: SelfTestKey, Location: backend.DataLayout.BlockchainMain, Err: errors.New("blockchain belongs to a different private key"), Fatal: true, Action: "Restore the matching config setting PrivateKey or change the config setting BlockchainMain."}}
+	}
+
+	return nil
+}
+
+// selfTestNetwork checks if at least one UDP socket can be bound. It returns the last address that failed.
+func (backend *Backend) selfTestNetwork() (location string, err error) {
+	var addresses []string
+
+	if len(backend.Config.Listen) > 0 {
+		for _, listen := range backend.Config.Listen {
+			host, _, errSplit := net.SplitHostPort(listen)
+			if errSplit != nil {
+				host = listen // port is optional
+			}
+			addresses = append(addresses, host)
+		}
+	} else {
+		interfaceList, err := net.Interfaces()
+		if err != nil {
+			return "", fmt.Errorf("enumerating network adapters: %w", err)
+		}
+
+		for _, iface := range interfaceList {
+			if iface.Flags&net.FlagUp == 0 {
+				continue
+			}
+			ifaceAddresses, _ := iface.Addrs()
+			for _, address := range ifaceAddresses {
+				if ipNet, ok := address.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+					addresses = append(addresses, ipNet.IP.String())
+				}
+			}
+		}
+
+		if len(addresses) == 0 {
+			return "", errors.New("no network adapter with an IP address found")
+		}
+	}
+
+	// Port 0 is used, since binding the configured port may conflict with the listen workers started later.
+	for _, host := range addresses {
+		location = net.JoinHostPort(host, "0")
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket("udp", location); err == nil {
+			conn.Close()
+			return "", nil
+		}
+	}
+
+	return location, fmt.Errorf("no UDP socket could be bound: %w", err)
+}
+
+// selfTestWritable checks if a store can be created at the location. If it exists, it must be writable, otherwise the parent folder.
+func selfTestWritable(location string) (err error) {
+	if location == "" {
+		return nil
+	}
+
+	if info, err := os.Stat(location); err == nil && info.IsDir() {
+		return selfTestWritableFolder(location)
+	}
+
+	return selfTestWritableFolder(filepath.Dir(location))
+}
+
+// selfTestWritableFolder checks if the folder can be created and a file can be written into it.
+func selfTestWritableFolder(folder string) (err error) {
+	if folder == "" {
+		return nil
+	}
+
+	if err = os.MkdirAll(folder, os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(folder, ".peernet-self-test-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte{0})
+	file.Close()
+	os.Remove(file.Name())
+
+	return err
+}
+
+// SelfTestResults returns the non-fatal failures of the startup self-test.
+func (backend *Backend) SelfTestResults() (failures []SelfTestFailure) {
+	return backend.selfTestFailures
+}
@@ -0,0 +1,209 @@
+/*
+File Username:  Session Ticket.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Session tickets allow a peer that reconnects within minutes (for example after a transient network drop or a NAT port rebinding)
+to skip the full Announcement/Response exchange. Whenever an Announcement or Response is received, a ticket is issued (or refreshed)
+containing the details learned from the remote peer. If a new connection from the same peer and IP appears before the ticket expires,
+the details are restored immediately instead of requesting them again.
+
+Tickets are local only and never sent over the wire. They are bound to the peer ID (which is authenticated by the packet signature) and the remote IP.
+*/
+
+package core
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// sessionTicketExpiry is the time a session ticket remains valid after it was last refreshed.
+const sessionTicketExpiry = 5 * time.Minute
+
+// sessionTicket contains the information of a peer needed to resume a session.
+type sessionTicket struct {
+	expires           time.Time                        // Expiry of the ticket.
+	UserAgent         string                           // User Agent reported by remote peer.
+	Features          uint8                            // Feature bit array.
+	BlockchainHeight  uint64                           // Blockchain height
+	BlockchainVersion uint64                           // Blockchain version
+	messageSequence   uint32                           // Last used outgoing sequence number. Only used if the PeerInfo structure is recreated.
+	addresses         map[string]*sessionTicketAddress // Known connection details per remote IP.
+}
+
+// sessionTicketAddress contains connection details reported by the remote peer for a specific IP.
+type sessionTicketAddress struct {
+	PortInternal uint16 // Internal listening port reported by remote peer.
+	PortExternal uint16 // External listening port reported by remote peer.
+	Firewall     bool   // Whether the remote peer indicates a potential firewall.
+}
+
+// sessionTickets keeps track of all session tickets.
+type sessionTickets struct {
+	tickets map[[btcec.PubKeyBytesLenCompressed]byte]*sessionTicket
+	sync.Mutex
+}
+
+func (backend *Backend) initSessionTickets() {
+	backend.sessionTickets = &sessionTickets{tickets: make(map[[btcec.PubKeyBytesLenCompressed]byte]*sessionTicket)}
+}
+
+// expireSessionTickets deletes expired session tickets. It is run by the scheduler.
+func (backend *Backend) expireSessionTickets() (err error) {
+	now := time.Now()
+
+	backend.sessionTickets.Lock()
+	for key, ticket := range backend.sessionTickets.tickets {
+		if ticket.expires.Before(now) {
+			delete(backend.sessionTickets.tickets, key)
+		}
+	}
+	backend.sessionTickets.Unlock()
+
+	return nil
+}
+
+// sessionTicketIssue issues or refreshes the session ticket for the peer. It must be called after an Announcement or Response was processed on the connection.
+func (peer *PeerInfo) sessionTicketIssue(connection *Connection) {
+	if connection == nil || connection.PortInternal == 0 {
+		return
+	}
+
+	key := publicKey2Compressed(peer.PublicKey)
+	tickets := peer.Backend.sessionTickets
+
+	tickets.Lock()
+	defer tickets.Unlock()
+
+	ticket := tickets.tickets[key]
+	if ticket == nil {
+		ticket = &sessionTicket{addresses: make(map[string]*sessionTicketAddress)}
+		tickets.tickets[key] = ticket
+	}
+
+	ticket.expires = time.Now().Add(sessionTicketExpiry)
+	ticket.UserAgent = peer.UserAgent
+	ticket.Features = peer.Features
+	ticket.BlockchainHeight = peer.BlockchainHeight
+	ticket.BlockchainVersion = peer.BlockchainVersion
+	ticket.messageSequence = peer.messageSequence
+	ticket.addresses[connection.Address.IP.String()] = &sessionTicketAddress{PortInternal: connection.PortInternal, PortExternal: connection.PortExternal, Firewall: connection.Firewall}
+}
+
+// sessionTicketValid returns the valid session ticket for the peer, if any. The session tickets must be locked.
+func (tickets *sessionTickets) sessionTicketValid(publicKey *btcec.PublicKey) (ticket *sessionTicket) {
+	key := publicKey2Compressed(publicKey)
+	if ticket = tickets.tickets[key]; ticket == nil {
+		return nil
+	} else if ticket.expires.Before(time.Now()) {
+		delete(tickets.tickets, key)
+		return nil
+	}
+
+	return ticket
+}
+
+// sessionTicketLookup returns a copy of the valid session ticket for the peer. The copy does not contain the addresses, see sessionTicketAddressLookup.
+func (backend *Backend) sessionTicketLookup(publicKey *btcec.PublicKey) (ticket sessionTicket, valid bool) {
+	backend.sessionTickets.Lock()
+	defer backend.sessionTickets.Unlock()
+
+	existing := backend.sessionTickets.sessionTicketValid(publicKey)
+	if existing == nil {
+		return ticket, false
+	}
+
+	ticket = *existing
+	ticket.addresses = nil
+
+	return ticket, true
+}
+
+// sessionTicketAddressLookup returns a copy of the connection details of the valid session ticket for the peer and the remote IP.
+func (backend *Backend) sessionTicketAddressLookup(publicKey *btcec.PublicKey, IP net.IP) (address sessionTicketAddress, valid bool) {
+	backend.sessionTickets.Lock()
+	defer backend.sessionTickets.Unlock()
+
+	ticket := backend.sessionTickets.sessionTicketValid(publicKey)
+	if ticket == nil {
+		return address, false
+	}
+
+	existing := ticket.addresses[IP.String()]
+	if existing == nil {
+		return address, false
+	}
+
+	return *existing, true
+}
+
+// sessionResumePeer restores the peer details from a valid session ticket. It is called when a peer is newly added to the peer list.
+func (peer *PeerInfo) sessionResumePeer() (resumed bool) {
+	ticket, valid := peer.Backend.sessionTicketLookup(peer.PublicKey)
+	if !valid {
+		return false
+	}
+
+	peer.UserAgent = ticket.UserAgent
+	peer.Features = ticket.Features
+	peer.BlockchainHeight = ticket.BlockchainHeight
+	peer.BlockchainVersion = ticket.BlockchainVersion
+	peer.messageSequence = ticket.messageSequence
+
+	return true
+}
+
+// sessionResumeConnection restores the connection details from a valid session ticket matching the remote IP.
+// It returns true if the connection was resumed, in which case no new Announcement/Response exchange is required.
+func (peer *PeerInfo) sessionResumeConnection(connection *Connection) (resumed bool) {
+	address, valid := peer.Backend.sessionTicketAddressLookup(peer.PublicKey, connection.Address.IP)
+	if !valid {
+		return false
+	}
+
+	connection.PortInternal = address.PortInternal
+	connection.PortExternal = address.PortExternal
+	connection.Firewall = address.Firewall
+
+	return true
+}
+
+// SessionTicketInfo is the public information about a session ticket.
+type SessionTicketInfo struct {
+	PublicKey *btcec.PublicKey // Peer ID
+	Expires   time.Time        // Expiry of the ticket
+	UserAgent string           // User Agent
+	Addresses []net.IP         // Remote IPs covered by the ticket
+}
+
+// SessionTickets returns all currently valid session tickets.
+func (backend *Backend) SessionTickets() (tickets []SessionTicketInfo) {
+	backend.sessionTickets.Lock()
+	defer backend.sessionTickets.Unlock()
+
+	now := time.Now()
+
+	for key, ticket := range backend.sessionTickets.tickets {
+		if ticket.expires.Before(now) {
+			continue
+		}
+
+		publicKey, err := btcec.ParsePubKey(key[:], btcec.S256())
+		if err != nil {
+			continue
+		}
+
+		info := SessionTicketInfo{PublicKey: publicKey, Expires: ticket.expires, UserAgent: ticket.UserAgent}
+		for address := range ticket.addresses {
+			info.Addresses = append(info.Addresses, net.ParseIP(address))
+		}
+
+		tickets = append(tickets, info)
+	}
+
+	return tickets
+}
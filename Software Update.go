@@ -0,0 +1,271 @@
+/*
+File Username:  Software Update.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Software update channel. The publisher configured via UpdatePublisher publishes release manifests (see blockchain.BlockRecordRelease) on its blockchain
+and shares the release files via its warehouse. Nodes regularly read the publisher's latest blocks and check for a newer release for their platform.
+Each block must be signed by the publisher; blocks signed by any other key are ignored. The release file is downloaded via the regular file transfer
+from the publisher into the warehouse and verified against the hash of the manifest. Installing the update is left to the client.
+
+The current version is taken from the User Agent, for example "1.2.0" for "Peernet Cmd/1.2.0".
+*/
+
+package core
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// updateMaxBlocks is the max count of the publisher's latest blocks read to find releases.
+const updateMaxBlocks = 64
+
+// updateMaxBlockSize is the max size of a block of the publisher's blockchain.
+const updateMaxBlockSize = 1024 * 1024
+
+// updateFindTimeout is the timeout to find the publisher via the DHT.
+const updateFindTimeout = 10 * time.Second
+
+// UpdateStatus is the status of the software update channel.
+type UpdateStatus struct {
+	Enabled        bool                           // Whether an update publisher is configured.
+	CurrentVersion string                         // Current version, taken from the User Agent.
+	Platform       string                         // Platform of this node as GOOS/GOARCH.
+	Checked        time.Time                      // Time of the last check. Zero if not checked yet.
+	Error          string                         // Error of the last check, if any.
+	Available      bool                           // Whether a newer release is available.
+	Release        *blockchain.BlockRecordRelease // Latest release for this platform. Nil if none was found.
+	Downloaded     bool                           // Whether the release file was downloaded into the warehouse and verified.
+}
+
+type softwareUpdate struct {
+	publisher *btcec.PublicKey
+	status    UpdateStatus
+	sync.Mutex
+}
+
+func (backend *Backend) initSoftwareUpdate() {
+	backend.softwareUpdate = &softwareUpdate{status: UpdateStatus{CurrentVersion: userAgentVersion(backend.userAgent), Platform: runtime.GOOS + "/" + runtime.GOARCH}}
+
+	if backend.Config.UpdatePublisher == "" {
+		return
+	}
+
+	publisher, err := PublicKeyFromPeerID(backend.Config.UpdatePublisher)
+	if err != nil {
+		backend.LogError("initSoftwareUpdate", "invalid update publisher '%s': %v\n", backend.Config.UpdatePublisher, err)
+		return
+	}
+
+	backend.softwareUpdate.publisher = publisher
+	backend.softwareUpdate.status.Enabled = true
+}
+
+// scheduleSoftwareUpdate checks for updates regularly.
+func (backend *Backend) scheduleSoftwareUpdate() {
+	if backend.softwareUpdate.publisher == nil || backend.Config.UpdateCheckInterval <= 0 {
+		return
+	}
+
+	interval := time.Duration(backend.Config.UpdateCheckInterval) * time.Hour
+
+	backend.scheduleTask("software-update", 5*time.Minute, interval, func() error {
+		status, err := backend.UpdateCheck()
+		if err == nil && status.Available {
+			backend.LogError("scheduleSoftwareUpdate", "update available: version %s (current %s)\n", status.Release.Version, status.CurrentVersion)
+		}
+		return err
+	})
+}
+
+// UpdateStatus returns the status of the last update check.
+func (backend *Backend) UpdateStatus() (status UpdateStatus) {
+	backend.softwareUpdate.Lock()
+	defer backend.softwareUpdate.Unlock()
+
+	return backend.softwareUpdate.status
+}
+
+// UpdateCheck reads the releases of the publisher and checks if a newer release is available for this platform.
+func (backend *Backend) UpdateCheck() (status UpdateStatus, err error) {
+	publisher := backend.softwareUpdate.publisher
+	if publisher == nil {
+		return backend.UpdateStatus(), errors.New("no update publisher configured")
+	}
+
+	releases, err := backend.updateReleases(publisher)
+
+	backend.softwareUpdate.Lock()
+	defer backend.softwareUpdate.Unlock()
+
+	status = backend.softwareUpdate.status
+	status.Checked = time.Now()
+	status.Error = ""
+
+	if err != nil {
+		status.Error = err.Error()
+		backend.softwareUpdate.status = status
+		return status, err
+	}
+
+	var latest *blockchain.BlockRecordRelease
+	for n := range releases {
+		if releases[n].Platform != "" && releases[n].Platform != status.Platform {
+			continue
+		} else if latest == nil || compareVersion(releases[n].Version, latest.Version) >= 0 {
+			latest = &releases[n]
+		}
+	}
+
+	status.Release = latest
+	status.Available = latest != nil && compareVersion(latest.Version, status.CurrentVersion) > 0
+	status.Downloaded = latest != nil && backend.UserWarehouse != nil && backend.warehouseHasFile(latest.Hash)
+
+	backend.softwareUpdate.status = status
+	return status, nil
+}
+
+// UpdateDownload downloads the release file of the latest release found by UpdateCheck from the publisher into the warehouse.
+// The file is verified against the hash of the manifest. If target is not empty, the file is also written to the target file.
+func (backend *Backend) UpdateDownload(target string) (status UpdateStatus, err error) {
+	status = backend.UpdateStatus()
+	if status.Release == nil {
+		return status, errors.New("no release available")
+	} else if backend.UserWarehouse == nil {
+		return status, errors.New("no warehouse")
+	}
+
+	if !backend.warehouseHasFile(status.Release.Hash) {
+		_, peer, err := backend.FindNode(protocol.PublicKey2NodeID(backend.softwareUpdate.publisher), updateFindTimeout)
+		if err != nil {
+			return status, err
+		} else if peer == nil {
+			return status, errors.New("publisher not found")
+		}
+
+		if err = backend.warehouseDownload(peer, status.Release.Hash, status.Release.Size); err != nil {
+			return status, err
+		}
+	}
+
+	backend.softwareUpdate.Lock()
+	if backend.softwareUpdate.status.Release == status.Release {
+		backend.softwareUpdate.status.Downloaded = true
+	}
+	status = backend.softwareUpdate.status
+	backend.softwareUpdate.Unlock()
+
+	if target != "" {
+		if result, _, err := backend.UserWarehouse.ReadFileToDisk(status.Release.Hash, 0, 0, target); result != warehouse.StatusOK {
+			return status, err
+		}
+	}
+
+	return status, nil
+}
+
+// updateReleases returns the releases published in the latest blocks of the publisher's blockchain. Blocks not signed by the publisher are ignored.
+func (backend *Backend) updateReleases(publisher *btcec.PublicKey) (releases []blockchain.BlockRecordRelease, err error) {
+	if publisher.IsEqual(backend.PeerPublicKey) {
+		releases, status := backend.UserBlockchain.ReleaseList()
+		if status != blockchain.StatusOK {
+			return nil, errors.New("reading blockchain failed")
+		}
+		return releases, nil
+	}
+
+	_, peer, err := backend.FindNode(protocol.PublicKey2NodeID(publisher), updateFindTimeout)
+	if err != nil {
+		return nil, err
+	} else if peer == nil {
+		return nil, errors.New("publisher not found")
+	} else if peer.BlockchainHeight == 0 {
+		return nil, nil
+	}
+
+	offset := uint64(0)
+	if peer.BlockchainHeight > updateMaxBlocks {
+		offset = peer.BlockchainHeight - updateMaxBlocks
+	}
+
+	var mutex sync.Mutex
+
+	err = peer.BlockDownload(publisher, updateMaxBlocks, updateMaxBlockSize, []protocol.BlockRange{{Offset: offset, Limit: peer.BlockchainHeight - offset}}, func(data []byte, targetBlock protocol.BlockRange, blockSize uint64, availability uint8) {
+		if availability != protocol.GetBlockStatusAvailable {
+			return
+		}
+
+		decoded, status, err := blockchain.DecodeBlockRaw(data)
+		if err != nil || status != blockchain.StatusOK || !decoded.Block.OwnerPublicKey.IsEqual(publisher) || decoded.Block.Number != targetBlock.Offset {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		for _, record := range decoded.RecordsDecoded {
+			if release, ok := record.(blockchain.BlockRecordRelease); ok {
+				releases = append(releases, release)
+			}
+		}
+	})
+
+	return releases, err
+}
+
+// userAgentVersion returns the version of the User Agent in the form "Application Name/1.0".
+func userAgentVersion(userAgent string) (version string) {
+	if index := strings.LastIndex(userAgent, "/"); index >= 0 {
+		version = userAgent[index+1:]
+	}
+	if index := strings.IndexAny(version, " ("); index >= 0 {
+		version = version[:index]
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(version), "v")
+}
+
+// compareVersion compares dot-separated version numbers. It returns -1 if a < b, 0 if equal, and 1 if a > b. Missing parts count as 0.
+// Only the leading digits of each part are compared, so "1.2.0-beta" equals "1.2.0".
+func compareVersion(a, b string) int {
+	partsA := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	partsB := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for n := 0; n < len(partsA) || n < len(partsB); n++ {
+		var numberA, numberB int
+		if n < len(partsA) {
+			numberA = leadingNumber(partsA[n])
+		}
+		if n < len(partsB) {
+			numberB = leadingNumber(partsB[n])
+		}
+
+		if numberA < numberB {
+			return -1
+		} else if numberA > numberB {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func leadingNumber(text string) int {
+	end := 0
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+
+	number, _ := strconv.Atoi(text[:end])
+	return number
+}
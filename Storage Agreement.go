@@ -0,0 +1,261 @@
+/*
+File Username:  Storage Agreement.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Storage agreements list remote peers that agreed to store files of this peer, for example as replicas.
+Each peer is regularly challenged to prove that it still stores the files: the challenger picks a random file, byte range, and nonce, and the storage provider
+must answer with the hash of the nonce followed by the byte range. The challenger verifies the proof using its own copy of the file in the warehouse.
+The results are recorded per peer and are available for reputation decisions.
+*/
+
+package core
+
+import (
+	"bytes"
+	cryptoRand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// StorageAgreementConfig is a remote peer that agreed to store files of this peer.
+type StorageAgreementConfig struct {
+	Peer  string   `yaml:"Peer"`  // Peer ID (hex encoded) of the storage provider.
+	Files []string `yaml:"Files"` // Hashes (hex encoded) of the files the peer stores.
+}
+
+// storageProofStreamService is the name of the stream service for proof-of-storage challenges.
+const storageProofStreamService = "storage-proof/1"
+
+// storageChallengeInterval is the interval to challenge each storage provider.
+const storageChallengeInterval = time.Hour
+
+// storageChallengeRange is the default length of the challenged byte range.
+const storageChallengeRange = 4096
+
+// storageChallengeTimeout is the timeout for opening the stream and receiving the proof.
+const storageChallengeTimeout = 30 * time.Second
+
+// Results of a challenge
+const (
+	StorageChallengePassed      = 0 // The peer proved that it stores the file.
+	StorageChallengeFailed      = 1 // The peer does not store the file or returned an invalid proof.
+	StorageChallengeUnreachable = 2 // The peer could not be reached.
+)
+
+// ErrStorageFileNotLocal is returned if the file to challenge is not in the local warehouse. The challenger requires its own copy to verify the proof.
+var ErrStorageFileNotLocal = errors.New("file not in local warehouse")
+
+// StorageProofStats contains the challenge results of a storage provider.
+type StorageProofStats struct {
+	PeerID        string    // Peer ID, hex encoded.
+	Passed        uint64    // Count of passed challenges.
+	Failed        uint64    // Count of failed challenges.
+	Unreachable   uint64    // Count of challenges that could not be sent.
+	LastChallenge time.Time // Time of the last challenge.
+	LastResult    int       // Result of the last challenge, see StorageChallengeX.
+}
+
+// Reliability returns the ratio of passed challenges to all answered challenges, between 0 and 1. Peers that were never reached return 0.
+func (stats *StorageProofStats) Reliability() float64 {
+	if stats.Passed+stats.Failed == 0 {
+		return 0
+	}
+	return float64(stats.Passed) / float64(stats.Passed+stats.Failed)
+}
+
+type storageProofs struct {
+	peers map[string]*StorageProofStats // Results by peer ID
+	sync.RWMutex
+}
+
+func (backend *Backend) initStorageAgreements() {
+	backend.storageProofs = &storageProofs{peers: make(map[string]*StorageProofStats)}
+
+	// Any peer can act as storage provider and answer challenges for files in its warehouse.
+	backend.RegisterStreamService(storageProofStreamService, backend.storageProofStreamHandler)
+}
+
+// scheduleStorageChallenges challenges each storage provider regularly for a random file of its agreement.
+func (backend *Backend) scheduleStorageChallenges() {
+	if len(backend.Config.StorageAgreements) == 0 {
+		return
+	}
+
+	backend.scheduleTask("storage-challenge", 10*time.Minute, storageChallengeInterval, func() error {
+		for _, agreement := range backend.Config.StorageAgreements {
+			publicKey, err := PublicKeyFromPeerID(agreement.Peer)
+			if err != nil || len(agreement.Files) == 0 {
+				continue
+			}
+
+			hash, err := hex.DecodeString(agreement.Files[rand.Intn(len(agreement.Files))])
+			if err != nil || len(hash) != protocol.HashSize {
+				continue
+			}
+
+			peer := backend.PeerlistLookup(publicKey)
+			if peer == nil {
+				backend.storageProofs.record(publicKey.SerializeCompressed(), StorageChallengeUnreachable)
+				continue
+			}
+
+			if result, err := backend.StorageChallenge(peer, hash); result == StorageChallengeFailed {
+				backend.LogError("scheduleStorageChallenges", "peer %s failed challenge for file %s\n", agreement.Peer, hex.EncodeToString(hash))
+			} else if err != nil && err != ErrStorageFileNotLocal {
+				backend.LogError("scheduleStorageChallenges", "challenging peer %s: %v\n", agreement.Peer, err)
+			}
+		}
+		return nil
+	})
+}
+
+// StorageChallenge challenges the peer to prove that it stores the file. The file must be in the local warehouse to verify the proof.
+// The result is recorded in the peer's storage proof stats, unless ErrStorageFileNotLocal is returned.
+func (backend *Backend) StorageChallenge(peer *PeerInfo, hash []byte) (result int, err error) {
+	challenge, expected, err := backend.storageChallengeCreate(hash)
+	if err != nil {
+		return StorageChallengeFailed, err
+	}
+
+	result, err = storageChallengeSend(peer, challenge, expected)
+	backend.storageProofs.record(peer.PublicKey.SerializeCompressed(), result)
+
+	return result, err
+}
+
+// storageChallengeCreate creates a challenge with a new nonce for a random range of the file and returns the expected proof.
+func (backend *Backend) storageChallengeCreate(hash []byte) (challenge *protocol.StorageChallenge, expected []byte, err error) {
+	_, fileSize, status, _ := backend.UserWarehouse.FileExists(hash)
+	if status != warehouse.StatusOK {
+		return nil, nil, ErrStorageFileNotLocal
+	}
+
+	challenge = &protocol.StorageChallenge{Hash: hash, Nonce: make([]byte, 32)}
+	if _, err = cryptoRand.Read(challenge.Nonce); err != nil {
+		return nil, nil, err
+	}
+
+	challenge.Length = storageChallengeRange
+	if fileSize < storageChallengeRange {
+		challenge.Length = uint32(fileSize)
+	}
+	if fileSize > uint64(challenge.Length) {
+		challenge.Offset = uint64(rand.Int63n(int64(fileSize - uint64(challenge.Length) + 1)))
+	}
+
+	data := bytes.NewBuffer(make([]byte, 0, challenge.Length))
+	if status, _, err = backend.UserWarehouse.ReadFile(hash, int64(challenge.Offset), int64(challenge.Length), data); status != warehouse.StatusOK {
+		if err == nil {
+			err = errors.New("error reading file")
+		}
+		return nil, nil, err
+	}
+
+	return challenge, protocol.StorageProof(challenge.Nonce, data.Bytes()), nil
+}
+
+func storageChallengeSend(peer *PeerInfo, challenge *protocol.StorageChallenge, expected []byte) (result int, err error) {
+	conn, err := peer.OpenStream(storageProofStreamService, storageChallengeTimeout)
+	if err != nil {
+		return StorageChallengeUnreachable, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(storageChallengeTimeout))
+
+	if err = protocol.StorageChallengeWrite(conn, challenge); err != nil {
+		return StorageChallengeUnreachable, err
+	}
+
+	response, err := protocol.StorageProofRead(conn)
+	if err != nil {
+		return StorageChallengeUnreachable, err
+	}
+
+	return storageProofVerify(response, expected), nil
+}
+
+// storageProofVerify checks the response against the expected proof. Result is StorageChallengePassed or StorageChallengeFailed.
+func storageProofVerify(response *protocol.StorageProofResponse, expected []byte) (result int) {
+	if response.Status != protocol.StorageProofOK || !bytes.Equal(response.Proof, expected) {
+		return StorageChallengeFailed
+	}
+
+	return StorageChallengePassed
+}
+
+// storageProofStreamHandler answers a challenge for a file in the warehouse.
+func (backend *Backend) storageProofStreamHandler(peer *PeerInfo, conn *udt.UDTSocket) {
+	conn.SetDeadline(time.Now().Add(storageChallengeTimeout))
+
+	challenge, err := protocol.StorageChallengeRead(conn)
+	if err != nil {
+		return
+	}
+
+	protocol.StorageProofWrite(conn, backend.storageProofAnswer(challenge))
+}
+
+// storageProofAnswer creates the response to the challenge from the file in the warehouse.
+func (backend *Backend) storageProofAnswer(challenge *protocol.StorageChallenge) (response *protocol.StorageProofResponse) {
+	_, fileSize, status, _ := backend.UserWarehouse.FileExists(challenge.Hash)
+	if status != warehouse.StatusOK {
+		return &protocol.StorageProofResponse{Status: protocol.StorageProofNotFound}
+	} else if challenge.Length > protocol.StorageProofMaxLength || challenge.Offset+uint64(challenge.Length) > fileSize || (challenge.Length == 0 && fileSize > 0) {
+		return &protocol.StorageProofResponse{Status: protocol.StorageProofInvalidRange}
+	}
+
+	data := bytes.NewBuffer(make([]byte, 0, challenge.Length))
+	if status, _, _ = backend.UserWarehouse.ReadFile(challenge.Hash, int64(challenge.Offset), int64(challenge.Length), data); status != warehouse.StatusOK {
+		return &protocol.StorageProofResponse{Status: protocol.StorageProofNotFound}
+	}
+
+	return &protocol.StorageProofResponse{Status: protocol.StorageProofOK, Proof: protocol.StorageProof(challenge.Nonce, data.Bytes())}
+}
+
+func (proofs *storageProofs) record(publicKey []byte, result int) {
+	peerID := hex.EncodeToString(publicKey)
+
+	proofs.Lock()
+	defer proofs.Unlock()
+
+	stats := proofs.peers[peerID]
+	if stats == nil {
+		stats = &StorageProofStats{PeerID: peerID}
+		proofs.peers[peerID] = stats
+	}
+
+	switch result {
+	case StorageChallengePassed:
+		stats.Passed++
+	case StorageChallengeFailed:
+		stats.Failed++
+	case StorageChallengeUnreachable:
+		stats.Unreachable++
+	}
+	stats.LastChallenge = time.Now()
+	stats.LastResult = result
+}
+
+// StorageProofResults returns the challenge results of all challenged storage providers, sorted by peer ID.
+func (backend *Backend) StorageProofResults() (results []StorageProofStats) {
+	backend.storageProofs.RLock()
+	defer backend.storageProofs.RUnlock()
+
+	for _, stats := range backend.storageProofs.peers {
+		results = append(results, *stats)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].PeerID < results[j].PeerID })
+
+	return results
+}
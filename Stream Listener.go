@@ -0,0 +1,155 @@
+/*
+File Username:  Stream Listener.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Stream listeners provide a net.Listener compatible API for stream services, so that services can be implemented with standard Go server code
+(for example net/http or an RPC server) on top of UDT. Incoming streams wait in a backlog until accepted. If the backlog is full or the optional
+filter refuses the peer, the stream is refused before the UDT handshake and the remote peer receives ErrStreamServiceNotAvailable.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/PeernetOfficial/core/udt"
+)
+
+// streamListenerBacklog is the default count of incoming streams waiting to be accepted.
+const streamListenerBacklog = 16
+
+// StreamListener accepts incoming streams of a service.
+type StreamListener struct {
+	backend   *Backend
+	service   string
+	filter    StreamFilter
+	backlog   chan *StreamConn // Incoming streams waiting for Accept
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// StreamConn is an incoming stream accepted via a StreamListener. It is closed by the caller.
+type StreamConn struct {
+	*udt.UDTSocket
+	Peer      *PeerInfo // Remote peer that opened the stream
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*StreamListener)(nil)
+var _ net.Conn = (*StreamConn)(nil)
+
+// ListenStream registers the named service and returns a listener for incoming streams. Any existing handler for the same name is replaced.
+// Backlog is the max count of streams waiting to be accepted (0 = default). The filter is optional and may refuse streams based on the peer's identity.
+func (backend *Backend) ListenStream(service string, backlog int, filter StreamFilter) (listener *StreamListener, err error) {
+	if backlog <= 0 {
+		backlog = streamListenerBacklog
+	}
+
+	listener = &StreamListener{
+		backend: backend,
+		service: service,
+		filter:  filter,
+		backlog: make(chan *StreamConn, backlog),
+		closed:  make(chan struct{}),
+	}
+
+	if err = backend.registerStreamService(service, listener.handle, listener.admit); err != nil {
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// admit checks the filter and the backlog before the stream is established.
+func (listener *StreamListener) admit(peer *PeerInfo) (accept bool) {
+	if len(listener.backlog) >= cap(listener.backlog) || isClosed(listener.closed) {
+		return false
+	}
+
+	return listener.filter == nil || listener.filter(peer)
+}
+
+// handle queues the stream for Accept and blocks until it is closed, since the stream is closed when the handler returns.
+func (listener *StreamListener) handle(peer *PeerInfo, conn *udt.UDTSocket) {
+	streamConn := &StreamConn{UDTSocket: conn, Peer: peer, done: make(chan struct{})}
+
+	if isClosed(listener.closed) {
+		return
+	}
+
+	select {
+	case listener.backlog <- streamConn:
+	default:
+		return
+	}
+
+	// The listener might have been closed in the meantime.
+	if isClosed(listener.closed) {
+		listener.drain()
+	}
+
+	<-streamConn.done
+}
+
+// drain closes all streams waiting in the backlog.
+func (listener *StreamListener) drain() {
+	for {
+		select {
+		case streamConn := <-listener.backlog:
+			streamConn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next incoming stream. It returns net.ErrClosed after the listener is closed.
+func (listener *StreamListener) Accept() (conn net.Conn, err error) {
+	select {
+	case streamConn := <-listener.backlog:
+		return streamConn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// AcceptStream is the same as Accept but returns the stream with the identity of the remote peer.
+func (listener *StreamListener) AcceptStream() (conn *StreamConn, err error) {
+	select {
+	case conn = <-listener.backlog:
+		return conn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close unregisters the service and closes all streams that were not accepted yet. Accepted streams are not affected.
+func (listener *StreamListener) Close() error {
+	err := errors.New("listener closed")
+
+	listener.closeOnce.Do(func() {
+		err = nil
+		listener.backend.UnregisterStreamService(listener.service)
+		close(listener.closed)
+		listener.drain()
+	})
+
+	return err
+}
+
+// Addr returns the address of the local peer. The transfer ID is zero.
+func (listener *StreamListener) Addr() net.Addr {
+	return &VirtualAddr{PeerID: hex.EncodeToString(listener.backend.PeerPublicKey.SerializeCompressed())}
+}
+
+// Close closes the stream.
+func (conn *StreamConn) Close() (err error) {
+	err = conn.UDTSocket.Close()
+	conn.closeOnce.Do(func() { close(conn.done) })
+	return err
+}
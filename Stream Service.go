@@ -0,0 +1,250 @@
+/*
+File Username:  Stream Service.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Applications can register named stream services (for example "chat/1" or "sync/1") that remote peers connect to.
+The stream request is a regular signed Peernet message, so the handler can rely on the peer's identity to authorize the stream.
+The stream data itself is exchanged via UDT over lite packets like file transfers; lite packets are neither signed nor encrypted.
+*/
+
+package core
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+	"github.com/google/uuid"
+)
+
+// streamSequenceTimeout is the timeout for a follow-up message to appear, otherwise the stream will be terminated.
+var streamSequenceTimeout = time.Minute * 1
+
+// StreamHandler handles an incoming stream from a remote peer. The stream is closed when the handler returns.
+type StreamHandler func(peer *PeerInfo, conn *udt.UDTSocket)
+
+// StreamFilter decides whether an incoming stream from the peer is accepted. Refused streams are reported as service not available to the peer.
+type StreamFilter func(peer *PeerInfo) (accept bool)
+
+type streamServices struct {
+	handlers map[string]StreamHandler // Handlers by service name
+	filters  map[string]StreamFilter  // Optional filters by service name
+	sync.RWMutex
+}
+
+// StreamStats contains information about a stream.
+type StreamStats struct {
+	Service   string         // Name of the service
+	Direction int            // Direction: DirectionIn = incoming stream to a local service, DirectionOut = stream opened to a remote service
+	UDTConn   *udt.UDTSocket // Underlying UDT connection
+}
+
+// Errors when opening a stream
+var (
+	ErrStreamServiceNotAvailable = errors.New("service not available")
+	ErrStreamTimeout             = errors.New("timeout")
+)
+
+func (backend *Backend) initStreamServices() {
+	backend.streamServices = &streamServices{handlers: make(map[string]StreamHandler), filters: make(map[string]StreamFilter)}
+}
+
+// RegisterStreamService registers the handler for the named service. Any existing handler for the same name is replaced.
+// Service names should include a version, for example "chat/1", and must not exceed protocol.StreamServiceMaxLength bytes.
+func (backend *Backend) RegisterStreamService(service string, handler StreamHandler) (err error) {
+	return backend.registerStreamService(service, handler, nil)
+}
+
+// registerStreamService registers the handler and the optional filter for the named service.
+func (backend *Backend) registerStreamService(service string, handler StreamHandler, filter StreamFilter) (err error) {
+	if len(service) == 0 || len(service) > protocol.StreamServiceMaxLength {
+		return errors.New("invalid service name")
+	}
+
+	backend.streamServices.Lock()
+	backend.streamServices.handlers[service] = handler
+	if filter != nil {
+		backend.streamServices.filters[service] = filter
+	} else {
+		delete(backend.streamServices.filters, service)
+	}
+	backend.streamServices.Unlock()
+
+	return nil
+}
+
+// UnregisterStreamService removes the handler for the named service. Existing streams are not affected.
+func (backend *Backend) UnregisterStreamService(service string) {
+	backend.streamServices.Lock()
+	delete(backend.streamServices.handlers, service)
+	delete(backend.streamServices.filters, service)
+	backend.streamServices.Unlock()
+}
+
+// StreamServices returns the names of all registered services.
+func (backend *Backend) StreamServices() (services []string) {
+	backend.streamServices.RLock()
+	defer backend.streamServices.RUnlock()
+
+	for service := range backend.streamServices.handlers {
+		services = append(services, service)
+	}
+
+	return services
+}
+
+func (backend *Backend) streamHandler(service string) (handler StreamHandler, filter StreamFilter) {
+	backend.streamServices.RLock()
+	defer backend.streamServices.RUnlock()
+
+	return backend.streamServices.handlers[service], backend.streamServices.filters[service]
+}
+
+// OpenStream opens a stream to the named service of the remote peer. The caller must call conn.Close() when done.
+// It returns ErrStreamServiceNotAvailable if the remote peer does not provide the service, and ErrStreamTimeout if it does not respond in time.
+func (peer *PeerInfo) OpenStream(service string, timeout time.Duration) (conn *udt.UDTSocket, err error) {
+	if len(service) == 0 || len(service) > protocol.StreamServiceMaxLength {
+		return nil, errors.New("invalid service name")
+	}
+
+	virtualConn := newVirtualPacketConn(peer, func(data []byte, sequenceNumber uint32, transferID uuid.UUID) {
+		peer.sendStream(data, protocol.StreamControlActive, "", sequenceNumber, transferID, true)
+	})
+
+	// new lite ID
+	liteID := peer.Backend.networks.LiteRouter.NewLiteID(virtualConn, peer.liteReplay(), streamSequenceTimeout, virtualConn.sequenceTerminate)
+	virtualConn.transferID = liteID.ID
+	virtualConn.Stats = &StreamStats{Service: service, Direction: DirectionOut}
+
+	// new sequence
+	sequence := peer.Backend.networks.Sequences.NewSequenceBi(peer.PublicKey, &peer.messageSequence, virtualConn, streamSequenceTimeout, nil)
+	if sequence == nil {
+		return nil, errors.New("cannot acquire sequence")
+	}
+	virtualConn.sequenceNumber = sequence.SequenceNumber
+	virtualConn.traceBegin("stream", true)
+
+	udtConfig := udt.DefaultConfig()
+	udtConfig.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
+	udtConfig.MaxFlowWinSize = maxFlowWinSize
+
+	// start UDT receiver
+	udtListener := udt.ListenUDT(udtConfig, virtualConn, virtualConn.incomingData, virtualConn.outgoingData, virtualConn.terminationSignal)
+
+	// request the stream
+	if err = peer.sendStream(nil, protocol.StreamControlRequestStart, service, virtualConn.sequenceNumber, virtualConn.transferID, false); err != nil {
+		udtListener.Close()
+		return nil, err
+	}
+
+	// accept the connection
+	type acceptResult struct {
+		conn *udt.UDTSocket
+		err  error
+	}
+	resultChan := make(chan acceptResult, 1)
+
+	go func() {
+		conn, err := udtListener.Accept()
+		resultChan <- acceptResult{conn: conn, err: err}
+	}()
+
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			udtListener.Close()
+			return nil, result.err
+		}
+		virtualConn.Stats.(*StreamStats).UDTConn = result.conn
+
+		// We do not close the UDT listener here. It should automatically close after udtConn is closed.
+		return result.conn, nil
+
+	case <-virtualConn.terminationSignal:
+		err = ErrStreamTimeout
+		if virtualConn.GetTerminateReason() == 404 {
+			err = ErrStreamServiceNotAvailable
+		}
+
+	case <-time.After(timeout):
+		err = ErrStreamTimeout
+	}
+
+	// Close any connection that was accepted in the meantime.
+	udtListener.Close()
+	go func() {
+		if result := <-resultChan; result.conn != nil {
+			result.conn.Close()
+		}
+	}()
+
+	return nil, err
+}
+
+// startStreamService serves an incoming stream via the registered handler.
+// It creates a virtual UDT client to connect to the remote peer, which is the UDT server.
+func (peer *PeerInfo) startStreamService(service string, handler StreamHandler, sequenceNumber uint32, transferID uuid.UUID) (err error) {
+	virtualConn := newVirtualPacketConn(peer, func(data []byte, sequenceNumber uint32, transferID uuid.UUID) {
+		peer.sendStream(data, protocol.StreamControlActive, "", sequenceNumber, transferID, true)
+	})
+	virtualConn.Stats = &StreamStats{Service: service, Direction: DirectionIn}
+
+	// use the transfer ID indicated by the remote peer
+	virtualConn.transferID = transferID
+	peer.Backend.networks.LiteRouter.RegisterLiteID(transferID, virtualConn, peer.liteReplay(), streamSequenceTimeout, virtualConn.sequenceTerminate)
+
+	// register the sequence since packets are sent bi-directional
+	virtualConn.sequenceNumber = sequenceNumber
+	peer.Backend.networks.Sequences.RegisterSequenceBi(peer.PublicKey, sequenceNumber, virtualConn, streamSequenceTimeout, nil)
+	virtualConn.traceBegin("stream", false)
+
+	udtConfig := udt.DefaultConfig()
+	udtConfig.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
+	udtConfig.MaxFlowWinSize = maxFlowWinSize
+
+	// Set streaming to true, otherwise udtSocket.Read returns the error "Message truncated" in case the reader has a smaller buffer.
+	udtConn, err := udt.DialUDT(udtConfig, virtualConn, virtualConn.incomingData, virtualConn.outgoingData, virtualConn.terminationSignal, true)
+	if err != nil {
+		return err
+	}
+
+	defer udtConn.Close()
+	virtualConn.Stats.(*StreamStats).UDTConn = udtConn
+
+	handler(peer, udtConn)
+
+	return nil
+}
+
+// cmdStream handles an incoming stream message
+func (peer *PeerInfo) cmdStream(msg *protocol.MessageStream) {
+	switch msg.Control {
+	case protocol.StreamControlRequestStart:
+		handler, filter := peer.Backend.streamHandler(msg.Service)
+		if handler == nil || filter != nil && !filter(peer) {
+			peer.sendStream(nil, protocol.StreamControlNotAvailable, "", msg.Sequence, msg.TransferID, false)
+			return
+		}
+
+		go peer.startStreamService(msg.Service, handler, msg.Sequence, msg.TransferID)
+
+	case protocol.StreamControlActive:
+		if v, ok := msg.SequenceInfo.Data.(*VirtualPacketConn); ok {
+			go v.receiveData(msg.Data)
+		}
+
+	case protocol.StreamControlNotAvailable:
+		if v, ok := msg.SequenceInfo.Data.(*VirtualPacketConn); ok {
+			v.Terminate(404)
+		}
+
+	case protocol.StreamControlTerminate:
+		if v, ok := msg.SequenceInfo.Data.(*VirtualPacketConn); ok {
+			v.Terminate(2)
+		}
+
+	}
+}
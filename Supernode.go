@@ -0,0 +1,139 @@
+/*
+File Username:  Supernode.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Supernodes are opt-in high-capacity nodes (config setting Supernode) that offer extra caching and relay capacity. They advertise
+protocol.FeatureSupernode in announcements and:
+
+* Accept more INFO_STORE records: The limits are multiplied by supernodeInfoStoreFactor and records are accepted regardless of the distance
+  to the hash. Other peers replicate their INFO_STORE records to the supernodeReplicas closest supernodes in addition to the closest nodes,
+  and query them for storing peers.
+* Mirror blocks: Blocks of blockchains in the global blockchain cache are served to other peers. Peers prefer a connected supernode for
+  downloading blocks and fall back to the owner of the blockchain for any block that was not mirrored. Mirrored blocks are only accepted if
+  they are signed by the owner and match the expected version and block number.
+* Load shedding: Once saturated, the feature bit is no longer advertised, mirror requests are declined, and the extended INFO_STORE limits
+  no longer apply to new records. A supernode is saturated if it serves SupernodeMaxTransfers block mirror transfers concurrently or the
+  congestion factor (see Congestion) reaches supernodeMaxCongestion.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+const (
+	supernodeInfoStoreFactor = 10  // Factor by which the INFO_STORE limits are raised on supernodes.
+	supernodeReplicas        = 2   // Count of supernodes that INFO_STORE records are replicated to and queried for storing peers.
+	supernodeMaxCongestion   = 2.0 // Congestion factor at which a supernode is saturated.
+	supernodeMaxTransfersDef = 32  // Default max count of concurrent block mirror transfers.
+)
+
+// SupernodeStatus is the current status of the supernode role.
+type SupernodeStatus struct {
+	Enabled      bool // Whether the supernode role is enabled via the config setting Supernode.
+	Saturated    bool // Whether the supernode is saturated. If so, it sheds load and does not advertise the feature.
+	Transfers    int  // Count of active block mirror transfers.
+	MaxTransfers int  // Max count of concurrent block mirror transfers.
+}
+
+// supernodeMaxTransfers returns the max count of concurrent block mirror transfers.
+func (backend *Backend) supernodeMaxTransfers() int {
+	if backend.Config.SupernodeMaxTransfers > 0 {
+		return backend.Config.SupernodeMaxTransfers
+	}
+	return supernodeMaxTransfersDef
+}
+
+// SupernodeStatus returns the current status of the supernode role.
+func (backend *Backend) SupernodeStatus() (status SupernodeStatus) {
+	status.Enabled = backend.Config.Supernode
+	status.Transfers = int(atomic.LoadInt32(&backend.supernodeTransfers))
+	status.MaxTransfers = backend.supernodeMaxTransfers()
+	status.Saturated = status.Enabled && (status.Transfers >= status.MaxTransfers || backend.Congestion().Factor >= supernodeMaxCongestion)
+
+	return status
+}
+
+// supernodeAvailable checks if this node acts as supernode and is not saturated.
+func (backend *Backend) supernodeAvailable() bool {
+	if !backend.Config.Supernode {
+		return false
+	}
+
+	return !backend.SupernodeStatus().Saturated
+}
+
+// IsSupernode checks if the peer advertises the supernode feature.
+func (peer *PeerInfo) IsSupernode() bool {
+	return peer.Features&(1<<protocol.FeatureSupernode) > 0
+}
+
+// closestSupernodes returns up to supernodeReplicas supernodes from the routing table that are closest to the hash.
+func (backend *Backend) closestSupernodes(hash []byte) (peers []*PeerInfo) {
+	return backend.ClosestPeers(hash, supernodeReplicas, NodeFilterFeatures(1<<protocol.FeatureSupernode, 0))
+}
+
+// blockMirrors returns connected supernodes to download blocks of the blockchain from, sorted by RTT. The owner of the blockchain is excluded.
+func (backend *Backend) blockMirrors(owner *btcec.PublicKey) (mirrors []*PeerInfo) {
+	for _, peer := range backend.PeerlistGet() {
+		if peer.IsSupernode() && !peer.PublicKey.IsEqual(owner) && peer.GetRTT() > 0 {
+			mirrors = append(mirrors, peer)
+		}
+	}
+
+	sort.Slice(mirrors, func(i, j int) bool { return mirrors[i].GetRTT() < mirrors[j].GetRTT() })
+
+	if len(mirrors) > supernodeReplicas {
+		mirrors = mirrors[:supernodeReplicas]
+	}
+
+	return mirrors
+}
+
+// mirrorBlockchainHeader returns the header of the cached blockchain if it can be mirrored to other peers.
+func (backend *Backend) mirrorBlockchainHeader(publicKey *btcec.PublicKey) (header *blockchain.MultiBlockchainHeader) {
+	if !backend.supernodeAvailable() || backend.GlobalBlockchainCache == nil {
+		return nil
+	}
+
+	header, found, err := backend.GlobalBlockchainCache.Store.ReadBlockchainHeader(publicKey)
+	if !found || err != nil || header.Height == 0 {
+		return nil
+	}
+
+	return header
+}
+
+// validMirroredBlock checks if a block returned by a mirror is signed by the owner of the blockchain and matches the expected version and number.
+func validMirroredBlock(raw []byte, owner *btcec.PublicKey, version, number uint64) bool {
+	decoded, status, err := blockchain.DecodeBlockRaw(raw)
+	if err != nil || status != blockchain.StatusOK {
+		return false
+	}
+
+	return decoded.OwnerPublicKey.IsEqual(owner) && decoded.BlockchainVersion == version && decoded.Number == number
+}
+
+// missingBlockRanges returns the ranges of blocks between offset and offset+limit that were not received.
+func missingBlockRanges(offset, limit uint64, received map[uint64]struct{}) (ranges []protocol.BlockRange) {
+	for blockN := offset; blockN < offset+limit; blockN++ {
+		if _, ok := received[blockN]; ok {
+			continue
+		}
+
+		if n := len(ranges); n > 0 && ranges[n-1].Offset+ranges[n-1].Limit == blockN {
+			ranges[n-1].Limit++
+		} else {
+			ranges = append(ranges, protocol.BlockRange{Offset: blockN, Limit: 1})
+		}
+	}
+
+	return ranges
+}
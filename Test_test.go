@@ -0,0 +1,1032 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/dht"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/warehouse"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// testBackend initializes a backend with its own data folder. It is not connected to any network.
+func testBackend(t *testing.T) (backend *Backend) {
+	directory := t.TempDir()
+
+	config := &Config{}
+	if err := yaml.Unmarshal(ConfigDefault, config); err != nil {
+		t.Fatalf("Error parsing default config: %s", err.Error())
+	}
+
+	config.DataFolder = filepath.Join(directory, "data") + string(filepath.Separator)
+	config.GeoIPDatabase = ""
+	config.LogTarget = 3
+	config.Listen = []string{"127.0.0.1:0"}
+	config.EnableUPnP = false
+	config.AutoUpdateSeedList = false
+	config.SeedList = nil
+
+	filename := filepath.Join(directory, "config.yaml")
+	if err := SaveConfig(filename, config); err != nil {
+		t.Fatalf("Error saving config: %s", err.Error())
+	}
+
+	backend, status, err := Init("Peernet Test/1.0", filename, nil, nil)
+	if status != ExitSuccess || err != nil {
+		t.Fatalf("Error initializing backend (status %d): %v", status, err)
+	}
+
+	return backend
+}
+
+func TestRelayedResponseEmbeddedInvalid(t *testing.T) {
+	backend := testBackend(t)
+
+	remoteKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotePublicKey := remoteKey.PubKey()
+
+	data := []byte("embedded file")
+	builder := protocol.NewResponseBuilder(0, 0, 0)
+	builder.AddEmbeddedFile(protocol.HashData(data), []byte("tampered"))
+
+	payloads, err := builder.Finalize()
+	if err != nil || len(payloads) != 1 {
+		t.Fatalf("Finalize: %v, %d packets", err, len(payloads))
+	}
+
+	info := backend.nodesDHT.NewInformationRequest(dht.ActionFindValue, protocol.HashData(data), nil)
+	messageSequence := rand.Uint32()
+	sequence := backend.networks.Sequences.NewSequence(remotePublicKey, &messageSequence, info)
+
+	// A relayed response has no connection.
+	decoded := &protocol.PacketRaw{Command: protocol.CommandResponse, Sequence: sequence.SequenceNumber, Payload: payloads[0]}
+	backend.lookupResponse(decoded, remotePublicKey, nil)
+
+	for _, stats := range backend.EmbeddedFileStats() {
+		if stats.PublicKey.IsEqual(remotePublicKey) {
+			if stats.HashFailures != 1 {
+				t.Fatalf("Unexpected count of hash failures %d", stats.HashFailures)
+			}
+			return
+		}
+	}
+
+	t.Fatal("Hash failure of the responder not recorded")
+}
+
+func TestSessionTicketResume(t *testing.T) {
+	backend := testBackend(t)
+
+	remoteKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := &PeerInfo{Backend: backend, PublicKey: remoteKey.PubKey(), UserAgent: "Remote/1.0"}
+	address := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1234}
+	connection := &Connection{Address: address, PortInternal: 1234}
+
+	peer.sessionTicketIssue(connection)
+
+	// Issuing and resuming concurrently must not race (run with -race).
+	done := make(chan struct{})
+	go func() {
+		for n := 0; n < 100; n++ {
+			peer.sessionTicketIssue(connection)
+		}
+		close(done)
+	}()
+
+	for n := 0; n < 100; n++ {
+		resumed := &Connection{Address: address}
+		if !peer.sessionResumeConnection(resumed) || resumed.PortInternal != 1234 {
+			t.Fatal("Connection not resumed")
+		}
+		if ticket, valid := backend.sessionTicketLookup(peer.PublicKey); !valid || ticket.UserAgent != "Remote/1.0" {
+			t.Fatal("Session ticket not found")
+		}
+	}
+	<-done
+
+	if peer.sessionResumeConnection(&Connection{Address: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 1234}}) {
+		t.Fatal("Connection resumed from a different IP")
+	}
+}
+
+func TestWarehouseScrub(t *testing.T) {
+	backend := testBackend(t)
+
+	hashValid, _, _ := backend.UserWarehouse.CreateFile(bytes.NewReader([]byte("valid file")), 10, nil)
+	hashCorrupt, _, _ := backend.UserWarehouse.CreateFile(bytes.NewReader([]byte("corrupt file")), 12, nil)
+
+	path, _, _, _ := backend.UserWarehouse.FileExists(hashCorrupt)
+	if err := os.WriteFile(path, []byte("tampered file"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []byte
+	backend.Filters.WarehouseCorrupt = func(hash []byte, path string, refetched bool) {
+		reported = hash
+	}
+
+	if err := backend.WarehouseScrub(); err != nil {
+		t.Fatalf("WarehouseScrub: %v", err)
+	}
+
+	status := backend.WarehouseScrubStatusGet()
+	if status.FilesChecked != 2 || len(status.Corrupt) != 1 || !bytes.Equal(status.Corrupt[0], hashCorrupt) || !bytes.Equal(reported, hashCorrupt) {
+		t.Fatalf("Unexpected scrub result: %d files checked, corrupt %v", status.FilesChecked, status.Corrupt)
+	}
+
+	if _, _, status, _ := backend.UserWarehouse.FileExists(hashCorrupt); status != warehouse.StatusFileNotFound {
+		t.Fatal("Corrupt file still served")
+	}
+	if _, _, status, _ := backend.UserWarehouse.FileExists(hashValid); status != warehouse.StatusOK {
+		t.Fatal("Valid file removed")
+	}
+}
+
+// BIP39 test vectors with 256-bit entropy, without passphrase.
+var testMnemonicVectors = []struct {
+	entropy  string
+	mnemonic string
+}{
+	{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", "legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth title"},
+	{"8080808080808080808080808080808080808080808080808080808080808080", "letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic bless"},
+	{"9f6a2878b2520799a44ef18bc7df394e7061a224d2c33cd015b157d746869863", "panda eyebrow bullet gorilla call smoke muffin taste mesh discover soft ostrich alcohol speed nation flash devote level hobby quick inner drive ghost inside"},
+	{"15da872c95a13dd738fbf50e427583ad61f18fd99f628c417a61cf8343c90419", "beyond stage sleep clip because twist token leaf atom beauty genius food business side grid unable middle armed observe pair crouch tonight away coconut"},
+	{"f585c11aec520db57dd353c69554b21a89b20fb0650966fa0a9d6f74fd989d8f", "void come effort suffer camp survey warrior heavy shoot primary clutch crush open amazing screen patrol group space point ten exist slush involve unfold"},
+}
+
+func TestMnemonicVectors(t *testing.T) {
+	for _, vector := range testMnemonicVectors {
+		entropy, _ := hex.DecodeString(vector.entropy)
+		privateKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), entropy)
+
+		mnemonic, err := PrivateKeyToMnemonic(privateKey, "")
+		if err != nil || mnemonic != vector.mnemonic {
+			t.Fatalf("Mnemonic mismatch for %s: %v\n%s", vector.entropy, err, mnemonic)
+		}
+
+		privateKey2, _, err := MnemonicToPrivateKey(strings.ToUpper(vector.mnemonic), "")
+		if err != nil || !bytes.Equal(privateKey2.Serialize(), entropy) {
+			t.Fatalf("Private key mismatch for %s: %v", vector.entropy, err)
+		}
+	}
+
+	// The all-zero and all-one entropy are valid mnemonics but invalid private keys.
+	for _, mnemonic := range []string{
+		strings.Repeat("abandon ", 23) + "art",
+		strings.Repeat("zoo ", 23) + "vote",
+	} {
+		if _, _, err := MnemonicToPrivateKey(mnemonic, ""); err == nil || err.Error() != "invalid private key" {
+			t.Fatalf("Invalid private key not rejected: %v", err)
+		}
+	}
+}
+
+func TestMnemonicInvalid(t *testing.T) {
+	valid := testMnemonicVectors[0].mnemonic
+
+	for _, mnemonic := range []string{
+		strings.Replace(valid, "title", "zoo", 1),    // checksum
+		strings.Replace(valid, "legal", "letter", 1), // checksum
+		strings.Replace(valid, "title", "peernet", 1),
+		strings.TrimSuffix(valid, " title"),
+		valid + " title",
+		"",
+	} {
+		if _, _, err := MnemonicToPrivateKey(mnemonic, ""); err == nil {
+			t.Fatalf("Invalid mnemonic accepted: %s", mnemonic)
+		}
+	}
+}
+
+func TestMnemonicPassphrase(t *testing.T) {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mnemonic, err := PrivateKeyToMnemonic(privateKey, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if privateKey2, _, err := MnemonicToPrivateKey(mnemonic, "secret"); err != nil || privateKey2.D.Cmp(privateKey.D) != 0 {
+		t.Fatalf("Round-trip with passphrase failed: %v", err)
+	}
+
+	// A wrong passphrase results in a different key.
+	if privateKey2, _, err := MnemonicToPrivateKey(mnemonic, "wrong"); err == nil && privateKey2.D.Cmp(privateKey.D) == 0 {
+		t.Fatal("Wrong passphrase recovered the private key")
+	}
+}
+
+func TestImportMnemonic(t *testing.T) {
+	backend := testBackend(t)
+	privateKeyOld := backend.Config.PrivateKey
+
+	if _, _, status := backend.UserBlockchain.ProfileWrite([]blockchain.BlockRecordProfile{blockchain.ProfileFieldFromText(blockchain.ProfileName, "Test User")}); status != blockchain.StatusOK {
+		t.Fatalf("Error writing profile (status %d)", status)
+	}
+
+	vector := testMnemonicVectors[2]
+	publicKey, err := backend.ImportMnemonic(vector.mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The running node keeps its key and blockchain until restart.
+	if backend.Config.PrivateKey != privateKeyOld || !backend.PeerPublicKey.IsEqual(backend.PeerPrivateKey.PubKey()) || backend.PeerPublicKey.IsEqual(publicKey) {
+		t.Fatal("Private key replaced while running")
+	}
+	if _, height, _ := backend.UserBlockchain.Header(); height != 1 {
+		t.Fatalf("Blockchain modified while running, height %d", height)
+	}
+
+	// Restart
+	backend.UserBlockchain.Close()
+
+	config := &Config{}
+	if status, err := LoadConfig(backend.ConfigFilename, config); status != ExitSuccess {
+		t.Fatal(err)
+	} else if config.PrivateKeyImport != vector.entropy {
+		t.Fatal("Imported private key not saved")
+	}
+
+	// Without confirmation the import is not applied.
+	if err := backend.applyPrivateKeyImport(); err != nil {
+		t.Fatal(err)
+	} else if backend.Config.PrivateKey != privateKeyOld || backend.Config.PrivateKeyImport != vector.entropy {
+		t.Fatal("Unconfirmed import applied")
+	}
+
+	if err := backend.ConfirmMnemonicImport(backend.PeerPublicKey); err == nil {
+		t.Fatal("Import confirmed with a different peer ID")
+	} else if err := backend.ConfirmMnemonicImport(publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.applyPrivateKeyImport(); err != nil {
+		t.Fatal(err)
+	}
+
+	if backend.Config.PrivateKey != vector.entropy || backend.Config.PrivateKeyImport != "" || backend.Config.PrivateKeyImportConfirmed {
+		t.Fatal("Imported private key not applied")
+	}
+	if _, found, _ := blockchain.ReadOwner(backend.Config.StoreBackend, backend.DataLayout.BlockchainMain); found {
+		t.Fatal("Blockchain of the previous key not moved")
+	}
+
+	// The previous key and blockchain are kept in the backup folder.
+	backups, _ := filepath.Glob(filepath.Join(backend.DataLayout.DataFolder, "Backup *"))
+	if len(backups) != 1 {
+		t.Fatalf("Backup folder not created (%d found)", len(backups))
+	}
+	if key, err := os.ReadFile(filepath.Join(backups[0], "Private Key.txt")); err != nil || string(key) != privateKeyOld {
+		t.Fatal("Previous private key not backed up")
+	}
+	if owner, found, _ := blockchain.ReadOwner(backend.Config.StoreBackend, filepath.Join(backups[0], filepath.Base(filepath.Clean(backend.DataLayout.BlockchainMain)))); !found || !owner.IsEqual(backend.PeerPublicKey) {
+		t.Fatal("Blockchain of the previous key not backed up")
+	}
+}
+
+// testMultipathPath creates a path to the target. Sending via a closed path fails.
+func testMultipathPath(t *testing.T, target *net.UDPConn, closed bool) *Connection {
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed {
+		socket.Close()
+	} else {
+		t.Cleanup(func() { socket.Close() })
+	}
+
+	network := &Network{socket: socket, address: socket.LocalAddr().(*net.UDPAddr)}
+	return &Connection{Network: network, Address: target.LocalAddr().(*net.UDPAddr), RoundTripTime: time.Millisecond}
+}
+
+func TestMultipathBackoff(t *testing.T) {
+	target, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	// One healthy path and one path whose socket is closed, which fails to send.
+	pathHealthy, pathFailing := testMultipathPath(t, target, false), testMultipathPath(t, target, true)
+	paths := []*Connection{pathFailing, pathHealthy}
+	peer := &PeerInfo{}
+
+	// Senders share the paths (run with -race).
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func(mode int) {
+			defer wg.Done()
+			for m := 0; m < 100; m++ {
+				peer.sendMultipath(paths, []byte("data"), mode, false)
+				pathFailing.MultipathStats()
+			}
+		}(MultipathStripe + n%2)
+	}
+	wg.Wait()
+
+	if _, _, sendErrors := pathFailing.MultipathStats(); sendErrors == 0 {
+		t.Fatal("Send errors not counted")
+	}
+	if backoff := time.Duration(atomic.LoadInt64(&pathFailing.multipath.backoff)); backoff < multipathBackoffMin || backoff > multipathBackoffMax {
+		t.Fatalf("Unexpected backoff %s", backoff)
+	}
+	if packetsSent, _, _ := pathHealthy.MultipathStats(); packetsSent == 0 || atomic.LoadInt64(&pathHealthy.multipath.backoff) != 0 {
+		t.Fatal("Healthy path not used")
+	}
+}
+
+func TestMultipathDuplicate(t *testing.T) {
+	target, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	peer := &PeerInfo{}
+	pathHealthy, pathFailing := testMultipathPath(t, target, false), testMultipathPath(t, target, true)
+
+	// Sending succeeds if at least one path succeeds, and fails if all paths fail.
+	if err := peer.sendMultipath([]*Connection{pathFailing, pathHealthy}, []byte("data"), MultipathDuplicate, false); err != nil {
+		t.Fatalf("Send via healthy path reported as failed: %v", err)
+	}
+	if err := peer.sendMultipath([]*Connection{pathFailing, testMultipathPath(t, target, true)}, []byte("data"), MultipathDuplicate, false); err == nil {
+		t.Fatal("Send via failing paths reported as success")
+	}
+}
+
+func TestMultipathStripeLoad(t *testing.T) {
+	target, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	peer := &PeerInfo{}
+	pathOld, pathRecent := testMultipathPath(t, target, false), testMultipathPath(t, target, false)
+
+	// The old load has decayed, so the path is preferred even though it sent more packets in total.
+	pathOld.multipath.load, pathOld.multipath.loadUpdated = 1000, time.Now().Add(-time.Minute)
+	pathRecent.multipath.load, pathRecent.multipath.loadUpdated = 10, time.Now()
+
+	if err := peer.sendMultipath([]*Connection{pathRecent, pathOld}, []byte("data"), MultipathStripe, false); err != nil {
+		t.Fatal(err)
+	}
+	if packetsSent, _, _ := pathOld.MultipathStats(); packetsSent != 1 {
+		t.Fatal("Path with decayed load not selected")
+	}
+
+	// Lossy paths are avoided at equal load.
+	pathLossy, pathClean := testMultipathPath(t, target, false), testMultipathPath(t, target, false)
+	pathLossy.path.pingsTotal, pathLossy.path.pingsLost = 10, 5
+	pathClean.path.pingsTotal = 10
+
+	for n := 0; n < 10; n++ {
+		peer.sendMultipath([]*Connection{pathLossy, pathClean}, []byte("data"), MultipathStripe, false)
+	}
+
+	packetsLossy, _, _ := pathLossy.MultipathStats()
+	packetsClean, _, _ := pathClean.MultipathStats()
+	if packetsClean <= packetsLossy {
+		t.Fatalf("Lossy path preferred: %d packets via lossy path, %d via clean path", packetsLossy, packetsClean)
+	}
+}
+
+func TestUserAgentRefuse(t *testing.T) {
+	backend := testBackend(t)
+	backend.Config.UserAgentPolicy = []UserAgentRule{{Prefix: "Ancient Client/0.", Action: "refuse"}}
+
+	remoteKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := &PeerInfo{Backend: backend, PublicKey: remoteKey.PubKey()}
+
+	if !peer.setUserAgent("Peernet Cmd/1.0") || backend.IsBanned(peer.PublicKey, nil) {
+		t.Fatal("Peer refused")
+	}
+
+	// A refused peer must be banned so that it is not accepted again when reconnecting.
+	if peer.setUserAgent("Ancient Client/0.1") {
+		t.Fatal("Peer not refused")
+	}
+	if !backend.IsBanned(peer.PublicKey, nil) {
+		t.Fatal("Refused peer not banned")
+	}
+
+	// Automatic bans are temporary and never stored in the config.
+	if len(backend.BanList()) != 0 || len(backend.Config.BanList) != 0 {
+		t.Fatal("Automatic ban stored in the config")
+	}
+
+	entries := backend.BanListTemporary()
+	if len(entries) != 1 || entries[0].PublicKey != hex.EncodeToString(peer.PublicKey.SerializeCompressed()) || entries[0].Expires.IsZero() || entries[0].Reason != userAgentRefuseReason {
+		t.Fatalf("Unexpected temporary bans %v", entries)
+	}
+}
+
+func TestProtocolErrorBan(t *testing.T) {
+	backend := testBackend(t)
+	backend.Config.ProtocolErrorThreshold = 3
+
+	remoteKey, _ := btcec.NewPrivateKey(btcec.S256())
+	peer := &PeerInfo{Backend: backend, PublicKey: remoteKey.PubKey()}
+
+	for n := 1; n <= 3; n++ {
+		if banned := peer.protocolError(ProtocolErrorMalformed); banned != (n == 3) {
+			t.Fatalf("Error %d: banned %t", n, banned)
+		}
+	}
+
+	// The ban is temporary and not stored in the config.
+	if !backend.IsBanned(peer.PublicKey, nil) {
+		t.Fatal("Peer not banned")
+	} else if len(backend.Config.BanList) != 0 {
+		t.Fatal("Automatic ban stored in the config")
+	}
+
+	entries := backend.BanListTemporary()
+	if len(entries) != 1 || entries[0].Reason != protocolErrorBanReason {
+		t.Fatalf("Unexpected temporary bans %v", entries)
+	}
+}
+
+func TestBanTemporaryLimit(t *testing.T) {
+	backend := testBackend(t)
+
+	var first *btcec.PublicKey
+	for n := 0; n <= banTemporaryMax; n++ {
+		key, _ := btcec.NewPrivateKey(btcec.S256())
+		if first == nil {
+			first = key.PubKey()
+		}
+		backend.banTemporary(key.PubKey(), time.Hour+time.Duration(n)*time.Second, "test")
+	}
+
+	// The count is limited by dropping the ban expiring first.
+	if count := len(backend.BanListTemporary()); count != banTemporaryMax {
+		t.Fatalf("Temporary bans not limited, count %d", count)
+	} else if backend.IsBanned(first, nil) {
+		t.Fatal("Ban expiring first not dropped")
+	}
+
+	// Expired temporary bans are removed.
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	backend.banTemporary(key.PubKey(), -time.Second, "test")
+	if backend.IsBanned(key.PubKey(), nil) {
+		t.Fatal("Expired temporary ban active")
+	}
+	backend.expireBanList()
+	if count := len(backend.BanListTemporary()); count != banTemporaryMax-1 {
+		t.Fatalf("Expired temporary ban not removed, count %d", count)
+	}
+}
+
+func TestBackendClose(t *testing.T) {
+	backend := testBackend(t)
+
+	if _, _, status := backend.UserBlockchain.ProfileWrite([]blockchain.BlockRecordProfile{blockchain.ProfileFieldFromText(blockchain.ProfileName, "Test User")}); status != blockchain.StatusOK {
+		t.Fatalf("Error writing profile (status %d)", status)
+	}
+
+	backend.Close()
+
+	if len(backend.Tasks()) != 0 {
+		t.Fatal("Scheduled tasks not stopped")
+	}
+
+	// The stores are released and the data is flushed, so the blockchain can be opened again.
+	if owner, found, err := blockchain.ReadOwner(backend.Config.StoreBackend, backend.DataLayout.BlockchainMain); err != nil || !found || !owner.IsEqual(backend.PeerPublicKey) {
+		t.Fatalf("Blockchain not closed: %v", err)
+	}
+}
+
+func TestLookupRelay(t *testing.T) {
+	backend := testBackend(t)
+	backend.Config.LookupRelay = true
+
+	target, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	targetKey, _ := btcec.NewPrivateKey(btcec.S256())
+	requesterKey, _ := btcec.NewPrivateKey(btcec.S256())
+	ephemeralKey, _ := btcec.NewPrivateKey(btcec.S256())
+	requester := &PeerInfo{Backend: backend, PublicKey: requesterKey.PubKey()}
+
+	builder := protocol.NewAnnouncementBuilder(0, 0, 0)
+	builder.AddFindValue(protocol.HashData([]byte("test")))
+	raw, err := protocol.PacketEncrypt(ephemeralKey, targetKey.PubKey(), &protocol.PacketRaw{Protocol: protocol.ProtocolVersion, Command: protocol.CommandAnnouncement, Payload: builder.Finalize()[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The relay never sends to an arbitrary address provided by the requester.
+	address := target.LocalAddr().(*net.UDPAddr)
+	msg := &protocol.MessageLookupRelay{Action: protocol.LookupRelayForward, Target: targetKey.PubKey(), IP: address.IP, Port: uint16(address.Port), EmbeddedPacketRaw: raw}
+
+	requester.cmdLookupRelay(msg, nil)
+	if count := atomic.LoadInt32(&backend.lookupPrivacy.countExpects); count != 0 {
+		t.Fatal("Lookup relayed to a peer not in the peer list")
+	}
+
+	// Targets in the peer list are reached via the own connection.
+	backend.networks.RLock()
+	network := backend.networks.networks4[0]
+	backend.networks.RUnlock()
+	backend.PeerlistAdd(targetKey.PubKey(), &Connection{Network: network, Address: address, Status: ConnectionActive})
+
+	msg.IP, msg.Port = net.IPv4(127, 0, 0, 2), 1
+	requester.cmdLookupRelay(msg, nil)
+
+	target.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 4096)
+	if n, _, err := target.ReadFromUDP(buffer); err != nil || !bytes.Equal(buffer[:n], raw) {
+		t.Fatalf("Lookup not relayed to the peer: %v", err)
+	}
+
+	// Relaying is opt-in.
+	backend.Config.LookupRelay = false
+	requester.cmdLookupRelay(msg, nil)
+	if count := atomic.LoadInt32(&backend.lookupPrivacy.countExpects); count != 1 {
+		t.Fatalf("Lookup relayed while disabled, %d expects", count)
+	}
+}
+
+func TestHolePunchIntroduce(t *testing.T) {
+	backend := testBackend(t)
+
+	other, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	address := other.LocalAddr().(*net.UDPAddr)
+
+	relayKey, _ := btcec.NewPrivateKey(btcec.S256())
+	relay := &PeerInfo{Backend: backend, PublicKey: relayKey.PubKey()}
+
+	introduce := func(sessionID uint64, otherKey *btcec.PrivateKey, report []byte) {
+		payload, _ := protocol.EncodeHolePunch(protocol.HolePunchIntroduce, sessionID, otherKey.PubKey(), address.IP, uint16(address.Port), 0, false, report)
+		msg, err := protocol.DecodeHolePunch(&protocol.MessageRaw{PacketRaw: protocol.PacketRaw{Payload: payload}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		relay.holePunchIntroduced(msg)
+	}
+
+	received := func() bool {
+		other.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		_, _, err := other.ReadFromUDP(make([]byte, 4096))
+		return err == nil
+	}
+
+	// Addresses not reported by the other peer itself are not punched.
+	otherKey1, _ := btcec.NewPrivateKey(btcec.S256())
+	introduce(1, otherKey1, nil)
+	if received() {
+		t.Fatal("Address without report punched")
+	}
+
+	otherKey2, _ := btcec.NewPrivateKey(btcec.S256())
+	reportOther, _ := protocol.EncodeHolePunchReport(otherKey2, 2, net.IPv4(192, 0, 2, 1))
+	introduce(2, otherKey2, reportOther)
+	if received() {
+		t.Fatal("Address not matching the report punched")
+	}
+
+	otherKey3, _ := btcec.NewPrivateKey(btcec.S256())
+	report, _ := protocol.EncodeHolePunchReport(otherKey3, 3, address.IP)
+	introduce(3, otherKey3, report)
+	if !received() {
+		t.Fatal("Reported address not punched")
+	}
+}
+
+func TestHolePunchLimit(t *testing.T) {
+	backend := testBackend(t)
+
+	relay1, _ := btcec.NewPrivateKey(btcec.S256())
+	relay2, _ := btcec.NewPrivateKey(btcec.S256())
+
+	// The limit is per relay and per introduced peer: One relay cannot block introductions by others.
+	for n := 0; n < holePunchIntroduceLimit; n++ {
+		peer, _ := btcec.NewPrivateKey(btcec.S256())
+		if !backend.holePunchAllowed(false, relay1.PubKey(), peer.PubKey()) {
+			t.Fatalf("Introduce %d not allowed", n)
+		}
+	}
+
+	peer, _ := btcec.NewPrivateKey(btcec.S256())
+	if backend.holePunchAllowed(false, relay1.PubKey(), peer.PubKey()) {
+		t.Fatal("Limit per relay not enforced")
+	}
+	for n := 0; n < holePunchIntroduceLimit; n++ {
+		if !backend.holePunchAllowed(false, relay2.PubKey(), peer.PubKey()) {
+			t.Fatal("Introduce by other relay blocked")
+		}
+	}
+
+	relay3, _ := btcec.NewPrivateKey(btcec.S256())
+	if backend.holePunchAllowed(false, relay3.PubKey(), peer.PubKey()) {
+		t.Fatal("Limit per introduced peer not enforced")
+	}
+
+	// Requests handled as relay are counted separately.
+	if !backend.holePunchAllowed(true, relay1.PubKey(), peer.PubKey()) {
+		t.Fatal("Request blocked by the Introduce limit")
+	}
+}
+
+// testGroupSync copies the blockchain of the owner into the global blockchain cache of the member.
+func testGroupSync(t *testing.T, owner, member *Backend) {
+	publicKey, height, version := owner.UserBlockchain.Header()
+	store := member.GlobalBlockchainCache.Store
+
+	if header, found, _ := store.ReadBlockchainHeader(publicKey); found {
+		store.DeleteBlockchain(header)
+	}
+
+	header, err := store.NewBlockchainHeader(publicKey, version, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := uint64(0); n < height; n++ {
+		raw, _, err := owner.UserBlockchain.GetBlockRaw(n)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := store.IngestBlock(header, n, raw, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGroupChannel(t *testing.T) {
+	owner := testBackend(t)
+	member1 := testBackend(t)
+	member2 := testBackend(t)
+	ownerPeer := &PeerInfo{PublicKey: owner.PeerPublicKey}
+
+	group := blockchain.BlockRecordGroup{ID: uuid.New(), Name: "Test", Members: []*btcec.PublicKey{member1.PeerPublicKey, member2.PeerPublicKey}}
+	if _, _, status := owner.UserBlockchain.GroupWrite(group); status != blockchain.StatusOK {
+		t.Fatalf("Error writing group: %d", status)
+	}
+	testGroupSync(t, owner, member1)
+	testGroupSync(t, owner, member2)
+
+	texts := func(backend *Backend) string {
+		messages, _ := backend.GroupMessages(owner.PeerPublicKey, group.ID, 0)
+		var list []string
+		for _, message := range messages {
+			list = append(list, message.Text)
+		}
+		return strings.Join(list, ",")
+	}
+
+	// Each member receives the sender key encrypted to its own public key.
+	_, keyID1, _, messageRaw, keyFrames, err := owner.groupPrepare(owner.PeerPublicKey, group.ID, "Hello")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(keyFrames) != 2 {
+		t.Fatalf("Expected 2 sender key frames, got %d", len(keyFrames))
+	}
+	keyFrame1 := keyFrames[string(member1.PeerPublicKey.SerializeCompressed())]
+	keyFrame2 := keyFrames[string(member2.PeerPublicKey.SerializeCompressed())]
+
+	// The message stays pending until the sender key arrives. Sender keys of other members cannot be decrypted.
+	member1.groupReceiveFrame(ownerPeer, messageRaw)
+	member1.groupReceiveFrame(ownerPeer, keyFrame2)
+	if texts(member1) != "" {
+		t.Fatal("Message decrypted without sender key")
+	}
+
+	member1.groupReceiveFrame(ownerPeer, keyFrame1)
+	member2.groupReceiveFrame(ownerPeer, keyFrame2)
+	member2.groupReceiveFrame(ownerPeer, messageRaw)
+	if texts(member1) != "Hello" || texts(member2) != "Hello" {
+		t.Fatalf("Message not decrypted: '%s', '%s'", texts(member1), texts(member2))
+	}
+
+	// Sender keys distributed by non-members are ignored.
+	outsider, _ := btcec.NewPrivateKey(btcec.S256())
+	keyEncrypted, _ := btcec.Encrypt(member1.PeerPublicKey, make([]byte, 32))
+	forged, _ := protocol.EncodeGroupFrame(outsider, &protocol.GroupFrame{Type: protocol.GroupFrameSenderKey, GroupID: group.ID, Owner: owner.PeerPublicKey, ID: uuid.New(), KeyID: 1, Date: time.Now(), Recipient: member1.PeerPublicKey, Data: keyEncrypted})
+	member1.groupReceiveFrame(ownerPeer, forged)
+
+	member1.groupChannels.Lock()
+	outsiderKeys := member1.groupChannels.groups[newGroupKey(owner.PeerPublicKey, group.ID)].senderKeys[string(outsider.PubKey().SerializeCompressed())]
+	member1.groupChannels.Unlock()
+	if outsiderKeys != nil {
+		t.Fatal("Sender key of non-member accepted")
+	}
+
+	// Removing a member rotates the sender key. The removed member keeps its outdated view of the group and the old key.
+	group.Members = []*btcec.PublicKey{member1.PeerPublicKey}
+	if _, _, status := owner.UserBlockchain.GroupWrite(group); status != blockchain.StatusOK {
+		t.Fatalf("Error writing group: %d", status)
+	}
+	testGroupSync(t, owner, member1)
+
+	_, keyID2, _, messageRaw, keyFrames, err := owner.groupPrepare(owner.PeerPublicKey, group.ID, "Second")
+	if err != nil {
+		t.Fatal(err)
+	} else if keyID2 != keyID1+1 {
+		t.Fatalf("Sender key not rotated: key ID %d", keyID2)
+	} else if len(keyFrames) != 1 || keyFrames[string(member1.PeerPublicKey.SerializeCompressed())] == nil {
+		t.Fatal("Sender key frames not limited to the remaining members")
+	}
+	keyFrame1 = keyFrames[string(member1.PeerPublicKey.SerializeCompressed())]
+
+	for _, raw := range [][]byte{keyFrame1, messageRaw} {
+		member1.groupReceiveFrame(ownerPeer, raw)
+		member2.groupReceiveFrame(ownerPeer, raw)
+	}
+
+	if texts(member1) != "Hello,Second" {
+		t.Fatalf("Message not decrypted by remaining member: '%s'", texts(member1))
+	} else if texts(member2) != "Hello" {
+		t.Fatalf("Message decrypted by removed member: '%s'", texts(member2))
+	}
+}
+
+// testInvitationEncode signs the invitation with the private key and returns the blob.
+func testInvitationEncode(t *testing.T, privateKey *btcec.PrivateKey, invitation Invitation) (blob string) {
+	hash, err := invitation.signatureHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := btcec.SignCompact(btcec.S256(), privateKey, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invitation.Signature = hex.EncodeToString(signature)
+
+	data, _ := json.Marshal(invitation)
+	return invitationPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestInvitation(t *testing.T) {
+	backend := testBackend(t)
+
+	blob, invitation, err := backend.InvitationCreate(time.Hour, []string{"192.0.2.1:112"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, publicKey, addresses, err := InvitationDecode(blob)
+	if err != nil {
+		t.Fatalf("Valid invitation rejected: %s", err.Error())
+	} else if !publicKey.IsEqual(backend.PeerPublicKey) || decoded.PeerID != invitation.PeerID || len(addresses) != 1 || addresses[0].String() != "192.0.2.1:112" {
+		t.Fatal("Invitation mismatch")
+	}
+
+	// Modified documents keep the original signature.
+	tampered := invitation
+	tampered.Addresses = []string{"198.51.100.1:112"}
+	data, _ := json.Marshal(tampered)
+	if _, _, _, err := InvitationDecode(invitationPrefix + base64.RawURLEncoding.EncodeToString(data)); err == nil {
+		t.Fatal("Tampered invitation accepted")
+	}
+
+	tampered = invitation
+	tampered.Expires = tampered.Expires.Add(time.Hour)
+	data, _ = json.Marshal(tampered)
+	if _, _, _, err := InvitationDecode(invitationPrefix + base64.RawURLEncoding.EncodeToString(data)); err == nil {
+		t.Fatal("Invitation with extended expiry accepted")
+	}
+
+	// Correctly signed but expired.
+	expired := invitation
+	expired.Created = time.Now().UTC().Add(-2 * time.Hour)
+	expired.Expires = time.Now().UTC().Add(-time.Hour)
+	if _, _, _, err := InvitationDecode(testInvitationEncode(t, backend.PeerPrivateKey, expired)); err == nil {
+		t.Fatal("Expired invitation accepted")
+	}
+
+	// Signed by another key than the inviting peer's.
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+	if _, _, _, err := InvitationDecode(testInvitationEncode(t, otherKey, invitation)); err == nil {
+		t.Fatal("Invitation signed by another peer accepted")
+	} else if _, _, _, err := InvitationDecode(testInvitationEncode(t, backend.PeerPrivateKey, invitation)); err != nil {
+		t.Fatalf("Re-signed invitation rejected: %s", err.Error())
+	}
+}
+
+// testBanListSign signs the ban list document with the private key.
+func testBanListSign(t *testing.T, privateKey *btcec.PrivateKey, file BanListFile) (data []byte) {
+	hash, err := file.signatureHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := btcec.SignCompact(btcec.S256(), privateKey, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Signature = hex.EncodeToString(signature)
+
+	data, _ = json.Marshal(file)
+	return data
+}
+
+func TestBanListImport(t *testing.T) {
+	exporter := testBackend(t)
+	importer := testBackend(t)
+	importer.Config.BanTrusted = []string{hex.EncodeToString(exporter.PeerPublicKey.SerializeCompressed())}
+
+	bannedKey, _ := btcec.NewPrivateKey(btcec.S256())
+	exporter.BanAdd(BanEntry{PublicKey: hex.EncodeToString(bannedKey.PubKey().SerializeCompressed()), Reason: "spam"})
+	exporter.BanAdd(BanEntry{IP: "192.0.2.0/24"})
+
+	data, err := exporter.BanListExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file BanListFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unsigned lists are rejected.
+	unsigned := file
+	unsigned.Signature = ""
+	data, _ = json.Marshal(unsigned)
+	if _, err := importer.BanListImport(data); err == nil {
+		t.Fatal("Unsigned ban list accepted")
+	}
+
+	// Lists with entries added after signing are rejected.
+	tampered := file
+	tampered.Entries = append(append([]BanEntry{}, file.Entries...), BanEntry{IP: "198.51.100.1"})
+	data, _ = json.Marshal(tampered)
+	if _, err := importer.BanListImport(data); err == nil {
+		t.Fatal("Tampered ban list accepted")
+	}
+
+	// Lists claiming a trusted signer but signed by another key, and lists signed by untrusted peers are rejected.
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+	if _, err := importer.BanListImport(testBanListSign(t, otherKey, file)); err == nil {
+		t.Fatal("Ban list with forged signature accepted")
+	}
+
+	untrusted := file
+	untrusted.Signer = hex.EncodeToString(otherKey.PubKey().SerializeCompressed())
+	if _, err := importer.BanListImport(testBanListSign(t, otherKey, untrusted)); err == nil {
+		t.Fatal("Ban list of untrusted signer accepted")
+	}
+
+	if len(importer.BanList()) != 0 || importer.IsBanned(bannedKey.PubKey(), net.ParseIP("192.0.2.1")) {
+		t.Fatal("Rejected ban list was merged")
+	}
+
+	// The valid list is merged.
+	data, _ = json.Marshal(file)
+	result, err := importer.BanListImport(data)
+	if err != nil {
+		t.Fatalf("Valid ban list rejected: %s", err.Error())
+	} else if result.Added != 2 {
+		t.Fatalf("Expected 2 added entries, got %d", result.Added)
+	} else if !importer.IsBanned(bannedKey.PubKey(), nil) || !importer.IsBanned(nil, net.ParseIP("192.0.2.1")) {
+		t.Fatal("Imported entries not banned")
+	}
+
+	for _, entry := range importer.BanList() {
+		if entry.Source != file.Signer {
+			t.Fatalf("Invalid source of imported entry: %s", entry.Source)
+		}
+	}
+}
+
+func TestStorageProof(t *testing.T) {
+	challenger := testBackend(t)
+	prover := testBackend(t)
+
+	// The file is smaller than the challenge range, so every challenge covers the entire file and only the nonce differs.
+	data := make([]byte, 3000)
+	rand.Read(data)
+
+	hash, _, _ := challenger.UserWarehouse.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+	prover.UserWarehouse.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+
+	challenge1, expected1, err := challenger.storageChallengeCreate(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response1 := prover.storageProofAnswer(challenge1)
+	if result := storageProofVerify(response1, expected1); result != StorageChallengePassed {
+		t.Fatalf("Correct proof failed with result %d", result)
+	}
+
+	// Proofs are bound to the nonce: Replaying a previous proof for a new challenge fails.
+	challenge2, expected2, err := challenger.storageChallengeCreate(hash)
+	if err != nil {
+		t.Fatal(err)
+	} else if bytes.Equal(challenge1.Nonce, challenge2.Nonce) {
+		t.Fatal("Nonce reused")
+	}
+
+	if result := storageProofVerify(response1, expected2); result != StorageChallengeFailed {
+		t.Fatal("Replayed proof passed")
+	}
+
+	// Proofs over modified data fail.
+	path, _, _, _ := prover.UserWarehouse.FileExists(hash)
+	modified := append([]byte{}, data...)
+	modified[len(modified)/2] ^= 1
+	if err := os.WriteFile(path, modified, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := storageProofVerify(prover.storageProofAnswer(challenge2), expected2); result != StorageChallengeFailed {
+		t.Fatal("Proof over modified data passed")
+	}
+
+	// Files not stored by the challenger cannot be challenged.
+	if _, _, err := prover.storageChallengeCreate(make([]byte, protocol.HashSize)); err != ErrStorageFileNotLocal {
+		t.Fatalf("Unexpected error for unknown file: %v", err)
+	}
+}
+
+func TestAdminReplay(t *testing.T) {
+	backend := testBackend(t)
+	admin1, _ := btcec.NewPrivateKey(btcec.S256())
+	admin2, _ := btcec.NewPrivateKey(btcec.S256())
+	now := time.Now()
+
+	if !backend.adminNonceUse(admin1.PubKey(), 1, now) {
+		t.Fatal("New request rejected")
+	} else if backend.adminNonceUse(admin1.PubKey(), 1, now) {
+		t.Fatal("Replayed request accepted")
+	} else if !backend.adminNonceUse(admin1.PubKey(), 2, now) || !backend.adminNonceUse(admin2.PubKey(), 1, now) {
+		t.Fatal("Request with other nonce or sender rejected")
+	}
+
+	// Nonces are removed once the timestamp is outside the accepted time drift.
+	old := now.Add(-2 * adminMaxTimeDrift)
+	backend.adminNonceUse(admin1.PubKey(), 3, old)
+	backend.adminNonceUse(admin1.PubKey(), 4, now)
+
+	backend.adminNonces.Lock()
+	count := len(backend.adminNonces.seen)
+	backend.adminNonces.Unlock()
+	if count != 4 {
+		t.Fatalf("Expected 4 remembered nonces, got %d", count)
+	}
+}
+
+func TestAdminLogTarget(t *testing.T) {
+	backend := testBackend(t)
+
+	// The log target is changed by admin requests while other goroutines log (run with -race).
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 100; n++ {
+			backend.LogError("TestAdminLogTarget", "message %d\n", n)
+		}
+	}()
+
+	for n := 0; n < 100; n++ {
+		backend.SetLogTarget(3)
+	}
+	wg.Wait()
+
+	if status := backend.adminStatus(); status.LogTarget != 3 {
+		t.Fatalf("Unexpected log target %d", status.LogTarget)
+	}
+}
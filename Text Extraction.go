@@ -0,0 +1,114 @@
+/*
+File Username:  Text Extraction.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Text extraction provides an excerpt of the text of published files, which is stored in the tag TagTextExcerpt and indexed for full-text search
+by the search index of all peers. Plain text files are extracted natively. Other formats such as PDF or office documents require a text extractor
+that is provided by the embedding application via SetTextExtractor, since parsers for these formats are out of scope of the core library.
+The max size of the excerpt is set by the config setting TextExcerptSize.
+*/
+
+package core
+
+import (
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// TextExtractor extracts the text of files. It is implemented by the embedding application.
+type TextExtractor interface {
+	// ExtractText returns the text of the file with the given File Type and File Format (see TypeX and FormatX). The data is size bytes long.
+	// It may return only the beginning of the text, as only the first maxSize bytes are used. Supported is false if the format is not supported.
+	ExtractText(data io.ReaderAt, size int64, fileType, fileFormat uint16, maxSize int) (text string, supported bool, err error)
+}
+
+// SetTextExtractor sets the text extractor used for formats that are not extracted natively. It should be called before Connect. Nil removes it.
+func (backend *Backend) SetTextExtractor(extractor TextExtractor) {
+	backend.textExtractor = extractor
+}
+
+// FileTextExcerpt returns an excerpt of the text of the file stored in the user's warehouse. It is empty if text extraction is disabled,
+// the format is not supported, or the file does not contain text.
+func (backend *Backend) FileTextExcerpt(hash []byte, fileType, fileFormat uint16) (excerpt string) {
+	maxSize := backend.Config.TextExcerptSize
+	if maxSize <= 0 || fileType == TypeFolder || fileFormat == FormatDirectory {
+		return ""
+	}
+
+	path, size, status, _ := backend.UserWarehouse.FileExists(hash)
+	if status != warehouse.StatusOK || size == 0 {
+		return ""
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	var text string
+
+	if fileType == TypeText && fileFormat != FormatHTML {
+		// The text is read with some extra room since whitespace is collapsed.
+		data := make([]byte, 2*maxSize)
+		n, _ := file.ReadAt(data, 0)
+		text = string(data[:n])
+	} else if extractor := backend.textExtractor; extractor != nil {
+		var supported bool
+		if text, supported, err = extractor.ExtractText(file, int64(size), fileType, fileFormat, maxSize); err != nil {
+			backend.LogError("FileTextExcerpt", "extracting text of file %x format %d: %v\n", hash, fileFormat, err)
+			return ""
+		} else if !supported {
+			return ""
+		}
+	}
+
+	return textExcerpt(text, maxSize)
+}
+
+// textExcerpt normalizes the text and cuts it to the max size. Invalid UTF-8 and control characters are removed and whitespace is collapsed.
+func textExcerpt(text string, maxSize int) string {
+	var builder strings.Builder
+	space := false
+
+	for _, char := range strings.ToValidUTF8(text, "") {
+		if unicode.IsSpace(char) {
+			space = builder.Len() > 0
+			continue
+		} else if unicode.IsControl(char) || char == utf8.RuneError {
+			continue
+		}
+
+		size := utf8.RuneLen(char)
+		if space {
+			size++
+		}
+		if builder.Len()+size > maxSize {
+			break
+		} else if space {
+			builder.WriteByte(' ')
+			space = false
+		}
+		builder.WriteRune(char)
+	}
+
+	return builder.String()
+}
+
+// SetFileTextExcerpt sets the tag TagTextExcerpt of the file, unless it is already set.
+func (backend *Backend) SetFileTextExcerpt(file *blockchain.BlockRecordFile) {
+	if file.GetTag(blockchain.TagTextExcerpt) != nil {
+		return
+	}
+
+	if excerpt := backend.FileTextExcerpt(file.Hash, uint16(file.Type), file.Format); excerpt != "" {
+		file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagTextExcerpt, excerpt))
+	}
+}
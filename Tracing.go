@@ -0,0 +1,266 @@
+/*
+File Username:  Tracing.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Message-level tracing for debugging. Each message exchange that uses a sequence (announcement and response, ping and pong, file and block transfers,
+streams) is a trace. The trace ID is derived from the public keys of both peers and the sequence number (see protocol.TraceID), so the requesting
+and the responding peer record spans with the same trace ID without sending any trace information. The span of the responding peer is a child
+of the span of the requesting peer.
+
+Spans are written to the log if TraceLog is enabled, and exported via OTLP/HTTP (JSON encoding) to TraceExport, for example an OpenTelemetry
+collector at "http://localhost:4318/v1/traces". If multiple own nodes export to the same collector, the spans of both sides appear in the same trace.
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// TraceSpan is a single operation within a trace.
+type TraceSpan struct {
+	TraceID    [16]byte          // Trace ID, see protocol.TraceID.
+	SpanID     [8]byte           // Span ID.
+	ParentID   [8]byte           // Parent span ID. Zero for the span of the requesting peer.
+	Name       string            // Name of the operation, for example "announcement".
+	Client     bool              // Whether the span was recorded by the requesting peer (true) or the responding peer (false).
+	Start      time.Time         // Start of the operation.
+	End        time.Time         // End of the operation.
+	Attributes map[string]string // Additional attributes.
+}
+
+// traceQueueSize is the max count of spans pending export. Further spans are dropped until the queue is processed.
+const traceQueueSize = 4096
+
+// traceBatchSize is the max count of spans exported in a single request.
+const traceBatchSize = 512
+
+// traceExportInterval is the max time spans are held before they are exported.
+const traceExportInterval = 5 * time.Second
+
+// traceExportTimeout is the timeout for a single export request.
+const traceExportTimeout = 10 * time.Second
+
+func (backend *Backend) initTracing() {
+	if backend.Config.TraceExport != "" {
+		backend.traceQueue = make(chan TraceSpan, traceQueueSize)
+	}
+}
+
+// traceEnabled checks if spans are recorded.
+func (backend *Backend) traceEnabled() bool {
+	return backend.Config.TraceLog || backend.traceQueue != nil
+}
+
+// traceSpanIDs returns the span ID of the requesting or responding side of the trace, and the parent span ID. Both peers derive the same IDs.
+func traceSpanIDs(traceID [16]byte, client bool) (spanID, parentID [8]byte) {
+	hashClient := protocol.HashData(append(traceID[:], 'c'))
+	if client {
+		copy(spanID[:], hashClient)
+		return spanID, parentID
+	}
+
+	copy(spanID[:], protocol.HashData(append(traceID[:], 's')))
+	copy(parentID[:], hashClient)
+	return spanID, parentID
+}
+
+// traceMessage records the span of a message exchange. The initiator is the peer that created the sequence.
+func (backend *Backend) traceMessage(name string, client bool, peer *btcec.PublicKey, sequenceNumber uint32, bidirectional bool, start, end time.Time, attributes map[string]string) {
+	if !backend.traceEnabled() {
+		return
+	}
+
+	initiator, responder := peer, backend.PeerPublicKey
+	if client {
+		initiator, responder = backend.PeerPublicKey, peer
+	}
+
+	span := TraceSpan{TraceID: protocol.TraceID(initiator, responder, sequenceNumber, bidirectional), Name: name, Client: client, Start: start, End: end, Attributes: attributes}
+	span.SpanID, span.ParentID = traceSpanIDs(span.TraceID, client)
+
+	if span.Attributes == nil {
+		span.Attributes = make(map[string]string)
+	}
+	span.Attributes["peernet.peer_id"] = hex.EncodeToString(peer.SerializeCompressed())
+	span.Attributes["peernet.sequence"] = strconv.FormatUint(uint64(sequenceNumber), 10)
+
+	backend.traceEmit(span)
+}
+
+// traceEmit logs and queues the span for export.
+func (backend *Backend) traceEmit(span TraceSpan) {
+	if backend.Config.TraceLog {
+		backend.LogError("trace", "%s\n", span.String())
+	}
+
+	if backend.traceQueue != nil {
+		select {
+		case backend.traceQueue <- span:
+		default:
+		}
+	}
+}
+
+// String returns the span as single line of text.
+func (span *TraceSpan) String() string {
+	side := "server"
+	if span.Client {
+		side = "client"
+	}
+
+	var keys []string
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString(span.Name + " " + side + " trace=" + hex.EncodeToString(span.TraceID[:]) + " span=" + hex.EncodeToString(span.SpanID[:]))
+	if span.ParentID != [8]byte{} {
+		builder.WriteString(" parent=" + hex.EncodeToString(span.ParentID[:]))
+	}
+	builder.WriteString(" duration=" + span.End.Sub(span.Start).String())
+	for _, key := range keys {
+		builder.WriteString(" " + key + "=" + span.Attributes[key])
+	}
+
+	return builder.String()
+}
+
+// autoTraceExport exports queued spans in batches.
+func (backend *Backend) autoTraceExport() {
+	if backend.traceQueue == nil {
+		return
+	}
+
+	client := &http.Client{Timeout: traceExportTimeout}
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+
+	var batch []TraceSpan
+
+	for {
+		select {
+		case span := <-backend.traceQueue:
+			if batch = append(batch, span); len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := backend.traceExport(client, batch); err != nil {
+			backend.LogError("autoTraceExport", "exporting %d spans to '%s': %v\n", len(batch), backend.Config.TraceExport, err)
+		}
+		batch = nil
+	}
+}
+
+// OTLP JSON encoding, see https://github.com/open-telemetry/opentelemetry-proto. Trace and span IDs are hex encoded.
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"` // 2 = Server, 3 = Client
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value otlpAttrString `json:"value"`
+}
+
+type otlpAttrString struct {
+	StringValue string `json:"stringValue"`
+}
+
+// traceExport sends the spans via OTLP/HTTP.
+func (backend *Backend) traceExport(client *http.Client, spans []TraceSpan) (err error) {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/PeernetOfficial/core"}}
+
+	for _, span := range spans {
+		exported := otlpSpan{
+			TraceID:           hex.EncodeToString(span.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.SpanID[:]),
+			Name:              span.Name,
+			Kind:              2,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        []otlpAttribute{},
+		}
+		if span.Client {
+			exported.Kind = 3
+		}
+		if span.ParentID != [8]byte{} {
+			exported.ParentSpanID = hex.EncodeToString(span.ParentID[:])
+		}
+		for key, value := range span.Attributes {
+			exported.Attributes = append(exported.Attributes, otlpAttribute{Key: key, Value: otlpAttrString{StringValue: value}})
+		}
+
+		scope.Spans = append(scope.Spans, exported)
+	}
+
+	resource := otlpResource{Attributes: []otlpAttribute{
+		{Key: "service.name", Value: otlpAttrString{StringValue: "peernet"}},
+		{Key: "service.instance.id", Value: otlpAttrString{StringValue: hex.EncodeToString(backend.PeerPublicKey.SerializeCompressed())}},
+		{Key: "peernet.user_agent", Value: otlpAttrString{StringValue: backend.userAgent}},
+	}}
+
+	body, err := json.Marshal(otlpExport{ResourceSpans: []otlpResourceSpans{{Resource: resource, ScopeSpans: []otlpScopeSpans{scope}}}})
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Post(backend.Config.TraceExport, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New("HTTP status " + response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+/*
+File Username:  Traffic Relay.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Peers can opt-in as traffic relay via the config setting TrafficRelay. They advertise it via protocol.FeatureExtTrafficRelay and forward the
+traffic of relay-only connections, including UDT transfers, of peers that cannot connect directly (see Hole Punch.go). The forwarded bytes are
+limited per sending peer and globally by the config settings TrafficRelayRatePeer and TrafficRelayRate.
+
+Peers that did not opt-in only forward the traffic of failed hole punching sessions if HolePunchRelay is set, limited per session.
+
+Callers can enumerate connected peers that advertise relaying via TrafficRelays and pass one to HolePunch.
+*/
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+const trafficRelayRateDefault = 1024      // Default max KB/s forwarded for all peers combined.
+const trafficRelayRatePeerDefault = 128   // Default max KB/s forwarded per peer.
+const trafficRelayIdle = 10 * time.Minute // Accounting of peers is deleted if there was no activity.
+
+type trafficRelay struct {
+	peers      map[[btcec.PubKeyBytesLenCompressed]byte]*trafficRelayPeer // Accounting per sending peer.
+	bytes      int                                                        // Bytes forwarded for all peers in the current second.
+	bytesReset time.Time                                                  // When bytes is reset.
+	forwarded  uint64                                                     // Total count of bytes forwarded.
+	dropped    uint64                                                     // Total count of bytes dropped due to the bandwidth caps.
+	sync.Mutex
+}
+
+// trafficRelayPeer is the accounting of a peer whose traffic is forwarded.
+type trafficRelayPeer struct {
+	publicKey  *btcec.PublicKey
+	bytes      int       // Bytes forwarded in the current second.
+	bytesReset time.Time // When bytes is reset.
+	forwarded  uint64    // Count of bytes forwarded.
+	dropped    uint64    // Count of bytes dropped due to the bandwidth caps.
+	lastActive time.Time // Last activity.
+}
+
+// TrafficRelayStats contains the accounting of traffic forwarded as relay.
+type TrafficRelayStats struct {
+	Enabled   bool                    // Whether this peer advertises relaying. Config setting TrafficRelay.
+	RateLimit int                     // Max KB/s forwarded for all peers combined.
+	RatePeer  int                     // Max KB/s forwarded per peer.
+	Forwarded uint64                  // Count of bytes forwarded.
+	Dropped   uint64                  // Count of bytes dropped due to the bandwidth caps.
+	Peers     []TrafficRelayPeerStats // Accounting per peer with recent activity.
+}
+
+// TrafficRelayPeerStats contains the accounting of a single peer whose traffic is forwarded.
+type TrafficRelayPeerStats struct {
+	PublicKey  *btcec.PublicKey // Peer ID of the sender.
+	Forwarded  uint64           // Count of bytes forwarded.
+	Dropped    uint64           // Count of bytes dropped due to the bandwidth caps.
+	LastActive time.Time        // Last activity.
+}
+
+func (backend *Backend) initTrafficRelay() {
+	backend.trafficRelay = &trafficRelay{
+		peers: make(map[[btcec.PubKeyBytesLenCompressed]byte]*trafficRelayPeer),
+	}
+}
+
+// trafficRelayRates returns the global and per peer bandwidth caps in bytes per second.
+func (backend *Backend) trafficRelayRates() (rateGlobal, ratePeer int) {
+	rateGlobal, ratePeer = backend.Config.TrafficRelayRate, backend.Config.TrafficRelayRatePeer
+	if rateGlobal <= 0 {
+		rateGlobal = trafficRelayRateDefault
+	}
+	if ratePeer <= 0 {
+		ratePeer = trafficRelayRatePeerDefault
+	}
+
+	return rateGlobal * 1024, ratePeer * 1024
+}
+
+// trafficRelayAccount accounts size bytes to be forwarded for the sender. If this peer opted-in as traffic relay, the bandwidth caps are
+// enforced and it returns false if the packet must be dropped.
+func (backend *Backend) trafficRelayAccount(sender *btcec.PublicKey, size int) (allowed bool) {
+	var key [btcec.PubKeyBytesLenCompressed]byte
+	copy(key[:], sender.SerializeCompressed())
+
+	tr := backend.trafficRelay
+	tr.Lock()
+	defer tr.Unlock()
+
+	account := tr.peers[key]
+	if account == nil {
+		account = &trafficRelayPeer{publicKey: sender}
+		tr.peers[key] = account
+	}
+
+	now := time.Now()
+	account.lastActive = now
+	if now.After(account.bytesReset) {
+		account.bytes = 0
+		account.bytesReset = now.Add(time.Second)
+	}
+	if now.After(tr.bytesReset) {
+		tr.bytes = 0
+		tr.bytesReset = now.Add(time.Second)
+	}
+
+	if backend.Config.TrafficRelay {
+		rateGlobal, ratePeer := backend.trafficRelayRates()
+		if account.bytes+size > ratePeer || tr.bytes+size > rateGlobal {
+			account.dropped += uint64(size)
+			tr.dropped += uint64(size)
+			return false
+		}
+	}
+
+	account.bytes += size
+	account.forwarded += uint64(size)
+	tr.bytes += size
+	tr.forwarded += uint64(size)
+
+	return true
+}
+
+// TrafficRelays returns the connected peers that advertise relaying traffic, sorted by round-trip time with the fastest first.
+func (backend *Backend) TrafficRelays() (relays []*PeerInfo) {
+	rtts := make(map[*PeerInfo]time.Duration)
+
+	for _, peer := range backend.PeerlistGet() {
+		if peer.FeaturesExt&(1<<protocol.FeatureExtTrafficRelay) == 0 || !peer.isConnectedDirect() {
+			continue
+		}
+
+		relays = append(relays, peer)
+		rtts[peer] = peer.GetRTT()
+	}
+
+	// Peers without measured RTT are sorted last.
+	sort.SliceStable(relays, func(i, j int) bool {
+		rttI, rttJ := rtts[relays[i]], rtts[relays[j]]
+		if rttI == 0 || rttJ == 0 {
+			return rttJ == 0 && rttI != 0
+		}
+		return rttI < rttJ
+	})
+
+	return relays
+}
+
+// TrafficRelayStats returns the accounting of traffic forwarded as relay.
+func (backend *Backend) TrafficRelayStats() (stats TrafficRelayStats) {
+	rateGlobal, ratePeer := backend.trafficRelayRates()
+
+	tr := backend.trafficRelay
+	tr.Lock()
+	defer tr.Unlock()
+
+	stats = TrafficRelayStats{Enabled: backend.Config.TrafficRelay, RateLimit: rateGlobal / 1024, RatePeer: ratePeer / 1024, Forwarded: tr.forwarded, Dropped: tr.dropped}
+
+	for _, account := range tr.peers {
+		stats.Peers = append(stats.Peers, TrafficRelayPeerStats{PublicKey: account.publicKey, Forwarded: account.forwarded, Dropped: account.dropped, LastActive: account.lastActive})
+	}
+
+	sort.Slice(stats.Peers, func(i, j int) bool { return stats.Peers[i].Forwarded > stats.Peers[j].Forwarded })
+
+	return stats
+}
+
+// expireTrafficRelay deletes the accounting of peers without recent activity. It is run by the scheduler.
+func (backend *Backend) expireTrafficRelay() (err error) {
+	threshold := time.Now().Add(-trafficRelayIdle)
+
+	tr := backend.trafficRelay
+	tr.Lock()
+	defer tr.Unlock()
+
+	for key, account := range tr.peers {
+		if account.lastActive.Before(threshold) {
+			delete(tr.peers, key)
+		}
+	}
+
+	return nil
+}
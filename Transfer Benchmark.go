@@ -0,0 +1,200 @@
+/*
+File Username:  Transfer Benchmark.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Benchmark transfers measure the transfer speed to a peer. They are regular file transfers requesting the reserved hash protocol.TransferHashBenchmark.
+The responder streams generated zeros from a shared buffer without involving the warehouse, so that no memory is allocated per transfer and disk
+performance does not distort the measurement. The size, rate, and duration are capped and only a few benchmarks are served concurrently.
+Benchmark transfers are not counted in file statistics or transfer credits.
+*/
+
+package core
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+	"github.com/google/uuid"
+)
+
+// benchmarkDefaultSize is the size of a benchmark transfer if none is requested.
+const benchmarkDefaultSize = 8 * 1024 * 1024
+
+// benchmarkMaxSize is the max size of a benchmark transfer. Larger requests are capped.
+const benchmarkMaxSize = 64 * 1024 * 1024
+
+// benchmarkMaxRate is the max rate in bytes per second a benchmark transfer is served with.
+const benchmarkMaxRate = 16 * 1024 * 1024
+
+// benchmarkMaxDuration is the max duration a benchmark transfer is served. The transfer ends early if it takes longer.
+const benchmarkMaxDuration = 20 * time.Second
+
+// benchmarkMaxConcurrent is the max count of benchmark transfers served concurrently. Only one is served per peer.
+const benchmarkMaxConcurrent = 2
+
+// benchmarkZeros is the shared buffer of generated data. It is never written to.
+var benchmarkZeros = make([]byte, 64*1024)
+
+// BenchmarkResult is the result of a benchmark transfer.
+type BenchmarkResult struct {
+	Size     uint64        // Count of bytes received.
+	Duration time.Duration // Duration from requesting the transfer until the last byte was received.
+	Rate     float64       // Average rate in bytes per second.
+	Complete bool          // Whether the size announced by the responder was received. False if the responder ended the transfer early.
+}
+
+type benchmarkServer struct {
+	peers map[*PeerInfo]struct{} // Peers currently served
+	sync.Mutex
+}
+
+func (backend *Backend) initBenchmark() {
+	backend.benchmark = &benchmarkServer{peers: make(map[*PeerInfo]struct{})}
+}
+
+// acquire reserves a slot to serve a benchmark to the peer.
+func (server *benchmarkServer) acquire(peer *PeerInfo) bool {
+	server.Lock()
+	defer server.Unlock()
+
+	if _, ok := server.peers[peer]; ok || len(server.peers) >= benchmarkMaxConcurrent {
+		return false
+	}
+
+	server.peers[peer] = struct{}{}
+	return true
+}
+
+func (server *benchmarkServer) release(peer *PeerInfo) {
+	server.Lock()
+	delete(server.peers, peer)
+	server.Unlock()
+}
+
+// startBenchmarkTransfer serves a benchmark transfer to the remote peer. Same as startFileTransferUDT, but the data is generated.
+func (peer *PeerInfo) startBenchmarkTransfer(size uint64, sequenceNumber uint32, transferID uuid.UUID) (err error) {
+	if !peer.Backend.benchmark.acquire(peer) {
+		return peer.sendTransfer(nil, protocol.TransferControlNotAvailable, protocol.TransferProtocolUDT, protocol.TransferHashBenchmark, 0, 0, sequenceNumber, uuid.UUID{}, false)
+	}
+	defer peer.Backend.benchmark.release(peer)
+
+	if size == 0 {
+		size = benchmarkDefaultSize
+	} else if size > benchmarkMaxSize {
+		size = benchmarkMaxSize
+	}
+
+	virtualConn := newVirtualPacketConn(peer, func(data []byte, sequenceNumber uint32, transferID uuid.UUID) {
+		peer.sendTransfer(data, protocol.TransferControlActive, 0, protocol.TransferHashBenchmark, 0, size, sequenceNumber, transferID, transferLite)
+	})
+	virtualConn.Stats = &FileTransferStats{Hash: protocol.TransferHashBenchmark, Direction: DirectionOut, FileSize: size, Limit: size}
+
+	virtualConn.transferID = transferID
+	peer.Backend.networks.LiteRouter.RegisterLiteID(transferID, virtualConn, peer.liteReplay(), transferSequenceTimeout, virtualConn.sequenceTerminate)
+
+	virtualConn.sequenceNumber = sequenceNumber
+	peer.Backend.networks.Sequences.RegisterSequenceBi(peer.PublicKey, sequenceNumber, virtualConn, transferSequenceTimeout, nil)
+	virtualConn.traceBegin("benchmark", false)
+
+	udtConfig := udt.DefaultConfig()
+	udtConfig.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
+	udtConfig.MaxFlowWinSize = maxFlowWinSize
+
+	udtConn, err := udt.DialUDT(udtConfig, virtualConn, virtualConn.incomingData, virtualConn.outgoingData, virtualConn.terminationSignal, true)
+	if err != nil {
+		return err
+	}
+
+	defer udtConn.Close()
+	virtualConn.Stats.(*FileTransferStats).UDTConn = udtConn
+
+	if err = protocol.FileTransferWriteHeader(udtConn, size, size); err != nil {
+		return err
+	}
+
+	// The write deadline enforces the max duration, including writes blocked by a slow receiver.
+	start := time.Now()
+	udtConn.SetWriteDeadline(start.Add(benchmarkMaxDuration))
+
+	for sent := uint64(0); sent < size; {
+		chunk := benchmarkZeros
+		if size-sent < uint64(len(chunk)) {
+			chunk = chunk[:size-sent]
+		}
+
+		n, err := udtConn.Write(chunk)
+		if err != nil {
+			return err
+		}
+		sent += uint64(n)
+
+		// Pace the writes to stay below the max rate.
+		if ahead := time.Duration(float64(sent)/benchmarkMaxRate*float64(time.Second)) - time.Since(start); ahead > 0 {
+			time.Sleep(ahead)
+		}
+	}
+
+	return nil
+}
+
+// TransferBenchmark measures the transfer speed from the peer by requesting a benchmark transfer of the given size (0 = default).
+// The responder may cap the size. The timeout applies to the entire transfer.
+func (peer *PeerInfo) TransferBenchmark(size uint64, timeout time.Duration) (result BenchmarkResult, err error) {
+	start := time.Now()
+
+	type requestResult struct {
+		udtConn *udt.UDTSocket
+		err     error
+	}
+	requestChan := make(chan requestResult, 1)
+
+	go func() {
+		udtConn, _, err := peer.FileTransferRequestUDT(protocol.TransferHashBenchmark, 0, size)
+		requestChan <- requestResult{udtConn: udtConn, err: err}
+	}()
+
+	var udtConn *udt.UDTSocket
+
+	select {
+	case request := <-requestChan:
+		if request.err != nil {
+			return result, request.err
+		}
+		udtConn = request.udtConn
+	case <-time.After(timeout):
+		go func() {
+			if request := <-requestChan; request.udtConn != nil {
+				request.udtConn.Close()
+			}
+		}()
+		return result, errors.New("timeout")
+	}
+
+	defer udtConn.Close()
+	udtConn.SetReadDeadline(start.Add(timeout))
+
+	_, transferSize, err := protocol.FileTransferReadHeader(udtConn)
+	if err != nil {
+		return result, err
+	}
+
+	received, err := io.Copy(io.Discard, io.LimitReader(udtConn, int64(transferSize)))
+
+	result.Size = uint64(received)
+	result.Duration = time.Since(start)
+	result.Complete = result.Size == transferSize
+	if result.Duration > 0 {
+		result.Rate = float64(result.Size) / result.Duration.Seconds()
+	}
+
+	if err != nil && result.Size == 0 {
+		return result, err
+	}
+
+	return result, nil
+}
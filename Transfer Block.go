@@ -8,6 +8,7 @@ package core
 
 import (
 	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/PeernetOfficial/core/blockchain"
@@ -23,8 +24,28 @@ var blockSequenceTimeout = time.Second * 10
 // Whether to use the lite protocol for transfer of data.
 const blockTransferLite = true
 
-// startBlockTransfer starts the transfer of blocks. Currently it only serves the user's blockchain.
+// startBlockTransfer starts the transfer of blocks. It serves the user's blockchain, or as supernode blockchains from the global blockchain cache.
 func (peer *PeerInfo) startBlockTransfer(BlockchainPublicKey *btcec.PublicKey, LimitBlockCount uint64, MaxBlockSize uint64, TargetBlocks []protocol.BlockRange, sequenceNumber uint32, transferID uuid.UUID) (err error) {
+	readBlock := func(blockN uint64) (blockData []byte, found bool) {
+		blockData, status, err := peer.Backend.UserBlockchain.GetBlockRaw(blockN)
+		return blockData, err == nil && status == blockchain.StatusOK
+	}
+
+	if !BlockchainPublicKey.IsEqual(peer.Backend.PeerPublicKey) {
+		header := peer.Backend.mirrorBlockchainHeader(BlockchainPublicKey)
+		if header == nil {
+			peer.sendGetBlock(nil, protocol.GetBlockControlNotAvailable, BlockchainPublicKey, 0, 0, nil, sequenceNumber, uuid.UUID{}, false)
+			return errors.New("blockchain not mirrored")
+		}
+
+		atomic.AddInt32(&peer.Backend.supernodeTransfers, 1)
+		defer atomic.AddInt32(&peer.Backend.supernodeTransfers, -1)
+
+		readBlock = func(blockN uint64) (blockData []byte, found bool) {
+			return peer.Backend.GlobalBlockchainCache.Store.ReadBlock(BlockchainPublicKey, header.Version, blockN)
+		}
+	}
+
 	virtualConn := newVirtualPacketConn(peer, func(data []byte, sequenceNumber uint32, transferID uuid.UUID) {
 		peer.sendGetBlock(data, protocol.GetBlockControlActive, BlockchainPublicKey, 0, 0, nil, sequenceNumber, transferID, blockTransferLite)
 	})
@@ -33,11 +54,12 @@ func (peer *PeerInfo) startBlockTransfer(BlockchainPublicKey *btcec.PublicKey, L
 	// use the transfer ID indicated by the remote peer
 	// 17.01.2021: Due to using lite IDs, the sequence termination function in RegisterSequenceBi is no longer used, as data packets are only sent via lite packets.
 	virtualConn.transferID = transferID
-	peer.Backend.networks.LiteRouter.RegisterLiteID(transferID, virtualConn, blockSequenceTimeout, virtualConn.sequenceTerminate)
+	peer.Backend.networks.LiteRouter.RegisterLiteID(transferID, virtualConn, peer.liteReplay(), blockSequenceTimeout, virtualConn.sequenceTerminate)
 
 	// register the sequence since packets are sent bi-directional
 	virtualConn.sequenceNumber = sequenceNumber
 	peer.Backend.networks.Sequences.RegisterSequenceBi(peer.PublicKey, sequenceNumber, virtualConn, blockSequenceTimeout, nil)
+	virtualConn.traceBegin("block-transfer", false)
 
 	udtConfig := udt.DefaultConfig()
 	udtConfig.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
@@ -58,14 +80,10 @@ func (peer *PeerInfo) startBlockTransfer(BlockchainPublicKey *btcec.PublicKey, L
 
 	for _, target := range TargetBlocks {
 		for blockN := target.Offset; blockN < target.Offset+target.Limit; blockN++ {
-			blockData, status, err := peer.Backend.UserBlockchain.GetBlockRaw(blockN)
-			if err != nil {
-				protocol.BlockTransferWriteHeader(udtConn, protocol.GetBlockStatusNotAvailable, protocol.BlockRange{Offset: blockN, Limit: 1}, 0)
-				continue
-			}
+			blockData, found := readBlock(blockN)
 			blockSize := uint64(len(blockData))
 
-			if status != blockchain.StatusOK {
+			if !found {
 				protocol.BlockTransferWriteHeader(udtConn, protocol.GetBlockStatusNotAvailable, protocol.BlockRange{Offset: blockN, Limit: 1}, 0)
 				continue
 			} else if blockSize > MaxBlockSize {
@@ -95,7 +113,7 @@ func (peer *PeerInfo) BlockTransferRequest(BlockchainPublicKey *btcec.PublicKey,
 	virtualConn.Stats = &BlockTransferStats{BlockchainPublicKey: BlockchainPublicKey, Direction: DirectionIn, LimitBlockCount: LimitBlockCount, MaxBlockSize: MaxBlockSize, TargetBlocks: TargetBlocks}
 
 	// new lite ID
-	liteID := peer.Backend.networks.LiteRouter.NewLiteID(virtualConn, blockSequenceTimeout, virtualConn.sequenceTerminate)
+	liteID := peer.Backend.networks.LiteRouter.NewLiteID(virtualConn, peer.liteReplay(), blockSequenceTimeout, virtualConn.sequenceTerminate)
 	virtualConn.transferID = liteID.ID
 
 	// new sequence
@@ -104,6 +122,7 @@ func (peer *PeerInfo) BlockTransferRequest(BlockchainPublicKey *btcec.PublicKey,
 		return nil, nil, errors.New("cannot acquire sequence")
 	}
 	virtualConn.sequenceNumber = sequence.SequenceNumber
+	virtualConn.traceBegin("block-transfer", true)
 
 	udtConfig := udt.DefaultConfig()
 	udtConfig.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
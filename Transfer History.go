@@ -0,0 +1,300 @@
+/*
+File Username:  Transfer History.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Ledger of file transfers in both directions. Each transfer session (one request of a file or file range) creates one record when it ends,
+regardless of whether it completed. Downloads split into multiple ranges or served by multiple peers create one record per range and peer.
+The ledger is stored in the folder defined by the config setting TransferHistory and records older than TransferHistoryMaxAge days are deleted.
+It is used for debugging transfers and restores the per-peer byte credit (see Credit.go) at startup.
+
+Structure of each record:
+Offset  Size    Info
+0       32      File hash
+32      33      Public key of the peer, compressed
+65      1       Direction, see DirectionX
+66      1       Result, see TransferResultX
+67      8       Transferred bytes of file data
+75      8       File size. 0 if unknown.
+83      8       Offset
+91      8       Limit. 0 = until the end of the file.
+99      8       Duration in nanoseconds
+107     8       End time, Unix nanoseconds
+
+The key is the end time (big endian Unix nanoseconds) followed by a 4 byte counter, so that records ending at the same time do not collide.
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/store"
+)
+
+// Results of transfers
+const (
+	TransferResultComplete     = 0 // The entire requested range was transferred.
+	TransferResultPartial      = 1 // Some data was transferred before the transfer was terminated or canceled.
+	TransferResultNotAvailable = 2 // The remote peer does not store the file.
+	TransferResultFailed       = 3 // No data was transferred.
+)
+
+// transferHistoryPruneInterval is the interval to delete records exceeding the max age.
+const transferHistoryPruneInterval = time.Hour
+
+const transferRecordSize = 115
+
+// TransferRecord is a single transfer in the history.
+type TransferRecord struct {
+	Hash      []byte           // File hash.
+	PublicKey *btcec.PublicKey // Remote peer.
+	Direction int              // Direction of the transfer: DirectionIn (download) or DirectionOut (upload).
+	Result    int              // Result, see TransferResultX.
+	Bytes     uint64           // Bytes of file data transferred.
+	FileSize  uint64           // File size. 0 if unknown (only if the transfer failed before it started).
+	Offset    uint64           // Offset of the requested range.
+	Limit     uint64           // Limit of the requested range. 0 = until the end of the file.
+	Duration  time.Duration    // Duration of the transfer.
+	Ended     time.Time        // When the transfer ended.
+}
+
+// TransferHistoryFilter selects records from the history. Direction and Result must be set to -1 to not filter; zero values of the other fields do not filter.
+type TransferHistoryFilter struct {
+	Hash      []byte           // File hash.
+	PublicKey *btcec.PublicKey // Remote peer.
+	Direction int              // DirectionIn or DirectionOut. -1 = any.
+	Result    int              // See TransferResultX. -1 = any.
+	From      time.Time        // Min end time.
+	To        time.Time        // Max end time.
+	Offset    int              // Count of matching records to skip (newest first).
+	Limit     int              // Max count of records to return. 0 = no limit.
+}
+
+// TransferHistorySummary contains totals over all records matching a filter, regardless of the offset and limit.
+type TransferHistorySummary struct {
+	Count    int    // Count of records.
+	BytesIn  uint64 // Bytes downloaded.
+	BytesOut uint64 // Bytes uploaded.
+}
+
+type transferHistory struct {
+	database store.Store
+	counter  uint32 // Counter for unique keys.
+}
+
+func (backend *Backend) initTransferHistory() {
+	if backend.DataLayout.TransferHistory == "" {
+		return
+	}
+
+	database, err := store.Open(backend.Config.StoreBackend, backend.DataLayout.TransferHistory)
+	if err != nil {
+		backend.LogError("initTransferHistory", "error opening transfer history '%s': %v\n", backend.DataLayout.TransferHistory, err)
+		return
+	}
+
+	backend.transferHistory = &transferHistory{database: database}
+
+	backend.transferHistoryPrune()
+	backend.transferHistoryRestoreCredits()
+
+	if backend.Config.TransferHistoryMaxAge > 0 {
+		backend.scheduleTask("transfer-history-prune", transferHistoryPruneInterval, transferHistoryPruneInterval, func() error {
+			backend.transferHistoryPrune()
+			return nil
+		})
+	}
+}
+
+// transferHistoryEnd records the end of the transfer session. It is called when the virtual connection terminates.
+// Benchmark transfers are not recorded.
+func (backend *Backend) transferHistoryEnd(peer *PeerInfo, stats *FileTransferStats, reason int) {
+	if backend.transferHistory == nil || bytes.Equal(stats.Hash, protocol.TransferHashBenchmark) {
+		return
+	}
+
+	record := TransferRecord{Hash: stats.Hash, PublicKey: peer.PublicKey, Direction: stats.Direction, FileSize: stats.FileSize, Offset: stats.Offset, Limit: stats.Limit, Ended: time.Now()}
+	if !stats.Started.IsZero() {
+		record.Duration = record.Ended.Sub(stats.Started)
+	}
+
+	// The payload starts with the header, see protocol.FileTransferWriteHeader.
+	if stats.UDTConn != nil {
+		metrics := stats.UDTConn.Metrics()
+		payload := metrics.DataReceived
+		if stats.Direction == DirectionOut {
+			payload = metrics.DataSent
+		}
+		if payload > 16 {
+			record.Bytes = payload - 16
+		}
+	}
+
+	expected := stats.Limit
+	if expected == 0 && stats.FileSize > stats.Offset {
+		expected = stats.FileSize - stats.Offset
+	}
+
+	switch {
+	case reason == 404:
+		record.Result = TransferResultNotAvailable
+	case stats.FileSize > 0 && record.Bytes >= expected:
+		record.Result = TransferResultComplete
+	case record.Bytes > 0:
+		record.Result = TransferResultPartial
+	default:
+		record.Result = TransferResultFailed
+	}
+
+	go backend.transferHistoryAdd(record)
+}
+
+// transferHistoryAdd stores the record.
+func (backend *Backend) transferHistoryAdd(record TransferRecord) {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key[0:8], uint64(record.Ended.UnixNano()))
+	binary.BigEndian.PutUint32(key[8:12], atomic.AddUint32(&backend.transferHistory.counter, 1))
+
+	if err := backend.transferHistory.database.Set(key, encodeTransferRecord(record)); err != nil {
+		backend.LogError("transferHistoryAdd", "error storing record: %v\n", err)
+	}
+}
+
+// TransferHistory returns the records matching the filter, newest first, and the summary over all matching records.
+// If the transfer history is disabled, no records are returned.
+func (backend *Backend) TransferHistory(filter TransferHistoryFilter) (records []TransferRecord, summary TransferHistorySummary) {
+	if backend.transferHistory == nil {
+		return nil, summary
+	}
+
+	var publicKey []byte
+	if filter.PublicKey != nil {
+		publicKey = filter.PublicKey.SerializeCompressed()
+	}
+
+	backend.transferHistory.database.Iterate(func(key, value []byte) {
+		if len(value) != transferRecordSize {
+			return
+		} else if filter.Hash != nil && !bytes.Equal(value[0:32], filter.Hash) {
+			return
+		} else if publicKey != nil && !bytes.Equal(value[32:32+33], publicKey) {
+			return
+		} else if filter.Direction >= 0 && int(value[65]) != filter.Direction {
+			return
+		} else if filter.Result >= 0 && int(value[66]) != filter.Result {
+			return
+		}
+
+		record, valid := decodeTransferRecord(value)
+		if !valid || !filter.From.IsZero() && record.Ended.Before(filter.From) || !filter.To.IsZero() && record.Ended.After(filter.To) {
+			return
+		}
+
+		summary.Count++
+		if record.Direction == DirectionOut {
+			summary.BytesOut += record.Bytes
+		} else {
+			summary.BytesIn += record.Bytes
+		}
+
+		records = append(records, record)
+	})
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Ended.After(records[j].Ended) })
+
+	if filter.Offset > 0 {
+		if filter.Offset >= len(records) {
+			return nil, summary
+		}
+		records = records[filter.Offset:]
+	}
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+
+	return records, summary
+}
+
+// transferHistoryPrune deletes records exceeding the max age.
+func (backend *Backend) transferHistoryPrune() {
+	if backend.Config.TransferHistoryMaxAge <= 0 {
+		return
+	}
+
+	threshold := uint64(time.Now().Add(-time.Duration(backend.Config.TransferHistoryMaxAge) * 24 * time.Hour).UnixNano())
+
+	var expired [][]byte
+	backend.transferHistory.database.Iterate(func(key, value []byte) {
+		if len(key) != 12 || binary.BigEndian.Uint64(key[0:8]) < threshold {
+			expired = append(expired, append([]byte{}, key...))
+		}
+	})
+
+	for _, key := range expired {
+		backend.transferHistory.database.Delete(key)
+	}
+}
+
+// transferHistoryRestoreCredits adds the transferred bytes of all records to the credits of the peers.
+func (backend *Backend) transferHistoryRestoreCredits() {
+	backend.transferHistory.database.Iterate(func(key, value []byte) {
+		record, valid := decodeTransferRecord(value)
+		if !valid || record.Bytes == 0 {
+			return
+		}
+
+		credit := backend.creditGet(record.PublicKey)
+		if record.Direction == DirectionOut {
+			atomic.AddUint64(&credit.Served, record.Bytes)
+		} else {
+			atomic.AddUint64(&credit.Consumed, record.Bytes)
+		}
+	})
+}
+
+func encodeTransferRecord(record TransferRecord) (raw []byte) {
+	raw = make([]byte, transferRecordSize)
+
+	copy(raw[0:32], record.Hash)
+	copy(raw[32:32+33], record.PublicKey.SerializeCompressed())
+	raw[65] = byte(record.Direction)
+	raw[66] = byte(record.Result)
+	binary.LittleEndian.PutUint64(raw[67:67+8], record.Bytes)
+	binary.LittleEndian.PutUint64(raw[75:75+8], record.FileSize)
+	binary.LittleEndian.PutUint64(raw[83:83+8], record.Offset)
+	binary.LittleEndian.PutUint64(raw[91:91+8], record.Limit)
+	binary.LittleEndian.PutUint64(raw[99:99+8], uint64(record.Duration))
+	binary.LittleEndian.PutUint64(raw[107:107+8], uint64(record.Ended.UnixNano()))
+
+	return raw
+}
+
+func decodeTransferRecord(raw []byte) (record TransferRecord, valid bool) {
+	if len(raw) != transferRecordSize {
+		return record, false
+	}
+
+	var err error
+	if record.PublicKey, err = btcec.ParsePubKey(raw[32:32+33], btcec.S256()); err != nil {
+		return record, false
+	}
+
+	record.Hash = append([]byte{}, raw[0:32]...)
+	record.Direction = int(raw[65])
+	record.Result = int(raw[66])
+	record.Bytes = binary.LittleEndian.Uint64(raw[67 : 67+8])
+	record.FileSize = binary.LittleEndian.Uint64(raw[75 : 75+8])
+	record.Offset = binary.LittleEndian.Uint64(raw[83 : 83+8])
+	record.Limit = binary.LittleEndian.Uint64(raw[91 : 91+8])
+	record.Duration = time.Duration(binary.LittleEndian.Uint64(raw[99 : 99+8]))
+	record.Ended = time.Unix(0, int64(binary.LittleEndian.Uint64(raw[107:107+8])))
+
+	return record, true
+}
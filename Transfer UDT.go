@@ -42,16 +42,17 @@ func (peer *PeerInfo) startFileTransferUDT(hash []byte, fileSize uint64, offset,
 	virtualConn := newVirtualPacketConn(peer, func(data []byte, sequenceNumber uint32, transferID uuid.UUID) {
 		peer.sendTransfer(data, protocol.TransferControlActive, 0, hash, offset, limit, sequenceNumber, transferID, transferLite)
 	})
-	virtualConn.Stats = &FileTransferStats{Hash: hash, Direction: DirectionOut, FileSize: fileSize, Offset: offset, Limit: limit}
+	virtualConn.Stats = &FileTransferStats{Hash: hash, Direction: DirectionOut, FileSize: fileSize, Offset: offset, Limit: limit, Started: time.Now()}
 
 	// use the transfer ID indicated by the remote peer
 	// 17.01.2021: Due to using lite IDs, the sequence termination function in RegisterSequenceBi is no longer used, as data packets are only sent via lite packets.
 	virtualConn.transferID = transferID
-	peer.Backend.networks.LiteRouter.RegisterLiteID(transferID, virtualConn, transferSequenceTimeout, virtualConn.sequenceTerminate)
+	peer.Backend.networks.LiteRouter.RegisterLiteID(transferID, virtualConn, peer.liteReplay(), transferSequenceTimeout, virtualConn.sequenceTerminate)
 
 	// register the sequence since packets are sent bi-directional
 	virtualConn.sequenceNumber = sequenceNumber
 	peer.Backend.networks.Sequences.RegisterSequenceBi(peer.PublicKey, sequenceNumber, virtualConn, transferSequenceTimeout, nil)
+	virtualConn.traceBegin("file-transfer", false)
 
 	udtConfig := udt.DefaultConfig()
 	udtConfig.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
@@ -70,7 +71,14 @@ func (peer *PeerInfo) startFileTransferUDT(hash []byte, fileSize uint64, offset,
 	// First send the header (Total File Size, Transfer Size) and then the file data.
 	protocol.FileTransferWriteHeader(udtConn, fileSize, limit)
 
-	_, _, err = peer.Backend.UserWarehouse.ReadFile(hash, int64(offset), int64(limit), udtConn)
+	_, bytesRead, err := peer.Backend.UserWarehouse.ReadFile(hash, int64(offset), int64(limit), udtConn)
+
+	peer.Backend.fileStatsTransferEnd(hash, uint64(bytesRead), err == nil && uint64(bytesRead) == limit)
+	peer.Backend.creditServed(peer, uint64(bytesRead))
+
+	if err == nil && offset == 0 && uint64(bytesRead) == fileSize {
+		peer.Backend.webhookTransferComplete(peer, hash, fileSize, DirectionOut)
+	}
 
 	return err
 }
@@ -84,9 +92,9 @@ func (peer *PeerInfo) FileTransferRequestUDT(hash []byte, offset, limit uint64)
 	})
 
 	// new lite ID
-	liteID := peer.Backend.networks.LiteRouter.NewLiteID(virtualConn, transferSequenceTimeout, virtualConn.sequenceTerminate)
+	liteID := peer.Backend.networks.LiteRouter.NewLiteID(virtualConn, peer.liteReplay(), transferSequenceTimeout, virtualConn.sequenceTerminate)
 	virtualConn.transferID = liteID.ID
-	virtualConn.Stats = &FileTransferStats{Hash: hash, Direction: DirectionIn, Offset: offset, Limit: limit}
+	virtualConn.Stats = &FileTransferStats{Hash: hash, Direction: DirectionIn, Offset: offset, Limit: limit, Started: time.Now()}
 
 	// new sequence
 	sequence := peer.Backend.networks.Sequences.NewSequenceBi(peer.PublicKey, &peer.messageSequence, virtualConn, transferSequenceTimeout, nil)
@@ -94,6 +102,7 @@ func (peer *PeerInfo) FileTransferRequestUDT(hash []byte, offset, limit uint64)
 		return nil, nil, errors.New("cannot acquire sequence")
 	}
 	virtualConn.sequenceNumber = sequence.SequenceNumber
+	virtualConn.traceBegin("file-transfer", true)
 
 	udtConfig := udt.DefaultConfig()
 	udtConfig.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
@@ -125,6 +134,7 @@ type FileTransferStats struct {
 	Offset    uint64         // Offset to start the transfer
 	Limit     uint64         // Limit in bytes to transfer
 	UDTConn   *udt.UDTSocket // Underlying UDT connection
+	Started   time.Time      // When the transfer was requested
 }
 
 // Transfer directions
@@ -5,12 +5,21 @@ Author:     Peter Kleissner
 
 This file defines a virtual connection between a transfer protocol and Peernet messages.
 If either the downstream transfer protocol or upstream Peernet messages indicate termination, the virtual connection ceases to exist.
+
+Transfer protocols either use the data channels directly (like UDT), or the net.Conn compatible VirtualConn returned by Conn().
 */
 
 package core
 
 import (
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -35,6 +44,14 @@ type VirtualPacketConn struct {
 	incomingData chan []byte
 	outgoingData chan []byte
 
+	// Count of incoming packets dropped because the incoming buffer was full.
+	packetsDropped uint64
+
+	// Tracing: The span is recorded when the connection terminates. Empty name if not traced.
+	traceName   string
+	traceClient bool
+	traceStart  time.Time
+
 	// internal data
 	closed            bool
 	terminationSignal chan struct{} // The termination signal shall be used by the underlying protocol to detect upstream termination.
@@ -76,15 +93,26 @@ func (v *VirtualPacketConn) receiveData(data []byte) {
 		return
 	}
 
+	// Data received via file downloads counts towards the peer's credit.
+	if stats, ok := v.Stats.(*FileTransferStats); ok && stats.Direction == DirectionIn {
+		v.Peer.Backend.creditConsumed(v.Peer, uint64(len(data)))
+	}
+
 	// pass the data on
 	select {
 	case v.incomingData <- data:
 	case <-v.terminationSignal:
 	default:
 		// packet lost
+		atomic.AddUint64(&v.packetsDropped, 1)
 	}
 }
 
+// PacketsDropped returns the count of incoming packets dropped because the reader did not keep up.
+func (v *VirtualPacketConn) PacketsDropped() uint64 {
+	return atomic.LoadUint64(&v.packetsDropped)
+}
+
 // Terminate closes the connection. Do not call this function manually. Use the underlying protocol's function to close the connection.
 // Reason: 404 = Remote peer does not store file (upstream), 2 = Remote termination signal (upstream), 3 = Sequence invalidation or expiration (upstream), 1000+ = Transfer protocol indicated closing (downstream)
 func (v *VirtualPacketConn) Terminate(reason int) (err error) {
@@ -99,9 +127,24 @@ func (v *VirtualPacketConn) Terminate(reason int) (err error) {
 	v.reason = reason
 	close(v.terminationSignal)
 
+	if v.traceName != "" {
+		v.Peer.Backend.traceMessage(v.traceName, v.traceClient, v.Peer.PublicKey, v.sequenceNumber, true, v.traceStart, time.Now(), map[string]string{"peernet.reason": strconv.Itoa(reason), "peernet.packets_dropped": strconv.FormatUint(v.PacketsDropped(), 10)})
+	}
+
+	if stats, ok := v.Stats.(*FileTransferStats); ok {
+		v.Peer.Backend.transferHistoryEnd(v.Peer, stats, reason)
+	}
+
 	return
 }
 
+// traceBegin starts tracing the connection. Client indicates whether the local peer created the sequence. The sequence number must be set.
+func (v *VirtualPacketConn) traceBegin(name string, client bool) {
+	v.traceName = name
+	v.traceClient = client
+	v.traceStart = time.Now()
+}
+
 // IsTerminated checks if the connection is terminated
 func (v *VirtualPacketConn) IsTerminated() bool {
 	return v.closed
@@ -127,7 +170,211 @@ func (v *VirtualPacketConn) CloseLinger(reason int) (err error) {
 	return nil
 }
 
+// LocalAddr returns the address of the local peer. It is also returned by LocalAddr of UDT sockets using the virtual connection.
+func (v *VirtualPacketConn) LocalAddr() net.Addr {
+	return &VirtualAddr{PeerID: hex.EncodeToString(v.Peer.Backend.PeerPublicKey.SerializeCompressed()), TransferID: v.transferID}
+}
+
+// RemoteAddr returns the address of the remote peer. It is also returned by RemoteAddr of UDT sockets using the virtual connection.
+func (v *VirtualPacketConn) RemoteAddr() net.Addr {
+	return &VirtualAddr{PeerID: hex.EncodeToString(v.Peer.PublicKey.SerializeCompressed()), TransferID: v.transferID}
+}
+
 // GetTerminateReason returns the termination reason. 0 = Not yet terminated.
 func (v *VirtualPacketConn) GetTerminateReason() int {
 	return v.reason
 }
+
+// ---- net.Conn compatible API ----
+
+// virtualConnCloseReason is the termination reason used when a VirtualConn is closed.
+const virtualConnCloseReason = 1000
+
+// VirtualConn provides a net.Conn compatible API on top of a virtual connection, so that transfer protocols can use standard Go IO interfaces.
+// Writes are split into packets of the max packet size. They block until each packet is passed on to the network, which provides backpressure to fast writers.
+// Incoming packets are buffered (see newVirtualPacketConn) until read. If the reader does not keep up, packets are dropped and counted via PacketsDropped.
+// Delivery is not guaranteed and there is no retransmission; protocols requiring reliable delivery must handle packet loss themselves.
+type VirtualConn struct {
+	virtual       *VirtualPacketConn
+	maxPacketSize int
+	remaining     []byte // Unread data of the current packet.
+
+	readMutex     sync.Mutex
+	writeMutex    sync.Mutex
+	readDeadline  *connDeadline
+	writeDeadline *connDeadline
+}
+
+var _ net.Conn = (*VirtualConn)(nil)
+
+// Conn returns a net.Conn compatible API to the virtual connection. It must not be used together with another transfer protocol (like UDT) on the same virtual connection.
+// The max packet size limits the data sent per packet, for example protocol.TransferMaxEmbedSizeLite.
+func (v *VirtualPacketConn) Conn(maxPacketSize int) (conn *VirtualConn) {
+	return &VirtualConn{
+		virtual:       v,
+		maxPacketSize: maxPacketSize,
+		readDeadline:  newConnDeadline(),
+		writeDeadline: newConnDeadline(),
+	}
+}
+
+// Read reads data from the connection. If a packet is larger than p, the remaining data is returned by the next call.
+// It returns io.EOF after the connection is terminated and all buffered data was read.
+func (c *VirtualConn) Read(p []byte) (n int, err error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	for len(c.remaining) == 0 {
+		select {
+		case c.remaining = <-c.virtual.incomingData:
+		case <-c.virtual.terminationSignal:
+			select {
+			case c.remaining = <-c.virtual.incomingData:
+			default:
+				return 0, io.EOF
+			}
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	n = copy(p, c.remaining)
+	c.remaining = c.remaining[n:]
+
+	return n, nil
+}
+
+// Write sends the data to the remote peer. It blocks until all packets are passed on to the network, the write deadline passes, or the connection terminates.
+func (c *VirtualConn) Write(p []byte) (n int, err error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	for len(p) > 0 {
+		size := len(p)
+		if size > c.maxPacketSize {
+			size = c.maxPacketSize
+		}
+
+		// The data is copied since the caller may reuse the buffer before the packet is sent.
+		packet := make([]byte, size)
+		copy(packet, p)
+
+		select {
+		case c.virtual.outgoingData <- packet:
+		case <-c.virtual.terminationSignal:
+			return n, net.ErrClosed
+		case <-c.writeDeadline.wait():
+			return n, os.ErrDeadlineExceeded
+		}
+
+		n += size
+		p = p[size:]
+	}
+
+	return n, nil
+}
+
+// Close closes the connection. Blocked Read and Write calls return.
+func (c *VirtualConn) Close() error {
+	return c.virtual.Close(virtualConnCloseReason)
+}
+
+// LocalAddr returns the address of the local peer.
+func (c *VirtualConn) LocalAddr() net.Addr {
+	return c.virtual.LocalAddr()
+}
+
+// RemoteAddr returns the address of the remote peer.
+func (c *VirtualConn) RemoteAddr() net.Addr {
+	return c.virtual.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines. A zero value means no timeout.
+func (c *VirtualConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future and currently blocked Read calls. A zero value means no timeout.
+func (c *VirtualConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future and currently blocked Write calls. A zero value means no timeout.
+func (c *VirtualConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// VirtualAddr is the address of a virtual connection endpoint.
+type VirtualAddr struct {
+	PeerID     string    // Peer ID, hex encoded.
+	TransferID uuid.UUID // Transfer ID of the virtual connection.
+}
+
+// Network returns the network name.
+func (a *VirtualAddr) Network() string {
+	return "peernet"
+}
+
+func (a *VirtualAddr) String() string {
+	return a.PeerID + "/" + a.TransferID.String()
+}
+
+// connDeadline is a deadline for Read or Write operations. The channel returned by wait is closed once the deadline passes.
+type connDeadline struct {
+	sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // Closed when the deadline passes.
+}
+
+func newConnDeadline() *connDeadline {
+	return &connDeadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline. A zero value means no deadline.
+func (d *connDeadline) set(t time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// The timer already fired (or is about to close the channel). Use a new channel.
+		<-d.cancel
+		d.cancel = make(chan struct{})
+	} else if d.timer == nil && isClosed(d.cancel) {
+		d.cancel = make(chan struct{})
+	}
+	d.timer = nil
+
+	if t.IsZero() {
+		return
+	}
+
+	if duration := time.Until(t); duration > 0 {
+		cancel := d.cancel
+		d.timer = time.AfterFunc(duration, func() { close(cancel) })
+		return
+	}
+
+	close(d.cancel)
+}
+
+// wait returns a channel that is closed when the deadline passes.
+func (d *connDeadline) wait() <-chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.cancel
+}
+
+// isClosed checks if the channel is closed without blocking.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
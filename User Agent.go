@@ -0,0 +1,107 @@
+/*
+File Username:  User Agent.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+User Agent policy and statistics. The User Agent is reported by remote peers in the initial Announcement and Response messages.
+Policy rules allow operators to warn about or refuse specific (for example ancient) client implementations. Refused peers are banned via the
+ban list for userAgentRefuseBan, so they are not accepted again each time they reconnect.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+)
+
+const userAgentRefuseBan = 60 * time.Minute       // Ban duration of peers refused per User Agent policy.
+const userAgentRefuseReason = "user agent policy" // Reason of the temporary ban.
+
+// UserAgentRule is a single policy rule matched against the User Agent reported by remote peers.
+type UserAgentRule struct {
+	Prefix string `yaml:"Prefix"` // Prefix of the User Agent to match, case insensitive. For example "Peernet Cmd/0.".
+	Action string `yaml:"Action"` // Action to take: "warn" logs the peer, "refuse" drops and temporarily bans the peer.
+}
+
+// User Agent policy actions
+const (
+	UserAgentActionAccept = iota // Accept the peer (no rule matched).
+	UserAgentActionWarn          // Accept the peer but log a warning.
+	UserAgentActionRefuse        // Refuse the peer.
+)
+
+// UserAgentPolicy returns the action to take for the given User Agent according to the configured rules. The first matching rule wins.
+func (backend *Backend) UserAgentPolicy(userAgent string) (action int) {
+	userAgentL := strings.ToLower(userAgent)
+
+	for _, rule := range backend.Config.UserAgentPolicy {
+		if rule.Prefix == "" || !strings.HasPrefix(userAgentL, strings.ToLower(rule.Prefix)) {
+			continue
+		}
+
+		switch strings.ToLower(rule.Action) {
+		case "warn":
+			return UserAgentActionWarn
+		case "refuse":
+			return UserAgentActionRefuse
+		}
+	}
+
+	return UserAgentActionAccept
+}
+
+// setUserAgent applies the User Agent policy and sets the User Agent of the peer. It returns false if the peer is refused, in which case it is banned.
+func (peer *PeerInfo) setUserAgent(userAgent string) (accept bool) {
+	if userAgent == "" {
+		return true
+	}
+
+	switch peer.Backend.UserAgentPolicy(userAgent) {
+	case UserAgentActionRefuse:
+		peerID := hex.EncodeToString(peer.PublicKey.SerializeCompressed())
+		peer.Backend.LogError("setUserAgent", "refuse peer %s with User Agent '%s' per policy, banned for %s\n", peerID, userAgent, userAgentRefuseBan.String())
+
+		peer.Backend.banTemporary(peer.PublicKey, userAgentRefuseBan, userAgentRefuseReason)
+		return false
+
+	case UserAgentActionWarn:
+		if peer.UserAgent != userAgent {
+			peer.Backend.LogError("setUserAgent", "warning: peer %s uses User Agent '%s'\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()), userAgent)
+		}
+	}
+
+	peer.UserAgent = userAgent
+
+	return true
+}
+
+// UserAgentStatistic is the count of peers using the same User Agent.
+type UserAgentStatistic struct {
+	UserAgent string // User Agent. Empty if not yet reported by the peer.
+	Count     int    // Count of peers in the peer list
+}
+
+// UserAgentStatistics returns the aggregated User Agents of all peers in the peer list, sorted by count descending.
+func (backend *Backend) UserAgentStatistics() (stats []UserAgentStatistic) {
+	counts := make(map[string]int)
+
+	for _, peer := range backend.PeerlistGet() {
+		counts[peer.UserAgent]++
+	}
+
+	for userAgent, count := range counts {
+		stats = append(stats, UserAgentStatistic{UserAgent: userAgent, Count: count})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].UserAgent < stats[j].UserAgent
+	})
+
+	return stats
+}
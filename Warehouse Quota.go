@@ -0,0 +1,262 @@
+/*
+File Username:  Warehouse Quota.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The warehouse quota limits the disk usage of the warehouse to the configured WarehouseMaxSize. If exceeded, files are evicted according to
+the configured WarehouseEviction policy until the usage is within the limit:
+* LRU: Least recently used files are deleted first. The last access is the last read or the creation of the file.
+* LFU: Least frequently used files (by count of reads since start) are deleted first. Ties are resolved by the last access.
+
+Files referenced by the user's blockchain, a published directory manifest, or a sync folder are never evicted, since they are shared by the
+user. Pinned files are never evicted either. Evictable files are those cached by the gateway or downloaded for other peers.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// warehouseQuotaInterval is the interval to enforce the warehouse quota.
+const warehouseQuotaInterval = 5 * time.Minute
+
+// Eviction policies for the warehouse quota
+const (
+	WarehouseEvictionLRU = "lru" // Least recently used
+	WarehouseEvictionLFU = "lfu" // Least frequently used
+)
+
+type warehouseQuota struct {
+	pinned       map[[32]byte]struct{} // Pinned files, mirrored to Config.WarehousePinned.
+	evictions    uint64                // Count of evicted files since start.
+	evictedSize  uint64                // Total size of evicted files since start.
+	lastEviction time.Time             // Time of the last eviction.
+	enforcing    sync.Mutex            // Prevents concurrent enforcement.
+	sync.RWMutex                       // Protects the fields above.
+}
+
+// WarehouseStats contains the disk usage of the warehouse.
+type WarehouseStats struct {
+	Files        uint64    // Count of files.
+	Size         uint64    // Total size of all files in bytes.
+	MaxSize      uint64    // Max size in bytes. 0 = unlimited.
+	Policy       string    // Eviction policy, see WarehouseEvictionX.
+	PinnedFiles  uint64    // Count of pinned files in the warehouse.
+	PinnedSize   uint64    // Total size of pinned files in bytes.
+	SharedFiles  uint64    // Count of files shared by the user, which are not evictable.
+	SharedSize   uint64    // Total size of shared files in bytes.
+	Evictions    uint64    // Count of evicted files since start.
+	EvictedSize  uint64    // Total size of evicted files since start.
+	LastEviction time.Time // Time of the last eviction. Zero if none.
+}
+
+func (backend *Backend) initWarehouseQuota() {
+	backend.warehouseQuota = &warehouseQuota{pinned: make(map[[32]byte]struct{})}
+
+	for _, hashA := range backend.Config.WarehousePinned {
+		hash, err := hex.DecodeString(hashA)
+		if err != nil || len(hash) != 32 {
+			backend.LogError("initWarehouseQuota", "invalid pinned file hash '%s'\n", hashA)
+			continue
+		}
+
+		var key [32]byte
+		copy(key[:], hash)
+		backend.warehouseQuota.pinned[key] = struct{}{}
+	}
+
+	switch strings.ToLower(backend.Config.WarehouseEviction) {
+	case "", WarehouseEvictionLRU, WarehouseEvictionLFU:
+	default:
+		backend.LogError("initWarehouseQuota", "unknown eviction policy '%s', using '%s'\n", backend.Config.WarehouseEviction, WarehouseEvictionLRU)
+	}
+}
+
+// scheduleWarehouseQuota enforces the warehouse quota regularly if a max size is configured.
+func (backend *Backend) scheduleWarehouseQuota() {
+	if backend.Config.WarehouseMaxSize == 0 || backend.UserWarehouse == nil || backend.Config.Observer {
+		return
+	}
+
+	backend.scheduleTask("warehouse-quota", warehouseQuotaInterval, warehouseQuotaInterval, func() error {
+		_, _, err := backend.WarehouseEnforceQuota()
+		return err
+	})
+}
+
+// warehouseEvictionPolicy returns the configured eviction policy.
+func (backend *Backend) warehouseEvictionPolicy() string {
+	if strings.ToLower(backend.Config.WarehouseEviction) == WarehouseEvictionLFU {
+		return WarehouseEvictionLFU
+	}
+	return WarehouseEvictionLRU
+}
+
+// WarehousePin pins or unpins a file. Pinned files are never evicted nor deleted by the warehouse GC. The file does not need to exist.
+// The change is stored in the config.
+func (backend *Backend) WarehousePin(hash []byte, pin bool) (err error) {
+	var key [32]byte
+	if len(hash) != len(key) {
+		return errors.New("invalid hash")
+	}
+	copy(key[:], hash)
+
+	quota := backend.warehouseQuota
+	quota.Lock()
+
+	if pin {
+		quota.pinned[key] = struct{}{}
+	} else {
+		delete(quota.pinned, key)
+	}
+
+	pinned := []string{}
+	for key := range quota.pinned {
+		pinned = append(pinned, hex.EncodeToString(key[:]))
+	}
+	sort.Strings(pinned)
+
+	backend.Config.WarehousePinned = pinned
+
+	quota.Unlock()
+
+	backend.SaveConfig()
+
+	return nil
+}
+
+// WarehouseIsPinned checks if the file is pinned.
+func (backend *Backend) WarehouseIsPinned(hash []byte) bool {
+	var key [32]byte
+	if len(hash) != len(key) {
+		return false
+	}
+	copy(key[:], hash)
+
+	backend.warehouseQuota.RLock()
+	defer backend.warehouseQuota.RUnlock()
+
+	_, ok := backend.warehouseQuota.pinned[key]
+	return ok
+}
+
+// warehouseFile is a file in the warehouse considered for eviction.
+type warehouseFile struct {
+	hash       []byte
+	size       uint64
+	lastAccess time.Time
+	reads      uint64
+}
+
+// WarehouseStats returns the disk usage of the warehouse. An error is returned if the blockchain cannot be read.
+func (backend *Backend) WarehouseStats() (stats WarehouseStats, err error) {
+	stats, _, err = backend.warehouseUsage()
+	return stats, err
+}
+
+// warehouseUsage returns the disk usage of the warehouse and the evictable files.
+func (backend *Backend) warehouseUsage() (stats WarehouseStats, evictable []warehouseFile, err error) {
+	referenced, err := backend.warehouseReferencedFiles()
+	if err != nil {
+		return stats, nil, err
+	}
+
+	stats.MaxSize = backend.Config.WarehouseMaxSize * 1024 * 1024
+	stats.Policy = backend.warehouseEvictionPolicy()
+
+	backend.UserWarehouse.IterateFiles(func(hash []byte, fileSize int64) bool {
+		stats.Files++
+		stats.Size += uint64(fileSize)
+
+		if backend.WarehouseIsPinned(hash) {
+			stats.PinnedFiles++
+			stats.PinnedSize += uint64(fileSize)
+		} else if _, ok := referenced[string(hash)]; ok {
+			stats.SharedFiles++
+			stats.SharedSize += uint64(fileSize)
+		} else {
+			evictable = append(evictable, warehouseFile{hash: hash, size: uint64(fileSize)})
+		}
+		return true
+	})
+
+	quota := backend.warehouseQuota
+	quota.RLock()
+	stats.Evictions = quota.evictions
+	stats.EvictedSize = quota.evictedSize
+	stats.LastEviction = quota.lastEviction
+	quota.RUnlock()
+
+	return stats, evictable, nil
+}
+
+// WarehouseEnforceQuota evicts files according to the eviction policy until the warehouse is within the configured max size.
+// It returns the hashes and total size of evicted files. An error is returned if the blockchain cannot be read.
+func (backend *Backend) WarehouseEnforceQuota() (evicted [][]byte, size uint64, err error) {
+	if backend.Config.WarehouseMaxSize == 0 {
+		return nil, 0, nil
+	}
+
+	quota := backend.warehouseQuota
+	quota.enforcing.Lock()
+	defer quota.enforcing.Unlock()
+
+	stats, files, err := backend.warehouseUsage()
+	if err != nil || stats.Size <= stats.MaxSize {
+		return nil, 0, err
+	}
+
+	for n := range files {
+		files[n].lastAccess, files[n].reads, _ = backend.UserWarehouse.FileAccess(files[n].hash)
+	}
+
+	if stats.Policy == WarehouseEvictionLFU {
+		sort.SliceStable(files, func(i, j int) bool {
+			if files[i].reads != files[j].reads {
+				return files[i].reads < files[j].reads
+			}
+			return files[i].lastAccess.Before(files[j].lastAccess)
+		})
+	} else {
+		sort.SliceStable(files, func(i, j int) bool { return files[i].lastAccess.Before(files[j].lastAccess) })
+	}
+
+	usage := stats.Size
+
+	for _, file := range files {
+		if usage <= stats.MaxSize {
+			break
+		}
+
+		if status, err := backend.UserWarehouse.DeleteFile(file.hash); status != warehouse.StatusOK {
+			backend.LogError("WarehouseEnforceQuota", "deleting file %s status %d error: %v\n", hex.EncodeToString(file.hash), status, err)
+			continue
+		}
+
+		usage -= file.size
+		evicted = append(evicted, file.hash)
+		size += file.size
+	}
+
+	if usage > stats.MaxSize {
+		backend.LogError("WarehouseEnforceQuota", "warehouse exceeds max size after eviction: %d of %d bytes (shared %d, pinned %d)\n", usage, stats.MaxSize, stats.SharedSize, stats.PinnedSize)
+	}
+
+	if len(evicted) > 0 {
+		quota.Lock()
+		quota.evictions += uint64(len(evicted))
+		quota.evictedSize += size
+		quota.lastEviction = time.Now()
+		quota.Unlock()
+	}
+
+	return evicted, size, nil
+}
@@ -0,0 +1,196 @@
+/*
+File Username:  Warehouse Scrub.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The warehouse scrubber regularly re-hashes all stored files to detect bit rot or tampering. It reads at a limited rate (config setting
+WarehouseScrubRate) so that it does not compete with transfers. Corrupt files are moved to the quarantine folder of the warehouse and are no longer
+served. If the user still shares the file (it is referenced by the user's blockchain, a directory manifest, or a sync folder) or it is pinned,
+it is downloaded again from peers storing it. Each corrupt file is reported via the filter WarehouseCorrupt and the webhook event warehouse-corrupt.
+*/
+
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+// warehouseScrubRefetchTimeout is the timeout to look up peers storing a corrupt file and to connect to them.
+const warehouseScrubRefetchTimeout = 10 * time.Second
+
+// warehouseScrubRefetchPeers is the max count of peers tried to download a corrupt file again.
+const warehouseScrubRefetchPeers = 5
+
+// WarehouseScrubStatus is the status of the warehouse scrubber.
+type WarehouseScrubStatus struct {
+	Running      bool      // Whether a run is in progress. The counters below refer to the current run.
+	LastStart    time.Time // Start of the current or last run. Zero if never run.
+	LastEnd      time.Time // End of the last run. Zero if not yet completed.
+	FilesChecked uint64    // Count of files verified.
+	BytesChecked uint64    // Total size of files verified.
+	Corrupt      [][]byte  // Hashes of corrupt files found.
+	Refetched    [][]byte  // Hashes of corrupt files that were successfully downloaded again.
+	TotalCorrupt uint64    // Count of corrupt files found since start.
+}
+
+// WarehouseCorruptFile is the data of the warehouse-corrupt webhook event.
+type WarehouseCorruptFile struct {
+	Hash       string `json:"hash"`       // Hash of the file, hex encoded.
+	Size       uint64 `json:"size"`       // Size of the corrupt file on disk.
+	Quarantine string `json:"quarantine"` // Path of the file in the quarantine folder.
+	Shared     bool   `json:"shared"`     // Whether the file is shared by the user or pinned, in which case it is downloaded again.
+	Refetched  bool   `json:"refetched"`  // Whether the file was successfully downloaded again.
+}
+
+type warehouseScrub struct {
+	status WarehouseScrubStatus
+	sync.Mutex
+}
+
+// scheduleWarehouseScrub runs the warehouse scrubber regularly if enabled in the config.
+func (backend *Backend) scheduleWarehouseScrub() {
+	if backend.Config.WarehouseScrubInterval <= 0 || backend.UserWarehouse == nil || backend.UserWarehouse.Disabled {
+		return
+	}
+
+	interval := time.Duration(backend.Config.WarehouseScrubInterval) * time.Hour
+
+	backend.scheduleTask("warehouse-scrub", time.Hour, interval, backend.WarehouseScrub)
+}
+
+// WarehouseScrubStatusGet returns the status of the current or last run of the warehouse scrubber.
+func (backend *Backend) WarehouseScrubStatusGet() (status WarehouseScrubStatus) {
+	backend.warehouseScrub.Lock()
+	defer backend.warehouseScrub.Unlock()
+
+	status = backend.warehouseScrub.status
+	status.Corrupt = append([][]byte{}, status.Corrupt...)
+	status.Refetched = append([][]byte{}, status.Refetched...)
+
+	return status
+}
+
+// WarehouseScrub verifies all files in the warehouse. Corrupt files are quarantined and downloaded again if still shared. It blocks until all
+// files are verified, which may take a long time depending on the warehouse size and the rate limit. Only one run may be active at a time.
+func (backend *Backend) WarehouseScrub() (err error) {
+	if backend.UserWarehouse == nil || backend.UserWarehouse.Disabled {
+		return errors.New("warehouse is disabled")
+	}
+
+	backend.warehouseScrub.Lock()
+	if backend.warehouseScrub.status.Running {
+		backend.warehouseScrub.Unlock()
+		return errors.New("scrub already running")
+	}
+	backend.warehouseScrub.status = WarehouseScrubStatus{Running: true, LastStart: time.Now(), TotalCorrupt: backend.warehouseScrub.status.TotalCorrupt}
+	backend.warehouseScrub.Unlock()
+
+	defer func() {
+		backend.warehouseScrub.Lock()
+		backend.warehouseScrub.status.Running = false
+		backend.warehouseScrub.status.LastEnd = time.Now()
+		backend.warehouseScrub.Unlock()
+	}()
+
+	// The list of files is collected first, since corrupt files are moved while iterating.
+	type storedFile struct {
+		hash []byte
+		size uint64
+	}
+	var files []storedFile
+
+	if err = backend.UserWarehouse.IterateFiles(func(hash []byte, size int64) bool {
+		files = append(files, storedFile{hash: hash, size: uint64(size)})
+		return true
+	}); err != nil {
+		return err
+	}
+
+	rate := backend.Config.WarehouseScrubRate * 1024 * 1024
+
+	for _, file := range files {
+		status, err := backend.UserWarehouse.VerifyFile(file.hash, rate)
+
+		backend.warehouseScrub.Lock()
+		backend.warehouseScrub.status.FilesChecked++
+		backend.warehouseScrub.status.BytesChecked += file.size
+		backend.warehouseScrub.Unlock()
+
+		switch status {
+		case warehouse.StatusOK, warehouse.StatusFileNotFound: // Files may be deleted while the scrubber runs.
+		case warehouse.StatusCorrupt:
+			backend.warehouseScrubCorrupt(file.hash, file.size)
+		default:
+			backend.LogError("WarehouseScrub", "verifying file %s status %d: %v\n", hex.EncodeToString(file.hash), status, err)
+		}
+	}
+
+	return nil
+}
+
+// warehouseScrubCorrupt quarantines the corrupt file, downloads it again if still shared, and reports it.
+func (backend *Backend) warehouseScrubCorrupt(hash []byte, size uint64) {
+	report := WarehouseCorruptFile{Hash: hex.EncodeToString(hash), Size: size}
+
+	path, status, err := backend.UserWarehouse.QuarantineFile(hash)
+	if status != warehouse.StatusOK {
+		backend.LogError("warehouseScrubCorrupt", "quarantining corrupt file %s status %d: %v\n", report.Hash, status, err)
+		return
+	}
+	report.Quarantine = path
+
+	backend.LogError("warehouseScrubCorrupt", "corrupt file %s moved to quarantine '%s'\n", report.Hash, path)
+
+	if referenced, err := backend.warehouseReferencedFiles(); err == nil {
+		_, report.Shared = referenced[string(hash)]
+	}
+	report.Shared = report.Shared || backend.WarehouseIsPinned(hash)
+
+	if report.Shared {
+		report.Refetched = backend.warehouseRefetch(hash)
+	}
+
+	backend.warehouseScrub.Lock()
+	backend.warehouseScrub.status.Corrupt = append(backend.warehouseScrub.status.Corrupt, hash)
+	backend.warehouseScrub.status.TotalCorrupt++
+	if report.Refetched {
+		backend.warehouseScrub.status.Refetched = append(backend.warehouseScrub.status.Refetched, hash)
+	}
+	backend.warehouseScrub.Unlock()
+
+	backend.Filters.WarehouseCorrupt(hash, path, report.Refetched)
+	backend.SendWebhook(WebhookWarehouseCorrupt, nil, report)
+}
+
+// warehouseRefetch downloads the file from other peers storing it. It returns true on success.
+func (backend *Backend) warehouseRefetch(hash []byte) (success bool) {
+	peers, _ := backend.FindStoringPeers(hash, warehouseScrubRefetchTimeout)
+
+	tried := 0
+	for _, peer := range peers {
+		if tried >= warehouseScrubRefetchPeers {
+			break
+		} else if peer.PublicKey.IsEqual(backend.PeerPublicKey) {
+			continue
+		}
+
+		// Storing peers may be temporary structures without an active connection.
+		if connected := backend.PeerlistLookup(peer.PublicKey); connected != nil {
+			peer = connected
+		} else if _, peer, _ = backend.FindNode(peer.NodeID, warehouseScrubRefetchTimeout); peer == nil {
+			continue
+		}
+
+		tried++
+		if err := backend.FetchFile(peer, hash, 0); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
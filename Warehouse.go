@@ -7,14 +7,201 @@ Author:     Peter Kleissner
 package core
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/protocol"
 	"github.com/PeernetOfficial/core/warehouse"
 )
 
 func (backend *Backend) initUserWarehouse() {
+	backend.warehouseScrub = &warehouseScrub{}
+
+	// Observers do not store any files.
+	if backend.Config.Observer {
+		backend.UserWarehouse = warehouse.InitDisabled()
+		return
+	}
+
 	var err error
-	backend.UserWarehouse, err = warehouse.Init(backend.Config.WarehouseMain)
+	backend.UserWarehouse, err = warehouse.Init(backend.DataLayout.WarehouseMain)
 
 	if err != nil {
 		backend.LogError("initUserWarehouse", "error: %s\n", err.Error())
 	}
+
+	backend.initWarehouseQuota()
+}
+
+// WarehouseGC deletes all files in the warehouse that are not referenced by the user's blockchain (directly or via a published directory manifest) or a sync folder. This includes files cached by the gateway.
+// Pinned files are kept. In dry run mode the files are only listed. Status is of type warehouse.StatusX and indicates the last failed deletion, if any.
+// An error is returned if the blockchain cannot be read.
+func (backend *Backend) WarehouseGC(dryRun bool) (deleted [][]byte, size uint64, status int, err error) {
+	referenced, err := backend.warehouseReferencedFiles()
+	if err != nil {
+		return nil, 0, warehouse.StatusOK, err
+	}
+
+	type orphan struct {
+		hash []byte
+		size uint64
+	}
+	var orphans []orphan
+
+	backend.UserWarehouse.IterateFiles(func(hash []byte, fileSize int64) bool {
+		if _, ok := referenced[string(hash)]; !ok && !backend.WarehouseIsPinned(hash) {
+			orphans = append(orphans, orphan{hash: hash, size: uint64(fileSize)})
+		}
+		return true
+	})
+
+	status = warehouse.StatusOK
+
+	for _, file := range orphans {
+		if !dryRun {
+			if statusD, err := backend.UserWarehouse.DeleteFile(file.hash); statusD != warehouse.StatusOK {
+				backend.LogError("WarehouseGC", "deleting file status %d error: %v", statusD, err)
+				status = statusD
+				continue
+			}
+		}
+		deleted = append(deleted, file.hash)
+		size += file.size
+	}
+
+	return deleted, size, status, nil
+}
+
+// warehouseReferencedFiles returns the hashes of all files referenced by the user's blockchain (directly or via a published directory manifest) or a sync folder.
+func (backend *Backend) warehouseReferencedFiles() (referenced map[string]struct{}, err error) {
+	files, status := backend.UserBlockchain.ListFiles()
+	if status != blockchain.StatusOK {
+		return nil, fmt.Errorf("reading blockchain status %d", status)
+	}
+
+	referenced = make(map[string]struct{})
+	for _, file := range files {
+		referenced[string(file.Hash)] = struct{}{}
+	}
+	for _, hash := range backend.syncReferencedFiles() {
+		referenced[string(hash)] = struct{}{}
+	}
+
+	// Published directory manifests reference all files in the tree.
+	for _, file := range files {
+		if file.Format != FormatDirectory {
+			continue
+		}
+
+		hashes, _, _ := backend.UserWarehouse.DirectoryFiles(file.Hash)
+		for _, hash := range hashes {
+			referenced[string(hash)] = struct{}{}
+		}
+	}
+
+	return referenced, nil
+}
+
+// scheduleWarehouseGC runs the warehouse garbage collection regularly if enabled in the config.
+func (backend *Backend) scheduleWarehouseGC() {
+	if backend.Config.WarehouseGCInterval <= 0 || backend.UserWarehouse == nil || backend.Config.Observer {
+		return
+	}
+
+	interval := time.Duration(backend.Config.WarehouseGCInterval) * time.Hour
+
+	backend.scheduleTask("warehouse-gc", interval, interval, func() error {
+		_, _, status, err := backend.WarehouseGC(false)
+		if err != nil {
+			return err
+		} else if status != warehouse.StatusOK {
+			return fmt.Errorf("deleting files status %d", status)
+		}
+		return nil
+	})
+}
+
+func (backend *Backend) warehouseHasFile(hash []byte) bool {
+	_, _, status, _ := backend.UserWarehouse.FileExists(hash)
+	return status == warehouse.StatusOK
+}
+
+// warehouseDownload downloads the file from the peer into the warehouse. The downloaded data must match the hash and must not exceed the max size.
+func (backend *Backend) warehouseDownload(peer *PeerInfo, hash []byte, maxSize uint64) (err error) {
+	udtConn, virtualConn, err := peer.FileTransferRequestUDT(hash, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer udtConn.Close()
+
+	fileSize, transferSize, err := protocol.FileTransferReadHeader(udtConn)
+	if err != nil {
+		return err
+	} else if fileSize > maxSize || transferSize != fileSize {
+		return errors.New("file size mismatch")
+	}
+	virtualConn.Stats.(*FileTransferStats).FileSize = fileSize
+
+	hashData, status, err := backend.UserWarehouse.CreateFile(io.LimitReader(udtConn, int64(transferSize)), transferSize, nil)
+	if status != warehouse.StatusOK {
+		return err
+	} else if !bytes.Equal(hashData, hash) {
+		backend.UserWarehouse.DeleteFile(hashData)
+		return errors.New("hash mismatch")
+	}
+
+	return nil
+}
+
+// directoryMaxDepth is the max depth of sub directories accepted when downloading a directory tree.
+const directoryMaxDepth = 64
+
+// DirectoryDownload downloads the directory tree from the peer and writes it to the target directory, which must not exist.
+// All files are first downloaded into the warehouse and verified by their hash. The target directory is only created if the entire tree is available.
+// Status is of type warehouse.StatusX.
+func (backend *Backend) DirectoryDownload(peer *PeerInfo, hash []byte, target string) (status int, err error) {
+	if err = backend.directoryFetch(peer, hash, 0); err != nil {
+		return warehouse.StatusFileNotFound, err
+	}
+
+	return backend.UserWarehouse.ReadDirectoryToDisk(hash, target)
+}
+
+// directoryFetch downloads the manifest and all files in the tree that are not yet in the warehouse.
+func (backend *Backend) directoryFetch(peer *PeerInfo, hash []byte, depth int) (err error) {
+	if depth > directoryMaxDepth {
+		return errors.New("directory tree exceeds max depth")
+	}
+
+	if !backend.warehouseHasFile(hash) {
+		if err = backend.warehouseDownload(peer, hash, warehouse.DirectoryMaxSize); err != nil {
+			return err
+		}
+	}
+
+	entries, status, err := backend.UserWarehouse.ReadDirectory(hash)
+	if status != warehouse.StatusOK {
+		if err == nil {
+			err = fmt.Errorf("reading directory manifest status %d", status)
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDirectory {
+			err = backend.directoryFetch(peer, entry.Hash, depth+1)
+		} else if !backend.warehouseHasFile(entry.Hash) {
+			err = backend.warehouseDownload(peer, entry.Hash, entry.Size)
+		}
+
+		if err != nil {
+			return fmt.Errorf("'%s': %w", entry.Name, err)
+		}
+	}
+
+	return nil
 }
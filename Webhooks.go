@@ -0,0 +1,282 @@
+/*
+File Username:  Webhooks.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Outbound webhooks allow server deployments to integrate node events with external automation without holding a websocket open.
+Each event is sent as JSON via HTTP POST. If a secret is configured, the body is signed via HMAC-SHA256 and the signature is provided in the header:
+
+X-Peernet-Event:      [event name]
+X-Peernet-Signature:  sha256=[hex encoded HMAC-SHA256 of the body]
+*/
+
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// WebhookConfig is an outbound webhook.
+type WebhookConfig struct {
+	URL    string   `yaml:"URL"`    // Target URL.
+	Secret string   `yaml:"Secret"` // Secret for the HMAC-SHA256 signature. Empty = unsigned.
+	Events []string `yaml:"Events"` // Events to send. Empty = all events.
+	Peers  []string `yaml:"Peers"`  // Peer IDs (hex encoded) to limit events related to remote peers, for example to only receive new content of followed peers. Empty = all peers.
+}
+
+// Webhook events
+const (
+	WebhookTransferComplete = "transfer-complete" // A file transfer (download or full upload) completed.
+	WebhookNewContent       = "new-content"       // New files were shared by a remote peer, as detected by the global blockchain cache.
+	WebhookLowDisk          = "low-disk"          // Free disk space of the warehouse drive fell below the threshold.
+	WebhookPeerOnline       = "peer-online"       // A watched peer came online, see WatchPeer.
+	WebhookWarehouseCorrupt = "warehouse-corrupt" // A corrupt file was found in the warehouse by the scrubber, see WarehouseScrub.
+)
+
+// WebhookEvent is the JSON body sent to the webhook URL.
+type WebhookEvent struct {
+	Event  string      `json:"event"`  // Event name, see WebhookX.
+	Time   time.Time   `json:"time"`   // Time of the event.
+	PeerID string      `json:"peerid"` // Peer ID of this node.
+	Data   interface{} `json:"data"`   // Event specific data.
+}
+
+// WebhookTransfer is the data of the transfer-complete event.
+type WebhookTransfer struct {
+	Hash      string `json:"hash"`           // Hash of the file, hex encoded.
+	Size      uint64 `json:"size"`           // Size of the file.
+	Direction string `json:"direction"`      // "in" for downloads, "out" for uploads to a remote peer.
+	NodeID    string `json:"nodeid"`         // Node ID of the remote peer, hex encoded.
+	Path      string `json:"path,omitempty"` // Target path of downloads.
+}
+
+// WebhookFile is a file in the new-content event.
+type WebhookFile struct {
+	Hash   string `json:"hash"`   // Hash of the file, hex encoded.
+	Size   uint64 `json:"size"`   // Size of the file.
+	Name   string `json:"name"`   // File name.
+	Folder string `json:"folder"` // Folder, if any.
+}
+
+// WebhookContent is the data of the new-content event.
+type WebhookContent struct {
+	PeerID            string        `json:"peerid"`            // Peer ID of the remote peer, hex encoded.
+	BlockchainVersion uint64        `json:"blockchainversion"` // Blockchain version.
+	BlockNumber       uint64        `json:"blocknumber"`       // Block number containing the files.
+	Files             []WebhookFile `json:"files"`             // New files.
+}
+
+// WebhookDisk is the data of the low-disk event.
+type WebhookDisk struct {
+	Path      string `json:"path"`      // Warehouse directory.
+	Free      uint64 `json:"free"`      // Free disk space in bytes.
+	Threshold uint64 `json:"threshold"` // Configured threshold in bytes.
+}
+
+// webhookQueueSize is the max count of pending events. Further events are dropped until the queue is processed.
+const webhookQueueSize = 256
+
+// webhookTimeout is the timeout for a single HTTP POST.
+const webhookTimeout = 10 * time.Second
+
+// webhookRetries is the count of delivery attempts per event. The delay between attempts doubles each time.
+const webhookRetries = 3
+
+// webhookDiskCheckInterval is the interval to check the free disk space.
+const webhookDiskCheckInterval = 5 * time.Minute
+
+type webhookDelivery struct {
+	webhook *WebhookConfig
+	event   string
+	body    []byte
+}
+
+func (backend *Backend) initWebhooks() {
+	backend.webhookQueue = make(chan webhookDelivery, webhookQueueSize)
+}
+
+// autoWebhooks delivers queued webhook events and checks the free disk space if enabled.
+func (backend *Backend) autoWebhooks() {
+	if len(backend.Config.Webhooks) == 0 {
+		return
+	}
+
+	if backend.Config.WebhookLowDisk > 0 {
+		backend.scheduleWebhookLowDisk()
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+
+	for delivery := range backend.webhookQueue {
+		delay := 2 * time.Second
+
+		for attempt := 1; ; attempt++ {
+			err := delivery.post(client)
+			if err == nil {
+				break
+			} else if attempt >= webhookRetries {
+				backend.LogError("autoWebhooks", "sending event '%s' to '%s': %v\n", delivery.event, delivery.webhook.URL, err)
+				break
+			}
+
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (delivery *webhookDelivery) post(client *http.Client) (err error) {
+	request, err := http.NewRequest(http.MethodPost, delivery.webhook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Peernet-Event", delivery.event)
+	if delivery.webhook.Secret != "" {
+		request.Header.Set("X-Peernet-Signature", "sha256="+WebhookSignature([]byte(delivery.webhook.Secret), delivery.body))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New("HTTP status " + response.Status)
+	}
+
+	return nil
+}
+
+// WebhookSignature returns the hex encoded HMAC-SHA256 of the body. Receivers use it to verify the X-Peernet-Signature header.
+func WebhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendWebhook queues the event for all webhooks subscribed to it. Peer is optional and refers to the remote peer the event relates to.
+// It does not block. If the queue is full, the event is dropped.
+func (backend *Backend) SendWebhook(event string, peer *btcec.PublicKey, data interface{}) {
+	if len(backend.Config.Webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(WebhookEvent{Event: event, Time: time.Now().UTC(), PeerID: hex.EncodeToString(backend.PeerPublicKey.SerializeCompressed()), Data: data})
+	if err != nil {
+		return
+	}
+
+	for n := range backend.Config.Webhooks {
+		webhook := &backend.Config.Webhooks[n]
+		if !webhook.subscribed(event, peer) {
+			continue
+		}
+
+		select {
+		case backend.webhookQueue <- webhookDelivery{webhook: webhook, event: event, body: body}:
+		default:
+			backend.LogError("SendWebhook", "queue full, dropping event '%s' for '%s'\n", event, webhook.URL)
+		}
+	}
+}
+
+// subscribed checks if the webhook receives the event.
+func (webhook *WebhookConfig) subscribed(event string, peer *btcec.PublicKey) bool {
+	if len(webhook.Events) > 0 {
+		found := false
+		for _, name := range webhook.Events {
+			if strings.EqualFold(name, event) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if peer != nil && len(webhook.Peers) > 0 {
+		peerID := hex.EncodeToString(peer.SerializeCompressed())
+		for _, allowed := range webhook.Peers {
+			if strings.EqualFold(allowed, peerID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// webhookTransferComplete sends the transfer-complete event.
+func (backend *Backend) webhookTransferComplete(peer *PeerInfo, hash []byte, size uint64, direction int) {
+	transfer := WebhookTransfer{Hash: hex.EncodeToString(hash), Size: size, Direction: "in", NodeID: hex.EncodeToString(peer.NodeID)}
+	if direction == DirectionOut {
+		transfer.Direction = "out"
+	}
+
+	backend.SendWebhook(WebhookTransferComplete, peer.PublicKey, transfer)
+}
+
+// webhookNewContent sends the new-content event for the files in the block, if any.
+func (backend *Backend) webhookNewContent(publicKey *btcec.PublicKey, version, blockNumber uint64, records []interface{}) {
+	if len(backend.Config.Webhooks) == 0 {
+		return
+	}
+
+	content := WebhookContent{PeerID: hex.EncodeToString(publicKey.SerializeCompressed()), BlockchainVersion: version, BlockNumber: blockNumber}
+
+	for _, record := range records {
+		file, ok := record.(blockchain.BlockRecordFile)
+		if !ok {
+			continue
+		}
+
+		webhookFile := WebhookFile{Hash: hex.EncodeToString(file.Hash), Size: file.Size}
+		if tag := file.GetTag(blockchain.TagName); tag != nil {
+			webhookFile.Name = tag.Text()
+		}
+		if tag := file.GetTag(blockchain.TagFolder); tag != nil {
+			webhookFile.Folder = tag.Text()
+		}
+		content.Files = append(content.Files, webhookFile)
+	}
+
+	if len(content.Files) > 0 {
+		backend.SendWebhook(WebhookNewContent, publicKey, content)
+	}
+}
+
+// scheduleWebhookLowDisk checks the free disk space of the warehouse drive regularly. The event is sent once when falling below the threshold and again only after it recovered.
+func (backend *Backend) scheduleWebhookLowDisk() {
+	threshold := backend.Config.WebhookLowDisk * 1024 * 1024
+	low := false
+
+	backend.scheduleTask("webhook-low-disk", 0, webhookDiskCheckInterval, func() error {
+		free, ok := diskFree(backend.DataLayout.WarehouseMain)
+		if !ok {
+			return errors.New("free disk space not available")
+		}
+
+		if free < threshold && !low {
+			backend.SendWebhook(WebhookLowDisk, nil, WebhookDisk{Path: backend.DataLayout.WarehouseMain, Free: free, Threshold: threshold})
+		}
+		low = free < threshold
+
+		return nil
+	})
+}
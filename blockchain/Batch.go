@@ -0,0 +1,112 @@
+/*
+File Username:  Batch.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Batched appending: Records passed to AppendBatch by multiple publish operations within BatchWindow are written as a single block, with one
+signature and one header update. This reduces the growth of the blockchain height and the disk churn for bulk sharers.
+
+The batch is a transaction: Either the block with the records of all callers is written, or none of them. Records of a single call are never
+split across blocks. A batch is written early if adding the records of another call would exceed TargetBlockSize; a single call exceeding it
+is written immediately as its own block.
+*/
+
+package blockchain
+
+import "time"
+
+// appendBatch collects records of multiple AppendBatch calls.
+type appendBatch struct {
+	records []BlockRecordRaw // Records of all calls in the order of the calls.
+	size    uint64           // Size of the block including the header.
+	written bool             // Whether the batch was detached for writing. Protected by the blockchain's batchLock.
+	done    chan struct{}    // Closed when the batch is written.
+
+	// result
+	height  uint64
+	version uint64
+	status  int
+}
+
+// recordsSizeInBlock returns the size of the raw records in a block.
+func recordsSizeInBlock(records []BlockRecordRaw) (size uint64) {
+	for _, record := range records {
+		size += record.SizeInBlock()
+	}
+
+	return size
+}
+
+// AppendBatch appends the records as part of a batch. It waits for records of other calls within BatchWindow and writes them together in a
+// single block. It blocks until the block is written and returns the new height and version. Status is StatusX and applies to the entire batch.
+// If BatchWindow is 0, it is the same as Append.
+func (blockchain *Blockchain) AppendBatch(records []BlockRecordRaw) (newHeight, newVersion uint64, status int) {
+	if blockchain.BatchWindow <= 0 {
+		return blockchain.Append(records)
+	} else if len(records) == 0 {
+		_, height, version := blockchain.Header()
+		return height, version, StatusOK
+	}
+
+	size := recordsSizeInBlock(records)
+
+	blockchain.batchLock.Lock()
+
+	// Write the pending batch first if the records would not fit.
+	if batch := blockchain.batch; batch != nil && batch.size+size > TargetBlockSize {
+		blockchain.batchDetach(batch)
+		go blockchain.batchWrite(batch)
+	}
+
+	batch := blockchain.batch
+	if batch == nil {
+		batch = &appendBatch{size: blockHeaderSize, done: make(chan struct{})}
+		blockchain.batch = batch
+
+		time.AfterFunc(blockchain.BatchWindow, func() {
+			blockchain.batchLock.Lock()
+			detached := blockchain.batchDetach(batch)
+			blockchain.batchLock.Unlock()
+
+			if detached {
+				blockchain.batchWrite(batch)
+			}
+		})
+	}
+
+	batch.records = append(batch.records, records...)
+	batch.size += size
+
+	// A single call exceeding the target block size is not combined with others.
+	if batch.size >= TargetBlockSize {
+		blockchain.batchDetach(batch)
+		go blockchain.batchWrite(batch)
+	}
+
+	blockchain.batchLock.Unlock()
+
+	<-batch.done
+
+	return batch.height, batch.version, batch.status
+}
+
+// batchDetach detaches the batch so that no further records are added. It returns false if it was already detached.
+// The caller must hold the batchLock.
+func (blockchain *Blockchain) batchDetach(batch *appendBatch) (detached bool) {
+	if batch.written {
+		return false
+	}
+
+	batch.written = true
+	if blockchain.batch == batch {
+		blockchain.batch = nil
+	}
+
+	return true
+}
+
+// batchWrite writes the detached batch as a single block and releases all waiting callers.
+func (blockchain *Blockchain) batchWrite(batch *appendBatch) {
+	batch.height, batch.version, batch.status = blockchain.Append(batch.records)
+	close(batch.done)
+}
@@ -0,0 +1,181 @@
+/*
+File Username:  Block Record Custom.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Custom record types allow applications built on the core to store their own structured data on user blockchains. Record types starting at
+RecordTypeCustomFirst are reserved for them. The application registers a handler for each type at startup, before the blockchain is used.
+
+The encoding of the record data is entirely up to the handler. Records of types that are not registered are kept as raw records and passed through
+unchanged; this is the case for any peer that does not run the application. Records that fail to decode are skipped and do not invalidate the block,
+since the data is not validated by the core.
+*/
+
+package blockchain
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecordTypeCustomFirst is the first record type available for custom record types. Lower types are reserved for the core.
+const RecordTypeCustomFirst = 128
+
+// RecordTypeHandler handles a custom record type. Only Decode is required.
+type RecordTypeHandler struct {
+	Name string // Name of the record type. Informational.
+
+	// Decode decodes the record data into the application's structure.
+	Decode func(data []byte) (value interface{}, err error)
+
+	// Encode encodes the value into the record data. Required for CustomRecordAdd.
+	Encode func(value interface{}) (data []byte, err error)
+
+	// Index returns text that is added to the search index for the value. The ID is returned in search results and must be unique for the record.
+	// If ok is false, the value is not indexed.
+	Index func(value interface{}) (id uuid.UUID, text string, ok bool)
+
+	// Project returns the representation of the value in the webapi, which is JSON encoded. If not set, the value itself is used.
+	Project func(value interface{}) interface{}
+}
+
+// BlockRecordCustom is a decoded record of a custom record type.
+type BlockRecordCustom struct {
+	Type  uint8       // Record type
+	Date  time.Time   // Date created
+	Value interface{} // Value as returned by the handler's Decode function
+}
+
+var (
+	recordTypeHandlers      = make(map[uint8]*RecordTypeHandler)
+	recordTypeHandlersMutex sync.RWMutex
+)
+
+// RegisterRecordType registers the handler for a custom record type. It fails if the type is reserved or already registered.
+func RegisterRecordType(recordType uint8, handler RecordTypeHandler) error {
+	if recordType < RecordTypeCustomFirst {
+		return errors.New("record type is reserved")
+	} else if handler.Decode == nil {
+		return errors.New("decode function missing")
+	}
+
+	recordTypeHandlersMutex.Lock()
+	defer recordTypeHandlersMutex.Unlock()
+
+	if _, ok := recordTypeHandlers[recordType]; ok {
+		return errors.New("record type already registered")
+	}
+
+	recordTypeHandlers[recordType] = &handler
+
+	return nil
+}
+
+// RecordTypeHandlerGet returns the handler for the custom record type. Nil if not registered.
+func RecordTypeHandlerGet(recordType uint8) (handler *RecordTypeHandler) {
+	recordTypeHandlersMutex.RLock()
+	defer recordTypeHandlersMutex.RUnlock()
+
+	return recordTypeHandlers[recordType]
+}
+
+// decodeBlockRecordCustom decodes all records of registered custom record types. Other records and records that fail to decode are ignored.
+func decodeBlockRecordCustom(recordsRaw []BlockRecordRaw) (records []BlockRecordCustom) {
+	for _, record := range recordsRaw {
+		if record.Type < RecordTypeCustomFirst {
+			continue
+		}
+
+		handler := RecordTypeHandlerGet(record.Type)
+		if handler == nil {
+			continue
+		}
+
+		value, err := handler.Decode(record.Data)
+		if err != nil {
+			continue
+		}
+
+		records = append(records, BlockRecordCustom{Type: record.Type, Date: record.Date, Value: value})
+	}
+
+	return records
+}
+
+// CustomRecordAdd encodes the values with the handler of the record type and appends them to the blockchain. Status is StatusX.
+func (blockchain *Blockchain) CustomRecordAdd(recordType uint8, values ...interface{}) (newHeight, newVersion uint64, status int) {
+	handler := RecordTypeHandlerGet(recordType)
+	if handler == nil || handler.Encode == nil {
+		return 0, 0, StatusCorruptBlockRecord
+	}
+
+	var records []BlockRecordRaw
+	blockSize := uint64(blockHeaderSize)
+
+	for _, value := range values {
+		data, err := handler.Encode(value)
+		if err != nil {
+			return 0, 0, StatusCorruptBlockRecord
+		}
+
+		recordSize := blockRecordHeaderSize + uint64(len(data))
+
+		// need to create a new block due to target block size?
+		if len(records) > 0 && blockSize+recordSize > TargetBlockSize {
+			if newHeight, newVersion, status = blockchain.Append(records); status != StatusOK {
+				return newHeight, newVersion, status
+			}
+
+			blockSize = blockHeaderSize
+			records = nil
+		}
+
+		blockSize += recordSize
+		records = append(records, BlockRecordRaw{Type: recordType, Data: data})
+	}
+
+	return blockchain.Append(records)
+}
+
+// CustomRecordList returns all records of the custom record type. Status is StatusX.
+func (blockchain *Blockchain) CustomRecordList(recordType uint8) (records []BlockRecordCustom, status int) {
+	status = blockchain.Iterate(func(block *Block) (statusI int) {
+		for _, record := range decodeBlockRecordCustom(block.RecordsRaw) {
+			if record.Type == recordType {
+				records = append(records, record)
+			}
+		}
+
+		return StatusOK
+	})
+
+	return records, status
+}
+
+// CustomRecordDelete deletes all records of the custom record type for which the callback returns true. Status is StatusX.
+func (blockchain *Blockchain) CustomRecordDelete(recordType uint8, callback func(record *BlockRecordCustom) (delete bool)) (newHeight, newVersion uint64, status int) {
+	handler := RecordTypeHandlerGet(recordType)
+	if handler == nil {
+		return 0, 0, StatusCorruptBlockRecord
+	}
+
+	return blockchain.IterateDeleteRecord(nil, func(record *BlockRecordRaw) (deleteAction int) {
+		if record.Type != recordType {
+			return 0
+		}
+
+		value, err := handler.Decode(record.Data)
+		if err != nil {
+			return 0
+		}
+
+		if callback(&BlockRecordCustom{Type: record.Type, Date: record.Date, Value: value}) {
+			return 1
+		}
+
+		return 0
+	})
+}
@@ -0,0 +1,230 @@
+/*
+File Username:  Block Record Delegation.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Delegation records authorize a delegate key to create records of specific types on behalf of the blockchain owner:
+Offset  Size    Info
+0       33      Public key of the delegate (compressed)
+33      8       Expiry, Unix time in seconds
+41      1       Count of record types in scope
+42      n       Record types the delegate may create
+
+Delegated records are created and signed by a delegate. They wrap the inner record:
+Offset  Size    Info
+0       65      Signature by the delegate of the owner's public key (compressed) followed by the remaining data
+65      1       Record type of the inner record
+66      8       Date of the inner record, Unix time in seconds
+74      ?       Data of the inner record
+
+The block is still signed by the owner. When decoding a block, delegated records with a valid signature are unwrapped to the inner record with
+the delegate set, so they are processed like any other record. Encoding the block wraps them again. The owner checks the delegation (scope and
+expiry) when appending delegated records, see DelegatedAppend. Delegated records must be self-contained: Tag data records cannot be delegated.
+*/
+
+package blockchain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/serialize"
+)
+
+// DelegationMaxScope is the max count of record types in the scope of a delegation.
+const DelegationMaxScope = 64
+
+// delegatedRecordHeaderSize is the size of the wrapper of a delegated record before the inner data.
+const delegatedRecordHeaderSize = 74
+
+// BlockRecordDelegation authorizes a delegate key to create records on behalf of the owner.
+type BlockRecordDelegation struct {
+	Delegate *btcec.PublicKey // Public key of the delegate
+	Expiry   time.Time        // Expiry of the delegation
+	Scope    []uint8          // Record types the delegate may create, see RecordTypeX.
+}
+
+// IsActive checks if the delegation is not expired.
+func (delegation *BlockRecordDelegation) IsActive() bool {
+	return time.Now().Before(delegation.Expiry)
+}
+
+// InScope checks if the delegate may create records of the type.
+func (delegation *BlockRecordDelegation) InScope(recordType uint8) bool {
+	for _, scope := range delegation.Scope {
+		if scope == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// isDelegable checks if records of the type may be created by delegates. Tag data records are only valid in the context of their block,
+// and delegates cannot delegate further.
+func isDelegable(recordType uint8) bool {
+	return recordType != RecordTypeTagData && recordType != RecordTypeDelegation && recordType != RecordTypeDelegated && recordType != RecordTypeSubKey &&
+		recordType != RecordTypeSubKeyRevoke
+}
+
+// decodeBlockRecordDelegations decodes only delegation records. Other records are ignored.
+func decodeBlockRecordDelegations(recordsRaw []BlockRecordRaw) (delegations []BlockRecordDelegation, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeDelegation {
+			continue
+		}
+
+		delegation := BlockRecordDelegation{}
+		reader := serialize.NewReader(record.Data)
+		delegateB := reader.Bytes(33)
+		delegation.Expiry = time.Unix(int64(reader.Uint64()), 0).UTC()
+		countScope := int(reader.Uint8())
+		delegation.Scope = reader.BytesCopy(countScope)
+
+		if reader.Err() != nil || reader.Len() != 0 {
+			return nil, errors.New("delegation record invalid size")
+		}
+
+		if delegation.Delegate, err = btcec.ParsePubKey(delegateB, btcec.S256()); err != nil {
+			return nil, err
+		}
+
+		delegations = append(delegations, delegation)
+	}
+
+	return delegations, nil
+}
+
+// encodeBlockRecordDelegation encodes the delegation record.
+func encodeBlockRecordDelegation(delegation BlockRecordDelegation) (recordRaw BlockRecordRaw, err error) {
+	if delegation.Delegate == nil {
+		return recordRaw, errors.New("missing delegate")
+	} else if len(delegation.Scope) == 0 || len(delegation.Scope) > DelegationMaxScope {
+		return recordRaw, errors.New("invalid count of record types in scope")
+	}
+
+	for _, recordType := range delegation.Scope {
+		if !isDelegable(recordType) {
+			return recordRaw, errors.New("record type cannot be delegated")
+		}
+	}
+
+	writer := serialize.NewWriter(int(delegation.SizeInBlock()))
+	writer.Bytes(delegation.Delegate.SerializeCompressed())
+	writer.Uint64(uint64(delegation.Expiry.Unix()))
+	writer.Uint8(uint8(len(delegation.Scope)))
+	writer.Bytes(delegation.Scope)
+
+	data, err := writer.Data()
+	if err != nil {
+		return recordRaw, err
+	}
+
+	return BlockRecordRaw{Type: RecordTypeDelegation, Data: data}, nil
+}
+
+// SizeInBlock returns the full size this delegation record takes up in a single block. (i.e., the record size)
+func (delegation *BlockRecordDelegation) SizeInBlock() (size uint64) {
+	return blockRecordHeaderSize + 42 + uint64(len(delegation.Scope))
+}
+
+// delegatedRecordHash returns the hash signed by the delegate. Including the owner prevents using the record in other blockchains.
+func delegatedRecordHash(owner *btcec.PublicKey, data []byte) []byte {
+	return protocol.HashData(append(owner.SerializeCompressed(), data...))
+}
+
+// DelegatedRecordSign signs the record by the delegate to be appended to the owner's blockchain, see DelegatedAppend.
+// If the date is not set, the current time is used.
+func DelegatedRecordSign(delegateKey *btcec.PrivateKey, owner *btcec.PublicKey, record BlockRecordRaw) (signed BlockRecordRaw, err error) {
+	if !isDelegable(record.Type) {
+		return signed, errors.New("record type cannot be delegated")
+	}
+
+	if record.Date.IsZero() {
+		record.Date = time.Now()
+	}
+
+	signed = BlockRecordRaw{Type: record.Type, Date: time.Unix(record.Date.Unix(), 0), Data: record.Data, Delegate: delegateKey.PubKey()}
+	signed.DelegateSignature = make([]byte, 65)
+
+	data, err := EncodeDelegatedRecord(signed)
+	if err != nil {
+		return signed, err
+	}
+
+	if signed.DelegateSignature, err = btcec.SignCompact(btcec.S256(), delegateKey, delegatedRecordHash(owner, data[65:]), true); err != nil {
+		return signed, err
+	}
+
+	return signed, nil
+}
+
+// EncodeDelegatedRecord encodes the wrapper of a delegated record.
+func EncodeDelegatedRecord(record BlockRecordRaw) (data []byte, err error) {
+	if len(record.DelegateSignature) != 65 {
+		return nil, errors.New("invalid delegate signature")
+	}
+
+	writer := serialize.NewWriter(delegatedRecordHeaderSize + len(record.Data))
+	writer.Bytes(record.DelegateSignature)
+	writer.Uint8(record.Type)
+	writer.Uint64(uint64(record.Date.UTC().Unix()))
+	writer.Bytes(record.Data)
+
+	return writer.Data()
+}
+
+// DecodeDelegatedRecord decodes the wrapper of a delegated record and verifies the signature of the delegate for the owner's blockchain.
+// It does not check whether the delegate is authorized.
+func DecodeDelegatedRecord(data []byte, owner *btcec.PublicKey) (record BlockRecordRaw, err error) {
+	if len(data) < delegatedRecordHeaderSize {
+		return record, errors.New("delegated record invalid size")
+	}
+
+	reader := serialize.NewReader(data)
+	record.DelegateSignature = reader.BytesCopy(65)
+	record.Type = reader.Uint8()
+	record.Date = time.Unix(int64(reader.Uint64()), 0)
+	record.Data = reader.Remaining()
+
+	if !isDelegable(record.Type) {
+		return record, errors.New("record type cannot be delegated")
+	}
+
+	if record.Delegate, _, err = btcec.RecoverCompact(btcec.S256(), record.DelegateSignature, delegatedRecordHash(owner, data[65:])); err != nil {
+		return record, err
+	}
+
+	return record, nil
+}
+
+// verifyDelegatedRecord checks if the delegated record is signed by its delegate for the owner's blockchain.
+func verifyDelegatedRecord(record BlockRecordRaw, owner *btcec.PublicKey) bool {
+	if record.Delegate == nil {
+		return false
+	}
+
+	data, err := EncodeDelegatedRecord(record)
+	if err != nil {
+		return false
+	}
+
+	decoded, err := DecodeDelegatedRecord(data, owner)
+	return err == nil && decoded.Delegate.IsEqual(record.Delegate)
+}
+
+// ownRecords returns the records with delegated ones replaced by an empty placeholder. The index of records is preserved, which is required
+// for file records referencing tag data records.
+func ownRecords(recordsRaw []BlockRecordRaw) (records []BlockRecordRaw) {
+	records = make([]BlockRecordRaw, len(recordsRaw))
+	for n := range recordsRaw {
+		if recordsRaw[n].Delegate != nil {
+			records[n] = BlockRecordRaw{Type: RecordTypeDelegated}
+		} else {
+			records[n] = recordsRaw[n]
+		}
+	}
+
+	return records
+}
@@ -0,0 +1,88 @@
+/*
+File Username:  Block Record Denial.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Denial records are entries of a denial list published by a list maintainer:
+Offset  Size    Info
+0       32      Blake3 hash of the denied content
+32      1       Action: 0 = Add the hash to the list, 1 = Remove the hash from the list
+33      8       Date of the entry, Unix time in seconds
+41      ?       Reason (UTF-8), optional
+
+The list is the result of all entries in the order they were published. The entries are signed as part of the block.
+*/
+
+package blockchain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/PeernetOfficial/core/serialize"
+)
+
+// Actions of denial records
+const (
+	DenialAdd    = 0 // Add the hash to the denial list.
+	DenialRemove = 1 // Remove the hash from the denial list.
+)
+
+// BlockRecordDenial is an entry of a denial list.
+type BlockRecordDenial struct {
+	Hash   []byte    // Blake3 hash of the denied content
+	Action uint8     // Action, see DenialX
+	Date   time.Time // Date of the entry
+	Reason string    // Reason, optional
+}
+
+// decodeBlockRecordDenials decodes only denial records. Other records are ignored.
+func decodeBlockRecordDenials(recordsRaw []BlockRecordRaw) (denials []BlockRecordDenial, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeDenial {
+			continue
+		}
+
+		denial := BlockRecordDenial{}
+		reader := serialize.NewReader(record.Data)
+		denial.Hash = reader.BytesCopy(32)
+		denial.Action = reader.Uint8()
+		denial.Date = time.Unix(int64(reader.Uint64()), 0).UTC()
+		denial.Reason = string(reader.Remaining())
+
+		if reader.Err() != nil {
+			return nil, errors.New("denial record invalid size")
+		}
+
+		denials = append(denials, denial)
+	}
+
+	return denials, nil
+}
+
+// encodeBlockRecordDenial encodes the denial record.
+func encodeBlockRecordDenial(denial BlockRecordDenial) (recordRaw BlockRecordRaw, err error) {
+	if len(denial.Hash) != 32 {
+		return recordRaw, errors.New("invalid hash")
+	} else if denial.Action != DenialAdd && denial.Action != DenialRemove {
+		return recordRaw, errors.New("invalid action")
+	}
+
+	writer := serialize.NewWriter(int(denial.SizeInBlock()))
+	writer.Bytes(denial.Hash)
+	writer.Uint8(denial.Action)
+	writer.Uint64(uint64(denial.Date.Unix()))
+	writer.Bytes([]byte(denial.Reason))
+
+	data, err := writer.Data()
+	if err != nil {
+		return recordRaw, err
+	}
+
+	return BlockRecordRaw{Type: RecordTypeDenial, Data: data}, nil
+}
+
+// SizeInBlock returns the full size this denial record takes up in a single block. (i.e., the record size)
+func (denial *BlockRecordDenial) SizeInBlock() (size uint64) {
+	return blockRecordHeaderSize + 41 + uint64(len(denial.Reason))
+}
@@ -29,26 +29,27 @@ This is a basic embedded way of compression when tags are repetitive in multiple
 package blockchain
 
 import (
-	"encoding/binary"
 	"errors"
 	"math"
 
 	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/serialize"
 	"github.com/google/uuid"
 )
 
 // BlockRecordFile is the metadata of a file published on the blockchain
 type BlockRecordFile struct {
-	Hash           []byte               // Hash of the file data
-	ID             uuid.UUID            // ID of the file
-	MerkleRootHash []byte               // Merkle Root Hash
-	FragmentSize   uint64               // Fragment Size
-	Type           uint8                // File Type
-	Format         uint16               // File Format
-	Size           uint64               // Size of the file data
-	NodeID         []byte               // Node ID, owner of the file
-	Tags           []BlockRecordFileTag // Tags provide additional metadata
-	Username       string               // Username of the User who uploaded the file
+	Hash             []byte               // Hash of the file data
+	ID               uuid.UUID            // ID of the file
+	MerkleRootHash   []byte               // Merkle Root Hash
+	FragmentSize     uint64               // Fragment Size
+	Type             uint8                // File Type
+	Format           uint16               // File Format
+	Size             uint64               // Size of the file data
+	NodeID           []byte               // Node ID, owner of the file
+	Tags             []BlockRecordFileTag // Tags provide additional metadata
+	Username         string               // Username of the User who uploaded the file
+	UsernameConflict bool                 // Whether other peers claim the same username. Set by the client, not encoded.
 }
 
 // BlockRecordFileTag provides metadata about the file.
@@ -72,47 +73,38 @@ func decodeBlockRecordFiles(recordsRaw []BlockRecordRaw, nodeID []byte) (files [
 			}
 
 			file := BlockRecordFile{NodeID: nodeID}
-			file.Hash = make([]byte, protocol.HashSize)
-			copy(file.Hash, record.Data[0:0+protocol.HashSize])
-			copy(file.ID[:], record.Data[32:32+16])
+			reader := serialize.NewReader(record.Data)
 
-			file.MerkleRootHash = make([]byte, protocol.HashSize)
-			copy(file.MerkleRootHash, record.Data[48:48+protocol.HashSize])
-			file.FragmentSize = binary.LittleEndian.Uint64(record.Data[80 : 80+8])
+			file.Hash = reader.BytesCopy(protocol.HashSize)
+			reader.Fixed(file.ID[:])
+			file.MerkleRootHash = reader.BytesCopy(protocol.HashSize)
+			file.FragmentSize = reader.Uint64()
+			file.Type = reader.Uint8()
+			file.Format = reader.Uint16()
+			file.Size = reader.Uint64()
 
-			file.Type = record.Data[88]
-			file.Format = binary.LittleEndian.Uint16(record.Data[89 : 89+2])
-			file.Size = binary.LittleEndian.Uint64(record.Data[91 : 91+8])
-
-			countTags := binary.LittleEndian.Uint16(record.Data[99 : 99+2])
-
-			index := blockRecordFileMinSize
+			countTags := reader.Uint16()
 
 			for n := uint16(0); n < countTags; n++ {
-				if index+6 > len(record.Data) {
+				tagType := reader.Uint16()
+				tagSize := reader.Uint32()
+				if reader.Err() != nil {
 					return nil, errors.New("file record tags invalid size")
 				}
 
-				tag := BlockRecordFileTag{}
-				tag.Type = binary.LittleEndian.Uint16(record.Data[index:index+2]) & 0x7FFF
-				tagSize := binary.LittleEndian.Uint32(record.Data[index+2 : index+2+4])
-				isDataReference := record.Data[index+1]&0x80 != 0
-
-				if index+6+int(tagSize) > len(record.Data) {
+				tagData := reader.Bytes(int(tagSize))
+				if reader.Err() != nil {
 					return nil, errors.New("file record tag data invalid size")
 				}
 
-				if isDataReference { // reference to RecordTypeTagData record?
-					var refRecordNumber int
-					if tagSize == 2 {
-						refRecordNumber = i + int(int16(binary.LittleEndian.Uint16(record.Data[index+6:index+6+2])))
-					} else if tagSize == 4 {
-						refRecordNumber = i + int(int32(binary.LittleEndian.Uint32(record.Data[index+6:index+6+4])))
-					} else if tagSize == 8 {
-						refRecordNumber = i + int(int64(binary.LittleEndian.Uint64(record.Data[index+6:index+6+8])))
-					} else {
+				tag := BlockRecordFileTag{Type: tagType & 0x7FFF, Data: tagData}
+
+				if tagType&0x8000 != 0 { // reference to RecordTypeTagData record?
+					refRecordNumber, valid := bytesToInt(tagData)
+					if !valid {
 						return nil, errors.New("file record tag reference invalid size")
 					}
+					refRecordNumber += i
 
 					if refRecordNumber < 0 || refRecordNumber >= len(recordsRaw) {
 						return nil, errors.New("file record tag reference not available")
@@ -121,14 +113,9 @@ func decodeBlockRecordFiles(recordsRaw []BlockRecordRaw, nodeID []byte) (files [
 					}
 
 					tag.Data = recordsRaw[refRecordNumber].Data
-
-				} else {
-					tag.Data = record.Data[index+6 : index+6+int(tagSize)]
 				}
 
 				file.Tags = append(file.Tags, tag)
-
-				index += 6 + int(tagSize)
 			}
 
 			file.Tags = append(file.Tags, TagFromDate(TagDateShared, record.Date))
@@ -140,6 +127,18 @@ func decodeBlockRecordFiles(recordsRaw []BlockRecordRaw, nodeID []byte) (files [
 	return files, err
 }
 
+// EncodeFileRecord encodes a single file as self-contained record that does not reference tag data records. This is used for delegated records.
+func EncodeFileRecord(file BlockRecordFile) (recordRaw BlockRecordRaw, err error) {
+	recordsRaw, err := encodeBlockRecordFiles([]BlockRecordFile{file})
+	if err != nil {
+		return recordRaw, err
+	} else if len(recordsRaw) != 1 {
+		return recordRaw, errors.New("file record references tag data")
+	}
+
+	return recordsRaw[0], nil
+}
+
 // encodeBlockRecordFiles encodes files into the block record data
 // This function should be called grouped with all files in the same folder. The folder name is deduplicated; only unique folder records will be returned.
 // Note that this function only stores the folder names as tags; it does not create separate TypeFolder file records.
@@ -163,24 +162,23 @@ func encodeBlockRecordFiles(files []BlockRecordFile) (recordsRaw []BlockRecordRa
 
 	// then encode all files as records
 	for n := range files {
-		data := make([]byte, blockRecordFileMinSize)
-
 		if len(files[n].Hash) != protocol.HashSize {
 			return nil, errors.New("encodeBlockRecords invalid file hash")
 		} else if len(files[n].MerkleRootHash) != protocol.HashSize {
 			return nil, errors.New("encodeBlockRecords invalid merkle root hash")
 		}
 
-		copy(data[0:32], files[n].Hash[0:32])
-		copy(data[32:32+16], files[n].ID[:])
-		copy(data[48:48+32], files[n].MerkleRootHash[0:32])
-		binary.LittleEndian.PutUint64(data[80:80+8], files[n].FragmentSize)
-
-		data[88] = files[n].Type
-		binary.LittleEndian.PutUint16(data[89:89+2], files[n].Format)
-		binary.LittleEndian.PutUint64(data[91:91+8], files[n].Size)
+		writer := serialize.NewWriter(int(files[n].SizeInBlock()))
+		writer.Bytes(files[n].Hash)
+		writer.Bytes(files[n].ID[:])
+		writer.Bytes(files[n].MerkleRootHash)
+		writer.Uint64(files[n].FragmentSize)
+		writer.Uint8(files[n].Type)
+		writer.Uint16(files[n].Format)
+		writer.Uint64(files[n].Size)
 
-		var tagCount uint16
+		countOffset := writer.Reserve(2)
+		tagCount := 0
 
 		for _, tag := range files[n].Tags {
 			// Some tags are virtual and never stored on the blockchain. If attempted to write, ignore.
@@ -197,17 +195,18 @@ func encodeBlockRecordFiles(files []BlockRecordFile) (recordsRaw []BlockRecordRa
 				}
 			}
 
-			var tempTag [6]byte
+			writer.Uint16(tag.Type)
+			writer.Count32(len(tag.Data))
+			writer.Bytes(tag.Data)
+		}
 
-			binary.LittleEndian.PutUint16(tempTag[0:2], tag.Type)
-			binary.LittleEndian.PutUint32(tempTag[2:2+4], uint32(len(tag.Data)))
+		writer.PutCount16At(countOffset, tagCount)
 
-			data = append(data, tempTag[:]...)
-			data = append(data, tag.Data...)
+		data, err := writer.Data()
+		if err != nil {
+			return nil, errors.New("encodeBlockRecords: " + err.Error())
 		}
 
-		binary.LittleEndian.PutUint16(data[99:99+2], tagCount)
-
 		recordsRaw = append(recordsRaw, BlockRecordRaw{Type: RecordTypeFile, Data: data})
 	}
 
@@ -216,18 +215,34 @@ func encodeBlockRecordFiles(files []BlockRecordFile) (recordsRaw []BlockRecordRa
 
 // intToBytes encodes int to little endian byte array as it fits to 16, 32 or 64 bit.
 func intToBytes(number int) (buffer []byte) {
-	buffer = make([]byte, 4)
+	writer := serialize.NewWriter(8)
 
 	if number <= math.MaxInt16 && number >= math.MinInt16 {
-		binary.LittleEndian.PutUint16(buffer[0:2], uint16(number))
-		return buffer[0:2]
+		writer.Uint16(uint16(number))
 	} else if number <= math.MaxInt32 && number >= math.MinInt32 {
-		binary.LittleEndian.PutUint32(buffer[0:4], uint32(number))
-		return buffer[0:4]
+		writer.Uint32(uint32(number))
+	} else {
+		writer.Uint64(uint64(number))
+	}
+
+	buffer, _ = writer.Data()
+	return buffer
+}
+
+// bytesToInt decodes a little endian signed 16, 32 or 64 bit number encoded by intToBytes.
+func bytesToInt(buffer []byte) (number int, valid bool) {
+	reader := serialize.NewReader(buffer)
+
+	switch len(buffer) {
+	case 2:
+		return int(int16(reader.Uint16())), true
+	case 4:
+		return int(int32(reader.Uint32())), true
+	case 8:
+		return int(int64(reader.Uint64())), true
 	}
 
-	binary.LittleEndian.PutUint64(buffer[0:8], uint64(number))
-	return buffer[0:8]
+	return 0, false
 }
 
 // SizeInBlock returns the full size this file takes up in a single block. (i.e., the record size)
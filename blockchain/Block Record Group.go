@@ -0,0 +1,111 @@
+/*
+File Username:  Block Record Group.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Group records define the membership of group channels owned by the blockchain owner:
+Offset  Size    Info
+0       16      Group ID
+16      2       Count of members
+18      33 * n  Public keys of the members (compressed)
+?       ?       Name of the group (UTF-8)
+
+The owner is implicitly a member. If there are multiple records with the same group ID, only the latest one is valid.
+*/
+
+package blockchain
+
+import (
+	"errors"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/serialize"
+	"github.com/google/uuid"
+)
+
+// GroupMaxMembers is the max count of members in a group, excluding the owner.
+const GroupMaxMembers = 64
+
+// BlockRecordGroup defines a group channel and its members.
+type BlockRecordGroup struct {
+	ID      uuid.UUID          // Group ID
+	Name    string             // Name of the group
+	Members []*btcec.PublicKey // Members. The owner is implicitly a member.
+}
+
+// decodeBlockRecordGroups decodes only group records. Other records are ignored.
+func decodeBlockRecordGroups(recordsRaw []BlockRecordRaw) (groups []BlockRecordGroup, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeGroup {
+			continue
+		}
+
+		group := BlockRecordGroup{}
+		reader := serialize.NewReader(record.Data)
+		reader.Fixed(group.ID[:])
+
+		countMembers := int(reader.Uint16())
+		for n := 0; n < countMembers && reader.Err() == nil; n++ {
+			memberB := reader.Bytes(33)
+			if reader.Err() != nil {
+				break
+			}
+
+			member, err := btcec.ParsePubKey(memberB, btcec.S256())
+			if err != nil {
+				return nil, err
+			}
+			group.Members = append(group.Members, member)
+		}
+
+		group.Name = string(reader.Remaining())
+
+		if reader.Err() != nil {
+			return nil, errors.New("group record invalid size")
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// encodeBlockRecordGroup encodes the group record.
+func encodeBlockRecordGroup(group BlockRecordGroup) (recordRaw BlockRecordRaw, err error) {
+	if len(group.Members) > GroupMaxMembers {
+		return recordRaw, errors.New("exceeding max count of members")
+	}
+
+	writer := serialize.NewWriter(int(group.SizeInBlock()))
+	writer.Bytes(group.ID[:])
+	writer.Count16(len(group.Members))
+
+	for _, member := range group.Members {
+		writer.Bytes(member.SerializeCompressed())
+	}
+
+	writer.Bytes([]byte(group.Name))
+
+	data, err := writer.Data()
+	if err != nil {
+		return recordRaw, err
+	}
+
+	return BlockRecordRaw{Type: RecordTypeGroup, Data: data}, nil
+}
+
+// SizeInBlock returns the full size this group takes up in a single block. (i.e., the record size)
+func (group *BlockRecordGroup) SizeInBlock() (size uint64) {
+	return blockRecordHeaderSize + 18 + uint64(len(group.Members))*33 + uint64(len(group.Name))
+}
+
+// IsMember checks if the public key is a member of the group. The owner is not listed as member.
+func (group *BlockRecordGroup) IsMember(publicKey *btcec.PublicKey) bool {
+	for _, member := range group.Members {
+		if member.IsEqual(publicKey) {
+			return true
+		}
+	}
+
+	return false
+}
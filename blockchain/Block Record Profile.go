@@ -8,14 +8,24 @@ Offset  Size    Info
 0       2       Type
 2       ?       Data according to the type
 
+Profile update records change a single field without rewriting existing blocks:
+Offset  Size    Info
+0       1       Action: 0 = Set (add or replace), 1 = Delete
+1       2       Type
+3       ?       Data according to the type. Only for Set.
+
+The profile is the result of applying all profile and profile update records in the order of the blockchain. A profile record is equivalent to a Set update.
+Update records with unknown actions are ignored, so future versions can introduce new actions without breaking older clients.
+
 */
 
 package blockchain
 
 import (
-	"encoding/binary"
 	"errors"
 	"math"
+
+	"github.com/PeernetOfficial/core/serialize"
 )
 
 // BlockRecordProfile provides information about the end user.
@@ -24,21 +34,26 @@ type BlockRecordProfile struct {
 	Data []byte // Data
 }
 
-// DecodeBlockRecordProfile decodes only profile records. Other records are ignored.
-func DecodeBlockRecordProfile(recordsRaw []BlockRecordRaw) (fields []BlockRecordProfile, err error) {
-	fieldMap := make(map[uint16][]byte)
+// BlockRecordProfileUpdate is a field-level update of the profile.
+type BlockRecordProfileUpdate struct {
+	Action uint8  // See ProfileUpdateX constants.
+	Type   uint16 // See ProfileX constants.
+	Data   []byte // Data. Only for ProfileUpdateSet.
+}
 
-	for _, record := range recordsRaw {
-		if record.Type != RecordTypeProfile {
-			continue
-		}
+// Actions of profile update records
+const (
+	ProfileUpdateSet    = 0 // Add or replace the field.
+	ProfileUpdateDelete = 1 // Delete the field.
+)
 
-		if len(record.Data) < 2 {
-			return nil, errors.New("profile record invalid size")
-		}
+// DecodeBlockRecordProfile decodes the profile fields set in the block. Profile update records within the block are applied. Other records are ignored.
+// Use ProfileMerge to apply the records of multiple blocks in order.
+func DecodeBlockRecordProfile(recordsRaw []BlockRecordRaw) (fields []BlockRecordProfile, err error) {
+	fieldMap := make(map[uint16][]byte)
 
-		fieldType := binary.LittleEndian.Uint16(record.Data[0:2])
-		fieldMap[fieldType] = record.Data[2:]
+	if err = ProfileMerge(fieldMap, recordsRaw); err != nil {
+		return nil, err
 	}
 
 	for fieldType, fieldData := range fieldMap {
@@ -48,6 +63,53 @@ func DecodeBlockRecordProfile(recordsRaw []BlockRecordRaw) (fields []BlockRecord
 	return fields, nil
 }
 
+// ProfileMerge applies the profile and profile update records to the fields, in order. Other records are ignored.
+func ProfileMerge(fields map[uint16][]byte, recordsRaw []BlockRecordRaw) (err error) {
+	for _, record := range recordsRaw {
+		switch record.Type {
+		case RecordTypeProfile:
+			reader := serialize.NewReader(record.Data)
+			fieldType := reader.Uint16()
+			fieldData := reader.Remaining()
+
+			if reader.Err() != nil {
+				return errors.New("profile record invalid size")
+			}
+
+			fields[fieldType] = fieldData
+
+		case RecordTypeProfileUpdate:
+			update, err := decodeBlockRecordProfileUpdate(record)
+			if err != nil {
+				return err
+			}
+
+			switch update.Action {
+			case ProfileUpdateSet:
+				fields[update.Type] = update.Data
+			case ProfileUpdateDelete:
+				delete(fields, update.Type)
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeBlockRecordProfileUpdate decodes a single profile update record.
+func decodeBlockRecordProfileUpdate(record BlockRecordRaw) (update BlockRecordProfileUpdate, err error) {
+	reader := serialize.NewReader(record.Data)
+	update.Action = reader.Uint8()
+	update.Type = reader.Uint16()
+	update.Data = reader.Remaining()
+
+	if reader.Err() != nil {
+		return update, errors.New("profile update record invalid size")
+	}
+
+	return update, nil
+}
+
 // encodeBlockRecordProfile encodes the profile record.
 func encodeBlockRecordProfile(fields []BlockRecordProfile) (recordsRaw []BlockRecordRaw, err error) {
 	if len(fields) > math.MaxUint16 {
@@ -59,9 +121,14 @@ func encodeBlockRecordProfile(fields []BlockRecordProfile) (recordsRaw []BlockRe
 			return nil, errors.New("exceeding max field size")
 		}
 
-		data := make([]byte, 2)
-		binary.LittleEndian.PutUint16(data[0:2], fields[n].Type)
-		data = append(data, fields[n].Data...)
+		writer := serialize.NewWriter(2 + len(fields[n].Data))
+		writer.Uint16(fields[n].Type)
+		writer.Bytes(fields[n].Data)
+
+		data, err := writer.Data()
+		if err != nil {
+			return nil, err
+		}
 
 		recordsRaw = append(recordsRaw, BlockRecordRaw{Type: RecordTypeProfile, Data: data})
 	}
@@ -73,3 +140,27 @@ func encodeBlockRecordProfile(fields []BlockRecordProfile) (recordsRaw []BlockRe
 func (field *BlockRecordProfile) SizeInBlock() (size uint64) {
 	return blockRecordHeaderSize + 2 + uint64(len(field.Data))
 }
+
+// encodeBlockRecordProfileUpdate encodes the profile update record.
+func encodeBlockRecordProfileUpdate(update BlockRecordProfileUpdate) (recordRaw BlockRecordRaw, err error) {
+	if update.Action == ProfileUpdateDelete && len(update.Data) > 0 {
+		return recordRaw, errors.New("profile delete update with data")
+	}
+
+	writer := serialize.NewWriter(3 + len(update.Data))
+	writer.Uint8(update.Action)
+	writer.Uint16(update.Type)
+	writer.Bytes(update.Data)
+
+	data, err := writer.Data()
+	if err != nil {
+		return recordRaw, err
+	}
+
+	return BlockRecordRaw{Type: RecordTypeProfileUpdate, Data: data}, nil
+}
+
+// SizeInBlock returns the full size this update takes up in a single block. (i.e., the record size)
+func (update *BlockRecordProfileUpdate) SizeInBlock() (size uint64) {
+	return blockRecordHeaderSize + 3 + uint64(len(update.Data))
+}
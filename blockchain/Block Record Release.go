@@ -0,0 +1,97 @@
+/*
+File Username:  Block Record Release.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Release records are manifests of software releases published by an update publisher:
+Offset  Size    Info
+0       32      Blake3 hash of the release file
+32      8       Size of the release file
+40      8       Date of the release, Unix time in seconds
+48      1       Length of the version
+49      1       Length of the platform
+50      ?       Version, for example "1.2.0"
+?       ?       Platform as GOOS/GOARCH, for example "windows/amd64". Empty for any platform.
+?       ?       Release notes (UTF-8)
+
+The manifest is signed as part of the block. The release file is shared via the publisher's warehouse and verified by its hash.
+*/
+
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/PeernetOfficial/core/serialize"
+)
+
+// BlockRecordRelease is a manifest of a software release.
+type BlockRecordRelease struct {
+	Hash     []byte    // Blake3 hash of the release file
+	Size     uint64    // Size of the release file
+	Date     time.Time // Date of the release
+	Version  string    // Version, for example "1.2.0"
+	Platform string    // Platform as GOOS/GOARCH. Empty for any platform.
+	Notes    string    // Release notes
+}
+
+// decodeBlockRecordReleases decodes only release records. Other records are ignored.
+func decodeBlockRecordReleases(recordsRaw []BlockRecordRaw) (releases []BlockRecordRelease, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeRelease {
+			continue
+		}
+
+		release := BlockRecordRelease{}
+		reader := serialize.NewReader(record.Data)
+		release.Hash = reader.BytesCopy(32)
+		release.Size = reader.Uint64()
+		release.Date = time.Unix(int64(reader.Uint64()), 0).UTC()
+		versionLength := int(reader.Uint8())
+		platformLength := int(reader.Uint8())
+		release.Version = string(reader.Bytes(versionLength))
+		release.Platform = string(reader.Bytes(platformLength))
+		release.Notes = string(reader.Remaining())
+
+		if reader.Err() != nil {
+			return nil, errors.New("release record invalid size")
+		}
+
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
+
+// encodeBlockRecordRelease encodes the release record.
+func encodeBlockRecordRelease(release BlockRecordRelease) (recordRaw BlockRecordRaw, err error) {
+	if len(release.Hash) != 32 {
+		return recordRaw, errors.New("invalid hash")
+	} else if len(release.Version) == 0 || len(release.Version) > math.MaxUint8 || len(release.Platform) > math.MaxUint8 {
+		return recordRaw, errors.New("invalid version or platform length")
+	}
+
+	writer := serialize.NewWriter(int(release.SizeInBlock()))
+	writer.Bytes(release.Hash)
+	writer.Uint64(release.Size)
+	writer.Uint64(uint64(release.Date.Unix()))
+	writer.Uint8(uint8(len(release.Version)))
+	writer.Uint8(uint8(len(release.Platform)))
+	writer.Bytes([]byte(release.Version))
+	writer.Bytes([]byte(release.Platform))
+	writer.Bytes([]byte(release.Notes))
+
+	data, err := writer.Data()
+	if err != nil {
+		return recordRaw, err
+	}
+
+	return BlockRecordRaw{Type: RecordTypeRelease, Data: data}, nil
+}
+
+// SizeInBlock returns the full size this release takes up in a single block. (i.e., the record size)
+func (release *BlockRecordRelease) SizeInBlock() (size uint64) {
+	return blockRecordHeaderSize + 50 + uint64(len(release.Version)+len(release.Platform)+len(release.Notes))
+}
@@ -0,0 +1,236 @@
+/*
+File Username:  Block Record Sub Key.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Sub-key records authorize a secondary key (for example of a mobile device) to sign entire blocks on behalf of the owner until the expiry:
+Offset  Size    Info
+0       33      Public key of the owner (compressed)
+33      33      Public key of the sub-key (compressed)
+66      8       Expiry, Unix time in seconds
+74      8       Max block number (exclusive) the sub-key may sign
+82      65      Signature by the owner of the previous fields
+
+The owner issues the record via SubKeySign and publishes it on the blockchain via SubKeyAdd. A block signed by the sub-key carries a copy of
+the record as its first record. When decoding the block, the signature of the record is verified against the owner, which makes the block
+verifiable on its own. Expired sub-keys cannot sign new blocks.
+
+The dates of records are chosen by the signer, therefore receivers cannot use them to check the expiry: A leaked sub-key could backdate its
+blocks. Instead, blocks signed by a sub-key are invalid if their block number is not below the max block number set by the owner.
+
+Sub-key revocation records invalidate blocks signed by the sub-key starting at the given block number. They are only valid in blocks signed by the owner:
+Offset  Size    Info
+0       33      Public key of the sub-key (compressed)
+33      8       First block number that the sub-key may no longer sign
+
+Since blocks signed by a sub-key are verified on their own, revocations are enforced when blocks are ingested into the multi-blockchain store.
+*/
+
+package blockchain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/serialize"
+)
+
+// subKeyRecordSize is the size of the sub-key record data.
+const subKeyRecordSize = 147
+
+// subKeyRevokeRecordSize is the size of the sub-key revocation record data.
+const subKeyRevokeRecordSize = 41
+
+// BlockRecordSubKey authorizes a sub-key to sign blocks on behalf of the owner.
+type BlockRecordSubKey struct {
+	Owner     *btcec.PublicKey // Public key of the owner
+	SubKey    *btcec.PublicKey // Public key of the sub-key
+	Expiry    time.Time        // Expiry of the sub-key
+	MaxBlock  uint64           // Blocks signed by the sub-key must have a lower block number.
+	Signature []byte           // Signature by the owner
+}
+
+// BlockRecordSubKeyRevoke revokes a sub-key. Blocks signed by the sub-key starting at the block number are invalid.
+type BlockRecordSubKeyRevoke struct {
+	SubKey *btcec.PublicKey // Public key of the revoked sub-key
+	Block  uint64           // First block number that the sub-key may no longer sign
+}
+
+// IsActive checks if the sub-key is not expired.
+func (subKey *BlockRecordSubKey) IsActive() bool {
+	return time.Now().Before(subKey.Expiry)
+}
+
+// canSign checks if the sub-key may sign the block with the given number.
+func (subKey *BlockRecordSubKey) canSign(number uint64) bool {
+	return subKey.IsActive() && number < subKey.MaxBlock
+}
+
+// SubKeySign issues a sub-key record signed by the owner. The sub-key may sign blocks on behalf of the owner until the expiry, and only blocks
+// with a block number lower than maxBlock.
+func SubKeySign(ownerKey *btcec.PrivateKey, subKey *btcec.PublicKey, expiry time.Time, maxBlock uint64) (record BlockRecordSubKey, err error) {
+	if subKey == nil || subKey.IsEqual(ownerKey.PubKey()) {
+		return record, errors.New("invalid sub-key")
+	} else if maxBlock == 0 {
+		return record, errors.New("invalid max block number")
+	}
+
+	record = BlockRecordSubKey{Owner: ownerKey.PubKey(), SubKey: subKey, Expiry: time.Unix(expiry.Unix(), 0).UTC(), MaxBlock: maxBlock}
+
+	if record.Signature, err = btcec.SignCompact(btcec.S256(), ownerKey, subKeyHash(record), true); err != nil {
+		return record, err
+	}
+
+	return record, nil
+}
+
+// subKeyHash returns the hash signed by the owner.
+func subKeyHash(record BlockRecordSubKey) []byte {
+	writer := serialize.NewWriter(82)
+	writer.Bytes(record.Owner.SerializeCompressed())
+	writer.Bytes(record.SubKey.SerializeCompressed())
+	writer.Uint64(uint64(record.Expiry.Unix()))
+	writer.Uint64(record.MaxBlock)
+
+	data, _ := writer.Data()
+	return protocol.HashData(data)
+}
+
+// Verify checks if the sub-key record is signed by the owner.
+func (subKey *BlockRecordSubKey) Verify() (err error) {
+	if subKey.Owner == nil || subKey.SubKey == nil || len(subKey.Signature) != 65 {
+		return errors.New("invalid sub-key record")
+	}
+
+	signer, _, err := btcec.RecoverCompact(btcec.S256(), subKey.Signature, subKeyHash(*subKey))
+	if err != nil {
+		return err
+	} else if !signer.IsEqual(subKey.Owner) {
+		return errors.New("sub-key record not signed by the owner")
+	}
+
+	return nil
+}
+
+// decodeBlockRecordSubKeys decodes only sub-key records. Other records are ignored.
+func decodeBlockRecordSubKeys(recordsRaw []BlockRecordRaw) (subKeys []BlockRecordSubKey, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeSubKey {
+			continue
+		}
+
+		if len(record.Data) != subKeyRecordSize {
+			return nil, errors.New("sub-key record invalid size")
+		}
+
+		subKey := BlockRecordSubKey{}
+		reader := serialize.NewReader(record.Data)
+		ownerB := reader.Bytes(33)
+		subKeyB := reader.Bytes(33)
+		subKey.Expiry = time.Unix(int64(reader.Uint64()), 0).UTC()
+		subKey.MaxBlock = reader.Uint64()
+		subKey.Signature = reader.BytesCopy(65)
+
+		if subKey.Owner, err = btcec.ParsePubKey(ownerB, btcec.S256()); err != nil {
+			return nil, err
+		} else if subKey.SubKey, err = btcec.ParsePubKey(subKeyB, btcec.S256()); err != nil {
+			return nil, err
+		}
+
+		subKeys = append(subKeys, subKey)
+	}
+
+	return subKeys, nil
+}
+
+// encodeBlockRecordSubKey encodes the sub-key record.
+func encodeBlockRecordSubKey(subKey BlockRecordSubKey) (recordRaw BlockRecordRaw, err error) {
+	if subKey.Owner == nil || subKey.SubKey == nil || len(subKey.Signature) != 65 {
+		return recordRaw, errors.New("invalid sub-key record")
+	}
+
+	writer := serialize.NewWriter(subKeyRecordSize)
+	writer.Bytes(subKey.Owner.SerializeCompressed())
+	writer.Bytes(subKey.SubKey.SerializeCompressed())
+	writer.Uint64(uint64(subKey.Expiry.Unix()))
+	writer.Uint64(subKey.MaxBlock)
+	writer.Bytes(subKey.Signature)
+
+	data, err := writer.Data()
+	if err != nil {
+		return recordRaw, err
+	}
+
+	return BlockRecordRaw{Type: RecordTypeSubKey, Date: subKey.Expiry, Data: data}, nil
+}
+
+// blockSubKey returns the sub-key record and the owner if the block is signed by the sub-key. It must be the first record.
+// Found is false if the block is signed by the owner. The block number must be lower than the max block number of the sub-key.
+// The expiry is not checked, since blocks signed before the expiry remain valid.
+func blockSubKey(recordsRaw []BlockRecordRaw, number uint64, signer *btcec.PublicKey) (subKey *BlockRecordSubKey, owner *btcec.PublicKey, found bool, err error) {
+	if len(recordsRaw) == 0 || recordsRaw[0].Type != RecordTypeSubKey {
+		return nil, nil, false, nil
+	}
+
+	subKeys, err := decodeBlockRecordSubKeys(recordsRaw[:1])
+	if err != nil || len(subKeys) != 1 || !subKeys[0].SubKey.IsEqual(signer) {
+		// A sub-key record published by the owner may be the first record of a block signed by the owner.
+		return nil, nil, false, nil
+	}
+
+	if err = subKeys[0].Verify(); err != nil {
+		return nil, nil, true, err
+	}
+
+	if number >= subKeys[0].MaxBlock {
+		return nil, nil, true, errors.New("block number exceeds the limit of the sub-key")
+	}
+
+	return &subKeys[0], subKeys[0].Owner, true, nil
+}
+
+// decodeBlockRecordSubKeyRevokes decodes only sub-key revocation records. Other records are ignored. Revocations created by delegates are ignored.
+func decodeBlockRecordSubKeyRevokes(recordsRaw []BlockRecordRaw) (revokes []BlockRecordSubKeyRevoke, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeSubKeyRevoke || record.Delegate != nil {
+			continue
+		}
+
+		if len(record.Data) != subKeyRevokeRecordSize {
+			return nil, errors.New("sub-key revocation record invalid size")
+		}
+
+		revoke := BlockRecordSubKeyRevoke{}
+		reader := serialize.NewReader(record.Data)
+		subKeyB := reader.Bytes(33)
+		revoke.Block = reader.Uint64()
+
+		if revoke.SubKey, err = btcec.ParsePubKey(subKeyB, btcec.S256()); err != nil {
+			return nil, err
+		}
+
+		revokes = append(revokes, revoke)
+	}
+
+	return revokes, nil
+}
+
+// encodeBlockRecordSubKeyRevoke encodes the sub-key revocation record.
+func encodeBlockRecordSubKeyRevoke(revoke BlockRecordSubKeyRevoke) (recordRaw BlockRecordRaw, err error) {
+	if revoke.SubKey == nil {
+		return recordRaw, errors.New("invalid sub-key revocation record")
+	}
+
+	writer := serialize.NewWriter(subKeyRevokeRecordSize)
+	writer.Bytes(revoke.SubKey.SerializeCompressed())
+	writer.Uint64(revoke.Block)
+
+	data, err := writer.Data()
+	if err != nil {
+		return recordRaw, err
+	}
+
+	return BlockRecordRaw{Type: RecordTypeSubKeyRevoke, Data: data}, nil
+}
@@ -16,13 +16,23 @@ package blockchain
 
 // RecordTypeX defines the type of the record
 const (
-	RecordTypeProfile       = 0 // Profile data about the end user.
-	RecordTypeTagData       = 1 // Tag data record to be referenced by one or multiple tags. Only valid in the context of the current block.
-	RecordTypeFile          = 2 // File
-	RecordTypeInvalid1      = 3 // Do not use.
-	RecordTypeCertificate   = 4 // Certificate to certify provided information in the blockchain issued by a trusted 3rd party.
-	RecordTypeContentRating = 5 // Content rating (positive).
-	RecordTypeContentReport = 6 // Content report (negative).
+	RecordTypeProfile       = 0  // Profile data about the end user.
+	RecordTypeTagData       = 1  // Tag data record to be referenced by one or multiple tags. Only valid in the context of the current block.
+	RecordTypeFile          = 2  // File
+	RecordTypeInvalid1      = 3  // Do not use.
+	RecordTypeCertificate   = 4  // Certificate to certify provided information in the blockchain issued by a trusted 3rd party.
+	RecordTypeContentRating = 5  // Content rating (positive).
+	RecordTypeContentReport = 6  // Content report (negative).
+	RecordTypeGroup         = 7  // Group channel and its members.
+	RecordTypeProfileUpdate = 8  // Field-level update of the profile.
+	RecordTypeRelease       = 9  // Manifest of a software release.
+	RecordTypeDenial        = 10 // Entry of a denial list: hash of content denied by the list maintainer.
+	RecordTypeDelegation    = 11 // Authorization of a delegate key to create records on behalf of the owner.
+	RecordTypeDelegated     = 12 // Record created by a delegate. Unwrapped when decoding, see DelegatedRecordSign.
+	RecordTypeSubKey        = 13 // Authorization of a sub-key to sign blocks on behalf of the owner.
+	RecordTypeSubKeyRevoke  = 14 // Revocation of a sub-key. Only valid in blocks signed by the owner.
+
+	// Types starting at RecordTypeCustomFirst are custom record types registered via RegisterRecordType.
 )
 
 // BlockDecoded contains the decoded records from a block
@@ -51,5 +61,66 @@ func decodeBlockRecords(block *Block) (decoded *BlockDecoded, err error) {
 		decoded.RecordsDecoded = append(decoded.RecordsDecoded, profileFields)
 	}
 
+	groups, err := decodeBlockRecordGroups(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, group)
+	}
+
+	releases, err := decodeBlockRecordReleases(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, release := range releases {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, release)
+	}
+
+	denials, err := decodeBlockRecordDenials(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, denial := range denials {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, denial)
+	}
+
+	delegations, err := decodeBlockRecordDelegations(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, delegation := range delegations {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, delegation)
+	}
+
+	subKeys, err := decodeBlockRecordSubKeys(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, subKey := range subKeys {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, subKey)
+	}
+
+	// Revocations in blocks signed by a sub-key are not authorized by the owner.
+	if block.SubKey == nil {
+		revokes, err := decodeBlockRecordSubKeyRevokes(block.RecordsRaw)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, revoke := range revokes {
+			decoded.RecordsDecoded = append(decoded.RecordsDecoded, revoke)
+		}
+	}
+
+	for _, record := range decodeBlockRecordCustom(block.RecordsRaw) {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, record)
+	}
+
 	return decoded, nil
 }
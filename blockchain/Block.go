@@ -12,18 +12,19 @@ Offset  Size   Info
 113     4      Size of entire block including this header
 117     2      Count of records that follow
 
+Blocks may be signed by a sub-key instead of the owner. In that case the first record is the sub-key record issued by the owner, see SubKeySign.
+
 */
 
 package blockchain
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"time"
 
 	"github.com/PeernetOfficial/core/btcec"
 	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/serialize"
 )
 
 // Block is a single block containing a set of records (metadata).
@@ -35,6 +36,9 @@ type Block struct {
 	BlockchainVersion uint64           // Blockchain version
 	Number            uint64           // Block number
 	RecordsRaw        []BlockRecordRaw // Block records raw
+
+	// Blocks signed by a sub-key carry the sub-key record. It is not part of RecordsRaw.
+	SubKey *BlockRecordSubKey // Sub-key that signed the block. Nil if signed by the owner.
 }
 
 // BlockRecordRaw is a single block record (not decoded)
@@ -42,6 +46,18 @@ type BlockRecordRaw struct {
 	Type uint8     // Record Type. See RecordTypeX.
 	Date time.Time // Date created. This remains the same in case of block refactoring.
 	Data []byte    // Data according to the type
+
+	// Records created by a delegate on behalf of the owner are stored as RecordTypeDelegated and unwrapped when decoding. See DelegatedRecordSign.
+	Delegate          *btcec.PublicKey // Delegate that created the record. Nil if created by the owner.
+	DelegateSignature []byte           // Signature of the delegate. Nil if created by the owner.
+}
+
+// SizeInBlock returns the full size this record takes up in a single block.
+func (record *BlockRecordRaw) SizeInBlock() (size uint64) {
+	if record.DelegateSignature != nil {
+		return blockRecordHeaderSize + delegatedRecordHeaderSize + uint64(len(record.Data))
+	}
+	return blockRecordHeaderSize + uint64(len(record.Data))
 }
 
 const blockHeaderSize = 119
@@ -54,105 +70,128 @@ func decodeBlock(raw []byte) (block *Block, err error) {
 	}
 
 	block = &Block{}
+	reader := serialize.NewReader(raw)
 
-	signature := raw[0 : 0+65]
+	signature := reader.Bytes(65)
 
-	block.OwnerPublicKey, _, err = btcec.RecoverCompact(btcec.S256(), signature, protocol.HashData(raw[65:]))
+	signer, _, err := btcec.RecoverCompact(btcec.S256(), signature, protocol.HashData(raw[65:]))
 	if err != nil {
 		return nil, err
 	}
 
-	block.NodeID = protocol.PublicKey2NodeID(block.OwnerPublicKey)
-
-	block.LastBlockHash = make([]byte, protocol.HashSize)
-	copy(block.LastBlockHash, raw[65:65+protocol.HashSize])
-
-	block.BlockchainVersion = binary.LittleEndian.Uint64(raw[97 : 97+8])
-	block.Number = binary.LittleEndian.Uint64(raw[105 : 105+8])
+	block.LastBlockHash = reader.BytesCopy(protocol.HashSize)
+	block.BlockchainVersion = reader.Uint64()
+	block.Number = reader.Uint64()
 
-	blockSize := binary.LittleEndian.Uint32(raw[113 : 113+4])
-	if blockSize != uint32(len(raw)) {
+	if blockSize := reader.Uint32(); blockSize != uint32(len(raw)) {
 		return nil, errors.New("decodeBlock invalid block size")
 	}
 
 	// decode on a low-level all block records
-	countRecords := binary.LittleEndian.Uint16(raw[117 : 117+2])
-	index := blockHeaderSize
+	countRecords := reader.Uint16()
 
 	for n := uint16(0); n < countRecords; n++ {
-		if index+blockRecordHeaderSize > len(raw) {
+		recordType := reader.Uint8()
+		recordDate := int64(reader.Uint64()) // Unix time int64, the number of seconds elapsed since January 1, 1970 UTC
+		recordSize := reader.Uint32()
+		recordData := reader.Bytes(int(recordSize))
+
+		if reader.Err() != nil {
 			return nil, errors.New("decodeBlock record exceeds block size")
 		}
 
-		recordType := raw[index]
-		recordDate := int64(binary.LittleEndian.Uint64(raw[index+1 : index+9])) // Unix time int64, the number of seconds elapsed since January 1, 1970 UTC
-		recordSize := binary.LittleEndian.Uint32(raw[index+9 : index+9+4])
-		index += blockRecordHeaderSize
+		block.RecordsRaw = append(block.RecordsRaw, BlockRecordRaw{Type: recordType, Data: recordData, Date: time.Unix(recordDate, 0)})
+	}
 
-		if index+int(recordSize) > len(raw) {
-			return nil, errors.New("decodeBlock record exceeds block size")
-		}
+	// If signed by a sub-key, the owner is recovered from the sub-key record.
+	subKey, owner, found, err := blockSubKey(block.RecordsRaw, block.Number, signer)
+	if err != nil {
+		return nil, errors.New("decodeBlock invalid sub-key: " + err.Error())
+	} else if found {
+		block.OwnerPublicKey = owner
+		block.SubKey = subKey
+		block.RecordsRaw = block.RecordsRaw[1:]
+	} else {
+		block.OwnerPublicKey = signer
+	}
 
-		block.RecordsRaw = append(block.RecordsRaw, BlockRecordRaw{Type: recordType, Data: raw[index : index+int(recordSize)], Date: time.Unix(recordDate, 0)})
+	block.NodeID = protocol.PublicKey2NodeID(block.OwnerPublicKey)
 
-		index += int(recordSize)
+	// Delegated records are unwrapped. Invalid ones remain as is and are ignored by the record decoders.
+	for n := range block.RecordsRaw {
+		if block.RecordsRaw[n].Type == RecordTypeDelegated {
+			if delegated, err := DecodeDelegatedRecord(block.RecordsRaw[n].Data, block.OwnerPublicKey); err == nil {
+				block.RecordsRaw[n] = delegated
+			}
+		}
 	}
 
 	return block, nil
 }
 
-func encodeBlock(block *Block, ownerPrivateKey *btcec.PrivateKey) (raw []byte, err error) {
-	var buffer bytes.Buffer
-	buffer.Write(make([]byte, 65)) // Signature, filled at the end
-
+// encodeBlock encodes the block and signs it with the private key of the owner, or of the sub-key if set.
+func encodeBlock(block *Block, signerPrivateKey *btcec.PrivateKey) (raw []byte, err error) {
 	if block.Number > 0 && len(block.LastBlockHash) != protocol.HashSize {
 		return nil, errors.New("encodeBlock invalid last block hash")
 	} else if block.Number == 0 { // Block 0: Empty last hash
 		block.LastBlockHash = make([]byte, 32)
 	}
-	buffer.Write(block.LastBlockHash)
 
-	var temp [8]byte
-	binary.LittleEndian.PutUint64(temp[0:8], block.BlockchainVersion)
-	buffer.Write(temp[:8])
-
-	binary.LittleEndian.PutUint64(temp[0:8], block.Number)
-	buffer.Write(temp[:8])
+	writer := serialize.NewWriter(blockHeaderSize)
+	writer.Reserve(65) // Signature, filled at the end
+	writer.Fixed(block.LastBlockHash, protocol.HashSize)
+	writer.Uint64(block.BlockchainVersion)
+	writer.Uint64(block.Number)
+	sizeOffset := writer.Reserve(4)  // Size of block, filled later
+	countOffset := writer.Reserve(2) // Count of records, filled later
+
+	recordsRaw := block.RecordsRaw
+
+	// Blocks signed by a sub-key start with the sub-key record
+	if block.SubKey != nil {
+		if !block.SubKey.SubKey.IsEqual(signerPrivateKey.PubKey()) {
+			return nil, errors.New("encodeBlock sub-key mismatch")
+		} else if !block.SubKey.canSign(block.Number) {
+			return nil, errors.New("encodeBlock sub-key expired")
+		}
 
-	buffer.Write(make([]byte, 4)) // Size of block, filled later
-	buffer.Write(make([]byte, 2)) // Count of records, filled later
+		subKeyRecord, err := encodeBlockRecordSubKey(*block.SubKey)
+		if err != nil {
+			return nil, errors.New("encodeBlock: " + err.Error())
+		}
+		recordsRaw = append([]BlockRecordRaw{subKeyRecord}, recordsRaw...)
+	}
 
 	// write all records
-	countRecords := uint16(0)
+	for _, record := range recordsRaw {
+		if record.DelegateSignature != nil { // Wrap delegated records again with the delegate's signature
+			data, err := EncodeDelegatedRecord(record)
+			if err != nil {
+				return nil, errors.New("encodeBlock: " + err.Error())
+			}
+			record = BlockRecordRaw{Type: RecordTypeDelegated, Date: record.Date, Data: data}
+		}
 
-	for _, record := range block.RecordsRaw {
 		if record.Date == (time.Time{}) { // Always set date if not already set
 			record.Date = time.Now()
 		}
 
-		var tempSize, tempDate [8]byte
-		binary.LittleEndian.PutUint32(tempSize[0:4], uint32(len(record.Data)))
-		binary.LittleEndian.PutUint64(tempDate[0:8], uint64(record.Date.UTC().Unix()))
-
-		buffer.Write([]byte{record.Type}) // Record Type
-		buffer.Write(tempDate[:8])        // Date created
-		buffer.Write(tempSize[:4])        // Size of data
-		buffer.Write(record.Data)         // Data
-
-		countRecords++
+		writer.Uint8(record.Type)                       // Record Type
+		writer.Uint64(uint64(record.Date.UTC().Unix())) // Date created
+		writer.Count32(len(record.Data))                // Size of data
+		writer.Bytes(record.Data)                       // Data
 	}
 
 	// finalize the block
-	raw = buffer.Bytes()
-	if len(raw) < blockHeaderSize {
-		return nil, errors.New("encodeBlock invalid block size")
-	}
+	writer.PutCount32At(sizeOffset, writer.Len())     // Size of block
+	writer.PutCount16At(countOffset, len(recordsRaw)) // Count of records
 
-	binary.LittleEndian.PutUint32(raw[113:113+4], uint32(len(raw))) // Size of block
-	binary.LittleEndian.PutUint16(raw[117:117+2], countRecords)     // Count of records
+	if raw, err = writer.Data(); err != nil {
+		return nil, errors.New("encodeBlock: " + err.Error())
+	}
 
 	// signature is last
-	signature, err := btcec.SignCompact(btcec.S256(), ownerPrivateKey, protocol.HashData(raw[65:]), true)
+	signature, err := btcec.SignCompact(btcec.S256(), signerPrivateKey, protocol.HashData(raw[65:]), true)
 	if err != nil {
 		return nil, err
 	}
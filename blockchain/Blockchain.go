@@ -20,7 +20,10 @@ package blockchain
 import (
 	"encoding/binary"
 	"errors"
+	"io"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/PeernetOfficial/core/btcec"
 	"github.com/PeernetOfficial/core/protocol"
@@ -47,6 +50,18 @@ type Blockchain struct {
 	path       string            // Path of the blockchain on disk. Depends on key-value store whether a filename or folder.
 	database   store.Store       // The database storing the blockchain.
 	sync.Mutex                   // synchronized access to the header
+	readOnly   bool              // Read-only blockchains reject any changes with StatusReadOnly, see InitReadOnly.
+
+	// Sub-key record if the private key is a sub-key of the owner, see InitSubKey. Nil if the private key is of the owner.
+	subKey *BlockRecordSubKey
+
+	// batched appending, see AppendBatch
+	BatchWindow time.Duration // Time to wait for records of other calls before writing the block. 0 = disabled.
+	batch       *appendBatch  // Pending batch. Nil if none.
+	batchLock   sync.Mutex    // synchronized access to the pending batch
+
+	// RecordDate is used as date of new records that have no date set. Zero = current time. Setting it makes the blocks deterministic, see package fixtures.
+	RecordDate time.Time
 
 	// callback
 	BlockchainUpdate func(blockchain *Blockchain, oldHeight, oldVersion, newHeight, newVersion uint64)
@@ -54,14 +69,20 @@ type Blockchain struct {
 
 // Init initializes the given blockchain. It creates the blockchain file if it does not exist already.
 func Init(privateKey *btcec.PrivateKey, path string) (blockchain *Blockchain, err error) {
-	blockchain = &Blockchain{privateKey: privateKey, path: path}
-	publicKey := privateKey.PubKey()
-
 	// open existing blockchain file or create new one
-	if blockchain.database, err = store.NewPogrebStore(path); err != nil {
+	database, err := store.NewPogrebStore(path)
+	if err != nil {
 		return nil, err
 	}
 
+	return InitWithStore(privateKey, database, path)
+}
+
+// InitWithStore initializes the given blockchain using any key-value store, see store.Open. Path is informational only.
+func InitWithStore(privateKey *btcec.PrivateKey, database store.Store, path string) (blockchain *Blockchain, err error) {
+	blockchain = &Blockchain{privateKey: privateKey, path: path, database: database}
+	publicKey := privateKey.PubKey()
+
 	// verify header
 	var found bool
 
@@ -82,6 +103,41 @@ func Init(privateKey *btcec.PrivateKey, path string) (blockchain *Blockchain, er
 	return blockchain, nil
 }
 
+// InitReadOnly initializes an empty blockchain that is only kept in memory. Any changes are rejected with StatusReadOnly.
+// This is used by nodes that never publish, such as observer nodes with an ephemeral key.
+func InitReadOnly(privateKey *btcec.PrivateKey) (blockchain *Blockchain, err error) {
+	blockchain = &Blockchain{privateKey: privateKey, publicKey: privateKey.PubKey(), database: store.NewMemoryStore(), readOnly: true}
+
+	if err := blockchain.headerWrite(0, 0); err != nil {
+		return nil, err
+	}
+
+	return blockchain, nil
+}
+
+// ReadOwner reads the public key of the owner from the blockchain header on disk without keeping the database open. Found is false if the blockchain does not exist yet.
+// It is used to verify that the blockchain matches the private key before calling Init. StoreName is the registered store, see store.Open.
+func ReadOwner(storeName, path string) (publicKey *btcec.PublicKey, found bool, err error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+
+	database, err := store.Open(storeName, path)
+	if err != nil {
+		return nil, false, err
+	}
+	if closer, ok := database.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	blockchain := &Blockchain{path: path, database: database}
+	if found, err = blockchain.headerRead(); !found || err != nil {
+		return nil, found, err
+	}
+
+	return blockchain.publicKey, true, nil
+}
+
 // the key names in the key-value database are constant and must not collide with block numbers (i.e. they must be >64 bit)
 const keyHeader = "header blockchain"
 
@@ -151,6 +207,8 @@ const (
 	StatusCorruptBlockRecord = 3 // Error block record encoding
 	StatusDataNotFound       = 4 // Requested data not available in the blockchain
 	StatusNotInWarehouse     = 5 // File to be added to blockchain does not exist in the Warehouse
+	StatusReadOnly           = 6 // The blockchain is read-only.
+	StatusNotAuthorized      = 7 // A delegated record is not authorized by an active delegation, or the sub-key expired or reached its max block number.
 )
 
 // blockNumberToKey returns the database key for the given block number
@@ -193,6 +251,10 @@ func (blockchain *Blockchain) IterateDeleteRecord(callbackFile func(file *BlockR
 	blockchain.Lock()
 	defer blockchain.Unlock()
 
+	if blockchain.readOnly {
+		return blockchain.height, blockchain.version, StatusReadOnly
+	}
+
 	// New blockchain keeps track of the new blocks. If anything changes in the blockchain, it must be recalculated and the version number increased.
 	var blockchainNew []Block
 	refactorBlockchain := false
@@ -216,7 +278,8 @@ func (blockchain *Blockchain) IterateDeleteRecord(callbackFile func(file *BlockR
 
 		// Decode all file records at once. This is needed due to potential referenced tags.
 		// If a file is deleted or referenced tag data changed, it would corrupt the blockchain if the other records were not updated.
-		filesD, err := decodeBlockRecordFiles(block.RecordsRaw, block.NodeID)
+		// Delegated records are handled below with the other records to retain the signature of the delegate.
+		filesD, err := decodeBlockRecordFiles(ownRecords(block.RecordsRaw), block.NodeID)
 		if err != nil {
 			return 0, 0, StatusCorruptBlock
 		}
@@ -252,7 +315,38 @@ func (blockchain *Blockchain) IterateDeleteRecord(callbackFile func(file *BlockR
 
 		for n := range block.RecordsRaw {
 			// File and Tag records were already handled in above loop.
-			if block.RecordsRaw[n].Type == RecordTypeFile || block.RecordsRaw[n].Type == RecordTypeTagData {
+			if (block.RecordsRaw[n].Type == RecordTypeFile || block.RecordsRaw[n].Type == RecordTypeTagData) && block.RecordsRaw[n].Delegate == nil {
+				continue
+			}
+
+			// Delegated file records are self-contained. Replaced ones are re-encoded as records of the owner.
+			if block.RecordsRaw[n].Type == RecordTypeFile {
+				files, err := decodeBlockRecordFiles(block.RecordsRaw[n:n+1], block.NodeID)
+				if err != nil || len(files) != 1 {
+					return 0, 0, StatusCorruptBlockRecord
+				}
+
+				deleteAction := 0
+				if callbackFile != nil {
+					deleteAction = callbackFile(&files[0])
+				}
+
+				switch deleteAction {
+				case 0: // no action on record
+					newRecordsRaw = append(newRecordsRaw, block.RecordsRaw[n])
+
+				case 1: // delete record
+					refactorBlock = true
+					refactorBlockchain = true
+
+				case 2: // replace record
+					newFileRecords = append(newFileRecords, files[0])
+					refactorBlock = true
+					refactorBlockchain = true
+
+				case 3: // error blockchain corrupt
+					return 0, 0, StatusCorruptBlockRecord
+				}
 				continue
 			}
 
@@ -290,10 +384,10 @@ func (blockchain *Blockchain) IterateDeleteRecord(callbackFile func(file *BlockR
 			newRecordsRaw = append(newRecordsRaw, filesRecords...)
 
 			if len(newRecordsRaw) > 0 {
-				blockchainNew = append(blockchainNew, Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: newRecordsRaw, BlockchainVersion: refactorVersion, Number: uint64(len(blockchainNew))})
+				blockchainNew = append(blockchainNew, Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: newRecordsRaw, BlockchainVersion: refactorVersion, Number: uint64(len(blockchainNew)), SubKey: blockchain.subKey})
 			}
 		} else {
-			blockchainNew = append(blockchainNew, Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: block.RecordsRaw, BlockchainVersion: refactorVersion, Number: uint64(len(blockchainNew))})
+			blockchainNew = append(blockchainNew, Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: block.RecordsRaw, BlockchainVersion: refactorVersion, Number: uint64(len(blockchainNew)), SubKey: blockchain.subKey})
 		}
 	}
 
@@ -343,9 +437,22 @@ func (blockchain *Blockchain) Append(RecordsRaw []BlockRecordRaw) (newHeight, ne
 
 	if len(RecordsRaw) == 0 {
 		return blockchain.height, blockchain.version, StatusOK
+	} else if blockchain.readOnly {
+		return blockchain.height, blockchain.version, StatusReadOnly
+	} else if blockchain.subKey != nil && !blockchain.subKey.canSign(blockchain.height) {
+		return blockchain.height, blockchain.version, StatusNotAuthorized
+	}
+
+	if !blockchain.RecordDate.IsZero() {
+		RecordsRaw = append([]BlockRecordRaw{}, RecordsRaw...)
+		for n := range RecordsRaw {
+			if RecordsRaw[n].Date.IsZero() {
+				RecordsRaw[n].Date = blockchain.RecordDate
+			}
+		}
 	}
 
-	block := &Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: RecordsRaw}
+	block := &Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: RecordsRaw, SubKey: blockchain.subKey}
 
 	// set the last block hash first
 	if blockchain.height > 0 {
@@ -398,11 +505,27 @@ func (blockchain *Blockchain) Read(number uint64) (decoded *BlockDecoded, status
 	return decoded, StatusOK, nil
 }
 
+// Close closes the database, if supported by the store. The blockchain must not be used afterwards.
+func (blockchain *Blockchain) Close() (err error) {
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	if closer, ok := blockchain.database.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
 // DeleteBlockchain deletes the entire blockchain
 func (blockchain *Blockchain) DeleteBlockchain() (status int, err error) {
 	blockchain.Lock()
 	defer blockchain.Unlock()
 
+	if blockchain.readOnly {
+		return StatusReadOnly, errors.New("blockchain is read-only")
+	}
+
 	for n := uint64(0); n < blockchain.height; n++ {
 		blockchain.database.Delete(blockNumberToKey(n))
 	}
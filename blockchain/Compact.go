@@ -0,0 +1,274 @@
+/*
+File Username:  Compact.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Compaction rewrites the blockchain into fewer, larger blocks. Chains with many single-record appends accumulate thousands of tiny blocks,
+each requiring a round trip when other peers sync the blockchain. The records of consecutive blocks are merged into blocks up to the target
+size. The order of records is preserved. The blocks of a compacted blockchain are re-encoded and signed, therefore the version is increased.
+
+Tag data records are dropped and re-created for each new block: Tag data shared by files that were in different blocks is deduplicated, and
+tag data records orphaned by deleted files are removed. The size of a new block is calculated including the deduplication, so that blocks are
+filled up to the target size.
+
+Safeguards:
+* The entire new blockchain is encoded and verified in memory before anything is written. The count of file and other records must match.
+* The blockchain is locked during compaction. Concurrent appends wait until it is finished.
+* Nothing is written if compaction would reduce neither the height nor the size.
+* After writing, the new blocks are read back and the hash chain is verified.
+*/
+
+package blockchain
+
+import (
+	"bytes"
+
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// Phases of compaction reported to the progress callback
+const (
+	CompactPhaseRead   = 0 // Reading and merging the existing blocks.
+	CompactPhaseWrite  = 1 // Writing the new blocks.
+	CompactPhaseVerify = 2 // Verifying the written blocks.
+)
+
+// CompactResult is the result of compacting the blockchain.
+type CompactResult struct {
+	HeightBefore  uint64 // Height before compaction.
+	HeightAfter   uint64 // Height after compaction. In a dry run, the height the blockchain would have.
+	VersionBefore uint64 // Version before compaction.
+	VersionAfter  uint64 // Version after compaction. Unchanged if nothing was written.
+	Records       uint64 // Count of records (files counted as one) in the blockchain.
+	SizeBefore    uint64 // Total size of all blocks before compaction.
+	SizeAfter     uint64 // Total size of all blocks after compaction. In a dry run, the size the blockchain would have.
+	Written       bool   // Whether the blockchain was rewritten. False for dry runs and if the height would not be reduced.
+}
+
+// compactUnit are the records of a single existing block. They are never split.
+type compactUnit struct {
+	files  []BlockRecordFile
+	others []BlockRecordRaw
+	size   uint64 // Size without deduplication of tag data.
+}
+
+// compactBlock is a new block with the units merged into it.
+type compactBlock struct {
+	units   []compactUnit
+	size    uint64         // Size of the block including deduplication of tag data.
+	tagData map[string]int // Count of files referencing tag data eligible for deduplication.
+}
+
+func newCompactBlock() *compactBlock {
+	return &compactBlock{size: blockHeaderSize, tagData: make(map[string]int)}
+}
+
+// sizeWith returns the size of the block if the unit is added. Tag data shared by 2 or more files is stored once as tag data record and
+// referenced by 4 bytes instead, see encodeBlockRecordFiles. If add is set, the unit is added.
+func (block *compactBlock) sizeWith(unit compactUnit, add bool) (size uint64) {
+	size = block.size + unit.size
+	added := make(map[string]int)
+
+	for n := range unit.files {
+		for _, tag := range unit.files[n].Tags {
+			if tag.IsVirtual() || len(tag.Data) <= 4 {
+				continue
+			}
+
+			length := uint64(len(tag.Data))
+			switch block.tagData[string(tag.Data)] + added[string(tag.Data)] {
+			case 0: // First occurrence is stored inline, which is already included.
+			case 1: // Second occurrence: Tag data record is created and both occurrences become references.
+				size = size + blockRecordHeaderSize + length + 4 + 4 - 2*length
+			default: // Further occurrences become references.
+				size = size + 4 - length
+			}
+
+			added[string(tag.Data)]++
+		}
+	}
+
+	if add {
+		block.units = append(block.units, unit)
+		block.size = size
+		for data, count := range added {
+			block.tagData[data] += count
+		}
+	}
+
+	return size
+}
+
+// Compact merges consecutive blocks into blocks up to the target size. If the target size is 0, TargetBlockSize is used. If dryRun is set,
+// the new height is calculated but nothing is written. The optional progress callback receives the phase (see CompactPhaseX) and the count of
+// processed and total blocks. Status is StatusX.
+func (blockchain *Blockchain) Compact(targetSize uint64, dryRun bool, progress func(phase int, done, total uint64)) (result CompactResult, status int) {
+	if targetSize == 0 {
+		targetSize = TargetBlockSize
+	}
+	if progress == nil {
+		progress = func(phase int, done, total uint64) {}
+	}
+
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	height := blockchain.height
+	result = CompactResult{HeightBefore: height, HeightAfter: height, VersionBefore: blockchain.version, VersionAfter: blockchain.version}
+
+	// Read all blocks and merge them into new blocks.
+	var blocksNew []*compactBlock
+	current := newCompactBlock()
+	var countFiles, countOthers uint64
+
+	for blockN := uint64(0); blockN < height; blockN++ {
+		progress(CompactPhaseRead, blockN, height)
+
+		blockRaw, found := blockchain.database.Get(blockNumberToKey(blockN))
+		if !found || len(blockRaw) == 0 {
+			return result, StatusBlockNotFound
+		}
+
+		result.SizeBefore += uint64(len(blockRaw))
+
+		block, err := decodeBlock(blockRaw)
+		if err != nil {
+			return result, StatusCorruptBlock
+		}
+
+		// Delegated records are kept as is to retain the signature of the delegate.
+		files, err := decodeBlockRecordFiles(ownRecords(block.RecordsRaw), block.NodeID)
+		if err != nil {
+			return result, StatusCorruptBlockRecord
+		}
+
+		unit := compactUnit{files: files}
+		for n := range files {
+			unit.size += files[n].SizeInBlock()
+		}
+		for _, record := range block.RecordsRaw {
+			if (record.Type != RecordTypeFile && record.Type != RecordTypeTagData) || record.Delegate != nil {
+				unit.others = append(unit.others, record)
+				unit.size += record.SizeInBlock()
+			}
+		}
+
+		countFiles += uint64(len(unit.files))
+		countOthers += uint64(len(unit.others))
+
+		if len(current.units) > 0 && current.sizeWith(unit, false) > targetSize {
+			blocksNew = append(blocksNew, current)
+			current = newCompactBlock()
+		}
+
+		current.sizeWith(unit, true)
+	}
+
+	if len(current.units) > 0 {
+		blocksNew = append(blocksNew, current)
+	}
+
+	progress(CompactPhaseRead, height, height)
+
+	result.Records = countFiles + countOthers
+
+	// Encode the new blocks and verify the record counts before writing anything.
+	version := blockchain.version + 1
+	var blocksRaw [][]byte
+	var lastBlockHash []byte
+	var verifyFiles, verifyOthers uint64
+	var sizeAfter uint64
+
+	for number, blockNew := range blocksNew {
+		var files []BlockRecordFile
+		var recordsRaw []BlockRecordRaw
+
+		for _, unit := range blockNew.units {
+			files = append(files, unit.files...)
+			recordsRaw = append(recordsRaw, unit.others...)
+		}
+
+		filesRaw, err := encodeBlockRecordFiles(files)
+		if err != nil {
+			return result, StatusCorruptBlockRecord
+		}
+		recordsRaw = append(recordsRaw, filesRaw...)
+
+		raw, err := encodeBlock(&Block{OwnerPublicKey: blockchain.publicKey, LastBlockHash: lastBlockHash, BlockchainVersion: version, Number: uint64(number), RecordsRaw: recordsRaw, SubKey: blockchain.subKey}, blockchain.privateKey)
+		if err != nil {
+			return result, StatusCorruptBlock
+		}
+
+		block, err := decodeBlock(raw)
+		if err != nil {
+			return result, StatusCorruptBlock
+		}
+		filesVerify, err := decodeBlockRecordFiles(ownRecords(block.RecordsRaw), block.NodeID)
+		if err != nil {
+			return result, StatusCorruptBlockRecord
+		}
+
+		verifyFiles += uint64(len(filesVerify))
+		for _, record := range block.RecordsRaw {
+			if (record.Type != RecordTypeFile && record.Type != RecordTypeTagData) || record.Delegate != nil {
+				verifyOthers++
+			}
+		}
+
+		blocksRaw = append(blocksRaw, raw)
+		lastBlockHash = protocol.HashData(raw)
+		sizeAfter += uint64(len(raw))
+	}
+
+	if verifyFiles != countFiles || verifyOthers != countOthers {
+		return result, StatusCorruptBlockRecord
+	}
+
+	if dryRun {
+		result.HeightAfter, result.SizeAfter = uint64(len(blocksRaw)), sizeAfter
+		return result, StatusOK
+	} else if uint64(len(blocksRaw)) >= height && sizeAfter >= result.SizeBefore {
+		result.SizeAfter = result.SizeBefore
+		return result, StatusOK
+	} else if blockchain.readOnly {
+		return result, StatusReadOnly
+	}
+
+	// Write the new blocks, the header, and delete the orphaned blocks.
+	for number, raw := range blocksRaw {
+		progress(CompactPhaseWrite, uint64(number), uint64(len(blocksRaw)))
+
+		blockchain.database.Set(blockNumberToKey(uint64(number)), raw)
+	}
+
+	blockchain.headerWrite(uint64(len(blocksRaw)), version)
+
+	for n := blockchain.height; n < height; n++ {
+		blockchain.database.Delete(blockNumberToKey(n))
+	}
+
+	result.HeightAfter, result.VersionAfter, result.SizeAfter, result.Written = blockchain.height, blockchain.version, sizeAfter, true
+
+	// Read back the written blocks and verify the hash chain. Block 0 has an empty last hash.
+	lastBlockHash = make([]byte, protocol.HashSize)
+
+	for number := range blocksRaw {
+		progress(CompactPhaseVerify, uint64(number), uint64(len(blocksRaw)))
+
+		raw, found := blockchain.database.Get(blockNumberToKey(uint64(number)))
+		if !found || !bytes.Equal(raw, blocksRaw[number]) {
+			return result, StatusBlockNotFound
+		}
+
+		block, err := decodeBlock(raw)
+		if err != nil || !bytes.Equal(block.LastBlockHash, lastBlockHash) {
+			return result, StatusCorruptBlock
+		}
+
+		lastBlockHash = protocol.HashData(raw)
+	}
+
+	progress(CompactPhaseVerify, uint64(len(blocksRaw)), uint64(len(blocksRaw)))
+
+	return result, StatusOK
+}
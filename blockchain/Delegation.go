@@ -0,0 +1,90 @@
+/*
+File Username:  Delegation.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package blockchain
+
+import "github.com/PeernetOfficial/core/btcec"
+
+// DelegationList lists all delegation records including expired ones. Status is StatusX.
+func (blockchain *Blockchain) DelegationList() (delegations []BlockRecordDelegation, status int) {
+	status = blockchain.Iterate(func(block *Block) (statusI int) {
+		blockDelegations, err := decodeBlockRecordDelegations(block.RecordsRaw)
+		if err != nil {
+			return StatusCorruptBlockRecord
+		}
+
+		delegations = append(delegations, blockDelegations...)
+
+		return StatusOK
+	})
+
+	return delegations, status
+}
+
+// DelegationAdd authorizes the delegate to create records of the types in the scope until the expiry. Status is StatusX.
+func (blockchain *Blockchain) DelegationAdd(delegation BlockRecordDelegation) (newHeight, newVersion uint64, status int) {
+	encoded, err := encodeBlockRecordDelegation(delegation)
+	if err != nil {
+		return 0, 0, StatusCorruptBlockRecord
+	}
+
+	return blockchain.Append([]BlockRecordRaw{encoded})
+}
+
+// DelegationRevoke deletes all delegation records of the delegate. Records already created by the delegate are kept. Status is StatusX.
+func (blockchain *Blockchain) DelegationRevoke(delegate *btcec.PublicKey) (newHeight, newVersion uint64, status int) {
+	return blockchain.IterateDeleteRecord(nil, func(record *BlockRecordRaw) (deleteAction int) {
+		if record.Type != RecordTypeDelegation {
+			return 0 // no action
+		}
+
+		delegations, err := decodeBlockRecordDelegations([]BlockRecordRaw{*record})
+		if err != nil || len(delegations) != 1 {
+			return 3 // error blockchain corrupt
+		}
+
+		if delegations[0].Delegate.IsEqual(delegate) {
+			return 1 // delete record
+		}
+
+		return 0 // no action
+	})
+}
+
+// DelegatedAppend appends records created by delegates, see DelegatedRecordSign. Each record must be signed by its delegate and authorized by an
+// active delegation that includes the record type. Otherwise no record is appended and the status is StatusNotAuthorized. Status is StatusX.
+func (blockchain *Blockchain) DelegatedAppend(records []BlockRecordRaw) (newHeight, newVersion uint64, status int) {
+	delegations, status := blockchain.DelegationList()
+	if status != StatusOK {
+		return 0, 0, status
+	}
+
+	for _, record := range records {
+		if !verifyDelegatedRecord(record, blockchain.publicKey) || !isDelegationActive(delegations, record.Delegate, record.Type) {
+			return 0, 0, StatusNotAuthorized
+		}
+
+		// Delegated file records must not reference tag data records.
+		if record.Type == RecordTypeFile {
+			if _, err := decodeBlockRecordFiles([]BlockRecordRaw{record}, nil); err != nil {
+				return 0, 0, StatusCorruptBlockRecord
+			}
+		}
+	}
+
+	return blockchain.AppendBatch(records)
+}
+
+// isDelegationActive checks if any of the delegations authorizes the delegate to create records of the type.
+func isDelegationActive(delegations []BlockRecordDelegation, delegate *btcec.PublicKey, recordType uint8) bool {
+	for n := range delegations {
+		if delegations[n].Delegate.IsEqual(delegate) && delegations[n].IsActive() && delegations[n].InScope(recordType) {
+			return true
+		}
+	}
+
+	return false
+}
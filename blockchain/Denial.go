@@ -0,0 +1,53 @@
+/*
+File Username:  Denial.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package blockchain
+
+// DenialList lists all denial records in the order they were published. Status is StatusX.
+func (blockchain *Blockchain) DenialList() (denials []BlockRecordDenial, status int) {
+	status = blockchain.Iterate(func(block *Block) (statusI int) {
+		blockDenials, err := decodeBlockRecordDenials(block.RecordsRaw)
+		if err != nil {
+			return StatusCorruptBlockRecord
+		}
+
+		denials = append(denials, blockDenials...)
+
+		return StatusOK
+	})
+
+	return denials, status
+}
+
+// DenialPublish publishes the denial records. Multiple blocks are created if the records exceed the target block size. Status is StatusX.
+func (blockchain *Blockchain) DenialPublish(denials []BlockRecordDenial) (newHeight, newVersion uint64, status int) {
+	var records []BlockRecordRaw
+	blockSize := uint64(blockHeaderSize)
+
+	for _, denial := range denials {
+		encoded, err := encodeBlockRecordDenial(denial)
+		if err != nil {
+			return 0, 0, StatusCorruptBlockRecord
+		}
+
+		recordSize := denial.SizeInBlock()
+
+		// need to create a new block due to target block size?
+		if len(records) > 0 && blockSize+recordSize > TargetBlockSize {
+			if newHeight, newVersion, status = blockchain.Append(records); status != StatusOK {
+				return newHeight, newVersion, status
+			}
+
+			blockSize = blockHeaderSize
+			records = nil
+		}
+
+		blockSize += recordSize
+		records = append(records, encoded)
+	}
+
+	return blockchain.Append(records)
+}
@@ -23,6 +23,9 @@ const (
 	TagDateCreated   = 4 // Date when the file was originally created. This may differ from the date in the block record, which indicates when the file was shared.
 	TagSharedByCount = 5 // Count of peers that share the file. Virtual.
 	TagSharedByGeoIP = 6 // GeoIP data of peers that are sharing the file. CSV encoded with header "latitude,longitude". Virtual.
+	TagDateExpires   = 7 // Date when the file expires. Expired files are excluded from search and deleted by the owner.
+	TagDirectory     = 8 // Hash of the directory manifest that contains the file. See warehouse.DirectoryEntry.
+	TagTextExcerpt   = 9 // Excerpt of the text of the file. Indexed for full-text search.
 )
 
 // Future tags to be defined for audio/video: Artist, Album, Title, Length, Bitrate, Codec
@@ -92,6 +95,12 @@ func IsTagVirtual(Type uint16) bool {
 	}
 }
 
+// IsExpired checks if the file has an expiration date that has passed.
+func (file *BlockRecordFile) IsExpired() bool {
+	expires, err := file.GetTag(TagDateExpires).Date()
+	return err == nil && !expires.After(time.Now())
+}
+
 // GetTag returns the tag with the type or nil if not available.
 func (file *BlockRecordFile) GetTag(Type uint16) (tag *BlockRecordFileTag) {
 	for n := range file.Tags {
@@ -16,11 +16,14 @@ import (
 
 // AddFiles adds files to the blockchain. Status is StatusX.
 // It makes sense to group all files in the same directory into one call, since only one directory record will be created per unique directory per block.
+// The last block is written via AppendBatch, so that files added by multiple calls within the batch window share a block.
 func (blockchain *Blockchain) AddFiles(files []BlockRecordFile) (newHeight, newVersion uint64, status int) {
-	encodeFilesAppend := func(files []BlockRecordFile) (newHeight, newVersion uint64, status int) {
+	encodeFilesAppend := func(files []BlockRecordFile, batch bool) (newHeight, newVersion uint64, status int) {
 		encoded, err := encodeBlockRecordFiles(files)
 		if err != nil {
 			return 0, 0, StatusCorruptBlockRecord
+		} else if batch {
+			return blockchain.AppendBatch(encoded)
 		}
 
 		return blockchain.Append(encoded)
@@ -34,7 +37,7 @@ func (blockchain *Blockchain) AddFiles(files []BlockRecordFile) (newHeight, newV
 
 		// need to create a new block due to target block size?
 		if len(recordFiles) > 0 && blockSize+recordSize > TargetBlockSize {
-			if newHeight, newVersion, status = encodeFilesAppend(recordFiles); status != StatusOK {
+			if newHeight, newVersion, status = encodeFilesAppend(recordFiles, false); status != StatusOK {
 				return newHeight, newVersion, status
 			}
 
@@ -46,7 +49,7 @@ func (blockchain *Blockchain) AddFiles(files []BlockRecordFile) (newHeight, newV
 		recordFiles = append(recordFiles, file)
 	}
 
-	return encodeFilesAppend(recordFiles)
+	return encodeFilesAppend(recordFiles, true)
 }
 
 // ListFiles returns a list of all files. Status is StatusX.
@@ -101,6 +104,20 @@ func (blockchain *Blockchain) DeleteFiles(IDs []uuid.UUID) (newHeight, newVersio
 	return
 }
 
+// DeleteExpiredFiles deletes all files from the blockchain that have an expiration date that has passed. Status is StatusX.
+func (blockchain *Blockchain) DeleteExpiredFiles() (newHeight, newVersion uint64, deletedFiles []*BlockRecordFile, status int) {
+	newHeight, newVersion, status = blockchain.IterateDeleteRecord(func(file *BlockRecordFile) (deleteAction int) {
+		if file.IsExpired() {
+			deletedFiles = append(deletedFiles, file)
+			return 1 // delete record
+		}
+
+		return 0 // no action on record
+	}, nil)
+
+	return
+}
+
 // ReplaceFiles is a convenience wrapper to replace files in the blockchain identified via their IDs. Status is StatusX.
 // If a file does not exist on the blockchain, it acts as add.
 func (blockchain *Blockchain) ReplaceFiles(files []BlockRecordFile) (newHeight, newVersion uint64, status int) {
@@ -0,0 +1,86 @@
+/*
+File Username:  Group.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package blockchain
+
+import "github.com/google/uuid"
+
+// GroupList lists all groups. Status is StatusX.
+func (blockchain *Blockchain) GroupList() (groups []BlockRecordGroup, status int) {
+	uniqueGroups := make(map[uuid.UUID]int) // index into groups
+
+	status = blockchain.Iterate(func(block *Block) (statusI int) {
+		blockGroups, err := decodeBlockRecordGroups(block.RecordsRaw)
+		if err != nil {
+			return StatusCorruptBlockRecord
+		}
+
+		// Later records replace earlier ones with the same ID.
+		for n := range blockGroups {
+			if index, ok := uniqueGroups[blockGroups[n].ID]; ok {
+				groups[index] = blockGroups[n]
+				continue
+			}
+
+			uniqueGroups[blockGroups[n].ID] = len(groups)
+			groups = append(groups, blockGroups[n])
+		}
+
+		return StatusOK
+	})
+
+	return groups, status
+}
+
+// GroupRead reads the group. Status is StatusX.
+func (blockchain *Blockchain) GroupRead(id uuid.UUID) (group *BlockRecordGroup, status int) {
+	groups, status := blockchain.GroupList()
+	if status != StatusOK {
+		return nil, status
+	}
+
+	for n := range groups {
+		if groups[n].ID == id {
+			return &groups[n], StatusOK
+		}
+	}
+
+	return nil, StatusDataNotFound
+}
+
+// GroupWrite writes the group to the blockchain. Any existing record of the same group is replaced. Status is StatusX.
+func (blockchain *Blockchain) GroupWrite(group BlockRecordGroup) (newHeight, newVersion uint64, status int) {
+	encoded, err := encodeBlockRecordGroup(group)
+	if err != nil {
+		return 0, 0, StatusCorruptBlockRecord
+	}
+
+	if _, _, status = blockchain.GroupDelete(group.ID); status != StatusOK {
+		return 0, 0, status
+	}
+
+	return blockchain.Append([]BlockRecordRaw{encoded})
+}
+
+// GroupDelete deletes the group from the blockchain. Status is StatusX.
+func (blockchain *Blockchain) GroupDelete(id uuid.UUID) (newHeight, newVersion uint64, status int) {
+	return blockchain.IterateDeleteRecord(nil, func(record *BlockRecordRaw) (deleteAction int) {
+		if record.Type != RecordTypeGroup {
+			return 0 // no action
+		}
+
+		groups, err := decodeBlockRecordGroups([]BlockRecordRaw{*record})
+		if err != nil || len(groups) != 1 {
+			return 3 // error blockchain corrupt
+		}
+
+		if groups[0].ID == id {
+			return 1 // delete record
+		}
+
+		return 0 // no action on record
+	})
+}
@@ -44,14 +44,18 @@ type MultiStore struct {
 }
 
 func InitMultiStore(path string) (multi *MultiStore, err error) {
-	multi = &MultiStore{path: path}
-
 	// open existing blockchain file or create new one
-	if multi.Database, err = store.NewPogrebStore(path); err != nil {
+	database, err := store.NewPogrebStore(path)
+	if err != nil {
 		return nil, err
 	}
 
-	return multi, nil
+	return InitMultiStoreWithStore(database, path), nil
+}
+
+// InitMultiStoreWithStore initializes the multi store using any key-value store, see store.Open. Path is informational only.
+func InitMultiStoreWithStore(database store.Store, path string) (multi *MultiStore) {
+	return &MultiStore{path: path, Database: database}
 }
 
 /*
@@ -277,6 +281,15 @@ func (multi *MultiStore) IngestBlock(header *MultiBlockchainHeader, blockNumber
 		return nil, err
 	}
 
+	// Blocks signed by a sub-key are only valid at their own block number and until the sub-key is revoked.
+	if decoded != nil && decoded.SubKey != nil {
+		if decoded.Number != blockNumber {
+			return nil, errors.New("block number mismatch")
+		} else if isSubKeyRevoked(multi.subKeyRevocations(header), decoded.SubKey.SubKey, blockNumber) {
+			return nil, errors.New("sub-key revoked")
+		}
+	}
+
 	// store the transferred block in the cache
 	err = multi.WriteBlock(header.PublicKey, header.Version, blockNumber, raw)
 	if err != nil {
@@ -284,10 +297,12 @@ func (multi *MultiStore) IngestBlock(header *MultiBlockchainHeader, blockNumber
 	}
 
 	header.ListBlocks = append(header.ListBlocks, blockNumber)
+	header.DateLastBlockAdded = time.Now().UTC()
 
 	// update blockchain header stats if records were decoded
 	if status == StatusOK {
 		multi.UpdateBlockchainStatistics(header, decoded.RecordsDecoded)
+		multi.removeRevokedBlocks(header, decoded.RecordsDecoded)
 	}
 
 	// update the blockchain header
@@ -295,3 +310,60 @@ func (multi *MultiStore) IngestBlock(header *MultiBlockchainHeader, blockNumber
 
 	return decoded, nil
 }
+
+// subKeyRevocations returns the sub-key revocations in the stored blocks signed by the owner.
+func (multi *MultiStore) subKeyRevocations(header *MultiBlockchainHeader) (revokes []BlockRecordSubKeyRevoke) {
+	for _, blockN := range header.ListBlocks {
+		raw, found := multi.ReadBlock(header.PublicKey, header.Version, blockN)
+		if !found {
+			continue
+		}
+
+		if block, err := decodeBlock(raw); err == nil && block.SubKey == nil {
+			blockRevokes, _ := decodeBlockRecordSubKeyRevokes(block.RecordsRaw)
+			revokes = append(revokes, blockRevokes...)
+		}
+	}
+
+	return revokes
+}
+
+// removeRevokedBlocks removes stored blocks that were signed by sub-keys revoked in the decoded records. Blocks may be ingested in any order.
+// It does not write the blockchain header.
+func (multi *MultiStore) removeRevokedBlocks(header *MultiBlockchainHeader, recordsDecoded []interface{}) {
+	var revokes []BlockRecordSubKeyRevoke
+	for _, record := range recordsDecoded {
+		if revoke, ok := record.(BlockRecordSubKeyRevoke); ok {
+			revokes = append(revokes, revoke)
+		}
+	}
+	if len(revokes) == 0 {
+		return
+	}
+
+	var listBlocks []uint64
+
+	for _, blockN := range header.ListBlocks {
+		if raw, found := multi.ReadBlock(header.PublicKey, header.Version, blockN); found {
+			if block, err := decodeBlock(raw); err == nil && block.SubKey != nil && isSubKeyRevoked(revokes, block.SubKey.SubKey, blockN) {
+				multi.Database.Delete(lookupKeyForBlock(header.PublicKey, header.Version, blockN))
+				continue
+			}
+		}
+
+		listBlocks = append(listBlocks, blockN)
+	}
+
+	header.ListBlocks = listBlocks
+}
+
+// isSubKeyRevoked checks if any of the revocations invalidates the block signed by the sub-key.
+func isSubKeyRevoked(revokes []BlockRecordSubKeyRevoke, subKey *btcec.PublicKey, blockNumber uint64) bool {
+	for _, revoke := range revokes {
+		if revoke.SubKey.IsEqual(subKey) && blockNumber >= revoke.Block {
+			return true
+		}
+	}
+
+	return false
+}
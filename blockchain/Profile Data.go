@@ -8,13 +8,14 @@ package blockchain
 
 // List of recognized profile fields.
 const (
-	ProfileName    = 0 // Arbitrary username
-	ProfileEmail   = 1 // Email address
-	ProfileWebsite = 2 // Website address
-	ProfileTwitter = 3 // Twitter account without the @
-	ProfileYouTube = 4 // YouTube channel URL
-	ProfileAddress = 5 // Physical address
-	ProfilePicture = 6 // Profile picture, blob
+	ProfileName            = 0 // Arbitrary username
+	ProfileEmail           = 1 // Email address
+	ProfileWebsite         = 2 // Website address
+	ProfileTwitter         = 3 // Twitter account without the @
+	ProfileYouTube         = 4 // YouTube channel URL
+	ProfileAddress         = 5 // Physical address
+	ProfilePicture         = 6 // Profile picture, blob
+	ProfilePictureOriginal = 7 // Blake3 hash of the original profile picture in the warehouse. Only set if the picture was downscaled.
 )
 
 // The encoding of profile fields depends on the field. Text data is always UTF-8 text encoded.
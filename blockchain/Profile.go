@@ -8,22 +8,11 @@ package blockchain
 
 // ProfileReadField reads the specified profile field. See ProfileX for the list of recognized fields. The encoding depends on the field type. Status is StatusX.
 func (blockchain *Blockchain) ProfileReadField(index uint16) (data []byte, status int) {
-	found := false
+	fields := make(map[uint16][]byte)
 
 	status = blockchain.Iterate(func(block *Block) (statusI int) {
-		fields, err := DecodeBlockRecordProfile(block.RecordsRaw)
-		if err != nil {
+		if err := ProfileMerge(fields, block.RecordsRaw); err != nil {
 			return StatusCorruptBlockRecord
-		} else if len(fields) == 0 {
-			return StatusOK
-		}
-
-		// Check if the field is available in the profile record. If there are multiple records, only return the latest one.
-		for n := range fields {
-			if fields[n].Type == index {
-				data = fields[n].Data
-				found = true
-			}
 		}
 
 		return StatusOK
@@ -31,7 +20,10 @@ func (blockchain *Blockchain) ProfileReadField(index uint16) (data []byte, statu
 
 	if status != StatusOK {
 		return nil, status
-	} else if !found {
+	}
+
+	data, found := fields[index]
+	if !found {
 		return nil, StatusDataNotFound
 	}
 
@@ -43,15 +35,10 @@ func (blockchain *Blockchain) ProfileList() (fields []BlockRecordProfile, status
 	uniqueFields := make(map[uint16][]byte)
 
 	status = blockchain.Iterate(func(block *Block) (statusI int) {
-		fields, err := DecodeBlockRecordProfile(block.RecordsRaw)
-		if err != nil {
+		if err := ProfileMerge(uniqueFields, block.RecordsRaw); err != nil {
 			return StatusCorruptBlockRecord
 		}
 
-		for n := range fields {
-			uniqueFields[fields[n].Type] = fields[n].Data
-		}
-
 		return StatusOK
 	})
 
@@ -96,20 +83,63 @@ func (blockchain *Blockchain) ProfileWrite(fields []BlockRecordProfile) (newHeig
 	return encodeProfileAppend(recordFields)
 }
 
-// ProfileDelete deletes fields and blobs from the blockchain. Status is StatusX.
+// ProfileUpdate appends field-level updates to the blockchain. Unlike ProfileDelete, existing blocks are not rewritten and the blockchain version does not change.
+// Deleted fields remain in older blocks until the blockchain is refactored. Status is StatusX.
+func (blockchain *Blockchain) ProfileUpdate(updates []BlockRecordProfileUpdate) (newHeight, newVersion uint64, status int) {
+	blockSize := uint64(blockHeaderSize)
+	var records []BlockRecordRaw
+
+	for _, update := range updates {
+		record, err := encodeBlockRecordProfileUpdate(update)
+		if err != nil {
+			return 0, 0, StatusCorruptBlockRecord
+		}
+
+		recordSize := update.SizeInBlock()
+
+		// need to create a new block due to target block size?
+		if len(records) > 0 && blockSize+recordSize > TargetBlockSize {
+			if newHeight, newVersion, status = blockchain.Append(records); status != StatusOK {
+				return newHeight, newVersion, status
+			}
+
+			blockSize = blockHeaderSize
+			records = nil
+		}
+
+		blockSize += recordSize
+		records = append(records, record)
+	}
+
+	return blockchain.Append(records)
+}
+
+// ProfileDelete deletes fields and blobs from the blockchain, including any updates of them. The blockchain is refactored. Status is StatusX.
 func (blockchain *Blockchain) ProfileDelete(fields []uint16) (newHeight, newVersion uint64, status int) {
 	return blockchain.IterateDeleteRecord(nil, func(record *BlockRecordRaw) (deleteAction int) {
-		if record.Type != RecordTypeProfile {
-			return 0 // no action
-		}
+		var fieldType uint16
+
+		switch record.Type {
+		case RecordTypeProfile:
+			existingFields, err := DecodeBlockRecordProfile([]BlockRecordRaw{*record})
+			if err != nil || len(existingFields) != 1 {
+				return 3 // error blockchain corrupt
+			}
+			fieldType = existingFields[0].Type
+
+		case RecordTypeProfileUpdate:
+			update, err := decodeBlockRecordProfileUpdate(*record)
+			if err != nil {
+				return 3 // error blockchain corrupt
+			}
+			fieldType = update.Type
 
-		existingFields, err := DecodeBlockRecordProfile([]BlockRecordRaw{*record})
-		if err != nil || len(existingFields) != 1 {
-			return 3 // error blockchain corrupt
+		default:
+			return 0 // no action
 		}
 
 		for _, i := range fields {
-			if i == existingFields[0].Type { // found a file ID to delete?
+			if i == fieldType { // found a field to delete?
 				return 1 // delete record
 			}
 		}
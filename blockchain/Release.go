@@ -0,0 +1,33 @@
+/*
+File Username:  Release.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package blockchain
+
+// ReleaseList lists all releases in the order they were published. Status is StatusX.
+func (blockchain *Blockchain) ReleaseList() (releases []BlockRecordRelease, status int) {
+	status = blockchain.Iterate(func(block *Block) (statusI int) {
+		blockReleases, err := decodeBlockRecordReleases(block.RecordsRaw)
+		if err != nil {
+			return StatusCorruptBlockRecord
+		}
+
+		releases = append(releases, blockReleases...)
+
+		return StatusOK
+	})
+
+	return releases, status
+}
+
+// ReleasePublish publishes the release manifest. The release file should be stored in the warehouse so that peers can download it. Status is StatusX.
+func (blockchain *Blockchain) ReleasePublish(release BlockRecordRelease) (newHeight, newVersion uint64, status int) {
+	encoded, err := encodeBlockRecordRelease(release)
+	if err != nil {
+		return 0, 0, StatusCorruptBlockRecord
+	}
+
+	return blockchain.Append([]BlockRecordRaw{encoded})
+}
@@ -0,0 +1,94 @@
+/*
+File Username:  Sub Key.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package blockchain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// InitSubKey initializes the given blockchain of the owner with a sub-key instead of the owner's private key. New blocks are signed by the
+// sub-key and carry the sub-key record, see SubKeySign. Appending fails with StatusNotAuthorized once the sub-key is expired.
+func InitSubKey(subKeyPrivate *btcec.PrivateKey, subKey BlockRecordSubKey, path string) (blockchain *Blockchain, err error) {
+	if subKey.SubKey == nil || !subKey.SubKey.IsEqual(subKeyPrivate.PubKey()) {
+		return nil, errors.New("sub-key record does not match the private key")
+	}
+
+	if err = subKey.Verify(); err != nil {
+		return nil, err
+	}
+
+	if blockchain, err = Init(subKeyPrivate, path); err != nil {
+		return blockchain, err
+	}
+
+	// The header on disk is signed by the sub-key. The blockchain itself is the owner's.
+	blockchain.publicKey = subKey.Owner
+	blockchain.subKey = &subKey
+
+	return blockchain, nil
+}
+
+// SubKeyAdd issues a sub-key record and publishes it on the blockchain. The sub-key may sign up to maxBlocks blocks after the block
+// containing the record. The returned record must be provided to InitSubKey by the holder of the sub-key. Sub-keys cannot issue other
+// sub-keys. Status is StatusX.
+func (blockchain *Blockchain) SubKeyAdd(subKey *btcec.PublicKey, expiry time.Time, maxBlocks uint64) (record BlockRecordSubKey, newHeight, newVersion uint64, status int) {
+	if blockchain.subKey != nil {
+		return record, 0, 0, StatusNotAuthorized
+	}
+
+	_, height, _ := blockchain.Header()
+
+	record, err := SubKeySign(blockchain.privateKey, subKey, expiry, height+1+maxBlocks)
+	if err != nil {
+		return record, 0, 0, StatusCorruptBlockRecord
+	}
+
+	encoded, err := encodeBlockRecordSubKey(record)
+	if err != nil {
+		return record, 0, 0, StatusCorruptBlockRecord
+	}
+
+	newHeight, newVersion, status = blockchain.Append([]BlockRecordRaw{encoded})
+
+	return record, newHeight, newVersion, status
+}
+
+// SubKeyRevoke publishes a revocation of the sub-key. Blocks signed by the sub-key starting at the block containing the revocation are
+// invalid. Only the owner can revoke sub-keys. Status is StatusX.
+func (blockchain *Blockchain) SubKeyRevoke(subKey *btcec.PublicKey) (newHeight, newVersion uint64, status int) {
+	if blockchain.subKey != nil {
+		return 0, 0, StatusNotAuthorized
+	}
+
+	_, height, _ := blockchain.Header()
+
+	encoded, err := encodeBlockRecordSubKeyRevoke(BlockRecordSubKeyRevoke{SubKey: subKey, Block: height})
+	if err != nil {
+		return 0, 0, StatusCorruptBlockRecord
+	}
+
+	return blockchain.Append([]BlockRecordRaw{encoded})
+}
+
+// SubKeyList lists all sub-key records published on the blockchain including expired ones. Status is StatusX.
+func (blockchain *Blockchain) SubKeyList() (subKeys []BlockRecordSubKey, status int) {
+	status = blockchain.Iterate(func(block *Block) (statusI int) {
+		blockSubKeys, err := decodeBlockRecordSubKeys(block.RecordsRaw)
+		if err != nil {
+			return StatusCorruptBlockRecord
+		}
+
+		subKeys = append(subKeys, blockSubKeys...)
+
+		return StatusOK
+	})
+
+	return subKeys, status
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/hex"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/PeernetOfficial/core/btcec"
 	"github.com/PeernetOfficial/core/merkle"
 	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/store"
 	"github.com/google/uuid"
 )
 
@@ -262,3 +264,441 @@ func printProfileField(field BlockRecordProfile) {
 
 const testTypeText = 1
 const testFormatText = 10
+
+func TestProfileMerge(t *testing.T) {
+	var recordsRaw []BlockRecordRaw
+
+	profile, _ := encodeBlockRecordProfile([]BlockRecordProfile{ProfileFieldFromText(ProfileName, "Test User 1"), ProfileFieldFromText(ProfileWebsite, "peernet.org")})
+	recordsRaw = append(recordsRaw, profile...)
+
+	for _, update := range []BlockRecordProfileUpdate{
+		{Action: ProfileUpdateSet, Type: ProfileEmail, Data: []byte("test@test.com")},
+		{Action: ProfileUpdateDelete, Type: ProfileName},
+		{Action: ProfileUpdateSet, Type: ProfileWebsite, Data: []byte("example.com")},
+		{Action: 7, Type: ProfileWebsite}, // unknown action is ignored
+	} {
+		record, err := encodeBlockRecordProfileUpdate(update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recordsRaw = append(recordsRaw, record)
+	}
+
+	fields := make(map[uint16][]byte)
+	if err := ProfileMerge(fields, recordsRaw); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fields) != 2 || string(fields[ProfileEmail]) != "test@test.com" || string(fields[ProfileWebsite]) != "example.com" {
+		t.Fatalf("invalid merged profile: %v", fields)
+	}
+
+	// A later full profile record replaces the updated field.
+	profile, _ = encodeBlockRecordProfile([]BlockRecordProfile{ProfileFieldFromText(ProfileEmail, "new@test.com")})
+	if err := ProfileMerge(fields, profile); err != nil || string(fields[ProfileEmail]) != "new@test.com" {
+		t.Fatal("profile record did not replace the field")
+	}
+
+	if _, err := encodeBlockRecordProfileUpdate(BlockRecordProfileUpdate{Action: ProfileUpdateDelete, Type: ProfileEmail, Data: []byte{1}}); err == nil {
+		t.Fatal("delete update with data accepted")
+	}
+	if err := ProfileMerge(fields, []BlockRecordRaw{{Type: RecordTypeProfileUpdate, Data: []byte{0, 1}}}); err == nil {
+		t.Fatal("truncated update record accepted")
+	}
+}
+
+func TestReleaseRecord(t *testing.T) {
+	release := BlockRecordRelease{Hash: protocol.HashData([]byte("release")), Size: 1234, Date: time.Unix(1640995200, 0).UTC(), Version: "1.2.0", Platform: "windows/amd64", Notes: "Bug fixes"}
+
+	record, err := encodeBlockRecordRelease(release)
+	if err != nil {
+		t.Fatal(err)
+	} else if uint64(len(record.Data))+blockRecordHeaderSize != release.SizeInBlock() {
+		t.Fatal("invalid record size")
+	}
+
+	releases, err := decodeBlockRecordReleases([]BlockRecordRaw{record})
+	if err != nil || len(releases) != 1 {
+		t.Fatal("decoding failed")
+	}
+
+	decoded := releases[0]
+	if !bytes.Equal(decoded.Hash, release.Hash) || decoded.Size != release.Size || !decoded.Date.Equal(release.Date) || decoded.Version != release.Version || decoded.Platform != release.Platform || decoded.Notes != release.Notes {
+		t.Fatalf("mismatch: %+v", decoded)
+	}
+
+	if _, err := decodeBlockRecordReleases([]BlockRecordRaw{{Type: RecordTypeRelease, Data: record.Data[:49]}}); err == nil {
+		t.Fatal("truncated record accepted")
+	}
+}
+
+func TestDenialRecord(t *testing.T) {
+	denial := BlockRecordDenial{Hash: protocol.HashData([]byte("denied")), Action: DenialRemove, Date: time.Unix(1640995200, 0).UTC(), Reason: "Malware"}
+
+	record, err := encodeBlockRecordDenial(denial)
+	if err != nil {
+		t.Fatal(err)
+	} else if uint64(len(record.Data))+blockRecordHeaderSize != denial.SizeInBlock() {
+		t.Fatal("invalid record size")
+	}
+
+	denials, err := decodeBlockRecordDenials([]BlockRecordRaw{record})
+	if err != nil || len(denials) != 1 {
+		t.Fatal("decoding failed")
+	}
+
+	decoded := denials[0]
+	if !bytes.Equal(decoded.Hash, denial.Hash) || decoded.Action != denial.Action || !decoded.Date.Equal(denial.Date) || decoded.Reason != denial.Reason {
+		t.Fatalf("mismatch: %+v", decoded)
+	}
+
+	if _, err := decodeBlockRecordDenials([]BlockRecordRaw{{Type: RecordTypeDenial, Data: record.Data[:40]}}); err == nil {
+		t.Fatal("truncated record accepted")
+	} else if _, err := encodeBlockRecordDenial(BlockRecordDenial{Hash: denial.Hash, Action: 2}); err == nil {
+		t.Fatal("invalid action accepted")
+	}
+}
+
+func TestCustomRecordType(t *testing.T) {
+	handler := RecordTypeHandler{
+		Name:   "note",
+		Decode: func(data []byte) (value interface{}, err error) { return string(data), nil },
+		Encode: func(value interface{}) (data []byte, err error) { return []byte(value.(string)), nil },
+	}
+
+	if err := RegisterRecordType(RecordTypeRelease, handler); err == nil {
+		t.Fatal("reserved record type accepted")
+	} else if err := RegisterRecordType(200, handler); err != nil {
+		t.Fatal(err)
+	} else if err := RegisterRecordType(200, handler); err == nil {
+		t.Fatal("duplicate record type accepted")
+	}
+
+	records := []BlockRecordRaw{{Type: 200, Data: []byte("hello")}, {Type: 201, Data: []byte("unregistered")}}
+
+	decoded := decodeBlockRecordCustom(records)
+	if len(decoded) != 1 || decoded[0].Type != 200 || decoded[0].Value.(string) != "hello" {
+		t.Fatalf("decoding failed: %+v", decoded)
+	}
+}
+
+func TestAppendBatch(t *testing.T) {
+	blockchain, err := initTestPrivateKey()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	blockchain.BatchWindow = 50 * time.Millisecond
+	_, heightBefore, _ := blockchain.Header()
+
+	heights := make(chan uint64)
+	for n := 0; n < 5; n++ {
+		go func(n int) {
+			newHeight, _, status := blockchain.AppendBatch([]BlockRecordRaw{{Type: 200, Data: []byte{byte(n)}}})
+			if status != StatusOK {
+				newHeight = 0
+			}
+			heights <- newHeight
+		}(n)
+	}
+
+	for n := 0; n < 5; n++ {
+		if height := <-heights; height != heightBefore+1 {
+			t.Fatalf("batch not written as single block: height %d, expected %d", height, heightBefore+1)
+		}
+	}
+
+	block, status, err := blockchain.Read(heightBefore)
+	if status != StatusOK || len(block.Block.RecordsRaw) != 5 {
+		t.Fatalf("invalid batch block: status %d, error %v", status, err)
+	}
+
+	// A single call exceeding the target block size is written immediately.
+	newHeight, _, status := blockchain.AppendBatch([]BlockRecordRaw{{Type: 200, Data: make([]byte, TargetBlockSize)}})
+	if status != StatusOK || newHeight != heightBefore+2 {
+		t.Fatalf("large batch failed: status %d, height %d", status, newHeight)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	blockchain, err := initTestPrivateKey()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for n := 0; n < 10; n++ {
+		file, _ := createBlockRecordFile([]byte{byte(n)}, fmt.Sprintf("Compact %d.txt", n), "compact")
+		if _, _, status := blockchain.AddFiles([]BlockRecordFile{file}); status != StatusOK {
+			t.Fatalf("error adding file: status %d", status)
+		}
+	}
+
+	filesBefore, _ := blockchain.ListFiles()
+	_, heightBefore, versionBefore := blockchain.Header()
+
+	result, status := blockchain.Compact(0, true, nil)
+	if status != StatusOK || result.Written || result.HeightAfter >= heightBefore {
+		t.Fatalf("dry run failed: status %d, result %+v", status, result)
+	}
+	heightPredicted, sizePredicted := result.HeightAfter, result.SizeAfter
+
+	// The shared directory tag data is deduplicated across the merged blocks.
+	result, status = blockchain.Compact(0, false, nil)
+	if status != StatusOK || !result.Written || result.HeightAfter != heightPredicted || result.VersionAfter != versionBefore+1 {
+		t.Fatalf("compaction failed: status %d, result %+v", status, result)
+	} else if result.SizeAfter != sizePredicted || result.SizeAfter >= result.SizeBefore {
+		t.Fatalf("compaction did not save space: result %+v", result)
+	}
+
+	filesAfter, _ := blockchain.ListFiles()
+	if len(filesAfter) != len(filesBefore) {
+		t.Fatalf("file count changed from %d to %d", len(filesBefore), len(filesAfter))
+	}
+
+	// A compacted blockchain cannot be compacted further.
+	if result, status = blockchain.Compact(0, false, nil); status != StatusOK || result.Written {
+		t.Fatalf("repeated compaction rewrote the blockchain: status %d, result %+v", status, result)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+	blockchain, err := InitReadOnly(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, status := blockchain.Append([]BlockRecordRaw{{Type: 200, Data: []byte{1}}}); status != StatusReadOnly {
+		t.Fatalf("append to read-only blockchain returned status %d", status)
+	}
+
+	if _, height, version := blockchain.Header(); height != 0 || version != 0 {
+		t.Fatalf("read-only blockchain changed: height %d version %d", height, version)
+	}
+}
+
+func TestWireSpec(t *testing.T) {
+	sizes := []struct {
+		layout   interface{}
+		expected int
+	}{
+		{wireBlock{}, blockHeaderSize},
+		{wireBlockRecord{}, blockRecordHeaderSize},
+		{wireRecordFile{}, blockRecordFileMinSize},
+		{wireRecordDelegated{}, delegatedRecordHeaderSize},
+		{wireRecordSubKey{}, subKeyRecordSize},
+		{wireRecordSubKeyRevoke{}, subKeyRevokeRecordSize},
+	}
+
+	for _, size := range sizes {
+		if format := protocol.WireLayout("", "", -1, "", size.layout); format.MinSize != size.expected {
+			t.Errorf("layout %T size %d, expected %d", size.layout, format.MinSize, size.expected)
+		}
+	}
+}
+
+func TestDelegatedRecord(t *testing.T) {
+	ownerKey, _ := btcec.NewPrivateKey(btcec.S256())
+	delegateKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+	record, err := DelegatedRecordSign(delegateKey, ownerKey.PubKey(), BlockRecordRaw{Type: 200, Data: []byte("bot")})
+	if err != nil {
+		t.Fatal(err)
+	} else if !verifyDelegatedRecord(record, ownerKey.PubKey()) {
+		t.Fatal("valid delegated record rejected")
+	}
+
+	// The record is wrapped when encoding the block and unwrapped when decoding it.
+	raw, err := encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{record, {Type: 201, Data: []byte("owner")}}}, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := decodeBlock(raw)
+	if err != nil || len(block.RecordsRaw) != 2 {
+		t.Fatalf("decoding block failed: %v", err)
+	}
+
+	decoded := block.RecordsRaw[0]
+	if decoded.Type != 200 || !bytes.Equal(decoded.Data, record.Data) || decoded.Delegate == nil || !decoded.Delegate.IsEqual(delegateKey.PubKey()) {
+		t.Fatalf("delegated record mismatch: %+v", decoded)
+	} else if block.RecordsRaw[1].Delegate != nil {
+		t.Fatal("record of the owner has a delegate")
+	}
+
+	// The signature is bound to the owner and the data.
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+	tampered := record
+	tampered.Data = []byte("tampered")
+
+	if verifyDelegatedRecord(record, otherKey.PubKey()) || verifyDelegatedRecord(tampered, ownerKey.PubKey()) {
+		t.Fatal("invalid delegated record accepted")
+	} else if _, err := DelegatedRecordSign(delegateKey, ownerKey.PubKey(), BlockRecordRaw{Type: RecordTypeTagData}); err == nil {
+		t.Fatal("tag data record delegated")
+	}
+}
+
+func TestDelegation(t *testing.T) {
+	blockchain, err := initTestPrivateKey()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	delegateKey, _ := btcec.NewPrivateKey(btcec.S256())
+	publicKey, _, _ := blockchain.Header()
+
+	record, _ := DelegatedRecordSign(delegateKey, publicKey, BlockRecordRaw{Type: 200, Data: []byte("bot")})
+	group, _ := DelegatedRecordSign(delegateKey, publicKey, BlockRecordRaw{Type: RecordTypeGroup, Data: make([]byte, 18)})
+
+	if _, _, status := blockchain.DelegatedAppend([]BlockRecordRaw{record}); status != StatusNotAuthorized {
+		t.Fatalf("record without delegation returned status %d", status)
+	}
+
+	if _, _, status := blockchain.DelegationAdd(BlockRecordDelegation{Delegate: delegateKey.PubKey(), Expiry: time.Now().Add(time.Hour), Scope: []uint8{200}}); status != StatusOK {
+		t.Fatalf("adding delegation failed: status %d", status)
+	}
+
+	if _, _, status := blockchain.DelegatedAppend([]BlockRecordRaw{group}); status != StatusNotAuthorized {
+		t.Fatalf("record out of scope returned status %d", status)
+	}
+
+	newHeight, _, status := blockchain.DelegatedAppend([]BlockRecordRaw{record})
+	if status != StatusOK {
+		t.Fatalf("delegated append failed: status %d", status)
+	}
+
+	block, status, err := blockchain.Read(newHeight - 1)
+	if status != StatusOK || len(block.RecordsRaw) != 1 || block.RecordsRaw[0].Delegate == nil || !block.RecordsRaw[0].Delegate.IsEqual(delegateKey.PubKey()) {
+		t.Fatalf("reading delegated record failed: status %d, error %v", status, err)
+	}
+
+	if _, _, status := blockchain.DelegationRevoke(delegateKey.PubKey()); status != StatusOK {
+		t.Fatalf("revoking delegation failed: status %d", status)
+	} else if _, _, status := blockchain.DelegatedAppend([]BlockRecordRaw{record}); status != StatusNotAuthorized {
+		t.Fatalf("record of revoked delegate returned status %d", status)
+	}
+}
+
+func TestSubKey(t *testing.T) {
+	ownerKey, _ := btcec.NewPrivateKey(btcec.S256())
+	subKeyPrivate, _ := btcec.NewPrivateKey(btcec.S256())
+
+	subKey, err := SubKeySign(ownerKey, subKeyPrivate.PubKey(), time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := SubKeySign(ownerKey, ownerKey.PubKey(), time.Now().Add(time.Hour), 10); err == nil {
+		t.Fatal("owner key accepted as sub-key")
+	}
+
+	// A block signed by the sub-key is attributed to the owner.
+	raw, err := encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{{Type: 200, Data: []byte("mobile")}}, SubKey: &subKey}, subKeyPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := decodeBlock(raw)
+	if err != nil {
+		t.Fatalf("decoding block signed by sub-key failed: %v", err)
+	} else if !block.OwnerPublicKey.IsEqual(ownerKey.PubKey()) || block.SubKey == nil || !block.SubKey.SubKey.IsEqual(subKeyPrivate.PubKey()) {
+		t.Fatal("block signed by sub-key not attributed to the owner")
+	} else if len(block.RecordsRaw) != 1 || block.RecordsRaw[0].Type != 200 {
+		t.Fatalf("records mismatch: %+v", block.RecordsRaw)
+	}
+
+	// A sub-key record published by the owner is a regular record.
+	published, _ := encodeBlockRecordSubKey(subKey)
+	raw, _ = encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{published}}, ownerKey)
+
+	if block, err := decodeBlock(raw); err != nil || block.SubKey != nil || !block.OwnerPublicKey.IsEqual(ownerKey.PubKey()) || len(block.RecordsRaw) != 1 {
+		t.Fatalf("decoding block with published sub-key failed: %v", err)
+	}
+
+	// Expired sub-keys cannot sign new blocks.
+	expired, _ := SubKeySign(ownerKey, subKeyPrivate.PubKey(), time.Now().Add(-time.Hour), 10)
+	if _, err := encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{{Type: 200}}, SubKey: &expired}, subKeyPrivate); err == nil {
+		t.Fatal("expired sub-key signed a block")
+	}
+
+	// Blocks signed before the expiry remain valid. The sub-key record is encoded as first record manually, since encodeBlock refuses expired sub-keys.
+	expiredRecord, _ := encodeBlockRecordSubKey(expired)
+	raw, _ = encodeBlock(&Block{Number: 9, LastBlockHash: make([]byte, 32), RecordsRaw: []BlockRecordRaw{expiredRecord, {Type: 200, Date: time.Now().Add(-2 * time.Hour)}}}, subKeyPrivate)
+
+	if block, err := decodeBlock(raw); err != nil || !block.OwnerPublicKey.IsEqual(ownerKey.PubKey()) {
+		t.Fatalf("block signed before the sub-key expired rejected: %v", err)
+	}
+
+	// Backdated blocks beyond the max block number set by the owner are rejected, regardless of the record dates.
+	raw, _ = encodeBlock(&Block{Number: 10, LastBlockHash: make([]byte, 32), RecordsRaw: []BlockRecordRaw{expiredRecord, {Type: 200, Date: time.Now().Add(-2 * time.Hour)}}}, subKeyPrivate)
+
+	if _, err := decodeBlock(raw); err == nil {
+		t.Fatal("backdated block beyond the max block number of the sub-key accepted")
+	}
+
+	if _, err := encodeBlock(&Block{Number: 10, LastBlockHash: make([]byte, 32), RecordsRaw: []BlockRecordRaw{{Type: 200}}, SubKey: &subKey}, subKeyPrivate); err == nil {
+		t.Fatal("sub-key signed a block beyond its max block number")
+	}
+
+	// Blocks signed by a forged sub-key are rejected.
+	forged := subKey
+	forged.Expiry = subKey.Expiry.Add(24 * time.Hour)
+	raw, _ = encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{{Type: 200}}, SubKey: &forged}, subKeyPrivate)
+
+	if _, err := decodeBlock(raw); err == nil {
+		t.Fatal("block signed by forged sub-key accepted")
+	}
+}
+
+func TestSubKeyRevoke(t *testing.T) {
+	ownerKey, _ := btcec.NewPrivateKey(btcec.S256())
+	subKeyPrivate, _ := btcec.NewPrivateKey(btcec.S256())
+
+	subKey, _ := SubKeySign(ownerKey, subKeyPrivate.PubKey(), time.Now().Add(time.Hour), 100)
+	revoke, _ := encodeBlockRecordSubKeyRevoke(BlockRecordSubKeyRevoke{SubKey: subKeyPrivate.PubKey(), Block: 3})
+
+	blockRaw := func(number uint64, signer *btcec.PrivateKey, records ...BlockRecordRaw) []byte {
+		block := &Block{Number: number, LastBlockHash: make([]byte, 32), RecordsRaw: records}
+		if signer == subKeyPrivate {
+			block.SubKey = &subKey
+		}
+		raw, err := encodeBlock(block, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	// A revocation in a block signed by the sub-key is not decoded.
+	if decoded, _, err := DecodeBlockRaw(blockRaw(0, subKeyPrivate, revoke)); err != nil || len(decoded.RecordsDecoded) != 0 {
+		t.Fatalf("revocation in block signed by the sub-key decoded: %v", err)
+	}
+
+	multi := InitMultiStoreWithStore(store.NewMemoryStore(), "")
+	header, _ := multi.NewBlockchainHeader(ownerKey.PubKey(), 0, 10)
+
+	// Blocks signed by the sub-key are stored at their own block number only.
+	if _, err := multi.IngestBlock(header, 1, blockRaw(2, subKeyPrivate, BlockRecordRaw{Type: 200}), true); err == nil {
+		t.Fatal("block signed by the sub-key accepted at a different block number")
+	}
+	if _, err := multi.IngestBlock(header, 2, blockRaw(2, subKeyPrivate, BlockRecordRaw{Type: 200}), true); err != nil {
+		t.Fatalf("block signed by the sub-key rejected: %v", err)
+	}
+	if _, err := multi.IngestBlock(header, 5, blockRaw(5, subKeyPrivate, BlockRecordRaw{Type: 200}), true); err != nil {
+		t.Fatalf("block signed by the sub-key rejected: %v", err)
+	}
+
+	// The revocation removes stored blocks signed by the sub-key starting at the block number.
+	if _, err := multi.IngestBlock(header, 3, blockRaw(3, ownerKey, revoke), true); err != nil {
+		t.Fatalf("revocation rejected: %v", err)
+	}
+	if _, found := multi.ReadBlock(header.PublicKey, header.Version, 5); found {
+		t.Fatal("block signed by the revoked sub-key not removed")
+	} else if _, found := multi.ReadBlock(header.PublicKey, header.Version, 2); !found {
+		t.Fatal("block signed before the revocation removed")
+	}
+
+	// New blocks of the revoked sub-key are rejected, even if backdated.
+	if _, err := multi.IngestBlock(header, 4, blockRaw(4, subKeyPrivate, BlockRecordRaw{Type: 200, Date: time.Now().Add(-24 * time.Hour)}), true); err == nil {
+		t.Fatal("block signed by the revoked sub-key accepted")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+File Username:  Wire Spec.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Layouts of blocks and block records for the wire format specification. See protocol.WireLayout.
+When changing an encoding, the layout here must be updated as well.
+*/
+
+package blockchain
+
+import "github.com/PeernetOfficial/core/protocol"
+
+type wireBlock struct {
+	Signature         [65]byte `wire:"Signature of entire block"`
+	LastBlockHash     [32]byte `wire:"Hash (blake3) of last block. 0 for first one."`
+	BlockchainVersion uint64   `wire:"Blockchain version number"`
+	Number            uint64   `wire:"Block number"`
+	Size              uint32   `wire:"Size of entire block including this header"`
+	CountRecords      uint16   `wire:"Count of records that follow"`
+	Records           []byte   `wire:"Records, see Block Record" size:"Size of entire block - 119"`
+}
+
+type wireBlockRecord struct {
+	Type uint8  `wire:"Record type, see RecordTypeX"`
+	Date uint64 `wire:"Date created, Unix time in seconds. This remains the same in case of block refactoring."`
+	Size uint32 `wire:"Size of data"`
+	Data []byte `wire:"Data, encoding depends on the record type" size:"Size of data"`
+}
+
+type wireRecordProfile struct {
+	Type uint16 `wire:"Profile field type, see ProfileX"`
+	Data []byte `wire:"Data according to the type" size:"Size of the record data - 2"`
+}
+
+type wireRecordTagData struct {
+	Data []byte `wire:"Raw data referenced by tags of file records in the same block" size:"Size of the record data"`
+}
+
+type wireRecordFile struct {
+	Hash           [32]byte `wire:"Hash blake3 of the file content"`
+	ID             [16]byte `wire:"File ID"`
+	MerkleRootHash [32]byte `wire:"Merkle root hash"`
+	FragmentSize   uint64   `wire:"Fragment size"`
+	Type           uint8    `wire:"File type"`
+	Format         uint16   `wire:"File format"`
+	Size           uint64   `wire:"File size"`
+	CountTags      uint16   `wire:"Count of tags"`
+	Tags           []byte   `wire:"Tags, see File Tag" size:"Count of tags"`
+}
+
+type wireFileTag struct {
+	Type uint16 `wire:"Tag type, see TagX. If the top bit is set, the data references a tag data record in the same block."`
+	Size uint32 `wire:"Size of data that follows"`
+	Data []byte `wire:"Data according to the tag type. References are 2, 4, or 8 bytes: the distance to the raw record." size:"Size of data"`
+}
+
+type wireRecordGroup struct {
+	GroupID      [16]byte `wire:"Group ID"`
+	CountMembers uint16   `wire:"Count of members"`
+	Members      []byte   `wire:"Public keys of the members (compressed)" size:"Count of members * 33"`
+	Name         string   `wire:"Name of the group (UTF-8)" size:"Remaining record data"`
+}
+
+type wireRecordProfileUpdate struct {
+	Action uint8  `wire:"Action: 0 = Set (add or replace), 1 = Delete"`
+	Type   uint16 `wire:"Profile field type, see ProfileX"`
+	Data   []byte `wire:"Data according to the type. Only for Set." size:"Size of the record data - 3"`
+}
+
+type wireRecordRelease struct {
+	Hash           [32]byte `wire:"Blake3 hash of the release file"`
+	Size           uint64   `wire:"Size of the release file"`
+	Date           uint64   `wire:"Date of the release, Unix time in seconds"`
+	VersionLength  uint8    `wire:"Length of the version"`
+	PlatformLength uint8    `wire:"Length of the platform"`
+	Version        string   `wire:"Version, for example 1.2.0" size:"Length of the version"`
+	Platform       string   `wire:"Platform as GOOS/GOARCH. Empty for any platform." size:"Length of the platform"`
+	Notes          string   `wire:"Release notes (UTF-8)" size:"Remaining record data"`
+}
+
+type wireRecordDenial struct {
+	Hash   [32]byte `wire:"Blake3 hash of the denied content"`
+	Action uint8    `wire:"Action: 0 = Add the hash to the list, 1 = Remove the hash from the list"`
+	Date   uint64   `wire:"Date of the entry, Unix time in seconds"`
+	Reason string   `wire:"Reason (UTF-8), optional" size:"Remaining record data"`
+}
+
+type wireRecordDelegation struct {
+	Delegate   [33]byte `wire:"Public key of the delegate (compressed)"`
+	Expiry     uint64   `wire:"Expiry, Unix time in seconds"`
+	CountScope uint8    `wire:"Count of record types in scope"`
+	Scope      []byte   `wire:"Record types the delegate may create" size:"Count of record types in scope"`
+}
+
+type wireRecordDelegated struct {
+	Signature [65]byte `wire:"Signature by the delegate of the owner's public key (compressed) followed by the remaining data"`
+	Type      uint8    `wire:"Record type of the inner record"`
+	Date      uint64   `wire:"Date of the inner record, Unix time in seconds"`
+	Data      []byte   `wire:"Data of the inner record" size:"Remaining record data"`
+}
+
+type wireRecordSubKey struct {
+	Owner     [33]byte `wire:"Public key of the owner (compressed)"`
+	SubKey    [33]byte `wire:"Public key of the sub-key (compressed)"`
+	Expiry    uint64   `wire:"Expiry, Unix time in seconds"`
+	MaxBlock  uint64   `wire:"Max block number (exclusive) the sub-key may sign"`
+	Signature [65]byte `wire:"Signature by the owner of the previous fields"`
+}
+
+type wireRecordSubKeyRevoke struct {
+	SubKey [33]byte `wire:"Public key of the revoked sub-key (compressed)"`
+	Block  uint64   `wire:"First block number that the sub-key may no longer sign"`
+}
+
+func init() {
+	protocol.RegisterWireFormat(
+		protocol.WireLayout("Block", protocol.WireCategoryRecord, -1, "Encoding of a block. It is the same stored in the database and shared via Get Block.", wireBlock{}),
+		protocol.WireLayout("Block Record", protocol.WireCategoryRecord, -1, "Basic structure of each record inside a block.", wireBlockRecord{}),
+		protocol.WireLayout("Profile Record", protocol.WireCategoryRecord, RecordTypeProfile, "Profile data about the end user.", wireRecordProfile{}),
+		protocol.WireLayout("Tag Data Record", protocol.WireCategoryRecord, RecordTypeTagData, "Tag data referenced by tags of file records. Only valid in the context of the current block.", wireRecordTagData{}),
+		protocol.WireLayout("File Record", protocol.WireCategoryRecord, RecordTypeFile, "Metadata of a published file.", wireRecordFile{}),
+		protocol.WireLayout("File Tag", protocol.WireCategoryRecord, -1, "Tag providing additional information in a file record.", wireFileTag{}),
+		protocol.WireLayout("Group Record", protocol.WireCategoryRecord, RecordTypeGroup, "Membership of a group channel owned by the blockchain owner.", wireRecordGroup{}),
+		protocol.WireLayout("Profile Update Record", protocol.WireCategoryRecord, RecordTypeProfileUpdate, "Field-level update of the profile.", wireRecordProfileUpdate{}),
+		protocol.WireLayout("Release Record", protocol.WireCategoryRecord, RecordTypeRelease, "Manifest of a software release.", wireRecordRelease{}),
+		protocol.WireLayout("Denial Record", protocol.WireCategoryRecord, RecordTypeDenial, "Entry of a denial list published by a list maintainer.", wireRecordDenial{}),
+		protocol.WireLayout("Delegation Record", protocol.WireCategoryRecord, RecordTypeDelegation, "Authorization of a delegate key to create records of the types in scope on behalf of the owner.", wireRecordDelegation{}),
+		protocol.WireLayout("Delegated Record", protocol.WireCategoryRecord, RecordTypeDelegated, "Record created by a delegate, wrapping the inner record.", wireRecordDelegated{}),
+		protocol.WireLayout("Sub-Key Record", protocol.WireCategoryRecord, RecordTypeSubKey, "Authorization of a sub-key to sign blocks on behalf of the owner. Blocks signed by the sub-key start with a copy of it.", wireRecordSubKey{}),
+		protocol.WireLayout("Sub-Key Revocation Record", protocol.WireCategoryRecord, RecordTypeSubKeyRevoke, "Revocation of a sub-key. Only valid in blocks signed by the owner.", wireRecordSubKeyRevoke{}),
+	)
+}
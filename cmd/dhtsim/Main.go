@@ -0,0 +1,345 @@
+/*
+File Username:  Main.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Benchmark and simulation harness for the DHT. It spins up hundreds of in-process DHT nodes connected via a simulated network and measures
+lookup hop counts, latency, message count, and success rate, optionally under churn. The numbers serve as regression baseline for routing changes.
+
+Usage: dhtsim [-nodes 200] [-lookups 1000] [-values 100] [-concurrency 16] [-latency 50ms] [-jitter 10ms] [-timeout-ir 1s] [-timeout 10s]
+              [-offline 0] [-churn 0] [-churn-interval 1s] [-seed 0] [-json file] [-min-success 0] [-iterative] [-beta 0]
+
+The process exits with status 1 if the success rate of any lookup type is below -min-success.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/dht"
+)
+
+func main() {
+	var nodeCount, lookups, values, concurrency int
+	var settings simSettings
+	var offline, churn, minSuccess float64
+	var churnInterval time.Duration
+	var seed int64
+	var jsonFile string
+
+	flag.IntVar(&nodeCount, "nodes", 200, "Count of DHT nodes")
+	flag.IntVar(&lookups, "lookups", 1000, "Count of FIND_NODE lookups for random online nodes")
+	flag.IntVar(&values, "values", 100, "Count of values to store. Each value is looked up once via FIND_VALUE.")
+	flag.IntVar(&concurrency, "concurrency", 16, "Count of concurrent lookups")
+	flag.DurationVar(&settings.Latency, "latency", 50*time.Millisecond, "Max one-way access latency per node")
+	flag.DurationVar(&settings.Jitter, "jitter", 10*time.Millisecond, "Max random additional delay per message")
+	flag.DurationVar(&settings.TimeoutIR, "timeout-ir", time.Second, "Timeout of information requests")
+	flag.DurationVar(&settings.Timeout, "timeout", 10*time.Second, "Timeout of an entire lookup")
+	flag.Float64Var(&offline, "offline", 0, "Fraction of nodes that go offline after bootstrapping, leaving stale routing table entries")
+	flag.Float64Var(&churn, "churn", 0, "Fraction of online nodes replaced each churn interval: they go offline and the same count of offline nodes rejoin")
+	flag.DurationVar(&churnInterval, "churn-interval", time.Second, "Churn interval")
+	flag.Int64Var(&seed, "seed", 0, "Random seed. 0 = time based.")
+	flag.StringVar(&jsonFile, "json", "", "File to write the results as JSON for regression comparison")
+	flag.Float64Var(&minSuccess, "min-success", 0, "Min success rate (0-1) of each lookup type")
+	flag.BoolVar(&settings.Iterative, "iterative", false, "Use the iterative lookup instead of the search client")
+	flag.IntVar(&settings.Beta, "beta", 0, "Count of closest nodes that must have replied for an iterative lookup to converge. 0 = bucket size.")
+	flag.Parse()
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if nodeCount < 2 || concurrency < 1 {
+		fmt.Println("At least 2 nodes and a concurrency of 1 are required.")
+		os.Exit(1)
+	}
+
+	fmt.Printf("DHT simulation: seed %d, %d nodes, latency %s, jitter %s, timeout IR %s, offline %.2f, churn %.2f per %s\n", seed, nodeCount, settings.Latency, settings.Jitter, settings.TimeoutIR, offline, churn, churnInterval)
+	if settings.Iterative {
+		fmt.Printf("Iterative lookups: alpha %d, beta %d\n", alpha, settings.Beta)
+	}
+
+	network := newSimNetwork(settings, seed)
+
+	// Bootstrap without latency. Each node joins via a random node that already joined.
+	started := time.Now()
+	atomic.StoreInt32(&network.noDelay, 1)
+
+	for n := 0; n < nodeCount; n++ {
+		network.newNode()
+	}
+
+	refreshBits := int(math.Ceil(math.Log2(float64(nodeCount)))) + 2
+	for n, node := range network.nodes {
+		var seedNode *simNode
+		if n > 0 {
+			seedNode = network.nodes[network.randomInt(n)]
+		}
+		node.join(seedNode, refreshBits)
+	}
+
+	// Store the values at the closest nodes.
+	keys := make([][]byte, values)
+	origins := make([]*simNode, values)
+	for n := range keys {
+		keys[n] = network.randomID()
+		origins[n] = network.nodes[network.randomInt(nodeCount)]
+
+		origins[n].Lock()
+		origins[n].values[string(keys[n])] = []byte("value")
+		origins[n].Unlock()
+
+		origins[n].dht.Store(keys[n], 5, respondClosestContactsCount)
+	}
+
+	atomic.StoreInt32(&network.noDelay, 0)
+	fmt.Printf("Bootstrap finished in %s. Average routing table size %.1f nodes.\n", time.Since(started).Round(time.Millisecond), network.averageTableSize())
+
+	// At least 2 nodes stay online.
+	for n := 0; n < int(offline*float64(nodeCount)) && n < nodeCount-2; n++ {
+		network.randomOnlineNode().leave()
+	}
+
+	// Churn in the background
+	terminate := make(chan struct{})
+	if churn > 0 {
+		go network.churn(churn, churnInterval, terminate)
+	}
+
+	atomic.StoreUint64(&network.messages, 0)
+	started = time.Now()
+
+	resultNode := network.benchmark(dht.ActionFindNode, lookups, concurrency, nil, nil)
+	resultValue := network.benchmark(dht.ActionFindValue, values, concurrency, keys, origins)
+
+	close(terminate)
+
+	fmt.Printf("Lookups finished in %s. %d messages sent.\n", time.Since(started).Round(time.Millisecond), atomic.LoadUint64(&network.messages))
+	resultNode.print()
+	resultValue.print()
+
+	if jsonFile != "" {
+		data, _ := json.MarshalIndent([]benchmarkResult{resultNode, resultValue}, "", "    ")
+		if err := os.WriteFile(jsonFile, data, 0644); err != nil {
+			fmt.Printf("Error writing results to '%s': %s\n", jsonFile, err.Error())
+		}
+	}
+
+	if (resultNode.Lookups > 0 && resultNode.SuccessRate < minSuccess) || (resultValue.Lookups > 0 && resultValue.SuccessRate < minSuccess) {
+		os.Exit(1)
+	}
+}
+
+// benchmarkResult contains the measured numbers of one lookup type.
+type benchmarkResult struct {
+	Action       string  `json:"action"`       // FIND_NODE or FIND_VALUE
+	Lookups      int     `json:"lookups"`      // Count of lookups
+	Succeeded    int     `json:"succeeded"`    // Count of successful lookups
+	SuccessRate  float64 `json:"successrate"`  // Succeeded / Lookups
+	HopsMean     float64 `json:"hopsmean"`     // Mean count of sequential request rounds of successful lookups
+	HopsP50      int     `json:"hopsp50"`      // Median hops
+	HopsP90      int     `json:"hopsp90"`      // 90th percentile hops
+	HopsMax      int     `json:"hopsmax"`      // Max hops
+	LatencyMean  float64 `json:"latencymean"`  // Mean duration of successful lookups in ms
+	LatencyP50   float64 `json:"latencyp50"`   // Median duration in ms
+	LatencyP90   float64 `json:"latencyp90"`   // 90th percentile duration in ms
+	LatencyP99   float64 `json:"latencyp99"`   // 99th percentile duration in ms
+	MessagesMean float64 `json:"messagesmean"` // Mean count of requests sent per lookup, including failed ones
+}
+
+func (result *benchmarkResult) print() {
+	if result.Lookups == 0 {
+		return
+	}
+
+	fmt.Printf("%s: %d of %d succeeded (%.1f%%). Hops mean %.2f, p50 %d, p90 %d, max %d. Latency mean %.0f ms, p50 %.0f ms, p90 %.0f ms, p99 %.0f ms. Requests per lookup %.1f.\n",
+		result.Action, result.Succeeded, result.Lookups, result.SuccessRate*100, result.HopsMean, result.HopsP50, result.HopsP90, result.HopsMax,
+		result.LatencyMean, result.LatencyP50, result.LatencyP90, result.LatencyP99, result.MessagesMean)
+}
+
+// benchmark runs the lookups with the given concurrency. For FIND_NODE the targets are random online nodes. For FIND_VALUE the keys are looked up once each.
+func (network *simNetwork) benchmark(action, count, concurrency int, keys [][]byte, origins []*simNode) (result benchmarkResult) {
+	result.Action = "FIND_NODE"
+	if action == dht.ActionFindValue {
+		result.Action = "FIND_VALUE"
+	}
+
+	var mutex sync.Mutex
+	var hops []int
+	var latencies []float64
+	var messages uint64
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				requester := network.randomOnlineNode()
+
+				var key []byte
+				if action == dht.ActionFindNode {
+					key = network.randomOnlineNode().id
+				} else if origins[job].isOnline() {
+					key = keys[job]
+				} else {
+					continue // The origin is offline due to churn, the value is not available.
+				}
+
+				found, hopCount, messageCount, duration := requester.lookup(action, key)
+
+				mutex.Lock()
+				result.Lookups++
+				messages += messageCount
+				if found {
+					result.Succeeded++
+					hops = append(hops, hopCount)
+					latencies = append(latencies, float64(duration)/float64(time.Millisecond))
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for n := 0; n < count; n++ {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+
+	if result.Lookups == 0 {
+		return result
+	}
+
+	result.SuccessRate = float64(result.Succeeded) / float64(result.Lookups)
+	result.MessagesMean = float64(messages) / float64(result.Lookups)
+
+	if len(hops) > 0 {
+		sort.Ints(hops)
+		sort.Float64s(latencies)
+
+		sumHops, sumLatency := 0, 0.0
+		for n := range hops {
+			sumHops += hops[n]
+			sumLatency += latencies[n]
+		}
+
+		result.HopsMean = float64(sumHops) / float64(len(hops))
+		result.HopsP50 = hops[percentileIndex(len(hops), 0.5)]
+		result.HopsP90 = hops[percentileIndex(len(hops), 0.9)]
+		result.HopsMax = hops[len(hops)-1]
+		result.LatencyMean = sumLatency / float64(len(latencies))
+		result.LatencyP50 = latencies[percentileIndex(len(latencies), 0.5)]
+		result.LatencyP90 = latencies[percentileIndex(len(latencies), 0.9)]
+		result.LatencyP99 = latencies[percentileIndex(len(latencies), 0.99)]
+	}
+
+	return result
+}
+
+func percentileIndex(count int, percentile float64) int {
+	index := int(math.Ceil(float64(count)*percentile)) - 1
+	if index < 0 {
+		index = 0
+	}
+	return index
+}
+
+// lookup performs a single lookup and returns whether the node or value was found, the hop count, the count of requests sent, and the duration.
+func (node *simNode) lookup(action int, key []byte) (found bool, hops int, messages uint64, duration time.Duration) {
+	node.traceStart(key)
+	started := time.Now()
+
+	var senderID []byte
+
+	if node.network.settings.Iterative {
+		result, _ := node.dht.IterativeLookup(action, key, alpha, node.network.settings.Beta)
+		duration = time.Since(started)
+
+		if found = result != nil && result.Found; found {
+			senderID = result.SenderID
+		}
+	} else {
+		search := node.dht.NewSearch(action, key, node.network.settings.Timeout, node.network.settings.TimeoutIR, alpha)
+		search.SearchAway()
+
+		var result *dht.SearchResult
+		result, found = <-search.Results
+		duration = time.Since(started)
+
+		if found {
+			senderID = result.SenderID
+		}
+	}
+
+	hops, messages = node.traceEnd(key, senderID)
+
+	return found, hops, messages, duration
+}
+
+func (network *simNetwork) randomOnlineNode() *simNode {
+	for {
+		if node := network.nodes[network.randomInt(len(network.nodes))]; node.isOnline() {
+			return node
+		}
+	}
+}
+
+func (network *simNetwork) averageTableSize() float64 {
+	total := 0
+	for _, node := range network.nodes {
+		total += node.dht.NumNodes()
+	}
+	return float64(total) / float64(len(network.nodes))
+}
+
+// churn replaces the fraction of online nodes each interval: they go offline and the same count of offline nodes rejoin via a random online node.
+func (network *simNetwork) churn(fraction float64, interval time.Duration, terminate <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-terminate:
+			return
+		case <-ticker.C:
+		}
+
+		var offline []*simNode
+		online := 0
+		for _, node := range network.nodes {
+			if node.isOnline() {
+				online++
+			} else {
+				offline = append(offline, node)
+			}
+		}
+
+		count := int(fraction * float64(online))
+		if count > online-2 {
+			count = online - 2
+		}
+
+		for n := 0; n < count; n++ {
+			network.randomOnlineNode().leave()
+		}
+
+		for n := 0; n < count && n < len(offline); n++ {
+			swap := n + network.randomInt(len(offline)-n)
+			offline[n], offline[swap] = offline[swap], offline[n]
+
+			go offline[n].join(network.randomOnlineNode(), 0)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+/*
+File Username:  Simulated Network.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Simulated network of in-process DHT nodes. Messages between nodes are delivered via timers that add the one-way latency of both nodes and a random jitter.
+Messages to offline nodes are dropped; the sender removes the node from its routing table once the request timed out, like the core does for inactive peers.
+FIND_NODE returns the closest contacts. FIND_VALUE returns the data if stored locally, otherwise the storing node if known via an info-store message and the closest
+contacts, as expected by the iterative search of the dht package.
+*/
+
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PeernetOfficial/core/dht"
+)
+
+const alpha = 5                       // Count of nodes contacted in parallel, same as the core
+const bucketSize = 20                 // Count of nodes per bucket, same as the core
+const respondClosestContactsCount = 5 // Count of closest contacts returned per request, same as the core
+
+// simSettings defines the simulated network.
+type simSettings struct {
+	Latency   time.Duration // Max one-way access latency per node. Each node gets a random latency up to this value.
+	Jitter    time.Duration // Max random additional delay per message.
+	TimeoutIR time.Duration // Timeout of information requests.
+	Timeout   time.Duration // Timeout of an entire lookup.
+	Iterative bool          // Use the iterative lookup instead of the search client.
+	Beta      int           // Count of closest nodes that must have replied for an iterative lookup to converge.
+}
+
+// simNetwork contains all simulated nodes.
+type simNetwork struct {
+	settings simSettings
+	nodes    []*simNode
+	byID     map[string]*simNode
+	random   *rand.Rand
+	randomMu sync.Mutex
+	messages uint64 // Total count of messages sent
+	noDelay  int32  // If 1, messages are delivered without latency. Used while bootstrapping.
+}
+
+// simNode is a single simulated DHT node.
+type simNode struct {
+	network *simNetwork
+	dht     *dht.DHT
+	id      []byte
+	latency time.Duration // One-way access latency
+	online  int32         // 1 if online
+
+	sync.Mutex
+	values  map[string][]byte // Values stored by this node
+	storing map[string][]byte // Node ID storing the value, by key. Learned via info-store messages.
+	traces  map[string]*lookupTrace
+}
+
+// lookupTrace tracks the hop depth of each contacted node during a lookup.
+type lookupTrace struct {
+	depth    map[string]int // Hop depth by node ID. Nodes from the own routing table have depth 1.
+	messages uint64         // Count of requests sent
+}
+
+func newSimNetwork(settings simSettings, seed int64) *simNetwork {
+	return &simNetwork{settings: settings, byID: make(map[string]*simNode), random: rand.New(rand.NewSource(seed))}
+}
+
+func (network *simNetwork) randomInt(n int) int {
+	network.randomMu.Lock()
+	defer network.randomMu.Unlock()
+	return network.random.Intn(n)
+}
+
+func (network *simNetwork) randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	network.randomMu.Lock()
+	defer network.randomMu.Unlock()
+	return time.Duration(network.random.Int63n(int64(max)))
+}
+
+func (network *simNetwork) randomID() []byte {
+	id := make([]byte, 32)
+	network.randomMu.Lock()
+	network.random.Read(id)
+	network.randomMu.Unlock()
+	return id
+}
+
+// newNode creates a new node. It is offline until it joins.
+func (network *simNetwork) newNode() (node *simNode) {
+	node = &simNode{
+		network: network,
+		id:      network.randomID(),
+		latency: network.randomDuration(network.settings.Latency),
+		values:  make(map[string][]byte),
+		storing: make(map[string][]byte),
+		traces:  make(map[string]*lookupTrace),
+	}
+
+	node.dht = dht.NewDHT(&dht.Node{ID: node.id}, 256, bucketSize, alpha)
+	node.dht.TimeoutIR = network.settings.TimeoutIR
+	node.dht.TimeoutSearch = network.settings.Timeout
+
+	// Same as the core: Evict the old node if the new one has a lower latency. Without latency keep the closer one.
+	node.dht.ShouldEvict = func(node1, node2 *dht.Node) bool {
+		latencyOld := node1.Info.(*simNode).latency
+		latencyNew := node2.Info.(*simNode).latency
+		if latencyOld != latencyNew {
+			return latencyNew < latencyOld
+		}
+		return node.dht.IsNodeCloser(node1.ID, node2.ID)
+	}
+
+	node.dht.SendRequestStore = func(target *dht.Node, key []byte, dataSize uint64) {
+		remote := target.Info.(*simNode)
+		node.send(remote, func() {
+			remote.Lock()
+			remote.storing[string(key)] = node.id
+			remote.Unlock()
+		})
+	}
+
+	node.dht.SendRequestFindNode = func(request *dht.InformationRequest) {
+		for _, target := range request.Nodes {
+			node.request(target.Info.(*simNode), request)
+		}
+	}
+	node.dht.SendRequestFindValue = node.dht.SendRequestFindNode
+
+	network.nodes = append(network.nodes, node)
+	network.byID[string(node.id)] = node
+
+	return node
+}
+
+func (node *simNode) isOnline() bool {
+	return atomic.LoadInt32(&node.online) == 1
+}
+
+func (node *simNode) dhtNode() *dht.Node {
+	return &dht.Node{ID: node.id, Info: node}
+}
+
+// send delivers the message to the remote node after the simulated latency. Messages to offline nodes are dropped.
+func (node *simNode) send(remote *simNode, deliver func()) {
+	atomic.AddUint64(&node.network.messages, 1)
+
+	if !remote.isOnline() {
+		// The core removes peers that do not respond. Simulate it after the request timed out.
+		time.AfterFunc(node.network.settings.TimeoutIR, func() { node.dht.RemoveNode(remote.id) })
+		return
+	}
+
+	if atomic.LoadInt32(&node.network.noDelay) == 1 {
+		go deliver()
+		return
+	}
+
+	delay := node.latency + remote.latency + node.network.randomDuration(node.network.settings.Jitter)
+	time.AfterFunc(delay, deliver)
+}
+
+// request sends the information request to the remote node and delivers the response.
+func (node *simNode) request(remote *simNode, request *dht.InformationRequest) {
+	node.traceRequest(request.Key)
+
+	node.send(remote, func() {
+		if !remote.isOnline() {
+			return
+		}
+
+		// The remote node learns about the requester, like any incoming message adds the peer in the core.
+		remote.dht.AddNode(node.dhtNode())
+		response := remote.respond(node, request.Action, request.Key)
+
+		remote.send(node, func() {
+			if !node.isOnline() {
+				return
+			}
+
+			node.dht.AddNode(remote.dhtNode())
+			node.traceResponse(request.Key, remote.id, response)
+
+			request.QueueResult(response)
+			request.Done()
+		})
+	})
+}
+
+// respond creates the response to a request.
+func (node *simNode) respond(requester *simNode, action int, key []byte) (response *dht.NodeMessage) {
+	response = &dht.NodeMessage{SenderID: node.id}
+
+	if action == dht.ActionFindValue {
+		node.Lock()
+		data, found := node.values[string(key)]
+		storingID := node.storing[string(key)]
+		node.Unlock()
+
+		if found {
+			response.Data = data
+			return response
+		} else if storing := node.network.byID[string(storingID)]; storing != nil {
+			response.Storing = append(response.Storing, storing.dhtNode())
+		}
+	}
+
+	for _, contact := range node.dht.GetClosestContacts(respondClosestContactsCount, key, nil, requester.id) {
+		response.Closest = append(response.Closest, contact.Info.(*simNode).dhtNode())
+	}
+
+	return response
+}
+
+// traceStart starts tracking the hops of a lookup for the key.
+func (node *simNode) traceStart(key []byte) {
+	trace := &lookupTrace{depth: make(map[string]int)}
+	for _, contact := range node.dht.Nodes() {
+		trace.depth[string(contact.ID)] = 1
+	}
+
+	node.Lock()
+	node.traces[string(key)] = trace
+	node.Unlock()
+}
+
+// traceEnd stops tracking the lookup. It returns the hop depth of the sender of the result and the count of requests sent.
+func (node *simNode) traceEnd(key, senderID []byte) (hops int, messages uint64) {
+	node.Lock()
+	defer node.Unlock()
+
+	trace := node.traces[string(key)]
+	delete(node.traces, string(key))
+	if trace == nil {
+		return 0, 0
+	}
+
+	return trace.depth[string(senderID)], trace.messages
+}
+
+func (node *simNode) traceRequest(key []byte) {
+	node.Lock()
+	defer node.Unlock()
+
+	if trace := node.traces[string(key)]; trace != nil {
+		trace.messages++
+	}
+}
+
+func (node *simNode) traceResponse(key, senderID []byte, response *dht.NodeMessage) {
+	node.Lock()
+	defer node.Unlock()
+
+	trace := node.traces[string(key)]
+	if trace == nil {
+		return
+	}
+
+	depth := trace.depth[string(senderID)]
+	if depth == 0 {
+		depth = 1
+	}
+
+	for _, list := range [][]*dht.Node{response.Closest, response.Storing} {
+		for _, contact := range list {
+			if _, ok := trace.depth[string(contact.ID)]; !ok {
+				trace.depth[string(contact.ID)] = depth + 1
+			}
+		}
+	}
+}
+
+// join brings the node online and bootstraps its routing table via the seed node: a lookup for its own ID and for random IDs in the closest buckets.
+func (node *simNode) join(seed *simNode, refreshBits int) {
+	atomic.StoreInt32(&node.online, 1)
+
+	if seed != nil {
+		node.dht.AddNode(seed.dhtNode())
+	}
+
+	node.dht.FindNode(node.id)
+
+	for bit := 0; bit < refreshBits; bit++ {
+		node.dht.FindNode(randomIDAtDistance(node.id, bit, node.network))
+	}
+}
+
+// leave takes the node offline. Other nodes are not informed.
+func (node *simNode) leave() {
+	atomic.StoreInt32(&node.online, 0)
+}
+
+// randomIDAtDistance returns a random ID that shares the first bits with the ID and differs in the next bit. It falls into the bucket for that distance.
+func randomIDAtDistance(id []byte, bit int, network *simNetwork) (result []byte) {
+	result = network.randomID()
+
+	for n := 0; n < bit; n++ {
+		mask := byte(0x80) >> (n % 8)
+		result[n/8] = result[n/8]&^mask | id[n/8]&mask
+	}
+
+	mask := byte(0x80) >> (bit % 8)
+	result[bit/8] = result[bit/8]&^mask | ^id[bit/8]&mask
+
+	return result
+}
@@ -0,0 +1,88 @@
+/*
+File Username:  Client.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Minimal HTTP client for the webapi.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// client connects to the webapi of a running Peernet node.
+type client struct {
+	address string // Base URL, for example "http://127.0.0.1:112".
+	apiKey  string // API key sent in the x-api-key header. Empty if not used.
+	http    *http.Client
+}
+
+func newClient(address, apiKey string, timeout time.Duration) *client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	return &client{address: strings.TrimRight(address, "/"), apiKey: apiKey, http: &http.Client{Timeout: timeout}}
+}
+
+// get calls the endpoint with the parameters and decodes the JSON response into result.
+func (c *client) get(path string, params url.Values, result interface{}) (err error) {
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	request, err := http.NewRequest(http.MethodGet, c.address+path, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(request, result)
+}
+
+// post calls the endpoint with the JSON encoded data and decodes the JSON response into result.
+func (c *client) post(path string, data interface{}, result interface{}) (err error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, c.address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	return c.do(request, result)
+}
+
+func (c *client) do(request *http.Request, result interface{}) (err error) {
+	if c.apiKey != "" {
+		request.Header.Set("x-api-key", c.apiKey)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		if text := strings.TrimSpace(string(message)); text != "" {
+			return errors.New("HTTP " + strconv.Itoa(response.StatusCode) + ": " + text)
+		}
+		return errors.New("HTTP " + strconv.Itoa(response.StatusCode) + " " + http.StatusText(response.StatusCode))
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,498 @@
+/*
+File Username:  Commands.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/warehouse"
+	"github.com/PeernetOfficial/core/webapi"
+	"github.com/google/uuid"
+)
+
+var errUsage = errors.New("invalid usage")
+
+// file is a file as returned by the webapi.
+type file struct {
+	ID          uuid.UUID `json:"id"`
+	Hash        []byte    `json:"hash"`
+	Type        uint8     `json:"type"`
+	Format      uint16    `json:"format"`
+	Size        uint64    `json:"size"`
+	Folder      string    `json:"folder"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Date        time.Time `json:"date"`
+	NodeID      []byte    `json:"nodeid"`
+	Username    string    `json:"username"`
+}
+
+// fileList is used for adding and listing files on the blockchain.
+type fileList struct {
+	Files  []file `json:"files"`
+	Status int    `json:"status"`
+}
+
+// blockchainStatus is the status of the blockchain after an operation.
+type blockchainStatus struct {
+	Status  int    `json:"status"`
+	Height  uint64 `json:"height"`
+	Version uint64 `json:"version"`
+}
+
+// downloadStatus is the status of a download.
+type downloadStatus struct {
+	APIStatus      int       `json:"apistatus"`
+	ID             uuid.UUID `json:"id"`
+	DownloadStatus int       `json:"downloadstatus"`
+	File           file      `json:"file"`
+	Progress       struct {
+		TotalSize      uint64  `json:"totalsize"`
+		DownloadedSize uint64  `json:"downloadedsize"`
+		Percentage     float64 `json:"percentage"`
+	} `json:"progress"`
+}
+
+// parseFlags parses the command flags. It returns errUsage if the count of remaining arguments does not match.
+func parseFlags(set *flag.FlagSet, args []string, countArgs int) (err error) {
+	set.SetOutput(io.Discard)
+	if err = set.Parse(args); err != nil || set.NArg() != countArgs {
+		return errUsage
+	}
+	return nil
+}
+
+func printFile(f *file) {
+	printf("%s  %s  %10d  %s\n", hex.EncodeToString(f.Hash), hex.EncodeToString(f.NodeID), f.Size, filepath.ToSlash(filepath.Join(f.Folder, f.Name)))
+}
+
+func cmdStatus(c *client, args []string) (result interface{}, err error) {
+	var status struct {
+		Status        int  `json:"status"`
+		IsConnected   bool `json:"isconnected"`
+		CountPeerList int  `json:"countpeerlist"`
+		CountNetwork  int  `json:"countnetwork"`
+		NATType       int  `json:"nattype"`
+	}
+	if err = c.get("/status", nil, &status); err != nil {
+		return nil, err
+	}
+
+	printf("Connected:  %t\nPeers:      %d\nNetwork:    %d\nNAT type:   %d\n", status.IsConnected, status.CountPeerList, status.CountNetwork, status.NATType)
+
+	return status, nil
+}
+
+func cmdPeers(c *client, args []string) (result interface{}, err error) {
+	var peers []struct {
+		PeerID            []byte `json:"peerid"`
+		NodeID            []byte `json:"nodeid"`
+		GeoIP             string `json:"geoip"`
+		UserAgent         string `json:"useragent"`
+		IsRoot            bool   `json:"isroot"`
+		BlockchainHeight  uint64 `json:"blockchainheight"`
+		BlockchainVersion uint64 `json:"blockchainversion"`
+	}
+	if err = c.get("/status/peers", nil, &peers); err != nil {
+		return nil, err
+	}
+
+	for _, peer := range peers {
+		root := ""
+		if peer.IsRoot {
+			root = " [root]"
+		}
+		printf("%s  height %d  %s%s\n", hex.EncodeToString(peer.PeerID), peer.BlockchainHeight, peer.UserAgent, root)
+	}
+
+	return peers, nil
+}
+
+func cmdPublish(c *client, args []string) (result interface{}, err error) {
+	set := flag.NewFlagSet("publish", flag.ContinueOnError)
+	name := set.String("name", "", "")
+	folder := set.String("folder", "", "")
+	description := set.String("description", "", "")
+	if err = parseFlags(set, args, 1); err != nil {
+		return nil, err
+	}
+
+	// The webapi runs on the same machine and reads the file from disk.
+	path, err := filepath.Abs(set.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	if *name == "" {
+		*name = filepath.Base(path)
+	}
+
+	var format struct {
+		Status     int    `json:"status"`
+		FileType   uint16 `json:"filetype"`
+		FileFormat uint16 `json:"fileformat"`
+	}
+	if err = c.get("/file/format", url.Values{"path": {path}}, &format); err != nil {
+		return nil, err
+	}
+
+	var created webapi.WarehouseResult
+	if err = c.get("/warehouse/create/path", url.Values{"path": {path}}, &created); err != nil {
+		return nil, err
+	} else if created.Status != warehouse.StatusOK {
+		return nil, fmt.Errorf("adding file to the warehouse failed with status %d", created.Status)
+	}
+
+	add := fileList{Files: []file{{Hash: created.Hash, Type: uint8(format.FileType), Format: format.FileFormat, Folder: *folder, Name: *name, Description: *description}}}
+
+	var status blockchainStatus
+	if err = c.post("/blockchain/file/add", add, &status); err != nil {
+		return nil, err
+	} else if status.Status != blockchain.StatusOK {
+		return nil, fmt.Errorf("adding file to the blockchain failed with status %d", status.Status)
+	}
+
+	printf("Published %s\nHash:       %s\nBlockchain: height %d version %d\n", *name, hex.EncodeToString(created.Hash), status.Height, status.Version)
+
+	return struct {
+		Hash   []byte           `json:"hash"`
+		Status blockchainStatus `json:"blockchain"`
+	}{created.Hash, status}, nil
+}
+
+func cmdSearch(c *client, args []string) (result interface{}, err error) {
+	set := flag.NewFlagSet("search", flag.ContinueOnError)
+	timeout := set.Int("timeout", 10, "")
+	limit := set.Int("limit", 100, "")
+	if err = parseFlags(set, args, 1); err != nil {
+		return nil, err
+	}
+
+	request := webapi.SearchRequest{Term: set.Arg(0), Timeout: *timeout, MaxResults: *limit, FileType: -1, FileFormat: -1, SizeMin: -1, SizeMax: -1}
+
+	var job webapi.SearchRequestResponse
+	if err = c.post("/search", request, &job); err != nil {
+		return nil, err
+	} else if job.Status != 0 {
+		return nil, fmt.Errorf("search failed with status %d", job.Status)
+	}
+
+	defer c.get("/search/terminate", url.Values{"id": {job.ID.String()}}, &struct{}{})
+
+	files := []file{}
+	deadline := time.Now().Add(time.Duration(*timeout) * time.Second)
+
+	for len(files) < *limit {
+		var results struct {
+			Status int    `json:"status"`
+			Files  []file `json:"files"`
+		}
+		if err = c.get("/search/result", url.Values{"id": {job.ID.String()}, "limit": {strconv.Itoa(*limit - len(files))}}, &results); err != nil {
+			return nil, err
+		}
+
+		for n := range results.Files {
+			printFile(&results.Files[n])
+		}
+		files = append(files, results.Files...)
+
+		if results.Status == 2 { // search ID not found (already terminated)
+			break
+		} else if results.Status != 0 {
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	return files, nil
+}
+
+func cmdDownload(c *client, args []string) (result interface{}, err error) {
+	set := flag.NewFlagSet("download", flag.ContinueOnError)
+	if err = parseFlags(set, args, 3); err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(set.Arg(2))
+	if err != nil {
+		return nil, err
+	}
+
+	var status downloadStatus
+	if err = c.get("/download/start", url.Values{"hash": {set.Arg(0)}, "node": {set.Arg(1)}, "path": {path}}, &status); err != nil {
+		return nil, err
+	} else if status.APIStatus != webapi.DownloadResponseSuccess {
+		return nil, fmt.Errorf("starting download failed with status %d", status.APIStatus)
+	}
+
+	for status.DownloadStatus != webapi.DownloadFinished && status.DownloadStatus != webapi.DownloadCanceled {
+		time.Sleep(time.Second)
+
+		if err = c.get("/download/status", url.Values{"id": {status.ID.String()}}, &status); err != nil {
+			return nil, err
+		} else if status.APIStatus != webapi.DownloadResponseSuccess {
+			return nil, fmt.Errorf("download status %d", status.APIStatus)
+		}
+
+		printf("\r%6.2f%%  %d / %d bytes", status.Progress.Percentage, status.Progress.DownloadedSize, status.Progress.TotalSize)
+	}
+	printf("\n")
+
+	if status.DownloadStatus == webapi.DownloadCanceled {
+		return nil, errors.New("download canceled")
+	}
+
+	printf("Downloaded to %s\n", path)
+
+	return status, nil
+}
+
+func cmdBlockchain(c *client, args []string) (result interface{}, err error) {
+	action := "header"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch {
+	case action == "header" && len(args) <= 1:
+		var header struct {
+			PeerID  string `json:"peerid"`
+			Version uint64 `json:"version"`
+			Height  uint64 `json:"height"`
+		}
+		if err = c.get("/blockchain/header", nil, &header); err != nil {
+			return nil, err
+		}
+
+		printf("Peer ID:    %s\nVersion:    %d\nHeight:     %d\n", header.PeerID, header.Version, header.Height)
+		return header, nil
+
+	case action == "files" && len(args) == 1:
+		var list fileList
+		if err = c.get("/blockchain/file/list", nil, &list); err != nil {
+			return nil, err
+		}
+
+		for n := range list.Files {
+			printFile(&list.Files[n])
+		}
+		return list.Files, nil
+
+	case action == "read" && len(args) == 2:
+		if _, err := strconv.ParseUint(args[1], 10, 64); err != nil {
+			return nil, errUsage
+		}
+
+		var block struct {
+			Status         int           `json:"status"`
+			PeerID         string        `json:"peerid"`
+			LastBlockHash  []byte        `json:"lastblockhash"`
+			Version        uint64        `json:"blockchainversion"`
+			Number         uint64        `json:"blocknumber"`
+			RecordsDecoded []interface{} `json:"recordsdecoded"`
+		}
+		if err = c.get("/blockchain/read", url.Values{"block": {args[1]}}, &block); err != nil {
+			return nil, err
+		} else if block.Status != blockchain.StatusOK {
+			return nil, fmt.Errorf("reading block failed with status %d", block.Status)
+		}
+
+		printf("Block:      %d\nVersion:    %d\nLast hash:  %s\nRecords:    %d\n", block.Number, block.Version, hex.EncodeToString(block.LastBlockHash), len(block.RecordsDecoded))
+		for _, record := range block.RecordsDecoded {
+			printf("  %v\n", record)
+		}
+		return block, nil
+
+	case action == "compact":
+		set := flag.NewFlagSet("compact", flag.ContinueOnError)
+		dryRun := set.Bool("dryrun", false, "")
+		target := set.Uint64("target", 0, "")
+		if err = parseFlags(set, args[1:], 0); err != nil {
+			return nil, err
+		}
+
+		var status webapi.CompactStatus
+		if err = c.get("/blockchain/compact", url.Values{"dryrun": {strconv.FormatBool(*dryRun)}, "target": {strconv.FormatUint(*target, 10)}}, &status); err != nil {
+			return nil, err
+		}
+
+		phases := []string{"Reading", "Writing", "Verifying"}
+
+		for status.Running {
+			time.Sleep(time.Second)
+
+			if err = c.get("/blockchain/compact/status", nil, &status); err != nil {
+				return nil, err
+			}
+
+			if status.Running && status.Phase < len(phases) {
+				printf("\r%-10s %d / %d blocks", phases[status.Phase], status.Done, status.Total)
+			}
+		}
+		printf("\n")
+
+		if status.Status != blockchain.StatusOK {
+			return status, fmt.Errorf("compaction failed with status %d", status.Status)
+		}
+
+		verb := "Compacted"
+		if *dryRun {
+			verb = "Would compact"
+		} else if !status.Written {
+			verb = "Not compacted"
+		}
+		printf("%s: Height %d -> %d, version %d -> %d, %d records\n", verb, status.HeightBefore, status.HeightAfter, status.VersionBefore, status.VersionAfter, status.Records)
+		return status, nil
+	}
+
+	return nil, errUsage
+}
+
+func cmdGC(c *client, args []string) (result interface{}, err error) {
+	set := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := set.Bool("dryrun", false, "")
+	if err = parseFlags(set, args, 0); err != nil {
+		return nil, err
+	}
+
+	var gc webapi.WarehouseGCResult
+	if err = c.get("/warehouse/gc", url.Values{"dryrun": {strconv.FormatBool(*dryRun)}}, &gc); err != nil {
+		return nil, err
+	}
+
+	for _, hash := range gc.Files {
+		printf("%s\n", hex.EncodeToString(hash))
+	}
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	printf("%s %d files, %d bytes\n", verb, len(gc.Files), gc.Size)
+
+	if gc.Status != warehouse.StatusOK {
+		return gc, fmt.Errorf("some files could not be deleted, status %d", gc.Status)
+	}
+
+	return gc, nil
+}
+
+func cmdInvite(c *client, args []string) (result interface{}, err error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+
+	switch args[0] {
+	case "create":
+		set := flag.NewFlagSet("create", flag.ContinueOnError)
+		validity := set.Int("validity", 24, "")
+		if err = parseFlags(set, args[1:], 0); err != nil {
+			return nil, err
+		}
+
+		var invitation struct {
+			Invitation string    `json:"invitation"`
+			PeerID     string    `json:"peerid"`
+			Addresses  []string  `json:"addresses"`
+			Expires    time.Time `json:"expires"`
+		}
+		if err = c.get("/invitation/create", url.Values{"validity": {strconv.Itoa(*validity * 3600)}}, &invitation); err != nil {
+			return nil, err
+		}
+
+		printf("%s\nExpires:    %s\n", invitation.Invitation, invitation.Expires.Local().Format(time.RFC3339))
+		return invitation, nil
+
+	case "accept":
+		set := flag.NewFlagSet("accept", flag.ContinueOnError)
+		persist := set.Bool("persist", false, "")
+		if err = parseFlags(set, args[1:], 1); err != nil {
+			return nil, err
+		}
+
+		var accepted struct {
+			PeerID    string   `json:"peerid"`
+			Addresses []string `json:"addresses"`
+			Connected bool     `json:"connected"`
+		}
+		request := struct {
+			Invitation string `json:"invitation"`
+			Persist    bool   `json:"persist"`
+		}{Invitation: set.Arg(0), Persist: *persist}
+		if err = c.post("/invitation/accept", request, &accepted); err != nil {
+			return nil, err
+		}
+
+		printf("Peer ID:    %s\nConnected:  %t\n", accepted.PeerID, accepted.Connected)
+		if !accepted.Connected {
+			return accepted, errors.New("inviting peer not reachable")
+		}
+		return accepted, nil
+	}
+
+	return nil, errUsage
+}
+
+func cmdAdmin(c *client, args []string) (result interface{}, err error) {
+	set := flag.NewFlagSet("admin", flag.ContinueOnError)
+	timeout := set.Int("timeout", 10, "")
+	set.SetOutput(io.Discard)
+	if err = set.Parse(args); err != nil || set.NArg() < 2 {
+		return nil, errUsage
+	}
+
+	params := url.Values{"peer": {set.Arg(0)}, "timeout": {strconv.Itoa(*timeout)}}
+
+	switch {
+	case set.Arg(1) == "status" && set.NArg() == 2:
+		params.Set("action", "0")
+	case set.Arg(1) == "reannounce" && set.NArg() == 2:
+		params.Set("action", "1")
+	case set.Arg(1) == "gc" && set.NArg() == 2:
+		params.Set("action", "2")
+	case set.Arg(1) == "logtarget" && set.NArg() == 3:
+		params.Set("action", "3")
+		params.Set("target", set.Arg(2))
+	default:
+		return nil, errUsage
+	}
+
+	var response struct {
+		Status int             `json:"status"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err = c.get("/admin/request", params, &response); err != nil {
+		return nil, err
+	}
+
+	switch response.Status {
+	case 0:
+	case 1:
+		return response, errors.New("not authorized by the remote node")
+	default:
+		return response, fmt.Errorf("admin request failed with status %d", response.Status)
+	}
+
+	if len(response.Data) > 0 {
+		printf("%s\n", string(response.Data))
+	} else {
+		printf("Done\n")
+	}
+
+	return response, nil
+}
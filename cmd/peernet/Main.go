@@ -0,0 +1,115 @@
+/*
+File Username:  Main.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Peernet command line client. It connects to the webapi of a running Peernet node (for example peernetd) and provides scriptable commands.
+All commands support JSON output via the -json flag for use in scripts.
+
+Usage: peernet [-api 127.0.0.1:112] [-apikey UUID] [-json] [command] [arguments]
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// command is a CLI command.
+type command struct {
+	name        string
+	arguments   string // Description of the arguments for the usage text.
+	description string
+	run         func(c *client, args []string) (result interface{}, err error) // Returns the result printed as JSON in JSON mode.
+}
+
+var commands = []command{
+	{"status", "", "Show the connectivity status", cmdStatus},
+	{"peers", "", "List connected peers", cmdPeers},
+	{"publish", "[-name name] [-folder folder] [-description text] [file]", "Add a local file to the warehouse and publish it on the blockchain", cmdPublish},
+	{"search", "[-timeout seconds] [-limit count] [term]", "Search for files", cmdSearch},
+	{"download", "[hash] [node ID] [target path]", "Download a file and wait until finished", cmdDownload},
+	{"blockchain", "[header | files | read [block number] | compact [-dryrun] [-target bytes]]", "Inspect the user's blockchain", cmdBlockchain},
+	{"gc", "[-dryrun]", "Delete warehouse files not referenced by the blockchain", cmdGC},
+	{"invite", "[create [-validity hours] | accept [-persist] [invitation]]", "Create or accept an invitation to bootstrap into a private swarm", cmdInvite},
+	{"admin", "[-timeout seconds] [peer ID] [status | reannounce | gc | logtarget [target]]", "Send an admin request to a remote node", cmdAdmin},
+}
+
+// Exit codes
+const (
+	exitSuccess = 0
+	exitError   = 1
+	exitUsage   = 2
+)
+
+// jsonOutput indicates whether results are printed as JSON instead of text.
+var jsonOutput bool
+
+func main() {
+	var address, apiKey string
+	var timeout int
+
+	flag.StringVar(&address, "api", "127.0.0.1:112", "Webapi address (IP:Port or URL)")
+	flag.StringVar(&apiKey, "apikey", os.Getenv("PEERNET_APIKEY"), "Webapi API key (UUID). Defaults to the environment variable PEERNET_APIKEY.")
+	flag.BoolVar(&jsonOutput, "json", false, "Output results as JSON")
+	flag.IntVar(&timeout, "timeout", 30, "Timeout in seconds for each webapi call")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	c := newClient(address, apiKey, time.Duration(timeout)*time.Second)
+
+	for _, cmd := range commands {
+		if cmd.name != flag.Arg(0) {
+			continue
+		}
+
+		result, err := cmd.run(c, flag.Args()[1:])
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "Usage: peernet %s %s\n", cmd.name, cmd.arguments)
+			os.Exit(exitUsage)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+
+		if jsonOutput && result != nil {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "    ")
+			encoder.Encode(result)
+		}
+
+		os.Exit(exitSuccess)
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command '%s'.\n", flag.Arg(0))
+	usage()
+	os.Exit(exitUsage)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: peernet [flags] [command] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", cmd.name, cmd.description)
+		if cmd.arguments != "" {
+			fmt.Fprintf(os.Stderr, "  %-11s %s\n", "", cmd.arguments)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// printf prints the text only in text mode.
+func printf(format string, v ...interface{}) {
+	if !jsonOutput {
+		fmt.Printf(format, v...)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+File Username:  Main.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Peernet daemon: A headless Peernet node intended to run as a service. It loads the config, optionally starts the webapi and the gateway and mounts the filesystem,
+writes a PID file, notifies systemd when ready, and shuts down gracefully on SIGINT/SIGTERM.
+
+Usage: peernetd [-config Config.yaml] [-pidfile peernetd.pid] [-webapi 127.0.0.1:112] [-apikey UUID] [-gateway 127.0.0.1:8080]
+       peernetd [-config Config.yaml] -migrate-data [new data folder]
+       peernetd [-config Config.yaml] [other parameters] -service install|uninstall|run
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/fuse"
+	"github.com/PeernetOfficial/core/webapi"
+	"github.com/google/uuid"
+)
+
+const appName = "Peernet Daemon"
+const userAgent = appName + "/" + core.Version
+
+// config defines the daemon specific settings that are stored in the same config file as the core settings.
+type config struct {
+	// Webapi settings. The webapi is disabled if no listen address is set.
+	WebapiListen          []string `yaml:"WebapiListen"`          // IP:Port combinations
+	WebapiUseSSL          bool     `yaml:"WebapiUseSSL"`          // Enables SSL.
+	WebapiCertificateFile string   `yaml:"WebapiCertificateFile"` // This is the certificate received from the CA. This can also include the intermediate certificate from the CA.
+	WebapiCertificateKey  string   `yaml:"WebapiCertificateKey"`  // This is the private key.
+	WebapiTimeoutRead     int      `yaml:"WebapiTimeoutRead"`     // The maximum duration in seconds for reading the entire request, including the body. 0 = no timeout.
+	WebapiTimeoutWrite    int      `yaml:"WebapiTimeoutWrite"`    // Maximum duration in seconds before timing out writes of the response. 0 = no timeout.
+	WebapiAPIKey          string   `yaml:"WebapiAPIKey"`          // API key (UUID) required for all webapi requests. Empty to disable.
+
+	// Gateway serving files at /hash/[blake3]. The gateway is disabled if no listen address is set. It does not use the API key and should only listen on localhost.
+	GatewayListen []string `yaml:"GatewayListen"` // IP:Port combinations
+
+	// FUSE mount of the user's shares and the shares of followed peers (Linux only). Disabled if no mountpoint is set.
+	FuseMountpoint string   `yaml:"FuseMountpoint"` // Existing directory to mount the filesystem at.
+	FuseFollow     []string `yaml:"FuseFollow"`     // Peer IDs of followed peers whose shares are mounted.
+
+	PIDFile string `yaml:"PIDFile"` // PID file to create. Empty to disable.
+}
+
+// parameters are the command line parameters. Parameters other than the config file override the config settings.
+type parameters struct {
+	configFile    string
+	pidFile       string
+	webapiListen  string
+	apiKey        string
+	gatewayListen string
+}
+
+// daemon is a running node including the optional webapi, gateway and filesystem.
+type daemon struct {
+	backend    *core.Backend
+	config     config
+	filesystem *fuse.Filesystem
+}
+
+func main() {
+	var params parameters
+	var migrateData, service string
+
+	flag.StringVar(&params.configFile, "config", "Config.yaml", "Config file")
+	flag.StringVar(&params.pidFile, "pidfile", "", "PID file to create. Overrides the config setting.")
+	flag.StringVar(&params.webapiListen, "webapi", "", "Webapi listen address (IP:Port). Overrides the config setting.")
+	flag.StringVar(&params.apiKey, "apikey", "", "Webapi API key (UUID). Overrides the config setting.")
+	flag.StringVar(&params.gatewayListen, "gateway", "", "Gateway listen address (IP:Port). Overrides the config setting.")
+	flag.StringVar(&migrateData, "migrate-data", "", "Moves the data folder to the specified folder, updates the config and exits. The daemon must not be running.")
+	flag.StringVar(&service, "service", "", "Service command: install, uninstall, or run. Install registers the daemon with the other parameters to start on boot.")
+	flag.Parse()
+
+	if migrateData != "" {
+		if err := core.MigrateDataFolder(params.configFile, migrateData); err != nil {
+			fmt.Fprintf(os.Stderr, "Error migrating data folder: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stdout, "Data folder moved to '%s'.\n", migrateData)
+		return
+	}
+
+	if service != "" {
+		if err := serviceCommand(service, params); err != nil {
+			fmt.Fprintf(os.Stderr, "Error service %s: %v\n", service, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	os.Exit(runForeground(params))
+}
+
+// runForeground runs the daemon until a termination signal is received. It returns the exit status.
+func runForeground(params parameters) (status int) {
+	d, status, err := startDaemon(params)
+	if status != core.ExitSuccess {
+		fmt.Fprintf(os.Stderr, "Error %d initializing backend: %v\n", status, err)
+		return status
+	}
+
+	// Wait for a termination signal for graceful shutdown.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	d.backend.LogError("main", "received signal %s, shutting down\n", sig.String())
+	d.stop()
+
+	return core.ExitGraceful
+}
+
+// startDaemon initializes the backend, starts the optional services and connects to the network. Status is of type core.ExitX.
+func startDaemon(params parameters) (d *daemon, status int, err error) {
+	d = &daemon{}
+
+	if d.backend, status, err = core.Init(userAgent, params.configFile, nil, &d.config); status != core.ExitSuccess {
+		return nil, status, err
+	}
+
+	d.backend.Stdout.Subscribe(os.Stdout)
+
+	if params.pidFile != "" {
+		d.config.PIDFile = params.pidFile
+	}
+	if d.config.PIDFile != "" {
+		if err := writePIDFile(d.config.PIDFile); err != nil {
+			d.backend.LogError("main", "writing PID file '%s': %s\n", d.config.PIDFile, err.Error())
+		}
+	}
+
+	if params.webapiListen != "" {
+		d.config.WebapiListen = []string{params.webapiListen}
+	}
+	if params.apiKey != "" {
+		d.config.WebapiAPIKey = params.apiKey
+	}
+
+	if len(d.config.WebapiListen) > 0 {
+		apiKey := uuid.Nil
+		if d.config.WebapiAPIKey != "" {
+			if apiKey, err = uuid.Parse(d.config.WebapiAPIKey); err != nil {
+				return nil, core.ExitParamApiKeyInvalid, fmt.Errorf("invalid webapi API key: %v", err)
+			}
+		}
+
+		webapi.Start(d.backend, d.config.WebapiListen, d.config.WebapiUseSSL, d.config.WebapiCertificateFile, d.config.WebapiCertificateKey, time.Duration(d.config.WebapiTimeoutRead)*time.Second, time.Duration(d.config.WebapiTimeoutWrite)*time.Second, apiKey)
+	}
+
+	if params.gatewayListen != "" {
+		d.config.GatewayListen = []string{params.gatewayListen}
+	}
+	webapi.StartGateway(d.backend, d.config.GatewayListen, time.Duration(d.config.WebapiTimeoutRead)*time.Second, time.Duration(d.config.WebapiTimeoutWrite)*time.Second)
+
+	if d.config.FuseMountpoint != "" {
+		if d.filesystem, err = mountFilesystem(d.backend, d.config.FuseMountpoint, d.config.FuseFollow); err != nil {
+			d.backend.LogError("main", "mounting filesystem at '%s': %s\n", d.config.FuseMountpoint, err.Error())
+		}
+	}
+
+	d.backend.Connect()
+
+	systemdNotify("READY=1")
+	d.backend.LogError("main", "%s started\n", userAgent)
+
+	return d, core.ExitSuccess, nil
+}
+
+// stop shuts down the optional services and the backend, which flushes the stores, and removes the PID file.
+func (d *daemon) stop() {
+	systemdNotify("STOPPING=1")
+
+	if d.filesystem != nil {
+		d.filesystem.Unmount()
+	}
+
+	d.backend.Close()
+
+	if d.config.PIDFile != "" {
+		os.Remove(d.config.PIDFile)
+	}
+}
+
+// mountFilesystem mounts the user's shares and the shares of the followed peers (provided as peer IDs).
+func mountFilesystem(backend *core.Backend, mountpoint string, follow []string) (filesystem *fuse.Filesystem, err error) {
+	var publicKeys []*btcec.PublicKey
+	for _, peerID := range follow {
+		publicKey, err := core.PublicKeyFromPeerID(peerID)
+		if err != nil {
+			backend.LogError("mountFilesystem", "invalid peer ID '%s' to follow: %s\n", peerID, err.Error())
+			continue
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	filesystem = fuse.New(backend, publicKeys)
+	if err = filesystem.Mount(mountpoint); err != nil {
+		return nil, err
+	}
+
+	return filesystem, nil
+}
@@ -0,0 +1,116 @@
+//go:build darwin
+// +build darwin
+
+/*
+File Username:  Service Darwin.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+macOS service integration via launchd. If installed as root, the daemon is registered as launch daemon that starts on boot. Otherwise it is registered
+as launch agent of the current user that starts on login. launchd restarts the daemon if it exits unexpectedly.
+Resume from sleep is detected by the core.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const launchdLabel = "net.peernet." + serviceName
+
+// launchdPlistPath returns the path of the launchd property list. Launch daemons require root.
+func launchdPlistPath() (path string, err error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library/LaunchDaemons", launchdLabel+".plist"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, "Library/LaunchAgents", launchdLabel+".plist"), nil
+}
+
+// serviceInstall writes the launchd property list and loads it, which starts the daemon.
+func serviceInstall(arguments []string) (err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	path, err := launchdPlistPath()
+	if err != nil {
+		return err
+	} else if _, err := os.Stat(path); err == nil {
+		return errors.New("service already installed")
+	}
+
+	// The working directory is the folder of the config file.
+	workingDirectory := "/"
+	for n := range arguments {
+		if arguments[n] == "-config" && n+1 < len(arguments) {
+			workingDirectory = filepath.Dir(arguments[n+1])
+		}
+	}
+
+	var plist bytes.Buffer
+	plist.WriteString(xml.Header)
+	plist.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	plist.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	plist.WriteString("\t<key>Label</key>\n\t<string>" + xmlEscape(launchdLabel) + "</string>\n")
+	plist.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, argument := range append([]string{executable}, arguments...) {
+		plist.WriteString("\t\t<string>" + xmlEscape(argument) + "</string>\n")
+	}
+	plist.WriteString("\t</array>\n")
+	plist.WriteString("\t<key>WorkingDirectory</key>\n\t<string>" + xmlEscape(workingDirectory) + "</string>\n")
+	plist.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	plist.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	plist.WriteString("</dict>\n</plist>\n")
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	} else if err = os.WriteFile(path, plist.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	if output, err := exec.Command("launchctl", "load", "-w", path).CombinedOutput(); err != nil {
+		os.Remove(path)
+		return errors.New("launchctl load: " + err.Error() + " " + string(output))
+	}
+
+	return nil
+}
+
+// serviceUninstall unloads the launchd property list, which stops the daemon, and deletes it.
+func serviceUninstall() (err error) {
+	path, err := launchdPlistPath()
+	if err != nil {
+		return err
+	} else if _, err := os.Stat(path); err != nil {
+		return errors.New("service not installed")
+	}
+
+	exec.Command("launchctl", "unload", "-w", path).Run()
+
+	return os.Remove(path)
+}
+
+// serviceRun runs the daemon. launchd starts it directly as a regular process.
+func serviceRun(params parameters) (err error) {
+	os.Exit(runForeground(params))
+	return nil
+}
+
+func xmlEscape(text string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(text))
+	return buffer.String()
+}
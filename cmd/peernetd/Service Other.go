@@ -0,0 +1,31 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+/*
+File Username:  Service Other.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// serviceInstall is not supported on this platform. On Linux use a systemd unit, see the readme.
+func serviceInstall(arguments []string) (err error) {
+	return errors.New("not supported on this platform, use a systemd unit instead")
+}
+
+// serviceUninstall is not supported on this platform.
+func serviceUninstall() (err error) {
+	return errors.New("not supported on this platform")
+}
+
+// serviceRun runs the daemon in the foreground.
+func serviceRun(params parameters) (err error) {
+	os.Exit(runForeground(params))
+	return nil
+}
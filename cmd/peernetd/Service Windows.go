@@ -0,0 +1,139 @@
+//go:build windows
+// +build windows
+
+/*
+File Username:  Service Windows.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Windows service integration via the Service Control Manager. The service starts automatically on boot and is restarted on failure.
+Errors during startup are reported to the Windows event log. Resume from sleep is reported by the SCM as power event and passed to the core.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Power event types sent with svc.PowerEvent. See https://learn.microsoft.com/en-us/windows/win32/power/wm-powerbroadcast.
+const (
+	pbtAPMResumeSuspend   = 0x0007 // Resumed after suspend, triggered by user input.
+	pbtAPMResumeAutomatic = 0x0012 // Resumed after suspend.
+)
+
+type windowsService struct {
+	params parameters
+}
+
+// serviceInstall registers the daemon as service that starts on boot and starts it.
+func serviceInstall(arguments []string) (err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	manager, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer manager.Disconnect()
+
+	if service, err := manager.OpenService(serviceName); err == nil {
+		service.Close()
+		return errors.New("service already installed")
+	}
+
+	service, err := manager.CreateService(serviceName, executable, mgr.Config{DisplayName: serviceDisplayName, Description: serviceDescription, StartType: mgr.StartAutomatic}, arguments...)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	// Restart the service if it fails. The failure count is reset after 1 day.
+	service.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}, 24*60*60)
+
+	eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+	return service.Start()
+}
+
+// serviceUninstall stops and removes the service.
+func serviceUninstall() (err error) {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer manager.Disconnect()
+
+	service, err := manager.OpenService(serviceName)
+	if err != nil {
+		return errors.New("service not installed")
+	}
+	defer service.Close()
+
+	service.Control(svc.Stop)
+
+	if err = service.Delete(); err != nil {
+		return err
+	}
+
+	eventlog.Remove(serviceName)
+
+	return nil
+}
+
+// serviceRun runs the daemon as service. If not started by the SCM, it runs in the foreground.
+func serviceRun(params parameters) (err error) {
+	if isService, err := svc.IsWindowsService(); err != nil {
+		return err
+	} else if !isService {
+		os.Exit(runForeground(params))
+	}
+
+	return svc.Run(serviceName, &windowsService{params: params})
+}
+
+// Execute is called by the SCM. It runs the daemon until the service is stopped.
+func (service *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (svcSpecificEC bool, exitCode uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	d, status, err := startDaemon(service.params)
+	if status != core.ExitSuccess {
+		if log, errLog := eventlog.Open(serviceName); errLog == nil {
+			log.Error(1, fmt.Sprintf("Error %d initializing backend: %v", status, err))
+			log.Close()
+		}
+		return true, uint32(status)
+	}
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPowerEvent}
+
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			changes <- request.CurrentStatus
+
+		case svc.Stop, svc.Shutdown:
+			changes <- svc.Status{State: svc.StopPending}
+			d.backend.LogError("Execute", "service stop requested, shutting down\n")
+			d.stop()
+			return false, 0
+
+		case svc.PowerEvent:
+			if request.EventType == pbtAPMResumeSuspend || request.EventType == pbtAPMResumeAutomatic {
+				d.backend.NetworkResume()
+			}
+		}
+	}
+
+	return false, 0
+}
@@ -0,0 +1,96 @@
+/*
+File Username:  Service.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Service integration helpers: PID file and systemd notify support, and installing the daemon as service that starts on boot.
+The platform specific service functions are in the files "Service [platform].go".
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Name of the service as registered with the operating system.
+const (
+	serviceName        = "peernetd"
+	serviceDisplayName = "Peernet Daemon"
+	serviceDescription = "Runs a Peernet node in the background."
+)
+
+// writePIDFile writes the current process ID to the file.
+func writePIDFile(filename string) (err error) {
+	return os.WriteFile(filename, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// systemdNotify sends a state notification to systemd. It does nothing if not started by systemd with Type=notify.
+// See https://www.freedesktop.org/software/systemd/man/sd_notify.html.
+func systemdNotify(state string) (err error) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// serviceCommand executes the service command: install, uninstall, or run.
+func serviceCommand(command string, params parameters) (err error) {
+	// The service is started by the operating system in a different working directory. The config file is referenced by its absolute path
+	// and the working directory is set to the folder of the config file, so that relative paths in the config remain valid.
+	if params.configFile, err = filepath.Abs(params.configFile); err != nil {
+		return err
+	}
+
+	switch command {
+	case "install":
+		return serviceInstall(params.serviceArguments())
+
+	case "uninstall":
+		return serviceUninstall()
+
+	case "run":
+		if err = os.Chdir(filepath.Dir(params.configFile)); err != nil {
+			return err
+		}
+		return serviceRun(params)
+
+	default:
+		return errors.New("unknown command, use install, uninstall, or run")
+	}
+}
+
+// serviceArguments returns the command line arguments to run the daemon as service with the same parameters.
+func (params parameters) serviceArguments() (arguments []string) {
+	arguments = []string{"-service", "run", "-config", params.configFile}
+
+	if params.pidFile != "" {
+		if pidFile, err := filepath.Abs(params.pidFile); err == nil {
+			arguments = append(arguments, "-pidfile", pidFile)
+		}
+	}
+	if params.webapiListen != "" {
+		arguments = append(arguments, "-webapi", params.webapiListen)
+	}
+	if params.apiKey != "" {
+		arguments = append(arguments, "-apikey", params.apiKey)
+	}
+	if params.gatewayListen != "" {
+		arguments = append(arguments, "-gateway", params.gatewayListen)
+	}
+
+	return arguments
+}
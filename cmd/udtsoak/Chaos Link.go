@@ -0,0 +1,129 @@
+/*
+File Username:  Chaos Link.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Simulated unreliable link between two UDT endpoints. Packets are subject to loss, duplication, latency, and jitter (which causes reordering).
+*/
+
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// chaosSettings defines the impairments of the link.
+type chaosSettings struct {
+	Loss      float64       // Probability of dropping a packet.
+	Duplicate float64       // Probability of duplicating a packet.
+	Reorder   float64       // Probability of delaying a packet by the jitter, which reorders it with following packets.
+	Latency   time.Duration // Base one-way latency.
+	Jitter    time.Duration // Max additional random delay for reordered packets.
+}
+
+// chaosStats counts what happened to packets on the link.
+type chaosStats struct {
+	Forwarded  uint64
+	Dropped    uint64
+	Duplicated uint64
+	Reordered  uint64
+}
+
+// chaosLink forwards packets from the input to the output channel, applying the impairments.
+type chaosLink struct {
+	settings  chaosSettings
+	in        chan []byte
+	out       chan []byte
+	terminate <-chan struct{}
+	random    *rand.Rand
+	randomMu  sync.Mutex
+	Stats     chaosStats
+}
+
+// StatsSnapshot returns a copy of the link statistics. It is safe to call while the link is forwarding packets.
+func (link *chaosLink) StatsSnapshot() chaosStats {
+	return chaosStats{
+		Forwarded:  atomic.LoadUint64(&link.Stats.Forwarded),
+		Dropped:    atomic.LoadUint64(&link.Stats.Dropped),
+		Duplicated: atomic.LoadUint64(&link.Stats.Duplicated),
+		Reordered:  atomic.LoadUint64(&link.Stats.Reordered),
+	}
+}
+
+func newChaosLink(settings chaosSettings, seed int64, terminate <-chan struct{}) (link *chaosLink) {
+	link = &chaosLink{
+		settings:  settings,
+		in:        make(chan []byte, 256),
+		out:       make(chan []byte, 256),
+		terminate: terminate,
+		random:    rand.New(rand.NewSource(seed)),
+	}
+
+	go link.run()
+
+	return link
+}
+
+func (link *chaosLink) float() float64 {
+	link.randomMu.Lock()
+	defer link.randomMu.Unlock()
+	return link.random.Float64()
+}
+
+func (link *chaosLink) run() {
+	for {
+		var packet []byte
+		select {
+		case packet = <-link.in:
+		case <-link.terminate:
+			return
+		}
+
+		if link.float() < link.settings.Loss {
+			atomic.AddUint64(&link.Stats.Dropped, 1)
+			continue
+		}
+
+		copies := 1
+		if link.float() < link.settings.Duplicate {
+			copies = 2
+			atomic.AddUint64(&link.Stats.Duplicated, 1)
+		}
+
+		for n := 0; n < copies; n++ {
+			delay := link.settings.Latency
+			if link.settings.Jitter > 0 && link.float() < link.settings.Reorder {
+				delay += time.Duration(link.float() * float64(link.settings.Jitter))
+				atomic.AddUint64(&link.Stats.Reordered, 1)
+			}
+
+			data := make([]byte, len(packet))
+			copy(data, packet)
+
+			link.deliver(data, delay)
+		}
+	}
+}
+
+// deliver forwards the packet after the delay.
+func (link *chaosLink) deliver(packet []byte, delay time.Duration) {
+	if delay <= 0 {
+		select {
+		case link.out <- packet:
+			atomic.AddUint64(&link.Stats.Forwarded, 1)
+		case <-link.terminate:
+		}
+		return
+	}
+
+	time.AfterFunc(delay, func() {
+		select {
+		case link.out <- packet:
+			atomic.AddUint64(&link.Stats.Forwarded, 1)
+		case <-link.terminate:
+		}
+	})
+}
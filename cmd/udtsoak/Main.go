@@ -0,0 +1,259 @@
+/*
+File Username:  Main.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Soak test for the UDT stack. It repeatedly transfers large amounts of pseudo-random data between a UDT client and server that are connected
+via a simulated unreliable link (loss, duplication, reordering, latency) and verifies the integrity of the received data.
+Some runs are randomly terminated in the middle of the transfer to verify that both sides shut down without hanging.
+
+Usage: udtsoak [-size 1024] [-runs 10] [-duration 0] [-loss 0.01] [-dup 0.005] [-reorder 0.02] [-latency 2ms] [-jitter 5ms] [-terminate 0.1] [-seed 0]
+
+The process exits with status 1 if any run failed.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/udt"
+)
+
+func main() {
+	var sizeMB, runs int
+	var duration time.Duration
+	var settings chaosSettings
+	var terminateProbability float64
+	var seed int64
+
+	flag.IntVar(&sizeMB, "size", 1024, "Size of data to transfer per run in MB")
+	flag.IntVar(&runs, "runs", 10, "Count of runs. 0 = unlimited (use -duration).")
+	flag.DurationVar(&duration, "duration", 0, "Max total duration, for example 8h. 0 = unlimited.")
+	flag.Float64Var(&settings.Loss, "loss", 0.01, "Packet loss probability")
+	flag.Float64Var(&settings.Duplicate, "dup", 0.005, "Packet duplication probability")
+	flag.Float64Var(&settings.Reorder, "reorder", 0.02, "Packet reordering probability")
+	flag.DurationVar(&settings.Latency, "latency", 2*time.Millisecond, "One-way latency")
+	flag.DurationVar(&settings.Jitter, "jitter", 5*time.Millisecond, "Max additional delay of reordered packets")
+	flag.Float64Var(&terminateProbability, "terminate", 0.1, "Probability of a run being terminated in the middle of the transfer")
+	flag.Int64Var(&seed, "seed", 0, "Random seed. 0 = time based.")
+	flag.Parse()
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	random := rand.New(rand.NewSource(seed))
+	fmt.Printf("UDT soak test: seed %d, %d MB per run, loss %.3f, dup %.3f, reorder %.3f, latency %s, jitter %s\n", seed, sizeMB, settings.Loss, settings.Duplicate, settings.Reorder, settings.Latency, settings.Jitter)
+
+	started := time.Now()
+	failures := 0
+
+	for run := 1; runs == 0 || run <= runs; run++ {
+		if duration > 0 && time.Since(started) >= duration {
+			break
+		}
+
+		size := uint64(sizeMB) * 1024 * 1024
+		terminateAt := int64(-1)
+		if random.Float64() < terminateProbability {
+			terminateAt = random.Int63n(int64(size))
+		}
+
+		result := soakRun(random.Int63(), size, settings, terminateAt)
+
+		status := "OK"
+		if result.err != nil {
+			status = "FAIL: " + result.err.Error()
+			failures++
+		}
+
+		fmt.Printf("Run %d: %s. %d bytes in %s (%.2f MB/s). Link: %d forwarded, %d dropped, %d duplicated, %d reordered. Terminated: %t\n",
+			run, status, result.received, result.elapsed.Round(time.Millisecond), float64(result.received)/1024/1024/result.elapsed.Seconds(),
+			result.stats.Forwarded, result.stats.Dropped, result.stats.Duplicated, result.stats.Reordered, terminateAt >= 0)
+	}
+
+	fmt.Printf("Finished after %s with %d failed runs.\n", time.Since(started).Round(time.Second), failures)
+
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+type soakResult struct {
+	received uint64
+	elapsed  time.Duration
+	stats    chaosStats
+	err      error
+}
+
+// nopCloser implements the udt.Closer interface.
+type nopCloser struct{}
+
+func (nopCloser) Close(reason int) error       { return nil }
+func (nopCloser) CloseLinger(reason int) error { return nil }
+
+// soakRun transfers size bytes of pseudo-random data from a UDT client to a UDT server over a chaos link.
+// If terminateAt is not negative, the transfer is terminated after that amount of bytes was sent.
+func soakRun(seed int64, size uint64, settings chaosSettings, terminateAt int64) (result soakResult) {
+	terminate := make(chan struct{})
+	defer func() {
+		select {
+		case <-terminate:
+		default:
+			close(terminate)
+		}
+	}()
+
+	linkAB := newChaosLink(settings, seed, terminate)   // client -> server
+	linkBA := newChaosLink(settings, seed+1, terminate) // server -> client
+
+	config := udt.DefaultConfig()
+	config.MaxPacketSize = protocol.TransferMaxEmbedSizeLite
+	config.MaxFlowWinSize = 64
+
+	started := time.Now()
+	timeout := time.Duration(size/(512*1024))*time.Second + time.Minute // assume at least 512 KB/s
+
+	listener := udt.ListenUDT(config, nopCloser{}, linkAB.out, linkBA.in, terminate)
+
+	// Sender
+	senderHash := make(chan []byte, 1)
+	senderErr := make(chan error, 1)
+
+	go func() {
+		conn, err := udt.DialUDT(config, nopCloser{}, linkBA.out, linkAB.in, terminate, true)
+		if err != nil {
+			senderErr <- err
+			return
+		}
+		defer conn.Close()
+
+		hash, err := writeData(conn, seed, size, terminateAt, terminate)
+		senderHash <- hash
+		senderErr <- err
+	}()
+
+	// Receiver
+	receiverHash := make(chan []byte, 1)
+	receiverErr := make(chan error, 1)
+	var received uint64
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			receiverErr <- err
+			return
+		}
+		defer conn.Close()
+
+		hash, n, err := readData(conn)
+		received = n
+		receiverHash <- hash
+		receiverErr <- err
+	}()
+
+	var errReceiver error
+	select {
+	case errReceiver = <-receiverErr:
+	case <-time.After(timeout):
+		result.err = errors.New("timeout, receiver did not finish")
+		result.elapsed = time.Since(started)
+		result.stats = linkAB.StatsSnapshot()
+		return result
+	}
+
+	result.elapsed = time.Since(started)
+	result.received = received
+	result.stats = linkAB.StatsSnapshot()
+
+	if terminateAt >= 0 {
+		// The transfer was terminated. Only verify that both sides returned.
+		select {
+		case <-senderErr:
+		case <-time.After(time.Minute):
+			result.err = errors.New("sender did not return after termination")
+		}
+		return result
+	}
+
+	if errReceiver != nil {
+		result.err = fmt.Errorf("receiver: %w", errReceiver)
+		return result
+	}
+
+	select {
+	case err := <-senderErr:
+		if err != nil {
+			result.err = fmt.Errorf("sender: %w", err)
+			return result
+		}
+	case <-time.After(time.Minute):
+		result.err = errors.New("sender did not return")
+		return result
+	}
+
+	if received != size {
+		result.err = fmt.Errorf("received %d bytes, expected %d", received, size)
+	} else if hashS, hashR := <-senderHash, <-receiverHash; string(hashS) != string(hashR) {
+		result.err = errors.New("data integrity check failed, hash mismatch")
+	}
+
+	return result
+}
+
+// writeData writes the size header followed by size bytes of pseudo-random data. It returns the hash of the data.
+func writeData(writer io.Writer, seed int64, size uint64, terminateAt int64, terminate chan struct{}) (hash []byte, err error) {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint64(header, size)
+	if _, err = writer.Write(header); err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	random := rand.New(rand.NewSource(seed))
+	buffer := make([]byte, 64*1024)
+
+	for written := uint64(0); written < size; {
+		chunk := buffer
+		if remaining := size - written; remaining < uint64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		random.Read(chunk)
+		hasher.Write(chunk)
+
+		if _, err = writer.Write(chunk); err != nil {
+			return nil, err
+		}
+		written += uint64(len(chunk))
+
+		if terminateAt >= 0 && written >= uint64(terminateAt) {
+			close(terminate)
+			return nil, nil
+		}
+	}
+
+	return hasher.Sum(nil), nil
+}
+
+// readData reads the size header and then the data. It returns the hash of the data and the count of bytes read.
+func readData(reader io.Reader) (hash []byte, n uint64, err error) {
+	header := make([]byte, 8)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint64(header)
+
+	hasher := sha256.New()
+	copied, err := io.CopyN(hasher, reader, int64(size))
+
+	return hasher.Sum(nil), uint64(copied), err
+}
@@ -0,0 +1,53 @@
+/*
+File Username:  Main.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Generates the wire format specification of all packets, messages, structures, stream protocols, and block records from the layouts in the code.
+The output is written to stdout, either as plain text tables or as JSON.
+
+Usage: wirespec [-json] [-category message]
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/PeernetOfficial/core/blockchain" // registers the layouts of blocks and block records
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+func main() {
+	var outputJSON bool
+	var category string
+
+	flag.BoolVar(&outputJSON, "json", false, "Output as JSON instead of plain text")
+	flag.StringVar(&category, "category", "", "Only output formats of the category: packet, message, structure, stream, record")
+	flag.Parse()
+
+	var formats []protocol.WireFormat
+	for _, format := range protocol.WireSpec() {
+		if category == "" || format.Category == category {
+			formats = append(formats, format)
+		}
+	}
+
+	if !outputJSON {
+		fmt.Print(protocol.WireSpecText(formats))
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(struct {
+		Version int                   `json:"version"`
+		Formats []protocol.WireFormat `json:"formats"`
+	}{Version: protocol.ProtocolVersion, Formats: formats}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -44,6 +44,12 @@ type DHT struct {
 
 	// TimeoutIR is the maximum an information request to a node may take.
 	TimeoutIR time.Duration
+
+	// LookupAlpha is the max count of information requests in flight in iterative lookups. 0 = alpha.
+	LookupAlpha int
+
+	// LookupBeta is the count of closest nodes that must have replied for an iterative lookup to converge. 0 = bucket size.
+	LookupBeta int
 }
 
 // NewDHT initializes a new DHT node with default values.
@@ -108,6 +114,48 @@ func (dht *DHT) IsNodeContact(ID []byte) (node *Node) {
 	return dht.ht.doesNodeExist(ID)
 }
 
+// EstimateSize estimates the count of nodes in the network including self based on the density of the buckets.
+// Bucket n covers 1/2^(bits-n) of the ID space. Buckets closer than the closest full bucket are assumed to contain all nodes of their range,
+// so the count of nodes in them is extrapolated to the entire ID space. If no bucket is full, all nodes are known.
+func (dht *DHT) EstimateSize() (size uint64) {
+	buckets := dht.ht.getTotalNodesPerBucket()
+
+	full := -1
+	total := 0
+	for n, count := range buckets {
+		if count >= dht.ht.bSize && full == -1 {
+			full = n
+		}
+		total += count
+	}
+
+	if full == -1 {
+		return uint64(total) + 1
+	}
+
+	closer := 0
+	for n := 0; n < full; n++ {
+		closer += buckets[n]
+	}
+
+	// The full bucket and the closer ones cover 1/2^(bits-full-1) of the ID space and contain at least bucket size + closer nodes.
+	shift := dht.ht.bBits - full - 1
+	if shift > 40 {
+		shift = 40 // Prevents overflow. Anything beyond a trillion nodes is an artifact of very few nodes in the routing table.
+	}
+
+	size = uint64(closer) << (shift + 1)
+	if lower := uint64(dht.ht.bSize+closer) << shift; lower > size {
+		size = lower
+	}
+
+	if size < uint64(total) {
+		size = uint64(total)
+	}
+
+	return size + 1
+}
+
 // ---- Synchronous network query functions below ----
 
 // Store informs the network about data stored locally.
@@ -0,0 +1,286 @@
+/*
+File Username:  Iterative Lookup.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Iterative lookups implement the classic Kademlia node lookup. Unlike the search client (which pushes closer results into nested levels), a
+single shortlist of the closest known nodes to the key is maintained:
+* Up to alpha information requests are in flight at any time. Each request goes to a single node, so that replies and timeouts are tracked per node.
+* The next node to query is always the closest uncontacted node among the k (bucket size) closest nodes that did not fail.
+* Nodes that do not reply within the information request timeout are considered failed and are skipped.
+* The lookup converges once the beta closest non-failed nodes have all replied. It also ends if there are no more nodes to query.
+  By default beta is k, which is the classic Kademlia termination. A smaller beta speeds up lookups at the cost of a lower success rate for values
+  that are not stored at the closest nodes.
+* FIND_VALUE: Nodes reported to store the value are queried with priority. The lookup ends once a node returns the data.
+* FIND_NODE: The lookup ends once the target node is reported by a node.
+*/
+
+package dht
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+)
+
+// LookupResult is the result of an iterative lookup.
+type LookupResult struct {
+	Key      []byte // Key that was looked up
+	Action   int    // ActionX
+	Found    bool   // Whether the node or value was found
+	SenderID []byte // Node that returned the data or reported the target node
+
+	// data for ActionFindNode
+	TargetNode *Node // The node that was looked up
+
+	// data for ActionFindValue
+	Data    []byte  // Actual data, if returned by a node
+	Storing []*Node // Nodes reported to store the value
+
+	Closest   []*Node       // Closest nodes to the key that replied, sorted by distance. Up to bucket size.
+	Queried   int           // Count of nodes queried
+	Failed    int           // Count of nodes that did not reply
+	Converged bool          // Whether the lookup converged. False if it timed out or ran out of nodes to query.
+	Duration  time.Duration // Duration of the lookup
+}
+
+// lookupNode is a node in the shortlist of an iterative lookup.
+type lookupNode struct {
+	node     *Node
+	distance []byte // XOR distance to the key
+	state    int    // lookupStateX
+}
+
+const (
+	lookupStateNew     = iota // Not queried yet
+	lookupStatePending        // Information request in flight
+	lookupStateReplied        // Replied
+	lookupStateFailed         // Did not reply within the timeout
+)
+
+// lookupReply contains all messages returned by a single queried node.
+type lookupReply struct {
+	node     *lookupNode
+	messages []*NodeMessage
+}
+
+// IterativeFindNode looks up the node via an iterative lookup using the parallelism LookupAlpha and LookupBeta of the DHT. Blocking!
+func (dht *DHT) IterativeFindNode(nodeID []byte) (result *LookupResult, err error) {
+	return dht.IterativeLookup(ActionFindNode, nodeID, dht.LookupAlpha, dht.LookupBeta)
+}
+
+// IterativeFindValue looks up the value via an iterative lookup using the parallelism LookupAlpha and LookupBeta of the DHT. Blocking!
+// If the data is not returned directly (larger values), the nodes storing it are returned in the result.
+func (dht *DHT) IterativeFindValue(key []byte) (result *LookupResult, err error) {
+	return dht.IterativeLookup(ActionFindValue, key, dht.LookupAlpha, dht.LookupBeta)
+}
+
+// IterativeLookup performs an iterative lookup for a node or value. Alpha is the max count of information requests in flight. Beta is the count of
+// closest nodes that must have replied for the lookup to converge. Values of 0 use the defaults alpha and k. Blocking!
+// The lookup may take up to TimeoutSearch, and each node may take up to TimeoutIR to reply.
+func (dht *DHT) IterativeLookup(action int, key []byte, alpha, beta int) (result *LookupResult, err error) {
+	if len(key)*8 != dht.ht.bBits {
+		return nil, errors.New("invalid key size")
+	} else if action != ActionFindNode && action != ActionFindValue {
+		return nil, errors.New("invalid action")
+	}
+
+	if alpha <= 0 {
+		alpha = dht.alpha
+	}
+	k := dht.ht.bSize
+	if beta <= 0 || beta > k {
+		beta = k
+	}
+
+	result = &LookupResult{Key: key, Action: action}
+	timeStart := time.Now()
+	timeout := time.After(dht.TimeoutSearch)
+
+	done := make(chan struct{})
+	defer close(done)
+	replies := make(chan lookupReply, alpha)
+
+	var shortlist []*lookupNode
+	known := make(map[string]*lookupNode)
+	var priority []*lookupNode // Nodes reported to store the value, queried first
+	pending := 0
+
+	add := func(node *Node) (entry *lookupNode) {
+		if entry = known[string(node.ID)]; entry != nil || bytes.Equal(node.ID, dht.ht.Self.ID) {
+			return entry
+		}
+		entry = &lookupNode{node: node, distance: getDistance(node.ID, key).FillBytes(make([]byte, len(key)))}
+		known[string(node.ID)] = entry
+		shortlist = append(shortlist, entry)
+		return entry
+	}
+
+	query := func(entry *lookupNode) {
+		entry.state = lookupStatePending
+		pending++
+		result.Queried++
+
+		info := dht.NewInformationRequest(action, key, []*Node{entry.node})
+
+		switch action {
+		case ActionFindNode:
+			dht.SendRequestFindNode(info)
+		case ActionFindValue:
+			dht.SendRequestFindValue(info)
+		}
+
+		go func() {
+			select {
+			case <-info.TerminateSignal:
+			case <-time.After(dht.TimeoutIR):
+			case <-done:
+			}
+			info.Terminate()
+
+			var messages []*NodeMessage
+			for message := range info.ResultChan {
+				messages = append(messages, message)
+			}
+
+			select {
+			case replies <- lookupReply{node: entry, messages: messages}:
+			case <-done:
+			}
+		}()
+	}
+
+	finish := func(converged bool) (*LookupResult, error) {
+		result.Converged = converged
+		result.Duration = time.Since(timeStart)
+
+		for _, entry := range shortlist {
+			if entry.state == lookupStateReplied && len(result.Closest) < k {
+				result.Closest = append(result.Closest, entry.node)
+			}
+		}
+
+		return result, nil
+	}
+
+	for _, node := range dht.ht.getClosestContacts(k, key, nil).Nodes {
+		if action == ActionFindNode && bytes.Equal(node.ID, key) {
+			result.Found, result.TargetNode = true, node
+			return finish(true)
+		}
+		add(node)
+	}
+
+	for {
+		sort.Slice(shortlist, func(i, j int) bool { return bytes.Compare(shortlist[i].distance, shortlist[j].distance) < 0 })
+
+		if lookupConverged(shortlist, beta) && (action != ActionFindValue || !lookupStoringOpen(known, result.Storing)) {
+			return finish(true)
+		}
+
+		// Fill the in-flight requests up to alpha. Storing nodes first, then the closest uncontacted nodes among the k closest.
+		for pending < alpha && len(priority) > 0 {
+			if priority[0].state == lookupStateNew {
+				query(priority[0])
+			}
+			priority = priority[1:]
+		}
+
+		active := 0
+		for _, entry := range shortlist {
+			if pending >= alpha || active >= k {
+				break
+			}
+			if entry.state == lookupStateFailed {
+				continue
+			}
+			active++
+			if entry.state == lookupStateNew {
+				query(entry)
+			}
+		}
+
+		if pending == 0 {
+			return finish(false)
+		}
+
+		select {
+		case <-timeout:
+			return finish(false)
+
+		case reply := <-replies:
+			pending--
+
+			if len(reply.messages) == 0 {
+				reply.node.state = lookupStateFailed
+				result.Failed++
+				continue
+			}
+			reply.node.state = lookupStateReplied
+
+			for _, message := range reply.messages {
+				if action == ActionFindValue && len(message.Data) > 0 {
+					result.Found, result.SenderID, result.Data = true, message.SenderID, message.Data
+					return finish(true)
+				}
+
+				for _, node := range message.Closest {
+					if action == ActionFindNode && bytes.Equal(node.ID, key) {
+						result.Found, result.SenderID, result.TargetNode = true, message.SenderID, node
+						return finish(true)
+					}
+					add(node)
+				}
+
+				for _, node := range message.Storing {
+					entry := add(node)
+					if entry == nil || len(result.Storing) >= MaxAcceptKnownStore || lookupContains(result.Storing, node.ID) {
+						continue
+					}
+					result.Storing = append(result.Storing, node)
+					if entry.state == lookupStateNew {
+						priority = append(priority, entry)
+					}
+				}
+			}
+		}
+	}
+}
+
+// lookupConverged checks if the beta closest non-failed nodes replied. If there are fewer non-failed nodes, all of them must have replied.
+// The shortlist must be sorted.
+func lookupConverged(shortlist []*lookupNode, beta int) bool {
+	count := 0
+	for _, entry := range shortlist {
+		if entry.state == lookupStateFailed {
+			continue
+		} else if entry.state != lookupStateReplied {
+			return false
+		}
+		if count++; count == beta {
+			break
+		}
+	}
+	return count > 0
+}
+
+// lookupStoringOpen checks if any node reported to store the value was not queried yet or did not reply yet.
+func lookupStoringOpen(known map[string]*lookupNode, storing []*Node) bool {
+	for _, node := range storing {
+		if entry := known[string(node.ID)]; entry != nil && (entry.state == lookupStateNew || entry.state == lookupStatePending) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupContains checks if the list contains the node.
+func lookupContains(nodes []*Node, ID []byte) bool {
+	for _, node := range nodes {
+		if bytes.Equal(node.ID, ID) {
+			return true
+		}
+	}
+	return false
+}
@@ -131,3 +131,15 @@ type NodeMessage struct {
 
 // NodeFilterFunc is called to filter nodes based on the callers choice
 type NodeFilterFunc func(node *Node) (accept bool)
+
+// FilterAll combines multiple filters. A node is accepted only if all filters accept it. Nil filters are ignored.
+func FilterAll(filters ...NodeFilterFunc) NodeFilterFunc {
+	return func(node *Node) (accept bool) {
+		for _, filter := range filters {
+			if filter != nil && !filter(node) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,139 @@
+/*
+File Username:  Content.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+File content, file records, blockchains, and warehouses. File number n always has the same content, hash, ID, name, and size.
+Blockchains and warehouses must be created in new directories, otherwise existing data would be mixed with the fixtures.
+*/
+
+package fixtures
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/merkle"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/warehouse"
+	"github.com/google/uuid"
+)
+
+// Date is the date of all records in blockchains created by Blockchain.
+var Date = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Folder is the folder of all files.
+const Folder = "fixtures"
+
+// fileNamespace is the namespace of the file IDs.
+var fileNamespace = uuid.MustParse("8f4d5a1e-7b2c-4e36-9a0d-3c1f6b9e2d47")
+
+// FileSize returns the size of file number n. The sizes vary so that files have different fragment and chunk counts.
+func FileSize(n int) int {
+	return 1024 + n*4099
+}
+
+// FileName returns the name of file number n.
+func FileName(n int) string {
+	return "File " + strconv.Itoa(n) + ".txt"
+}
+
+// FileData returns the content of file number n.
+func FileData(n int) (data []byte) {
+	data = make([]byte, FileSize(n))
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// File returns the file record of file number n. The node ID is not set.
+func File(n int) (file blockchain.BlockRecordFile, err error) {
+	data := FileData(n)
+
+	file = blockchain.BlockRecordFile{Hash: protocol.HashData(data), Type: core.TypeText, Format: core.FormatText, Size: uint64(len(data))}
+	file.ID = uuid.NewSHA1(fileNamespace, []byte(strconv.Itoa(n)))
+	file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagName, FileName(n)))
+	file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagFolder, Folder))
+
+	file.FragmentSize = merkle.CalculateFragmentSize(file.Size)
+	tree, err := merkle.NewMerkleTree(file.Size, file.FragmentSize, bytes.NewBuffer(data))
+	if err != nil {
+		return file, err
+	}
+	file.MerkleRootHash = tree.RootHash
+
+	return file, nil
+}
+
+// Files returns the file records of the files 0 to count-1.
+func Files(count int) (files []blockchain.BlockRecordFile, err error) {
+	for n := 0; n < count; n++ {
+		file, err := File(n)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// Blockchain creates the blockchain of key number n in the new path. The first block contains the profile name "Fixture n", the second one the files 0 to
+// countFiles-1. Blockchains created with the same parameters are identical, including all block hashes and signatures.
+// RecordDate of the returned blockchain is set to Date, so that records appended by the caller are deterministic as well.
+func Blockchain(path string, n int, countFiles int) (chain *blockchain.Blockchain, err error) {
+	if _, err = os.Stat(path); err == nil {
+		return nil, errors.New("blockchain path already exists")
+	}
+
+	files, err := Files(countFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, _ := KeyPair(n)
+	if chain, err = blockchain.Init(privateKey, path); err != nil {
+		return nil, err
+	}
+	chain.RecordDate = Date
+
+	if _, _, status := chain.ProfileWrite([]blockchain.BlockRecordProfile{blockchain.ProfileFieldFromText(blockchain.ProfileName, "Fixture "+strconv.Itoa(n))}); status != blockchain.StatusOK {
+		return nil, errors.New("error writing profile to blockchain")
+	}
+
+	if len(files) > 0 {
+		if _, _, status := chain.AddFiles(files); status != blockchain.StatusOK {
+			return nil, errors.New("error adding files to blockchain")
+		}
+	}
+
+	return chain, nil
+}
+
+// Warehouse creates the warehouse in the new directory with the content of the files 0 to count-1. The hashes are in the order of the files.
+func Warehouse(directory string, count int) (wh *warehouse.Warehouse, hashes [][]byte, err error) {
+	if _, err = os.Stat(directory); err == nil {
+		return nil, nil, errors.New("warehouse directory already exists")
+	}
+
+	if wh, err = warehouse.Init(directory); err != nil {
+		return nil, nil, err
+	}
+
+	for n := 0; n < count; n++ {
+		data := FileData(n)
+
+		hash, _, err := wh.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return wh, hashes, nil
+}
@@ -0,0 +1,40 @@
+/*
+File Username:  Keys.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Package fixtures provides deterministic key pairs, blockchains, warehouse content, and local test networks for tests of applications using the core
+library. All fixtures are derived from a number, so that the same number always returns the same data across runs and platforms.
+
+The keys are derived from public seeds. They must never be used outside of tests.
+*/
+
+package fixtures
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// keySeed is the prefix of the data that private keys are derived from.
+const keySeed = "Peernet Fixture Key "
+
+// KeyPair returns the key pair number n.
+func KeyPair(n int) (privateKey *btcec.PrivateKey, publicKey *btcec.PublicKey) {
+	return btcec.PrivKeyFromBytes(btcec.S256(), protocol.HashData([]byte(keySeed+strconv.Itoa(n))))
+}
+
+// PrivateKeyHex returns the hex encoded private key number n, as used by the config setting PrivateKey.
+func PrivateKeyHex(n int) string {
+	privateKey, _ := KeyPair(n)
+	return hex.EncodeToString(privateKey.Serialize())
+}
+
+// PeerID returns the hex encoded compressed public key number n, which is the peer ID.
+func PeerID(n int) string {
+	_, publicKey := KeyPair(n)
+	return hex.EncodeToString(publicKey.SerializeCompressed())
+}
@@ -0,0 +1,89 @@
+/*
+File Username:  Network.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Local test networks. Node n uses the key pair n and listens on 127.0.0.1 at basePort+n. Each node has all other nodes in its seed list and its own
+data folder. UPnP, seed list updates, and the GeoIP database are disabled so that the network does not depend on the environment.
+*/
+
+package fixtures
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/PeernetOfficial/core"
+	"gopkg.in/yaml.v3"
+)
+
+// UserAgent is the user agent of nodes started by StartNetwork.
+const UserAgent = "Peernet Fixture/1.0"
+
+// NodeConfig returns the config of node n in a network of count nodes. The data folder is in the directory.
+func NodeConfig(directory string, n, count, basePort int) (config *core.Config, err error) {
+	config = &core.Config{}
+	if err = yaml.Unmarshal(core.ConfigDefault, config); err != nil {
+		return nil, err
+	}
+
+	config.DataFolder = filepath.Join(directory, "node "+strconv.Itoa(n)) + string(filepath.Separator)
+	config.GeoIPDatabase = ""
+	config.LogTarget = 0
+	config.Listen = []string{"127.0.0.1:" + strconv.Itoa(basePort+n)}
+	config.PrivateKey = PrivateKeyHex(n)
+	config.EnableUPnP = false
+	config.AutoUpdateSeedList = false
+	config.SeedList = nil
+
+	for m := 0; m < count; m++ {
+		if m != n {
+			config.SeedList = append(config.SeedList, core.PeerSeed{PublicKey: PeerID(m), Address: []string{"127.0.0.1:" + strconv.Itoa(basePort+m)}})
+		}
+	}
+
+	return config, nil
+}
+
+// NetworkConfigs writes the configs of a network of count nodes into the directory. The returned filenames are in the order of the nodes and can
+// be passed to core.Init.
+func NetworkConfigs(directory string, count, basePort int) (filenames []string, err error) {
+	for n := 0; n < count; n++ {
+		config, err := NodeConfig(directory, n, count, basePort)
+		if err != nil {
+			return nil, err
+		}
+
+		filename := filepath.Join(directory, "node "+strconv.Itoa(n)+".yaml")
+		if err = core.SaveConfig(filename, config); err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, filename)
+	}
+
+	return filenames, nil
+}
+
+// StartNetwork writes the configs of a network of count nodes into the directory, initializes all nodes, and connects them.
+// The nodes find each other via their seed lists; the caller should wait until the expected peers are in the peer lists.
+func StartNetwork(directory string, count, basePort int) (backends []*core.Backend, err error) {
+	filenames, err := NetworkConfigs(directory, count, basePort)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, filename := range filenames {
+		backend, status, err := core.Init(UserAgent, filename, nil, nil)
+		if status != core.ExitSuccess || err != nil {
+			return nil, fmt.Errorf("error initializing node %d (status %d): %v", n, status, err)
+		}
+		backends = append(backends, backend)
+	}
+
+	for _, backend := range backends {
+		backend.Connect()
+	}
+
+	return backends, nil
+}
@@ -0,0 +1,67 @@
+package fixtures
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/warehouse"
+)
+
+func TestBlockchainDeterministic(t *testing.T) {
+	directory := t.TempDir()
+
+	chain1, err := Blockchain(filepath.Join(directory, "chain 1"), 1, 3)
+	if err != nil {
+		t.Fatalf("Error creating blockchain: %s", err.Error())
+	}
+	chain2, err := Blockchain(filepath.Join(directory, "chain 2"), 1, 3)
+	if err != nil {
+		t.Fatalf("Error creating blockchain: %s", err.Error())
+	}
+
+	_, height1, _ := chain1.Header()
+	_, height2, _ := chain2.Header()
+	if height1 != 2 || height2 != 2 {
+		t.Fatalf("Unexpected blockchain height %d and %d", height1, height2)
+	}
+
+	for number := uint64(0); number < height1; number++ {
+		raw1, _, _ := chain1.GetBlockRaw(number)
+		raw2, _, _ := chain2.GetBlockRaw(number)
+		if len(raw1) == 0 || !bytes.Equal(raw1, raw2) {
+			t.Fatalf("Block %d differs", number)
+		}
+	}
+
+	files, status := chain1.ListFiles()
+	if status != blockchain.StatusOK || len(files) != 3 {
+		t.Fatalf("Unexpected file list with status %d and %d files", status, len(files))
+	}
+}
+
+func TestWarehouse(t *testing.T) {
+	wh, hashes, err := Warehouse(filepath.Join(t.TempDir(), "warehouse"), 2)
+	if err != nil {
+		t.Fatalf("Error creating warehouse: %s", err.Error())
+	}
+
+	for n, hash := range hashes {
+		file, err := File(n)
+		if err != nil {
+			t.Fatalf("Error creating file record: %s", err.Error())
+		}
+		if !bytes.Equal(hash, file.Hash) {
+			t.Fatalf("Hash of file %d differs from the file record", n)
+		}
+
+		var buffer bytes.Buffer
+		if status, _, err := wh.ReadFile(hash, 0, 0, &buffer); status != warehouse.StatusOK || err != nil {
+			t.Fatalf("Error reading file %d: status %d", n, status)
+		}
+		if !bytes.Equal(buffer.Bytes(), FileData(n)) {
+			t.Fatalf("Content of file %d differs", n)
+		}
+	}
+}
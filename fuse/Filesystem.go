@@ -0,0 +1,364 @@
+/*
+File Username:  Filesystem.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The virtual read-only filesystem tree:
+
+/My Shares/[folder]/[file]              Files shared by the user. The content is read from the warehouse.
+/Peers/[peer ID]/[folder]/[file]        Files shared by followed peers, as available in the global blockchain cache.
+
+File content of remote files is fetched on demand through the transfer layer. Files that are already in the local warehouse are read from there.
+*/
+
+package fuse
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/warehouse"
+	"github.com/PeernetOfficial/core/webapi"
+)
+
+// Names of the top-level directories
+const (
+	DirectoryMyShares = "My Shares"
+	DirectoryPeers    = "Peers"
+)
+
+// refreshInterval is the max age of the tree before it is rebuilt from the blockchains.
+const refreshInterval = 30 * time.Second
+
+// connectTimeout is the timeout for connecting to the peer storing a remote file.
+const connectTimeout = 10 * time.Second
+
+// rootInode is the inode of the root directory as expected by the kernel.
+const rootInode = 1
+
+// Filesystem is a read-only virtual filesystem of the user's shares and the shares of followed peers.
+type Filesystem struct {
+	backend *core.Backend
+	follow  []*btcec.PublicKey // Followed peers
+
+	root      *node             // Root of the tree.
+	nodes     map[uint64]*node  // All nodes by inode.
+	inodes    map[string]uint64 // Inodes by path. Inodes remain stable across rebuilds.
+	nextInode uint64            // Next inode to assign.
+	refreshed time.Time         // When the tree was last built.
+	sync.Mutex
+
+	mountpoint string                 // Directory where the filesystem is mounted.
+	device     *os.File               // FUSE device.
+	handles    map[uint64]*fileHandle // Open files by handle.
+	nextHandle uint64                 // Next file handle to assign.
+}
+
+// node is a file or directory in the tree.
+type node struct {
+	inode    uint64
+	path     string
+	name     string
+	isDir    bool
+	children []*node          // Directory only: Children sorted by name.
+	childMap map[string]*node // Directory only: Children by name.
+	modified time.Time        // Modification time.
+
+	// File only
+	size   uint64
+	hash   []byte
+	nodeID []byte // Node ID of the owner.
+}
+
+// New creates a new filesystem. Follow is the list of peers whose shares are mounted in addition to the user's own.
+func New(backend *core.Backend, follow []*btcec.PublicKey) (fs *Filesystem) {
+	fs = &Filesystem{
+		backend:   backend,
+		follow:    follow,
+		nodes:     make(map[uint64]*node),
+		inodes:    map[string]uint64{"": rootInode},
+		nextInode: rootInode + 1,
+		handles:   make(map[uint64]*fileHandle),
+	}
+	fs.refresh()
+
+	return fs
+}
+
+// refresh rebuilds the tree if it is outdated. The caller must hold the lock if the filesystem is in use.
+func (fs *Filesystem) refresh() {
+	if time.Since(fs.refreshed) < refreshInterval {
+		return
+	}
+
+	fs.nodes = make(map[uint64]*node)
+	fs.root = fs.newNode("", "", true, time.Now())
+
+	myShares := fs.newNode("/"+DirectoryMyShares, DirectoryMyShares, true, time.Now())
+	fs.addChild(fs.root, myShares)
+
+	if files, status := fs.backend.UserBlockchain.ListFiles(); status == blockchain.StatusOK {
+		fs.addFiles(myShares, files)
+	}
+
+	peers := fs.newNode("/"+DirectoryPeers, DirectoryPeers, true, time.Now())
+	fs.addChild(fs.root, peers)
+
+	for _, publicKey := range fs.follow {
+		peerID := hex.EncodeToString(publicKey.SerializeCompressed())
+		peerDir := fs.newNode("/"+DirectoryPeers+"/"+peerID, peerID, true, time.Now())
+		fs.addChild(peers, peerDir)
+
+		fs.addFiles(peerDir, fs.peerFiles(publicKey))
+	}
+
+	fs.refreshed = time.Now()
+}
+
+// peerFiles returns the files shared by the peer as available in the global blockchain cache.
+func (fs *Filesystem) peerFiles(publicKey *btcec.PublicKey) (files []blockchain.BlockRecordFile) {
+	if fs.backend.GlobalBlockchainCache == nil {
+		return nil
+	}
+
+	header, found, err := fs.backend.GlobalBlockchainCache.Store.ReadBlockchainHeader(publicKey)
+	if !found || err != nil {
+		return nil
+	}
+
+	for _, blockN := range header.ListBlocks {
+		blockDecoded, _, found, _ := fs.backend.ReadBlock(publicKey, header.Version, blockN)
+		if !found {
+			continue
+		}
+
+		for _, record := range blockDecoded.RecordsDecoded {
+			if file, ok := record.(blockchain.BlockRecordFile); ok {
+				files = append(files, file)
+			}
+		}
+	}
+
+	return files
+}
+
+// addFiles adds the files to the directory. Folders are created as needed. Expired files are skipped.
+func (fs *Filesystem) addFiles(dir *node, files []blockchain.BlockRecordFile) {
+	for n := range files {
+		file := &files[n]
+		if file.IsExpired() {
+			continue
+		}
+
+		var name, folder string
+		modified := time.Now()
+
+		if tag := file.GetTag(blockchain.TagName); tag != nil {
+			name = sanitizeName(tag.Text())
+		}
+		if tag := file.GetTag(blockchain.TagFolder); tag != nil {
+			folder = tag.Text()
+		}
+		if tag := file.GetTag(blockchain.TagDateShared); tag != nil {
+			if date, err := tag.Date(); err == nil {
+				modified = date
+			}
+		}
+		if name == "" {
+			name = hex.EncodeToString(file.Hash)
+		}
+
+		parent := dir
+		path := dir.path
+		for _, part := range strings.Split(strings.ReplaceAll(folder, "\\", "/"), "/") {
+			if part = sanitizeName(part); part == "" {
+				continue
+			}
+
+			path += "/" + part
+			child, ok := parent.childMap[part]
+			if !ok {
+				child = fs.newNode(path, part, true, modified)
+				fs.addChild(parent, child)
+			} else if !child.isDir {
+				break
+			}
+			parent = child
+		}
+
+		// Duplicate names get a counter appended.
+		uniqueName := name
+		for count := 2; parent.childMap[uniqueName] != nil; count++ {
+			uniqueName = name + " (" + strconv.Itoa(count) + ")"
+		}
+
+		fileNode := fs.newNode(parent.path+"/"+uniqueName, uniqueName, false, modified)
+		fileNode.size = file.Size
+		fileNode.hash = file.Hash
+		fileNode.nodeID = file.NodeID
+		fs.addChild(parent, fileNode)
+	}
+}
+
+// newNode creates a new node. The inode is reused if the path was known before.
+func (fs *Filesystem) newNode(path, name string, isDir bool, modified time.Time) (n *node) {
+	inode, ok := fs.inodes[path]
+	if !ok {
+		inode = fs.nextInode
+		fs.nextInode++
+		fs.inodes[path] = inode
+	}
+
+	n = &node{inode: inode, path: path, name: name, isDir: isDir, modified: modified}
+	if isDir {
+		n.childMap = make(map[string]*node)
+	}
+	fs.nodes[inode] = n
+
+	return n
+}
+
+// addChild adds the child to the directory, keeping the children sorted by name.
+func (fs *Filesystem) addChild(dir, child *node) {
+	dir.childMap[child.name] = child
+
+	index := sort.Search(len(dir.children), func(i int) bool { return dir.children[i].name >= child.name })
+	dir.children = append(dir.children, nil)
+	copy(dir.children[index+1:], dir.children[index:])
+	dir.children[index] = child
+}
+
+// sanitizeName removes characters that are not allowed in file names.
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if name == "." || name == ".." {
+		return ""
+	}
+
+	return name
+}
+
+// lookup returns the child of the directory.
+func (fs *Filesystem) lookup(parentInode uint64, name string) (n *node) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	fs.refresh()
+
+	if parent := fs.nodes[parentInode]; parent != nil && parent.isDir {
+		return parent.childMap[name]
+	}
+	return nil
+}
+
+// getNode returns the node by inode.
+func (fs *Filesystem) getNode(inode uint64) (n *node) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	return fs.nodes[inode]
+}
+
+// ---- file content ----
+
+var errNoPeer = errors.New("peer storing the file not available")
+
+// fileHandle is an open file. Sequential reads continue the same transfer; a read at a different offset starts a new one.
+type fileHandle struct {
+	fs       *Filesystem
+	node     *node
+	reader   io.ReadCloser // Active transfer, if any.
+	position uint64        // Offset of the next byte returned by the reader.
+	cancel   chan struct{} // Closed to cancel the active transfer.
+	sync.Mutex
+}
+
+// read reads the data at the offset. The returned data may be shorter at the end of the file.
+func (handle *fileHandle) read(offset uint64, size uint32) (data []byte, err error) {
+	if offset >= handle.node.size {
+		return nil, nil
+	}
+	if remaining := handle.node.size - offset; uint64(size) > remaining {
+		size = uint32(remaining)
+	}
+
+	backend := handle.fs.backend
+
+	// Files in the local warehouse are read directly.
+	if _, _, status, _ := backend.UserWarehouse.FileExists(handle.node.hash); status == warehouse.StatusOK {
+		var buffer bytes.Buffer
+		if status, _, err = backend.UserWarehouse.ReadFile(handle.node.hash, int64(offset), int64(size), &buffer); status != warehouse.StatusOK {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+
+	handle.Lock()
+	defer handle.Unlock()
+
+	if handle.reader == nil || handle.position != offset {
+		handle.closeTransfer()
+
+		peer, err := webapi.PeerConnectNode(backend, handle.node.nodeID, connectTimeout)
+		if err != nil {
+			return nil, errNoPeer
+		}
+
+		handle.cancel = make(chan struct{})
+		reader, _, _, err := webapi.FileStartReader(peer, handle.node.hash, offset, 0, handle.cancel)
+		if err != nil {
+			close(handle.cancel)
+			return nil, err
+		}
+
+		handle.reader = reader
+		handle.position = offset
+	}
+
+	data = make([]byte, size)
+	n, err := io.ReadFull(handle.reader, data)
+	handle.position += uint64(n)
+
+	if err != nil {
+		handle.closeTransfer()
+		if n == 0 {
+			return nil, err
+		}
+	}
+
+	return data[:n], nil
+}
+
+// closeTransfer closes the active transfer. The caller must hold the lock.
+func (handle *fileHandle) closeTransfer() {
+	if handle.reader != nil {
+		handle.reader.Close()
+		close(handle.cancel)
+		handle.reader = nil
+	}
+}
+
+// release closes the handle.
+func (handle *fileHandle) release() {
+	handle.Lock()
+	defer handle.Unlock()
+
+	handle.closeTransfer()
+}
@@ -0,0 +1,513 @@
+//go:build linux
+// +build linux
+
+/*
+File Username:  Kernel Linux.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Minimal implementation of the Linux FUSE kernel protocol (version 7) for a read-only filesystem. Only little endian architectures are supported.
+The filesystem is mounted via fusermount (which works for regular users) or directly via mount(2) if running as root.
+
+Request:  Header (40 bytes) followed by the opcode specific payload.
+Offset  Size    Info
+0       4       Length of the entire request
+4       4       Opcode
+8       8       Unique ID of the request
+16      8       Node ID (inode)
+24      4       UID
+28      4       GID
+32      4       PID
+36      4       Padding
+
+Reply:    Header (16 bytes) followed by the opcode specific payload.
+Offset  Size    Info
+0       4       Length of the entire reply
+4       4       Error as negative errno, 0 on success
+8       8       Unique ID of the request
+*/
+
+package fuse
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Opcodes
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opSetattr     = 4
+	opReadlink    = 5
+	opSymlink     = 6
+	opMknod       = 8
+	opMkdir       = 9
+	opUnlink      = 10
+	opRmdir       = 11
+	opRename      = 12
+	opLink        = 13
+	opOpen        = 14
+	opRead        = 15
+	opWrite       = 16
+	opStatfs      = 17
+	opRelease     = 18
+	opFsync       = 20
+	opSetxattr    = 21
+	opGetxattr    = 22
+	opListxattr   = 23
+	opRemovexattr = 24
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opFsyncdir    = 30
+	opAccess      = 34
+	opCreate      = 35
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+	opFallocate   = 43
+	opRename2     = 45
+)
+
+const (
+	kernelVersionMajor = 7
+	kernelVersionMinor = 26
+
+	inHeaderSize  = 40
+	outHeaderSize = 16
+	attrSize      = 88
+
+	maxWrite      = 128 * 1024         // Max size of a single write. Writes are not supported, but the kernel requires the read buffer to be large enough.
+	bufferSize    = maxWrite + 64*1024 // Size of the buffer for reading requests.
+	attrValid     = 1 * time.Second    // How long the kernel may cache attributes.
+	entryValid    = 1 * time.Second    // How long the kernel may cache lookups.
+	openKeepCache = 1 << 1             // FOPEN_KEEP_CACHE: The content of files never changes, as it is identified by hash.
+	mountOptions  = "ro,nosuid,nodev,default_permissions,fsname=peernet,subtype=peernet"
+)
+
+// Mount mounts the filesystem at the mountpoint, which must be an existing directory, and serves it in the background.
+func (fs *Filesystem) Mount(mountpoint string) (err error) {
+	if info, err := os.Stat(mountpoint); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return errors.New("mountpoint is not a directory")
+	}
+
+	device, err := mountFusermount(mountpoint)
+	if err != nil && os.Geteuid() == 0 {
+		device, err = mountDirect(mountpoint)
+	}
+	if err != nil {
+		return err
+	}
+
+	fs.mountpoint = mountpoint
+	fs.device = device
+
+	go fs.serve()
+
+	return nil
+}
+
+// Unmount unmounts the filesystem. Open files are closed by the kernel.
+func (fs *Filesystem) Unmount() (err error) {
+	if fs.device == nil {
+		return errors.New("not mounted")
+	}
+
+	if err = unix.Unmount(fs.mountpoint, 0); err != nil {
+		if output, err2 := exec.Command(fusermountBinary(), "-u", fs.mountpoint).CombinedOutput(); err2 != nil {
+			return errors.New(strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+// fusermountBinary returns the name of the available fusermount binary.
+func fusermountBinary() string {
+	if _, err := exec.LookPath("fusermount3"); err == nil {
+		return "fusermount3"
+	}
+	return "fusermount"
+}
+
+// mountFusermount mounts via the fusermount helper, which passes the opened FUSE device back via a Unix socket.
+func mountFusermount(mountpoint string) (device *os.File, err error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	local := os.NewFile(uintptr(fds[0]), "fusermount-local")
+	remote := os.NewFile(uintptr(fds[1]), "fusermount-remote")
+	defer local.Close()
+	defer remote.Close()
+
+	cmd := exec.Command(fusermountBinary(), "-o", mountOptions, "--", mountpoint)
+	cmd.ExtraFiles = []*os.File{remote} // becomes fd 3 in the child
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if len(output) > 0 {
+			return nil, errors.New(strings.TrimSpace(string(output)))
+		}
+		return nil, err
+	}
+
+	buffer := make([]byte, 4)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(fds[0], buffer, oob, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) == 0 {
+		return nil, errors.New("fusermount did not return the device")
+	}
+
+	deviceFds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(deviceFds) == 0 {
+		return nil, errors.New("fusermount did not return the device")
+	}
+
+	return os.NewFile(uintptr(deviceFds[0]), "/dev/fuse"), nil
+}
+
+// mountDirect mounts via mount(2). This requires root privileges.
+func mountDirect(mountpoint string) (device *os.File, err error) {
+	if device, err = os.OpenFile("/dev/fuse", os.O_RDWR, 0); err != nil {
+		return nil, err
+	}
+
+	data := "fd=" + strconv.Itoa(int(device.Fd())) + ",rootmode=40000,user_id=" + strconv.Itoa(os.Getuid()) + ",group_id=" + strconv.Itoa(os.Getgid()) + ",default_permissions"
+
+	if err = unix.Mount("peernet", mountpoint, "fuse.peernet", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, data); err != nil {
+		device.Close()
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// serve reads and handles requests from the kernel until the filesystem is unmounted.
+func (fs *Filesystem) serve() {
+	defer fs.device.Close()
+
+	buffer := make([]byte, bufferSize)
+
+	for {
+		n, err := fs.device.Read(buffer)
+		if err != nil {
+			var errno syscall.Errno
+			if errors.As(err, &errno) && (errno == syscall.ENOENT || errno == syscall.EINTR || errno == syscall.EAGAIN) {
+				continue // request was interrupted
+			}
+			return // ENODEV after unmount
+		}
+
+		if n < inHeaderSize {
+			continue
+		}
+
+		request := make([]byte, n)
+		copy(request, buffer[:n])
+
+		opcode := binary.LittleEndian.Uint32(request[4:8])
+		unique := binary.LittleEndian.Uint64(request[8:16])
+		inode := binary.LittleEndian.Uint64(request[16:24])
+		payload := request[inHeaderSize:]
+
+		switch opcode {
+		case opRead:
+			// Reads may block on the network and are therefore handled concurrently.
+			go fs.handleRead(unique, payload)
+
+		case opDestroy:
+			fs.reply(unique, 0, nil)
+			return
+
+		default:
+			fs.handle(opcode, unique, inode, payload)
+		}
+	}
+}
+
+// reply sends the reply to a request. Data is ignored in case of error.
+func (fs *Filesystem) reply(unique uint64, errno syscall.Errno, data []byte) {
+	if errno != 0 {
+		data = nil
+	}
+
+	raw := make([]byte, outHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(raw[0:4], uint32(len(raw)))
+	binary.LittleEndian.PutUint32(raw[4:8], uint32(-int32(errno)))
+	binary.LittleEndian.PutUint64(raw[8:16], unique)
+	copy(raw[outHeaderSize:], data)
+
+	fs.device.Write(raw)
+}
+
+// handle handles all requests except reads.
+func (fs *Filesystem) handle(opcode uint32, unique, inode uint64, payload []byte) {
+	switch opcode {
+	case opInit:
+		if len(payload) < 16 {
+			fs.reply(unique, syscall.EPROTO, nil)
+			return
+		}
+
+		major := binary.LittleEndian.Uint32(payload[0:4])
+		minor := binary.LittleEndian.Uint32(payload[4:8])
+		if major != kernelVersionMajor {
+			fs.reply(unique, syscall.EPROTO, nil)
+			return
+		}
+		if minor > kernelVersionMinor {
+			minor = kernelVersionMinor
+		}
+
+		raw := make([]byte, 64)
+		binary.LittleEndian.PutUint32(raw[0:4], kernelVersionMajor)
+		binary.LittleEndian.PutUint32(raw[4:8], minor)
+		copy(raw[8:12], payload[8:12])                // max readahead as proposed by the kernel
+		binary.LittleEndian.PutUint16(raw[16:18], 16) // max background requests
+		binary.LittleEndian.PutUint16(raw[18:20], 12) // congestion threshold
+		binary.LittleEndian.PutUint32(raw[20:24], maxWrite)
+		binary.LittleEndian.PutUint32(raw[24:28], 1) // time granularity in ns
+		fs.reply(unique, 0, raw)
+
+	case opLookup:
+		name := string(payload)
+		if index := strings.IndexByte(name, 0); index >= 0 {
+			name = name[:index]
+		}
+
+		n := fs.lookup(inode, name)
+		if n == nil {
+			fs.reply(unique, syscall.ENOENT, nil)
+			return
+		}
+
+		raw := make([]byte, 40+attrSize)
+		binary.LittleEndian.PutUint64(raw[0:8], n.inode)
+		binary.LittleEndian.PutUint64(raw[16:24], uint64(entryValid/time.Second))
+		binary.LittleEndian.PutUint64(raw[24:32], uint64(attrValid/time.Second))
+		encodeAttr(raw[40:], n)
+		fs.reply(unique, 0, raw)
+
+	case opGetattr:
+		n := fs.getNode(inode)
+		if n == nil {
+			fs.reply(unique, syscall.ENOENT, nil)
+			return
+		}
+
+		raw := make([]byte, 16+attrSize)
+		binary.LittleEndian.PutUint64(raw[0:8], uint64(attrValid/time.Second))
+		encodeAttr(raw[16:], n)
+		fs.reply(unique, 0, raw)
+
+	case opOpen:
+		n := fs.getNode(inode)
+		if n == nil {
+			fs.reply(unique, syscall.ENOENT, nil)
+			return
+		} else if n.isDir {
+			fs.reply(unique, syscall.EISDIR, nil)
+			return
+		} else if len(payload) < 4 || binary.LittleEndian.Uint32(payload[0:4])&syscall.O_ACCMODE != syscall.O_RDONLY {
+			fs.reply(unique, syscall.EROFS, nil)
+			return
+		}
+
+		fs.Lock()
+		fs.nextHandle++
+		handleID := fs.nextHandle
+		fs.handles[handleID] = &fileHandle{fs: fs, node: n}
+		fs.Unlock()
+
+		fs.reply(unique, 0, encodeOpenOut(handleID, openKeepCache))
+
+	case opRelease:
+		if len(payload) < 8 {
+			fs.reply(unique, syscall.EINVAL, nil)
+			return
+		}
+		handleID := binary.LittleEndian.Uint64(payload[0:8])
+
+		fs.Lock()
+		handle := fs.handles[handleID]
+		delete(fs.handles, handleID)
+		fs.Unlock()
+
+		if handle != nil {
+			handle.release()
+		}
+		fs.reply(unique, 0, nil)
+
+	case opOpendir:
+		if n := fs.getNode(inode); n == nil || !n.isDir {
+			fs.reply(unique, syscall.ENOTDIR, nil)
+			return
+		}
+		fs.reply(unique, 0, encodeOpenOut(0, 0))
+
+	case opReaddir:
+		if len(payload) < 20 {
+			fs.reply(unique, syscall.EINVAL, nil)
+			return
+		}
+		offset := binary.LittleEndian.Uint64(payload[8:16])
+		size := binary.LittleEndian.Uint32(payload[16:20])
+
+		fs.reply(unique, 0, fs.readDir(inode, offset, size))
+
+	case opStatfs:
+		raw := make([]byte, 80)
+		binary.LittleEndian.PutUint32(raw[40:44], 4096) // block size
+		binary.LittleEndian.PutUint32(raw[44:48], 255)  // max name length
+		binary.LittleEndian.PutUint32(raw[48:52], 4096) // fragment size
+		fs.reply(unique, 0, raw)
+
+	case opAccess:
+		if len(payload) >= 4 && binary.LittleEndian.Uint32(payload[0:4])&unix.W_OK != 0 {
+			fs.reply(unique, syscall.EROFS, nil)
+			return
+		}
+		fs.reply(unique, 0, nil)
+
+	case opFlush, opReleasedir, opFsync, opFsyncdir:
+		fs.reply(unique, 0, nil)
+
+	case opForget, opBatchForget, opInterrupt:
+		// no reply
+
+	case opSetattr, opSymlink, opMknod, opMkdir, opUnlink, opRmdir, opRename, opRename2, opLink, opWrite, opCreate, opSetxattr, opRemovexattr, opFallocate:
+		fs.reply(unique, syscall.EROFS, nil)
+
+	default: // includes opReadlink, opGetxattr, opListxattr
+		fs.reply(unique, syscall.ENOSYS, nil)
+	}
+}
+
+// handleRead handles a read request.
+func (fs *Filesystem) handleRead(unique uint64, payload []byte) {
+	if len(payload) < 20 {
+		fs.reply(unique, syscall.EINVAL, nil)
+		return
+	}
+
+	handleID := binary.LittleEndian.Uint64(payload[0:8])
+	offset := binary.LittleEndian.Uint64(payload[8:16])
+	size := binary.LittleEndian.Uint32(payload[16:20])
+
+	fs.Lock()
+	handle := fs.handles[handleID]
+	fs.Unlock()
+
+	if handle == nil {
+		fs.reply(unique, syscall.EBADF, nil)
+		return
+	}
+
+	data, err := handle.read(offset, size)
+	if err != nil {
+		fs.backend.LogError("fuse.handleRead", "reading file '%s': %v\n", handle.node.path, err)
+		fs.reply(unique, syscall.EIO, nil)
+		return
+	}
+
+	fs.reply(unique, 0, data)
+}
+
+// readDir returns the directory entries starting at the offset, up to size bytes.
+// Offsets 0 and 1 are the entries "." and "..", followed by the children.
+func (fs *Filesystem) readDir(inode, offset uint64, size uint32) (raw []byte) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	dir := fs.nodes[inode]
+	if dir == nil || !dir.isDir {
+		return nil
+	}
+
+	for index := offset; index < uint64(len(dir.children))+2; index++ {
+		var name string
+		var entryInode uint64
+		entryType := uint32(unix.DT_DIR)
+
+		switch index {
+		case 0:
+			name, entryInode = ".", dir.inode
+		case 1:
+			name, entryInode = "..", dir.inode // the kernel resolves the parent itself
+		default:
+			child := dir.children[index-2]
+			name, entryInode = child.name, child.inode
+			if !child.isDir {
+				entryType = unix.DT_REG
+			}
+		}
+
+		entrySize := (24 + len(name) + 7) &^ 7
+		if len(raw)+entrySize > int(size) {
+			break
+		}
+
+		entry := make([]byte, entrySize)
+		binary.LittleEndian.PutUint64(entry[0:8], entryInode)
+		binary.LittleEndian.PutUint64(entry[8:16], index+1) // offset of the next entry
+		binary.LittleEndian.PutUint32(entry[16:20], uint32(len(name)))
+		binary.LittleEndian.PutUint32(entry[20:24], entryType)
+		copy(entry[24:], name)
+
+		raw = append(raw, entry...)
+	}
+
+	return raw
+}
+
+// encodeAttr encodes the attributes of the node (fuse_attr).
+func encodeAttr(raw []byte, n *node) {
+	mode := uint32(syscall.S_IFREG | 0444)
+	nlink := uint32(1)
+	if n.isDir {
+		mode = syscall.S_IFDIR | 0555
+		nlink = 2
+	}
+
+	modified := uint64(n.modified.Unix())
+
+	binary.LittleEndian.PutUint64(raw[0:8], n.inode)
+	binary.LittleEndian.PutUint64(raw[8:16], n.size)
+	binary.LittleEndian.PutUint64(raw[16:24], (n.size+511)/512)
+	binary.LittleEndian.PutUint64(raw[24:32], modified) // atime
+	binary.LittleEndian.PutUint64(raw[32:40], modified) // mtime
+	binary.LittleEndian.PutUint64(raw[40:48], modified) // ctime
+	binary.LittleEndian.PutUint32(raw[60:64], mode)
+	binary.LittleEndian.PutUint32(raw[64:68], nlink)
+	binary.LittleEndian.PutUint32(raw[68:72], uint32(os.Getuid()))
+	binary.LittleEndian.PutUint32(raw[72:76], uint32(os.Getgid()))
+	binary.LittleEndian.PutUint32(raw[80:84], 4096) // block size
+}
+
+// encodeOpenOut encodes the reply to open requests (fuse_open_out).
+func encodeOpenOut(handleID uint64, flags uint32) (raw []byte) {
+	raw = make([]byte, 16)
+	binary.LittleEndian.PutUint64(raw[0:8], handleID)
+	binary.LittleEndian.PutUint32(raw[8:12], flags)
+	return raw
+}
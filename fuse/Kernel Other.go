@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+/*
+File Username:  Kernel Other.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package fuse
+
+import "errors"
+
+var errNotSupported = errors.New("FUSE is only supported on Linux")
+
+// Mount is not supported on this platform.
+func (fs *Filesystem) Mount(mountpoint string) (err error) {
+	return errNotSupported
+}
+
+// Unmount is not supported on this platform.
+func (fs *Filesystem) Unmount() (err error) {
+	return errNotSupported
+}
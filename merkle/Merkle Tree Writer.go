@@ -0,0 +1,102 @@
+/*
+File Username:  Merkle Tree Writer.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Generates the merkle tree on the fly while the data is written, for example when storing a file. The result is the same as NewMerkleTree,
+but the data does not have to be read again.
+*/
+
+package merkle
+
+import (
+	"errors"
+	"hash"
+
+	"lukechampine.com/blake3"
+)
+
+// TreeWriter creates the merkle tree from the data written to it. The file size must be known in advance.
+type TreeWriter struct {
+	tree           *MerkleTree
+	hasher         hash.Hash // Hash of the current fragment.
+	fragmentFilled uint64    // Bytes written to the current fragment.
+	written        uint64    // Total bytes written.
+}
+
+// NewTreeWriter creates a new writer that creates the merkle tree of the data written to it.
+func NewTreeWriter(fileSize, fragmentSize uint64) (writer *TreeWriter, err error) {
+	if fragmentSize == 0 {
+		return nil, errors.New("invalid fragment size")
+	}
+
+	return &TreeWriter{
+		tree: &MerkleTree{
+			FileSize:      fileSize,
+			FragmentSize:  fragmentSize,
+			FragmentCount: fileSizeToFragmentCount(fileSize, fragmentSize),
+		},
+		hasher: blake3.New(32, nil),
+	}, nil
+}
+
+// Write hashes the data. It never fails, so that it can be used with io.MultiWriter; data exceeding the file size is detected by Finalize.
+func (writer *TreeWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	writer.written += uint64(n)
+
+	if writer.written > writer.tree.FileSize {
+		return n, nil
+	}
+
+	// A single fragment is hashed as a whole.
+	if writer.tree.FragmentCount <= 1 {
+		writer.hasher.Write(p)
+		return n, nil
+	}
+
+	for len(p) > 0 {
+		size := writer.tree.FragmentSize - writer.fragmentFilled
+		if size > uint64(len(p)) {
+			size = uint64(len(p))
+		}
+
+		writer.hasher.Write(p[:size])
+		writer.fragmentFilled += size
+		p = p[size:]
+
+		if writer.fragmentFilled == writer.tree.FragmentSize {
+			writer.finishFragment()
+		}
+	}
+
+	return n, nil
+}
+
+// finishFragment adds the hash of the current fragment.
+func (writer *TreeWriter) finishFragment() {
+	writer.tree.FragmentHashes = append(writer.tree.FragmentHashes, writer.hasher.Sum(nil))
+	writer.hasher.Reset()
+	writer.fragmentFilled = 0
+}
+
+// Finalize returns the merkle tree. It fails if the count of bytes written does not match the file size.
+func (writer *TreeWriter) Finalize() (tree *MerkleTree, err error) {
+	if writer.written != writer.tree.FileSize {
+		return nil, errors.New("data size does not match the file size")
+	}
+
+	if writer.tree.FragmentCount <= 1 {
+		writer.tree.RootHash = writer.hasher.Sum(nil)
+		return writer.tree, nil
+	}
+
+	// The last fragment may be smaller than the fragment size.
+	if writer.fragmentFilled > 0 {
+		writer.finishFragment()
+	}
+
+	writer.tree.calculateMiddleHashes(0)
+
+	return writer.tree, nil
+}
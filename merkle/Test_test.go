@@ -137,3 +137,50 @@ func TestMerkleFileExport(t *testing.T) {
 
 	fmt.Printf("Success. Import/export match.\n")
 }
+
+func TestTreeWriter(t *testing.T) {
+	for _, dataSize := range []uint64{0, 100, MinimumFragmentSize, 3*MinimumFragmentSize + 7, 11*1024*1024 + 100} {
+		data := make([]byte, dataSize)
+		if _, err := io.ReadFull(rand.Reader, data); err != nil {
+			t.Fatal(err)
+		}
+
+		fragmentSize := CalculateFragmentSize(dataSize)
+
+		expected, err := NewMerkleTree(dataSize, fragmentSize, bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatalf("Error creating merkle tree: %v", err)
+		}
+
+		writer, err := NewTreeWriter(dataSize, fragmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Odd write sizes so that writes span fragment boundaries.
+		for remaining := data; len(remaining) > 0; {
+			size := 12345
+			if size > len(remaining) {
+				size = len(remaining)
+			}
+			writer.Write(remaining[:size])
+			remaining = remaining[size:]
+		}
+
+		tree, err := writer.Finalize()
+		if err != nil {
+			t.Fatalf("Error finalizing merkle tree: %v", err)
+		}
+
+		if !bytes.Equal(tree.Export(), expected.Export()) {
+			t.Fatalf("Merkle tree mismatch for data size %d", dataSize)
+		}
+	}
+
+	// Size mismatch
+	writer, _ := NewTreeWriter(100, MinimumFragmentSize)
+	writer.Write(make([]byte, 99))
+	if _, err := writer.Finalize(); err == nil {
+		t.Fatal("Size mismatch not detected")
+	}
+}
@@ -0,0 +1,304 @@
+/*
+File Username:  Blake3 Tree.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Blake3 is internally a binary hash tree over 1 KB chunks. This allows to prove that a single chunk is part of the data identified by its blake3 hash
+without access to the other data: The proof consists of the chunk and the chaining values of all sibling subtrees on the path to the root.
+The blake3 package does not expose chaining values, therefore the compression function is implemented here as per the blake3 specification.
+
+Tree layout: A subtree covering n > 1 chunks is split into a left subtree with the largest power of 2 count of chunks less than n, and a right
+subtree with the remaining chunks. The root node is finalized with the ROOT flag. Data of up to 1 KB is a single chunk that is the root.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Blake3ChunkSize is the size of a blake3 chunk. Proofs are created for single chunks.
+const Blake3ChunkSize = 1024
+
+// Blake3ProofMaxSiblings is the max count of sibling chaining values in a proof. It covers data sizes up to 2^64 chunks.
+const Blake3ProofMaxSiblings = 64
+
+const blake3BlockSize = 64
+
+// blake3SubtreeBuffer is the max size of a subtree read at once to calculate its chaining value. Larger subtrees are split.
+const blake3SubtreeBuffer = 1024 * 1024
+
+// blake3 domain flags
+const (
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(state *[16]uint32, a, b, c, d int, mx, my uint32) {
+	state[a] = state[a] + state[b] + mx
+	state[d] = bits.RotateLeft32(state[d]^state[a], -16)
+	state[c] = state[c] + state[d]
+	state[b] = bits.RotateLeft32(state[b]^state[c], -12)
+	state[a] = state[a] + state[b] + my
+	state[d] = bits.RotateLeft32(state[d]^state[a], -8)
+	state[c] = state[c] + state[d]
+	state[b] = bits.RotateLeft32(state[b]^state[c], -7)
+}
+
+// blake3Compress is the blake3 compression function. It returns the first 8 words of the output, which is the chaining value.
+func blake3Compress(cv [8]uint32, block [16]uint32, counter uint64, blockLen, flags uint32) (result [8]uint32) {
+	state := [16]uint32{cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7], blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3], uint32(counter), uint32(counter >> 32), blockLen, flags}
+
+	for round := 0; round < 7; round++ {
+		blake3G(&state, 0, 4, 8, 12, block[0], block[1])
+		blake3G(&state, 1, 5, 9, 13, block[2], block[3])
+		blake3G(&state, 2, 6, 10, 14, block[4], block[5])
+		blake3G(&state, 3, 7, 11, 15, block[6], block[7])
+		blake3G(&state, 0, 5, 10, 15, block[8], block[9])
+		blake3G(&state, 1, 6, 11, 12, block[10], block[11])
+		blake3G(&state, 2, 7, 8, 13, block[12], block[13])
+		blake3G(&state, 3, 4, 9, 14, block[14], block[15])
+
+		var permuted [16]uint32
+		for n := range permuted {
+			permuted[n] = block[blake3Permutation[n]]
+		}
+		block = permuted
+	}
+
+	for n := range result {
+		result[n] = state[n] ^ state[n+8]
+	}
+	return result
+}
+
+// blake3ChunkCV returns the chaining value of the chunk. If root is set, the chunk is finalized as root, which is only the case for data up to 1 KB.
+func blake3ChunkCV(chunk []byte, index uint64, root bool) (cv [8]uint32) {
+	cv = blake3IV
+
+	countBlocks := (len(chunk) + blake3BlockSize - 1) / blake3BlockSize
+	if countBlocks == 0 {
+		countBlocks = 1
+	}
+
+	for n := 0; n < countBlocks; n++ {
+		var raw [blake3BlockSize]byte
+		blockLen := 0
+		if n*blake3BlockSize < len(chunk) {
+			blockLen = copy(raw[:], chunk[n*blake3BlockSize:])
+		}
+
+		var block [16]uint32
+		for m := range block {
+			block[m] = binary.LittleEndian.Uint32(raw[m*4:])
+		}
+
+		var flags uint32
+		if n == 0 {
+			flags |= blake3ChunkStart
+		}
+		if n == countBlocks-1 {
+			flags |= blake3ChunkEnd
+			if root {
+				flags |= blake3Root
+			}
+		}
+
+		cv = blake3Compress(cv, block, index, uint32(blockLen), flags)
+	}
+
+	return cv
+}
+
+// blake3ParentCV returns the chaining value of a parent node.
+func blake3ParentCV(left, right [8]uint32, root bool) [8]uint32 {
+	var block [16]uint32
+	copy(block[0:8], left[:])
+	copy(block[8:16], right[:])
+
+	flags := uint32(blake3Parent)
+	if root {
+		flags |= blake3Root
+	}
+
+	return blake3Compress(blake3IV, block, 0, blake3BlockSize, flags)
+}
+
+func blake3CVToBytes(cv [8]uint32) (hash []byte) {
+	hash = make([]byte, 32)
+	for n := range cv {
+		binary.LittleEndian.PutUint32(hash[n*4:], cv[n])
+	}
+	return hash
+}
+
+func blake3BytesToCV(hash []byte) (cv [8]uint32) {
+	for n := range cv {
+		cv[n] = binary.LittleEndian.Uint32(hash[n*4:])
+	}
+	return cv
+}
+
+// blake3ChunkCount returns the count of chunks for the data size. Empty data is a single empty chunk.
+func blake3ChunkCount(size uint64) uint64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + Blake3ChunkSize - 1) / Blake3ChunkSize
+}
+
+// blake3LeftCount returns the count of chunks in the left subtree of a subtree with the given count of chunks (> 1).
+func blake3LeftCount(count uint64) uint64 {
+	return 1 << (bits.Len64(count-1) - 1)
+}
+
+// blake3SubtreeCV calculates the chaining value of the subtree covering count chunks starting at the chunk start.
+func blake3SubtreeCV(reader io.ReaderAt, size, start, count uint64, root bool) (cv [8]uint32, err error) {
+	if count > 1 && count*Blake3ChunkSize > blake3SubtreeBuffer {
+		left := blake3LeftCount(count)
+		leftCV, err := blake3SubtreeCV(reader, size, start, left, false)
+		if err != nil {
+			return cv, err
+		}
+		rightCV, err := blake3SubtreeCV(reader, size, start+left, count-left, false)
+		if err != nil {
+			return cv, err
+		}
+		return blake3ParentCV(leftCV, rightCV, root), nil
+	}
+
+	offset := start * Blake3ChunkSize
+	length := count * Blake3ChunkSize
+	if offset+length > size {
+		length = size - offset
+	}
+
+	data := make([]byte, length)
+	if length > 0 {
+		if _, err = reader.ReadAt(data, int64(offset)); err != nil && err != io.EOF {
+			return cv, err
+		}
+	}
+
+	return blake3SubtreeCVData(data, start, count, root), nil
+}
+
+// blake3SubtreeCVData calculates the chaining value of the subtree from its data.
+func blake3SubtreeCVData(data []byte, start, count uint64, root bool) [8]uint32 {
+	if count == 1 {
+		return blake3ChunkCV(data, start, root)
+	}
+
+	left := blake3LeftCount(count)
+	leftCV := blake3SubtreeCVData(data[:left*Blake3ChunkSize], start, left, false)
+	rightCV := blake3SubtreeCVData(data[left*Blake3ChunkSize:], start+left, count-left, false)
+
+	return blake3ParentCV(leftCV, rightCV, root)
+}
+
+// Blake3TreeHash calculates the blake3 hash of the data via the tree. It equals HashData and serves to validate this implementation.
+func Blake3TreeHash(data []byte) (hash []byte) {
+	return blake3CVToBytes(blake3SubtreeCVData(data, 0, blake3ChunkCount(uint64(len(data))), true))
+}
+
+// Blake3ProofCreate creates the proof that the chunk is part of the data. It returns the chunk data and the chaining values of the sibling subtrees
+// from the root down to the chunk. All data except the chunk is read to calculate the chaining values.
+func Blake3ProofCreate(reader io.ReaderAt, size, chunk uint64) (data []byte, siblings [][]byte, err error) {
+	count := blake3ChunkCount(size)
+	if chunk >= count {
+		return nil, nil, errors.New("invalid chunk")
+	}
+
+	for start := uint64(0); count > 1; {
+		left := blake3LeftCount(count)
+
+		var sibling [8]uint32
+		if chunk < start+left {
+			sibling, err = blake3SubtreeCV(reader, size, start+left, count-left, false)
+			count = left
+		} else {
+			sibling, err = blake3SubtreeCV(reader, size, start, left, false)
+			start, count = start+left, count-left
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		siblings = append(siblings, blake3CVToBytes(sibling))
+	}
+
+	offset := chunk * Blake3ChunkSize
+	length := uint64(Blake3ChunkSize)
+	if offset+length > size {
+		length = size - offset
+	}
+
+	data = make([]byte, length)
+	if length > 0 {
+		if _, err = reader.ReadAt(data, int64(offset)); err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+	}
+
+	return data, siblings, nil
+}
+
+// Blake3ProofVerify verifies that the chunk data is part of the data with the given blake3 hash and size.
+func Blake3ProofVerify(hash []byte, size, chunk uint64, data []byte, siblings [][]byte) bool {
+	count := blake3ChunkCount(size)
+	if len(hash) != HashSize || chunk >= count {
+		return false
+	}
+
+	expectedLength := uint64(Blake3ChunkSize)
+	if chunk*Blake3ChunkSize+expectedLength > size {
+		expectedLength = size - chunk*Blake3ChunkSize
+	}
+	if uint64(len(data)) != expectedLength {
+		return false
+	}
+
+	// Walk down from the root to determine on which side the path continues at each level.
+	var isLeft []bool
+	for start := uint64(0); count > 1; {
+		left := blake3LeftCount(count)
+		if chunk < start+left {
+			isLeft = append(isLeft, true)
+			count = left
+		} else {
+			isLeft = append(isLeft, false)
+			start, count = start+left, count-left
+		}
+	}
+
+	if len(siblings) != len(isLeft) {
+		return false
+	}
+
+	// Calculate the chaining values up to the root.
+	cv := blake3ChunkCV(data, chunk, len(isLeft) == 0)
+
+	for level := len(isLeft) - 1; level >= 0; level-- {
+		if len(siblings[level]) != HashSize {
+			return false
+		}
+		sibling := blake3BytesToCV(siblings[level])
+
+		if isLeft[level] {
+			cv = blake3ParentCV(cv, sibling, level == 0)
+		} else {
+			cv = blake3ParentCV(sibling, cv, level == 0)
+		}
+	}
+
+	return string(blake3CVToBytes(cv)) == string(hash)
+}
@@ -6,13 +6,15 @@ Author:     Peter Kleissner
 
 package protocol
 
+import "strconv"
+
 // Commands between peers
 const (
 	// Peer List Management
 	CommandAnnouncement   = 0 // Announcement
 	CommandResponse       = 1 // Response
 	CommandPing           = 2 // Keep-alive message (no payload).
-	CommandPong           = 3 // Response to ping (no payload).
+	CommandPong           = 3 // Response to ping. Payload only if INFO_STORE records are piggybacked.
 	CommandLocalDiscovery = 4 // Local discovery
 	CommandTraverse       = 5 // Help establish a connection between 2 remote peers
 
@@ -24,4 +26,51 @@ const (
 
 	// Debug
 	CommandChat = 10 // Chat message [debug]
+
+	// Management
+	CommandAdmin = 11 // Remote administration of own nodes.
+
+	// Privacy
+	CommandLookupRelay = 12 // Relay DHT lookups of ephemeral identities.
+	CommandOnion       = 13 // Onion routing via multiple hops.
+
+	// Content Discovery
+	CommandContentSummary = 14 // Bloom filter of stored hashes.
+
+	// NAT Detection
+	CommandNATProbe = 15 // Active NAT behavior tests.
+
+	// Services
+	CommandStream = 16 // Stream to a named service registered by the remote peer.
+
+	// NAT Traversal
+	CommandHolePunch = 17 // Coordinated hole punching between 2 peers behind NATs via a relay.
 )
+
+// commandNames contains the names of the commands as used in logs and diagnostics.
+var commandNames = map[uint8]string{
+	CommandAnnouncement:   "Announcement",
+	CommandResponse:       "Response",
+	CommandPing:           "Ping",
+	CommandPong:           "Pong",
+	CommandLocalDiscovery: "LocalDiscovery",
+	CommandTraverse:       "Traverse",
+	CommandGetBlock:       "GetBlock",
+	CommandTransfer:       "Transfer",
+	CommandChat:           "Chat",
+	CommandAdmin:          "Admin",
+	CommandLookupRelay:    "LookupRelay",
+	CommandOnion:          "Onion",
+	CommandContentSummary: "ContentSummary",
+	CommandNATProbe:       "NATProbe",
+	CommandStream:         "Stream",
+	CommandHolePunch:      "HolePunch",
+}
+
+// CommandName returns the name of the command. Unknown commands are returned as "Unknown" followed by the number.
+func CommandName(command uint8) string {
+	if name, ok := commandNames[command]; ok {
+		return name
+	}
+	return "Unknown" + strconv.Itoa(int(command))
+}
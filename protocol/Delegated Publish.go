@@ -0,0 +1,112 @@
+/*
+File Username:  Delegated Publish.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Encoding of delegated publish requests. A delegate sends records signed by its key to the owner of a blockchain, who appends them if they are
+authorized by a delegation record. Each record is encoded as delegated record, see blockchain.EncodeDelegatedRecord.
+
+Request:
+Offset  Size   Info
+0       2      Count of records
+2       ?      Records, each prefixed by its size (4 bytes)
+
+Response:
+Offset  Size   Info
+0       1      Status, see blockchain.StatusX
+1       8      Height of the blockchain after appending
+9       8      Version of the blockchain after appending
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DelegatedPublishMaxRecords is the max count of records in a delegated publish request.
+const DelegatedPublishMaxRecords = 256
+
+// DelegatedPublishMaxSize is the max size of a single record in a delegated publish request.
+const DelegatedPublishMaxSize = 64 * 1024
+
+// DelegatedPublishRequestWrite writes the request.
+func DelegatedPublishRequestWrite(writer io.Writer, records [][]byte) (err error) {
+	if len(records) == 0 || len(records) > DelegatedPublishMaxRecords {
+		return errors.New("invalid count of records")
+	}
+
+	raw := make([]byte, 2)
+	binary.LittleEndian.PutUint16(raw[0:2], uint16(len(records)))
+
+	for _, record := range records {
+		if len(record) > DelegatedPublishMaxSize {
+			return errors.New("record exceeds max size")
+		}
+
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(record)))
+		raw = append(raw, size[:]...)
+		raw = append(raw, record...)
+	}
+
+	_, err = writer.Write(raw)
+	return err
+}
+
+// DelegatedPublishRequestRead reads the request.
+func DelegatedPublishRequestRead(reader io.Reader) (records [][]byte, err error) {
+	var count [2]byte
+	if _, err = io.ReadFull(reader, count[:]); err != nil {
+		return nil, err
+	}
+
+	countRecords := int(binary.LittleEndian.Uint16(count[:]))
+	if countRecords == 0 || countRecords > DelegatedPublishMaxRecords {
+		return nil, errors.New("invalid count of records")
+	}
+
+	for n := 0; n < countRecords; n++ {
+		var size [4]byte
+		if _, err = io.ReadFull(reader, size[:]); err != nil {
+			return nil, err
+		}
+
+		length := binary.LittleEndian.Uint32(size[:])
+		if length > DelegatedPublishMaxSize {
+			return nil, errors.New("record exceeds max size")
+		}
+
+		record := make([]byte, length)
+		if _, err = io.ReadFull(reader, record); err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// DelegatedPublishResponseWrite writes the response.
+func DelegatedPublishResponseWrite(writer io.Writer, status uint8, height, version uint64) (err error) {
+	var raw [17]byte
+	raw[0] = status
+	binary.LittleEndian.PutUint64(raw[1:9], height)
+	binary.LittleEndian.PutUint64(raw[9:17], version)
+
+	_, err = writer.Write(raw[:])
+	return err
+}
+
+// DelegatedPublishResponseRead reads the response.
+func DelegatedPublishResponseRead(reader io.Reader) (status uint8, height, version uint64, err error) {
+	var raw [17]byte
+	if _, err = io.ReadFull(reader, raw[:]); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return raw[0], binary.LittleEndian.Uint64(raw[1:9]), binary.LittleEndian.Uint64(raw[9:17]), nil
+}
@@ -17,6 +17,10 @@ import (
 	"io"
 )
 
+// TransferHashBenchmark is the reserved hash to request a benchmark transfer instead of a file. The responder streams generated zeros without
+// involving the warehouse. The limit is the requested size; the offset is ignored. The responder may cap the size, rate, and duration of the transfer.
+var TransferHashBenchmark = make([]byte, HashSize)
+
 // FileTransferWriteHeader starts writing the header for a file transfer.
 func FileTransferWriteHeader(writer io.Writer, fileSize, transferSize uint64) (err error) {
 	// Send the header: Total File Size and Transfer Size.
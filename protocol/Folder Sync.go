@@ -0,0 +1,162 @@
+/*
+File Username:  Folder Sync.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Encoding of the folder sync protocol. Each side sends a single sync message via the stream:
+Offset  Size   Info
+0       4      Size of the message excluding this field
+4       1      Type: 0 = Request, 1 = Response, 2 = Folder not available, 3 = Busy
+5       1      Length of the folder name
+6       ?      Folder name
+?       4      Count of files
+
+Each file (only for request and response):
+Offset  Size   Info
+0       32     Hash of the file. Zero if the file was deleted.
+32      32     Hash of the file at the last sync with the receiver. Zero if not known.
+64      8      Size of the file
+72      8      Modification time, Unix time in nanoseconds
+80      2      Length of the path
+82      ?      Path relative to the folder, forward slashes as separator
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Sync message types
+const (
+	SyncRequest      = 0 // Request to sync the folder, including the manifest of the requester.
+	SyncResponse     = 1 // Response including the manifest of the responder.
+	SyncNotAvailable = 2 // The folder is not shared with the requester.
+	SyncBusy         = 3 // The folder is currently syncing. Try again later.
+)
+
+// SyncMessageMaxSize is the max size of a sync message.
+const SyncMessageMaxSize = 64 * 1024 * 1024
+
+// SyncFile is a file in the manifest of a sync message.
+type SyncFile struct {
+	Hash     []byte    // Hash of the file. Nil if the file was deleted.
+	Base     []byte    // Hash of the file at the last sync with the receiver. Nil if not known.
+	Size     uint64    // Size of the file
+	Modified time.Time // Modification time
+	Path     string    // Path relative to the folder
+}
+
+// SyncMessage is a sync message.
+type SyncMessage struct {
+	Type   uint8      // See SyncX constants.
+	Folder string     // Folder name
+	Files  []SyncFile // Manifest
+}
+
+// SyncMessageWrite writes the sync message.
+func SyncMessageWrite(writer io.Writer, message *SyncMessage) (err error) {
+	if len(message.Folder) > 255 {
+		return errors.New("folder name exceeds max length")
+	}
+
+	raw := make([]byte, 4, 4+2+len(message.Folder)+4+len(message.Files)*128)
+	raw = append(raw, message.Type, byte(len(message.Folder)))
+	raw = append(raw, []byte(message.Folder)...)
+
+	var count [4]byte
+	binary.LittleEndian.PutUint32(count[:], uint32(len(message.Files)))
+	raw = append(raw, count[:]...)
+
+	for _, file := range message.Files {
+		if (file.Hash != nil && len(file.Hash) != HashSize) || (file.Base != nil && len(file.Base) != HashSize) || len(file.Path) > 0xFFFF {
+			return errors.New("invalid file")
+		}
+
+		var header [82]byte
+		copy(header[0:32], file.Hash)
+		copy(header[32:64], file.Base)
+		binary.LittleEndian.PutUint64(header[64:72], file.Size)
+		binary.LittleEndian.PutUint64(header[72:80], uint64(file.Modified.UnixNano()))
+		binary.LittleEndian.PutUint16(header[80:82], uint16(len(file.Path)))
+
+		raw = append(raw, header[:]...)
+		raw = append(raw, []byte(file.Path)...)
+	}
+
+	if len(raw)-4 > SyncMessageMaxSize {
+		return errors.New("sync message exceeds max size")
+	}
+	binary.LittleEndian.PutUint32(raw[0:4], uint32(len(raw)-4))
+
+	_, err = writer.Write(raw)
+	return err
+}
+
+// SyncMessageRead reads a sync message.
+func SyncMessageRead(reader io.Reader) (message *SyncMessage, err error) {
+	var size [4]byte
+	if _, err = io.ReadFull(reader, size[:]); err != nil {
+		return nil, err
+	}
+
+	sizeMessage := binary.LittleEndian.Uint32(size[:])
+	if sizeMessage < 6 || sizeMessage > SyncMessageMaxSize {
+		return nil, errors.New("sync message invalid size")
+	}
+
+	raw := make([]byte, sizeMessage)
+	if _, err = io.ReadFull(reader, raw); err != nil {
+		return nil, err
+	}
+
+	message = &SyncMessage{Type: raw[0]}
+
+	folderLength := int(raw[1])
+	if len(raw) < 2+folderLength+4 {
+		return nil, errors.New("sync message invalid size")
+	}
+	message.Folder = string(raw[2 : 2+folderLength])
+	countFiles := binary.LittleEndian.Uint32(raw[2+folderLength : 2+folderLength+4])
+
+	index := 2 + folderLength + 4
+
+	for n := uint32(0); n < countFiles; n++ {
+		if index+82 > len(raw) {
+			return nil, errors.New("sync message invalid size")
+		}
+
+		file := SyncFile{}
+		if hash := raw[index : index+32]; !isZero(hash) {
+			file.Hash = hash
+		}
+		if base := raw[index+32 : index+64]; !isZero(base) {
+			file.Base = base
+		}
+		file.Size = binary.LittleEndian.Uint64(raw[index+64 : index+72])
+		file.Modified = time.Unix(0, int64(binary.LittleEndian.Uint64(raw[index+72:index+80])))
+		pathLength := int(binary.LittleEndian.Uint16(raw[index+80 : index+82]))
+
+		if index+82+pathLength > len(raw) {
+			return nil, errors.New("sync message invalid size")
+		}
+		file.Path = string(raw[index+82 : index+82+pathLength])
+		index += 82 + pathLength
+
+		message.Files = append(message.Files, file)
+	}
+
+	return message, nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+/*
+File Username:  Group Frame.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Group frames are exchanged between members of a group channel via a stream. Each frame is prefixed by its size (4 bytes) on the stream.
+Offset  Size   Info
+0       1      Frame type: 0 = Message, 1 = Sender key
+1       16     Group ID
+17      33     Owner public key (compressed)
+50      33     Sender public key (compressed)
+83      16     Frame ID, random. Used for deduplication when relaying.
+99      1      Hop count, incremented by relays. Not covered by the signature.
+100     4      Key ID of the sender key
+104     8      Date created, Unix time in milliseconds
+
+Message frame:
+112     24     Nonce
+136     ?      Message encrypted via XChaCha20-Poly1305 using the sender key
+
+Sender key frame:
+112     33     Recipient public key (compressed)
+145     ?      Sender key encrypted to the recipient (ECIES)
+
+End     65     Signature of the sender over all previous bytes with hop count set to 0
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/google/uuid"
+)
+
+// Group frame types
+const (
+	GroupFrameMessage   = 0 // Encrypted message
+	GroupFrameSenderKey = 1 // Sender key for a specific recipient
+)
+
+const groupFrameHeaderSize = 112
+
+// GroupFrameMaxSize is the max size of a single group frame.
+const GroupFrameMaxSize = 64 * 1024
+
+// GroupFrame is a frame exchanged between members of a group channel.
+type GroupFrame struct {
+	Type      uint8            // See GroupFrameX.
+	GroupID   uuid.UUID        // Group ID
+	Owner     *btcec.PublicKey // Owner of the group
+	Sender    *btcec.PublicKey // Original sender of the frame
+	ID        uuid.UUID        // Frame ID
+	Hops      uint8            // Count of relays that forwarded the frame
+	KeyID     uint32           // Key ID of the sender key
+	Date      time.Time        // Date created
+	Nonce     []byte           // Message: Nonce
+	Recipient *btcec.PublicKey // Sender key: Recipient
+	Data      []byte           // Message: Encrypted message. Sender key: Encrypted sender key.
+	Raw       []byte           // Raw frame as received
+}
+
+// DecodeGroupFrame decodes a group frame and verifies the signature of the sender.
+func DecodeGroupFrame(raw []byte) (frame *GroupFrame, err error) {
+	if len(raw) < groupFrameHeaderSize+signatureSize || len(raw) > GroupFrameMaxSize {
+		return nil, errors.New("group frame invalid size")
+	}
+
+	frame = &GroupFrame{Type: raw[0], Hops: raw[99], Raw: raw}
+	copy(frame.GroupID[:], raw[1:17])
+	copy(frame.ID[:], raw[83:99])
+	frame.KeyID = binary.LittleEndian.Uint32(raw[100:104])
+	frame.Date = time.UnixMilli(int64(binary.LittleEndian.Uint64(raw[104:112])))
+
+	if frame.Owner, err = btcec.ParsePubKey(raw[17:50], btcec.S256()); err != nil {
+		return nil, err
+	} else if frame.Sender, err = btcec.ParsePubKey(raw[50:83], btcec.S256()); err != nil {
+		return nil, err
+	}
+
+	data := raw[groupFrameHeaderSize : len(raw)-signatureSize]
+
+	switch frame.Type {
+	case GroupFrameMessage:
+		if len(data) < 24 {
+			return nil, errors.New("group frame invalid size")
+		}
+		frame.Nonce = data[:24]
+		frame.Data = data[24:]
+
+	case GroupFrameSenderKey:
+		if len(data) < 33 {
+			return nil, errors.New("group frame invalid size")
+		}
+		if frame.Recipient, err = btcec.ParsePubKey(data[:33], btcec.S256()); err != nil {
+			return nil, err
+		}
+		frame.Data = data[33:]
+
+	default:
+		return nil, errors.New("unknown group frame type")
+	}
+
+	// verify the signature
+	signed := make([]byte, len(raw)-signatureSize)
+	copy(signed, raw)
+	signed[99] = 0
+
+	signer, _, err := btcec.RecoverCompact(btcec.S256(), raw[len(raw)-signatureSize:], HashData(signed))
+	if err != nil {
+		return nil, err
+	} else if !signer.IsEqual(frame.Sender) {
+		return nil, errors.New("group frame invalid signature")
+	}
+
+	return frame, nil
+}
+
+// EncodeGroupFrame encodes and signs a group frame. The sender is derived from the private key. Hops is set to 0.
+func EncodeGroupFrame(senderPrivateKey *btcec.PrivateKey, frame *GroupFrame) (raw []byte, err error) {
+	raw = make([]byte, groupFrameHeaderSize)
+	raw[0] = frame.Type
+	copy(raw[1:17], frame.GroupID[:])
+	copy(raw[17:50], frame.Owner.SerializeCompressed())
+	copy(raw[50:83], senderPrivateKey.PubKey().SerializeCompressed())
+	copy(raw[83:99], frame.ID[:])
+	binary.LittleEndian.PutUint32(raw[100:104], frame.KeyID)
+	binary.LittleEndian.PutUint64(raw[104:112], uint64(frame.Date.UnixMilli()))
+
+	switch frame.Type {
+	case GroupFrameMessage:
+		if len(frame.Nonce) != 24 {
+			return nil, errors.New("invalid nonce size")
+		}
+		raw = append(raw, frame.Nonce...)
+
+	case GroupFrameSenderKey:
+		raw = append(raw, frame.Recipient.SerializeCompressed()...)
+
+	default:
+		return nil, errors.New("unknown group frame type")
+	}
+
+	raw = append(raw, frame.Data...)
+
+	if len(raw)+signatureSize > GroupFrameMaxSize {
+		return nil, errors.New("group frame exceeds max size")
+	}
+
+	signature, err := btcec.SignCompact(btcec.S256(), senderPrivateKey, HashData(raw), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(raw, signature...), nil
+}
+
+// SetGroupFrameHops sets the hop count in the raw frame. The signature remains valid.
+func SetGroupFrameHops(raw []byte, hops uint8) {
+	if len(raw) > 99 {
+		raw[99] = hops
+	}
+}
@@ -0,0 +1,128 @@
+/*
+File Username:  Info Store Proof.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Encoding of INFO_STORE proofs. A peer that received an INFO_STORE record challenges the announcing peer to prove that it stores the data,
+by requesting a random blake3 chunk. The announcing peer answers with the chunk and the sibling chaining values. See Blake3ProofCreate.
+
+Challenge:
+Offset  Size   Info
+0       32     Hash of the data
+32      8      Size of the data as announced
+40      8      Chunk index
+
+Response:
+Offset  Size   Info
+0       1      Status: 0 = OK, 1 = Not found, 2 = Invalid chunk, 3 = Busy
+1       2      Length of the chunk data
+3       1      Count of siblings
+4       ?      Chunk data
+?       32*n   Sibling chaining values from the root down to the chunk
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// INFO_STORE proof response status
+const (
+	InfoStoreProofOK           = 0 // The proof is included.
+	InfoStoreProofNotFound     = 1 // The data is not stored.
+	InfoStoreProofInvalidChunk = 2 // The chunk is outside the data or the size does not match.
+	InfoStoreProofBusy         = 3 // The peer is busy answering other challenges.
+)
+
+// InfoStoreChallenge is a challenge to prove storing the data of an INFO_STORE record.
+type InfoStoreChallenge struct {
+	Hash  []byte // Hash of the data
+	Size  uint64 // Size of the data as announced
+	Chunk uint64 // Index of the blake3 chunk
+}
+
+// InfoStoreProofResponse is the response to a challenge.
+type InfoStoreProofResponse struct {
+	Status   uint8    // See InfoStoreProofX constants.
+	Data     []byte   // Chunk data
+	Siblings [][]byte // Sibling chaining values from the root down to the chunk
+}
+
+// InfoStoreChallengeWrite writes the challenge.
+func InfoStoreChallengeWrite(writer io.Writer, challenge *InfoStoreChallenge) (err error) {
+	if len(challenge.Hash) != HashSize {
+		return errors.New("invalid challenge")
+	}
+
+	var raw [48]byte
+	copy(raw[0:32], challenge.Hash)
+	binary.LittleEndian.PutUint64(raw[32:40], challenge.Size)
+	binary.LittleEndian.PutUint64(raw[40:48], challenge.Chunk)
+
+	_, err = writer.Write(raw[:])
+	return err
+}
+
+// InfoStoreChallengeRead reads the challenge.
+func InfoStoreChallengeRead(reader io.Reader) (challenge *InfoStoreChallenge, err error) {
+	var raw [48]byte
+	if _, err = io.ReadFull(reader, raw[:]); err != nil {
+		return nil, err
+	}
+
+	return &InfoStoreChallenge{Hash: raw[0:32], Size: binary.LittleEndian.Uint64(raw[32:40]), Chunk: binary.LittleEndian.Uint64(raw[40:48])}, nil
+}
+
+// InfoStoreProofWrite writes the response.
+func InfoStoreProofWrite(writer io.Writer, response *InfoStoreProofResponse) (err error) {
+	if len(response.Data) > Blake3ChunkSize || len(response.Siblings) > Blake3ProofMaxSiblings {
+		return errors.New("invalid proof")
+	}
+
+	raw := make([]byte, 4, 4+len(response.Data)+len(response.Siblings)*HashSize)
+	raw[0] = response.Status
+	binary.LittleEndian.PutUint16(raw[1:3], uint16(len(response.Data)))
+	raw[3] = uint8(len(response.Siblings))
+	raw = append(raw, response.Data...)
+
+	for _, sibling := range response.Siblings {
+		if len(sibling) != HashSize {
+			return errors.New("invalid sibling")
+		}
+		raw = append(raw, sibling...)
+	}
+
+	_, err = writer.Write(raw)
+	return err
+}
+
+// InfoStoreProofRead reads the response.
+func InfoStoreProofRead(reader io.Reader) (response *InfoStoreProofResponse, err error) {
+	var header [4]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+
+	response = &InfoStoreProofResponse{Status: header[0]}
+	dataLength := int(binary.LittleEndian.Uint16(header[1:3]))
+	countSiblings := int(header[3])
+
+	if dataLength > Blake3ChunkSize || countSiblings > Blake3ProofMaxSiblings {
+		return nil, errors.New("invalid proof")
+	}
+
+	raw := make([]byte, dataLength+countSiblings*HashSize)
+	if _, err = io.ReadFull(reader, raw); err != nil {
+		return nil, err
+	}
+
+	response.Data = raw[:dataLength]
+	for n := 0; n < countSiblings; n++ {
+		response.Siblings = append(response.Siblings, raw[dataLength+n*HashSize:dataLength+(n+1)*HashSize])
+	}
+
+	return response, nil
+}
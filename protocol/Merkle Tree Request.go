@@ -0,0 +1,90 @@
+/*
+File Username:  Merkle Tree Request.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Encoding of merkle tree requests. A downloader requests the merkle tree of a file to verify each fragment independently, which allows
+downloading different fragments from multiple peers. The tree is encoded as exported by merkle.MerkleTree.Export.
+
+Request:
+Offset  Size   Info
+0       32     Hash of the file
+
+Response:
+Offset  Size   Info
+0       1      Status: 0 = OK, 1 = Not found
+1       4      Length of the merkle tree
+5       ?      Merkle tree
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Merkle tree response status
+const (
+	MerkleTreeOK       = 0 // The merkle tree is included.
+	MerkleTreeNotFound = 1 // The file or its merkle tree is not stored.
+)
+
+// MerkleTreeMaxSize is the max size of a merkle tree in a response.
+const MerkleTreeMaxSize = 16 * 1024 * 1024
+
+// MerkleTreeRequestWrite writes the request.
+func MerkleTreeRequestWrite(writer io.Writer, hash []byte) (err error) {
+	if len(hash) != HashSize {
+		return errors.New("invalid hash")
+	}
+
+	_, err = writer.Write(hash)
+	return err
+}
+
+// MerkleTreeRequestRead reads the request.
+func MerkleTreeRequestRead(reader io.Reader) (hash []byte, err error) {
+	hash = make([]byte, HashSize)
+	if _, err = io.ReadFull(reader, hash); err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// MerkleTreeResponseWrite writes the response. The tree is empty if the status is not MerkleTreeOK.
+func MerkleTreeResponseWrite(writer io.Writer, status uint8, tree []byte) (err error) {
+	if len(tree) > MerkleTreeMaxSize {
+		return errors.New("merkle tree exceeds max size")
+	}
+
+	raw := make([]byte, 5, 5+len(tree))
+	raw[0] = status
+	binary.LittleEndian.PutUint32(raw[1:5], uint32(len(tree)))
+	raw = append(raw, tree...)
+
+	_, err = writer.Write(raw)
+	return err
+}
+
+// MerkleTreeResponseRead reads the response.
+func MerkleTreeResponseRead(reader io.Reader) (status uint8, tree []byte, err error) {
+	var header [5]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[1:5])
+	if length > MerkleTreeMaxSize {
+		return 0, nil, errors.New("merkle tree exceeds max size")
+	}
+
+	tree = make([]byte, length)
+	if _, err = io.ReadFull(reader, tree); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], tree, nil
+}
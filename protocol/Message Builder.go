@@ -0,0 +1,392 @@
+/*
+File Username:  Message Builder.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Builders for Announcement and Response messages. Records are added one by one and Finalize encodes them into as many packets as required.
+Both messages share the same payload header; lists that do not fit into a packet continue in the next one.
+
+Offset  Size    Info
+0       1       Protocol version (low 4 bits) and extended feature support (high 4 bits)
+1       1       Feature support
+2       1       Action bit array
+3       8       Blockchain height
+11      8       Blockchain version
+19      2       Internal port, set by the sender via SetSelfReportedPorts
+21      2       External port, set by the sender via SetSelfReportedPorts
+23      1       Length of the User Agent
+24      ?       User Agent
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"unicode/utf8"
+)
+
+// Sizes of records
+const (
+	keyRecordSize       = HashSize     // FIND_PEER and FIND_VALUE key
+	infoStoreRecordSize = HashSize + 9 // INFO_STORE record: hash, size, type
+	hashRecordSize      = HashSize + 2 // Hash followed by a 2 byte count or size field
+)
+
+// messageHeader contains the fields of the payload header shared by Announcement and Response messages.
+type messageHeader struct {
+	features          byte
+	featuresExt       byte // Extended features, sent in the high 4 bits of the protocol byte
+	blockchainHeight  uint64
+	blockchainVersion uint64
+	userAgent         []byte // User Agent to send. Empty if not sent.
+	maxPayload        int    // Max payload size of a single packet
+}
+
+func newMessageHeader(features byte, blockchainHeight, blockchainVersion uint64) messageHeader {
+	return messageHeader{features: features, blockchainHeight: blockchainHeight, blockchainVersion: blockchainVersion, maxPayload: udpMaxPacketSize - PacketLengthMin}
+}
+
+// setUserAgent sets the User Agent. It is truncated to 255 bytes at a valid UTF-8 boundary.
+func (header *messageHeader) setUserAgent(userAgent string) {
+	userAgentB := []byte(userAgent)
+	if len(userAgentB) > 255 {
+		userAgentB = userAgentB[:255]
+		for len(userAgentB) > 0 && !utf8.Valid(userAgentB) {
+			userAgentB = userAgentB[:len(userAgentB)-1]
+		}
+	}
+
+	header.userAgent = userAgentB
+}
+
+// packetWriter writes packets sharing the same header and keeps track of the remaining space.
+type packetWriter struct {
+	header  *messageHeader
+	raw     []byte   // Current packet
+	packets [][]byte // Finished packets
+}
+
+// start starts a new packet with the header. extraHeader bytes are reserved directly after the header.
+func (writer *packetWriter) start(extraHeader int) {
+	writer.raw = make([]byte, announcementPayloadHeaderSize+len(writer.header.userAgent)+extraHeader, 1024)
+
+	writer.raw[0] = byte(ProtocolVersion) | writer.header.featuresExt<<4
+	writer.raw[1] = writer.header.features
+	binary.LittleEndian.PutUint64(writer.raw[3:3+8], writer.header.blockchainHeight)
+	binary.LittleEndian.PutUint64(writer.raw[11:11+8], writer.header.blockchainVersion)
+	writer.raw[23] = byte(len(writer.header.userAgent))
+	copy(writer.raw[announcementPayloadHeaderSize:], writer.header.userAgent)
+}
+
+// finish appends the current packet to the finished ones.
+func (writer *packetWriter) finish() {
+	writer.packets = append(writer.packets, writer.raw)
+	writer.raw = nil
+}
+
+// setAction sets the action bit in the current packet.
+func (writer *packetWriter) setAction(action int) {
+	writer.raw[2] |= 1 << action
+}
+
+// isEmpty checks if the current packet contains nothing but the header and extraHeader bytes.
+func (writer *packetWriter) isEmpty(extraHeader int) bool {
+	return len(writer.raw) == announcementPayloadHeaderSize+len(writer.header.userAgent)+extraHeader
+}
+
+// available returns the count of bytes that fit into the current packet.
+func (writer *packetWriter) available() int {
+	return writer.header.maxPayload - len(writer.raw)
+}
+
+// grow appends size zeroed bytes to the current packet and returns them.
+func (writer *packetWriter) grow(size int) (data []byte) {
+	offset := len(writer.raw)
+	writer.raw = append(writer.raw, make([]byte, size)...)
+	return writer.raw[offset : offset+size]
+}
+
+// writeList writes a list prefixed by a 2 byte count and sets the action bit. It writes as many of the count records as fit and returns the count written.
+func (writer *packetWriter) writeList(action, count, recordSize int, encode func(raw []byte, n int)) (written int) {
+	if count == 0 || writer.available() < 2+recordSize {
+		return 0
+	}
+
+	written = (writer.available() - 2) / recordSize
+	if written > count {
+		written = count
+	}
+	if written > 0xFFFF {
+		written = 0xFFFF
+	}
+
+	writer.setAction(action)
+	binary.LittleEndian.PutUint16(writer.grow(2), uint16(written))
+
+	for n := 0; n < written; n++ {
+		encode(writer.grow(recordSize), n)
+	}
+
+	return written
+}
+
+// ---- Announcement ----
+
+// AnnouncementBuilder builds announcement messages.
+type AnnouncementBuilder struct {
+	header    messageHeader
+	findSelf  bool
+	findPeer  []KeyHash
+	findValue []KeyHash
+	files     []InfoStore
+}
+
+// NewAnnouncementBuilder creates a new announcement builder. The User Agent is only sent if set via SetUserAgent.
+func NewAnnouncementBuilder(features byte, blockchainHeight, blockchainVersion uint64) *AnnouncementBuilder {
+	return &AnnouncementBuilder{header: newMessageHeader(features, blockchainHeight, blockchainVersion)}
+}
+
+// SetFeaturesExt sets the extended feature bits, see FeatureExtX. Only the low 4 bits are used.
+func (builder *AnnouncementBuilder) SetFeaturesExt(features byte) {
+	builder.header.featuresExt = features & 0x0F
+}
+
+// SetUserAgent sets the User Agent to send. Per protocol the initial announcement must provide it. It is truncated to 255 bytes.
+func (builder *AnnouncementBuilder) SetUserAgent(userAgent string) {
+	builder.header.setUserAgent(userAgent)
+}
+
+// FindSelf requests the closest neighbors to self.
+func (builder *AnnouncementBuilder) FindSelf() {
+	builder.findSelf = true
+}
+
+// AddFindPeer requests the closest neighbors to the node ID (blake3 hash of peer ID compressed form).
+func (builder *AnnouncementBuilder) AddFindPeer(nodeID []byte) {
+	builder.findPeer = append(builder.findPeer, KeyHash{Hash: nodeID})
+}
+
+// AddFindValue requests the data or the closest peers to the hash.
+func (builder *AnnouncementBuilder) AddFindValue(hash []byte) {
+	builder.findValue = append(builder.findValue, KeyHash{Hash: hash})
+}
+
+// AddInfoStore informs about a file stored.
+func (builder *AnnouncementBuilder) AddInfoStore(file InfoStore) {
+	builder.files = append(builder.files, file)
+}
+
+// Finalize encodes the announcement. It returns multiple packets if the records do not fit into one. The builder is not modified.
+// FIND_SELF is set in every packet, since each one is sent as separate message and answered individually.
+func (builder *AnnouncementBuilder) Finalize() (packetsRaw [][]byte) {
+	writer := packetWriter{header: &builder.header}
+	findPeer, findValue, files := builder.findPeer, builder.findValue, builder.files
+
+	for {
+		writer.start(0)
+
+		if builder.findSelf {
+			writer.setAction(ActionFindSelf)
+		}
+
+		n := writer.writeList(ActionFindPeer, len(findPeer), keyRecordSize, func(raw []byte, n int) {
+			copy(raw, findPeer[n].Hash)
+		})
+		findPeer = findPeer[n:]
+
+		if len(findPeer) == 0 {
+			n = writer.writeList(ActionFindValue, len(findValue), keyRecordSize, func(raw []byte, n int) {
+				copy(raw, findValue[n].Hash)
+			})
+			findValue = findValue[n:]
+		}
+
+		if len(findPeer) == 0 && len(findValue) == 0 {
+			n = writer.writeList(ActionInfoStore, len(files), infoStoreRecordSize, func(raw []byte, n int) {
+				encodeInfoStore(raw, &files[n])
+			})
+			files = files[n:]
+		}
+
+		writer.finish()
+
+		if len(findPeer) == 0 && len(findValue) == 0 && len(files) == 0 {
+			return writer.packets
+		}
+	}
+}
+
+// encodeInfoStore encodes a single INFO_STORE record
+func encodeInfoStore(raw []byte, file *InfoStore) {
+	copy(raw[0:HashSize], file.ID.Hash)
+	binary.LittleEndian.PutUint64(raw[HashSize:HashSize+8], file.Size)
+	raw[HashSize+8] = file.Type
+}
+
+// ---- Response ----
+
+// ResponseBuilder builds response messages.
+type ResponseBuilder struct {
+	header         messageHeader
+	hash2Peers     []Hash2Peer
+	filesEmbed     []EmbeddedFileData
+	hashesNotFound [][]byte
+}
+
+// Size of the count fields in the Response header: count of peer responses, embedded files, and hashes not found.
+const responseCountsSize = 6
+
+// NewResponseBuilder creates a new response builder. The User Agent is only sent if set via SetUserAgent.
+func NewResponseBuilder(features byte, blockchainHeight, blockchainVersion uint64) *ResponseBuilder {
+	return &ResponseBuilder{header: newMessageHeader(features, blockchainHeight, blockchainVersion)}
+}
+
+// SetFeaturesExt sets the extended feature bits, see FeatureExtX. Only the low 4 bits are used.
+func (builder *ResponseBuilder) SetFeaturesExt(features byte) {
+	builder.header.featuresExt = features & 0x0F
+}
+
+// SetUserAgent sets the User Agent to send. Per protocol the initial response must provide it. It is truncated to 255 bytes.
+func (builder *ResponseBuilder) SetUserAgent(userAgent string) {
+	builder.header.setUserAgent(userAgent)
+}
+
+// AddPeerRecord adds a peer as result for the hash. Storing indicates whether the peer stores the data, otherwise it is close to the hash.
+// Records for the same hash are grouped.
+func (builder *ResponseBuilder) AddPeerRecord(hash []byte, peer PeerRecord, storing bool) {
+	hash2Peer := builder.hash2Peer(hash)
+
+	if storing {
+		hash2Peer.Storing = append(hash2Peer.Storing, peer)
+	} else {
+		hash2Peer.Closest = append(hash2Peer.Closest, peer)
+	}
+
+}
+
+// AddHash2Peer adds the peers for the hash. It is also valid to add a hash without any peers.
+func (builder *ResponseBuilder) AddHash2Peer(hash2Peer Hash2Peer) {
+	existing := builder.hash2Peer(hash2Peer.ID.Hash)
+	existing.Storing = append(existing.Storing, hash2Peer.Storing...)
+	existing.Closest = append(existing.Closest, hash2Peer.Closest...)
+
+}
+
+// hash2Peer returns the peer list for the hash. It is created if necessary.
+func (builder *ResponseBuilder) hash2Peer(hash []byte) *Hash2Peer {
+	for n := range builder.hash2Peers {
+		if bytes.Equal(builder.hash2Peers[n].ID.Hash, hash) {
+			return &builder.hash2Peers[n]
+		}
+	}
+
+	builder.hash2Peers = append(builder.hash2Peers, Hash2Peer{ID: KeyHash{Hash: hash}})
+	return &builder.hash2Peers[len(builder.hash2Peers)-1]
+}
+
+// AddEmbeddedFile embeds the file data. The data must not exceed EmbeddedFileSizeMax.
+func (builder *ResponseBuilder) AddEmbeddedFile(hash, data []byte) (err error) {
+	if len(data) > EmbeddedFileSizeMax {
+		return errors.New("embedded file too big")
+	}
+
+	builder.filesEmbed = append(builder.filesEmbed, EmbeddedFileData{ID: KeyHash{Hash: hash}, Data: data})
+	return nil
+}
+
+// AddHashNotFound reports the hash as not found.
+func (builder *ResponseBuilder) AddHashNotFound(hash []byte) {
+	builder.hashesNotFound = append(builder.hashesNotFound, hash)
+}
+
+// Finalize encodes the response. It returns multiple packets if the records do not fit into one; only the last one has the SEQUENCE_LAST action set.
+// Peer records of a single hash may be split across packets, in which case only the last record list is flagged as last. The builder is not modified.
+func (builder *ResponseBuilder) Finalize() (packetsRaw [][]byte, err error) {
+	writer := packetWriter{header: &builder.header}
+	countIndex := announcementPayloadHeaderSize + len(builder.header.userAgent)
+
+	hash2Peers, filesEmbed, hashesNotFound := builder.hash2Peers, builder.filesEmbed, builder.hashesNotFound
+	var storingDone, closestDone int // Records of hash2Peers[0] already written
+
+	for {
+		writer.start(responseCountsSize)
+		var countPeers, countFiles, countNotFound int
+
+		// Peer response data for FIND_SELF, FIND_PEER and FIND_VALUE requests. Storing peers are encoded first, then closest peers.
+		for len(hash2Peers) > 0 {
+			storing, closest := hash2Peers[0].Storing[storingDone:], hash2Peers[0].Closest[closestDone:]
+
+			minSize := hashRecordSize
+			if len(storing)+len(closest) > 0 {
+				minSize += peerRecordSize
+			}
+			if writer.available() < minSize {
+				break
+			}
+
+			raw := writer.grow(hashRecordSize)
+			copy(raw[0:HashSize], hash2Peers[0].ID.Hash)
+			count := 0
+
+			for ; count < len(storing) && writer.available() >= peerRecordSize; count++ {
+				encodePeerRecord(writer.grow(peerRecordSize), &storing[count], 1)
+			}
+			storingDone += count
+
+			for m := 0; len(storing) == count && m < len(closest) && writer.available() >= peerRecordSize; m++ {
+				encodePeerRecord(writer.grow(peerRecordSize), &closest[m], 0)
+				closestDone++
+				count++
+			}
+
+			countPeers++
+			isLast := storingDone == len(hash2Peers[0].Storing) && closestDone == len(hash2Peers[0].Closest)
+
+			if !isLast {
+				binary.LittleEndian.PutUint16(raw[HashSize:HashSize+2], uint16(count))
+				break
+			}
+
+			binary.LittleEndian.PutUint16(raw[HashSize:HashSize+2], uint16(count)|0x8000) // signal the last result for the key with bit 15
+			hash2Peers = hash2Peers[1:]
+			storingDone, closestDone = 0, 0
+		}
+
+		// FIND_VALUE response embedded data
+		if len(hash2Peers) == 0 {
+			for ; len(filesEmbed) > 0 && writer.available() >= hashRecordSize+len(filesEmbed[0].Data); filesEmbed = filesEmbed[1:] {
+				raw := writer.grow(hashRecordSize + len(filesEmbed[0].Data))
+				copy(raw[0:HashSize], filesEmbed[0].ID.Hash)
+				binary.LittleEndian.PutUint16(raw[HashSize:HashSize+2], uint16(len(filesEmbed[0].Data)))
+				copy(raw[hashRecordSize:], filesEmbed[0].Data)
+				countFiles++
+			}
+
+			if len(filesEmbed) > 0 && countFiles == 0 && writer.isEmpty(responseCountsSize) {
+				return nil, errors.New("embedded file too big")
+			}
+		}
+
+		// Hashes not found
+		if len(hash2Peers) == 0 && len(filesEmbed) == 0 {
+			for ; len(hashesNotFound) > 0 && writer.available() >= HashSize; hashesNotFound = hashesNotFound[1:] {
+				copy(writer.grow(HashSize), hashesNotFound[0])
+				countNotFound++
+			}
+		}
+
+		binary.LittleEndian.PutUint16(writer.raw[countIndex+0:countIndex+2], uint16(countPeers))
+		binary.LittleEndian.PutUint16(writer.raw[countIndex+2:countIndex+4], uint16(countFiles))
+		binary.LittleEndian.PutUint16(writer.raw[countIndex+4:countIndex+6], uint16(countNotFound))
+
+		if len(hash2Peers) == 0 && len(filesEmbed) == 0 && len(hashesNotFound) == 0 {
+			writer.setAction(ActionSequenceLast) // Indicate that no more responses will be sent in this sequence
+			writer.finish()
+			return writer.packets, nil
+		}
+
+		writer.finish()
+	}
+}
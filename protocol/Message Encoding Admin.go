@@ -0,0 +1,95 @@
+/*
+File Username:  Message Encoding Admin.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Admin message encoding:
+Offset  Size    Info
+0       1       Control
+1       1       Action
+2       1       Status. Only used in responses.
+3       8       Timestamp (Unix time in seconds) when the message was created. Used to reject replayed messages.
+11      8       Nonce. Random value to reject replayed messages within the accepted time drift.
+19      ?       Data. Depends on the action.
+
+The message is authenticated via the regular packet signature. The receiver only accepts requests from configured admin public keys.
+*/
+
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// MessageAdmin is the decoded admin message.
+type MessageAdmin struct {
+	*MessageRaw           // Underlying raw message.
+	Control     uint8     // Control. See AdminControlX.
+	Action      uint8     // Action. See AdminActionX.
+	Status      uint8     // Status of the response. See AdminStatusX.
+	Timestamp   time.Time // Time the message was created.
+	Nonce       uint64    // Random value unique to the message.
+	Data        []byte    // Data specific to the action.
+}
+
+const (
+	AdminControlRequest  = 0 // Request to perform an action.
+	AdminControlResponse = 1 // Response to a request.
+)
+
+const (
+	AdminActionStatus     = 0 // Query status. Response data is JSON encoded.
+	AdminActionReannounce = 1 // Re-announce self to all peers in the peer list.
+	AdminActionGC         = 2 // Run garbage collection and return memory to the OS.
+	AdminActionLogTarget  = 3 // Change the log target. Request data is 1 byte: the new target.
+)
+
+const (
+	AdminStatusOK           = 0 // Success.
+	AdminStatusUnauthorized = 1 // Sender is not authorized.
+	AdminStatusInvalid      = 2 // Invalid action or data.
+)
+
+const adminPayloadHeaderSize = 19
+
+// DecodeAdmin decodes an admin message.
+func DecodeAdmin(msg *MessageRaw) (result *MessageAdmin, err error) {
+	if len(msg.Payload) < adminPayloadHeaderSize {
+		return nil, errors.New("admin: invalid minimum length")
+	}
+
+	result = &MessageAdmin{
+		MessageRaw: msg,
+		Control:    msg.Payload[0],
+		Action:     msg.Payload[1],
+		Status:     msg.Payload[2],
+		Timestamp:  time.Unix(int64(binary.LittleEndian.Uint64(msg.Payload[3:3+8])), 0),
+		Nonce:      binary.LittleEndian.Uint64(msg.Payload[11 : 11+8]),
+		Data:       msg.Payload[adminPayloadHeaderSize:],
+	}
+
+	return result, nil
+}
+
+// EncodeAdmin encodes an admin message.
+func EncodeAdmin(control, action, status uint8, data []byte) (packetRaw []byte, err error) {
+	if isPacketSizeExceed(adminPayloadHeaderSize, len(data)) {
+		return nil, errors.New("admin encode: data too big")
+	}
+
+	raw := make([]byte, adminPayloadHeaderSize+len(data))
+
+	raw[0] = control
+	raw[1] = action
+	raw[2] = status
+	binary.LittleEndian.PutUint64(raw[3:3+8], uint64(time.Now().UTC().Unix()))
+	if _, err = rand.Read(raw[11 : 11+8]); err != nil {
+		return nil, err
+	}
+	copy(raw[adminPayloadHeaderSize:], data)
+
+	return raw, nil
+}
@@ -17,6 +17,7 @@ type MessageAnnouncement struct {
 	*MessageRaw                   // Underlying raw message
 	Protocol          uint8       // Protocol version supported (low 4 bits).
 	Features          uint8       // Feature support
+	FeaturesExt       uint8       // Extended feature support (high 4 bits of the protocol byte). See FeatureExtX.
 	Actions           uint8       // Action bit array. See ActionX
 	BlockchainHeight  uint64      // Blockchain height
 	BlockchainVersion uint64      // Blockchain version
@@ -37,14 +38,32 @@ type KeyHash struct {
 type InfoStore struct {
 	ID   KeyHash // Hash of the file
 	Size uint64  // Size of the file
-	Type uint8   // Type of the file: 0 = File, 1 = Header file containing list of parts
+	Type uint8   // Type of the record, see InfoStoreTypeX.
 }
 
+// Types of INFO_STORE records
+const (
+	InfoStoreTypeFile    = 0 // File
+	InfoStoreTypeHeader  = 1 // Header file containing list of parts
+	InfoStoreTypeKeyword = 2 // Keyword posting: The sender shares files matching the keyword. The hash is the keyword key, the size is the count of matching files.
+)
+
 // Features are sent as bit array in the Announcement message.
 const (
-	FeatureIPv4Listen = 0 // Sender listens on IPv4
-	FeatureIPv6Listen = 1 // Sender listens on IPv6
-	FeatureFirewall   = 2 // Sender indicates a potential firewall. This informs uncontacted peers that a Traverse message might be required to establish a connection.
+	FeatureIPv4Listen   = 0 // Sender listens on IPv4
+	FeatureIPv6Listen   = 1 // Sender listens on IPv6
+	FeatureFirewall     = 2 // Sender indicates a potential firewall. This informs uncontacted peers that a Traverse message might be required to establish a connection.
+	FeatureLookupRelay  = 3 // Sender relays DHT lookups of ephemeral identities for other peers.
+	FeatureOnionRelay   = 4 // Sender acts as hop for onion routed messages.
+	FeatureNATFullCone  = 5 // Sender is behind a full cone NAT. Unsolicited incoming packets are received; no Traverse message is required.
+	FeatureNATSymmetric = 6 // Sender is behind a symmetric NAT. Direct packets are dropped unless the sender initiates the contact.
+	FeatureSupernode    = 7 // Sender is a supernode with extra caching and relay capacity. It mirrors blocks and accepts more INFO_STORE records.
+)
+
+// Extended features are sent as bit array in the high 4 bits of the protocol byte in the Announcement message, since all bits of the feature byte are used.
+const (
+	FeatureExtTrafficRelay = 0 // Sender relays the traffic, including UDT transfers, of peers that cannot connect directly.
+	FeatureExtLiteReplay   = 1 // Sender supports the lite packet header with replay protection.
 )
 
 // Actions between peers, sent via Announcement message. They correspond to the bit array index.
@@ -68,8 +87,9 @@ func DecodeAnnouncement(msg *MessageRaw) (result *MessageAnnouncement, err error
 		return nil, errors.New("announcement: invalid minimum length")
 	}
 
-	result.Protocol = msg.Payload[0] & 0x0F // Protocol version support is stored in the first 4 bits
-	result.Features = msg.Payload[1]        // Feature support
+	result.Protocol = msg.Payload[0] & 0x0F  // Protocol version support is stored in the first 4 bits
+	result.FeaturesExt = msg.Payload[0] >> 4 // Extended feature support is stored in the last 4 bits
+	result.Features = msg.Payload[1]         // Feature support
 	result.Actions = msg.Payload[2]
 	result.BlockchainHeight = binary.LittleEndian.Uint64(msg.Payload[3 : 3+8])
 	result.BlockchainVersion = binary.LittleEndian.Uint64(msg.Payload[11 : 11+8])
@@ -183,128 +203,21 @@ func decodeInfoStore(data []byte) (files []InfoStore, read int, valid bool) {
 // findPeer is a list of node IDs (blake3 hash of peer ID compressed form)
 // findValue is a list of hashes
 // files is a list of files stored to inform about
-func EncodeAnnouncement(sendUA, findSelf bool, findPeer []KeyHash, findValue []KeyHash, files []InfoStore, features byte, blockchainHeight, blockchainVersion uint64, userAgent string) (packetsRaw [][]byte) {
-createPacketLoop:
-	for {
-		raw := make([]byte, 64*1024) // max UDP packet size
-		packetSize := announcementPayloadHeaderSize
-
-		raw[0] = byte(ProtocolVersion) // Protocol
-		raw[1] = features              // Feature support
-		//raw[2] = Actions                                   // Action bit array
-
-		binary.LittleEndian.PutUint64(raw[3:3+8], blockchainHeight)
-		binary.LittleEndian.PutUint64(raw[11:11+8], blockchainVersion)
-
-		// only on initial announcement the User Agent must be provided according to the protocol spec
-		if sendUA {
-			userAgentB := []byte(userAgent)
-			if len(userAgentB) > 255 {
-				userAgentB = userAgentB[:255]
-			}
-
-			raw[23] = byte(len(userAgentB))
-			copy(raw[announcementPayloadHeaderSize:announcementPayloadHeaderSize+len(userAgentB)], userAgentB)
-			packetSize += len(userAgentB)
-		}
-
-		// FIND_SELF
-		if findSelf {
-			raw[2] |= 1 << ActionFindSelf
-		}
-
-		// FIND_PEER
-		if len(findPeer) > 0 {
-			// check if there is enough space for at least the header and 1 record
-			if isPacketSizeExceed(packetSize, 2+32) {
-				packetsRaw = append(packetsRaw, raw[:packetSize])
-				continue createPacketLoop
-			}
-
-			raw[2] |= 1 << ActionFindPeer
-			index := packetSize
-			packetSize += 2
-
-			for n, find := range findPeer {
-				// check if minimum length is available in packet
-				if isPacketSizeExceed(packetSize, 32) {
-					packetsRaw = append(packetsRaw, raw[:packetSize])
-					findPeer = findPeer[n:]
-					continue createPacketLoop
-				}
-
-				binary.LittleEndian.PutUint16(raw[index:index+2], uint16(n+1))
-				copy(raw[index+2+32*n:index+2+32*n+32], find.Hash)
-				packetSize += 32
-			}
-
-			findPeer = nil
-		}
-
-		// FIND_VALUE
-		if len(findValue) > 0 {
-			// check if there is enough space for at least the header and 1 record
-			if isPacketSizeExceed(packetSize, 2+32) {
-				packetsRaw = append(packetsRaw, raw[:packetSize])
-				continue createPacketLoop
-			}
-
-			raw[2] |= 1 << ActionFindValue
-			index := packetSize
-			packetSize += 2
-
-			for n, find := range findValue {
-				// check if minimum length is available in packet
-				if isPacketSizeExceed(packetSize, 32) {
-					packetsRaw = append(packetsRaw, raw[:packetSize])
-					findValue = findValue[n:]
-					continue createPacketLoop
-				}
-
-				binary.LittleEndian.PutUint16(raw[index:index+2], uint16(n+1))
-				copy(raw[index+2+32*n:index+2+32*n+32], find.Hash)
-				packetSize += 32
-			}
-
-			findValue = nil
-		}
-
-		// INFO_STORE
-		if len(files) > 0 {
-			// check if there is enough space for at least the header and 1 record
-			if isPacketSizeExceed(packetSize, 2+41) {
-				packetsRaw = append(packetsRaw, raw[:packetSize])
-				continue createPacketLoop
-			}
-
-			raw[2] |= 1 << ActionInfoStore
-			index := packetSize
-			packetSize += 2
-
-			for n, file := range files {
-				// check if minimum length is available in packet
-				if isPacketSizeExceed(packetSize, 41) {
-					packetsRaw = append(packetsRaw, raw[:packetSize])
-					files = files[n:]
-					continue createPacketLoop
-				}
-
-				binary.LittleEndian.PutUint16(raw[index:index+2], uint16(n+1))
-				copy(raw[index+2+41*n:index+2+41*n+32], file.ID.Hash)
-
-				binary.LittleEndian.PutUint64(raw[index+2+41*n+32:index+2+41*n+32+8], file.Size)
-				raw[index+2+41*n+40] = file.Type
+func EncodeAnnouncement(sendUA, findSelf bool, findPeer []KeyHash, findValue []KeyHash, files []InfoStore, features, featuresExt byte, blockchainHeight, blockchainVersion uint64, userAgent string) (packetsRaw [][]byte) {
+	builder := NewAnnouncementBuilder(features, blockchainHeight, blockchainVersion)
+	builder.SetFeaturesExt(featuresExt)
 
-				packetSize += 41
-			}
-
-			files = nil
-		}
+	// only on initial announcement the User Agent must be provided according to the protocol spec
+	if sendUA {
+		builder.SetUserAgent(userAgent)
+	}
+	if findSelf {
+		builder.FindSelf()
+	}
 
-		packetsRaw = append(packetsRaw, raw[:packetSize])
+	builder.findPeer = findPeer
+	builder.findValue = findValue
+	builder.files = files
 
-		if len(findPeer) == 0 && len(findValue) == 0 && len(files) == 0 {
-			return
-		}
-	}
+	return builder.Finalize()
 }
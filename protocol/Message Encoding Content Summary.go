@@ -0,0 +1,130 @@
+/*
+File Username:  Message Encoding Content Summary.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Content summary message encoding:
+Offset  Size    Info
+0       1       Count of hash functions
+1       ?       Bloom filter of stored hashes
+
+The bloom filter uses double hashing on the first 16 bytes of the blake3 hash: bit(i) = (h1 + i * h2) mod bits, with h1 and h2 being little endian uint64.
+An empty filter indicates that no data is stored.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// MessageContentSummary is the decoded content summary message.
+type MessageContentSummary struct {
+	*MessageRaw                 // Underlying raw message.
+	Summary     *ContentSummary // Summary of stored hashes.
+}
+
+// ContentSummary is a bloom filter of stored hashes.
+type ContentSummary struct {
+	HashCount uint8  // Count of hash functions.
+	Filter    []byte // Bloom filter bits.
+}
+
+// ContentSummaryMaxSize is the max size of the bloom filter in bytes. It keeps the message within a single unfragmented packet.
+const ContentSummaryMaxSize = 1024
+
+// contentSummaryBitsPerItem results in a false positive rate of about 1% if the max size is not exceeded.
+const contentSummaryBitsPerItem = 10
+
+// NewContentSummary creates a new empty summary sized for the count of items.
+func NewContentSummary(countItems int) (summary *ContentSummary) {
+	if countItems <= 0 {
+		return &ContentSummary{}
+	}
+
+	size := (countItems*contentSummaryBitsPerItem + 7) / 8
+	if size > ContentSummaryMaxSize {
+		size = ContentSummaryMaxSize
+	}
+
+	// optimal count of hash functions k = m/n * ln(2)
+	hashCount := int(math.Round(float64(size*8) / float64(countItems) * math.Ln2))
+	if hashCount < 1 {
+		hashCount = 1
+	} else if hashCount > 16 {
+		hashCount = 16
+	}
+
+	return &ContentSummary{HashCount: uint8(hashCount), Filter: make([]byte, size)}
+}
+
+// bits returns the bit positions for the hash.
+func (summary *ContentSummary) bits(hash []byte, callback func(bit uint64) bool) {
+	if len(summary.Filter) == 0 || len(hash) < 16 {
+		return
+	}
+
+	countBits := uint64(len(summary.Filter)) * 8
+	h1 := binary.LittleEndian.Uint64(hash[0:8])
+	h2 := binary.LittleEndian.Uint64(hash[8:16]) | 1
+
+	for n := uint64(0); n < uint64(summary.HashCount); n++ {
+		if !callback((h1 + n*h2) % countBits) {
+			return
+		}
+	}
+}
+
+// Add adds the hash to the summary.
+func (summary *ContentSummary) Add(hash []byte) {
+	summary.bits(hash, func(bit uint64) bool {
+		summary.Filter[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// Test checks if the hash is likely in the summary. False positives are possible, false negatives are not.
+func (summary *ContentSummary) Test(hash []byte) (found bool) {
+	if len(summary.Filter) == 0 || summary.HashCount == 0 || len(hash) < 16 {
+		return false
+	}
+
+	found = true
+	summary.bits(hash, func(bit uint64) bool {
+		found = summary.Filter[bit/8]&(1<<(bit%8)) != 0
+		return found
+	})
+
+	return found
+}
+
+// DecodeContentSummary decodes a content summary message.
+func DecodeContentSummary(msg *MessageRaw) (result *MessageContentSummary, err error) {
+	if len(msg.Payload) < 1 {
+		return nil, errors.New("content summary: invalid minimum length")
+	} else if len(msg.Payload)-1 > ContentSummaryMaxSize {
+		return nil, errors.New("content summary: filter too big")
+	}
+
+	result = &MessageContentSummary{
+		MessageRaw: msg,
+		Summary:    &ContentSummary{HashCount: msg.Payload[0], Filter: msg.Payload[1:]},
+	}
+
+	return result, nil
+}
+
+// EncodeContentSummary encodes a content summary message.
+func EncodeContentSummary(summary *ContentSummary) (packetRaw []byte, err error) {
+	if len(summary.Filter) > ContentSummaryMaxSize {
+		return nil, errors.New("content summary encode: filter too big")
+	}
+
+	raw := make([]byte, 1+len(summary.Filter))
+	raw[0] = summary.HashCount
+	copy(raw[1:], summary.Filter)
+
+	return raw, nil
+}
@@ -0,0 +1,187 @@
+/*
+File Username:  Message Encoding Hole Punch.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Hole punch message encoding:
+Offset  Size    Info
+0       1       Action: 0 = Request, 1 = Introduce, 2 = Punch, 3 = Punch acknowledge, 4 = Relay, 5 = Relayed, 6 = Report
+1       8       Session ID set by the initiator
+9       33      Peer ID: Request, Relay and Report to the relay = Target peer. Introduce, Relayed and Report from the relay = The other peer of the session.
+42      16      Introduce only: IP address of the other peer as observed by the relay
+58      2       Introduce only: Port, same as IP
+60      2       Introduce only: Delay in milliseconds before punching, so that the punches of both peers cross
+62      1       Introduce only: Flags. Bit 0 = The relay forwards the traffic if punching fails (relay-only mode).
+63      ?       Relay and Relayed: Embedded packet. Request, Introduce and Report: Address report, optional.
+
+Request: The initiator asks a relay, which is connected to both peers, to coordinate a simultaneous open with the target peer.
+Introduce: The relay sends both peers the observed address of the other one. Both send Punch messages directly to each other until one arrives.
+Report: The target sends its address report to the relay, which forwards it to the initiator.
+Relay: If punching failed, the encrypted packet is sent to the relay. The relay forwards it as Relayed message to the other peer of the session.
+
+Address report: The IP address of a peer as reported by the peer itself, signed by it. Peers only punch the address supplied by the relay if
+it matches the address report of the other peer. This prevents that a relay abuses peers to send packets to arbitrary addresses.
+Offset  Size    Info
+0       16      IP address
+16      65      Signature of the blake3 hash of session ID (8 bytes) and IP address (16 bytes) by the peer
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// MessageHolePunch is the decoded hole punch message.
+type MessageHolePunch struct {
+	*MessageRaw                  // Underlying raw message.
+	Action      uint8            // Action. See HolePunchX.
+	SessionID   uint64           // Session ID set by the initiator.
+	Peer        *btcec.PublicKey // Target peer or the other peer of the session. Nil if not used.
+	IP          net.IP           // Introduce only: Observed IP of the other peer.
+	Port        uint16           // Introduce only: Observed port of the other peer.
+	Delay       time.Duration    // Introduce only: Delay before punching.
+	RelayOnly   bool             // Introduce only: Whether the relay forwards the traffic if punching fails.
+	Embedded    []byte           // Relay and Relayed: Embedded packet. Request, Introduce and Report: Address report, optional.
+}
+
+// Actions in the hole punch message
+const (
+	HolePunchRequest   = 0 // Request the relay to coordinate hole punching.
+	HolePunchIntroduce = 1 // Relay informs a peer about the other one.
+	HolePunchPunch     = 2 // Punch packet sent directly between the peers.
+	HolePunchAck       = 3 // Acknowledge a received punch packet.
+	HolePunchRelay     = 4 // Packet to be forwarded by the relay.
+	HolePunchRelayed   = 5 // Packet forwarded by the relay.
+	HolePunchReport    = 6 // Address report of the target forwarded by the relay to the initiator.
+)
+
+const holePunchPayloadHeaderSize = 63
+
+// holePunchReportSize is the size of an address report.
+const holePunchReportSize = 16 + 65
+
+// HolePunchEmbedSizeMax is the max size of a packet embedded in a Relay message.
+const HolePunchEmbedSizeMax = udpMaxPacketSize - PacketLengthMin - holePunchPayloadHeaderSize
+
+// DecodeHolePunch decodes a hole punch message.
+func DecodeHolePunch(msg *MessageRaw) (result *MessageHolePunch, err error) {
+	if len(msg.Payload) < holePunchPayloadHeaderSize {
+		return nil, errors.New("hole punch: invalid minimum length")
+	}
+
+	result = &MessageHolePunch{
+		MessageRaw: msg,
+		Action:     msg.Payload[0],
+		SessionID:  binary.LittleEndian.Uint64(msg.Payload[1:9]),
+	}
+
+	switch result.Action {
+	case HolePunchRequest, HolePunchIntroduce, HolePunchRelay, HolePunchRelayed, HolePunchReport:
+		if result.Peer, err = btcec.ParsePubKey(msg.Payload[9:42], btcec.S256()); err != nil {
+			return nil, err
+		}
+	}
+
+	switch result.Action {
+	case HolePunchIntroduce:
+		result.IP = make(net.IP, net.IPv6len)
+		copy(result.IP, msg.Payload[42:58])
+		result.Port = binary.LittleEndian.Uint16(msg.Payload[58:60])
+		result.Delay = time.Duration(binary.LittleEndian.Uint16(msg.Payload[60:62])) * time.Millisecond
+		result.RelayOnly = msg.Payload[62]&1 > 0
+	}
+
+	switch result.Action {
+	case HolePunchRequest, HolePunchIntroduce, HolePunchReport:
+		result.Embedded = msg.Payload[holePunchPayloadHeaderSize:]
+
+	case HolePunchRelay, HolePunchRelayed:
+		result.Embedded = msg.Payload[holePunchPayloadHeaderSize:]
+		if len(result.Embedded) == 0 {
+			return nil, errors.New("hole punch: missing embedded packet")
+		}
+	}
+
+	return result, nil
+}
+
+// EncodeHolePunch encodes a hole punch message. The peer, address, delay, and relay flag are only used by the actions that carry them.
+func EncodeHolePunch(action uint8, sessionID uint64, peer *btcec.PublicKey, ip net.IP, port uint16, delay time.Duration, relayOnly bool, embedded []byte) (packetRaw []byte, err error) {
+	if len(embedded) > HolePunchEmbedSizeMax {
+		return nil, errors.New("hole punch: embedded packet exceeds max size")
+	}
+
+	raw := make([]byte, holePunchPayloadHeaderSize+len(embedded))
+
+	raw[0] = action
+	binary.LittleEndian.PutUint64(raw[1:9], sessionID)
+	if peer != nil {
+		copy(raw[9:42], peer.SerializeCompressed())
+	}
+	if ip != nil {
+		copy(raw[42:58], ip.To16())
+	}
+	binary.LittleEndian.PutUint16(raw[58:60], port)
+
+	delayMs := delay.Milliseconds()
+	if delayMs > 0xFFFF {
+		delayMs = 0xFFFF
+	}
+	binary.LittleEndian.PutUint16(raw[60:62], uint16(delayMs))
+
+	if relayOnly {
+		raw[62] |= 1
+	}
+	copy(raw[holePunchPayloadHeaderSize:], embedded)
+
+	return raw, nil
+}
+
+// holePunchReportHash returns the hash signed by an address report.
+func holePunchReportHash(sessionID uint64, ip net.IP) []byte {
+	data := make([]byte, 8+net.IPv6len)
+	binary.LittleEndian.PutUint64(data[0:8], sessionID)
+	copy(data[8:], ip.To16())
+
+	return HashData(data)
+}
+
+// EncodeHolePunchReport creates an address report of the IP address signed by the peer's private key. See the description above.
+func EncodeHolePunchReport(privateKey *btcec.PrivateKey, sessionID uint64, ip net.IP) (report []byte, err error) {
+	if ip.To16() == nil {
+		return nil, errors.New("hole punch: invalid IP")
+	}
+
+	signature, err := btcec.SignCompact(btcec.S256(), privateKey, holePunchReportHash(sessionID, ip), true)
+	if err != nil {
+		return nil, err
+	}
+
+	report = make([]byte, holePunchReportSize)
+	copy(report[0:16], ip.To16())
+	copy(report[16:], signature)
+
+	return report, nil
+}
+
+// VerifyHolePunchReport checks if the address report is signed by the peer and returns the reported IP address.
+func VerifyHolePunchReport(report []byte, sessionID uint64, signer *btcec.PublicKey) (ip net.IP, valid bool) {
+	if len(report) != holePunchReportSize || signer == nil {
+		return nil, false
+	}
+
+	ip = make(net.IP, net.IPv6len)
+	copy(ip, report[0:16])
+
+	if recovered, _, err := btcec.RecoverCompact(btcec.S256(), report[16:], holePunchReportHash(sessionID, ip)); err != nil || !recovered.IsEqual(signer) {
+		return nil, false
+	}
+
+	return ip, true
+}
@@ -0,0 +1,114 @@
+/*
+File Username:  Message Encoding Latency.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Latency records are appended to response messages if the action bit ActionLatencyRecords is set. They report the RTT measured by the sender
+to other peers. They follow the regular response data and any piggybacked INFO_STORE records:
+Offset  Size    Info
+0       1       Count of latency records
+1       35 * n  Latency records: 33 bytes peer ID compressed, 2 bytes RTT in milliseconds
+
+Older clients ignore the additional data.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// LatencyRecordsMax is the max count of latency records appended to a single response message.
+const LatencyRecordsMax = 8
+
+// latencyRecordSize is the size of a single encoded latency record.
+const latencyRecordSize = 35
+
+// latencyRTTMax is the max RTT that can be encoded.
+const latencyRTTMax = 0xFFFF * time.Millisecond
+
+// LatencyRecord is the RTT measured by the sender of the message to another peer.
+type LatencyRecord struct {
+	PublicKey *btcec.PublicKey // Peer ID of the other peer.
+	RTT       time.Duration    // Round-trip time. The precision is milliseconds.
+}
+
+// decodeLatencyRecords decodes latency records. Records with an invalid public key are skipped.
+func decodeLatencyRecords(data []byte) (records []LatencyRecord, read int, valid bool) {
+	if len(data) < 1 {
+		return nil, 0, false
+	}
+
+	count := int(data[0])
+	if read = 1 + count*latencyRecordSize; len(data) < read {
+		return nil, 0, false
+	}
+
+	for n := 0; n < count; n++ {
+		offset := 1 + n*latencyRecordSize
+
+		publicKey, err := btcec.ParsePubKey(data[offset:offset+33], btcec.S256())
+		if err != nil {
+			continue
+		}
+
+		rtt := time.Duration(binary.LittleEndian.Uint16(data[offset+33:offset+35])) * time.Millisecond
+		records = append(records, LatencyRecord{PublicKey: publicKey, RTT: rtt})
+	}
+
+	return records, read, true
+}
+
+// encodeLatencyRecords encodes up to LatencyRecordsMax latency records that fit into a packet with the given payload size. Returns nil if none fit.
+func encodeLatencyRecords(packetSize int, records []LatencyRecord) (raw []byte) {
+	count := 0
+	for count < len(records) && count < LatencyRecordsMax && !isPacketSizeExceed(packetSize, 1+latencyRecordSize*(count+1)) {
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	raw = make([]byte, 1+latencyRecordSize*count)
+	raw[0] = byte(count)
+
+	for n, record := range records[:count] {
+		offset := 1 + n*latencyRecordSize
+
+		rtt := record.RTT
+		if rtt > latencyRTTMax {
+			rtt = latencyRTTMax
+		}
+
+		copy(raw[offset:offset+33], record.PublicKey.SerializeCompressed())
+		binary.LittleEndian.PutUint16(raw[offset+33:offset+35], uint16(rtt/time.Millisecond))
+	}
+
+	return raw
+}
+
+// ResponseAppendLatency appends latency records to the last packet returned by EncodeResponse if space permits. It must be called after
+// ResponseAppendInfoStore. At most LatencyRecordsMax records are appended. It returns the count of records appended.
+func ResponseAppendLatency(packetsRaw [][]byte, records []LatencyRecord) (count int) {
+	if len(packetsRaw) == 0 || len(records) == 0 {
+		return 0
+	}
+
+	packet := packetsRaw[len(packetsRaw)-1]
+	if packet[2]&(1<<ActionLatencyRecords|1<<ActionTimestamp) > 0 {
+		return 0
+	}
+
+	record := encodeLatencyRecords(len(packet), records)
+	if record == nil {
+		return 0
+	}
+
+	packet[2] |= 1 << ActionLatencyRecords
+	packetsRaw[len(packetsRaw)-1] = append(packet, record...)
+
+	return int(record[0])
+}
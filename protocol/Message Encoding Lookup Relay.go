@@ -0,0 +1,83 @@
+/*
+File Username:  Message Encoding Lookup Relay.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Lookup relay message encoding:
+Offset  Size    Info
+0       1       Action
+1       33      Peer ID (compressed public key) of the target. For responses, the peer that sent the embedded packet.
+34      16      IP address of the target
+50      2       Port of the target
+52      ?       Embedded packet, encrypted and signed by the original sender
+
+The relay forwards the embedded packet from its own address to the target, which hides the IP address of the original sender.
+Packets that the target sends back are forwarded by the relay to the original sender.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// MessageLookupRelay is the decoded lookup relay message.
+type MessageLookupRelay struct {
+	*MessageRaw                        // Underlying raw message.
+	Action            uint8            // Action. See LookupRelayX.
+	Target            *btcec.PublicKey // Target peer ID.
+	IP                net.IP           // IP address of the target.
+	Port              uint16           // Port of the target.
+	EmbeddedPacketRaw []byte           // Embedded packet.
+}
+
+const (
+	LookupRelayForward  = 0 // Request to forward the embedded packet to the target.
+	LookupRelayResponse = 1 // Packet received from the target, forwarded back to the original sender.
+)
+
+const lookupRelayPayloadHeaderSize = 52
+
+// DecodeLookupRelay decodes a lookup relay message.
+func DecodeLookupRelay(msg *MessageRaw) (result *MessageLookupRelay, err error) {
+	if len(msg.Payload) < lookupRelayPayloadHeaderSize+PacketLengthMin {
+		return nil, errors.New("lookup relay: invalid minimum length")
+	}
+
+	result = &MessageLookupRelay{
+		MessageRaw: msg,
+		Action:     msg.Payload[0],
+	}
+
+	if result.Target, err = btcec.ParsePubKey(msg.Payload[1:34], btcec.S256()); err != nil {
+		return nil, err
+	}
+
+	result.IP = make(net.IP, net.IPv6len)
+	copy(result.IP, msg.Payload[34:50])
+	result.Port = binary.LittleEndian.Uint16(msg.Payload[50:52])
+	result.EmbeddedPacketRaw = msg.Payload[lookupRelayPayloadHeaderSize:]
+
+	return result, nil
+}
+
+// EncodeLookupRelay encodes a lookup relay message.
+func EncodeLookupRelay(action uint8, target *btcec.PublicKey, ip net.IP, port uint16, embeddedPacketRaw []byte) (packetRaw []byte, err error) {
+	if isPacketSizeExceed(lookupRelayPayloadHeaderSize, len(embeddedPacketRaw)) {
+		return nil, errors.New("lookup relay encode: embedded packet too big")
+	}
+
+	raw := make([]byte, lookupRelayPayloadHeaderSize+len(embeddedPacketRaw))
+
+	raw[0] = action
+	copy(raw[1:34], target.SerializeCompressed())
+	copy(raw[34:50], ip.To16())
+	binary.LittleEndian.PutUint16(raw[50:52], port)
+	copy(raw[lookupRelayPayloadHeaderSize:], embeddedPacketRaw)
+
+	return raw, nil
+}
@@ -0,0 +1,88 @@
+/*
+File Username:  Message Encoding NAT Probe.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+NAT probe message encoding:
+Offset  Size    Info
+0       1       Action: 0 = Request, 1 = Reply, 2 = Request forward, 3 = Forwarded
+1       8       Probe ID
+9       16      IP: Reply = IP address of the requester as seen by the sender. Forwarded = Address to send the reply to. Otherwise not used.
+25      2       Port, same as IP
+27      33      Forwarded only: Peer ID of the requester
+
+Request: The receiver replies with the observed IP:Port of the requester.
+Request forward: The receiver selects another peer and sends it a Forwarded message with the observed IP:Port of the requester. That peer sends an unsolicited Reply to it.
+If the Reply is received, the requester's NAT does not filter incoming packets from unknown endpoints (full cone).
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/PeernetOfficial/core/btcec"
+)
+
+// MessageNATProbe is the decoded NAT probe message.
+type MessageNATProbe struct {
+	*MessageRaw                  // Underlying raw message.
+	Action      uint8            // Action. See NATProbeX.
+	ProbeID     uint64           // Probe ID set by the requester.
+	IP          net.IP           // Observed or target IP address.
+	Port        uint16           // Observed or target port.
+	Requester   *btcec.PublicKey // Forwarded only: Peer ID of the requester.
+}
+
+// Actions in the NAT probe message
+const (
+	NATProbeRequest        = 0 // Request the observed address.
+	NATProbeReply          = 1 // Reply with the observed address.
+	NATProbeRequestForward = 2 // Request another peer to send an unsolicited reply.
+	NATProbeForwarded      = 3 // Send an unsolicited reply to the requester.
+)
+
+const natProbePayloadSize = 60
+
+// DecodeNATProbe decodes a NAT probe message.
+func DecodeNATProbe(msg *MessageRaw) (result *MessageNATProbe, err error) {
+	if len(msg.Payload) < natProbePayloadSize {
+		return nil, errors.New("NAT probe: invalid minimum length")
+	}
+
+	result = &MessageNATProbe{
+		MessageRaw: msg,
+		Action:     msg.Payload[0],
+		ProbeID:    binary.LittleEndian.Uint64(msg.Payload[1:9]),
+		IP:         make(net.IP, net.IPv6len),
+		Port:       binary.LittleEndian.Uint16(msg.Payload[25:27]),
+	}
+	copy(result.IP, msg.Payload[9:25])
+
+	if result.Action == NATProbeForwarded {
+		if result.Requester, err = btcec.ParsePubKey(msg.Payload[27:60], btcec.S256()); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// EncodeNATProbe encodes a NAT probe message. The requester is only used for forwarded messages.
+func EncodeNATProbe(action uint8, probeID uint64, ip net.IP, port uint16, requester *btcec.PublicKey) (packetRaw []byte) {
+	raw := make([]byte, natProbePayloadSize)
+
+	raw[0] = action
+	binary.LittleEndian.PutUint64(raw[1:9], probeID)
+	if ip != nil {
+		copy(raw[9:25], ip.To16())
+	}
+	binary.LittleEndian.PutUint16(raw[25:27], port)
+	if requester != nil {
+		copy(raw[27:60], requester.SerializeCompressed())
+	}
+
+	return raw
+}
@@ -0,0 +1,189 @@
+/*
+File Username:  Message Encoding Onion.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Onion message encoding:
+Offset  Size    Info
+0       1       Direction: 0 = Forward, 1 = Backward
+1       8       Circuit ID. It is different for each link between 2 hops.
+9       2       Size of data
+11      ?       Data. Forward: Onion layer encrypted for the receiver. Backward: Data encrypted by each hop with its backward key.
+?       ?       Random padding
+
+Onion layer (decrypted):
+Offset  Size    Info
+0       1       Action: 0 = Relay to next hop, 1 = Exit, 2 = Deliver
+1       32      Backward key used for packets sent back through the circuit
+33      8       Relay: Circuit ID for the link to the next hop
+41      33      Relay: Peer ID of the next hop. Exit: Peer ID of the target.
+74      16      Relay/Exit: IP address of the next hop or target
+90      2       Relay/Exit: Port of the next hop or target
+92      ?       Relay: Onion layer for the next hop. Exit/Deliver: Embedded packet.
+
+Each onion layer is encrypted using ECIES (ECDH secp256k1, AES-256-CBC, HMAC-SHA-256) for the public key of the hop.
+Backward data is prefixed by each hop with a random 8-byte nonce and encrypted using Salsa20 with the backward key.
+*/
+
+package protocol
+
+import (
+	cryptoRand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+
+	"github.com/PeernetOfficial/core/btcec"
+	"golang.org/x/crypto/salsa20"
+)
+
+// MessageOnion is the decoded onion message.
+type MessageOnion struct {
+	*MessageRaw        // Underlying raw message.
+	Direction   uint8  // Direction. See OnionX.
+	CircuitID   uint64 // Circuit ID of the link.
+	Data        []byte // Data without padding.
+}
+
+// OnionLayer is a single decrypted onion layer.
+type OnionLayer struct {
+	Action      uint8            // Action. See OnionActionX.
+	BackwardKey [32]byte         // Key for encrypting packets sent back.
+	CircuitID   uint64           // Relay: Circuit ID for the link to the next hop.
+	Peer        *btcec.PublicKey // Relay: Next hop. Exit: Target. Nil for Deliver.
+	IP          net.IP           // IP address of the next hop or target.
+	Port        uint16           // Port of the next hop or target.
+	Data        []byte           // Onion layer for next hop or embedded packet.
+}
+
+// Directions of onion messages
+const (
+	OnionForward  = 0 // From the original sender through the circuit.
+	OnionBackward = 1 // Back to the original sender.
+)
+
+// Actions in onion layers
+const (
+	OnionActionRelay   = 0 // Forward the inner layer to the next hop.
+	OnionActionExit    = 1 // Send the embedded packet to the target and relay any packets back.
+	OnionActionDeliver = 2 // The embedded packet is for the receiver of the layer.
+)
+
+// OnionPaddedSize is the minimum size of onion message payloads. Smaller ones are padded, which prevents hops from learning their position in the circuit.
+const OnionPaddedSize = 1024
+
+const onionPayloadHeaderSize = 11
+const onionLayerHeaderSize = 92
+const onionNonceSize = 8
+
+// DecodeOnion decodes an onion message.
+func DecodeOnion(msg *MessageRaw) (result *MessageOnion, err error) {
+	if len(msg.Payload) < onionPayloadHeaderSize {
+		return nil, errors.New("onion: invalid minimum length")
+	}
+
+	result = &MessageOnion{
+		MessageRaw: msg,
+		Direction:  msg.Payload[0],
+		CircuitID:  binary.LittleEndian.Uint64(msg.Payload[1:9]),
+	}
+
+	sizeData := int(binary.LittleEndian.Uint16(msg.Payload[9:11]))
+	if sizeData > len(msg.Payload)-onionPayloadHeaderSize {
+		return nil, errors.New("onion: invalid data size")
+	}
+	result.Data = msg.Payload[onionPayloadHeaderSize : onionPayloadHeaderSize+sizeData]
+
+	return result, nil
+}
+
+// EncodeOnion encodes an onion message. It is padded to OnionPaddedSize with random data.
+func EncodeOnion(direction uint8, circuitID uint64, data []byte) (packetRaw []byte, err error) {
+	if isPacketSizeExceed(onionPayloadHeaderSize, len(data)) || len(data) > 0xFFFF {
+		return nil, errors.New("onion encode: data too big")
+	}
+
+	size := onionPayloadHeaderSize + len(data)
+	if size < OnionPaddedSize {
+		size = OnionPaddedSize
+	}
+
+	raw := make([]byte, size)
+	raw[0] = direction
+	binary.LittleEndian.PutUint64(raw[1:9], circuitID)
+	binary.LittleEndian.PutUint16(raw[9:11], uint16(len(data)))
+	copy(raw[onionPayloadHeaderSize:], data)
+	rand.Read(raw[onionPayloadHeaderSize+len(data):])
+
+	return raw, nil
+}
+
+// EncodeOnionLayer encodes and encrypts an onion layer for the receiver.
+func EncodeOnionLayer(layer *OnionLayer, receiver *btcec.PublicKey) (ciphertext []byte, err error) {
+	raw := make([]byte, onionLayerHeaderSize+len(layer.Data))
+
+	raw[0] = layer.Action
+	copy(raw[1:33], layer.BackwardKey[:])
+	binary.LittleEndian.PutUint64(raw[33:41], layer.CircuitID)
+	if layer.Peer != nil {
+		copy(raw[41:74], layer.Peer.SerializeCompressed())
+	}
+	if layer.IP != nil {
+		copy(raw[74:90], layer.IP.To16())
+	}
+	binary.LittleEndian.PutUint16(raw[90:92], layer.Port)
+	copy(raw[onionLayerHeaderSize:], layer.Data)
+
+	return btcec.Encrypt(receiver, raw)
+}
+
+// DecodeOnionLayer decrypts and decodes an onion layer.
+func DecodeOnionLayer(ciphertext []byte, receiver *btcec.PrivateKey) (layer *OnionLayer, err error) {
+	raw, err := btcec.Decrypt(receiver, ciphertext)
+	if err != nil {
+		return nil, err
+	} else if len(raw) < onionLayerHeaderSize {
+		return nil, errors.New("onion layer: invalid minimum length")
+	}
+
+	layer = &OnionLayer{Action: raw[0]}
+	copy(layer.BackwardKey[:], raw[1:33])
+	layer.CircuitID = binary.LittleEndian.Uint64(raw[33:41])
+	layer.Data = raw[onionLayerHeaderSize:]
+
+	if layer.Action == OnionActionRelay || layer.Action == OnionActionExit {
+		if layer.Peer, err = btcec.ParsePubKey(raw[41:74], btcec.S256()); err != nil {
+			return nil, err
+		}
+
+		layer.IP = make(net.IP, net.IPv6len)
+		copy(layer.IP, raw[74:90])
+		layer.Port = binary.LittleEndian.Uint16(raw[90:92])
+	}
+
+	return layer, nil
+}
+
+// OnionBackwardWrap encrypts backward data with the key of a hop.
+func OnionBackwardWrap(data []byte, key *[32]byte) (wrapped []byte, err error) {
+	wrapped = make([]byte, onionNonceSize+len(data))
+	if _, err = cryptoRand.Read(wrapped[:onionNonceSize]); err != nil {
+		return nil, err
+	}
+	salsa20.XORKeyStream(wrapped[onionNonceSize:], data, wrapped[:onionNonceSize], key)
+
+	return wrapped, nil
+}
+
+// OnionBackwardUnwrap decrypts backward data that was wrapped by a hop.
+func OnionBackwardUnwrap(wrapped []byte, key *[32]byte) (data []byte, err error) {
+	if len(wrapped) < onionNonceSize {
+		return nil, errors.New("onion backward: invalid length")
+	}
+
+	data = make([]byte, len(wrapped)-onionNonceSize)
+	salsa20.XORKeyStream(data, wrapped[onionNonceSize:], wrapped[:onionNonceSize], key)
+
+	return data, nil
+}
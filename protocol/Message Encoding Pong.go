@@ -0,0 +1,75 @@
+/*
+File Username:  Message Encoding Pong.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Pong message encoding. The payload is optional and only present if INFO_STORE records are piggybacked:
+Offset  Size    Info
+0       2       Count of INFO_STORE records
+2       41 * n  INFO_STORE records: 32 bytes hash, 8 bytes size, 1 byte type
+
+Response messages carry the same structure after the regular response data if the action bit ActionInfoStorePiggyback is set.
+Older clients ignore the additional data.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// MessagePong is the decoded pong message.
+type MessagePong struct {
+	*MessageRaw                // Underlying raw message
+	InfoStoreFiles []InfoStore // INFO_STORE records piggybacked by the sender
+}
+
+// PiggybackInfoStoreMax is the max count of INFO_STORE records piggybacked on a single pong or response message.
+const PiggybackInfoStoreMax = 2
+
+// DecodePong decodes a pong message. An empty payload is valid.
+func DecodePong(msg *MessageRaw) (result *MessagePong, err error) {
+	result = &MessagePong{MessageRaw: msg}
+
+	if len(msg.Payload) == 0 {
+		return result, nil
+	}
+
+	files, _, valid := decodeInfoStore(msg.Payload)
+	if !valid {
+		return nil, errors.New("pong: INFO_STORE invalid data")
+	}
+	result.InfoStoreFiles = files
+
+	return result, nil
+}
+
+// EncodePong encodes a pong message with piggybacked INFO_STORE records. It returns the count of records included.
+func EncodePong(files []InfoStore) (packetRaw []byte, count int) {
+	if packetRaw = encodeInfoStorePiggyback(0, files); packetRaw == nil {
+		return nil, 0
+	}
+
+	return packetRaw, int(binary.LittleEndian.Uint16(packetRaw[0:2]))
+}
+
+// encodeInfoStorePiggyback encodes up to PiggybackInfoStoreMax INFO_STORE records that fit into a packet with the given payload size. Returns nil if none fit.
+func encodeInfoStorePiggyback(packetSize int, files []InfoStore) (raw []byte) {
+	count := 0
+	for count < len(files) && count < PiggybackInfoStoreMax && !isPacketSizeExceed(packetSize, 2+41*(count+1)) {
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	raw = make([]byte, 2+41*count)
+	binary.LittleEndian.PutUint16(raw[0:2], uint16(count))
+
+	for n := range files[:count] {
+		encodeInfoStore(raw[2+41*n:2+41*n+41], &files[n])
+	}
+
+	return raw
+}
@@ -15,13 +15,15 @@ import (
 	"unicode/utf8"
 
 	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/serialize"
 )
 
 // MessageResponse is the decoded response message.
 type MessageResponse struct {
 	*MessageRaw                          // Underlying raw message
 	Protocol          uint8              // Protocol version supported (low 4 bits).
-	Features          uint8              // Feature support (high 4 bits). Future use.
+	Features          uint8              // Feature support
+	FeaturesExt       uint8              // Extended feature support (high 4 bits of the protocol byte). See FeatureExtX.
 	Actions           uint8              // Action bit array. See ActionX
 	BlockchainHeight  uint64             // Blockchain height
 	BlockchainVersion uint64             // Blockchain version
@@ -30,7 +32,11 @@ type MessageResponse struct {
 	UserAgent         string             // User Agent. Format "Software/Version". Required in the initial announcement/bootstrap. UTF-8 encoded. Max length is 255 bytes.
 	Hash2Peers        []Hash2Peer        // List of peers that know the requested hashes or at least are close to it
 	FilesEmbed        []EmbeddedFileData // Files that were embedded in the response
+	FilesEmbedInvalid int                // Count of embedded files that were dropped because the data did not match the hash
 	HashesNotFound    [][]byte           // Hashes that were reported back as not found
+	InfoStoreFiles    []InfoStore        // INFO_STORE records piggybacked by the sender
+	LatencyRecords    []LatencyRecord    // RTTs measured by the sender to other peers
+	Timestamp         time.Time          // Time when the sender sent the response. Zero if not provided.
 }
 
 // PeerRecord informs about a peer
@@ -66,7 +72,10 @@ type EmbeddedFileData struct {
 
 // Actions in Response message
 const (
-	ActionSequenceLast = 0 // SEQUENCE_LAST Last response to the announcement in the sequence
+	ActionSequenceLast       = 0 // SEQUENCE_LAST Last response to the announcement in the sequence
+	ActionInfoStorePiggyback = 1 // INFO_STORE records are appended after the response data
+	ActionLatencyRecords     = 2 // Latency records are appended after the response data and any INFO_STORE records
+	ActionTimestamp          = 3 // The time of the sender is appended after the response data, any INFO_STORE records, and any latency records
 )
 
 // DecodeResponse decodes the incoming response message. Returns nil if invalid.
@@ -79,8 +88,9 @@ func DecodeResponse(msg *MessageRaw) (result *MessageResponse, err error) {
 		return nil, errors.New("response: invalid minimum length")
 	}
 
-	result.Protocol = msg.Payload[0] & 0x0F // Protocol version support is stored in the first 4 bits
-	result.Features = msg.Payload[1]        // Feature support
+	result.Protocol = msg.Payload[0] & 0x0F  // Protocol version support is stored in the first 4 bits
+	result.FeaturesExt = msg.Payload[0] >> 4 // Extended feature support is stored in the last 4 bits
+	result.Features = msg.Payload[1]         // Feature support
 	result.Actions = msg.Payload[2]
 	result.BlockchainHeight = binary.LittleEndian.Uint64(msg.Payload[3 : 3+8])
 	result.BlockchainVersion = binary.LittleEndian.Uint64(msg.Payload[11 : 11+8])
@@ -109,7 +119,7 @@ func DecodeResponse(msg *MessageRaw) (result *MessageResponse, err error) {
 	countHashesNotFound := binary.LittleEndian.Uint16(msg.Payload[read+4 : read+4+2])
 	read += 6
 
-	if countPeerResponses == 0 && countEmbeddedFiles == 0 && countHashesNotFound == 0 {
+	if countPeerResponses == 0 && countEmbeddedFiles == 0 && countHashesNotFound == 0 && result.Actions&(1<<ActionInfoStorePiggyback|1<<ActionLatencyRecords|1<<ActionTimestamp) == 0 {
 		// Empty responses are allowed. They can be useful as quasi-pings to get the latest blockchain info of the peer.
 		return
 	}
@@ -129,13 +139,14 @@ func DecodeResponse(msg *MessageRaw) (result *MessageResponse, err error) {
 
 	// Embedded files
 	if countEmbeddedFiles > 0 {
-		filesEmbed, read, valid := decodeEmbeddedFile(data, int(countEmbeddedFiles))
+		filesEmbed, invalid, read, valid := decodeEmbeddedFile(data, int(countEmbeddedFiles))
 		if !valid {
 			return nil, errors.New("response: embedded file invalid data")
 		}
 		data = data[read:]
 
 		result.FilesEmbed = append(result.FilesEmbed, filesEmbed...)
+		result.FilesEmbedInvalid += invalid
 	}
 
 	// Hashes not found
@@ -150,6 +161,39 @@ func DecodeResponse(msg *MessageRaw) (result *MessageResponse, err error) {
 
 			result.HashesNotFound = append(result.HashesNotFound, hash)
 		}
+		data = data[int(countHashesNotFound)*32:]
+	}
+
+	// Piggybacked INFO_STORE
+	if result.Actions&(1<<ActionInfoStorePiggyback) > 0 {
+		files, read, valid := decodeInfoStore(data)
+		if !valid {
+			return nil, errors.New("response: INFO_STORE invalid data")
+		}
+		data = data[read:]
+
+		result.InfoStoreFiles = files
+	}
+
+	// Latency records
+	if result.Actions&(1<<ActionLatencyRecords) > 0 {
+		records, read, valid := decodeLatencyRecords(data)
+		if !valid {
+			return nil, errors.New("response: latency records invalid data")
+		}
+		data = data[read:]
+
+		result.LatencyRecords = records
+	}
+
+	// Timestamp
+	if result.Actions&(1<<ActionTimestamp) > 0 {
+		timestamp, _, valid := decodeTimestamp(data)
+		if !valid {
+			return nil, errors.New("response: timestamp invalid data")
+		}
+
+		result.Timestamp = timestamp
 	}
 
 	return
@@ -160,88 +204,81 @@ const peerRecordSize = 70
 
 // decodePeerRecord decodes the response data for FIND_SELF, FIND_PEER and FIND_VALUE messages
 func decodePeerRecord(data []byte, count int) (hash2Peers []Hash2Peer, read int, valid bool) {
-	index := 0
+	reader := serialize.NewReader(data)
 
 	for n := 0; n < count; n++ {
-		if read += 34; len(data) < read {
+		hash := reader.BytesCopy(HashSize)
+		countField := reader.Uint16()
+		if reader.Err() != nil {
 			return nil, 0, false
 		}
 
-		hash := make([]byte, HashSize)
-		copy(hash, data[index:index+32])
-		countField := binary.LittleEndian.Uint16(data[index+32:index+32+2]) & 0x7FFF
-		isLast := binary.LittleEndian.Uint16(data[index+32:index+32+2])&0x8000 > 0
-		index += 34
-
-		hash2Peer := Hash2Peer{ID: KeyHash{hash}, IsLast: isLast}
+		hash2Peer := Hash2Peer{ID: KeyHash{hash}, IsLast: countField&0x8000 > 0}
 
 		// Response contains peer records
-		for m := 0; m < int(countField); m++ {
-			if read += peerRecordSize; len(data) < read {
+		for m := 0; m < int(countField&0x7FFF); m++ {
+			peer, reason, valid := decodePeerRecordSingle(reader)
+			if !valid {
 				return nil, 0, false
 			}
 
-			peer := PeerRecord{}
-
-			peerIDcompressed := make([]byte, 33)
-			copy(peerIDcompressed[:], data[index:index+33])
-
-			// IPv4
-			ipv4B := make([]byte, 4)
-			copy(ipv4B[:], data[index+33:index+33+4])
-
-			peer.IPv4 = ipv4B
-			peer.IPv4Port = binary.LittleEndian.Uint16(data[index+37 : index+37+2])
-			peer.IPv4PortReportedInternal = binary.LittleEndian.Uint16(data[index+39 : index+39+2])
-			peer.IPv4PortReportedExternal = binary.LittleEndian.Uint16(data[index+41 : index+41+2])
+			if reason == 0 { // Peer was returned because it is close to the requested hash
+				hash2Peer.Closest = append(hash2Peer.Closest, peer)
+			} else if reason == 1 { // Peer stores the data
+				hash2Peer.Storing = append(hash2Peer.Storing, peer)
+			}
+		}
 
-			// IPv6
-			ipv6B := make([]byte, 16)
-			copy(ipv6B[:], data[index+43:index+43+16])
+		hash2Peers = append(hash2Peers, hash2Peer)
+	}
 
-			peer.IPv6 = ipv6B
-			peer.IPv6Port = binary.LittleEndian.Uint16(data[index+59 : index+59+2])
-			peer.IPv6PortReportedInternal = binary.LittleEndian.Uint16(data[index+61 : index+61+2])
-			peer.IPv6PortReportedExternal = binary.LittleEndian.Uint16(data[index+63 : index+63+2])
+	return hash2Peers, reader.Offset(), true
+}
 
-			if peer.IPv6.To4() != nil { // IPv6 address mismatch
-				return nil, 0, false
-			}
+// decodePeerRecordSingle decodes a single peer record. Reason indicates why the peer was returned: 0 = close to the hash, 1 = stores the data.
+func decodePeerRecordSingle(reader *serialize.Reader) (peer PeerRecord, reason uint8, valid bool) {
+	peerIDcompressed := reader.Bytes(33)
 
-			peer.LastContact = binary.LittleEndian.Uint32(data[index+65 : index+65+4])
-			peer.LastContactT = time.Now().Add(-time.Second * time.Duration(peer.LastContact))
-			peer.Features = data[index+69] & 0x7F
-			reason := data[index+69] >> 7
+	// IPv4
+	peer.IPv4 = reader.BytesCopy(4)
+	peer.IPv4Port = reader.Uint16()
+	peer.IPv4PortReportedInternal = reader.Uint16()
+	peer.IPv4PortReportedExternal = reader.Uint16()
 
-			var err error
-			if peer.PublicKey, err = btcec.ParsePubKey(peerIDcompressed, btcec.S256()); err != nil {
-				return nil, 0, false
-			}
+	// IPv6
+	peer.IPv6 = reader.BytesCopy(16)
+	peer.IPv6Port = reader.Uint16()
+	peer.IPv6PortReportedInternal = reader.Uint16()
+	peer.IPv6PortReportedExternal = reader.Uint16()
 
-			peer.NodeID = PublicKey2NodeID(peer.PublicKey)
+	peer.LastContact = reader.Uint32()
+	features := reader.Uint8()
 
-			if reason == 0 { // Peer was returned because it is close to the requested hash
-				hash2Peer.Closest = append(hash2Peer.Closest, peer)
-			} else if reason == 1 { // Peer stores the data
-				hash2Peer.Storing = append(hash2Peer.Storing, peer)
-			}
+	if reader.Err() != nil || peer.IPv6.To4() != nil { // IPv6 address mismatch
+		return peer, 0, false
+	}
 
-			index += peerRecordSize
-		}
+	peer.LastContactT = time.Now().Add(-time.Second * time.Duration(peer.LastContact))
+	peer.Features = features & 0x7F
+	reason = features >> 7
 
-		hash2Peers = append(hash2Peers, hash2Peer)
+	var err error
+	if peer.PublicKey, err = btcec.ParsePubKey(peerIDcompressed, btcec.S256()); err != nil {
+		return peer, 0, false
 	}
 
-	return hash2Peers, read, true
+	peer.NodeID = PublicKey2NodeID(peer.PublicKey)
+
+	return peer, reason, true
 }
 
-// decodeEmbeddedFile decodes the embedded file response data for FIND_VALUE
-func decodeEmbeddedFile(data []byte, count int) (filesEmbed []EmbeddedFileData, read int, valid bool) {
+// decodeEmbeddedFile decodes the embedded file response data for FIND_VALUE. Files with data not matching the hash are dropped and counted as invalid.
+func decodeEmbeddedFile(data []byte, count int) (filesEmbed []EmbeddedFileData, invalid, read int, valid bool) {
 	index := 0
 
 	for n := 0; n < count; n++ {
 		if read += 34; len(data) < read {
-			return nil, 0, false
+			return nil, 0, 0, false
 		}
 
 		hash := make([]byte, HashSize)
@@ -250,7 +287,7 @@ func decodeEmbeddedFile(data []byte, count int) (filesEmbed []EmbeddedFileData,
 		index += 34
 
 		if read += sizeField; len(data) < read {
-			return nil, 0, false
+			return nil, 0, 0, false
 		}
 
 		fileData := make([]byte, sizeField)
@@ -260,183 +297,83 @@ func decodeEmbeddedFile(data []byte, count int) (filesEmbed []EmbeddedFileData,
 
 		// validate the hash
 		if !bytes.Equal(hash, HashData(fileData)) {
-			return nil, read, false
+			invalid++
+			continue
 		}
 
 		filesEmbed = append(filesEmbed, EmbeddedFileData{ID: KeyHash{Hash: hash}, Data: fileData})
 	}
 
-	return filesEmbed, read, true
+	return filesEmbed, invalid, read, true
 }
 
 // EmbeddedFileSizeMax is the maximum size of embedded files in response messages. Any file exceeding that must be shared via regular file transfer.
 const EmbeddedFileSizeMax = udpMaxPacketSize - PacketLengthMin - announcementPayloadHeaderSize - 2 - 35
 
-// EncodeResponse encodes a response message
-// hash2Peers will be modified.
-func EncodeResponse(sendUA bool, hash2Peers []Hash2Peer, filesEmbed []EmbeddedFileData, hashesNotFound [][]byte, features byte, blockchainHeight, blockchainVersion uint64, userAgent string) (packetsRaw [][]byte, err error) {
-	for n := range filesEmbed {
-		if len(filesEmbed[n].Data) > EmbeddedFileSizeMax {
-			return nil, errors.New("embedded file too big")
-		}
-	}
-
-createPacketLoop:
-	for {
-		raw := make([]byte, 64*1024) // max UDP packet size
-		packetSize := announcementPayloadHeaderSize
-
-		raw[0] = byte(ProtocolVersion) // Protocol
-		raw[1] = features              // Feature support
-		//raw[2] = Actions                                   // Action bit array
-
-		binary.LittleEndian.PutUint64(raw[3:3+8], blockchainHeight)
-		binary.LittleEndian.PutUint64(raw[11:11+8], blockchainVersion)
+// EncodeResponse encodes a response message. It may return multiple messages if the input does not fit into one.
+func EncodeResponse(sendUA bool, hash2Peers []Hash2Peer, filesEmbed []EmbeddedFileData, hashesNotFound [][]byte, features, featuresExt byte, blockchainHeight, blockchainVersion uint64, userAgent string) (packetsRaw [][]byte, err error) {
+	builder := NewResponseBuilder(features, blockchainHeight, blockchainVersion)
+	builder.SetFeaturesExt(featuresExt)
 
-		// only on initial response the User Agent must be provided according to the protocol spec
-		if sendUA {
-			userAgentB := []byte(userAgent)
-			if len(userAgentB) > 255 {
-				userAgentB = userAgentB[:255]
-			}
-
-			raw[23] = byte(len(userAgentB))
-			copy(raw[announcementPayloadHeaderSize:announcementPayloadHeaderSize+len(userAgentB)], userAgentB)
-			packetSize += len(userAgentB)
-		}
-
-		// 3 count field at raw[index]: count of peer responses, embedded files, and hashes not found
-		countIndex := packetSize
-		packetSize += 6
-
-		// Encode the peer response data for FIND_SELF, FIND_PEER and FIND_VALUE requests.
-		if len(hash2Peers) > 0 {
-			for n, hash2Peer := range hash2Peers {
-				if isPacketSizeExceed(packetSize, 34+peerRecordSize) { // check if minimum length is available in packet
-					packetsRaw = append(packetsRaw, raw[:packetSize])
-					hash2Peers = hash2Peers[n:]
-					continue createPacketLoop
-				}
-
-				index := packetSize
-				copy(raw[index:index+32], hash2Peer.ID.Hash)
-				count2Index := index + 32
-
-				packetSize += 34
-				count2 := uint16(0)
-
-				for m := range hash2Peer.Storing {
-					if isPacketSizeExceed(packetSize, peerRecordSize) { // check if minimum length is available in packet
-						packetsRaw = append(packetsRaw, raw[:packetSize])
-						hash2Peers = hash2Peers[n:]
-						hash2Peer.Storing = hash2Peer.Storing[m:]
-						continue createPacketLoop
-					}
-
-					index := packetSize
-					encodePeerRecord(raw[index:index+peerRecordSize], &hash2Peer.Storing[m], 1)
-
-					packetSize += peerRecordSize
-					binary.LittleEndian.PutUint16(raw[count2Index+0:count2Index+2], uint16(m+1))
-					count2++
-				}
-
-				hash2Peer.Storing = nil
-
-				for m := range hash2Peer.Closest {
-					if isPacketSizeExceed(packetSize, peerRecordSize) { // check if minimum length is available in packet
-						packetsRaw = append(packetsRaw, raw[:packetSize])
-						hash2Peers = hash2Peers[n:]
-						hash2Peer.Closest = hash2Peer.Closest[m:]
-						continue createPacketLoop
-					}
-
-					index := packetSize
-					encodePeerRecord(raw[index:index+peerRecordSize], &hash2Peer.Closest[m], 0)
-
-					packetSize += peerRecordSize
-					count2++
-					binary.LittleEndian.PutUint16(raw[count2Index+0:count2Index+2], count2)
-				}
-
-				binary.LittleEndian.PutUint16(raw[count2Index+0:count2Index+2], count2|0x8000) // signal the last result for the key with bit 15
-				binary.LittleEndian.PutUint16(raw[countIndex+0:countIndex+0+2], uint16(n+1))   // count of peer responses
-			}
-
-			hash2Peers = nil
-		}
-
-		// FIND_VALUE response embedded data
-		if len(filesEmbed) > 0 {
-			if isPacketSizeExceed(packetSize, 34+len(filesEmbed[0].Data)) { // check if there is enough space for at least the header and 1 record
-				packetsRaw = append(packetsRaw, raw[:packetSize])
-				continue createPacketLoop
-			}
-
-			for n, file := range filesEmbed {
-				if isPacketSizeExceed(packetSize, 34+len(file.Data)) { // check if minimum length is available in packet
-					packetsRaw = append(packetsRaw, raw[:packetSize])
-					filesEmbed = filesEmbed[n:]
-					continue createPacketLoop
-				}
-
-				index := packetSize
-				copy(raw[index:index+32], file.ID.Hash)
-				binary.LittleEndian.PutUint16(raw[index+32:index+32+2], uint16(len(file.Data)))
-				copy(raw[index+34:index+34+len(file.Data)], file.Data)
-
-				binary.LittleEndian.PutUint16(raw[countIndex+2:countIndex+2+2], uint16(n+1)) // count of embedded files
-				packetSize += 34 + len(file.Data)
-			}
+	// only on initial response the User Agent must be provided according to the protocol spec
+	if sendUA {
+		builder.SetUserAgent(userAgent)
+	}
 
-			filesEmbed = nil
+	for n := range filesEmbed {
+		if err = builder.AddEmbeddedFile(filesEmbed[n].ID.Hash, filesEmbed[n].Data); err != nil {
+			return nil, err
 		}
+	}
 
-		// Hashes not found
-		if len(hashesNotFound) > 0 {
-			index := packetSize
+	builder.hash2Peers = hash2Peers
+	builder.hashesNotFound = hashesNotFound
 
-			for n, hash := range hashesNotFound {
-				if isPacketSizeExceed(packetSize, 32) { // check if there is enough space for at least the header and 1 record
-					packetsRaw = append(packetsRaw, raw[:packetSize])
-					continue createPacketLoop
-				}
+	return builder.Finalize()
+}
 
-				copy(raw[index+n*32:index+n*32+32], hash)
+// ResponseAppendInfoStore piggybacks INFO_STORE records to the last packet returned by EncodeResponse if space permits.
+// At most PiggybackInfoStoreMax records are appended. It returns the count of records appended.
+func ResponseAppendInfoStore(packetsRaw [][]byte, files []InfoStore) (count int) {
+	if len(packetsRaw) == 0 || len(files) == 0 {
+		return 0
+	}
 
-				binary.LittleEndian.PutUint16(raw[countIndex+4:countIndex+4+2], uint16(n+1)) // count of hashes not found
-				packetSize += 32
-			}
+	packet := packetsRaw[len(packetsRaw)-1]
+	if packet[2]&(1<<ActionInfoStorePiggyback|1<<ActionLatencyRecords|1<<ActionTimestamp) > 0 {
+		return 0
+	}
 
-			hashesNotFound = nil
-		}
+	record := encodeInfoStorePiggyback(len(packet), files)
+	if record == nil {
+		return 0
+	}
 
-		raw[2] |= 1 << ActionSequenceLast // Indicate that no more responses will be sent in this sequence
-		packetsRaw = append(packetsRaw, raw[:packetSize])
+	packet[2] |= 1 << ActionInfoStorePiggyback
+	packetsRaw[len(packetsRaw)-1] = append(packet, record...)
 
-		if len(hash2Peers) == 0 && len(filesEmbed) == 0 && len(hashesNotFound) == 0 { // this should always be the case here
-			return
-		}
-	}
+	return int(binary.LittleEndian.Uint16(record[0:2]))
 }
 
 // encodePeerRecord encodes a single peer record and stores it into raw
 func encodePeerRecord(raw []byte, peer *PeerRecord, reason uint8) {
-	copy(raw[0:0+33], peer.PublicKey.SerializeCompressed())
-	binary.LittleEndian.PutUint32(raw[65:65+4], peer.LastContact)
-	raw[69] = peer.Features | reason<<7
+	writer := serialize.NewBoundedWriter(raw)
+	writer.Bytes(peer.PublicKey.SerializeCompressed())
 
 	// IPv4
-	copy(raw[33:33+4], peer.IPv4.To4())
-	binary.LittleEndian.PutUint16(raw[37:37+2], peer.IPv4Port)
-	binary.LittleEndian.PutUint16(raw[39:39+2], peer.IPv4PortReportedInternal)
-	binary.LittleEndian.PutUint16(raw[41:41+2], peer.IPv4PortReportedExternal)
+	writer.Fixed(peer.IPv4.To4(), 4)
+	writer.Uint16(peer.IPv4Port)
+	writer.Uint16(peer.IPv4PortReportedInternal)
+	writer.Uint16(peer.IPv4PortReportedExternal)
 
 	// IPv6
-	copy(raw[43:43+16], peer.IPv6.To16())
-	binary.LittleEndian.PutUint16(raw[59:59+2], peer.IPv6Port)
-	binary.LittleEndian.PutUint16(raw[61:61+2], peer.IPv6PortReportedInternal)
-	binary.LittleEndian.PutUint16(raw[63:63+2], peer.IPv6PortReportedExternal)
+	writer.Fixed(peer.IPv6.To16(), 16)
+	writer.Uint16(peer.IPv6Port)
+	writer.Uint16(peer.IPv6PortReportedInternal)
+	writer.Uint16(peer.IPv6PortReportedExternal)
+
+	writer.Uint32(peer.LastContact)
+	writer.Uint8(peer.Features | reason<<7)
 }
 
 // IsLast checks if the incoming message is the last expected response in this sequence.
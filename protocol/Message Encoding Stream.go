@@ -0,0 +1,117 @@
+/*
+File Username:  Message Encoding Stream.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Stream message encoding:
+Offset  Size    Info
+0       1       Control
+1       16      Transfer ID. This will identify lite packets.
+
+Control = 0: Request Start
+17      1       Length of the service name
+18      ?       Service name, UTF-8 encoded
+
+Control = 2: Active
+17      ?       Embedded protocol data
+
+Streams connect to a named service registered by the remote peer (for example "chat/1"). The actual data should be sent via lite packets.
+*/
+
+package protocol
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// MessageStream is the decoded stream message.
+type MessageStream struct {
+	*MessageRaw           // Underlying raw message.
+	Control     uint8     // Control. See StreamControlX.
+	TransferID  uuid.UUID // Transfer ID to identify lite packets.
+	Service     string    // Name of the service. Only StreamControlRequestStart.
+	Data        []byte    // Embedded protocol data. Only StreamControlActive.
+}
+
+const (
+	StreamControlRequestStart = 0 // Request start of a stream to the service.
+	StreamControlNotAvailable = 1 // Service not available
+	StreamControlActive       = 2 // Active stream
+	StreamControlTerminate    = 3 // Terminate
+)
+
+// StreamServiceMaxLength is the max length of service names in bytes.
+const StreamServiceMaxLength = 64
+
+const streamPayloadHeaderSize = 17
+
+// DecodeStream decodes a stream message
+func DecodeStream(msg *MessageRaw) (result *MessageStream, err error) {
+	if len(msg.Payload) < streamPayloadHeaderSize {
+		return nil, errors.New("stream: invalid minimum length")
+	}
+
+	result = &MessageStream{
+		MessageRaw: msg,
+		Control:    msg.Payload[0],
+	}
+	copy(result.TransferID[:], msg.Payload[1:17])
+
+	switch result.Control {
+	case StreamControlRequestStart:
+		if len(msg.Payload) < streamPayloadHeaderSize+1 {
+			return nil, errors.New("stream: invalid minimum length")
+		}
+
+		serviceLength := int(msg.Payload[17])
+		if serviceLength == 0 || serviceLength > StreamServiceMaxLength || len(msg.Payload) < streamPayloadHeaderSize+1+serviceLength {
+			return nil, errors.New("stream: invalid service name")
+		}
+		result.Service = string(msg.Payload[18 : 18+serviceLength])
+
+	case StreamControlActive:
+		result.Data = msg.Payload[streamPayloadHeaderSize:]
+
+	}
+
+	return result, nil
+}
+
+// StreamMaxEmbedSize is the recommended upper size of embedded data inside the stream message. See TransferMaxEmbedSize.
+const StreamMaxEmbedSize = internetSafeMTU - PacketLengthMin - streamPayloadHeaderSize
+
+// EncodeStream encodes a stream message. The service name is only used for StreamControlRequestStart.
+func EncodeStream(data []byte, control uint8, transferID uuid.UUID, service string) (packetRaw []byte, err error) {
+	if control == StreamControlRequestStart && (len(service) == 0 || len(service) > StreamServiceMaxLength) {
+		return nil, errors.New("stream encode: invalid service name")
+	} else if control != StreamControlActive && len(data) != 0 {
+		return nil, errors.New("stream encode: payload only allowed when active")
+	} else if isPacketSizeExceed(streamPayloadHeaderSize, len(data)) {
+		return nil, errors.New("stream encode: embedded packet too big")
+	}
+
+	packetSize := streamPayloadHeaderSize + len(data)
+	if control == StreamControlRequestStart {
+		packetSize += 1 + len(service)
+	}
+
+	raw := make([]byte, packetSize)
+	raw[0] = control
+	copy(raw[1:17], transferID[:])
+
+	if control == StreamControlRequestStart {
+		raw[17] = byte(len(service))
+		copy(raw[18:], service)
+	} else {
+		copy(raw[17:], data)
+	}
+
+	return raw, nil
+}
+
+// IsLast checks if the incoming message is the last one in this stream.
+func (msg *MessageStream) IsLast() bool {
+	return msg.Control == StreamControlTerminate || msg.Control == StreamControlNotAvailable
+}
@@ -0,0 +1,52 @@
+/*
+File Username:  Message Encoding Timestamp.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The sender's clock is appended to response messages if the action bit ActionTimestamp is set. It is used to estimate the offset of the
+local clock to the network. It follows the regular response data, any piggybacked INFO_STORE records, and any latency records:
+Offset  Size    Info
+0       8       Time when the response was sent, Unix time in milliseconds
+
+Older clients ignore the additional data.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// timestampSize is the size of the encoded timestamp.
+const timestampSize = 8
+
+// ResponseAppendTimestamp appends the current time to the last packet returned by EncodeResponse if space permits. It must be called after
+// ResponseAppendInfoStore and ResponseAppendLatency.
+func ResponseAppendTimestamp(packetsRaw [][]byte, now time.Time) (appended bool) {
+	if len(packetsRaw) == 0 {
+		return false
+	}
+
+	packet := packetsRaw[len(packetsRaw)-1]
+	if packet[2]&(1<<ActionTimestamp) > 0 || isPacketSizeExceed(len(packet), timestampSize) {
+		return false
+	}
+
+	var raw [timestampSize]byte
+	binary.LittleEndian.PutUint64(raw[:], uint64(now.UnixMilli()))
+
+	packet[2] |= 1 << ActionTimestamp
+	packetsRaw[len(packetsRaw)-1] = append(packet, raw[:]...)
+
+	return true
+}
+
+// decodeTimestamp decodes the timestamp.
+func decodeTimestamp(data []byte) (timestamp time.Time, read int, valid bool) {
+	if len(data) < timestampSize {
+		return timestamp, 0, false
+	}
+
+	return time.UnixMilli(int64(binary.LittleEndian.Uint64(data[0:timestampSize]))), timestampSize, true
+}
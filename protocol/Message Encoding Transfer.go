@@ -95,8 +95,8 @@ func DecodeTransfer(msg *MessageRaw) (result *MessageTransfer, err error) {
 // The caller may send bigger payloads but may risk that data packets are simply dropped and never arrive. A MTU negotiation or detection could pimp that.
 const TransferMaxEmbedSize = internetSafeMTU - PacketLengthMin - transferPayloadHeaderSize
 
-// Same as TransferMaxEmbedSize but for encoding via lite packets.
-const TransferMaxEmbedSizeLite = internetSafeMTU - PacketLiteSizeMin
+// Same as TransferMaxEmbedSize but for encoding via lite packets. It fits both lite headers, with and without replay protection.
+const TransferMaxEmbedSizeLite = internetSafeMTU - PacketLiteSizeReplay
 
 // EncodeTransfer encodes a transfer message. The embedded packet size must be smaller than TransferMaxEmbedSize.
 func EncodeTransfer(senderPrivateKey *btcec.PrivateKey, data []byte, control, transferProtocol uint8, hash []byte, offset, limit uint64, transferID uuid.UUID) (packetRaw []byte, err error) {
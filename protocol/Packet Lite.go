@@ -10,6 +10,22 @@ Unsolicited lite packets are therefore impossible; the receiver must have the ID
 Offset  Size   Info
 0       16     ID
 16      2      Size of data to follow
+18      ?      Data
+
+Sessions with replay protection use an extended header. It is only used if both peers support it, which is indicated via the extended feature
+FeatureExtLiteReplay in the Announcement and Response. Sessions with peers that do not support it use the header above.
+
+Offset  Size   Info
+0       16     ID
+16      2      Size of data to follow
+18      4      Sequence number. Incremented for each packet sent in the session.
+22      4      Timestamp. Unix time in seconds when the packet was sent.
+26      ?      Data
+
+Replay protection: Each session tracks the highest received sequence number and a sliding window (bitmap) of the last
+LiteReplayWindowSize sequence numbers. Packets with a sequence number already received or older than the window are rejected.
+Packets with a timestamp older than LiteReplayTimeWindow compared to the newest timestamp received in the session are rejected as well.
+The timestamp is only compared against previous timestamps of the same remote peer, so clock differences between peers do not matter.
 
 */
 
@@ -19,6 +35,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,6 +51,15 @@ type PacketLiteRaw struct {
 // Minimum packet size of lite packets.
 const PacketLiteSizeMin = 16 + 2
 
+// Minimum packet size of lite packets with replay protection.
+const PacketLiteSizeReplay = PacketLiteSizeMin + 4 + 4
+
+// LiteReplayWindowSize is the count of sequence numbers tracked for replay protection.
+const LiteReplayWindowSize = 64
+
+// LiteReplayTimeWindow is the max age of a packet compared to the newest packet received in the same session.
+const LiteReplayTimeWindow = 60 // in seconds
+
 // IsPacketLite identifies a lite packet based on its ID. If the ID is not recognized, it fails.
 func (router *LiteRouter) IsPacketLite(raw []byte) (isLite bool, err error) {
 	if len(raw) < PacketLiteSizeMin {
@@ -65,28 +91,112 @@ func (router *LiteRouter) PacketLiteDecode(raw []byte) (packet *PacketLiteRaw, e
 
 	// TODO: Decrypt the data if indicated by the session.
 
+	headerSize := PacketLiteSizeMin
+	if session.Replay {
+		headerSize = PacketLiteSizeReplay
+	}
+	if len(raw) < headerSize {
+		return nil, errors.New("invalid packet size")
+	}
+
 	sizePayload := binary.LittleEndian.Uint16(raw[16 : 16+2])
-	if int(sizePayload) > len(raw)-PacketLiteSizeMin { // invalid size field?
+	if int(sizePayload) > len(raw)-headerSize { // invalid size field?
 		return nil, errors.New("invalid packet size field")
 	}
 
+	if session.Replay {
+		sequence := binary.LittleEndian.Uint32(raw[18 : 18+4])
+		timestamp := binary.LittleEndian.Uint32(raw[22 : 22+4])
+
+		if err = session.checkReplay(sequence, timestamp); err != nil {
+			if err == errLiteReplayTimestamp {
+				atomic.AddUint64(&router.Stats.RejectedTimestamp, 1)
+			} else {
+				atomic.AddUint64(&router.Stats.RejectedReplay, 1)
+			}
+			return nil, err
+		}
+	}
+
 	// Valid packet received, extend expiration.
 	session.expires = time.Now().Add(session.timeout)
 
-	return &PacketLiteRaw{Payload: raw[PacketLiteSizeMin:], ID: id, Session: session}, nil
+	return &PacketLiteRaw{Payload: raw[headerSize : headerSize+int(sizePayload)], ID: id, Session: session}, nil
 }
 
-// Encodes a lite packet.
-func PacketLiteEncode(id uuid.UUID, data []byte) (raw []byte, err error) {
-	raw = make([]byte, PacketLiteSizeMin+len(data))
+// PacketLiteEncode encodes a lite packet. The session must be known to the router. If the session uses replay protection, it assigns the next
+// outgoing sequence number of the session.
+func (router *LiteRouter) PacketLiteEncode(id uuid.UUID, data []byte) (raw []byte, err error) {
+	session := router.LookupLiteID(id)
+	if session == nil {
+		return nil, errors.New("packet ID not found")
+	} else if len(data) > 0xFFFF {
+		return nil, errors.New("data too big")
+	}
+
+	if !session.Replay {
+		raw = make([]byte, PacketLiteSizeMin+len(data))
+
+		copy(raw[0:16], id[:])
+		binary.LittleEndian.PutUint16(raw[16:16+2], uint16(len(data)))
+		copy(raw[PacketLiteSizeMin:], data)
+
+		return raw, nil
+	}
+
+	raw = make([]byte, PacketLiteSizeReplay+len(data))
 
 	copy(raw[0:16], id[:])
 	binary.LittleEndian.PutUint16(raw[16:16+2], uint16(len(data)))
-	copy(raw[PacketLiteSizeMin:], data)
+	binary.LittleEndian.PutUint32(raw[18:18+4], atomic.AddUint32(&session.sequenceOut, 1))
+	binary.LittleEndian.PutUint32(raw[22:22+4], uint32(time.Now().Unix()))
+	copy(raw[PacketLiteSizeReplay:], data)
 
 	return raw, nil
 }
 
+var errLiteReplaySequence = errors.New("replayed sequence number")
+var errLiteReplayTimestamp = errors.New("timestamp outside of replay window")
+
+// checkReplay checks the sequence number and timestamp of an incoming packet against the replay window of the session.
+// If valid, the packet is recorded as received.
+func (session *LiteID) checkReplay(sequence, timestamp uint32) (err error) {
+	session.replayMutex.Lock()
+	defer session.replayMutex.Unlock()
+
+	if session.timestampHighest > LiteReplayTimeWindow && timestamp < session.timestampHighest-LiteReplayTimeWindow {
+		return errLiteReplayTimestamp
+	}
+
+	switch {
+	case sequence > session.sequenceHighest: // New packet. Shift the window.
+		shift := sequence - session.sequenceHighest
+		if shift >= LiteReplayWindowSize {
+			session.replayBitmap = 0
+		} else {
+			session.replayBitmap <<= shift
+		}
+		session.replayBitmap |= 1
+		session.sequenceHighest = sequence
+
+	case session.sequenceHighest-sequence >= LiteReplayWindowSize: // Too old.
+		return errLiteReplaySequence
+
+	default: // Within the window.
+		bit := uint64(1) << (session.sequenceHighest - sequence)
+		if session.replayBitmap&bit != 0 {
+			return errLiteReplaySequence
+		}
+		session.replayBitmap |= bit
+	}
+
+	if timestamp > session.timestampHighest {
+		session.timestampHighest = timestamp
+	}
+
+	return nil
+}
+
 // ---- Lite packet ID management. This is similar to packet sequences. ----
 
 // LiteRouter keeps track of accepted (expected) packet IDs.
@@ -95,6 +205,14 @@ type LiteRouter struct {
 	ids map[uuid.UUID]*LiteID
 
 	sync.Mutex // synchronized access to the IDs
+
+	Stats LiteRouterStats // Statistics of rejected packets. Use atomic access.
+}
+
+// LiteRouterStats contains counters of lite packets rejected by the replay protection.
+type LiteRouterStats struct {
+	RejectedReplay    uint64 // Packets rejected because the sequence number was already received or is outside of the window.
+	RejectedTimestamp uint64 // Packets rejected because the timestamp is outside of the replay time window.
 }
 
 // LiteID contains session information for a bidirectional transfer of data
@@ -105,6 +223,14 @@ type LiteID struct {
 	Data           interface{}   // Optional high-level data associated with the ID
 	timeout        time.Duration // Timeout for receiving the next message
 	invalidateFunc func()        // Called on expiration.
+	Replay         bool          // Whether packets use the header with replay protection. Only if supported by both peers.
+
+	// Replay protection
+	sequenceOut      uint32     // Last outgoing sequence number.
+	sequenceHighest  uint32     // Highest received sequence number.
+	replayBitmap     uint64     // Bitmap of received sequence numbers. Bit 0 = sequenceHighest.
+	timestampHighest uint32     // Newest received timestamp.
+	replayMutex      sync.Mutex // Synchronized access to the replay window.
 }
 
 // Creates a new manager to keep track of accepted IDs.
@@ -146,8 +272,8 @@ func (router *LiteRouter) LookupLiteID(id uuid.UUID) (info *LiteID) {
 	return info
 }
 
-// Returns a new lite ID to be used.
-func (router *LiteRouter) NewLiteID(data interface{}, timeout time.Duration, invalidateFunc func()) (info *LiteID) {
+// Returns a new lite ID to be used. Replay indicates whether the remote peer supports replay protection.
+func (router *LiteRouter) NewLiteID(data interface{}, replay bool, timeout time.Duration, invalidateFunc func()) (info *LiteID) {
 	info = &LiteID{
 		created:        time.Now(),
 		expires:        time.Now().Add(timeout),
@@ -155,6 +281,7 @@ func (router *LiteRouter) NewLiteID(data interface{}, timeout time.Duration, inv
 		invalidateFunc: invalidateFunc,
 		Data:           data,
 		ID:             uuid.New(),
+		Replay:         replay,
 	}
 
 	router.Lock()
@@ -164,7 +291,7 @@ func (router *LiteRouter) NewLiteID(data interface{}, timeout time.Duration, inv
 	return
 }
 
-func (router *LiteRouter) RegisterLiteID(id uuid.UUID, data interface{}, timeout time.Duration, invalidateFunc func()) (info *LiteID) {
+func (router *LiteRouter) RegisterLiteID(id uuid.UUID, data interface{}, replay bool, timeout time.Duration, invalidateFunc func()) (info *LiteID) {
 	info = &LiteID{
 		ID:             id,
 		created:        time.Now(),
@@ -172,6 +299,7 @@ func (router *LiteRouter) RegisterLiteID(id uuid.UUID, data interface{}, timeout
 		timeout:        timeout,
 		invalidateFunc: invalidateFunc,
 		Data:           data,
+		Replay:         replay,
 	}
 
 	router.Lock()
@@ -14,7 +14,7 @@ Advantages:
 * This secures against replay and poisoning attacks.
 * If used correctly it can also deduplicate messages (which occurs when 2 peers have multiple registered connections to each other but none are active and subsequent fallback to broadcast).
 * The round-trip time can be measured and used to determine the connection quality.
-* (future) It can be used to detect missed and lost replies.
+* It can be used to detect missed and lost replies. Unidirectional sequences may have a custom timeout and a callback for expiry without any reply.
 
 */
 
@@ -43,15 +43,17 @@ type SequenceManager struct {
 
 // SequenceExpiry contains the decoded sequence information of a message.
 type SequenceExpiry struct {
-	SequenceNumber uint32      // Sequence number
-	created        time.Time   // When the sequence was created.
-	expires        time.Time   // When the sequence expires. This can be extended on the fly!
-	counter        int         // How many replies used the sequence. Multiple Response messages may be returned for a single Announcement one.
-	Data           interface{} // Optional high-level data associated with the sequence
+	SequenceNumber uint32        // Sequence number
+	created        time.Time     // When the sequence was created.
+	expires        time.Time     // When the sequence expires. This can be extended on the fly!
+	counter        int           // How many replies used the sequence. Multiple Response messages may be returned for a single Announcement one.
+	Data           interface{}   // Optional high-level data associated with the sequence
+	embeddedSize   int64         // Total size of embedded file data accepted via this sequence. Atomic access.
+	timeout        time.Duration // Timeout for receiving the next message. Unidirectional: Reply timeout, 0 = ReplyTimeout.
+	unansweredFunc func()        // Unidirectional only: Called if the sequence expires without any reply.
 	// bidirectional sequences only
-	bidirectional  bool          // Whether this sequence is used in a bidirectional way
-	timeout        time.Duration // Timeout for receiving the next message
-	invalidateFunc func()        // The invalidation callback is in case a sequence collision or expiration invalidates the sequence.
+	bidirectional  bool   // Whether this sequence is used in a bidirectional way
+	invalidateFunc func() // The invalidation callback is in case a sequence collision or expiration invalidates the sequence.
 }
 
 // NewSequenceManager creates a new sequence manager. The ReplyTimeout is in seconds. The expiration function is started immediately.
@@ -80,6 +82,9 @@ func (manager *SequenceManager) autoDeleteExpired() {
 				if sequence.invalidateFunc != nil {
 					go sequence.invalidateFunc()
 				}
+				if sequence.unansweredFunc != nil && sequence.counter == 0 {
+					go sequence.unansweredFunc()
+				}
 			}
 		}
 		manager.Unlock()
@@ -99,10 +104,22 @@ func sequence2Key(bidirectional bool, publicKey *btcec.PublicKey, sequenceNumber
 // NewSequence returns a new sequence and registers it. messageSequence must point to the variable holding the continuous next sequence number.
 // Use only for Announcement and Ping messages.
 func (manager *SequenceManager) NewSequence(publicKey *btcec.PublicKey, messageSequence *uint32, data interface{}) (info *SequenceExpiry) {
+	return manager.NewSequenceTimeout(publicKey, messageSequence, data, 0, nil)
+}
+
+// NewSequenceTimeout is the same as NewSequence but with a custom reply timeout. 0 = ReplyTimeout. Follow-up responses extend the validity by half the timeout.
+// The optional unansweredFunc is called if the sequence expires without any reply. It is not called if the sequence is invalidated.
+func (manager *SequenceManager) NewSequenceTimeout(publicKey *btcec.PublicKey, messageSequence *uint32, data interface{}, timeout time.Duration, unansweredFunc func()) (info *SequenceExpiry) {
+	if timeout <= 0 {
+		timeout = time.Duration(manager.ReplyTimeout) * time.Second
+	}
+
 	info = &SequenceExpiry{
 		SequenceNumber: atomic.AddUint32(messageSequence, 1),
 		created:        time.Now(),
-		expires:        time.Now().Add(time.Duration(manager.ReplyTimeout) * time.Second),
+		expires:        time.Now().Add(timeout),
+		timeout:        timeout,
+		unansweredFunc: unansweredFunc,
 		Data:           data,
 	}
 
@@ -159,7 +176,11 @@ func (manager *SequenceManager) ValidateSequence(publicKey *btcec.PublicKey, seq
 		delete(manager.sequences, key)
 	} else if extendValidity {
 		// Special case CommandResponse: Extend validity in case there are follow-up responses, by half of the round-trip time since they will be sent one-way.
-		sequence.expires = time.Now().Add(time.Duration(manager.ReplyTimeout) * time.Second / 2)
+		timeout := sequence.timeout
+		if timeout == 0 {
+			timeout = time.Duration(manager.ReplyTimeout) * time.Second
+		}
+		sequence.expires = time.Now().Add(timeout / 2)
 	}
 
 	return sequence, sequence.expires.After(time.Now()), rtt
@@ -260,3 +281,35 @@ func (manager *SequenceManager) ValidateSequenceBi(publicKey *btcec.PublicKey, s
 
 	return sequence, sequence.expires.After(time.Now()), rtt
 }
+
+// ---- tracing ----
+
+// ReserveEmbeddedSize reserves size bytes of embedded file data for the sequence. It returns false without reserving if the total would exceed max.
+func (info *SequenceExpiry) ReserveEmbeddedSize(size int, max int64) bool {
+	for {
+		current := atomic.LoadInt64(&info.embeddedSize)
+		if current+int64(size) > max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&info.embeddedSize, current, current+int64(size)) {
+			return true
+		}
+	}
+}
+
+// TraceID returns the trace ID of the message exchange identified by the sequence. Both peers derive the same ID from the public key of the peer that
+// created the sequence (initiator), the public key of the other peer (responder), and the sequence number. No trace information is sent.
+func TraceID(initiator, responder *btcec.PublicKey, sequenceNumber uint32, bidirectional bool) (traceID [16]byte) {
+	data := make([]byte, 0, 2*btcec.PubKeyBytesLenCompressed+5)
+	data = append(data, initiator.SerializeCompressed()...)
+	data = append(data, responder.SerializeCompressed()...)
+	data = append(data, byte(sequenceNumber>>24), byte(sequenceNumber>>16), byte(sequenceNumber>>8), byte(sequenceNumber))
+	if bidirectional {
+		data = append(data, 'b')
+	} else {
+		data = append(data, 'u')
+	}
+
+	copy(traceID[:], HashData(data))
+	return traceID
+}
@@ -0,0 +1,115 @@
+/*
+File Username:  Storage Proof.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Encoding of proof-of-storage challenges. The challenger sends a challenge, the storage provider answers with a response.
+
+Challenge:
+Offset  Size   Info
+0       32     Hash of the file
+32      32     Random nonce
+64      8      Offset of the byte range
+72      4      Length of the byte range
+
+Response:
+Offset  Size   Info
+0       1      Status: 0 = OK, 1 = File not found, 2 = Invalid range
+1       32     Proof: blake3 hash of the nonce followed by the requested byte range. Zero if status is not OK.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Storage proof response status
+const (
+	StorageProofOK           = 0 // The proof is included.
+	StorageProofNotFound     = 1 // The file is not stored.
+	StorageProofInvalidRange = 2 // The byte range is outside the file or exceeds the max length.
+)
+
+// StorageProofMaxLength is the max length of the byte range of a challenge.
+const StorageProofMaxLength = 64 * 1024
+
+// StorageChallenge is a proof-of-storage challenge.
+type StorageChallenge struct {
+	Hash   []byte // Hash of the file
+	Nonce  []byte // Random nonce, 32 bytes. It prevents precomputing proofs.
+	Offset uint64 // Offset of the byte range
+	Length uint32 // Length of the byte range
+}
+
+// StorageProofResponse is the response to a challenge.
+type StorageProofResponse struct {
+	Status uint8  // See StorageProofX constants.
+	Proof  []byte // Proof, see StorageProof.
+}
+
+// StorageProof calculates the proof for the nonce and byte range.
+func StorageProof(nonce, data []byte) []byte {
+	return HashData(append(append(make([]byte, 0, len(nonce)+len(data)), nonce...), data...))
+}
+
+// StorageChallengeWrite writes the challenge.
+func StorageChallengeWrite(writer io.Writer, challenge *StorageChallenge) (err error) {
+	if len(challenge.Hash) != HashSize || len(challenge.Nonce) != 32 {
+		return errors.New("invalid challenge")
+	}
+
+	var raw [76]byte
+	copy(raw[0:32], challenge.Hash)
+	copy(raw[32:64], challenge.Nonce)
+	binary.LittleEndian.PutUint64(raw[64:72], challenge.Offset)
+	binary.LittleEndian.PutUint32(raw[72:76], challenge.Length)
+
+	_, err = writer.Write(raw[:])
+	return err
+}
+
+// StorageChallengeRead reads the challenge.
+func StorageChallengeRead(reader io.Reader) (challenge *StorageChallenge, err error) {
+	var raw [76]byte
+	if _, err = io.ReadFull(reader, raw[:]); err != nil {
+		return nil, err
+	}
+
+	challenge = &StorageChallenge{Hash: raw[0:32], Nonce: raw[32:64]}
+	challenge.Offset = binary.LittleEndian.Uint64(raw[64:72])
+	challenge.Length = binary.LittleEndian.Uint32(raw[72:76])
+
+	return challenge, nil
+}
+
+// StorageProofWrite writes the response.
+func StorageProofWrite(writer io.Writer, response *StorageProofResponse) (err error) {
+	if response.Status == StorageProofOK && len(response.Proof) != HashSize {
+		return errors.New("invalid proof")
+	}
+
+	var raw [33]byte
+	raw[0] = response.Status
+	copy(raw[1:33], response.Proof)
+
+	_, err = writer.Write(raw[:])
+	return err
+}
+
+// StorageProofRead reads the response.
+func StorageProofRead(reader io.Reader) (response *StorageProofResponse, err error) {
+	var raw [33]byte
+	if _, err = io.ReadFull(reader, raw[:]); err != nil {
+		return nil, err
+	}
+
+	response = &StorageProofResponse{Status: raw[0]}
+	if response.Status == StorageProofOK {
+		response.Proof = raw[1:33]
+	}
+
+	return response, nil
+}
@@ -2,10 +2,15 @@
 package protocol
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/PeernetOfficial/core/btcec"
+	"github.com/google/uuid"
 )
 
 func TestMessageEncodingAnnouncement(t *testing.T) {
@@ -28,7 +33,7 @@ func TestMessageEncodingAnnouncement(t *testing.T) {
 	findPeer = append(findPeer, KeyHash{Hash: hash1})
 	findValue = append(findValue, KeyHash{Hash: hash2})
 
-	packets := EncodeAnnouncement(true, true, findPeer, findValue, files, 1<<FeatureIPv4Listen|1<<FeatureIPv6Listen, 0, 0, "Debug Test/1.0")
+	packets := EncodeAnnouncement(true, true, findPeer, findValue, files, 1<<FeatureIPv4Listen|1<<FeatureIPv6Listen, 1<<FeatureExtTrafficRelay, 0, 0, "Debug Test/1.0")
 
 	msg := &MessageRaw{PacketRaw: packetR, SenderPublicKey: publicKey}
 	msg.Payload = packets[0]
@@ -66,7 +71,7 @@ func TestMessageEncodingResponse(t *testing.T) {
 
 	hashesNotFound = append(hashesNotFound, HashData([]byte("NA")))
 
-	packetsRaw, err := EncodeResponse(true, hash2Peers, filesEmbed, hashesNotFound, 1<<FeatureIPv4Listen|1<<FeatureIPv6Listen, 0, 0, "Debug Test/1.0")
+	packetsRaw, err := EncodeResponse(true, hash2Peers, filesEmbed, hashesNotFound, 1<<FeatureIPv4Listen|1<<FeatureIPv6Listen, 1<<FeatureExtTrafficRelay, 0, 0, "Debug Test/1.0")
 	if err != nil {
 		fmt.Printf("Error msgEncodeAnnouncement: %s\n", err.Error())
 		return
@@ -83,3 +88,750 @@ func TestMessageEncodingResponse(t *testing.T) {
 
 	fmt.Printf("Decode:\nUser Agent: %s\nHash2Peers: %v\nHashesNotFound: %v\nFiles embedded: %v\n", result.UserAgent, result.Hash2Peers, result.HashesNotFound, result.FilesEmbed)
 }
+
+func TestPacketLiteReplay(t *testing.T) {
+	router := NewLiteRouter()
+	session := router.NewLiteID(nil, true, time.Minute, nil)
+
+	raw1, _ := router.PacketLiteEncode(session.ID, []byte("test1"))
+	raw2, _ := router.PacketLiteEncode(session.ID, []byte("test2"))
+
+	// out of order delivery is accepted, duplicates are rejected
+	if _, err := router.PacketLiteDecode(raw2); err != nil {
+		t.Fatalf("PacketLiteDecode packet 2: %s", err.Error())
+	}
+	if _, err := router.PacketLiteDecode(raw1); err != nil {
+		t.Fatalf("PacketLiteDecode packet 1: %s", err.Error())
+	}
+	if _, err := router.PacketLiteDecode(raw1); err == nil {
+		t.Fatal("PacketLiteDecode accepted replayed packet")
+	}
+	if router.Stats.RejectedReplay != 1 {
+		t.Fatalf("RejectedReplay counter is %d, expected 1", router.Stats.RejectedReplay)
+	}
+}
+
+func TestPacketLiteLegacy(t *testing.T) {
+	router := NewLiteRouter()
+	session := router.NewLiteID(nil, false, time.Minute, nil)
+
+	// Header of peers that do not support replay protection.
+	data := []byte("test")
+	legacy := append(append(append([]byte{}, session.ID[:]...), byte(len(data)), 0), data...)
+
+	packet, err := router.PacketLiteDecode(legacy)
+	if err != nil || !bytes.Equal(packet.Payload, data) {
+		t.Fatalf("PacketLiteDecode legacy packet: %v", err)
+	}
+
+	raw, _ := router.PacketLiteEncode(session.ID, data)
+	if !bytes.Equal(raw, legacy) {
+		t.Fatal("PacketLiteEncode did not use the legacy header")
+	}
+
+	// Packets are not checked for replays.
+	if _, err := router.PacketLiteDecode(legacy); err != nil {
+		t.Fatalf("PacketLiteDecode legacy packet: %s", err.Error())
+	}
+}
+
+func TestOnionLayers(t *testing.T) {
+	var hops []*btcec.PrivateKey
+	for n := 0; n < 3; n++ {
+		privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+		hops = append(hops, privateKey)
+	}
+	target := hops[2].PubKey()
+	embedded := []byte("embedded packet")
+
+	// build the layers from the inside out
+	data, err := EncodeOnionLayer(&OnionLayer{Action: OnionActionExit, BackwardKey: [32]byte{3}, Peer: target, IP: net.ParseIP("1.2.3.4"), Port: 112, Data: embedded}, hops[2].PubKey())
+	if err != nil {
+		t.Fatalf("EncodeOnionLayer exit: %s", err.Error())
+	}
+	for n := 1; n >= 0; n-- {
+		if data, err = EncodeOnionLayer(&OnionLayer{Action: OnionActionRelay, BackwardKey: [32]byte{byte(n + 1)}, CircuitID: uint64(n + 1), Peer: hops[n+1].PubKey(), IP: net.ParseIP("::1"), Port: 112, Data: data}, hops[n].PubKey()); err != nil {
+			t.Fatalf("EncodeOnionLayer relay: %s", err.Error())
+		}
+	}
+
+	// each hop peels one layer
+	var layer *OnionLayer
+	for n := 0; n < 3; n++ {
+		payload, err := EncodeOnion(OnionForward, uint64(n), data)
+		if err != nil || len(payload) < OnionPaddedSize {
+			t.Fatalf("EncodeOnion: %v, size %d", err, len(payload))
+		}
+		msg, err := DecodeOnion(&MessageRaw{PacketRaw: PacketRaw{Payload: payload}})
+		if err != nil || msg.CircuitID != uint64(n) {
+			t.Fatalf("DecodeOnion: %v", err)
+		}
+
+		if layer, err = DecodeOnionLayer(msg.Data, hops[n]); err != nil {
+			t.Fatalf("DecodeOnionLayer hop %d: %s", n, err.Error())
+		} else if layer.BackwardKey[0] != byte(n+1) {
+			t.Fatalf("hop %d: invalid backward key", n)
+		}
+		data = layer.Data
+	}
+
+	if layer.Action != OnionActionExit || !layer.Peer.IsEqual(target) || !layer.IP.Equal(net.ParseIP("1.2.3.4")) || !bytes.Equal(layer.Data, embedded) {
+		t.Fatal("invalid exit layer")
+	}
+
+	// backward: each hop wraps, the original sender unwraps in reverse order
+	data = embedded
+	for n := 2; n >= 0; n-- {
+		data, _ = OnionBackwardWrap(data, &[32]byte{byte(n + 1)})
+	}
+	for n := 0; n < 3; n++ {
+		data, _ = OnionBackwardUnwrap(data, &[32]byte{byte(n + 1)})
+	}
+	if !bytes.Equal(data, embedded) {
+		t.Fatal("backward data mismatch")
+	}
+}
+
+func TestContentSummary(t *testing.T) {
+	summary := NewContentSummary(100)
+
+	for n := 0; n < 100; n++ {
+		summary.Add(HashData([]byte{byte(n)}))
+	}
+
+	raw, err := EncodeContentSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeContentSummary(&MessageRaw{PacketRaw: PacketRaw{Payload: raw}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 0; n < 100; n++ {
+		if !decoded.Summary.Test(HashData([]byte{byte(n)})) {
+			t.Fatalf("hash %d not found in summary", n)
+		}
+	}
+
+	if NewContentSummary(0).Test(HashData([]byte{0})) {
+		t.Fatal("empty summary returned positive")
+	}
+}
+
+func TestHolePunchEncoding(t *testing.T) {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := EncodeHolePunch(HolePunchIntroduce, 42, privateKey.PubKey(), net.ParseIP("192.0.2.1"), 5000, 30*time.Millisecond, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeHolePunch(&MessageRaw{PacketRaw: PacketRaw{Payload: raw}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Action != HolePunchIntroduce || decoded.SessionID != 42 || !decoded.Peer.IsEqual(privateKey.PubKey()) || !decoded.IP.Equal(net.ParseIP("192.0.2.1")) ||
+		decoded.Port != 5000 || decoded.Delay != 30*time.Millisecond || !decoded.RelayOnly {
+		t.Fatalf("introduce mismatch: %+v", decoded)
+	}
+
+	raw, err = EncodeHolePunch(HolePunchRelay, 42, privateKey.PubKey(), nil, 0, 0, false, []byte("embedded packet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded, err = DecodeHolePunch(&MessageRaw{PacketRaw: PacketRaw{Payload: raw}}); err != nil {
+		t.Fatal(err)
+	} else if string(decoded.Embedded) != "embedded packet" {
+		t.Fatalf("embedded packet mismatch: %s", decoded.Embedded)
+	}
+
+	if _, err = EncodeHolePunch(HolePunchRelay, 42, privateKey.PubKey(), nil, 0, 0, false, make([]byte, HolePunchEmbedSizeMax+1)); err == nil {
+		t.Fatal("oversized embedded packet accepted")
+	}
+}
+
+func TestHolePunchReport(t *testing.T) {
+	privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+	report, err := EncodeHolePunchReport(privateKey, 42, net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := EncodeHolePunch(HolePunchRequest, 42, otherKey.PubKey(), nil, 0, 0, false, report)
+	decoded, err := DecodeHolePunch(&MessageRaw{PacketRaw: PacketRaw{Payload: raw}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip, valid := VerifyHolePunchReport(decoded.Embedded, 42, privateKey.PubKey()); !valid || !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatal("valid address report rejected")
+	}
+
+	// Reports of other sessions, signed by other peers, or with a modified IP are rejected.
+	if _, valid := VerifyHolePunchReport(report, 43, privateKey.PubKey()); valid {
+		t.Fatal("address report of another session accepted")
+	} else if _, valid := VerifyHolePunchReport(report, 42, otherKey.PubKey()); valid {
+		t.Fatal("address report of another peer accepted")
+	}
+
+	report[15] ^= 1
+	if _, valid := VerifyHolePunchReport(report, 42, privateKey.PubKey()); valid {
+		t.Fatal("modified address report accepted")
+	}
+}
+
+func TestGroupFrameSignature(t *testing.T) {
+	ownerKey, _ := btcec.NewPrivateKey(btcec.S256())
+	senderKey, _ := btcec.NewPrivateKey(btcec.S256())
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+	frame := &GroupFrame{Type: GroupFrameMessage, GroupID: uuid.New(), Owner: ownerKey.PubKey(), ID: uuid.New(), KeyID: 1, Date: time.Now(), Nonce: make([]byte, 24), Data: []byte("encrypted message")}
+	raw, err := EncodeGroupFrame(senderKey, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeGroupFrame(raw)
+	if err != nil {
+		t.Fatal(err)
+	} else if !decoded.Sender.IsEqual(senderKey.PubKey()) || !bytes.Equal(decoded.Data, frame.Data) {
+		t.Fatal("group frame mismatch")
+	}
+
+	// The hop count is not signed so that relays can update it.
+	relayed := append([]byte{}, raw...)
+	SetGroupFrameHops(relayed, 1)
+	if _, err := DecodeGroupFrame(relayed); err != nil {
+		t.Fatal("relayed group frame rejected")
+	}
+
+	// Any modification of the signed data is detected.
+	for _, offset := range []int{1, 17, 83, 100, groupFrameHeaderSize, len(raw) - signatureSize - 1} {
+		tampered := append([]byte{}, raw...)
+		tampered[offset] ^= 1
+		if _, err := DecodeGroupFrame(tampered); err == nil {
+			t.Fatalf("group frame modified at offset %d accepted", offset)
+		}
+	}
+
+	// Frames claiming another sender are rejected.
+	forged := append([]byte{}, raw...)
+	copy(forged[50:83], otherKey.PubKey().SerializeCompressed())
+	if _, err := DecodeGroupFrame(forged); err == nil {
+		t.Fatal("group frame with replaced sender accepted")
+	}
+
+	signature, _ := btcec.SignCompact(btcec.S256(), otherKey, HashData(raw[:len(raw)-signatureSize]), true)
+	forged = append(append([]byte{}, raw[:len(raw)-signatureSize]...), signature...)
+	if _, err := DecodeGroupFrame(forged); err == nil {
+		t.Fatal("group frame signed by another peer accepted")
+	}
+}
+
+func TestInfoStorePiggyback(t *testing.T) {
+	files := []InfoStore{
+		{ID: KeyHash{HashData([]byte("file1"))}, Size: 100},
+		{ID: KeyHash{HashData([]byte("file2"))}, Size: 200},
+		{ID: KeyHash{HashData([]byte("file3"))}, Size: 300},
+	}
+
+	packetsRaw, err := EncodeResponse(false, nil, nil, [][]byte{HashData([]byte("NA"))}, 0, 0, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := ResponseAppendInfoStore(packetsRaw, files); count != PiggybackInfoStoreMax {
+		t.Fatalf("appended %d records, expected %d", count, PiggybackInfoStoreMax)
+	}
+
+	response, err := DecodeResponse(&MessageRaw{PacketRaw: PacketRaw{Payload: packetsRaw[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.HashesNotFound) != 1 || len(response.InfoStoreFiles) != 2 || response.InfoStoreFiles[1].Size != 200 {
+		t.Fatalf("invalid piggybacked response: %v", response.InfoStoreFiles)
+	}
+
+	payload, count := EncodePong(files[2:])
+	if count != 1 {
+		t.Fatalf("pong contains %d records, expected 1", count)
+	}
+
+	pong, err := DecodePong(&MessageRaw{PacketRaw: PacketRaw{Payload: payload}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pong.InfoStoreFiles) != 1 || !bytes.Equal(pong.InfoStoreFiles[0].ID.Hash, files[2].ID.Hash) {
+		t.Fatal("invalid piggybacked pong")
+	}
+}
+
+func TestLatencyRecords(t *testing.T) {
+	var records []LatencyRecord
+	for n := 0; n < LatencyRecordsMax+2; n++ {
+		privateKey, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, LatencyRecord{PublicKey: privateKey.PubKey(), RTT: time.Duration(n*40) * time.Millisecond})
+	}
+
+	packetsRaw, err := EncodeResponse(false, nil, nil, nil, 0, 0, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []InfoStore{{ID: KeyHash{HashData([]byte("file1"))}, Size: 100}}
+	if count := ResponseAppendInfoStore(packetsRaw, files); count != 1 {
+		t.Fatalf("appended %d INFO_STORE records, expected 1", count)
+	}
+	if count := ResponseAppendLatency(packetsRaw, records); count != LatencyRecordsMax {
+		t.Fatalf("appended %d latency records, expected %d", count, LatencyRecordsMax)
+	}
+
+	response, err := DecodeResponse(&MessageRaw{PacketRaw: PacketRaw{Payload: packetsRaw[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.InfoStoreFiles) != 1 || len(response.LatencyRecords) != LatencyRecordsMax {
+		t.Fatalf("invalid response: %d INFO_STORE records, %d latency records", len(response.InfoStoreFiles), len(response.LatencyRecords))
+	}
+
+	for n, record := range response.LatencyRecords {
+		if !record.PublicKey.IsEqual(records[n].PublicKey) || record.RTT != records[n].RTT {
+			t.Fatalf("latency record %d mismatch", n)
+		}
+	}
+}
+
+func TestAnnouncementBuilder(t *testing.T) {
+	builder := NewAnnouncementBuilder(1<<FeatureIPv4Listen, 100, 2)
+	builder.SetUserAgent("Debug Test/1.0")
+	builder.SetFeaturesExt(1 << FeatureExtTrafficRelay)
+	builder.FindSelf()
+	builder.AddFindPeer(HashData([]byte("peer")))
+	builder.AddFindValue(HashData([]byte("value1")))
+	builder.AddFindValue(HashData([]byte("value2")))
+	builder.AddInfoStore(InfoStore{ID: KeyHash{HashData([]byte("file"))}, Size: 1234, Type: 1})
+
+	packets := builder.Finalize()
+	if len(packets) != 1 {
+		t.Fatalf("%d packets, expected 1", len(packets))
+	}
+
+	result, err := DecodeAnnouncement(&MessageRaw{PacketRaw: PacketRaw{Payload: packets[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.UserAgent != "Debug Test/1.0" || result.Features != 1<<FeatureIPv4Listen || result.FeaturesExt != 1<<FeatureExtTrafficRelay || result.Protocol != ProtocolVersion || result.BlockchainHeight != 100 || result.BlockchainVersion != 2 || result.Actions&(1<<ActionFindSelf) == 0 {
+		t.Fatalf("invalid header: %+v", result)
+	}
+	if len(result.FindPeerKeys) != 1 || !bytes.Equal(result.FindPeerKeys[0].Hash, HashData([]byte("peer"))) {
+		t.Fatal("invalid FIND_PEER")
+	}
+	if len(result.FindDataKeys) != 2 || !bytes.Equal(result.FindDataKeys[1].Hash, HashData([]byte("value2"))) {
+		t.Fatal("invalid FIND_VALUE")
+	}
+	if len(result.InfoStoreFiles) != 1 || result.InfoStoreFiles[0].Size != 1234 || result.InfoStoreFiles[0].Type != 1 || !bytes.Equal(result.InfoStoreFiles[0].ID.Hash, HashData([]byte("file"))) {
+		t.Fatal("invalid INFO_STORE")
+	}
+
+	// Empty announcement without User Agent
+	packets = NewAnnouncementBuilder(0, 0, 0).Finalize()
+	if len(packets) != 1 || len(packets[0]) != announcementPayloadHeaderSize {
+		t.Fatal("invalid empty announcement")
+	}
+}
+
+func TestAnnouncementBuilderSplit(t *testing.T) {
+	builder := NewAnnouncementBuilder(0, 0, 0)
+	builder.SetUserAgent("Debug Test/1.0")
+	builder.FindSelf()
+	builder.header.maxPayload = announcementPayloadHeaderSize + 14 + 2 + 3*keyRecordSize
+
+	var findPeer, findValue [][]byte
+	var files []InfoStore
+	for n := 0; n < 10; n++ {
+		findPeer = append(findPeer, HashData([]byte{'p', byte(n)}))
+		findValue = append(findValue, HashData([]byte{'v', byte(n)}))
+		files = append(files, InfoStore{ID: KeyHash{HashData([]byte{'f', byte(n)})}, Size: uint64(n)})
+
+		builder.AddFindPeer(findPeer[n])
+		builder.AddFindValue(findValue[n])
+		builder.AddInfoStore(files[n])
+	}
+
+	var decodedPeer, decodedValue []KeyHash
+	var decodedFiles []InfoStore
+
+	packets := builder.Finalize()
+	for _, packet := range packets {
+		if len(packet) > builder.header.maxPayload {
+			t.Fatalf("packet size %d exceeds max %d", len(packet), builder.header.maxPayload)
+		}
+
+		result, err := DecodeAnnouncement(&MessageRaw{PacketRaw: PacketRaw{Payload: packet}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.UserAgent != "Debug Test/1.0" || result.Actions&(1<<ActionFindSelf) == 0 {
+			t.Fatal("header not repeated")
+		}
+
+		decodedPeer = append(decodedPeer, result.FindPeerKeys...)
+		decodedValue = append(decodedValue, result.FindDataKeys...)
+		decodedFiles = append(decodedFiles, result.InfoStoreFiles...)
+	}
+
+	if len(decodedPeer) != 10 || len(decodedValue) != 10 || len(decodedFiles) != 10 {
+		t.Fatalf("decoded %d FIND_PEER, %d FIND_VALUE, %d INFO_STORE records, expected 10 each", len(decodedPeer), len(decodedValue), len(decodedFiles))
+	}
+	for n := 0; n < 10; n++ {
+		if !bytes.Equal(decodedPeer[n].Hash, findPeer[n]) || !bytes.Equal(decodedValue[n].Hash, findValue[n]) || !bytes.Equal(decodedFiles[n].ID.Hash, files[n].ID.Hash) || decodedFiles[n].Size != files[n].Size {
+			t.Fatalf("record %d mismatch", n)
+		}
+	}
+
+	// Finalize does not modify the builder
+	if again := builder.Finalize(); len(again) != len(packets) {
+		t.Fatal("second Finalize returned different result")
+	}
+}
+
+func testPeerRecord(t *testing.T, n int) PeerRecord {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return PeerRecord{
+		PublicKey:                privateKey.PubKey(),
+		IPv4:                     net.IPv4(10, 0, 0, byte(n)),
+		IPv4Port:                 uint16(1000 + n),
+		IPv4PortReportedInternal: 2,
+		IPv4PortReportedExternal: 3,
+		IPv6:                     net.ParseIP("2001:db8::1"),
+		IPv6Port:                 uint16(2000 + n),
+		LastContact:              uint32(n),
+		Features:                 1 << FeatureIPv6Listen,
+	}
+}
+
+func TestResponseBuilder(t *testing.T) {
+	hash1 := HashData([]byte("hash1"))
+	hash2 := HashData([]byte("hash2"))
+	peer1, peer2, peer3 := testPeerRecord(t, 1), testPeerRecord(t, 2), testPeerRecord(t, 3)
+	fileData := []byte("embedded")
+
+	builder := NewResponseBuilder(1<<FeatureIPv6Listen, 7, 8)
+	builder.SetUserAgent("Debug Test/1.0")
+	builder.AddPeerRecord(hash1, peer1, false)
+	builder.AddPeerRecord(hash2, peer2, true)
+	builder.AddPeerRecord(hash1, peer3, true)
+	builder.AddHash2Peer(Hash2Peer{ID: KeyHash{HashData([]byte("empty"))}})
+	builder.AddHashNotFound(HashData([]byte("NA")))
+	if err := builder.AddEmbeddedFile(HashData(fileData), fileData); err != nil {
+		t.Fatal(err)
+	}
+
+	packets, err := builder.Finalize()
+	if err != nil || len(packets) != 1 {
+		t.Fatalf("Finalize: %v, %d packets", err, len(packets))
+	}
+
+	result, err := DecodeResponse(&MessageRaw{PacketRaw: PacketRaw{Payload: packets[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.UserAgent != "Debug Test/1.0" || result.BlockchainHeight != 7 || result.BlockchainVersion != 8 || !result.IsLast() {
+		t.Fatalf("invalid header: %+v", result)
+	}
+	if len(result.Hash2Peers) != 3 || !result.Hash2Peers[0].IsLast || !result.Hash2Peers[1].IsLast || !result.Hash2Peers[2].IsLast {
+		t.Fatalf("invalid hash2peers: %+v", result.Hash2Peers)
+	}
+
+	first := result.Hash2Peers[0]
+	if !bytes.Equal(first.ID.Hash, hash1) || len(first.Storing) != 1 || len(first.Closest) != 1 || !first.Storing[0].PublicKey.IsEqual(peer3.PublicKey) || !first.Closest[0].PublicKey.IsEqual(peer1.PublicKey) {
+		t.Fatal("invalid records for hash 1")
+	}
+	if record := first.Closest[0]; !record.IPv4.Equal(peer1.IPv4) || record.IPv4Port != peer1.IPv4Port || record.IPv4PortReportedInternal != 2 || record.IPv4PortReportedExternal != 3 ||
+		!record.IPv6.Equal(peer1.IPv6) || record.IPv6Port != peer1.IPv6Port || record.LastContact != 1 || record.Features != peer1.Features || !bytes.Equal(record.NodeID, PublicKey2NodeID(peer1.PublicKey)) {
+		t.Fatalf("peer record mismatch: %+v", record)
+	}
+	if !bytes.Equal(result.Hash2Peers[1].ID.Hash, hash2) || len(result.Hash2Peers[1].Storing) != 1 || len(result.Hash2Peers[2].Storing)+len(result.Hash2Peers[2].Closest) != 0 {
+		t.Fatal("invalid records for hash 2")
+	}
+
+	if len(result.FilesEmbed) != 1 || !bytes.Equal(result.FilesEmbed[0].Data, fileData) {
+		t.Fatal("invalid embedded file")
+	}
+	if len(result.HashesNotFound) != 1 || !bytes.Equal(result.HashesNotFound[0], HashData([]byte("NA"))) {
+		t.Fatal("invalid hashes not found")
+	}
+
+	// Empty response
+	packets, err = NewResponseBuilder(0, 0, 0).Finalize()
+	if err != nil || len(packets) != 1 {
+		t.Fatal("invalid empty response")
+	}
+	if result, err = DecodeResponse(&MessageRaw{PacketRaw: PacketRaw{Payload: packets[0]}}); err != nil || !result.IsLast() {
+		t.Fatal("invalid empty response")
+	}
+
+	if err := builder.AddEmbeddedFile(HashData(nil), make([]byte, EmbeddedFileSizeMax+1)); err == nil {
+		t.Fatal("oversized embedded file accepted")
+	}
+}
+
+func TestResponseEmbeddedInvalid(t *testing.T) {
+	valid, invalid := []byte("valid"), []byte("invalid")
+
+	builder := NewResponseBuilder(0, 0, 0)
+	builder.AddEmbeddedFile(HashData(invalid), []byte("tampered"))
+	builder.AddEmbeddedFile(HashData(valid), valid)
+
+	packets, err := builder.Finalize()
+	if err != nil || len(packets) != 1 {
+		t.Fatalf("Finalize: %v, %d packets", err, len(packets))
+	}
+
+	result, err := DecodeResponse(&MessageRaw{PacketRaw: PacketRaw{Payload: packets[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.FilesEmbed) != 1 || !bytes.Equal(result.FilesEmbed[0].Data, valid) || result.FilesEmbedInvalid != 1 {
+		t.Fatalf("invalid embedded files: %d valid, %d invalid", len(result.FilesEmbed), result.FilesEmbedInvalid)
+	}
+}
+
+func TestResponseBuilderSplit(t *testing.T) {
+	builder := NewResponseBuilder(0, 0, 0)
+	builder.header.maxPayload = announcementPayloadHeaderSize + responseCountsSize + hashRecordSize + 3*peerRecordSize
+
+	// 3 hashes with 5 storing and 4 closest records each
+	var hashes [][]byte
+	var records []PeerRecord
+	for n := 0; n < 3; n++ {
+		hashes = append(hashes, HashData([]byte{'h', byte(n)}))
+		for m := 0; m < 9; m++ {
+			record := testPeerRecord(t, m)
+			records = append(records, record)
+			builder.AddPeerRecord(hashes[n], record, m < 5)
+		}
+	}
+
+	var files [][]byte
+	for n := 0; n < 4; n++ {
+		files = append(files, make([]byte, 100+n))
+		builder.AddEmbeddedFile(HashData(files[n]), files[n])
+	}
+
+	var notFound [][]byte
+	for n := 0; n < 20; n++ {
+		notFound = append(notFound, HashData([]byte{'n', byte(n)}))
+		builder.AddHashNotFound(notFound[n])
+	}
+
+	packets, err := builder.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decodedRecords []PeerRecord
+	var decodedFiles []EmbeddedFileData
+	var decodedNotFound [][]byte
+	hashIndex := 0
+
+	for n, packet := range packets {
+		if len(packet) > builder.header.maxPayload {
+			t.Fatalf("packet size %d exceeds max %d", len(packet), builder.header.maxPayload)
+		}
+
+		result, err := DecodeResponse(&MessageRaw{PacketRaw: PacketRaw{Payload: packet}})
+		if err != nil {
+			t.Fatalf("packet %d: %s", n, err.Error())
+		}
+		if result.IsLast() != (n == len(packets)-1) {
+			t.Fatalf("packet %d: invalid sequence last flag", n)
+		}
+
+		for _, hash2Peer := range result.Hash2Peers {
+			if !bytes.Equal(hash2Peer.ID.Hash, hashes[hashIndex]) {
+				t.Fatalf("packet %d: unexpected hash", n)
+			}
+			decodedRecords = append(decodedRecords, hash2Peer.Storing...)
+			decodedRecords = append(decodedRecords, hash2Peer.Closest...)
+
+			if hash2Peer.IsLast != (len(decodedRecords)%9 == 0) {
+				t.Fatalf("packet %d: invalid last flag for hash %d after %d records", n, hashIndex, len(decodedRecords))
+			}
+			if hash2Peer.IsLast {
+				hashIndex++
+			}
+		}
+
+		decodedFiles = append(decodedFiles, result.FilesEmbed...)
+		decodedNotFound = append(decodedNotFound, result.HashesNotFound...)
+	}
+
+	if len(decodedRecords) != len(records) || hashIndex != 3 {
+		t.Fatalf("decoded %d peer records, expected %d", len(decodedRecords), len(records))
+	}
+	for n := range records {
+		if !decodedRecords[n].PublicKey.IsEqual(records[n].PublicKey) {
+			t.Fatalf("peer record %d mismatch", n)
+		}
+	}
+
+	if len(decodedFiles) != len(files) || len(decodedNotFound) != len(notFound) {
+		t.Fatalf("decoded %d embedded files and %d hashes not found", len(decodedFiles), len(decodedNotFound))
+	}
+	for n := range files {
+		if !bytes.Equal(decodedFiles[n].Data, files[n]) {
+			t.Fatalf("embedded file %d mismatch", n)
+		}
+	}
+	for n := range notFound {
+		if !bytes.Equal(decodedNotFound[n], notFound[n]) {
+			t.Fatalf("hash not found %d mismatch", n)
+		}
+	}
+
+	// An embedded file that does not fit into an empty packet is rejected.
+	builder = NewResponseBuilder(0, 0, 0)
+	builder.header.maxPayload = announcementPayloadHeaderSize + responseCountsSize + hashRecordSize + 10
+	builder.AddEmbeddedFile(HashData(make([]byte, 11)), make([]byte, 11))
+	if _, err := builder.Finalize(); err == nil {
+		t.Fatal("embedded file exceeding the packet size accepted")
+	}
+}
+
+func TestBuilderUserAgentTruncate(t *testing.T) {
+	builder := NewAnnouncementBuilder(0, 0, 0)
+	builder.SetUserAgent("a" + strings.Repeat("€", 100)) // 1 + 300 bytes
+
+	result, err := DecodeAnnouncement(&MessageRaw{PacketRaw: PacketRaw{Payload: builder.Finalize()[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.UserAgent) != 253 {
+		t.Fatalf("user agent length %d, expected 253", len(result.UserAgent))
+	}
+}
+
+func TestBlake3Proof(t *testing.T) {
+	for _, size := range []int{0, 1, 1023, 1024, 1025, 2048, 3073, 5000, 64 * 1024, 1024*1024 + 1, 3*1024*1024 + 517} {
+		data := make([]byte, size)
+		for n := range data {
+			data[n] = byte(n * 7 % 251)
+		}
+		hash := HashData(data)
+
+		if !bytes.Equal(Blake3TreeHash(data), hash) {
+			t.Fatalf("tree hash mismatch for size %d", size)
+		}
+
+		count := blake3ChunkCount(uint64(size))
+		for _, chunk := range []uint64{0, count / 2, count - 1} {
+			chunkData, siblings, err := Blake3ProofCreate(bytes.NewReader(data), uint64(size), chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Blake3ProofVerify(hash, uint64(size), chunk, chunkData, siblings) {
+				t.Fatalf("valid proof rejected for size %d chunk %d", size, chunk)
+			}
+
+			if len(chunkData) > 0 {
+				chunkData[0] ^= 1
+				if Blake3ProofVerify(hash, uint64(size), chunk, chunkData, siblings) {
+					t.Fatalf("modified chunk accepted for size %d chunk %d", size, chunk)
+				}
+				chunkData[0] ^= 1
+			}
+			// The size is only bound by the length of the last chunk.
+			if chunk == count-1 && Blake3ProofVerify(hash, uint64(size)+1, chunk, chunkData, siblings) {
+				t.Fatalf("proof accepted for wrong size %d chunk %d", size, chunk)
+			}
+		}
+	}
+}
+
+func TestWireSpec(t *testing.T) {
+	sizes := []struct {
+		layout   interface{}
+		expected int
+	}{
+		{wirePacket{}, PacketLengthMin},
+		{wirePacketLite{}, PacketLiteSizeMin},
+		{wirePacketLiteReplay{}, PacketLiteSizeReplay},
+		{wireAnnouncement{}, announcementPayloadHeaderSize},
+		{wireResponse{}, announcementPayloadHeaderSize + responseCountsSize},
+		{wireTraverse{}, traversePayloadHeaderSize},
+		{wireGetBlockRequest{}, getBlockRequestHeaderSize},
+		{wireTransferRequest{}, transferPayloadHeaderSize + 32},
+		{wireTransferActive{}, transferPayloadHeaderSize},
+		{wireAdmin{}, adminPayloadHeaderSize},
+		{wireLookupRelay{}, lookupRelayPayloadHeaderSize},
+		{wireOnion{}, onionPayloadHeaderSize},
+		{wireOnionLayer{}, onionLayerHeaderSize},
+		{wireNATProbe{}, natProbePayloadSize},
+		{wireStreamActive{}, streamPayloadHeaderSize},
+		{wireHolePunch{}, holePunchPayloadHeaderSize},
+		{wirePeerRecord{}, peerRecordSize},
+		{wireInfoStore{}, 41},
+		{wireGroupFrameMessage{}, groupFrameHeaderSize + 24 + signatureSize},
+		{wireGroupFrameSenderKey{}, groupFrameHeaderSize + 33 + signatureSize},
+		{wireInfoStoreChallenge{}, 48},
+	}
+
+	for _, size := range sizes {
+		if format := WireLayout("", "", -1, "", size.layout); format.MinSize != size.expected {
+			t.Errorf("layout %T size %d, expected %d", size.layout, format.MinSize, size.expected)
+		}
+	}
+
+	format := WireLayout("", "", -1, "", wireResponse{})
+	if format.Fields[7].Offset != 23 || format.Fields[8].Offset != 24 || format.Fields[8].Size != 0 || format.Fields[9].Offset != -1 {
+		t.Error("variable offsets invalid")
+	}
+
+	if text := WireSpecText(WireSpec()); !strings.Contains(text, "0       4       Nonce") {
+		t.Error("text specification invalid")
+	}
+}
+
+func TestResponseTimestamp(t *testing.T) {
+	packetsRaw, err := EncodeResponse(false, nil, nil, nil, 0, 0, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if !ResponseAppendTimestamp(packetsRaw, now) {
+		t.Fatal("timestamp not appended")
+	}
+
+	// Latency records must not be appended after the timestamp.
+	if count := ResponseAppendLatency(packetsRaw, []LatencyRecord{{PublicKey: nil, RTT: time.Millisecond}}); count != 0 {
+		t.Fatalf("appended %d latency records after the timestamp", count)
+	}
+
+	response, err := DecodeResponse(&MessageRaw{PacketRaw: PacketRaw{Payload: packetsRaw[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Timestamp.UnixMilli() != now.UnixMilli() {
+		t.Fatalf("timestamp mismatch: %s, expected %s", response.Timestamp, now)
+	}
+}
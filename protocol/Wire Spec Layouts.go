@@ -0,0 +1,405 @@
+/*
+File Username:  Wire Spec Layouts.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Layouts of packets, messages, structures, and stream protocols for the wire format specification. See Wire Spec.go.
+When changing an encoding, the layout here must be updated as well.
+*/
+
+package protocol
+
+// ---- Packets ----
+
+type wirePacket struct {
+	Nonce     [4]byte  `wire:"Nonce. Also used for the Salsa20 encryption."`
+	Protocol  uint8    `wire:"Protocol version = 0"`
+	Command   uint8    `wire:"Command, see CommandX"`
+	Sequence  uint32   `wire:"Sequence"`
+	Size      uint16   `wire:"Size of payload data"`
+	Payload   []byte   `wire:"Payload according to the command" size:"Size of payload data"`
+	Garbage   []byte   `wire:"Randomized garbage" size:"Up to 20 bytes, the rest of the packet"`
+	Signature [65]byte `wire:"Signature, ECDSA secp256k1 512-bit + 1 header byte. Encrypted."`
+}
+
+type wirePacketLite struct {
+	ID   [16]byte `wire:"ID of the session"`
+	Size uint16   `wire:"Size of data to follow"`
+	Data []byte   `wire:"Data" size:"Size of data"`
+}
+
+type wirePacketLiteReplay struct {
+	ID        [16]byte `wire:"ID of the session"`
+	Size      uint16   `wire:"Size of data to follow"`
+	Sequence  uint32   `wire:"Sequence number. Incremented for each packet sent in the session."`
+	Timestamp uint32   `wire:"Timestamp. Unix time in seconds when the packet was sent."`
+	Data      []byte   `wire:"Data" size:"Size of data"`
+}
+
+// ---- Messages ----
+
+type wireAnnouncement struct {
+	Protocol          uint8  `wire:"Protocol version supported (low 4 bits). Extended feature bit array (high 4 bits), see FeatureExtX."`
+	Features          uint8  `wire:"Feature bit array, see FeatureX"`
+	Actions           uint8  `wire:"Action bit array, see ActionX"`
+	BlockchainHeight  uint64 `wire:"Blockchain height"`
+	BlockchainVersion uint64 `wire:"Blockchain version"`
+	PortInternal      uint16 `wire:"Internal port"`
+	PortExternal      uint16 `wire:"External port if known. 0 if not."`
+	UserAgentLength   uint8  `wire:"Length of the User Agent"`
+	UserAgent         string `wire:"User Agent, UTF-8 encoded. Format Software/Version." size:"Length of the User Agent"`
+	FindPeer          []byte `wire:"FIND_PEER: Key list, only if action bit 1 is set" size:"See Key List"`
+	FindValue         []byte `wire:"FIND_VALUE: Key list, only if action bit 2 is set" size:"See Key List"`
+	InfoStore         []byte `wire:"INFO_STORE: Record list, only if action bit 3 is set" size:"See INFO_STORE List"`
+}
+
+type wireResponse struct {
+	Protocol            uint8  `wire:"Protocol version supported (low 4 bits). Extended feature bit array (high 4 bits), see FeatureExtX."`
+	Features            uint8  `wire:"Feature bit array, see FeatureX"`
+	Actions             uint8  `wire:"Action bit array: 0 = Last response in the sequence, 1 = INFO_STORE piggyback, 2 = Latency records, 3 = Timestamp"`
+	BlockchainHeight    uint64 `wire:"Blockchain height"`
+	BlockchainVersion   uint64 `wire:"Blockchain version"`
+	PortInternal        uint16 `wire:"Internal port"`
+	PortExternal        uint16 `wire:"External port if known. 0 if not."`
+	UserAgentLength     uint8  `wire:"Length of the User Agent"`
+	UserAgent           string `wire:"User Agent, UTF-8 encoded. Format Software/Version." size:"Length of the User Agent"`
+	CountPeerResponses  uint16 `wire:"Count of peer responses"`
+	CountEmbeddedFiles  uint16 `wire:"Count of embedded files"`
+	CountHashesNotFound uint16 `wire:"Count of hashes not found"`
+	PeerResponses       []byte `wire:"Peer responses, see Peer Response" size:"Count of peer responses"`
+	EmbeddedFiles       []byte `wire:"Embedded files, see Embedded File" size:"Count of embedded files"`
+	HashesNotFound      []byte `wire:"Hashes not found" size:"Count of hashes not found * 32"`
+	InfoStore           []byte `wire:"Piggybacked INFO_STORE records, only if action bit 1 is set" size:"See INFO_STORE List"`
+	LatencyRecords      []byte `wire:"Latency records, only if action bit 2 is set" size:"See Latency Records"`
+	Timestamp           []byte `wire:"Time when the response was sent, Unix time in milliseconds" size:"8 if action bit 3 is set, otherwise 0"`
+}
+
+type wirePong struct {
+	Count   uint16 `wire:"Count of INFO_STORE records. The payload is only present if records are piggybacked."`
+	Records []byte `wire:"INFO_STORE records, see INFO_STORE Record" size:"Count * 41"`
+}
+
+type wireTraverse struct {
+	TargetPeer               [33]byte `wire:"Peer ID (compressed) of the target peer"`
+	AuthorizedRelayPeer      [33]byte `wire:"Peer ID (compressed) of the relay authorized to forward the message"`
+	Expires                  uint64   `wire:"Expiration, Unix time in seconds"`
+	SizePacketEmbed          uint16   `wire:"Size of the embedded packet"`
+	EmbeddedPacket           []byte   `wire:"Embedded packet, encrypted for the target" size:"Size of the embedded packet"`
+	Signature                [65]byte `wire:"Signature of the original sender over all previous bytes"`
+	IPv4                     [4]byte  `wire:"IPv4 address of the original sender. Set by the relay."`
+	PortIPv4                 uint16   `wire:"IPv4 port of the original sender. Set by the relay."`
+	PortIPv4ReportedExternal uint16   `wire:"External IPv4 port as reported by the original sender"`
+	IPv6                     [16]byte `wire:"IPv6 address of the original sender. Set by the relay."`
+	PortIPv6                 uint16   `wire:"IPv6 port of the original sender. Set by the relay."`
+	PortIPv6ReportedExternal uint16   `wire:"External IPv6 port as reported by the original sender"`
+}
+
+type wireGetBlockRequest struct {
+	Control          uint8    `wire:"Control = 0: Request blocks"`
+	PeerID           [33]byte `wire:"Peer ID (compressed) identifying which blockchain to transfer"`
+	LimitBlockCount  uint64   `wire:"Limit total count of blocks to transfer"`
+	MaxBlockSize     uint64   `wire:"Limit of bytes per block. Blocks exceeding it are not transferred."`
+	TransferID       [16]byte `wire:"Transfer ID identifying lite packets"`
+	CountBlockRanges uint16   `wire:"Count of block ranges"`
+	BlockRanges      []byte   `wire:"Block ranges, see Block Range" size:"Count of block ranges * 16"`
+}
+
+type wireGetBlockActive struct {
+	Control   uint8    `wire:"Control = 2: Active. Other controls: 1 = Not available, 3 = Terminate, 4 = Empty blockchain (no data follows)."`
+	PeerID    [33]byte `wire:"Peer ID (compressed) identifying the blockchain"`
+	BlockData []byte   `wire:"Embedded block stream data, see Block Stream Header" size:"Remaining payload"`
+}
+
+type wireTransferRequest struct {
+	Control          uint8    `wire:"Control = 0: Request start"`
+	TransferProtocol uint8    `wire:"Transfer protocol: 0 = UDT via lite packets"`
+	Hash             [32]byte `wire:"Hash of the file"`
+	Offset           uint64   `wire:"Offset to start reading in the file"`
+	Limit            uint64   `wire:"Limit of bytes to read at the offset. 0 = entire file."`
+	TransferID       [16]byte `wire:"Transfer ID identifying lite packets"`
+}
+
+type wireTransferActive struct {
+	Control          uint8    `wire:"Control = 2: Active. Other controls: 1 = Not available, 3 = Terminate (no data follows)."`
+	TransferProtocol uint8    `wire:"Transfer protocol: 0 = UDT via lite packets"`
+	Hash             [32]byte `wire:"Hash of the file"`
+	Data             []byte   `wire:"Embedded protocol data. Usually sent via lite packets instead." size:"Remaining payload"`
+}
+
+type wireChat struct {
+	Text string `wire:"Text, UTF-8 encoded. Debug only." size:"Entire payload"`
+}
+
+type wireAdmin struct {
+	Control   uint8  `wire:"Control: 0 = Request, 1 = Response"`
+	Action    uint8  `wire:"Action, see AdminActionX"`
+	Status    uint8  `wire:"Status, only used in responses: 0 = OK, 1 = Unauthorized, 2 = Invalid"`
+	Timestamp uint64 `wire:"Unix time in seconds when the message was created. Used to reject replayed messages."`
+	Nonce     uint64 `wire:"Random value to reject replayed messages within the accepted time drift"`
+	Data      []byte `wire:"Data according to the action" size:"Remaining payload"`
+}
+
+type wireLookupRelay struct {
+	Action         uint8    `wire:"Action: 0 = Forward, 1 = Response"`
+	PeerID         [33]byte `wire:"Peer ID (compressed) of the target. For responses, the peer that sent the embedded packet."`
+	IP             [16]byte `wire:"IP address of the target"`
+	Port           uint16   `wire:"Port of the target"`
+	EmbeddedPacket []byte   `wire:"Embedded packet, encrypted and signed by the original sender" size:"Remaining payload"`
+}
+
+type wireOnion struct {
+	Direction uint8  `wire:"Direction: 0 = Forward, 1 = Backward"`
+	CircuitID uint64 `wire:"Circuit ID. It is different for each link between 2 hops."`
+	Size      uint16 `wire:"Size of data"`
+	Data      []byte `wire:"Forward: Onion layer encrypted for the receiver. Backward: Data encrypted by each hop." size:"Size of data"`
+	Padding   []byte `wire:"Random padding" size:"Remaining payload"`
+}
+
+type wireContentSummary struct {
+	HashFunctions uint8  `wire:"Count of hash functions"`
+	Filter        []byte `wire:"Bloom filter of stored hashes. Empty if no data is stored." size:"Remaining payload, up to 1024 bytes"`
+}
+
+type wireNATProbe struct {
+	Action    uint8    `wire:"Action: 0 = Request, 1 = Reply, 2 = Request forward, 3 = Forwarded"`
+	ProbeID   uint64   `wire:"Probe ID"`
+	IP        [16]byte `wire:"Reply: IP address of the requester as seen by the sender. Forwarded: Address to send the reply to."`
+	Port      uint16   `wire:"Port, same as IP"`
+	Requester [33]byte `wire:"Forwarded only: Peer ID (compressed) of the requester"`
+}
+
+type wireHolePunch struct {
+	Action    uint8    `wire:"Action: 0 = Request, 1 = Introduce, 2 = Punch, 3 = Punch acknowledge, 4 = Relay, 5 = Relayed, 6 = Report"`
+	SessionID uint64   `wire:"Session ID set by the initiator"`
+	Peer      [33]byte `wire:"Peer ID (compressed). Request, Relay and Report to the relay: Target peer. Introduce, Relayed and Report from the relay: The other peer of the session."`
+	IP        [16]byte `wire:"Introduce only: IP address of the other peer as observed by the relay"`
+	Port      uint16   `wire:"Introduce only: Port, same as IP"`
+	Delay     uint16   `wire:"Introduce only: Delay in milliseconds before punching"`
+	Flags     uint8    `wire:"Introduce only: Bit 0 = The relay forwards the traffic if punching fails"`
+	Embedded  []byte   `wire:"Relay and Relayed: Embedded packet. Request, Introduce and Report: Address report (IP address 16 bytes, signature 65 bytes), optional." size:"Remaining payload"`
+}
+
+type wireStreamRequest struct {
+	Control       uint8    `wire:"Control = 0: Request start"`
+	TransferID    [16]byte `wire:"Transfer ID identifying lite packets"`
+	ServiceLength uint8    `wire:"Length of the service name"`
+	Service       string   `wire:"Service name, UTF-8 encoded" size:"Length of the service name"`
+}
+
+type wireStreamActive struct {
+	Control    uint8    `wire:"Control = 2: Active. Other controls: 1 = Not available, 3 = Terminate (no data follows)."`
+	TransferID [16]byte `wire:"Transfer ID identifying lite packets"`
+	Data       []byte   `wire:"Embedded protocol data. Usually sent via lite packets instead." size:"Remaining payload"`
+}
+
+// ---- Structures ----
+
+type wireKeyList struct {
+	Count uint16 `wire:"Count of keys"`
+	Keys  []byte `wire:"Blake3 hashes" size:"Count of keys * 32"`
+}
+
+type wireInfoStoreList struct {
+	Count   uint16 `wire:"Count of records"`
+	Records []byte `wire:"Records, see INFO_STORE Record" size:"Count of records * 41"`
+}
+
+type wireInfoStore struct {
+	Hash [32]byte `wire:"Hash of the file"`
+	Size uint64   `wire:"Size of the file"`
+	Type uint8    `wire:"Type: 0 = File, 1 = Header file containing list of parts, 2 = Keyword posting (hash is the keyword key, size is the count of matching files)"`
+}
+
+type wirePeerResponse struct {
+	Hash    [32]byte `wire:"Hash that was queried"`
+	Count   uint16   `wire:"Count of peer records (low 15 bits). The top bit indicates the last response for the hash."`
+	Records []byte   `wire:"Peer records, see Peer Record" size:"Count of peer records * 70"`
+}
+
+type wirePeerRecord struct {
+	PeerID                   [33]byte `wire:"Peer ID (compressed)"`
+	IPv4                     [4]byte  `wire:"IPv4 address. 0 if not set."`
+	IPv4Port                 uint16   `wire:"IPv4 port"`
+	IPv4PortReportedInternal uint16   `wire:"Internal IPv4 port as reported by the peer"`
+	IPv4PortReportedExternal uint16   `wire:"External IPv4 port as reported by the peer"`
+	IPv6                     [16]byte `wire:"IPv6 address. 0 if not set."`
+	IPv6Port                 uint16   `wire:"IPv6 port"`
+	IPv6PortReportedInternal uint16   `wire:"Internal IPv6 port as reported by the peer"`
+	IPv6PortReportedExternal uint16   `wire:"External IPv6 port as reported by the peer"`
+	LastContact              uint32   `wire:"Last contact in seconds"`
+	Features                 uint8    `wire:"Features (low 7 bits). The top bit is the reason: 0 = Close to the hash, 1 = Stores the data."`
+}
+
+type wireEmbeddedFile struct {
+	Hash [32]byte `wire:"Hash of the file"`
+	Size uint16   `wire:"Size of the data"`
+	Data []byte   `wire:"Data" size:"Size of the data"`
+}
+
+type wireLatencyRecords struct {
+	Count   uint8  `wire:"Count of latency records"`
+	Records []byte `wire:"Latency records: 33 bytes peer ID compressed, 2 bytes RTT in milliseconds" size:"Count of latency records * 35"`
+}
+
+type wireBlockRange struct {
+	Number uint64 `wire:"Block number"`
+	Count  uint64 `wire:"Count of blocks"`
+}
+
+type wireBlockStreamHeader struct {
+	Availability uint8  `wire:"Availability: 0 = Available, 1 = Not available, 2 = Exceeds size limit"`
+	Number       uint64 `wire:"Block number"`
+	Count        uint64 `wire:"Count of blocks. Must be 1 if a block is returned."`
+	Size         uint64 `wire:"Block size"`
+}
+
+type wireOnionLayer struct {
+	Action      uint8    `wire:"Action: 0 = Relay to next hop, 1 = Exit, 2 = Deliver"`
+	BackwardKey [32]byte `wire:"Backward key used for packets sent back through the circuit"`
+	CircuitID   uint64   `wire:"Relay: Circuit ID for the link to the next hop"`
+	PeerID      [33]byte `wire:"Relay: Peer ID of the next hop. Exit: Peer ID of the target."`
+	IP          [16]byte `wire:"Relay/Exit: IP address of the next hop or target"`
+	Port        uint16   `wire:"Relay/Exit: Port of the next hop or target"`
+	Data        []byte   `wire:"Relay: Onion layer for the next hop. Exit/Deliver: Embedded packet." size:"Remaining data"`
+}
+
+// ---- Streams ----
+
+type wireFileTransferHeader struct {
+	FileSize     uint64 `wire:"Total file size"`
+	TransferSize uint64 `wire:"Transfer size"`
+}
+
+type wireGroupFrameMessage struct {
+	Type      uint8    `wire:"Frame type = 0: Message"`
+	GroupID   [16]byte `wire:"Group ID"`
+	Owner     [33]byte `wire:"Owner public key (compressed)"`
+	Sender    [33]byte `wire:"Sender public key (compressed)"`
+	FrameID   [16]byte `wire:"Frame ID, random. Used for deduplication when relaying."`
+	Hops      uint8    `wire:"Hop count, incremented by relays. Not covered by the signature."`
+	KeyID     uint32   `wire:"Key ID of the sender key"`
+	Date      uint64   `wire:"Date created, Unix time in milliseconds"`
+	Nonce     [24]byte `wire:"Nonce"`
+	Message   []byte   `wire:"Message encrypted via XChaCha20-Poly1305 using the sender key" size:"Frame size - 201"`
+	Signature [65]byte `wire:"Signature of the sender over all previous bytes with hop count set to 0"`
+}
+
+type wireGroupFrameSenderKey struct {
+	Type      uint8    `wire:"Frame type = 1: Sender key"`
+	GroupID   [16]byte `wire:"Group ID"`
+	Owner     [33]byte `wire:"Owner public key (compressed)"`
+	Sender    [33]byte `wire:"Sender public key (compressed)"`
+	FrameID   [16]byte `wire:"Frame ID, random. Used for deduplication when relaying."`
+	Hops      uint8    `wire:"Hop count, incremented by relays. Not covered by the signature."`
+	KeyID     uint32   `wire:"Key ID of the sender key"`
+	Date      uint64   `wire:"Date created, Unix time in milliseconds"`
+	Recipient [33]byte `wire:"Recipient public key (compressed)"`
+	SenderKey []byte   `wire:"Sender key encrypted to the recipient (ECIES)" size:"Frame size - 210"`
+	Signature [65]byte `wire:"Signature of the sender over all previous bytes with hop count set to 0"`
+}
+
+type wireSyncMessage struct {
+	Size         uint32 `wire:"Size of the message excluding this field"`
+	Type         uint8  `wire:"Type: 0 = Request, 1 = Response, 2 = Folder not available, 3 = Busy"`
+	FolderLength uint8  `wire:"Length of the folder name"`
+	Folder       string `wire:"Folder name" size:"Length of the folder name"`
+	CountFiles   uint32 `wire:"Count of files"`
+	Files        []byte `wire:"Files, see Sync File. Only for request and response." size:"Count of files"`
+}
+
+type wireSyncFile struct {
+	Hash       [32]byte `wire:"Hash of the file. Zero if the file was deleted."`
+	HashSynced [32]byte `wire:"Hash of the file at the last sync with the receiver. Zero if not known."`
+	Size       uint64   `wire:"Size of the file"`
+	Modified   uint64   `wire:"Modification time, Unix time in nanoseconds"`
+	PathLength uint16   `wire:"Length of the path"`
+	Path       string   `wire:"Path relative to the folder, forward slashes as separator" size:"Length of the path"`
+}
+
+type wireStorageChallenge struct {
+	Hash   [32]byte `wire:"Hash of the file"`
+	Nonce  [32]byte `wire:"Random nonce"`
+	Offset uint64   `wire:"Offset of the byte range"`
+	Length uint32   `wire:"Length of the byte range"`
+}
+
+type wireStorageProof struct {
+	Status uint8    `wire:"Status: 0 = OK, 1 = File not found, 2 = Invalid range"`
+	Proof  [32]byte `wire:"Blake3 hash of the nonce followed by the requested byte range. Zero if status is not OK."`
+}
+
+type wireInfoStoreChallenge struct {
+	Hash  [32]byte `wire:"Hash of the data"`
+	Size  uint64   `wire:"Size of the data as announced"`
+	Chunk uint64   `wire:"Index of the blake3 chunk (1 KB)"`
+}
+
+type wireInfoStoreProof struct {
+	Status        uint8  `wire:"Status: 0 = OK, 1 = Not found, 2 = Invalid chunk, 3 = Busy"`
+	DataLength    uint16 `wire:"Length of the chunk data"`
+	CountSiblings uint8  `wire:"Count of siblings"`
+	Data          []byte `wire:"Chunk data" size:"Length of the chunk data"`
+	Siblings      []byte `wire:"Sibling chaining values from the root down to the chunk" size:"Count of siblings * 32"`
+}
+
+type wireDelegatedPublishRequest struct {
+	CountRecords uint16 `wire:"Count of records"`
+	Records      []byte `wire:"Delegated records, each prefixed by its size (4 bytes)" size:"Count of records"`
+}
+
+type wireDelegatedPublishResponse struct {
+	Status  uint8  `wire:"Status, see blockchain.StatusX"`
+	Height  uint64 `wire:"Height of the blockchain after appending"`
+	Version uint64 `wire:"Version of the blockchain after appending"`
+}
+
+func init() {
+	RegisterWireFormat(
+		WireLayout("Packet", WireCategoryPacket, -1, "Basic structure of all packets. Everything except the nonce is encrypted via Salsa20 using the receiver's public key.", wirePacket{}),
+		WireLayout("Lite Packet", WireCategoryPacket, -1, "Header of lite packets used for data transfers. They are neither signed nor encrypted.", wirePacketLite{}),
+		WireLayout("Lite Packet (Replay Protection)", WireCategoryPacket, -1, "Header of lite packets if both peers support FeatureExtLiteReplay.", wirePacketLiteReplay{}),
+
+		WireLayout("Announcement", WireCategoryMessage, CommandAnnouncement, "Initial message to a peer and DHT requests.", wireAnnouncement{}),
+		WireLayout("Local Discovery", WireCategoryMessage, CommandLocalDiscovery, "Sent via IPv4 broadcast and IPv6 multicast. Same encoding as Announcement.", wireAnnouncement{}),
+		WireLayout("Response", WireCategoryMessage, CommandResponse, "Response to an Announcement.", wireResponse{}),
+		WireLayout("Ping", WireCategoryMessage, CommandPing, "Keep-alive message.", struct{}{}),
+		WireLayout("Pong", WireCategoryMessage, CommandPong, "Response to a Ping.", wirePong{}),
+		WireLayout("Traverse", WireCategoryMessage, CommandTraverse, "Sent via a relay to help establish a connection between 2 peers.", wireTraverse{}),
+		WireLayout("Get Block Request", WireCategoryMessage, CommandGetBlock, "Request blocks of a blockchain.", wireGetBlockRequest{}),
+		WireLayout("Get Block Active", WireCategoryMessage, CommandGetBlock, "Active block transfer.", wireGetBlockActive{}),
+		WireLayout("Transfer Request", WireCategoryMessage, CommandTransfer, "Request a file transfer.", wireTransferRequest{}),
+		WireLayout("Transfer Active", WireCategoryMessage, CommandTransfer, "Active file transfer.", wireTransferActive{}),
+		WireLayout("Chat", WireCategoryMessage, CommandChat, "Chat message for debugging.", wireChat{}),
+		WireLayout("Admin", WireCategoryMessage, CommandAdmin, "Remote administration of own nodes.", wireAdmin{}),
+		WireLayout("Lookup Relay", WireCategoryMessage, CommandLookupRelay, "Relay DHT lookups of ephemeral identities.", wireLookupRelay{}),
+		WireLayout("Onion", WireCategoryMessage, CommandOnion, "Onion routing via multiple hops.", wireOnion{}),
+		WireLayout("Content Summary", WireCategoryMessage, CommandContentSummary, "Bloom filter of stored hashes.", wireContentSummary{}),
+		WireLayout("NAT Probe", WireCategoryMessage, CommandNATProbe, "Active NAT behavior tests.", wireNATProbe{}),
+		WireLayout("Stream Request", WireCategoryMessage, CommandStream, "Request a stream to a named service.", wireStreamRequest{}),
+		WireLayout("Stream Active", WireCategoryMessage, CommandStream, "Active stream.", wireStreamActive{}),
+		WireLayout("Hole Punch", WireCategoryMessage, CommandHolePunch, "Coordinated hole punching via a relay.", wireHolePunch{}),
+
+		WireLayout("Key List", WireCategoryStructure, -1, "List of keys for FIND_PEER and FIND_VALUE.", wireKeyList{}),
+		WireLayout("INFO_STORE List", WireCategoryStructure, -1, "List of INFO_STORE records.", wireInfoStoreList{}),
+		WireLayout("INFO_STORE Record", WireCategoryStructure, -1, "Informs that the sender stores the file.", wireInfoStore{}),
+		WireLayout("Peer Response", WireCategoryStructure, -1, "Peers close to or storing the queried hash.", wirePeerResponse{}),
+		WireLayout("Peer Record", WireCategoryStructure, -1, "Information about a peer.", wirePeerRecord{}),
+		WireLayout("Embedded File", WireCategoryStructure, -1, "Data of a small file embedded in a response.", wireEmbeddedFile{}),
+		WireLayout("Latency Records", WireCategoryStructure, -1, "RTTs measured by the sender to other peers.", wireLatencyRecords{}),
+		WireLayout("Block Range", WireCategoryStructure, -1, "Range of blocks requested via Get Block.", wireBlockRange{}),
+		WireLayout("Block Stream Header", WireCategoryStructure, -1, "Header preceding each block in the block stream.", wireBlockStreamHeader{}),
+		WireLayout("Onion Layer", WireCategoryStructure, -1, "Decrypted onion layer. Encrypted via ECIES for the public key of the hop.", wireOnionLayer{}),
+
+		WireLayout("File Transfer Header", WireCategoryStream, -1, "Header at the start of each file transfer.", wireFileTransferHeader{}),
+		WireLayout("Group Frame Message", WireCategoryStream, -1, "Group channel message, via stream service group/1. Prefixed by its size (4 bytes).", wireGroupFrameMessage{}),
+		WireLayout("Group Frame Sender Key", WireCategoryStream, -1, "Group channel sender key, via stream service group/1. Prefixed by its size (4 bytes).", wireGroupFrameSenderKey{}),
+		WireLayout("Sync Message", WireCategoryStream, -1, "Folder sync message, via stream service sync/1.", wireSyncMessage{}),
+		WireLayout("Sync File", WireCategoryStream, -1, "Single file in a folder sync message.", wireSyncFile{}),
+		WireLayout("Storage Challenge", WireCategoryStream, -1, "Proof-of-storage challenge, via stream service storage-proof/1.", wireStorageChallenge{}),
+		WireLayout("Storage Proof", WireCategoryStream, -1, "Response to a proof-of-storage challenge.", wireStorageProof{}),
+		WireLayout("INFO_STORE Challenge", WireCategoryStream, -1, "Challenge to prove storing the data of an INFO_STORE record, via stream service info-store-proof/1.", wireInfoStoreChallenge{}),
+		WireLayout("INFO_STORE Proof", WireCategoryStream, -1, "Response to an INFO_STORE challenge.", wireInfoStoreProof{}),
+		WireLayout("Delegated Publish Request", WireCategoryStream, -1, "Records signed by a delegate to be appended to the owner's blockchain, via stream service delegated-publish/1.", wireDelegatedPublishRequest{}),
+		WireLayout("Delegated Publish Response", WireCategoryStream, -1, "Response to a delegated publish request.", wireDelegatedPublishResponse{}),
+	)
+}
@@ -0,0 +1,180 @@
+/*
+File Username:  Wire Spec.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The wire format specification is generated from layout structures. Each field of a layout is tagged with its description; the offsets and sizes
+are derived from the Go types, so that the specification always matches the layouts:
+* Fixed size fields: uint8, uint16, uint32, uint64 (all little endian) and arrays of them.
+* Variable size fields: byte slices and strings. The tag "size" describes how the size is determined. Offsets of all following fields are variable.
+
+Example:
+type wireExample struct {
+	Control uint8    `wire:"Control"`
+	Hash    [32]byte `wire:"Hash of the file"`
+	Data    []byte   `wire:"Data" size:"Remaining payload"`
+}
+
+Layouts of packets, messages, shared structures, and stream protocols are registered in this package. Other packages register their layouts via
+RegisterWireFormat, for example the blockchain package for blocks and block records. Tests verify the layout sizes against the size constants used
+by the encoders.
+*/
+
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Categories of wire formats
+const (
+	WireCategoryPacket    = "packet"    // Packet headers.
+	WireCategoryMessage   = "message"   // Payload of a command. The ID is the command.
+	WireCategoryStructure = "structure" // Structures embedded in messages.
+	WireCategoryStream    = "stream"    // Protocols used within streams and file transfers.
+	WireCategoryRecord    = "record"    // Blocks and block records. The ID is the record type.
+)
+
+// WireField is a single field of a wire format.
+type WireField struct {
+	Name     string `json:"name"`     // Name of the field.
+	Offset   int    `json:"offset"`   // Offset in bytes. -1 if the offset is variable.
+	Size     int    `json:"size"`     // Size in bytes. 0 if the size is variable.
+	SizeInfo string `json:"sizeinfo"` // How the size is determined, for variable size fields.
+	Info     string `json:"info"`     // Description.
+}
+
+// WireFormat is the specification of a single wire format.
+type WireFormat struct {
+	Name     string      `json:"name"`     // Name of the format.
+	Category string      `json:"category"` // Category, see WireCategoryX.
+	ID       int         `json:"id"`       // Command for messages, record type for block records. -1 if not applicable.
+	Info     string      `json:"info"`     // Description.
+	MinSize  int         `json:"minsize"`  // Sum of the sizes of all fixed size fields.
+	Fields   []WireField `json:"fields"`   // Fields in order.
+}
+
+var wireFormats struct {
+	list []WireFormat
+	sync.Mutex
+}
+
+// WireLayout generates the wire format from the layout structure. It panics if the layout is not a structure.
+func WireLayout(name, category string, id int, info string, layout interface{}) (format WireFormat) {
+	format = WireFormat{Name: name, Category: category, ID: id, Info: info, Fields: []WireField{}}
+
+	layoutType := reflect.TypeOf(layout)
+	if layoutType.Kind() != reflect.Struct {
+		panic("wire layout must be a structure")
+	}
+
+	offset := 0
+	for n := 0; n < layoutType.NumField(); n++ {
+		field := layoutType.Field(n)
+		size := wireTypeSize(field.Type)
+
+		format.Fields = append(format.Fields, WireField{Name: field.Name, Offset: offset, Size: size, SizeInfo: field.Tag.Get("size"), Info: field.Tag.Get("wire")})
+		format.MinSize += size
+
+		if offset >= 0 && size > 0 {
+			offset += size
+		} else {
+			offset = -1
+		}
+	}
+
+	return format
+}
+
+// wireTypeSize returns the encoded size of the type. 0 if the size is variable.
+func wireTypeSize(fieldType reflect.Type) int {
+	switch fieldType.Kind() {
+	case reflect.Uint8, reflect.Int8:
+		return 1
+	case reflect.Uint16, reflect.Int16:
+		return 2
+	case reflect.Uint32, reflect.Int32:
+		return 4
+	case reflect.Uint64, reflect.Int64:
+		return 8
+	case reflect.Array:
+		return fieldType.Len() * wireTypeSize(fieldType.Elem())
+	}
+
+	return 0
+}
+
+// RegisterWireFormat registers wire formats to be included in the specification.
+func RegisterWireFormat(formats ...WireFormat) {
+	wireFormats.Lock()
+	defer wireFormats.Unlock()
+
+	wireFormats.list = append(wireFormats.list, formats...)
+}
+
+// WireSpec returns all registered wire formats sorted by category order (packets, messages, structures, streams, records) and ID. Formats with
+// the same ID keep the order of registration.
+func WireSpec() (formats []WireFormat) {
+	wireFormats.Lock()
+	formats = append(formats, wireFormats.list...)
+	wireFormats.Unlock()
+
+	order := map[string]int{WireCategoryPacket: 0, WireCategoryMessage: 1, WireCategoryStructure: 2, WireCategoryStream: 3, WireCategoryRecord: 4}
+
+	sort.SliceStable(formats, func(i, j int) bool {
+		if order[formats[i].Category] != order[formats[j].Category] {
+			return order[formats[i].Category] < order[formats[j].Category]
+		}
+		return formats[i].ID < formats[j].ID
+	})
+
+	return formats
+}
+
+// WireSpecText returns the specification as plain text, using the same table layout as the documentation in the source files.
+func WireSpecText(formats []WireFormat) string {
+	var text strings.Builder
+
+	fmt.Fprintf(&text, "Peernet Protocol version %d\n", ProtocolVersion)
+
+	for _, format := range formats {
+		fmt.Fprintf(&text, "\n%s (%s", format.Name, format.Category)
+		if format.ID >= 0 {
+			fmt.Fprintf(&text, " %d", format.ID)
+		}
+		fmt.Fprintf(&text, ")\n")
+		if format.Info != "" {
+			fmt.Fprintf(&text, "%s\n", format.Info)
+		}
+
+		if len(format.Fields) == 0 {
+			fmt.Fprintf(&text, "No payload.\n")
+			continue
+		}
+
+		fmt.Fprintf(&text, "Offset  Size    Info\n")
+		for _, field := range format.Fields {
+			offset, size, info := "?", "?", field.Info
+			if field.Offset >= 0 {
+				offset = fmt.Sprint(field.Offset)
+			}
+			if field.Size > 0 {
+				size = fmt.Sprint(field.Size)
+			}
+			if field.SizeInfo != "" {
+				if !strings.HasSuffix(info, ".") {
+					info += "."
+				}
+				info += " Size: " + field.SizeInfo + "."
+			}
+
+			fmt.Fprintf(&text, "%-8s%-8s%s\n", offset, size, info)
+		}
+	}
+
+	return text.String()
+}
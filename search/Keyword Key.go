@@ -0,0 +1,49 @@
+/*
+File name:  Keyword Key.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Keys of the distributed keyword index. The DHT key of a keyword is derived from the hash of the normalized word as used by the local search index.
+The prefix separates keyword keys from hashes of files, so that a file containing only the keyword does not collide with it.
+*/
+
+package search
+
+import (
+	"lukechampine.com/blake3"
+)
+
+// keywordKeyPrefix is prepended to the hash of the keyword to derive the DHT key.
+const keywordKeyPrefix = "peernet keyword "
+
+// KeywordKey returns the DHT key for the hash of a keyword.
+func KeywordKey(hash []byte) (key []byte) {
+	keyB := blake3.Sum256(append([]byte(keywordKeyPrefix), hash...))
+	return keyB[:]
+}
+
+// TermKeywords returns the hashes of the keywords of the search term using the same normalization as Search.
+// The hash of the entire term comes first. In exact search mode, it is the only one.
+func TermKeywords(term string) (hashes [][]byte) {
+	termS, isExact, _ := sanitizeInputTerm(term)
+
+	hashExact, _ := hashWord(termS)
+	if hashExact == nil {
+		return nil
+	}
+
+	hashes = append(hashes, hashExact)
+	if isExact {
+		return hashes
+	}
+
+	words := make(map[[32]byte]string)
+	text2Hashes(termS, words)
+	hashMapDelete(hashExact, words)
+
+	for hash := range words {
+		hashes = append(hashes, append([]byte{}, hash[:]...))
+	}
+
+	return hashes
+}
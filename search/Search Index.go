@@ -9,6 +9,7 @@ package search
 import (
 	"encoding/binary"
 	"errors"
+	"strings"
 	"sync"
 
 	"github.com/PeernetOfficial/core/blockchain"
@@ -17,11 +18,15 @@ import (
 	"github.com/google/uuid"
 )
 
+// textExcerptIndexMax is the max count of bytes of the text excerpt of a file (tag TagTextExcerpt) that are indexed.
+const textExcerptIndexMax = 4096
+
 // A search selector is a term that discovers a file.
 type SearchSelector struct {
 	Word        string // Normalized version of the word
 	Hash        []byte // Hash of the word
 	ExactSearch bool   // Indicates this is an exact search term, for example a full filename.
+	Fuzzy       bool   // Indicates the word is a typo-tolerant match of a search keyword, see fuzzySelectors.
 }
 
 // SearchIndexRecord identifies a hash to a given file
@@ -40,6 +45,8 @@ type SearchIndexRecord struct {
 type SearchIndexStore struct {
 	Database store.Store // The database storing the blockchain.
 	sync.RWMutex
+
+	vocabulary map[[32]byte]*vocabularyWord // Words behind the hashes. Used for fuzzy and prefix search.
 }
 
 func InitSearchIndexStore(DatabaseDirectory string) (searchIndex *SearchIndexStore, err error) {
@@ -53,6 +60,8 @@ func InitSearchIndexStore(DatabaseDirectory string) (searchIndex *SearchIndexSto
 		return nil, err
 	}
 
+	searchIndex.loadVocabulary()
+
 	return searchIndex, nil
 }
 
@@ -78,27 +87,65 @@ func (index *SearchIndexStore) IndexNewBlockDecoded(publicKey *btcec.PublicKey,
 
 	for _, decodedR := range recordsDecoded {
 		if file, ok := decodedR.(blockchain.BlockRecordFile); ok {
-			var filename, folder, description string
-			for _, tag := range file.Tags {
-				switch tag.Type {
-				case blockchain.TagName:
-					filename = sanitizeGeneric(tag.Text())
-				case blockchain.TagFolder:
-					folder = sanitizeGeneric(tag.Text())
-				case blockchain.TagDescription:
-					description = sanitizeGeneric(tag.Text())
-				}
+			// Expired files are not indexed. The owner is expected to delete them.
+			if file.IsExpired() {
+				continue
+			}
+
+			for hash, word := range FileKeywords(file) {
+				index.IndexHash(publicKey, blockchainVersion, blockNumber, file.ID, hash[:])
+				index.indexWord(hash, word)
+			}
+		} else if record, ok := decodedR.(blockchain.BlockRecordCustom); ok {
+			// Custom records are indexed by the text provided by the handler. The search result refers to the ID returned by the handler.
+			handler := blockchain.RecordTypeHandlerGet(record.Type)
+			if handler == nil || handler.Index == nil {
+				continue
+			}
+
+			id, text, ok := handler.Index(record.Value)
+			if !ok {
+				continue
 			}
 
 			hashes := make(map[[32]byte]string)
-			filename2Hashes(filename, folder, hashes)
-			text2Hashes(description, hashes)
+			text2Hashes(sanitizeGeneric(text), hashes)
 
-			for hash := range hashes {
-				index.IndexHash(publicKey, blockchainVersion, blockNumber, file.ID, hash[:])
+			for hash, word := range hashes {
+				index.IndexHash(publicKey, blockchainVersion, blockNumber, id, hash[:])
+				index.indexWord(hash, word)
+			}
+		}
+	}
+}
+
+// FileKeywords returns the hashes of the keywords of the file, which are the name, folder, description, and the beginning of the text excerpt.
+func FileKeywords(file blockchain.BlockRecordFile) (hashes map[[32]byte]string) {
+	var filename, folder, description, excerpt string
+	for _, tag := range file.Tags {
+		switch tag.Type {
+		case blockchain.TagName:
+			filename = sanitizeGeneric(tag.Text())
+		case blockchain.TagFolder:
+			folder = sanitizeGeneric(tag.Text())
+		case blockchain.TagDescription:
+			description = sanitizeGeneric(tag.Text())
+		case blockchain.TagTextExcerpt:
+			// Only the beginning of the excerpt is indexed, to limit the index size per file.
+			excerpt = tag.Text()
+			if len(excerpt) > textExcerptIndexMax {
+				excerpt = strings.ToValidUTF8(excerpt[:textExcerptIndexMax], "")
 			}
+			excerpt = sanitizeGeneric(excerpt)
 		}
 	}
+
+	hashes = make(map[[32]byte]string)
+	filename2Hashes(filename, folder, hashes)
+	text2Hashes(description, hashes)
+	text2Hashes(excerpt, hashes)
+
+	return hashes
 }
 
 // UnindexBlockchain deletes all index for a given blockchain. This is intentionally not done on a version/block level, because it could easily lead to orphans.
@@ -156,6 +203,8 @@ func (index *SearchIndexStore) IndexHash(publicKey *btcec.PublicKey, blockchainV
 	// create the reverse record
 	index.createReverseIndexRecord(publicKey, blockchainVersion, blockNumber, fileID, hash)
 
+	index.vocabularyCount(hash, len(raw)/indexRecordSize)
+
 	return index.Database.Set(hash, raw)
 }
 
@@ -188,9 +237,12 @@ func (index *SearchIndexStore) UnindexHash(fileID uuid.UUID, hash []byte) (err e
 	if len(newRaw) == 0 {
 		// delete the entire hash key
 		index.Database.Delete(hash)
+		index.vocabularyCount(hash, 0)
 		return
 	}
 
+	index.vocabularyCount(hash, len(newRaw)/indexRecordSize)
+
 	return index.Database.Set(hash, newRaw)
 }
 
@@ -0,0 +1,255 @@
+/*
+File name:  Search Suggest.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The index only stores hashes of keywords, which allows exact matches only. To support typo-tolerant search and autocompletion, the
+vocabulary (the words behind the hashes) is stored as well and kept in memory:
+* Suggest returns the words starting with the search term (prefix search), ordered by the count of files they match.
+* Search falls back to words within an edit distance (Levenshtein) of the keywords that do not match any file, see fuzzyWords.
+Words indexed before the vocabulary was introduced are only known once their blockchain is indexed again.
+*/
+
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	suggestMinLength  = 2 // Min count of characters of the term for prefix search.
+	fuzzyMaxDistance  = 2 // Max edit distance of words considered matches of a keyword.
+	fuzzyShortLength  = 5 // Words shorter than this only allow an edit distance of 1, as otherwise nearly any word would match.
+	fuzzyMaxWords     = 3 // Max count of words a single keyword is expanded to.
+	vocabularyKeySize = len(vocabularyKeyPrefix) + 32
+)
+
+// vocabularyKeyPrefix is prepended to the keyword hash for the vocabulary record. The key size differs from index (32 bytes) and reverse index records (33 bytes).
+const vocabularyKeyPrefix = "word"
+
+// vocabularyWord is a word stored in the index.
+type vocabularyWord struct {
+	word  string
+	count int // Count of index records (files) matching the word.
+}
+
+// Suggestion is a word in the index matching a search term
+type Suggestion struct {
+	Word     string // Word as stored in the index (lowercase).
+	Count    int    // Count of files in the index matching the word.
+	Distance int    // Edit distance to the search term. 0 for prefix matches.
+}
+
+func vocabularyKey(hash []byte) (key []byte) {
+	return append([]byte(vocabularyKeyPrefix), hash...)
+}
+
+// loadVocabulary loads all vocabulary records from the database into memory.
+func (index *SearchIndexStore) loadVocabulary() {
+	index.Lock()
+	defer index.Unlock()
+
+	index.vocabulary = make(map[[32]byte]*vocabularyWord)
+
+	index.Database.Iterate(func(key, value []byte) {
+		if len(key) != vocabularyKeySize || !strings.HasPrefix(string(key), vocabularyKeyPrefix) {
+			return
+		}
+
+		var hash [32]byte
+		copy(hash[:], key[len(vocabularyKeyPrefix):])
+		index.vocabulary[hash] = &vocabularyWord{word: string(value)}
+	})
+
+	for hash, entry := range index.vocabulary {
+		raw, _ := index.Database.Get(hash[:])
+		entry.count = len(raw) / indexRecordSize
+	}
+}
+
+// indexWord stores the word behind the hash in the vocabulary. The hash must be indexed already via IndexHash.
+func (index *SearchIndexStore) indexWord(hash [32]byte, word string) {
+	if index == nil {
+		return
+	}
+
+	index.Lock()
+	defer index.Unlock()
+
+	if index.vocabulary == nil {
+		index.vocabulary = make(map[[32]byte]*vocabularyWord)
+	} else if _, ok := index.vocabulary[hash]; ok {
+		return
+	}
+
+	raw, found := index.Database.Get(hash[:])
+	if !found {
+		return
+	}
+
+	index.vocabulary[hash] = &vocabularyWord{word: word, count: len(raw) / indexRecordSize}
+	index.Database.Set(vocabularyKey(hash[:]), []byte(word))
+}
+
+// vocabularyCount updates the count of files matching the word behind the hash. If the count is 0, the word is deleted.
+// This function must be called in a RW locked database state.
+func (index *SearchIndexStore) vocabularyCount(hash []byte, count int) {
+	var hashB [32]byte
+	copy(hashB[:], hash)
+
+	entry, ok := index.vocabulary[hashB]
+	if !ok {
+		return
+	} else if count > 0 {
+		entry.count = count
+		return
+	}
+
+	delete(index.vocabulary, hashB)
+	index.Database.Delete(vocabularyKey(hash))
+}
+
+// Suggest returns up to limit words starting with the search term, ordered by the count of files they match. The entire term is used as prefix,
+// since full file names are indexed as words as well. If no word starts with the term, words within the max edit distance are returned instead.
+func (index *SearchIndexStore) Suggest(term string, limit int) (suggestions []Suggestion) {
+	if index == nil {
+		return nil
+	}
+
+	termS, _, _ := sanitizeInputTerm(term)
+	termS = strings.ToLower(termS)
+	if utf8.RuneCountInString(termS) < suggestMinLength || limit <= 0 {
+		return nil
+	}
+
+	index.RLock()
+	for _, entry := range index.vocabulary {
+		if strings.HasPrefix(entry.word, termS) {
+			suggestions = append(suggestions, Suggestion{Word: entry.word, Count: entry.count})
+		}
+	}
+	index.RUnlock()
+
+	if len(suggestions) == 0 {
+		suggestions = index.fuzzyWords(termS)
+	}
+
+	sortSuggestions(suggestions)
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions
+}
+
+// fuzzyWords returns all words within the max edit distance of the word. The word itself is not returned.
+func (index *SearchIndexStore) fuzzyWords(word string) (matches []Suggestion) {
+	maxDistance := fuzzyMaxDistance
+	if utf8.RuneCountInString(word) < fuzzyShortLength {
+		maxDistance = 1
+	}
+
+	wordR := []rune(word)
+
+	index.RLock()
+	defer index.RUnlock()
+
+	for _, entry := range index.vocabulary {
+		if entry.word == word {
+			continue
+		}
+
+		if distance := editDistance(wordR, []rune(entry.word), maxDistance); distance <= maxDistance {
+			matches = append(matches, Suggestion{Word: entry.word, Count: entry.count, Distance: distance})
+		}
+	}
+
+	return matches
+}
+
+// fuzzySelectors returns the selectors for the closest words of a keyword that does not match any file.
+func (index *SearchIndexStore) fuzzySelectors(word string) (selectors []SearchSelector) {
+	matches := index.fuzzyWords(word)
+	sortSuggestions(matches)
+
+	if len(matches) > fuzzyMaxWords {
+		matches = matches[:fuzzyMaxWords]
+	}
+
+	for _, match := range matches {
+		if hash, wordH := hashWord(match.Word); hash != nil {
+			selectors = append(selectors, SearchSelector{Hash: hash, Word: wordH, Fuzzy: true})
+		}
+	}
+
+	return selectors
+}
+
+// isHashIndexed checks if any file is indexed for the hash.
+func (index *SearchIndexStore) isHashIndexed(hash []byte) bool {
+	index.RLock()
+	defer index.RUnlock()
+
+	_, found := index.Database.Get(hash)
+	return found
+}
+
+// sortSuggestions sorts by edit distance, then by count of files descending, then alphabetically.
+func sortSuggestions(suggestions []Suggestion) {
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Distance != suggestions[j].Distance {
+			return suggestions[i].Distance < suggestions[j].Distance
+		} else if suggestions[i].Count != suggestions[j].Count {
+			return suggestions[i].Count > suggestions[j].Count
+		}
+		return suggestions[i].Word < suggestions[j].Word
+	})
+}
+
+// editDistance returns the Levenshtein distance between the words. If the distance exceeds max, any value greater than max is returned.
+func editDistance(a, b []rune, max int) int {
+	if diff := len(a) - len(b); diff > max || -diff > max {
+		return max + 1
+	}
+
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		rowMin := current[0]
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if current[j] < rowMin {
+				rowMin = current[j]
+			}
+		}
+
+		// Stop early if the distance already exceeds the max.
+		if rowMin > max {
+			return max + 1
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
@@ -40,6 +40,13 @@ func (index *SearchIndexStore) Search(term string) (results []SearchIndexRecord)
 		return resultMapToSlice()
 	}
 
+	// Typo-tolerant search for the entire term if it does not match. Words of the term are checked below.
+	if hashExact != nil && !index.isHashIndexed(hashExact) {
+		for _, selector := range index.fuzzySelectors(wordH) {
+			index.LookupHash(selector, resultMap)
+		}
+	}
+
 	// break up the term into hashes
 	hashes := make(map[[32]byte]string)
 
@@ -50,6 +57,13 @@ func (index *SearchIndexStore) Search(term string) (results []SearchIndexRecord)
 
 	// look up the hashes!
 	for hash, keyword := range hashes {
+		if !index.isHashIndexed(hash[:]) {
+			for _, selector := range index.fuzzySelectors(keyword) {
+				index.LookupHash(selector, resultMap)
+			}
+			continue
+		}
+
 		index.LookupHash(SearchSelector{Hash: hash[:], Word: keyword}, resultMap)
 	}
 
@@ -0,0 +1,130 @@
+/*
+File Username:  Reader.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Bounded reader for little endian encoded records. Instead of checking the length before each field, the fields are read in sequence and the
+first out-of-bounds read sets the error. Any subsequent read returns zero values, so the error only needs to be checked once at the end.
+*/
+
+package serialize
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrShortBuffer is returned if a read or write exceeds the available data.
+var ErrShortBuffer = errors.New("data exceeds buffer")
+
+// Reader reads fields from a byte slice.
+type Reader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+// NewReader creates a new reader for the data.
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+// take returns the next size bytes. It returns nil and sets the error if not enough data is available.
+func (reader *Reader) take(size int) (data []byte) {
+	if reader.err != nil {
+		return nil
+	} else if size < 0 || size > len(reader.data)-reader.offset {
+		reader.err = ErrShortBuffer
+		return nil
+	}
+
+	data = reader.data[reader.offset : reader.offset+size]
+	reader.offset += size
+	return data
+}
+
+// Uint8 reads a single byte.
+func (reader *Reader) Uint8() uint8 {
+	if data := reader.take(1); data != nil {
+		return data[0]
+	}
+	return 0
+}
+
+// Uint16 reads a 16-bit number.
+func (reader *Reader) Uint16() uint16 {
+	if data := reader.take(2); data != nil {
+		return binary.LittleEndian.Uint16(data)
+	}
+	return 0
+}
+
+// Uint32 reads a 32-bit number.
+func (reader *Reader) Uint32() uint32 {
+	if data := reader.take(4); data != nil {
+		return binary.LittleEndian.Uint32(data)
+	}
+	return 0
+}
+
+// Uint64 reads a 64-bit number.
+func (reader *Reader) Uint64() uint64 {
+	if data := reader.take(8); data != nil {
+		return binary.LittleEndian.Uint64(data)
+	}
+	return 0
+}
+
+// Bytes reads size bytes. The returned slice references the underlying data.
+func (reader *Reader) Bytes(size int) []byte {
+	return reader.take(size)
+}
+
+// BytesCopy reads size bytes into a new slice.
+func (reader *Reader) BytesCopy(size int) (data []byte) {
+	if source := reader.take(size); source != nil {
+		data = make([]byte, size)
+		copy(data, source)
+	}
+	return data
+}
+
+// Fixed reads len(target) bytes into target.
+func (reader *Reader) Fixed(target []byte) {
+	copy(target, reader.take(len(target)))
+}
+
+// Skip skips size bytes.
+func (reader *Reader) Skip(size int) {
+	reader.take(size)
+}
+
+// Remaining reads all remaining bytes. The returned slice references the underlying data.
+func (reader *Reader) Remaining() []byte {
+	return reader.take(reader.Len())
+}
+
+// Len returns the count of remaining bytes.
+func (reader *Reader) Len() int {
+	if reader.err != nil {
+		return 0
+	}
+	return len(reader.data) - reader.offset
+}
+
+// Offset returns the count of bytes read.
+func (reader *Reader) Offset() int {
+	return reader.offset
+}
+
+// Fail sets the error unless one is already set. It is used for semantic errors detected by the caller.
+func (reader *Reader) Fail(err error) {
+	if reader.err == nil {
+		reader.err = err
+	}
+}
+
+// Err returns the first error that occurred.
+func (reader *Reader) Err() error {
+	return reader.err
+}
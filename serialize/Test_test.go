@@ -0,0 +1,76 @@
+package serialize
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestReaderWriter(t *testing.T) {
+	writer := NewWriter(0)
+	writer.Uint8(1)
+	countOffset := writer.Reserve(2)
+	writer.Uint32(3)
+	writer.Uint64(4)
+	writer.Fixed([]byte{5}, 4)
+	writer.Count32(2)
+	writer.Bytes([]byte("ab"))
+	writer.PutCount16At(countOffset, 2)
+
+	data, err := writer.Data()
+	if err != nil || len(data) != 1+2+4+8+4+4+2 {
+		t.Fatalf("Data: %v, %d bytes", err, len(data))
+	}
+
+	reader := NewReader(data)
+	if reader.Uint8() != 1 || reader.Uint16() != 2 || reader.Uint32() != 3 || reader.Uint64() != 4 || !bytes.Equal(reader.Bytes(4), []byte{5, 0, 0, 0}) {
+		t.Fatal("field mismatch")
+	}
+	if size := reader.Uint32(); !bytes.Equal(reader.BytesCopy(int(size)), []byte("ab")) || reader.Len() != 0 || reader.Err() != nil {
+		t.Fatal("data mismatch")
+	}
+
+	// Reading beyond the end sets a sticky error and returns zero values.
+	if reader.Uint8() != 0 || reader.Err() != ErrShortBuffer || reader.Remaining() != nil {
+		t.Fatal("out of bounds read not detected")
+	}
+
+	reader = NewReader([]byte{1, 2, 3})
+	if reader.Bytes(-1) != nil || reader.Err() != ErrShortBuffer {
+		t.Fatal("negative size not detected")
+	}
+}
+
+func TestWriterOverflow(t *testing.T) {
+	writer := NewWriter(0)
+	writer.Count16(math.MaxUint16 + 1)
+	writer.Uint8(1)
+	if _, err := writer.Data(); err != ErrValueOverflow || writer.Len() != 0 {
+		t.Fatal("count overflow not detected")
+	}
+
+	writer = NewWriter(0)
+	writer.Fixed([]byte{1, 2, 3}, 2)
+	if writer.Err() != ErrValueOverflow {
+		t.Fatal("fixed field overflow not detected")
+	}
+
+	// Bounded writer writes in place and rejects writes beyond the buffer.
+	buffer := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	writer = NewBoundedWriter(buffer[:4])
+	writer.Uint16(0x0201)
+	writer.Uint8(3)
+	if writer.Err() != nil || !bytes.Equal(buffer, []byte{1, 2, 3, 0xFF, 0xFF}) {
+		t.Fatalf("bounded writer mismatch: %v", buffer)
+	}
+	writer.Uint16(4)
+	if writer.Err() != ErrShortBuffer || buffer[3] != 0xFF || buffer[4] != 0xFF {
+		t.Fatal("bounded writer exceeded the buffer")
+	}
+
+	writer = NewBoundedWriter(nil)
+	writer.Uint8(1)
+	if writer.Err() != ErrShortBuffer {
+		t.Fatal("empty bounded writer accepted data")
+	}
+}
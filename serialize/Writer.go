@@ -0,0 +1,168 @@
+/*
+File Username:  Writer.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Bounded writer for little endian encoded records. Like the reader, the first error is kept and subsequent writes are ignored.
+Count and size fields are range checked; fields whose value is only known at the end can be reserved and filled in later.
+*/
+
+package serialize
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrValueOverflow is returned if a count or size exceeds the range of its field.
+var ErrValueOverflow = errors.New("value exceeds field size")
+
+// Writer writes fields to a byte slice.
+type Writer struct {
+	buffer []byte
+	limit  int // Max size of the buffer. -1 if unlimited.
+	err    error
+}
+
+// NewWriter creates a new writer with an initial capacity. The size is not limited.
+func NewWriter(capacity int) *Writer {
+	return &Writer{buffer: make([]byte, 0, capacity), limit: -1}
+}
+
+// NewBoundedWriter creates a writer that writes directly into the buffer. Writes exceeding its length set the error.
+func NewBoundedWriter(buffer []byte) *Writer {
+	return &Writer{buffer: buffer[:0:len(buffer)], limit: len(buffer)}
+}
+
+// grow appends size zero bytes and returns them. It returns nil and sets the error if the limit is exceeded.
+func (writer *Writer) grow(size int) (data []byte) {
+	if writer.err != nil {
+		return nil
+	} else if writer.limit >= 0 && size > writer.limit-len(writer.buffer) {
+		writer.err = ErrShortBuffer
+		return nil
+	}
+
+	offset := len(writer.buffer)
+	if size <= cap(writer.buffer)-offset {
+		writer.buffer = writer.buffer[:offset+size]
+		for n := range writer.buffer[offset:] {
+			writer.buffer[offset+n] = 0
+		}
+	} else {
+		writer.buffer = append(writer.buffer, make([]byte, size)...)
+	}
+
+	return writer.buffer[offset : offset+size]
+}
+
+// Uint8 writes a single byte.
+func (writer *Writer) Uint8(value uint8) {
+	if data := writer.grow(1); data != nil {
+		data[0] = value
+	}
+}
+
+// Uint16 writes a 16-bit number.
+func (writer *Writer) Uint16(value uint16) {
+	if data := writer.grow(2); data != nil {
+		binary.LittleEndian.PutUint16(data, value)
+	}
+}
+
+// Uint32 writes a 32-bit number.
+func (writer *Writer) Uint32(value uint32) {
+	if data := writer.grow(4); data != nil {
+		binary.LittleEndian.PutUint32(data, value)
+	}
+}
+
+// Uint64 writes a 64-bit number.
+func (writer *Writer) Uint64(value uint64) {
+	if data := writer.grow(8); data != nil {
+		binary.LittleEndian.PutUint64(data, value)
+	}
+}
+
+// Count16 writes a count or size as 16-bit number. Values that do not fit set the error.
+func (writer *Writer) Count16(value int) {
+	if value < 0 || value > math.MaxUint16 {
+		writer.Fail(ErrValueOverflow)
+		return
+	}
+	writer.Uint16(uint16(value))
+}
+
+// Count32 writes a count or size as 32-bit number. Values that do not fit set the error.
+func (writer *Writer) Count32(value int) {
+	if value < 0 || uint64(value) > math.MaxUint32 {
+		writer.Fail(ErrValueOverflow)
+		return
+	}
+	writer.Uint32(uint32(value))
+}
+
+// Bytes writes the data.
+func (writer *Writer) Bytes(data []byte) {
+	copy(writer.grow(len(data)), data)
+}
+
+// Fixed writes exactly size bytes. Shorter data is zero padded, longer data sets the error.
+func (writer *Writer) Fixed(data []byte, size int) {
+	if len(data) > size {
+		writer.Fail(ErrValueOverflow)
+		return
+	}
+	copy(writer.grow(size), data)
+}
+
+// Reserve reserves size zero bytes for a field that is filled in later via PutCount16At or PutCount32At. It returns the offset.
+func (writer *Writer) Reserve(size int) (offset int) {
+	offset = len(writer.buffer)
+	writer.grow(size)
+	return offset
+}
+
+// PutCount16At fills a reserved 16-bit count field. Values that do not fit set the error.
+func (writer *Writer) PutCount16At(offset, value int) {
+	if value < 0 || value > math.MaxUint16 {
+		writer.Fail(ErrValueOverflow)
+	} else if writer.err == nil {
+		binary.LittleEndian.PutUint16(writer.buffer[offset:offset+2], uint16(value))
+	}
+}
+
+// PutCount32At fills a reserved 32-bit count field. Values that do not fit set the error.
+func (writer *Writer) PutCount32At(offset, value int) {
+	if value < 0 || uint64(value) > math.MaxUint32 {
+		writer.Fail(ErrValueOverflow)
+	} else if writer.err == nil {
+		binary.LittleEndian.PutUint32(writer.buffer[offset:offset+4], uint32(value))
+	}
+}
+
+// Len returns the count of bytes written.
+func (writer *Writer) Len() int {
+	return len(writer.buffer)
+}
+
+// Fail sets the error unless one is already set. It is used for semantic errors detected by the caller.
+func (writer *Writer) Fail(err error) {
+	if writer.err == nil {
+		writer.err = err
+	}
+}
+
+// Err returns the first error that occurred.
+func (writer *Writer) Err() error {
+	return writer.err
+}
+
+// Data returns the written data and the first error that occurred.
+func (writer *Writer) Data() (data []byte, err error) {
+	if writer.err != nil {
+		return nil, writer.err
+	}
+	return writer.buffer, nil
+}
@@ -41,6 +41,11 @@ func NewPogrebStore(filename string) (store *PogrebStore, err error) {
 	}, nil
 }
 
+// Close closes the database. The store must not be used afterwards.
+func (store *PogrebStore) Close() error {
+	return store.db.Close()
+}
+
 func (store *PogrebStore) ExpireKeys() {
 	// Not yet implemented
 }
@@ -0,0 +1,75 @@
+/*
+File Username:  Registry.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Registry of key-value store implementations. Embedders select the store by name, for example to use an in-memory store or a store without
+memory-mapped files (such as bbolt or LevelDB) on platforms where Pogreb's mmap behavior is problematic. Additional stores are registered via
+Register before the backend is initialized.
+*/
+
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultStore is the name of the store used if none is specified.
+const DefaultStore = "pogreb"
+
+// Factory opens the store at the given path. Depending on the store, the path is a filename or folder. In-memory stores ignore it.
+type Factory func(path string) (Store, error)
+
+var (
+	factories      = make(map[string]Factory)
+	factoriesMutex sync.RWMutex
+)
+
+func init() {
+	Register("pogreb", func(path string) (Store, error) {
+		return NewPogrebStore(path)
+	})
+	Register("memory", func(path string) (Store, error) {
+		return NewMemoryStore(), nil
+	})
+}
+
+// Register registers a store under the given name. An existing registration with the same name is replaced.
+func Register(name string, factory Factory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+
+	factories[name] = factory
+}
+
+// Open opens the store at the given path using the registered store. An empty name selects DefaultStore.
+func Open(name, path string) (store Store, err error) {
+	if name == "" {
+		name = DefaultStore
+	}
+
+	factoriesMutex.RLock()
+	factory, ok := factories[name]
+	factoriesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown store '%s'", name)
+	}
+
+	return factory(path)
+}
+
+// Available returns the names of all registered stores.
+func Available() (names []string) {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -11,11 +11,18 @@ type Config struct {
 	CanAcceptDgram     bool          // can this listener accept datagrams?
 	CanAcceptStream    bool          // can this listener accept streams?
 	ListenReplayWindow time.Duration // length of time to wait for repeated incoming connections
+	ListenBacklog      int           // max count of accepted connections waiting for Accept (0 = 100). Further connections are refused.
 	MaxPacketSize      uint          // Upper limit on maximum packet size (0 = unlimited)
 	MaxBandwidth       uint64        // Maximum bandwidth to take with this connection (in bytes/sec, 0 = unlimited)
 	LingerTime         time.Duration // time to wait for retransmit requests after connection shutdown
 	MaxFlowWinSize     uint          // maximum number of unacknowledged packets to permit (minimum 32)
 	SynTime            time.Duration // SynTime
+	CongestionReports  bool          // Enables structured congestion reports if supported by the remote side
+
+	// ACK aggregation. ACKs for received data packets are coalesced until ACKInterval packets are received or ACKMaxDelay expires.
+	ACKInterval uint          // number of data packets to receive before sending an ACK (0 = set by congestion control)
+	ACKPeriod   time.Duration // period of the timer for sending delayed ACKs and resending ACKs/NAKs (0 = SynTime)
+	ACKMaxDelay time.Duration // maximum time an ACK may be delayed for coalescing (0 = ACKPeriod). Enforced with the resolution of ACKPeriod.
 
 	CanAccept           func(hsPacket *packet.HandshakePacket) error // can this listener accept this connection?
 	CongestionForSocket func(sock *UDTSocket) CongestionControl      // create or otherwise return the CongestionControl for this socket
@@ -32,6 +39,7 @@ func DefaultConfig() *Config {
 		MaxBandwidth:       0,
 		MaxPacketSize:      65535,
 		SynTime:            10000 * time.Microsecond,
+		CongestionReports:  true,
 		CongestionForSocket: func(sock *UDTSocket) CongestionControl {
 			return &NativeCongestionControl{}
 		},
@@ -0,0 +1,81 @@
+package udt
+
+import (
+	"sync"
+	"time"
+)
+
+// socketDeadline is a race-free deadline for Read or Write operations. The channel returned by wait is closed once the deadline passes.
+// Setting a new deadline replaces the channel if the old one was already closed, which means that blocked callers must call wait again after it fires.
+type socketDeadline struct {
+	mutex  sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // closed when the deadline passes
+	epoch  uint64        // incremented on every call to set, invalidates timers that already fired but did not yet acquire the mutex
+}
+
+func newSocketDeadline() *socketDeadline {
+	return &socketDeadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline. A zero value means no deadline.
+func (d *socketDeadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.epoch++
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	passed := isClosedChan(d.cancel)
+
+	if t.IsZero() {
+		if passed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if duration := time.Until(t); duration > 0 {
+		if passed {
+			d.cancel = make(chan struct{})
+		}
+
+		epoch := d.epoch
+		d.timer = time.AfterFunc(duration, func() {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+
+			// Only close the channel if the deadline was not changed in the meantime by a new call to set.
+			if d.epoch == epoch && !isClosedChan(d.cancel) {
+				close(d.cancel)
+			}
+		})
+		return
+	}
+
+	// The deadline is in the past.
+	if !passed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline passes.
+func (d *socketDeadline) wait() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.cancel
+}
+
+// isClosedChan checks if the channel is closed without blocking.
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -50,9 +50,9 @@ func (l *listener) Close() (err error) {
 	return nil
 }
 
+// Addr returns the local address. It is nil if the closer does not implement Addresser.
 func (l *listener) Addr() net.Addr {
-	//return l.m.laddr
-	return nil
+	return l.m.laddr
 }
 
 // checkValidHandshake checks to see if we want to accept a new connection with this handshake.
@@ -104,7 +104,7 @@ func (l *listener) readHandshake(m *multiplexer, hsPacket *packet.HandshakePacke
 		if s != nil {
 			l.acceptHist[idx].lastTouch = now
 			l.acceptHistProt.Unlock()
-			return s.readHandshake(m, hsPacket)
+			return s.handshakeIn(m, hsPacket)
 		}
 	}
 	l.acceptHistProt.Unlock()
@@ -119,6 +119,11 @@ func (l *listener) readHandshake(m *multiplexer, hsPacket *packet.HandshakePacke
 		l.rejectHandshake(m, hsPacket)
 		return false
 	}
+	if len(l.accept) >= cap(l.accept) {
+		// backlog full
+		l.rejectHandshake(m, hsPacket)
+		return false
+	}
 	if l.config.CanAccept != nil {
 		err := l.config.CanAccept(hsPacket)
 		if err != nil {
@@ -156,17 +161,28 @@ func (l *listener) readHandshake(m *multiplexer, hsPacket *packet.HandshakePacke
 		return false
 	}
 
-	l.accept <- s
-	return true
+	select {
+	case l.accept <- s:
+		return true
+	default:
+		// backlog filled up in the meantime
+		s.Close()
+		return false
+	}
 }
 
 // ListenUDT listens for incoming UDT connections using the existing provided packet connection. It creates a UDT server.
 func ListenUDT(config *Config, closer Closer, incomingData <-chan []byte, outgoingData chan<- []byte, terminationSignal <-chan struct{}) *listener {
 	m := newMultiplexer(closer, config.MaxPacketSize, incomingData, outgoingData, terminationSignal)
 
+	backlog := config.ListenBacklog
+	if backlog <= 0 {
+		backlog = 100
+	}
+
 	l := &listener{
 		m:      m,
-		accept: make(chan *UDTSocket, 100),
+		accept: make(chan *UDTSocket, backlog),
 		closed: make(chan struct{}, 1),
 		config: config,
 	}
@@ -1,65 +1,199 @@
 package udt
 
 import (
+	"math"
+	"sync/atomic"
+	"time"
+
 	"github.com/PeernetOfficial/core/udt/packet"
 )
 
+// socketMetrics contains the performance counters of a socket. All counters are updated atomically by the send and receive goroutines.
+type socketMetrics struct {
+	PktSentData        uint64    // number of sent data packets, including retransmissions
+	PktSendHandShake   uint64    // number of Handshake packets sent
+	PktRecvHandShake   uint64    // number of Handshake packets received
+	PktSendKeepAlive   uint64    // number of Keep-alive packets sent
+	PktRecvKeepAlive   uint64    // number of Keep-alive packets received
+	PktRecvData        uint64    // number of received packets
+	PktSentCongestion  uint64    // number of Congestion Packets sent
+	PktRecvCongestion  uint64    // number of Congestion Packets received
+	PktSentShutdown    uint64    // number of Shutdown Packets sent
+	PktRecvShutdown    uint64    // number of Shutdown Packets received
+	PktSendMessageDrop uint64    // number of Message Drop Packets sent
+	PktRecvMessageDrop uint64    // number of Message Drop Packets received
+	PktSendError       uint64    // number of Error Packets sent
+	PktRecvError       uint64    // number of Error Packets received
+	PktSendUserDefined uint64    // number of User Defined Packets sent
+	PktRecvUserDefined uint64    // number of User Defined Packets received
+	PktSndLoss         uint64    // number of lost packets (sender side)
+	PktRcvLoss         uint64    // number of lost packets (receiver side)
+	PktRetrans         uint64    // number of retransmitted packets
+	PktSentACK         uint64    // number of sent ACK packets
+	PktSentACK2        uint64    // number of sent ACK2 packets
+	PktRecvACK2        uint64    // number of received ACK2 packets
+	PktRecvACK         uint64    // number of received ACK packets
+	PktSentNAK         uint64    // number of sent NAK packets
+	PktRecvNAK         uint64    // number of received NAK packets
+	PktSentOther       uint64    // number of sent Other packets
+	PktRecvOther       uint64    // number of received Other packets
+	DataSent           uint64    // Payload data sent in bytes
+	DataReceived       uint64    // Payload data received in bytes
+	SpeedSend          uint64    // Outgoing data transfer speed in bytes/second. Float64 bits.
+	SpeedReceive       uint64    // Incoming data transfer speed in bytes/second. Float64 bits.
+	timeUpdateSend     time.Time // last time send speed was updated
+	timeUpdateRcv      time.Time // last time receive speed was updated
+	lastTotalSend      uint64    // bytes send when recorded last
+	lastTotalRcv       uint64    // bytes received when recorded last
+	Started            time.Time // Started
+}
+
+// Metrics is a snapshot of the statistics of a socket.
+type Metrics struct {
+	Started       time.Time     // When the socket was created
+	PktSentData   uint64        // Count of data packets sent, including retransmissions
+	PktRecvData   uint64        // Count of data packets received
+	PktSentACK    uint64        // Count of ACK packets sent
+	PktRecvACK    uint64        // Count of ACK packets received
+	PktSentNAK    uint64        // Count of NAK packets sent
+	PktRecvNAK    uint64        // Count of NAK packets received
+	PktSentOther  uint64        // Count of all other control packets sent
+	PktRecvOther  uint64        // Count of all other control packets received
+	PktRetrans    uint64        // Count of retransmitted data packets
+	PktSndLoss    uint64        // Count of sent data packets reported lost by the peer
+	PktRcvLoss    uint64        // Count of data packets detected as lost by the receiver
+	SendLossRate  float64       // Ratio of lost to sent data packets. Between 0 and 1.
+	RecvLossRate  float64       // Ratio of lost to expected data packets. Between 0 and 1.
+	DataSent      uint64        // Payload data sent in bytes
+	DataReceived  uint64        // Payload data received in bytes
+	SpeedSend     float64       // Outgoing data transfer speed in bytes/second, measured each second
+	SpeedReceive  float64       // Incoming data transfer speed in bytes/second, measured each second
+	RTT           time.Duration // Estimated round-trip time
+	RTTVar        time.Duration // Round-trip time variance
+	Bandwidth     uint64        // Estimated link capacity in bytes/second as reported by the peer
+	DeliveryRate  uint64        // Packet delivery rate in bytes/second as reported by the peer
+	MaxPacketSize uint32        // Max packet size
+}
+
+// Metrics returns a snapshot of the statistics of the socket. It is safe to call at any time, including while data is transferred.
+func (s *UDTSocket) Metrics() (metrics Metrics) {
+	m := s.metrics
+
+	metrics = Metrics{
+		Started:       m.Started,
+		PktSentData:   atomic.LoadUint64(&m.PktSentData),
+		PktRecvData:   atomic.LoadUint64(&m.PktRecvData),
+		PktSentACK:    atomic.LoadUint64(&m.PktSentACK) + atomic.LoadUint64(&m.PktSentACK2),
+		PktRecvACK:    atomic.LoadUint64(&m.PktRecvACK) + atomic.LoadUint64(&m.PktRecvACK2),
+		PktSentNAK:    atomic.LoadUint64(&m.PktSentNAK),
+		PktRecvNAK:    atomic.LoadUint64(&m.PktRecvNAK),
+		PktRetrans:    atomic.LoadUint64(&m.PktRetrans),
+		PktSndLoss:    atomic.LoadUint64(&m.PktSndLoss),
+		PktRcvLoss:    atomic.LoadUint64(&m.PktRcvLoss),
+		DataSent:      atomic.LoadUint64(&m.DataSent),
+		DataReceived:  atomic.LoadUint64(&m.DataReceived),
+		SpeedSend:     math.Float64frombits(atomic.LoadUint64(&m.SpeedSend)),
+		SpeedReceive:  math.Float64frombits(atomic.LoadUint64(&m.SpeedReceive)),
+		MaxPacketSize: s.maxPacketSize,
+	}
+
+	for _, counter := range []*uint64{&m.PktSendHandShake, &m.PktSendKeepAlive, &m.PktSentCongestion, &m.PktSentShutdown, &m.PktSendMessageDrop, &m.PktSendError, &m.PktSendUserDefined, &m.PktSentOther} {
+		metrics.PktSentOther += atomic.LoadUint64(counter)
+	}
+	for _, counter := range []*uint64{&m.PktRecvHandShake, &m.PktRecvKeepAlive, &m.PktRecvCongestion, &m.PktRecvShutdown, &m.PktRecvMessageDrop, &m.PktRecvError, &m.PktRecvUserDefined, &m.PktRecvOther} {
+		metrics.PktRecvOther += atomic.LoadUint64(counter)
+	}
+
+	if metrics.PktSentData > 0 {
+		metrics.SendLossRate = math.Min(float64(metrics.PktSndLoss)/float64(metrics.PktSentData), 1)
+	}
+	if expected := metrics.PktRecvData + metrics.PktRcvLoss; expected > 0 {
+		metrics.RecvLossRate = float64(metrics.PktRcvLoss) / float64(expected)
+	}
+
+	rtt, rttVar := s.getRTT()
+	metrics.RTT = time.Duration(rtt) * time.Microsecond
+	metrics.RTTVar = time.Duration(rttVar) * time.Microsecond
+
+	deliveryRate, bandwidth := s.getRcvSpeeds()
+	metrics.DeliveryRate = uint64(deliveryRate) * uint64(s.maxPacketSize)
+	metrics.Bandwidth = uint64(bandwidth) * uint64(s.maxPacketSize)
+
+	return metrics
+}
+
+// updateSpeed calculates the effective send and receive speeds. It is called every second by goManageConnection.
+func (m *socketMetrics) updateSpeed() {
+	dataSent := atomic.LoadUint64(&m.DataSent)
+	atomic.StoreUint64(&m.SpeedSend, math.Float64bits(float64(dataSent-m.lastTotalSend)/time.Since(m.timeUpdateSend).Seconds()))
+	m.timeUpdateSend = time.Now()
+	m.lastTotalSend = dataSent
+
+	dataReceived := atomic.LoadUint64(&m.DataReceived)
+	atomic.StoreUint64(&m.SpeedReceive, math.Float64bits(float64(dataReceived-m.lastTotalRcv)/time.Since(m.timeUpdateRcv).Seconds()))
+	m.timeUpdateRcv = time.Now()
+	m.lastTotalRcv = dataReceived
+}
+
 // recordTypeOfPacket records statistics on packet related metrics
 func (s *UDTSocket) recordTypeOfPacket(p packet.Packet, isSend bool) {
+	var counter *uint64
 
 	if isSend {
 		switch packet.PacketTypeName(p.PacketType()) {
 		case "handshake":
-			s.Metrics.PktSendHandShake++
+			counter = &s.metrics.PktSendHandShake
 		case "keep-alive":
-			s.Metrics.PktSendKeepAlive++
+			counter = &s.metrics.PktSendKeepAlive
 		case "ack":
-			s.Metrics.PktSentACK++
+			counter = &s.metrics.PktSentACK
 		case "nak":
-			s.Metrics.PktSentNAK++
+			counter = &s.metrics.PktSentNAK
 		case "congestion":
-			s.Metrics.PktSentCongestion++
+			counter = &s.metrics.PktSentCongestion
 		case "shutdown":
-			s.Metrics.PktSentShutdown++
+			counter = &s.metrics.PktSentShutdown
 		case "ack2":
-			s.Metrics.PktSentACK2++
+			counter = &s.metrics.PktSentACK2
 		case "msg-drop":
-			s.Metrics.PktSendMessageDrop++
+			counter = &s.metrics.PktSendMessageDrop
 		case "error":
-			s.Metrics.PktSendError++
+			counter = &s.metrics.PktSendError
 		case "user-defined":
-			s.Metrics.PktSendUserDefined++
+			counter = &s.metrics.PktSendUserDefined
 		case "data":
-			s.Metrics.PktSentData++
+			counter = &s.metrics.PktSentData
 		default:
-			s.Metrics.PktSentOther++
+			counter = &s.metrics.PktSentOther
 		}
 	} else {
 		switch packet.PacketTypeName(p.PacketType()) {
 		case "handshake":
-			s.Metrics.PktRecvHandShake++
+			counter = &s.metrics.PktRecvHandShake
 		case "keep-alive":
-			s.Metrics.PktRecvKeepAlive++
+			counter = &s.metrics.PktRecvKeepAlive
 		case "ack":
-			s.Metrics.PktRecvACK++
+			counter = &s.metrics.PktRecvACK
 		case "nak":
-			s.Metrics.PktRecvNAK++
+			counter = &s.metrics.PktRecvNAK
 		case "congestion":
-			s.Metrics.PktRecvCongestion++
+			counter = &s.metrics.PktRecvCongestion
 		case "shutdown":
-			s.Metrics.PktRecvShutdown++
+			counter = &s.metrics.PktRecvShutdown
 		case "ack2":
-			s.Metrics.PktRecvACK2++
+			counter = &s.metrics.PktRecvACK2
 		case "msg-drop":
-			s.Metrics.PktRecvMessageDrop++
+			counter = &s.metrics.PktRecvMessageDrop
 		case "error":
-			s.Metrics.PktRecvError++
+			counter = &s.metrics.PktRecvError
 		case "user-defined":
-			s.Metrics.PktRecvUserDefined++
+			counter = &s.metrics.PktRecvUserDefined
 		case "data":
-			s.Metrics.PktRecvData++
+			counter = &s.metrics.PktRecvData
 		default:
-			s.Metrics.PktRecvOther++
+			counter = &s.metrics.PktRecvOther
 		}
 	}
+
+	atomic.AddUint64(counter, 1)
 }
@@ -4,6 +4,7 @@ package udt
 
 import (
 	"math/rand"
+	"net"
 
 	"github.com/PeernetOfficial/core/udt/packet"
 )
@@ -18,6 +19,8 @@ type multiplexer struct {
 	outgoingData      chan<- []byte   // destination to send packets to
 	terminationSignal <-chan struct{} // external termination signal to watch
 	closer            Closer          // external closer to call in case the local socket/listener closes
+	laddr             net.Addr        // local address of the underlying connection, if provided by the closer
+	raddr             net.Addr        // remote address of the underlying connection, if provided by the closer
 }
 
 // The closer is called when the socket/listener closes. The terminationSignal is an external (upstream) signal to watch for.
@@ -30,6 +33,10 @@ func newMultiplexer(closer Closer, maxPacketSize uint, incomingData <-chan []byt
 		terminationSignal: terminationSignal,
 	}
 
+	if addresser, ok := closer.(Addresser); ok {
+		m.laddr, m.raddr = addresser.LocalAddr(), addresser.RemoteAddr()
+	}
+
 	go m.goRead()
 
 	return
@@ -2,19 +2,57 @@ package packet
 
 // Structure of packets and functions for writing/reading them
 
-// CongestionPacket is a (deprecated) UDT packet notifying the peer of increased congestion
+import (
+	"errors"
+)
+
+// CongestionPacket is a UDT packet notifying the peer of increased congestion.
+// In original UDT it is deprecated and has no body. If both peers indicate CapabilityCongestionReport in the handshake, it carries a structured congestion report.
 type CongestionPacket struct {
 	ctrlHeader
+
+	// the following data is optional (only sent if CapabilityCongestionReport was negotiated)
+	IncludeReport bool
+	DelayTrend    int32  // Increase of the one-way delay (in microseconds) measured by the receiver between the last two measurement windows
+	Level         uint32 // Congestion level from 0 (none) to CongestionLevelMax (severe), derived from the delay trend relative to the RTT
+	Samples       uint32 // Count of data packets in the measurement window
 }
 
+// CongestionLevelMax is the max congestion level in a congestion report.
+const CongestionLevelMax = 1000
+
 // WriteTo writes this packet to the provided buffer, returning the length of the packet
 func (p *CongestionPacket) WriteTo(buf []byte) (uint, error) {
-	return p.writeHdrTo(buf, ptCongestion, 0)
+	if _, err := p.writeHdrTo(buf, ptCongestion, 0); err != nil {
+		return 0, err
+	}
+
+	if !p.IncludeReport {
+		return 16, nil
+	} else if len(buf) < 28 {
+		return 0, errors.New("congestion packet too small")
+	}
+
+	endianness.PutUint32(buf[16:20], uint32(p.DelayTrend))
+	endianness.PutUint32(buf[20:24], p.Level)
+	endianness.PutUint32(buf[24:28], p.Samples)
+
+	return 28, nil
 }
 
 func (p *CongestionPacket) readFrom(data []byte) (err error) {
-	_, err = p.readHdrFrom(data)
-	return
+	if _, err = p.readHdrFrom(data); err != nil {
+		return err
+	}
+
+	if len(data) >= 28 {
+		p.IncludeReport = true
+		p.DelayTrend = int32(endianness.Uint32(data[16:20]))
+		p.Level = endianness.Uint32(data[20:24])
+		p.Samples = endianness.Uint32(data[24:28])
+	}
+
+	return nil
 }
 
 // PacketType returns the packetType associated with this packet
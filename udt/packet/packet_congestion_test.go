@@ -9,3 +9,9 @@ func TestCongestionPacket(t *testing.T) {
 	pkt1.SetHeader(59, 100)
 	testPacket(pkt1, t)
 }
+
+func TestCongestionPacketReport(t *testing.T) {
+	pkt1 := &CongestionPacket{IncludeReport: true, DelayTrend: -1500, Level: 250, Samples: 16}
+	pkt1.SetHeader(59, 100)
+	testPacket(pkt1, t)
+}
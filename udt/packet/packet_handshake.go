@@ -32,8 +32,14 @@ type HandshakePacket struct {
 	MaxFlowWinSize uint32           // maximum flow window size
 	ReqType        HandshakeReqType // connection type (regular(1), rendezvous(0), -1/-2 response)
 	SockID         uint32           // socket ID
+	Capabilities   uint32           // Capability bits, see CapabilityX. Replaces the unused SYN cookie field.
 }
 
+// Capability bits exchanged in the handshake
+const (
+	CapabilityCongestionReport = 1 << 0 // Supports structured congestion reports in CongestionPacket.
+)
+
 // WriteTo writes this packet to the provided buffer, returning the length of the packet
 func (p *HandshakePacket) WriteTo(buf []byte) (uint, error) {
 	l := len(buf)
@@ -52,7 +58,7 @@ func (p *HandshakePacket) WriteTo(buf []byte) (uint, error) {
 	endianness.PutUint32(buf[32:36], p.MaxFlowWinSize)
 	endianness.PutUint32(buf[36:40], uint32(p.ReqType))
 	endianness.PutUint32(buf[40:44], p.SockID)
-	//endianness.PutUint32(buf[44:48], p.SynCookie)
+	endianness.PutUint32(buf[44:48], p.Capabilities)
 
 	//sockAddr := make([]byte, 16)
 	//copy(sockAddr, p.SockAddr)
@@ -76,7 +82,7 @@ func (p *HandshakePacket) readFrom(data []byte) error {
 	p.MaxFlowWinSize = endianness.Uint32(data[32:36])
 	p.ReqType = HandshakeReqType(endianness.Uint32(data[36:40]))
 	p.SockID = endianness.Uint32(data[40:44])
-	//p.SynCookie = endianness.Uint32(data[44:48])
+	p.Capabilities = endianness.Uint32(data[44:48])
 
 	//p.SockAddr = make(net.IP, 16)
 	//copy(p.SockAddr, data[48:64])
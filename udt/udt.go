@@ -11,23 +11,42 @@ implemented:
 
 */
 
+import (
+	"errors"
+	"net"
+)
+
+// Errors returned by UDTSocket.Write after the socket was closed or terminated locally.
+var (
+	ErrSocketClosed     = errors.New("socket closed")
+	ErrSocketTerminated = errors.New("terminate signal")
+)
+
 // Closer provides a status code indicating why the closing happens.
 type Closer interface {
 	Close(reason int) error       // Close is called when the socket is actually closed.
 	CloseLinger(reason int) error // CloseLinger is called when the socket indicates to be closed soon, after the linger time.
 }
 
+// Addresser is optionally implemented by the Closer to provide the local and remote address of the underlying connection.
+// The addresses are returned by LocalAddr and RemoteAddr of the socket.
+type Addresser interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
 // The termination reason is passed on to the close function
 const (
 	TerminateReasonListenerClosed     = 1000 // Listener: The listener.Close function was called.
 	TerminateReasonLingerTimerExpired = 1001 // Socket: The linger timer expired. Use CloseLinger to know the actual closing reason.
 	TerminateReasonConnectTimeout     = 1002 // Socket: The connection timed out when sending the initial handshake.
 	TerminateReasonRemoteSentShutdown = 1003 // Remote peer sent a shutdown message.
-	TerminateReasonSocketClosed       = 1004 // Send: Socket closed. Called UDTSocket.Close().
+	TerminateReasonSocketClosed       = 1004 // Send: Socket closed. Called UDTSocket.Close() and all pending data was sent, or the linger time expired.
 	TerminateReasonInvalidPacketIDAck = 1005 // Send: Invalid packet ID received in ACK message.
 	TerminateReasonInvalidPacketIDNak = 1006 // Send: Invalid packet ID received in NAK message.
 	TerminateReasonCorruptPacketNak   = 1007 // Send: Invalid NAK packet received.
 	TerminateReasonSignal             = 1008 // Send: Terminate signal. Called UDTSocket.Terminate().
+	TerminateReasonConnectRefused     = 1009 // Socket: The remote peer refused the connection.
 )
 
 // DialUDT establishes an outbound UDT connection using the existing provided packet connection. It creates a UDT client.
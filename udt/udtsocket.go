@@ -5,13 +5,14 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/PeernetOfficial/core/udt/packet"
 )
 
-type sockState int
+type sockState int32
 
 const (
 	sockStateInit       sockState = iota // object is being constructed
@@ -50,8 +51,7 @@ so that it can be used anywhere that a stream-oriented network connection
 */
 type UDTSocket struct {
 	// this data not changed after the socket is initialized and/or handshaked
-	m *multiplexer // the multiplexer that handles this socket
-	//raddr       *net.UDPAddr    // the remote address
+	m           *multiplexer    // the multiplexer that handles this socket
 	created     time.Time       // the time that this socket was created
 	Config      *Config         // configuration parameters for this socket
 	udtVer      int             // UDT protcol version (normally 4.  Will we be supporting others?)
@@ -62,14 +62,14 @@ type UDTSocket struct {
 	initPktSeq  packet.PacketID // initial packet sequence to start the connection with
 	connectWait *sync.WaitGroup // released when connection is complete (or failed)
 
-	sockState           sockState   // socket state - used mostly during handshakes
-	maxPacketSize       uint32      // the maximum packet size
-	maxFlowWinSize      uint        // receiver: maximum unacknowledged packet count
-	currPartialRead     []byte      // stream connections: currently reading message (for partial reads). Owned by client caller (Read)
-	readDeadline        *time.Timer // if set, then calls to Read() will return "timeout" after this time
-	readDeadlinePassed  bool        // if set, then calls to Read() will return "timeout"
-	writeDeadline       *time.Timer // if set, then calls to Write() will return "timeout" after this time
-	writeDeadlinePassed bool        // if set, then calls to Write() will return "timeout"
+	remoteCapabilities uint32 // capability bits indicated by the peer in the handshake
+
+	state           int32           // socket state (sockState). Only access via getState/setState since it is read by the caller of Read/Write.
+	maxPacketSize   uint32          // the maximum packet size
+	maxFlowWinSize  uint            // receiver: maximum unacknowledged packet count
+	currPartialRead []byte          // stream connections: currently reading message (for partial reads). Owned by client caller (Read)
+	readDeadline    *socketDeadline // calls to Read() return "timeout" after the deadline passed
+	writeDeadline   *socketDeadline // calls to Write() return "timeout" after the deadline passed
 
 	rttProt sync.RWMutex // lock must be held before referencing rtt/rttVar
 	rtt     uint         // receiver: estimated roundtrip time. (in microseconds)
@@ -80,16 +80,18 @@ type UDTSocket struct {
 	bandwidth       uint         // bandwidth reported from peer (packets/sec)
 
 	// channels
-	messageIn       chan []byte          // inbound messages. Sender is goReceiveEvent->ingestData, Receiver is client caller (Read)
-	messageOut      chan sendMessage     // outbound messages. Sender is client caller (Write), Receiver is goSendEvent. Closed when socket is closed
-	recvEvent       chan recvPktEvent    // receiver: ingest the specified packet. Sender is readPacket, receiver is goReceiveEvent
-	sendEvent       chan recvPktEvent    // sender: ingest the specified packet. Sender is readPacket, receiver is goSendEvent
-	sendPacket      chan packet.Packet   // packets to send out on the wire (once goManageConnection is running)
-	shutdownEvent   chan shutdownMessage // channel signals the connection to be shutdown
-	sockClosed      chan struct{}        // closed when socket is closed
-	terminateSignal chan struct{}        // termination signal
-	closeMutex      sync.Mutex
-	isClosed        bool
+	messageIn       chan []byte                  // inbound messages. Sender is goReceiveEvent->ingestData, Receiver is client caller (Read)
+	messageOut      chan sendMessage             // outbound messages. Sender is client caller (Write), Receiver is goSendEvent. Never closed.
+	recvEvent       chan recvPktEvent            // receiver: ingest the specified packet. Sender is readPacket, receiver is goReceiveEvent
+	sendEvent       chan recvPktEvent            // sender: ingest the specified packet. Sender is readPacket, receiver is goSendEvent
+	sendPacket      chan packet.Packet           // packets to send out on the wire (once goManageConnection is running)
+	shutdownEvent   chan shutdownMessage         // channel signals the connection to be shutdown
+	handshakeEvent  chan *packet.HandshakePacket // handshake packets received after the connection attempt started. Receiver is goManageConnection
+	sockClosed      chan struct{}                // closed when socket is closed
+	closeSignal     chan struct{}                // closed by Close. The sender flushes pending data and then shuts down the socket.
+	closeOnce       sync.Once
+	terminateSignal chan struct{} // closed by Terminate. The socket is shut down immediately.
+	terminateOnce   sync.Once
 
 	// timers
 	connTimeout <-chan time.Time // connecting: fires when connection attempt times out
@@ -101,47 +103,7 @@ type UDTSocket struct {
 	recv *udtSocketRecv // reference to receiving side of this socket
 	cong *udtSocketCc   // reference to contestion control
 
-	// performance metrics
-	Metrics *Metrics
-}
-
-type Metrics struct {
-	PktSentData        uint64    // number of sent data packets, including retransmissions
-	PktSendHandShake   uint64    // number of Handshake packets sent
-	PktRecvHandShake   uint64    // number of Handshake packets received
-	PktSendKeepAlive   uint64    // number of Keep-alive packets sent
-	PktRecvKeepAlive   uint64    // number of Keep-alive packets received
-	PktRecvData        uint64    // number of received packets
-	PktSentCongestion  uint64    // number of Congestion Packets sent
-	PktRecvCongestion  uint64    // number of Congestion Packets received
-	PktSentShutdown    uint64    // number of Shutdown Packets sent
-	PktRecvShutdown    uint64    // number of Shutdown Packets received
-	PktSendMessageDrop uint64    // number of Message Drop Packets sent
-	PktRecvMessageDrop uint64    // number of Message Drop Packets received
-	PktSendError       uint64    // number of Error Packets sent
-	PktRecvError       uint64    // number of Error Packets received
-	PktSendUserDefined uint64    // number of User Defined Packets sent
-	PktRecvUserDefined uint64    // number of User Defined Packets received
-	PktSndLoss         uint64    // number of lost packets (sender side)
-	PktRcvLoss         uint64    // number of lost packets (receiver side)
-	PktRetrans         uint64    // number of retransmitted packets
-	PktSentACK         uint64    // number of sent ACK packets
-	PktSentACK2        uint64    // number of sent ACK2 packets
-	PktRecvACK2        uint64    // number of received ACK2 packets
-	PktRecvACK         uint64    // number of received ACK packets
-	PktSentNAK         uint64    // number of sent NAK packets
-	PktRecvNAK         uint64    // number of received NAK packets
-	PktSentOther       uint64    // number of sent Other packets
-	PktRecvOther       uint64    // number of received Other packets
-	DataSent           uint64    // Payload data sent in bytes
-	DataReceived       uint64    // Payload data received in bytes
-	SpeedSend          float64   // Incoming data transfer speed in bytes/second
-	SpeedReceive       float64   // Outgoing data transfer speed in bytes/second
-	timeUpdateSend     time.Time // last time send speed was updated
-	timeUpdateRcv      time.Time // last time receive speed was updated
-	lastTotalSend      uint64    // bytes send when recorded last
-	lastTotalRcv       uint64    // bytes received when recorded last
-	Started            time.Time // Started
+	metrics *socketMetrics // performance metrics. See Metrics.
 }
 
 /*******************************************************************************
@@ -152,27 +114,22 @@ type Metrics struct {
 func (s *UDTSocket) fetchReadPacket(blocking bool) ([]byte, error) {
 	var result []byte
 	if blocking {
-		for {
-			if s.readDeadlinePassed {
-				return nil, syscall.ETIMEDOUT
-			}
-			var deadline <-chan time.Time
-			if s.readDeadline != nil {
-				deadline = s.readDeadline.C
-			}
-			select {
-			case result = <-s.messageIn:
-				if result == nil { // nil result indicates EOF
-					return nil, io.EOF
-				}
-				return result, nil
-			case _, ok := <-deadline:
-				if !ok {
-					continue
-				}
-				s.readDeadlinePassed = true
-				return nil, syscall.ETIMEDOUT
+		deadline := s.readDeadline.wait()
+		if isClosedChan(deadline) {
+			return nil, syscall.ETIMEDOUT
+		}
+
+		select {
+		case result = <-s.messageIn:
+			if result == nil { // nil result indicates EOF
+				return nil, io.EOF
 			}
+			return result, nil
+		case <-s.sockClosed:
+			// The socket was shut down. Return any remaining buffered data first.
+			return s.fetchReadPacketClosed()
+		case <-deadline:
+			return nil, syscall.ETIMEDOUT
 		}
 	}
 
@@ -189,8 +146,20 @@ func (s *UDTSocket) fetchReadPacket(blocking bool) ([]byte, error) {
 	return result, nil
 }
 
+// fetchReadPacketClosed returns the next buffered data packet after the socket was shut down, or io.EOF if there is none.
+func (s *UDTSocket) fetchReadPacketClosed() ([]byte, error) {
+	select {
+	case result := <-s.messageIn:
+		if result != nil {
+			return result, nil
+		}
+	default:
+	}
+	return nil, io.EOF
+}
+
 func (s *UDTSocket) connectionError() error {
-	switch s.sockState {
+	switch s.getState() {
 	case sockStateRefused:
 		return errors.New("Connection refused by remote host")
 	case sockStateCorrupted:
@@ -264,89 +233,72 @@ func (s *UDTSocket) Write(p []byte) (n int, err error) {
 	// on the other side:
 	//  for datagram sockets: this is a distinct message to be broken into as few packets as possible
 	//  for streaming sockets: collect as much as can fit into a packet and send them out
-	switch s.sockState {
-	case sockStateRefused:
-		err = errors.New("Connection refused by remote host")
-		return
-	case sockStateCorrupted:
-		err = errors.New("Connection closed due to protocol error")
-		return
-	case sockStateClosed:
-		err = errors.New("Connection closed")
-		return
+	if isClosedChan(s.terminateSignal) {
+		return 0, ErrSocketTerminated
+	} else if isClosedChan(s.closeSignal) {
+		return 0, ErrSocketClosed
+	} else if err = s.connectionError(); err != nil {
+		return 0, err
+	}
+
+	deadline := s.writeDeadline.wait()
+	if isClosedChan(deadline) {
+		return 0, syscall.ETIMEDOUT
 	}
 
 	// previous bug: io.Writer documentation says "Implementations must not retain p.", but it was passed on in s.messageOut
-	n = len(p)
-	data := make([]byte, n)
+	data := make([]byte, len(p))
 	copy(data, p)
 
-	for {
-		if s.writeDeadlinePassed {
-			err = syscall.ETIMEDOUT
-			return
-		}
-		var deadline <-chan time.Time
-		if s.writeDeadline != nil {
-			deadline = s.writeDeadline.C
-		}
-		select {
-		case <-s.terminateSignal:
-			return n, errors.New("terminate signal")
-		case s.messageOut <- sendMessage{content: data, tim: time.Now()}:
-			// send successful
-			s.Metrics.DataSent += uint64(n)
-			return
-		case _, ok := <-deadline:
-			if !ok {
-				continue
-			}
-			s.writeDeadlinePassed = true
-			err = syscall.ETIMEDOUT
-			return
-		}
+	select {
+	case <-s.terminateSignal:
+		return 0, ErrSocketTerminated
+	case <-s.closeSignal:
+		return 0, ErrSocketClosed
+	case <-s.sockClosed:
+		return 0, s.connectionError()
+	case s.messageOut <- sendMessage{content: data, tim: time.Now()}:
+		// send successful
+		atomic.AddUint64(&s.metrics.DataSent, uint64(len(data)))
+		return len(data), nil
+	case <-deadline:
+		return 0, syscall.ETIMEDOUT
 	}
 }
 
-// Close closes the connection.
-// Any blocked Read or Write operations will be unblocked.
-// Write operations will be permitted to send (initial packets)
-// Read operations will return an error // (required for net.Conn implementation).
-// Note: Do not simultaneously call Close() and Write(). To close while the socket is still in use, use Terminate().
+// Close closes the connection gracefully (required for net.Conn implementation).
+// Data already accepted by Write is still sent out and the socket is shut down once the remote peer acknowledged it, or after the linger time.
+// New calls to Write return ErrSocketClosed. Read continues to return any buffered data and then io.EOF once the socket is shut down.
+// It is safe to call Close concurrently with Read, Write and Terminate, and multiple times.
 func (s *UDTSocket) Close() error {
-	s.closeMutex.Lock()
-	defer s.closeMutex.Unlock()
-
-	if s.isClosed || !s.isOpen() {
-		return nil // already closed
-	}
-
-	s.isClosed = true
-
-	// closing messageOut was a signal supposed to tell the send code to initiate shutdown. However, it closes too fast before all data is transferred.
-	// The entire UDT code is a piece of !@#$ and needs a rewrite.
-	//close(s.messageOut)
+	s.closeOnce.Do(func() {
+		close(s.closeSignal)
+	})
 	return nil
 }
 
-// Terminate terminates the connection immediately. Unlike Close, it does not permit any reading/writing.
-// If the connection should be ordinarily closed (after reading/writing) use Close().
+// Terminate terminates the connection immediately without flushing pending data. Any blocked Write returns ErrSocketTerminated.
+// Read returns io.EOF once the socket is shut down. If the connection should be ordinarily closed (after reading/writing) use Close().
+// It is safe to call Terminate concurrently with Read, Write and Close, and multiple times.
 func (s *UDTSocket) Terminate() error {
-	s.closeMutex.Lock()
-	defer s.closeMutex.Unlock()
-
-	if s.isClosed || !s.isOpen() {
-		return nil // already closed
-	}
+	s.terminateOnce.Do(func() {
+		close(s.terminateSignal)
+	})
+	return nil
+}
 
-	s.isClosed = true
+// getState returns the current socket state.
+func (s *UDTSocket) getState() sockState {
+	return sockState(atomic.LoadInt32(&s.state))
+}
 
-	close(s.terminateSignal)
-	return nil
+// setState sets the socket state.
+func (s *UDTSocket) setState(state sockState) {
+	atomic.StoreInt32(&s.state, int32(state))
 }
 
 func (s *UDTSocket) isOpen() bool {
-	switch s.sockState {
+	switch s.getState() {
 	case sockStateClosed, sockStateRefused, sockStateCorrupted, sockStateTimeout:
 		return false
 	default:
@@ -354,18 +306,16 @@ func (s *UDTSocket) isOpen() bool {
 	}
 }
 
-// LocalAddr returns the local network address.
+// LocalAddr returns the local network address. It is nil if the closer does not implement Addresser.
 // (required for net.Conn implementation)
 func (s *UDTSocket) LocalAddr() net.Addr {
-	//return s.m.laddr
-	return nil
+	return s.m.laddr
 }
 
-// RemoteAddr returns the remote network address.
+// RemoteAddr returns the remote network address. It is nil if the closer does not implement Addresser.
 // (required for net.Conn implementation)
 func (s *UDTSocket) RemoteAddr() net.Addr {
-	//return s.raddr
-	return nil
+	return s.m.raddr
 }
 
 // SetDeadline sets the read and write deadlines associated
@@ -392,40 +342,17 @@ func (s *UDTSocket) RemoteAddr() net.Addr {
 // errors.Is(err, syscall.ETIMEDOUT).
 // (required for net.Conn implementation)
 func (s *UDTSocket) SetDeadline(t time.Time) error {
-	s.setDeadline(t, &s.readDeadline, &s.readDeadlinePassed)
-	s.setDeadline(t, &s.writeDeadline, &s.writeDeadlinePassed)
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
 	return nil
 }
 
-func (s *UDTSocket) setDeadline(dl time.Time, timer **time.Timer, timerPassed *bool) {
-	if *timer == nil {
-		if !dl.IsZero() {
-			*timer = time.NewTimer(dl.Sub(time.Now()))
-		}
-	} else {
-		now := time.Now()
-		if !dl.IsZero() && dl.Before(now) {
-			*timerPassed = true
-		}
-		oldTime := *timer
-		if dl.IsZero() {
-			*timer = nil
-		}
-		oldTime.Stop()
-		_, _ = <-oldTime.C
-		if !dl.IsZero() && dl.After(now) {
-			*timerPassed = false
-			oldTime.Reset(dl.Sub(time.Now()))
-		}
-	}
-}
-
 // SetReadDeadline sets the deadline for future Read calls
 // and any currently-blocked Read call.
 // A zero value for t means Read will not time out.
 // (required for net.Conn implementation)
 func (s *UDTSocket) SetReadDeadline(t time.Time) error {
-	s.setDeadline(t, &s.readDeadline, &s.readDeadlinePassed)
+	s.readDeadline.set(t)
 	return nil
 }
 
@@ -436,7 +363,7 @@ func (s *UDTSocket) SetReadDeadline(t time.Time) error {
 // A zero value for t means Write will not time out.
 // (required for net.Conn implementation)
 func (s *UDTSocket) SetWriteDeadline(t time.Time) error {
-	s.setDeadline(t, &s.writeDeadline, &s.writeDeadlinePassed)
+	s.writeDeadline.set(t)
 	return nil
 }
 
@@ -461,7 +388,7 @@ func newSocket(m *multiplexer, config *Config, sockID uint32, isServer bool, isD
 		Config: config,
 		//raddr:          raddr,
 		created:         now,
-		sockState:       sockStateInit,
+		state:           int32(sockStateInit),
 		udtVer:          4,
 		isServer:        isServer,
 		maxPacketSize:   uint32(config.MaxPacketSize),
@@ -474,12 +401,16 @@ func newSocket(m *multiplexer, config *Config, sockID uint32, isServer bool, isD
 		recvEvent:       make(chan recvPktEvent, 256),
 		sendEvent:       make(chan recvPktEvent, 256),
 		sockClosed:      make(chan struct{}, 1),
+		closeSignal:     make(chan struct{}),
 		terminateSignal: make(chan struct{}),
+		readDeadline:    newSocketDeadline(),
+		writeDeadline:   newSocketDeadline(),
 		deliveryRate:    16,
 		bandwidth:       1,
 		sendPacket:      make(chan packet.Packet, 256),
 		shutdownEvent:   make(chan shutdownMessage, 5),
-		Metrics:         &Metrics{timeUpdateRcv: time.Now(), timeUpdateSend: time.Now(), Started: time.Now()},
+		handshakeEvent:  make(chan *packet.HandshakePacket, 16),
+		metrics:         &socketMetrics{timeUpdateRcv: time.Now(), timeUpdateSend: time.Now(), Started: time.Now()},
 		speedTicker:     time.NewTicker(time.Second),
 	}
 	s.cong = newUdtSocketCc(s)
@@ -487,10 +418,16 @@ func newSocket(m *multiplexer, config *Config, sockID uint32, isServer bool, isD
 	return
 }
 
-func (s *UDTSocket) launchProcessors() {
+// launchProcessors creates the sending and receiving side of the socket based on the handshake and starts their goroutines.
+func (s *UDTSocket) launchProcessors(p *packet.HandshakePacket) {
 	s.send = newUdtSocketSend(s)
 	s.recv = newUdtSocketRecv(s)
+	s.recv.configureHandshake(p)
+	s.send.configureHandshake(p, true)
 	s.cong.init(s.initPktSeq)
+
+	go s.recv.goReceiveEvent()
+	go s.send.goSendEvent()
 }
 
 func (s *UDTSocket) startConnect() error {
@@ -499,18 +436,20 @@ func (s *UDTSocket) startConnect() error {
 	s.connectWait = connectWait
 	connectWait.Add(1)
 
-	s.sockState = sockStateConnecting
+	s.setState(sockStateConnecting)
 
 	s.connTimeout = time.After(3 * time.Second)
 	s.connRetry = time.After(250 * time.Millisecond)
-	go s.goManageConnection()
 
+	// Send the first handshake before starting the state goroutine which owns the socket from then on.
 	s.sendHandshake(packet.HsRequest)
+	go s.goManageConnection()
 
 	connectWait.Wait()
 	return s.connectionError()
 }
 
+// goManageConnection is the state goroutine of the socket. Once started, it exclusively handles handshakes, state transitions and sending of packets.
 func (s *UDTSocket) goManageConnection() {
 	defer s.speedTicker.Stop()
 
@@ -528,23 +467,19 @@ func (s *UDTSocket) goManageConnection() {
 			s.m.sendPacket(s.farSockID, ts, p)
 		case sd := <-s.shutdownEvent: // connection shut down
 			s.shutdown(sd.sockState, sd.permitLinger, sd.err, sd.reason)
+		case p := <-s.handshakeEvent:
+			s.readHandshake(s.m, p)
 		case <-s.connTimeout: // connection timed out
 			s.shutdown(sockStateTimeout, true, nil, TerminateReasonConnectTimeout)
 		case <-s.connRetry: // resend connection attempt
 			s.connRetry = nil
-			switch s.sockState {
+			switch s.getState() {
 			case sockStateConnecting:
 				s.sendHandshake(packet.HsRequest)
 				s.connRetry = time.After(250 * time.Millisecond)
 			}
 		case <-s.speedTicker.C:
-			s.Metrics.SpeedSend = float64(s.Metrics.DataSent-s.Metrics.lastTotalSend) / time.Since(s.Metrics.timeUpdateSend).Seconds()
-			s.Metrics.timeUpdateSend = time.Now()
-			s.Metrics.lastTotalSend = s.Metrics.DataSent
-
-			s.Metrics.SpeedReceive = float64(s.Metrics.DataReceived-s.Metrics.lastTotalRcv) / time.Since(s.Metrics.timeUpdateRcv).Seconds()
-			s.Metrics.timeUpdateRcv = time.Now()
-			s.Metrics.lastTotalRcv = s.Metrics.DataReceived
+			s.metrics.updateSpeed()
 		}
 	}
 }
@@ -563,6 +498,7 @@ func (s *UDTSocket) sendHandshake(reqType packet.HandshakeReqType) {
 		MaxFlowWinSize: uint32(s.maxFlowWinSize), // maximum flow window size
 		ReqType:        reqType,
 		SockID:         s.sockID,
+		Capabilities:   s.localCapabilities(),
 	}
 
 	ts := uint32(time.Now().Sub(s.created) / time.Microsecond)
@@ -579,34 +515,46 @@ func (s *UDTSocket) checkValidHandshake(m *multiplexer, p *packet.HandshakePacke
 	return true
 }
 
+// handshakeIn processes an incoming handshake packet. Once the connection attempt started, the packet is passed to the state goroutine.
+func (s *UDTSocket) handshakeIn(m *multiplexer, p *packet.HandshakePacket) bool {
+	if s.getState() == sockStateInit {
+		return s.readHandshake(m, p)
+	}
+
+	select {
+	case s.handshakeEvent <- p:
+	default: // Drop excess handshakes. The remote peer retries.
+	}
+	return true
+}
+
 // readHandshake is received when a handshake packet is received without a destination, either as part
 // of a listening response or as a rendezvous connection
 func (s *UDTSocket) readHandshake(m *multiplexer, p *packet.HandshakePacket) bool {
-	switch s.sockState {
+	switch s.getState() {
 	case sockStateInit: // server accepting a connection from a client
 		s.initPktSeq = p.InitPktSeq
 		s.udtVer = int(p.UdtVer)
 		s.farSockID = p.SockID
 		s.isDatagram = p.SockType == packet.TypeDGRAM
+		s.remoteCapabilities = p.Capabilities
 
 		// MTU negotiation is disabled. Packets may be sent across any network adapter; it would be impossible to use a per-adapter MTU.
 		//if s.mtu.get() > p.MaxPktSize {
 		//	s.mtu.set(p.MaxPktSize)
 		//}
-		s.launchProcessors()
-		s.recv.configureHandshake(p)
-		s.send.configureHandshake(p, true)
-		s.sockState = sockStateConnected
+		s.launchProcessors(p)
+		s.setState(sockStateConnected)
 		s.connTimeout = nil
 		s.connRetry = nil
-		go s.goManageConnection()
 
 		s.sendHandshake(packet.HsResponse)
+		go s.goManageConnection()
 		return true
 
 	case sockStateConnecting: // client attempting to connect to server
 		if p.ReqType == packet.HsRefused {
-			s.sockState = sockStateRefused
+			s.shutdown(sockStateRefused, false, nil, TerminateReasonConnectRefused)
 			return true
 		}
 		if p.ReqType == packet.HsRequest {
@@ -627,16 +575,15 @@ func (s *UDTSocket) readHandshake(m *multiplexer, p *packet.HandshakePacket) boo
 			return true
 		}
 		s.farSockID = p.SockID
+		s.remoteCapabilities = p.Capabilities
 
 		// See documentation above MTU negotation above.
 		//if s.mtu.get() > p.MaxPktSize {
 		//	s.mtu.set(p.MaxPktSize)
 		//}
-		s.launchProcessors()
-		s.recv.configureHandshake(p)
-		s.send.configureHandshake(p, true)
+		s.launchProcessors(p)
 		s.connRetry = nil
-		s.sockState = sockStateConnected
+		s.setState(sockStateConnected)
 		s.connTimeout = nil
 		if s.connectWait != nil {
 			s.connectWait.Done()
@@ -682,7 +629,7 @@ func (s *UDTSocket) shutdown(sockState sockState, permitLinger bool, err error,
 		s.connectWait.Done()
 		s.connectWait = nil
 	}
-	s.sockState = sockState
+	s.setState(sockState)
 	s.cong.close()
 
 	s.connTimeout = nil
@@ -691,7 +638,11 @@ func (s *UDTSocket) shutdown(sockState sockState, permitLinger bool, err error,
 
 	s.m.closer.Close(reason)
 
-	s.messageIn <- nil
+	// Signal EOF to a blocked reader. If the buffer is full, the reader detects the closed socket via sockClosed after draining it.
+	select {
+	case s.messageIn <- nil:
+	default:
+	}
 }
 
 func absdiff(a uint, b uint) uint {
@@ -740,13 +691,13 @@ func (s *UDTSocket) getRcvSpeeds() (deliveryRate uint, bandwidth uint) {
 // Minimal processing is permitted but try not to stall the caller
 func (s *UDTSocket) readPacket(m *multiplexer, p packet.Packet) {
 	now := time.Now()
-	if s.sockState == sockStateClosed {
+	if s.getState() == sockStateClosed {
 		return
 	}
 
 	switch sp := p.(type) {
 	case *packet.HandshakePacket: // sent by both peers
-		s.readHandshake(m, sp)
+		s.handshakeIn(m, sp)
 	case *packet.ShutdownPacket: // sent by either peer
 		s.shutdownEvent <- shutdownMessage{sockState: sockStateClosed, permitLinger: s.isServer, reason: TerminateReasonRemoteSentShutdown} // if client tells us done, it is done.
 	case *packet.AckPacket, *packet.NakPacket: // receiver -> sender
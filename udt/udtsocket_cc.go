@@ -214,6 +214,10 @@ func (s *udtSocketCc) SetACKPeriod(ack time.Duration) {
 }
 
 // SetACKInterval sets the number of packets sent to the peer before sending an ACK
+// If ACKInterval is set in the config, it takes precedence.
 func (s *udtSocketCc) SetACKInterval(ack uint) {
+	if s.socket.Config.ACKInterval > 0 {
+		ack = s.socket.Config.ACKInterval
+	}
 	s.socket.recv.ackInterval.set(uint32(ack))
 }
@@ -0,0 +1,111 @@
+package udt
+
+import (
+	"time"
+
+	"github.com/PeernetOfficial/core/udt/packet"
+)
+
+/*
+Explicit congestion feedback (ECN-like) via the Congestion packet. Only used if both sides indicate CapabilityCongestionReport in the handshake.
+
+Receiver: The one-way delay of each data packet is measured as the difference between the local socket time and the send timestamp in the packet.
+The clock offset between both sockets is unknown but constant, therefore only the trend is used. The minimum delay is taken per window of
+congestionReportWindow packets. If the minimum increases between two windows, queues are building up along the path. The increase relative to the
+RTT is reported as congestion level (0 - CongestionLevelMax). Reports are sent at most once per RTT.
+
+Sender: On receiving a report, the packet send period is increased according to the response curve:
+    sndPeriod = sndPeriod * (1.125 + 0.375 * level / CongestionLevelMax)
+The rate is decreased at most once per RTT so that a single congestion event is not counted multiple times.
+*/
+
+const (
+	congestionReportWindow    = 16                             // Count of data packets per measurement window.
+	congestionReportThreshold = packet.CongestionLevelMax / 20 // Min congestion level to report (delay increase of 5% of the RTT).
+)
+
+// congestionReportsEnabled returns true if structured congestion reports were negotiated with the remote side.
+func (s *UDTSocket) congestionReportsEnabled() bool {
+	return s.Config.CongestionReports && s.remoteCapabilities&packet.CapabilityCongestionReport != 0
+}
+
+// localCapabilities returns the capabilities to indicate in the handshake.
+func (s *UDTSocket) localCapabilities() (capabilities uint32) {
+	if s.Config.CongestionReports {
+		capabilities |= packet.CapabilityCongestionReport
+	}
+	return capabilities
+}
+
+// measureDelay records the one-way delay of an incoming data packet and sends a congestion report if the delay trend indicates congestion.
+func (s *udtSocketRecv) measureDelay(p *packet.DataPacket, now time.Time) {
+	if !s.socket.congestionReportsEnabled() {
+		return
+	}
+
+	// The subtraction is done in 32 bits to handle the timestamp wrap-around.
+	delay := time.Duration(int32(uint32(now.Sub(s.socket.created)/time.Microsecond)-p.SendTime())) * time.Microsecond
+
+	if s.delayWindowCount == 0 || delay < s.delayWindowMin {
+		s.delayWindowMin = delay
+	}
+	s.delayWindowCount++
+
+	if s.delayWindowCount < congestionReportWindow {
+		return
+	}
+
+	trend := s.delayWindowMin - s.delayPreviousMin
+	hasPrevious := s.delayHasPrevious
+
+	s.delayPreviousMin = s.delayWindowMin
+	s.delayHasPrevious = true
+	s.delayWindowCount = 0
+
+	if !hasPrevious || trend <= 0 {
+		return
+	}
+
+	rttU, _ := s.socket.getRTT()
+	rtt := time.Duration(rttU) * time.Microsecond
+	if rtt <= 0 {
+		return
+	}
+
+	level := uint32(packet.CongestionLevelMax)
+	if trend < rtt {
+		level = uint32(trend * packet.CongestionLevelMax / rtt)
+	}
+
+	if level < congestionReportThreshold || now.Sub(s.congestionReportSent) < rtt {
+		return
+	}
+	s.congestionReportSent = now
+
+	s.sendPacket <- &packet.CongestionPacket{IncludeReport: true, DelayTrend: int32(trend / time.Microsecond), Level: level, Samples: congestionReportWindow}
+}
+
+// ingestCongestion is called to process a Congestion packet
+func (s *udtSocketSend) ingestCongestion(p *packet.CongestionPacket, now time.Time) {
+	if !p.IncludeReport || !s.socket.congestionReportsEnabled() {
+		// Legacy: One way packet delay is increasing, so decrease the sending rate
+		// this is very rough (not atomic, doesn't inform congestion) but this is a deprecated message in any case
+		s.sndPeriod.set(s.sndPeriod.get() * 1125 / 1000)
+		return
+	}
+
+	level := p.Level
+	if level > packet.CongestionLevelMax {
+		level = packet.CongestionLevelMax
+	}
+
+	// Decrease at most once per RTT.
+	rttU, _ := s.socket.getRTT()
+	if now.Sub(s.congestionLastDecrease) < time.Duration(rttU)*time.Microsecond {
+		return
+	}
+	s.congestionLastDecrease = now
+
+	factor := time.Duration(1125 + 375*level/packet.CongestionLevelMax)
+	s.SetPacketSendPeriod(s.sndPeriod.get() * factor / 1000)
+}
@@ -1,6 +1,7 @@
 package udt
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/PeernetOfficial/core/udt/packet"
@@ -24,14 +25,24 @@ type udtSocketRecv struct {
 	recvLastProbe      time.Time        // time of the most recent data packet probe packet
 	ackPeriod          atomicDuration   // (set by congestion control) delay between sending ACKs. Currently not used.
 	ackInterval        atomicUint32     // (set by congestion control) number of data packets to send before sending an ACK
+	ackMaxDelay        time.Duration    // maximum time an ACK may be delayed for coalescing
 	unackPktCount      uint             // number of packets we've received that we haven't sent an ACK for
+	ackPending         packet.PacketID  // delayed ACK: the ACK waiting to be sent
+	ackPendingSince    time.Time        // delayed ACK: when the first unsent ACK became pending. Zero if none pending.
 	recvPktHistory     []time.Duration  // list of recently received packets.
 	recvPktPairHistory []time.Duration  // probing packet window.
 	ackLinkInfoSent    time.Time        // when link info was sent in ACK packet last time
 	resendACKTimer     <-chan time.Time // Timer for resending outgoing ACK
-	resendACKTicker    time.Ticker      // Ticker for resending outgoing ACK
+	resendACKTicker    *time.Ticker     // Ticker for resending outgoing ACK
 	resendACKLimiter   rateLimiter      // Doubles after every resend to prevent ddos
 	resendNAKLimiter   rateLimiter      // Doubles after every resend to prevent ddos
+
+	// congestion reports
+	delayWindowMin       time.Duration // Minimum one-way delay in the current measurement window
+	delayWindowCount     int           // Count of packets in the current measurement window
+	delayPreviousMin     time.Duration // Minimum one-way delay in the previous measurement window
+	delayHasPrevious     bool          // Whether delayPreviousMin is set
+	congestionReportSent time.Time     // Last time a congestion report was sent
 }
 
 func newUdtSocketRecv(s *UDTSocket) *udtSocketRecv {
@@ -46,11 +57,18 @@ func newUdtSocketRecv(s *UDTSocket) *udtSocketRecv {
 		resendNAKLimiter: rateLimiter{MinWaitTime: s.Config.SynTime, MaxWaitTime: time.Second},
 	}
 
-	// set the timer for constantly resending ACKs for the highest sequence ID and NAKs for missing packets
-	sr.resendACKTicker = *time.NewTicker(s.Config.SynTime)
-	sr.resendACKTimer = sr.resendACKTicker.C
+	ackPeriod := s.Config.ACKPeriod
+	if ackPeriod <= 0 {
+		ackPeriod = s.Config.SynTime
+	}
+	sr.ackMaxDelay = s.Config.ACKMaxDelay
+	if sr.ackMaxDelay <= 0 {
+		sr.ackMaxDelay = ackPeriod
+	}
 
-	go sr.goReceiveEvent()
+	// set the timer for sending delayed ACKs and constantly resending ACKs for the highest sequence ID and NAKs for missing packets
+	sr.resendACKTicker = time.NewTicker(ackPeriod)
+	sr.resendACKTimer = sr.resendACKTicker.C
 
 	return sr
 }
@@ -83,8 +101,10 @@ func (s *udtSocketRecv) goReceiveEvent() {
 			return
 		case <-s.socket.terminateSignal:
 			return
-		case <-s.resendACKTimer: // handles both resending ACKs and NAKs
-			if s.recvAck2.IsLess(s.sentAck) && s.resendACKLimiter.Allow() {
+		case <-s.resendACKTimer: // handles delayed ACKs and both resending ACKs and NAKs
+			if !s.ackPendingSince.IsZero() && time.Since(s.ackPendingSince) >= s.ackMaxDelay {
+				s.flushPendingACK()
+			} else if s.recvAck2.IsLess(s.sentAck) && s.resendACKLimiter.Allow() {
 				s.sendACK(s.sentAck)
 				s.unackPktCount = 0
 			}
@@ -152,6 +172,7 @@ func (s *udtSocketRecv) ingestMsgDropReq(p *packet.MsgDropReqPacket, now time.Ti
 // ingestData is called to process a data packet
 func (s *udtSocketRecv) ingestData(p *packet.DataPacket, now time.Time) {
 	s.socket.cong.onPktRecv(*p)
+	s.measureDelay(p, now)
 
 	/* If the sequence number of the current data packet is 16n + 1,
 	where n is an integer, record the time interval between this
@@ -191,6 +212,7 @@ func (s *udtSocketRecv) ingestData(p *packet.DataPacket, now time.Time) {
 		for n := uint32(0); n < uint32(seqDiff); n++ {
 			s.recvLossList.Add(recvLossEntry{packetID: s.nextSequenceExpect.Add(int32(n))})
 		}
+		atomic.AddUint64(&s.socket.metrics.PktRcvLoss, uint64(seqDiff))
 
 		s.sendNAK(s.nextSequenceExpect.Seq, uint32(seqDiff))
 		s.nextSequenceExpect = p.Seq.Add(1)
@@ -249,7 +271,7 @@ func (s *udtSocketRecv) attemptProcessPacket(p *packet.DataPacket, isNew, ackImm
 	}
 
 	// record metrics
-	s.socket.Metrics.DataReceived += uint64(len(msg))
+	atomic.AddUint64(&s.socket.metrics.DataReceived, uint64(len(msg)))
 
 	s.messageIn <- msg
 	return true
@@ -420,6 +442,7 @@ func (s *udtSocketRecv) getRcvSpeeds() (recvSpeed, bandwidth int) {
 // sendACK sends an ACK with the given sequence number.
 func (s *udtSocketRecv) sendACK(ack packet.PacketID) {
 	s.sentAck = ack
+	s.ackPendingSince = time.Time{}
 
 	s.lastACKID++
 	s.ackHistory.Add(ackHistoryEntry{
@@ -481,11 +504,17 @@ func (s *udtSocketRecv) ackEvent(immediate bool) {
 		return
 	}
 
-	// Check if the threshold to send is reached, if used. Note that sendACK is called revery SynTime.
+	// Check if the threshold to send is reached, if used. Otherwise the ACK is delayed and coalesced with following ones.
+	// The delayed ACK is sent by resendACKTimer at the latest after ackMaxDelay.
 	if !immediate {
 		ackInterval := uint(s.ackInterval.get())
 		if (ackInterval > 0) && (ackInterval > s.unackPktCount) {
-			s.sentAck = ack // This is needed for resendACKTimer to pick it up in case no ackInterval count of packets are immediately sent.
+			s.ackPending = ack
+			if s.ackPendingSince.IsZero() {
+				s.ackPendingSince = time.Now()
+			} else if time.Since(s.ackPendingSince) >= s.ackMaxDelay {
+				s.flushPendingACK()
+			}
 			return
 		}
 	}
@@ -495,6 +524,19 @@ func (s *udtSocketRecv) ackEvent(immediate bool) {
 	s.resendACKLimiter.Reset()
 }
 
+// flushPendingACK sends the delayed ACK, if any.
+func (s *udtSocketRecv) flushPendingACK() {
+	if s.ackPendingSince.IsZero() {
+		return
+	}
+
+	if s.sentAck.IsLess(s.ackPending) {
+		s.sendACK(s.ackPending)
+	}
+	s.unackPktCount = 0
+	s.resendACKLimiter.Reset()
+}
+
 // rateLimiter is a simple helper to double resending time until reset
 // It does not rely on a Ticker which would be expensive.
 type rateLimiter struct {
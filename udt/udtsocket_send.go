@@ -2,6 +2,7 @@ package udt
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/PeernetOfficial/core/udt/packet"
@@ -24,7 +25,7 @@ type udtSocketSend struct {
 	// channels
 	sockClosed    <-chan struct{}        // closed when socket is closed
 	sendEvent     <-chan recvPktEvent    // sender: ingest the specified packet. Sender is readPacket, receiver is goSendEvent
-	messageOut    <-chan sendMessage     // outbound data messages. Sender is client caller (Write), Receiver is goSendEvent. Closed when socket is closed
+	messageOut    <-chan sendMessage     // outbound data messages. Sender is client caller (Write), Receiver is goSendEvent
 	sendPacket    chan<- packet.Packet   // send a packet out on the wire
 	shutdownEvent chan<- shutdownMessage // channel signals the connection to be shutdown
 	socket        *UDTSocket
@@ -42,6 +43,8 @@ type udtSocketSend struct {
 	flowWindowSize  uint             // negotiated maximum number of unacknowledged packets (in packets)
 	resendDataTimer <-chan time.Time // Timer for resending outgoing data packets
 	resendDataTime  time.Duration    // Doubles after every send to prevent ddos
+
+	congestionLastDecrease time.Time // Last time the send rate was decreased due to a congestion report
 }
 
 func newUdtSocketSend(s *UDTSocket) *udtSocketSend {
@@ -59,7 +62,6 @@ func newUdtSocketSend(s *UDTSocket) *udtSocketSend {
 		sendLossList:    createPacketIDHeap(),
 		resendDataTimer: make(chan time.Time),
 	}
-	go ss.goSendEvent()
 	return ss
 }
 
@@ -106,7 +108,17 @@ func (s *udtSocketSend) goSendEvent() {
 		return time.After(diff - sendPeriod)
 	}
 
+	closeSignal := s.socket.closeSignal // set to nil once Close was called
+	var closeTimeout <-chan time.Time   // fires if pending data could not be sent within the linger time after Close
+
 	for {
+		// After Close, shut down once all pending data is sent and acknowledged.
+		if closeSignal == nil && s.isFlushed() {
+			s.sendPacket <- &packet.ShutdownPacket{}
+			s.shutdownEvent <- shutdownMessage{sockState: sockStateClosed, permitLinger: !s.socket.isServer, reason: TerminateReasonSocketClosed}
+			return
+		}
+
 		// immediately send out remainder?
 		if s.sendState == sendStateSending {
 			s.processDataMsg(s.msgRemainder.content, s.msgRemainder.tim, s.msgRemainder.ttl, false)
@@ -142,14 +154,8 @@ func (s *udtSocketSend) goSendEvent() {
 
 		// wait for a channel to fire
 		select {
-		case msg, ok := <-messageOut: // nil if we can't process outgoing messages right now, which means it will not be selected
+		case msg := <-messageOut: // nil if we can't process outgoing messages right now, which means it will not be selected
 			// new message outgoing
-			if !ok {
-				s.sendPacket <- &packet.ShutdownPacket{}
-				s.shutdownEvent <- shutdownMessage{sockState: sockStateClosed, permitLinger: !s.socket.isServer, reason: TerminateReasonSocketClosed}
-				return
-			}
-
 			msg.content = s.fillDataToMTU(msg.content, messageOut) // a trick to fill up the packet immediately with data (stream only)
 
 			s.processDataMsg(msg.content, msg.tim, msg.ttl, true)
@@ -174,6 +180,21 @@ func (s *udtSocketSend) goSendEvent() {
 		case <-s.sockClosed:
 			return
 
+		case <-closeSignal:
+			closeSignal = nil
+
+			linger := s.socket.Config.LingerTime
+			if linger == 0 {
+				linger = DefaultConfig().LingerTime
+			}
+			closeTimeout = time.After(linger)
+
+		case <-closeTimeout:
+			// Pending data could not be delivered in time.
+			s.sendPacket <- &packet.ShutdownPacket{}
+			s.shutdownEvent <- shutdownMessage{sockState: sockStateClosed, permitLinger: false, reason: TerminateReasonSocketClosed}
+			return
+
 		case <-s.socket.terminateSignal:
 			s.sendPacket <- &packet.ShutdownPacket{}
 			s.shutdownEvent <- shutdownMessage{sockState: sockStateClosed, permitLinger: false, reason: TerminateReasonSignal}
@@ -192,6 +213,11 @@ func (s *udtSocketSend) goSendEvent() {
 	}
 }
 
+// isFlushed checks if all data written to the socket was sent and acknowledged by the remote peer.
+func (s *udtSocketSend) isFlushed() bool {
+	return s.msgRemainder == nil && len(s.messageOut) == 0 && s.sendPktPend.Count() == 0
+}
+
 // reevalSendState updates the send state to idle/send/wait as appropriate.
 func (s *udtSocketSend) reevalSendState() sendState {
 	// Do we have too many unacknowledged packets for us to send any more?
@@ -343,6 +369,7 @@ func (s *udtSocketSend) processSendLoss() bool {
 		}
 
 		// resend the packet
+		atomic.AddUint64(&s.socket.metrics.PktRetrans, 1)
 		s.socket.cong.onDataPktSent(dp.pkt.Seq)
 		s.sendPacket <- dp.pkt
 	}
@@ -484,6 +511,7 @@ func (s *udtSocketSend) ingestNak(p *packet.NakPacket, now time.Time) {
 		}
 	}
 
+	atomic.AddUint64(&s.socket.metrics.PktSndLoss, uint64(len(lossList)))
 	s.socket.cong.onNAK(lossList)
 
 	// Some loss entries may be discarded if out of date (already ACK received), so make sure loss list contains entries before changing the sending state.
@@ -494,11 +522,3 @@ func (s *udtSocketSend) ingestNak(p *packet.NakPacket, now time.Time) {
 		s.resendDataTimer = make(chan time.Time)
 	}
 }
-
-// ingestCongestion is called to process a (retired?) Congestion packet
-func (s *udtSocketSend) ingestCongestion(p *packet.CongestionPacket, now time.Time) {
-	// One way packet delay is increasing, so decrease the sending rate
-	// this is very rough (not atomic, doesn't inform congestion) but this is a deprecated message in any case
-	s.sndPeriod.set(s.sndPeriod.get() * 1125 / 1000)
-	//m_iLastDecSeq = s.sendPktSeq
-}
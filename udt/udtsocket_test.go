@@ -0,0 +1,262 @@
+package udt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type testCloser struct{}
+
+func (testCloser) Close(reason int) error       { return nil }
+func (testCloser) CloseLinger(reason int) error { return nil }
+
+type testAddrCloser struct {
+	testCloser
+	local, remote net.Addr
+}
+
+func (c testAddrCloser) LocalAddr() net.Addr  { return c.local }
+func (c testAddrCloser) RemoteAddr() net.Addr { return c.remote }
+
+// testSocketPair connects a client and server socket via channels.
+func testSocketPair(t *testing.T) (client, server *UDTSocket, terminate chan struct{}) {
+	config := DefaultConfig()
+	config.MaxPacketSize = 1400
+	config.LingerTime = time.Second
+
+	clientToServer := make(chan []byte, 1024)
+	serverToClient := make(chan []byte, 1024)
+	terminate = make(chan struct{})
+
+	listener := ListenUDT(config, testCloser{}, clientToServer, serverToClient, terminate)
+
+	client, err := DialUDT(config, testCloser{}, serverToClient, clientToServer, terminate, true)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	if server, err = listener.Accept(); err != nil {
+		t.Fatalf("accept: %s", err)
+	}
+
+	return client, server, terminate
+}
+
+func TestSocketDeadline(t *testing.T) {
+	deadline := newSocketDeadline()
+
+	deadline.set(time.Now().Add(-time.Second))
+	if !isClosedChan(deadline.wait()) {
+		t.Fatal("deadline in the past did not pass")
+	}
+
+	deadline.set(time.Time{})
+	if isClosedChan(deadline.wait()) {
+		t.Fatal("cleared deadline is still passed")
+	}
+
+	deadline.set(time.Now().Add(20 * time.Millisecond))
+	select {
+	case <-deadline.wait():
+	case <-time.After(time.Second):
+		t.Fatal("deadline did not pass")
+	}
+
+	// A timer that fires while the deadline is moved must not close the new channel.
+	for n := 0; n < 100; n++ {
+		deadline.set(time.Now().Add(time.Microsecond))
+		deadline.set(time.Now().Add(time.Hour))
+		if isClosedChan(deadline.wait()) {
+			t.Fatal("old timer closed the new deadline")
+		}
+	}
+	deadline.set(time.Time{})
+}
+
+func TestSocketReadDeadline(t *testing.T) {
+	client, server, terminate := testSocketPair(t)
+	defer close(terminate)
+
+	buffer := make([]byte, 100)
+
+	server.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := server.Read(buffer); !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	// Extend the deadline concurrently while Read is blocked.
+	server.SetReadDeadline(time.Now().Add(time.Hour))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	}()
+	if _, err := server.Read(buffer); !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	// Clearing the deadline allows reading again.
+	server.SetReadDeadline(time.Time{})
+	if _, err := client.Write([]byte("test")); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if n, err := server.Read(buffer); err != nil || string(buffer[:n]) != "test" {
+		t.Fatalf("read: %d %v", n, err)
+	}
+}
+
+func TestSocketCloseWrite(t *testing.T) {
+	client, server, terminate := testSocketPair(t)
+	defer close(terminate)
+
+	data := bytes.Repeat([]byte{1}, 1000)
+
+	// Write concurrently with Close. Writes that succeed must arrive at the remote side.
+	var wg sync.WaitGroup
+	var writtenMutex sync.Mutex
+	var written int
+
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := 0; m < 100; m++ {
+				n, err := client.Write(data)
+				if err != nil {
+					if !errors.Is(err, ErrSocketClosed) {
+						t.Errorf("unexpected write error: %s", err)
+					}
+					return
+				}
+				writtenMutex.Lock()
+				written += n
+				writtenMutex.Unlock()
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+	client.Close()
+	wg.Wait()
+
+	if _, err := client.Write(data); !errors.Is(err, ErrSocketClosed) {
+		t.Fatalf("write after close: %v", err)
+	}
+
+	received, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	// Writes racing with Close may be discarded, but all data written before Close returned must be received.
+	if len(received) == 0 || len(received) > written {
+		t.Fatalf("received %d bytes, written %d", len(received), written)
+	}
+}
+
+func TestSocketTerminate(t *testing.T) {
+	client, server, terminate := testSocketPair(t)
+	defer close(terminate)
+
+	readResult := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(server)
+		readResult <- err
+	}()
+
+	// Terminate concurrently with Close and Write.
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() { defer wg.Done(); client.Terminate() }()
+	go func() { defer wg.Done(); client.Close() }()
+	go func() { defer wg.Done(); client.Write([]byte("test")) }()
+	wg.Wait()
+
+	server.Terminate()
+
+	select {
+	case err := <-readResult:
+		if err != nil {
+			t.Fatalf("read: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked read did not return after terminate")
+	}
+
+	if _, err := server.Write([]byte("test")); err == nil {
+		t.Fatal("write after terminate succeeded")
+	}
+}
+
+func TestSocketAddr(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxPacketSize = 1400
+
+	clientToServer := make(chan []byte, 1024)
+	serverToClient := make(chan []byte, 1024)
+	terminate := make(chan struct{})
+	defer close(terminate)
+
+	addrClient := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	addrServer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	listener := ListenUDT(config, testAddrCloser{local: addrServer, remote: addrClient}, clientToServer, serverToClient, terminate)
+
+	client, err := DialUDT(config, testAddrCloser{local: addrClient, remote: addrServer}, serverToClient, clientToServer, terminate, true)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %s", err)
+	}
+
+	if client.LocalAddr() != addrClient || client.RemoteAddr() != addrServer || server.LocalAddr() != addrServer || server.RemoteAddr() != addrClient {
+		t.Fatal("socket addresses mismatch")
+	}
+}
+
+func TestSocketMetrics(t *testing.T) {
+	client, server, terminate := testSocketPair(t)
+	defer close(terminate)
+
+	data := bytes.Repeat([]byte{1}, 100*1000)
+
+	// Read concurrently with the snapshots taken while data is transferred.
+	readResult := make(chan int, 1)
+	go func() {
+		received, _ := io.ReadAll(server)
+		readResult <- len(received)
+	}()
+
+	for n := 0; n < 100; n++ {
+		if _, err := client.Write(data[n*1000 : (n+1)*1000]); err != nil {
+			t.Fatalf("write: %s", err)
+		}
+		client.Metrics()
+		server.Metrics()
+	}
+	client.Close()
+
+	if received := <-readResult; received != len(data) {
+		t.Fatalf("received %d bytes, written %d", received, len(data))
+	}
+
+	sent, recv := client.Metrics(), server.Metrics()
+	if sent.DataSent != uint64(len(data)) || recv.DataReceived != uint64(len(data)) {
+		t.Fatalf("data sent %d, received %d, expected %d", sent.DataSent, recv.DataReceived, len(data))
+	}
+	if sent.PktSentData == 0 || recv.PktRecvData == 0 || recv.PktSentACK == 0 {
+		t.Fatalf("packet counts not recorded: sent %d, received %d, ACKs %d", sent.PktSentData, recv.PktRecvData, recv.PktSentACK)
+	}
+	if sent.SendLossRate < 0 || sent.SendLossRate > 1 || recv.RecvLossRate < 0 || recv.RecvLossRate > 1 {
+		t.Fatalf("invalid loss rates %f, %f", sent.SendLossRate, recv.RecvLossRate)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+File Username:  Access.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+Access tracking of files for eviction policies. The last access is persisted as modification time of the file, which is updated at most once
+per accessTouchInterval to limit disk writes. The count of reads is only kept in memory since the warehouse was initialized.
+*/
+
+package warehouse
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// accessTouchInterval is the min interval to update the modification time of a file on read.
+const accessTouchInterval = time.Hour
+
+type fileAccess struct {
+	counts map[[hashSize]byte]uint64 // Count of reads per file.
+	sync.Mutex
+}
+
+func newFileAccess() *fileAccess {
+	return &fileAccess{counts: make(map[[hashSize]byte]uint64)}
+}
+
+// recordAccess records a read of the file.
+func (wh *Warehouse) recordAccess(hash []byte, file *os.File) {
+	if wh.access == nil {
+		return
+	}
+
+	var key [hashSize]byte
+	copy(key[:], hash)
+
+	wh.access.Lock()
+	wh.access.counts[key]++
+	wh.access.Unlock()
+
+	if info, err := file.Stat(); err == nil && time.Since(info.ModTime()) >= accessTouchInterval {
+		now := time.Now()
+		os.Chtimes(file.Name(), now, now)
+	}
+}
+
+// removeAccess removes the access count of a deleted file.
+func (wh *Warehouse) removeAccess(hash []byte) {
+	if wh.access == nil {
+		return
+	}
+
+	var key [hashSize]byte
+	copy(key[:], hash)
+
+	wh.access.Lock()
+	delete(wh.access.counts, key)
+	wh.access.Unlock()
+}
+
+// FileAccess returns the last time the file was read or created (with a precision of accessTouchInterval) and the count of reads since the
+// warehouse was initialized. It returns StatusInvalidHash, StatusFileNotFound, or StatusOK.
+func (wh *Warehouse) FileAccess(hash []byte) (lastAccess time.Time, count uint64, status int) {
+	hashA, err := ValidateHash(hash)
+	if err != nil {
+		return lastAccess, 0, StatusInvalidHash
+	} else if wh.Disabled {
+		return lastAccess, 0, StatusFileNotFound
+	}
+
+	a, b := buildPath(wh.Directory, hashA)
+	info, err := os.Stat(filepath.Join(a, b))
+	if err != nil {
+		return lastAccess, 0, StatusFileNotFound
+	}
+
+	var key [hashSize]byte
+	copy(key[:], hash)
+
+	wh.access.Lock()
+	count = wh.access.counts[key]
+	wh.access.Unlock()
+
+	return info.ModTime(), count, StatusOK
+}
@@ -0,0 +1,279 @@
+/*
+File Username:  Directory.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+Directory manifests describe a folder snapshot. They are stored in the warehouse like any other file and are addressed by their hash.
+Sub directories are referenced by the hash of their own manifest, so the hash of the root manifest covers the entire tree.
+
+Offset  Size   Info
+0       1      Version, currently 0
+1       4      Count of entries
+
+Each entry, sorted by name (byte order):
+Offset  Size   Info
+0       1      Type: 0 = File, 1 = Directory
+1       32     Hash of the file, or of the manifest of the sub directory
+33      8      Size of the file, or total size of all files in the sub directory
+41      2      Length of the name
+43      ?      Name (UTF-8), without any path separator
+*/
+
+package warehouse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirectoryEntry is an entry in a directory manifest.
+type DirectoryEntry struct {
+	Name        string // Name of the file or sub directory
+	Hash        []byte // Hash of the file, or of the manifest of the sub directory
+	Size        uint64 // Size of the file, or total size of all files in the sub directory
+	IsDirectory bool   // Whether the entry is a sub directory
+}
+
+// DirectoryMaxSize is the max size of a single directory manifest.
+const DirectoryMaxSize = 16 * 1024 * 1024
+
+// isValidDirectoryName checks if the name is valid as directory entry.
+func isValidDirectoryName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= 0xFFFF && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// EncodeDirectory encodes a directory manifest. The entries are sorted by name. Names must be unique.
+func EncodeDirectory(entries []DirectoryEntry) (data []byte, err error) {
+	sorted := make([]DirectoryEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	data = make([]byte, 5)
+	binary.LittleEndian.PutUint32(data[1:5], uint32(len(sorted)))
+
+	for n, entry := range sorted {
+		if !isValidDirectoryName(entry.Name) || len(entry.Hash) != hashSize {
+			return nil, errors.New("invalid directory entry")
+		} else if n > 0 && sorted[n-1].Name == entry.Name {
+			return nil, errors.New("duplicate directory entry")
+		}
+
+		var header [43]byte
+		if entry.IsDirectory {
+			header[0] = 1
+		}
+		copy(header[1:33], entry.Hash)
+		binary.LittleEndian.PutUint64(header[33:41], entry.Size)
+		binary.LittleEndian.PutUint16(header[41:43], uint16(len(entry.Name)))
+
+		data = append(data, header[:]...)
+		data = append(data, []byte(entry.Name)...)
+	}
+
+	if len(data) > DirectoryMaxSize {
+		return nil, errors.New("directory manifest exceeds max size")
+	}
+
+	return data, nil
+}
+
+// DecodeDirectory decodes a directory manifest. Only the canonical encoding (sorted unique names) is accepted.
+func DecodeDirectory(data []byte) (entries []DirectoryEntry, err error) {
+	if len(data) < 5 || data[0] != 0 {
+		return nil, errors.New("invalid directory manifest")
+	}
+
+	count := binary.LittleEndian.Uint32(data[1:5])
+	index := 5
+
+	for n := uint32(0); n < count; n++ {
+		if index+43 > len(data) {
+			return nil, errors.New("invalid directory manifest")
+		}
+
+		entry := DirectoryEntry{IsDirectory: data[index] == 1, Hash: data[index+1 : index+33]}
+		entry.Size = binary.LittleEndian.Uint64(data[index+33 : index+41])
+		nameLength := int(binary.LittleEndian.Uint16(data[index+41 : index+43]))
+
+		if data[index] > 1 || index+43+nameLength > len(data) {
+			return nil, errors.New("invalid directory manifest")
+		}
+		entry.Name = string(data[index+43 : index+43+nameLength])
+		index += 43 + nameLength
+
+		if !isValidDirectoryName(entry.Name) || len(entries) > 0 && entries[len(entries)-1].Name >= entry.Name {
+			return nil, errors.New("invalid directory manifest entry")
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if index != len(data) {
+		return nil, errors.New("invalid directory manifest")
+	}
+
+	return entries, nil
+}
+
+// CreateDirectory stores a directory manifest in the warehouse. The referenced files are not checked.
+func (wh *Warehouse) CreateDirectory(entries []DirectoryEntry) (hash []byte, status int, err error) {
+	data, err := EncodeDirectory(entries)
+	if err != nil {
+		return nil, StatusInvalidDirectory, err
+	}
+
+	return wh.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+}
+
+// CreateDirectoryFromPath stores all files in the directory and its sub directories in the warehouse, including the manifests.
+// Anything other than regular files and directories is skipped. Size is the total size of all files.
+func (wh *Warehouse) CreateDirectoryFromPath(path string) (hash []byte, size uint64, status int, err error) {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, 0, StatusErrorOpenFile, err
+	}
+
+	var entries []DirectoryEntry
+
+	for _, file := range files {
+		entry := DirectoryEntry{Name: file.Name(), IsDirectory: file.IsDir()}
+
+		if file.IsDir() {
+			if entry.Hash, entry.Size, status, err = wh.CreateDirectoryFromPath(filepath.Join(path, file.Name())); status != StatusOK {
+				return nil, 0, status, err
+			}
+		} else if file.Type().IsRegular() {
+			if entry.Hash, status, err = wh.CreateFileFromPath(filepath.Join(path, file.Name())); status != StatusOK {
+				return nil, 0, status, err
+			}
+			if info, err := file.Info(); err == nil {
+				entry.Size = uint64(info.Size())
+			}
+		} else {
+			continue
+		}
+
+		size += entry.Size
+		entries = append(entries, entry)
+	}
+
+	hash, status, err = wh.CreateDirectory(entries)
+
+	return hash, size, status, err
+}
+
+// ReadDirectory reads and decodes a directory manifest from the warehouse.
+func (wh *Warehouse) ReadDirectory(hash []byte) (entries []DirectoryEntry, status int, err error) {
+	_, fileSize, status, err := wh.FileExists(hash)
+	if status != StatusOK {
+		return nil, status, err
+	} else if fileSize > DirectoryMaxSize {
+		return nil, StatusInvalidDirectory, errors.New("directory manifest exceeds max size")
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, fileSize))
+	if status, _, err = wh.ReadFile(hash, 0, 0, buffer); status != StatusOK {
+		return nil, status, err
+	}
+
+	if entries, err = DecodeDirectory(buffer.Bytes()); err != nil {
+		return nil, StatusInvalidDirectory, err
+	}
+
+	return entries, StatusOK, nil
+}
+
+// DirectoryFiles returns the hashes of all files and manifests in the tree, including the root manifest.
+// It stops at the first manifest that is not available and returns its status.
+func (wh *Warehouse) DirectoryFiles(hash []byte) (hashes [][]byte, status int, err error) {
+	hashes = append(hashes, hash)
+
+	entries, status, err := wh.ReadDirectory(hash)
+	if status != StatusOK {
+		return hashes, status, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDirectory {
+			hashes = append(hashes, entry.Hash)
+			continue
+		}
+
+		sub, status, err := wh.DirectoryFiles(entry.Hash)
+		hashes = append(hashes, sub...)
+		if status != StatusOK {
+			return hashes, status, err
+		}
+	}
+
+	return hashes, StatusOK, nil
+}
+
+// ReadDirectoryToDisk writes the directory tree to the target directory, which must not exist.
+// All files must be in the warehouse. The tree is first written to a temporary directory next to the target and then renamed, so the target appears atomically.
+func (wh *Warehouse) ReadDirectoryToDisk(hash []byte, target string) (status int, err error) {
+	if _, err := os.Stat(target); err == nil {
+		return StatusErrorTargetExists, nil
+	}
+
+	// verify that all files are available before writing anything
+	hashes, status, err := wh.DirectoryFiles(hash)
+	if status != StatusOK {
+		return status, err
+	}
+	for _, fileHash := range hashes {
+		if _, _, status, err = wh.FileExists(fileHash); status != StatusOK {
+			return status, err
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return StatusErrorCreateTarget, err
+	}
+
+	temp, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+"-")
+	if err != nil {
+		return StatusErrorCreateTarget, err
+	}
+
+	if status, err = wh.writeDirectory(hash, temp); status != StatusOK {
+		os.RemoveAll(temp)
+		return status, err
+	}
+
+	if err = os.Rename(temp, target); err != nil {
+		os.RemoveAll(temp)
+		return StatusErrorCreateTarget, err
+	}
+
+	return StatusOK, nil
+}
+
+func (wh *Warehouse) writeDirectory(hash []byte, target string) (status int, err error) {
+	entries, status, err := wh.ReadDirectory(hash)
+	if status != StatusOK {
+		return status, err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(target, entry.Name)
+
+		if entry.IsDirectory {
+			if err = os.Mkdir(path, os.ModePerm); err != nil {
+				return StatusErrorCreateTarget, err
+			}
+			if status, err = wh.writeDirectory(entry.Hash, path); status != StatusOK {
+				return status, err
+			}
+		} else if status, _, err = wh.ReadFileToDisk(entry.Hash, 0, 0, path); status != StatusOK {
+			return status, err
+		}
+	}
+
+	return StatusOK, nil
+}
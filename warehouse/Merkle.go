@@ -23,6 +23,8 @@ func (wh *Warehouse) MerkleFileExists(hash []byte) (path string, fileSize uint64
 	hashA, err := ValidateHash(hash)
 	if err != nil {
 		return "", 0, StatusInvalidHash, err
+	} else if wh.Disabled {
+		return "", 0, StatusFileNotFound, os.ErrNotExist
 	}
 
 	a, b := buildPath(wh.Directory, hashA)
@@ -61,24 +63,30 @@ func (wh *Warehouse) createMerkleCompanionFile(dataFilePath string) (status int,
 		return StatusOK, nil
 	}
 
-	// Create a new merkle file. If one exists, overwrite.
+	// create the merkle tree and write it to the companion file
+	fragmentSize := merkle.CalculateFragmentSize(fileSize)
+	tree, err := merkle.NewMerkleTree(fileSize, fragmentSize, dataFile)
+	if err != nil {
+		return StatusErrorCreateMerkle, err
+	}
+
+	return wh.writeMerkleCompanionFile(dataFilePath, tree)
+}
+
+// writeMerkleCompanionFile writes the merkle tree to the companion file. If one exists, it is overwritten.
+func (wh *Warehouse) writeMerkleCompanionFile(dataFilePath string, tree *merkle.MerkleTree) (status int, err error) {
 	merkleFile := dataFilePath + merkleCompanionExt
 
-	fileM, err := os.OpenFile(merkleFile, os.O_WRONLY|os.O_CREATE, 0666) // 666 = All uses can read/write
+	fileM, err := os.OpenFile(merkleFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666) // 666 = All uses can read/write
 	if err != nil {
 		return StatusErrorCreateTarget, err
 	}
 	defer fileM.Close()
 
-	// create the merkle tree and write it to the companion file
-	fragmentSize := merkle.CalculateFragmentSize(fileSize)
-	tree, err := merkle.NewMerkleTree(fileSize, fragmentSize, dataFile)
-	if err != nil {
+	if _, err = fileM.Write(tree.Export()); err != nil {
 		return StatusErrorCreateMerkle, err
 	}
 
-	fileM.Write(tree.Export())
-
 	return StatusOK, nil
 }
 
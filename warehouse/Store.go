@@ -7,6 +7,7 @@ Author:     Peter Kleissner
 package warehouse
 
 import (
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -34,11 +35,18 @@ const (
 	StatusErrorCreateTarget   = 14 // Error creating target file.
 	StatusErrorCreateMerkle   = 15 // Error creating merkle tree.
 	StatusErrorMerkleTreeFile = 16 // Invalid merkle tree companion file.
+	StatusInvalidDirectory    = 17 // Invalid directory manifest.
+	StatusDisabled            = 18 // The warehouse is disabled.
+	StatusCorrupt             = 19 // The file data does not match the hash.
 )
 
 // CreateFile creates a new file in the warehouse
 // If fileSize is provided, creating the merkle tree is significantly faster as it will be created on the fly. If the file size is unknown, set the size to 0.
 func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io.Writer) (hash []byte, status int, err error) {
+	if wh.Disabled {
+		return nil, StatusDisabled, errors.New("warehouse is disabled")
+	}
+
 	// create a temporary file to hold the body content
 	tmpFile, err := wh.tempFile()
 	if err != nil {
@@ -47,23 +55,26 @@ func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io
 
 	tmpFileName := tmpFile.Name()
 
-	// create merkle tree in parallel if the file size is known (which means the fragment size can be calculated)
-	if fileSize > 0 {
-		// TODO
-	}
-
 	// create the hash-writer
 	hashWriter := blake3.New(hashSize, nil)
 
-	var mw io.Writer
+	// the multi-writer writes to the temp-file and the hash simultaneously
+	writers := []io.Writer{tmpFile, hashWriter}
+
+	// create merkle tree in parallel if the file size is known (which means the fragment size can be calculated)
+	var treeWriter *merkle.TreeWriter
+	if fileSize > merkle.MinimumFragmentSize {
+		if treeWriter, err = merkle.NewTreeWriter(fileSize, merkle.CalculateFragmentSize(fileSize)); err == nil {
+			writers = append(writers, treeWriter)
+		}
+	}
 
 	if uploadStatus != nil {
-		// the multi-writer writes to the temp-file and the hash simultaneously
-		mw = io.MultiWriter(tmpFile, hashWriter, uploadStatus)
-	} else {
-		mw = io.MultiWriter(tmpFile, hashWriter)
+		writers = append(writers, uploadStatus)
 	}
 
+	mw := io.MultiWriter(writers...)
+
 	// copy into the multiwriter
 	if _, err = io.Copy(mw, data); err != nil {
 		tmpFile.Close()
@@ -109,8 +120,17 @@ func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io
 			}
 		}
 
-		// create the merkle tree companion file
-		if fileSize == 0 || fileSize > merkle.MinimumFragmentSize {
+		// Write the merkle tree companion file. If the tree was not created on the fly (or the provided file size was wrong), the file is read again.
+		var tree *merkle.MerkleTree
+		if treeWriter != nil {
+			tree, _ = treeWriter.Finalize()
+		}
+
+		if tree != nil {
+			if status, err = wh.writeMerkleCompanionFile(pathFull, tree); status != StatusOK {
+				return hash, status, err
+			}
+		} else if fileSize == 0 || fileSize > merkle.MinimumFragmentSize {
 			if status, err = wh.createMerkleCompanionFile(pathFull); status != StatusOK {
 				return hash, status, err
 			}
@@ -120,6 +140,31 @@ func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io
 	return hash, StatusOK, nil
 }
 
+// CreateFileStream creates a new file in the warehouse by streaming it from the reader without buffering it in memory.
+// Size is the expected file size (0 if unknown). If it is provided, the hash and the merkle tree are created while the data is written.
+// The optional progress callback is called after each chunk is hashed and written to disk with the total count of bytes written so far.
+// It is called from the calling goroutine.
+func (wh *Warehouse) CreateFileStream(reader io.Reader, size uint64, progress func(written uint64)) (hash []byte, status int, err error) {
+	if progress == nil {
+		return wh.CreateFile(reader, size, nil)
+	}
+
+	return wh.CreateFile(reader, size, &progressWriter{callback: progress})
+}
+
+// progressWriter reports the total count of bytes written to the callback.
+type progressWriter struct {
+	written  uint64
+	callback func(written uint64)
+}
+
+func (writer *progressWriter) Write(p []byte) (n int, err error) {
+	writer.written += uint64(len(p))
+	writer.callback(writer.written)
+
+	return len(p), nil
+}
+
 // CreateFileFromPath creates a file from an existing file path.
 // Warning: An attacker could supply any local file using this function, put them into storage and read them! No input path verification or limitation is done.
 func (wh *Warehouse) CreateFileFromPath(file string) (hash []byte, status int, err error) {
@@ -150,6 +195,8 @@ func (wh *Warehouse) ReadFile(hash []byte, offset, limit int64, writer io.Writer
 	hashA, err := ValidateHash(hash)
 	if err != nil {
 		return StatusInvalidHash, 0, err
+	} else if wh.Disabled {
+		return StatusFileNotFound, 0, os.ErrNotExist
 	}
 
 	a, b := buildPath(wh.Directory, hashA)
@@ -177,6 +224,8 @@ retryOpenFile:
 	}
 	defer file.Close()
 
+	wh.recordAccess(hash, file)
+
 	reader = file
 
 	// seek to offset, if provided
@@ -212,6 +261,8 @@ func (wh *Warehouse) DeleteFile(hash []byte) (status int, err error) {
 		return StatusErrorDeleteFile, err
 	}
 
+	wh.removeAccess(hash)
+
 	return StatusOK, nil
 }
 
@@ -220,6 +271,8 @@ func (wh *Warehouse) FileExists(hash []byte) (path string, fileSize uint64, stat
 	hashA, err := ValidateHash(hash)
 	if err != nil {
 		return "", 0, StatusInvalidHash, err
+	} else if wh.Disabled {
+		return "", 0, StatusFileNotFound, os.ErrNotExist
 	}
 
 	a, b := buildPath(wh.Directory, hashA)
@@ -246,6 +299,10 @@ func (wh *Warehouse) DeleteWarehouse() (err error) {
 // Offset is the position in the file to start reading. Limit (0 = not used) defines how many bytes to read starting at the offset.
 // Return status codes: StatusInvalidHash, StatusFileNotFound, StatusErrorTargetExists, StatusErrorCreateTarget, StatusErrorOpenFile, StatusErrorSeekFile, StatusErrorReadFile, StatusOK
 func (wh *Warehouse) ReadFileToDisk(hash []byte, offset, limit int64, fileTarget string) (status int, bytesRead int64, err error) {
+	if wh.Disabled {
+		return StatusFileNotFound, 0, os.ErrNotExist
+	}
+
 	// check if the target file already exist
 	if _, err := os.Stat(fileTarget); err == nil {
 		return StatusErrorTargetExists, 0, nil
@@ -0,0 +1,144 @@
+package warehouse
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PeernetOfficial/core/merkle"
+	"lukechampine.com/blake3"
+)
+
+func testWarehouse(t *testing.T) (wh *Warehouse) {
+	wh, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error initializing warehouse: %s", err.Error())
+	}
+	return wh
+}
+
+func testData(t *testing.T, size int) (data []byte) {
+	data = make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCreateFileStream(t *testing.T) {
+	wh := testWarehouse(t)
+	data := testData(t, 3*merkle.MinimumFragmentSize+100)
+
+	var progress []uint64
+	hash, status, err := wh.CreateFileStream(bytes.NewReader(data), uint64(len(data)), func(written uint64) {
+		progress = append(progress, written)
+	})
+	if status != StatusOK || err != nil {
+		t.Fatalf("Error creating file (status %d): %v", status, err)
+	}
+
+	expected := blake3.Sum256(data)
+	if !bytes.Equal(hash, expected[:]) {
+		t.Fatal("Hash mismatch")
+	}
+
+	// The progress must be increasing and end at the file size.
+	if len(progress) == 0 || progress[len(progress)-1] != uint64(len(data)) {
+		t.Fatalf("Unexpected progress %v", progress)
+	}
+	for n := 1; n < len(progress); n++ {
+		if progress[n] <= progress[n-1] {
+			t.Fatalf("Progress not increasing: %v", progress)
+		}
+	}
+
+	// The merkle tree created on the fly must match the one created from the data.
+	tree, status, err := wh.ReadMerkleTree(hash, false)
+	if status != StatusOK {
+		t.Fatalf("Error reading merkle tree (status %d): %v", status, err)
+	}
+	expectedTree, _ := merkle.NewMerkleTree(uint64(len(data)), merkle.CalculateFragmentSize(uint64(len(data))), bytes.NewReader(data))
+	if !bytes.Equal(tree.Export(), expectedTree.Export()) {
+		t.Fatal("Merkle tree mismatch")
+	}
+
+	var buffer bytes.Buffer
+	if status, _, err := wh.ReadFile(hash, 0, 0, &buffer); status != StatusOK || !bytes.Equal(buffer.Bytes(), data) {
+		t.Fatalf("Error reading file (status %d): %v", status, err)
+	}
+}
+
+func TestCreateFileSizeMismatch(t *testing.T) {
+	wh := testWarehouse(t)
+	data := testData(t, 2*merkle.MinimumFragmentSize)
+
+	// A wrong file size must not result in an invalid merkle tree.
+	hash, status, err := wh.CreateFileStream(bytes.NewReader(data), uint64(len(data))+1, nil)
+	if status != StatusOK {
+		t.Fatalf("Error creating file (status %d): %v", status, err)
+	}
+
+	tree, status, err := wh.ReadMerkleTree(hash, false)
+	if status != StatusOK {
+		t.Fatalf("Error reading merkle tree (status %d): %v", status, err)
+	}
+	if tree.FileSize != uint64(len(data)) {
+		t.Fatalf("Unexpected merkle tree file size %d", tree.FileSize)
+	}
+}
+
+func TestVerifyQuarantine(t *testing.T) {
+	wh := testWarehouse(t)
+	data := testData(t, 2*merkle.MinimumFragmentSize)
+
+	hash, status, err := wh.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+	if status != StatusOK {
+		t.Fatalf("Error creating file (status %d): %v", status, err)
+	}
+
+	if status, err := wh.VerifyFile(hash, 0); status != StatusOK {
+		t.Fatalf("Valid file not verified (status %d): %v", status, err)
+	}
+
+	// Corrupt a single byte in the stored file.
+	path, _, _, _ := wh.FileExists(hash)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte{data[1000] ^ 0xFF}, 1000)
+	file.Close()
+
+	if status, _ := wh.VerifyFile(hash, 0); status != StatusCorrupt {
+		t.Fatalf("Corrupt file not detected (status %d)", status)
+	}
+	if status, _ := wh.VerifyFile(hash, 1024*1024); status != StatusCorrupt {
+		t.Fatalf("Corrupt file not detected with rate limit (status %d)", status)
+	}
+
+	pathQuarantine, status, err := wh.QuarantineFile(hash)
+	if status != StatusOK {
+		t.Fatalf("Error quarantining file (status %d): %v", status, err)
+	}
+	if expected := filepath.Join(wh.Directory, "_Quarantine", hex.EncodeToString(hash)); pathQuarantine != expected {
+		t.Fatalf("Unexpected quarantine path '%s'", pathQuarantine)
+	}
+	if _, err := os.Stat(pathQuarantine); err != nil {
+		t.Fatalf("Quarantined file missing: %v", err)
+	}
+
+	// The file must no longer be served.
+	if _, _, status, _ := wh.FileExists(hash); status != StatusFileNotFound {
+		t.Fatalf("Quarantined file still exists (status %d)", status)
+	}
+	if status, _, _ := wh.ReadFile(hash, 0, 0, io.Discard); status != StatusFileNotFound {
+		t.Fatalf("Quarantined file still served (status %d)", status)
+	}
+	if _, _, status, _ := wh.MerkleFileExists(hash); status != StatusFileNotFound {
+		t.Fatalf("Merkle companion file of quarantined file still exists (status %d)", status)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+File Username:  Verify.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+Verification of stored files against their hash, to detect bit rot or tampering. Corrupt files are moved to the quarantine folder "_Quarantine",
+so they are no longer served but remain available for inspection.
+*/
+
+package warehouse
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lukechampine.com/blake3"
+)
+
+// verifyChunkSize is the size of the chunks read when verifying a file at a limited rate.
+const verifyChunkSize = 1024 * 1024
+
+// VerifyFile reads the file and checks if the data matches the hash. Rate is the max read rate in bytes per second (0 = unlimited), to
+// limit the impact on other disk IO. It returns StatusOK if the data is valid and StatusCorrupt if not.
+// Other return status codes: StatusInvalidHash, StatusFileNotFound, StatusErrorOpenFile, StatusErrorReadFile
+func (wh *Warehouse) VerifyFile(hash []byte, rate uint64) (status int, err error) {
+	path, _, status, err := wh.FileExists(hash)
+	if status != StatusOK {
+		return status, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return StatusErrorOpenFile, err
+	}
+	defer file.Close()
+
+	hashWriter := blake3.New(hashSize, nil)
+
+	if rate == 0 {
+		if _, err = io.Copy(hashWriter, file); err != nil {
+			return StatusErrorReadFile, err
+		}
+	} else {
+		chunkDuration := time.Duration(float64(verifyChunkSize) / float64(rate) * float64(time.Second))
+
+		for {
+			start := time.Now()
+
+			n, err := io.CopyN(hashWriter, file, verifyChunkSize)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return StatusErrorReadFile, err
+			} else if n < verifyChunkSize {
+				break
+			}
+
+			if wait := chunkDuration - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+
+	if !bytes.Equal(hashWriter.Sum(nil), hash) {
+		return StatusCorrupt, errors.New("file data does not match the hash")
+	}
+
+	return StatusOK, nil
+}
+
+// QuarantineFile moves the file to the quarantine folder. Its merkle companion file is deleted. The path is the new location of the file.
+// An existing file with the same hash in the quarantine folder is replaced.
+// Return status codes: StatusInvalidHash, StatusFileNotFound, StatusErrorCreatePath, StatusErrorRenameTempFile, StatusOK
+func (wh *Warehouse) QuarantineFile(hash []byte) (path string, status int, err error) {
+	pathOld, _, status, err := wh.FileExists(hash)
+	if status != StatusOK {
+		return "", status, err
+	}
+
+	folder := filepath.Join(wh.Directory, "_Quarantine")
+	if err = createDirectory(folder); err != nil {
+		return "", StatusErrorCreatePath, err
+	}
+
+	path = filepath.Join(folder, hex.EncodeToString(hash))
+	if err = os.Rename(pathOld, path); err != nil {
+		return "", StatusErrorRenameTempFile, err
+	}
+
+	os.Remove(pathOld + merkleCompanionExt)
+	wh.removeAccess(hash)
+
+	return path, StatusOK, nil
+}
@@ -20,14 +20,16 @@ const (
 
 // Warehouse represents a folder on disk.
 type Warehouse struct {
-	Directory string // The main directory for the files
-	Temp      string // Temporary folder
+	Directory string      // The main directory for the files
+	Temp      string      // Temporary folder
+	Disabled  bool        // A disabled warehouse stores no files, see InitDisabled.
+	access    *fileAccess // Access counts of files for eviction policies.
 }
 
 // Init initializes the warehouse
 func Init(Directory string) (wh *Warehouse, err error) {
 	// The temp folder will always be a sub-folder named "_Temp"
-	wh = &Warehouse{Directory: Directory, Temp: filepath.Join(Directory, "_Temp")}
+	wh = &Warehouse{Directory: Directory, Temp: filepath.Join(Directory, "_Temp"), access: newFileAccess()}
 
 	if err = createDirectory(wh.Directory); err != nil {
 		return nil, err
@@ -39,6 +41,12 @@ func Init(Directory string) (wh *Warehouse, err error) {
 	return
 }
 
+// InitDisabled initializes a disabled warehouse. It does not use any disk storage: Creating files fails with StatusDisabled and no files exist.
+// This is used by nodes that never share files, such as observer nodes.
+func InitDisabled() (wh *Warehouse) {
+	return &Warehouse{Disabled: true}
+}
+
 // ---- hash functions ----
 
 func ValidateHash(hash []byte) (hashA string, err error) {
@@ -83,6 +91,10 @@ func (wh *Warehouse) createFilePath(hash []byte) (pathFull string, err error) {
 
 // IterateFiles iterates through all the files and calls the callback
 func (wh *Warehouse) IterateFiles(Callback func(Hash []byte, Size int64) (Continue bool)) (err error) {
+	if wh.Disabled {
+		return nil
+	}
+
 	// list all directories in the local Storage folder. We have to walk 2 levels down to see the actual files.
 	files, err := ioutil.ReadDir(wh.Directory)
 	if err != nil {
@@ -40,6 +40,12 @@ type WebapiInstance struct {
 	// upload info
 	uploads      map[uuid.UUID]*UploadStatus
 	uploadsMutex sync.RWMutex
+
+	// blockchain compaction
+	compact compactJob
+
+	// snapshots of explore results
+	exploreCache exploreCache
 }
 
 // API error
@@ -84,31 +90,95 @@ func Start(Backend *core.Backend, ListenAddresses []string, UseSSL bool, Certifi
 	api.Router.HandleFunc("/status", api.apiStatus).Methods("GET")
 	api.Router.HandleFunc("/status/peers", api.apiStatusPeers).Methods("GET")
 	api.Router.HandleFunc("/status/config", api.apiStatusConfig).Methods("GET")
+	api.Router.HandleFunc("/status/useragents", api.apiStatusUserAgents).Methods("GET")
+	api.Router.HandleFunc("/status/tasks", api.apiStatusTasks).Methods("GET")
+	api.Router.HandleFunc("/status/credits", api.apiStatusCredits).Methods("GET")
+	api.Router.HandleFunc("/status/congestion", api.apiStatusCongestion).Methods("GET")
+	api.Router.HandleFunc("/status/networksize", api.apiStatusNetworkSize).Methods("GET")
+	api.Router.HandleFunc("/status/supernode", api.apiStatusSupernode).Methods("GET")
+	api.Router.HandleFunc("/status/infostore", api.apiStatusInfoStore).Methods("GET")
+	api.Router.HandleFunc("/status/traversal", api.apiStatusTraversal).Methods("GET")
+	api.Router.HandleFunc("/status/holepunch", api.apiStatusHolePunch).Methods("GET")
+	api.Router.HandleFunc("/status/relay", api.apiStatusRelay).Methods("GET")
+	api.Router.HandleFunc("/status/relay/list", api.apiStatusRelayList).Methods("GET")
+	api.Router.HandleFunc("/status/update", api.apiStatusUpdate).Methods("GET")
+	api.Router.HandleFunc("/status/update/download", api.apiStatusUpdateDownload).Methods("GET")
+	api.Router.HandleFunc("/status/watch", api.apiPeerWatchList).Methods("GET")
+	api.Router.HandleFunc("/status/watch/add", api.apiPeerWatchAdd).Methods("GET")
+	api.Router.HandleFunc("/status/watch/remove", api.apiPeerWatchRemove).Methods("GET")
+	api.Router.HandleFunc("/denial/status", api.apiDenialStatus).Methods("GET")
+	api.Router.HandleFunc("/denial/refresh", api.apiDenialRefresh).Methods("GET")
+	api.Router.HandleFunc("/denial/check", api.apiDenialCheck).Methods("GET")
+	api.Router.HandleFunc("/denial/override", api.apiDenialOverride).Methods("GET")
+	api.Router.HandleFunc("/denial/publish", api.apiDenialPublish).Methods("POST")
+	api.Router.HandleFunc("/ban/list", api.apiBanList).Methods("GET")
+	api.Router.HandleFunc("/ban/add", api.apiBanAdd).Methods("POST")
+	api.Router.HandleFunc("/ban/remove", api.apiBanRemove).Methods("GET")
+	api.Router.HandleFunc("/ban/export", api.apiBanExport).Methods("GET")
+	api.Router.HandleFunc("/ban/import", api.apiBanImport).Methods("POST")
+	api.Router.HandleFunc("/node/export", api.apiNodeStateExport).Methods("GET")
+	api.Router.HandleFunc("/node/import", api.apiNodeStateImport).Methods("POST")
+	api.Router.HandleFunc("/invitation/create", api.apiInvitationCreate).Methods("GET")
+	api.Router.HandleFunc("/invitation/accept", api.apiInvitationAccept).Methods("POST")
+	api.Router.HandleFunc("/dht/lookup", api.apiDHTLookup).Methods("GET")
+	api.Router.HandleFunc("/protocol/spec", api.apiProtocolSpec).Methods("GET")
+	api.Router.HandleFunc("/diagnostics/capture", api.apiCaptureDownload).Methods("GET")
+	api.Router.HandleFunc("/diagnostics/capture/start", api.apiCaptureStart).Methods("GET")
+	api.Router.HandleFunc("/diagnostics/capture/stop", api.apiCaptureStop).Methods("GET")
+	api.Router.HandleFunc("/diagnostics/capture/status", api.apiCaptureStatus).Methods("GET")
+	api.Router.HandleFunc("/diagnostics/benchmark", api.apiBenchmark).Methods("GET")
+	api.Router.HandleFunc("/admin/request", api.apiAdminRequest).Methods("GET")
 	api.Router.HandleFunc("/account/info", api.apiAccountInfo).Methods("GET")
 	api.Router.HandleFunc("/account/delete", api.apiAccountDelete).Methods("GET")
+	api.Router.HandleFunc("/account/mnemonic/export", api.apiAccountMnemonicExport).Methods("POST")
+	api.Router.HandleFunc("/account/mnemonic/verify", api.apiAccountMnemonicVerify).Methods("POST")
+	api.Router.HandleFunc("/account/mnemonic/import", api.apiAccountMnemonicImport).Methods("POST")
+	api.Router.HandleFunc("/account/mnemonic/confirm", api.apiAccountMnemonicConfirm).Methods("POST")
 	api.Router.HandleFunc("/blockchain/header", api.apiBlockchainHeaderFunc).Methods("GET")
 	api.Router.HandleFunc("/blockchain/append", api.apiBlockchainAppend).Methods("POST")
 	api.Router.HandleFunc("/blockchain/read", api.apiBlockchainRead).Methods("GET")
+	api.Router.HandleFunc("/blockchain/compact", api.apiBlockchainCompact).Methods("GET")
+	api.Router.HandleFunc("/blockchain/compact/status", api.apiBlockchainCompactStatus).Methods("GET")
 	api.Router.HandleFunc("/blockchain/file/add", api.apiBlockchainFileAdd).Methods("POST")
 	api.Router.HandleFunc("/blockchain/file/list", api.apiBlockchainFileList).Methods("GET")
 	api.Router.HandleFunc("/blockchain/file/delete", api.apiBlockchainFileDelete).Methods("POST")
 	api.Router.HandleFunc("/blockchain/file/update", api.apiBlockchainFileUpdate).Methods("POST")
 	api.Router.HandleFunc("/blockchain/view", api.apiExploreNodeID).Methods("GET")
+	api.Router.HandleFunc("/blockchain/custom/list", api.apiBlockchainCustomList).Methods("GET")
+	api.Router.HandleFunc("/blockchain/delegation/list", api.apiDelegationList).Methods("GET")
+	api.Router.HandleFunc("/blockchain/delegation/add", api.apiDelegationAdd).Methods("POST")
+	api.Router.HandleFunc("/blockchain/delegation/revoke", api.apiDelegationRevoke).Methods("GET")
+	api.Router.HandleFunc("/blockchain/delegated/publish", api.apiDelegatedPublish).Methods("POST")
 	api.Router.HandleFunc("/merge/directory", api.apiMergeDirectory).Methods("GET")
 	api.Router.HandleFunc("/profile/list", api.apiProfileList).Methods("GET")
 	api.Router.HandleFunc("/profile/read", api.apiProfileRead).Methods("GET")
 	api.Router.HandleFunc("/profile/write", api.apiProfileWrite).Methods("POST")
 	api.Router.HandleFunc("/profile/delete", api.apiProfileDelete).Methods("POST")
+	api.Router.HandleFunc("/profile/update", api.apiProfileUpdate).Methods("POST")
+	api.Router.HandleFunc("/profile/name/claims", api.apiProfileNameClaims).Methods("GET")
+	api.Router.HandleFunc("/group/create", api.apiGroupCreate).Methods("POST")
+	api.Router.HandleFunc("/group/update", api.apiGroupUpdate).Methods("POST")
+	api.Router.HandleFunc("/group/delete", api.apiGroupDelete).Methods("GET")
+	api.Router.HandleFunc("/group/list", api.apiGroupList).Methods("GET")
+	api.Router.HandleFunc("/group/send", api.apiGroupSend).Methods("POST")
+	api.Router.HandleFunc("/group/messages", api.apiGroupMessages).Methods("GET")
 	api.Router.HandleFunc("/search", api.apiSearch).Methods("POST")
 	api.Router.HandleFunc("/search/result", api.apiSearchResult).Methods("GET")
 	api.Router.HandleFunc("/search/result/ws", api.apiSearchResultStream).Methods("GET")
 	api.Router.HandleFunc("/search/statistic", api.apiSearchStatistic).Methods("GET")
+	api.Router.HandleFunc("/search/statistic/ws", api.apiSearchStatisticStream).Methods("GET")
 	api.Router.HandleFunc("/search/terminate", api.apiSearchTerminate).Methods("GET")
+	api.Router.HandleFunc("/search/suggest", api.apiSearchSuggest).Methods("GET")
 	api.Router.HandleFunc("/explore", api.apiExplore).Methods("GET")
+	api.Router.HandleFunc("/explore/hashtags", api.apiExploreHashtags).Methods("GET")
+	api.Router.HandleFunc("/explore/hashtag", api.apiExploreHashtag).Methods("GET")
 	api.Router.HandleFunc("/file/format", api.apiFileFormat).Methods("GET")
 	api.Router.HandleFunc("/download/start", api.apiDownloadStart).Methods("GET")
 	api.Router.HandleFunc("/download/status", api.apiDownloadStatus).Methods("GET")
 	api.Router.HandleFunc("/download/action", api.apiDownloadAction).Methods("GET")
+	api.Router.HandleFunc("/download/limit", api.apiDownloadLimit).Methods("GET")
+	api.Router.HandleFunc("/download/directory", api.apiDownloadDirectory).Methods("GET")
+	api.Router.HandleFunc("/transfer/history", api.apiTransferHistory).Methods("GET")
 	api.Router.HandleFunc("/warehouse/create", api.ApiWarehouseCreateFile).Methods("POST")
 	api.Router.HandleFunc("/warehouse/create/uploadID", api.apiUploadID).Methods("GET")
 	api.Router.HandleFunc("/warehouse/create/track/uploadID", api.apiUploadInfo).Methods("GET")
@@ -116,8 +186,19 @@ func Start(Backend *core.Backend, ListenAddresses []string, UseSSL bool, Certifi
 	api.Router.HandleFunc("/warehouse/read", api.apiWarehouseReadFile).Methods("GET")
 	api.Router.HandleFunc("/warehouse/read/path", api.apiWarehouseReadFilePath).Methods("GET")
 	api.Router.HandleFunc("/warehouse/delete", api.apiWarehouseDeleteFile).Methods("GET")
+	api.Router.HandleFunc("/warehouse/gc", api.apiWarehouseGC).Methods("GET")
+	api.Router.HandleFunc("/warehouse/stats", api.apiWarehouseStats).Methods("GET")
+	api.Router.HandleFunc("/warehouse/pin", api.apiWarehousePin).Methods("GET")
+	api.Router.HandleFunc("/warehouse/scrub", api.apiWarehouseScrub).Methods("GET")
+	api.Router.HandleFunc("/warehouse/create/directory", api.apiWarehouseCreateDirectory).Methods("GET")
+	api.Router.HandleFunc("/warehouse/read/directory", api.apiWarehouseReadDirectory).Methods("GET")
+	api.Router.HandleFunc("/warehouse/directory", api.apiWarehouseDirectory).Methods("GET")
 	api.Router.HandleFunc("/file/read", api.apiFileRead).Methods("GET")
 	api.Router.HandleFunc("/file/view", api.apiFileView).Methods("GET")
+	api.Router.HandleFunc("/file/stats", api.apiFileStats).Methods("GET")
+	api.Router.HandleFunc("/file/fetch", api.apiFileFetch).Methods("GET")
+	api.Router.HandleFunc("/storage/results", api.apiStorageResults).Methods("GET")
+	api.Router.HandleFunc("/storage/challenge", api.apiStorageChallenge).Methods("GET")
 
 	for _, listen := range ListenAddresses {
 		go startWebAPI(Backend, listen, UseSSL, CertificateFile, CertificateKey, api.Router, "API", TimeoutRead, TimeoutWrite)
@@ -171,13 +252,13 @@ func EncodeJSON(Backend *core.Backend, w http.ResponseWriter, r *http.Request, d
 // In case of error it will automatically send an error to the client.
 func DecodeJSON(w http.ResponseWriter, r *http.Request, data interface{}) (err error) {
 	if r.Body == nil {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return errors.New("no data")
 	}
 
 	err = json.NewDecoder(r.Body).Decode(data)
 	if err != nil {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return err
 	}
 
@@ -199,12 +280,12 @@ func (api *WebapiInstance) authenticateMiddleware(APIKey uuid.UUID) func(http.Ha
 				}
 			}
 			if err != nil { // Invalid key format
-				w.WriteHeader(http.StatusUnauthorized)
+				EncodeError(w, http.StatusUnauthorized, "")
 				return
 			}
 
 			if keyID != APIKey {
-				w.WriteHeader(http.StatusUnauthorized)
+				EncodeError(w, http.StatusUnauthorized, "")
 				return
 			}
 
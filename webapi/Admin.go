@@ -0,0 +1,80 @@
+/*
+File Username:  Admin.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+type apiAdminResult struct {
+	Status int             `json:"status"` // Status returned by the remote node: 0 = Success, 1 = Not authorized, 2 = Invalid action or data.
+	Data   json.RawMessage `json:"data"`   // Response data. For the status action this is the JSON encoded status of the remote node. Null otherwise.
+}
+
+/*
+apiAdminRequest sends an admin request to a remote node. The remote node must list the peer ID of this node in its config setting AdminPublicKeys.
+Actions: 0 = Status, 1 = Re-announce, 2 = Garbage collection, 3 = Change log target to target. The timeout is in seconds (default 10).
+
+Request:    GET /admin/request?peer=[peer ID]&action=[action]&target=[log target]&timeout=[seconds]
+Response:   200 with JSON structure apiAdminResult. 400 if the peer ID or action is invalid. 404 if the peer is not found. 502 if no response was received.
+*/
+func (api *WebapiInstance) apiAdminRequest(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	publicKey, err := core.PublicKeyFromPeerID(r.Form.Get("peer"))
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "invalid peer ID")
+		return
+	}
+
+	var data []byte
+
+	action, err := strconv.Atoi(r.Form.Get("action"))
+	switch {
+	case err != nil || action < protocol.AdminActionStatus || action > protocol.AdminActionLogTarget:
+		EncodeError(w, http.StatusBadRequest, "invalid action")
+		return
+
+	case action == protocol.AdminActionLogTarget:
+		target, err := strconv.Atoi(r.Form.Get("target"))
+		if err != nil || target < 0 || target > 3 {
+			EncodeError(w, http.StatusBadRequest, "invalid log target")
+			return
+		}
+		data = []byte{byte(target)}
+	}
+
+	timeout, _ := strconv.Atoi(r.Form.Get("timeout"))
+	if timeout <= 0 {
+		timeout = 10
+	}
+
+	peer, err := PeerConnectPublicKey(api.Backend, publicKey, time.Duration(timeout)*time.Second)
+	if err != nil {
+		EncodeError(w, http.StatusNotFound, "")
+		return
+	}
+
+	status, response, err := api.Backend.AdminRequest(peer, uint8(action), data, time.Duration(timeout)*time.Second)
+	if err != nil {
+		EncodeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	result := apiAdminResult{Status: int(status)}
+	if len(response) > 0 && json.Valid(response) {
+		result.Data = response
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
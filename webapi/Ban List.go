@@ -0,0 +1,106 @@
+/*
+File Username:  Ban List.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/PeernetOfficial/core"
+)
+
+/*
+apiBanList returns all entries of the ban list.
+
+Request:    GET /ban/list
+Response:   200 with JSON array core.BanEntry
+*/
+func (api *WebapiInstance) apiBanList(w http.ResponseWriter, r *http.Request) {
+	entries := api.Backend.BanList()
+	if entries == nil {
+		entries = []core.BanEntry{}
+	}
+
+	EncodeJSON(api.Backend, w, r, entries)
+}
+
+/*
+apiBanAdd adds a peer ID or IP address (or CIDR range) to the ban list. An existing entry for the same target is replaced.
+
+Request:    POST /ban/add with JSON structure core.BanEntry
+Response:   204 Empty. 400 if the entry is invalid.
+*/
+func (api *WebapiInstance) apiBanAdd(w http.ResponseWriter, r *http.Request) {
+	var entry core.BanEntry
+	if err := DecodeJSON(w, r, &entry); err != nil {
+		return
+	}
+
+	if err := api.Backend.BanAdd(entry); err != nil {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+apiBanRemove removes the entry for a peer ID or IP address (or CIDR range) from the ban list.
+
+Request:    GET /ban/remove?target=[peer ID or IP]
+Response:   204 Empty. 404 if there is no entry.
+*/
+func (api *WebapiInstance) apiBanRemove(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if !api.Backend.BanRemove(r.Form.Get("target")) {
+		EncodeError(w, http.StatusNotFound, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+apiBanExport exports all active entries of the ban list as JSON document signed by the peer's private key.
+
+Request:    GET /ban/export
+Response:   200 with JSON structure core.BanListFile as file download
+*/
+func (api *WebapiInstance) apiBanExport(w http.ResponseWriter, r *http.Request) {
+	data, err := api.Backend.BanListExport()
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="banlist.json"`)
+	w.Write(data)
+}
+
+/*
+apiBanImport merges a signed ban list into the local one. The signer must be listed in the config setting BanTrusted.
+
+Request:    POST /ban/import with JSON structure core.BanListFile as body
+Response:   200 with JSON structure core.BanImportResult. 400 if the document is invalid, the signature is invalid, or the signer is not trusted.
+*/
+func (api *WebapiInstance) apiBanImport(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	result, err := api.Backend.BanListImport(data)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
@@ -0,0 +1,95 @@
+/*
+File Username:  Blockchain Compact.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// CompactStatus is the status of the blockchain compaction job. Only one compaction can run at a time.
+type CompactStatus struct {
+	Running bool   `json:"running"` // Whether compaction is currently running.
+	DryRun  bool   `json:"dryrun"`  // Whether it is a dry run that does not write anything.
+	Phase   int    `json:"phase"`   // Current phase. See blockchain.CompactPhaseX.
+	Done    uint64 `json:"done"`    // Count of blocks processed in the current phase.
+	Total   uint64 `json:"total"`   // Total count of blocks in the current phase.
+
+	// Result after compaction finished
+	Status        int    `json:"status"`        // See blockchain.StatusX.
+	HeightBefore  uint64 `json:"heightbefore"`  // Height before compaction.
+	HeightAfter   uint64 `json:"heightafter"`   // Height after compaction. In a dry run, the height the blockchain would have.
+	VersionBefore uint64 `json:"versionbefore"` // Version before compaction.
+	VersionAfter  uint64 `json:"versionafter"`  // Version after compaction. Unchanged if nothing was written.
+	Records       uint64 `json:"records"`       // Count of records in the blockchain.
+	SizeBefore    uint64 `json:"sizebefore"`    // Total size of all blocks before compaction.
+	SizeAfter     uint64 `json:"sizeafter"`     // Total size of all blocks after compaction. In a dry run, the size the blockchain would have.
+	Written       bool   `json:"written"`       // Whether the blockchain was rewritten.
+}
+
+// compactJob keeps track of the current or last blockchain compaction.
+type compactJob struct {
+	status CompactStatus
+	sync.Mutex
+}
+
+/*
+apiBlockchainCompact starts compacting the user's blockchain in the background. Consecutive blocks are merged into blocks up to the target size.
+The blockchain version is increased. Use /blockchain/compact/status to get the progress and result.
+The target size is in bytes. If 0 or not provided, the default target block size is used.
+
+Request:    GET /blockchain/compact?dryrun=[true|false]&target=[size]
+Response:   200 with JSON structure CompactStatus. 409 if compaction is already running.
+*/
+func (api *WebapiInstance) apiBlockchainCompact(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	dryRun, _ := strconv.ParseBool(r.Form.Get("dryrun"))
+	target, _ := strconv.ParseUint(r.Form.Get("target"), 10, 64)
+
+	api.compact.Lock()
+	defer api.compact.Unlock()
+
+	if api.compact.status.Running {
+		EncodeError(w, http.StatusConflict, "")
+		return
+	}
+
+	api.compact.status = CompactStatus{Running: true, DryRun: dryRun}
+
+	go func() {
+		result, status := api.Backend.UserBlockchain.Compact(target, dryRun, func(phase int, done, total uint64) {
+			api.compact.Lock()
+			api.compact.status.Phase, api.compact.status.Done, api.compact.status.Total = phase, done, total
+			api.compact.Unlock()
+		})
+
+		api.compact.Lock()
+		api.compact.status.Running, api.compact.status.Status = false, status
+		api.compact.status.HeightBefore, api.compact.status.HeightAfter = result.HeightBefore, result.HeightAfter
+		api.compact.status.VersionBefore, api.compact.status.VersionAfter = result.VersionBefore, result.VersionAfter
+		api.compact.status.SizeBefore, api.compact.status.SizeAfter = result.SizeBefore, result.SizeAfter
+		api.compact.status.Records, api.compact.status.Written = result.Records, result.Written
+		api.compact.Unlock()
+	}()
+
+	EncodeJSON(api.Backend, w, r, api.compact.status)
+}
+
+/*
+apiBlockchainCompactStatus returns the progress of the running compaction, or the result of the last one.
+
+Request:    GET /blockchain/compact/status
+Response:   200 with JSON structure CompactStatus
+*/
+func (api *WebapiInstance) apiBlockchainCompactStatus(w http.ResponseWriter, r *http.Request) {
+	api.compact.Lock()
+	status := api.compact.status
+	api.compact.Unlock()
+
+	EncodeJSON(api.Backend, w, r, status)
+}
@@ -8,9 +8,11 @@ package webapi
 
 import (
 	"encoding/hex"
+	"github.com/PeernetOfficial/core"
 	"github.com/PeernetOfficial/core/blockchain"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type apiBlockchainHeader struct {
@@ -32,8 +34,9 @@ func (api *WebapiInstance) apiBlockchainHeaderFunc(w http.ResponseWriter, r *htt
 }
 
 type apiBlockRecordRaw struct {
-	Type uint8  `json:"type"` // Record Type. See core.RecordTypeX.
-	Data []byte `json:"data"` // Data according to the type.
+	Type     uint8  `json:"type"`     // Record Type. See core.RecordTypeX.
+	Data     []byte `json:"data"`     // Data according to the type.
+	Delegate string `json:"delegate"` // Peer ID of the delegate that created the record hex encoded. Empty if created by the owner. Ignored as input.
 }
 
 // apiBlockchainBlockRaw contains a raw block of the blockchain via API
@@ -50,6 +53,7 @@ type apiBlockchainBlockStatus struct {
 /*
 apiBlockchainAppend appends a block to the blockchain. This is a low-level function for already encoded blocks.
 Do not use this function. Adding invalid data to the blockchain may corrupt it which might result in blacklisting by other peers.
+Records of multiple calls within the batch window (config setting BlockchainBatchWindow) are written as a single block.
 
 Request:    POST /blockchain/append with JSON structure apiBlockchainBlockRaw
 Response:   200 with JSON structure apiBlockchainBlockStatus
@@ -66,7 +70,7 @@ func (api *WebapiInstance) apiBlockchainAppend(w http.ResponseWriter, r *http.Re
 		records = append(records, blockchain.BlockRecordRaw{Type: record.Type, Data: record.Data})
 	}
 
-	newHeight, newVersion, status := api.Backend.UserBlockchain.Append(records)
+	newHeight, newVersion, status := api.Backend.UserBlockchain.AppendBatch(records)
 
 	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
 }
@@ -91,7 +95,7 @@ func (api *WebapiInstance) apiBlockchainRead(w http.ResponseWriter, r *http.Requ
 	r.ParseForm()
 	blockN, err := strconv.Atoi(r.Form.Get("block"))
 	if err != nil || blockN < 0 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -100,7 +104,11 @@ func (api *WebapiInstance) apiBlockchainRead(w http.ResponseWriter, r *http.Requ
 
 	if status == 0 {
 		for _, record := range block.RecordsRaw {
-			result.RecordsRaw = append(result.RecordsRaw, apiBlockRecordRaw{Type: record.Type, Data: record.Data})
+			recordRaw := apiBlockRecordRaw{Type: record.Type, Data: record.Data}
+			if record.Delegate != nil {
+				recordRaw.Delegate = hex.EncodeToString(record.Delegate.SerializeCompressed())
+			}
+			result.RecordsRaw = append(result.RecordsRaw, recordRaw)
 		}
 
 		result.PeerID = hex.EncodeToString(block.OwnerPublicKey.SerializeCompressed())
@@ -108,11 +116,22 @@ func (api *WebapiInstance) apiBlockchainRead(w http.ResponseWriter, r *http.Requ
 		for _, record := range block.RecordsDecoded {
 			switch v := record.(type) {
 			case blockchain.BlockRecordFile:
-				result.RecordsDecoded = append(result.RecordsDecoded, blockRecordFileToAPI(v, true))
+				file := blockRecordFileToAPI(v, true)
+				api.markFutureDated(&file)
+				result.RecordsDecoded = append(result.RecordsDecoded, file)
 
 			case blockchain.BlockRecordProfile:
 				result.RecordsDecoded = append(result.RecordsDecoded, blockRecordProfileToAPI(v))
 
+			case blockchain.BlockRecordGroup:
+				result.RecordsDecoded = append(result.RecordsDecoded, api.groupToAPI(core.GroupInfo{Owner: block.OwnerPublicKey, BlockRecordGroup: v}))
+
+			case blockchain.BlockRecordCustom:
+				result.RecordsDecoded = append(result.RecordsDecoded, blockRecordCustomToAPI(v))
+
+			case blockchain.BlockRecordDelegation:
+				result.RecordsDecoded = append(result.RecordsDecoded, blockRecordDelegationToAPI(v))
+
 			}
 		}
 	}
@@ -120,6 +139,57 @@ func (api *WebapiInstance) apiBlockchainRead(w http.ResponseWriter, r *http.Requ
 	EncodeJSON(api.Backend, w, r, result)
 }
 
+// apiBlockRecordCustom is a record of a custom record type registered by the application.
+type apiBlockRecordCustom struct {
+	Type  uint8       `json:"type"`  // Record type.
+	Name  string      `json:"name"`  // Name of the record type as registered.
+	Date  time.Time   `json:"date"`  // Date the record was created.
+	Value interface{} `json:"value"` // Value as projected by the handler of the record type.
+}
+
+type apiBlockchainCustomList struct {
+	Status  int                    `json:"status"`  // See blockchain.StatusX.
+	Records []apiBlockRecordCustom `json:"records"` // Records of the requested type.
+}
+
+/*
+apiBlockchainCustomList lists all records of a custom record type stored on the blockchain. The type must be registered by the application.
+
+Request:    GET /blockchain/custom/list?type=[record type]
+Result:     200 with JSON structure apiBlockchainCustomList. 400 if the type is invalid or not registered.
+*/
+func (api *WebapiInstance) apiBlockchainCustomList(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	recordType, err := strconv.Atoi(r.Form.Get("type"))
+	if err != nil || recordType < blockchain.RecordTypeCustomFirst || recordType > 255 || blockchain.RecordTypeHandlerGet(uint8(recordType)) == nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	records, status := api.Backend.UserBlockchain.CustomRecordList(uint8(recordType))
+
+	result := apiBlockchainCustomList{Status: status, Records: []apiBlockRecordCustom{}}
+	for _, record := range records {
+		result.Records = append(result.Records, blockRecordCustomToAPI(record))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+// blockRecordCustomToAPI projects the custom record via the handler of its type.
+func blockRecordCustomToAPI(record blockchain.BlockRecordCustom) (result apiBlockRecordCustom) {
+	result = apiBlockRecordCustom{Type: record.Type, Date: record.Date, Value: record.Value}
+
+	if handler := blockchain.RecordTypeHandlerGet(record.Type); handler != nil {
+		result.Name = handler.Name
+		if handler.Project != nil {
+			result.Value = handler.Project(record.Value)
+		}
+	}
+
+	return result
+}
+
 /*
 apiExploreNodeID returns the shared files of a particular node in Peernet. Results are returned in real-time. The file type is an optional filter. See TypeX.
 Special type -2 = Binary, Compressed, Container, Executable. This special type includes everything except Documents, Video, Audio, Ebooks, Picture, Text.
@@ -0,0 +1,87 @@
+/*
+File Username:  DHT Lookup.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/dht"
+)
+
+// apiDHTNode is a node returned by an iterative DHT lookup.
+type apiDHTNode struct {
+	PeerID []byte `json:"peerid"` // Peer ID.
+	NodeID []byte `json:"nodeid"` // Node ID.
+}
+
+// apiDHTLookup is the result of an iterative DHT lookup.
+type apiDHTLookup struct {
+	Found     bool         `json:"found"`     // Whether the node or value was found.
+	SenderID  []byte       `json:"senderid"`  // Node ID of the peer that returned the data or reported the target node.
+	Target    *apiDHTNode  `json:"target"`    // Node lookups: The target node, if found.
+	Data      []byte       `json:"data"`      // Value lookups: The data, if returned directly.
+	Storing   []apiDHTNode `json:"storing"`   // Value lookups: Peers reported to store the value.
+	Closest   []apiDHTNode `json:"closest"`   // Closest peers to the key that replied, sorted by distance.
+	Queried   int          `json:"queried"`   // Count of peers queried.
+	Failed    int          `json:"failed"`    // Count of peers that did not reply.
+	Converged bool         `json:"converged"` // Whether the lookup converged.
+	Duration  int64        `json:"duration"`  // Duration of the lookup in milliseconds.
+}
+
+func dhtNodes2API(nodes []*dht.Node) (result []apiDHTNode) {
+	result = []apiDHTNode{}
+	for _, node := range nodes {
+		result = append(result, apiDHTNode{PeerID: node.Info.(*core.PeerInfo).PublicKey.SerializeCompressed(), NodeID: node.ID})
+	}
+	return result
+}
+
+/*
+apiDHTLookup performs an iterative Kademlia lookup for a node ID (action = node) or a value hash (action = value).
+Alpha is the max count of parallel requests and beta the count of closest peers that must have replied for the lookup to converge. Both are optional.
+
+Request:    GET /dht/lookup?action=[node|value]&key=[hash]&alpha=[count]&beta=[count]
+Response:   200 with JSON structure apiDHTLookup. 400 if the action or key is invalid.
+*/
+func (api *WebapiInstance) apiDHTLookup(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key, valid := DecodeBlake3Hash(r.Form.Get("key"))
+	alpha, _ := strconv.Atoi(r.Form.Get("alpha"))
+	beta, _ := strconv.Atoi(r.Form.Get("beta"))
+
+	var action int
+	switch r.Form.Get("action") {
+	case "node":
+		action = dht.ActionFindNode
+	case "value":
+		action = dht.ActionFindValue
+	default:
+		valid = false
+	}
+
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	result, err := api.Backend.IterativeLookup(action, key, alpha, beta)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := apiDHTLookup{Found: result.Found, SenderID: result.SenderID, Data: result.Data, Storing: dhtNodes2API(result.Storing), Closest: dhtNodes2API(result.Closest),
+		Queried: result.Queried, Failed: result.Failed, Converged: result.Converged, Duration: result.Duration.Milliseconds()}
+
+	if result.TargetNode != nil {
+		response.Target = &dhtNodes2API([]*dht.Node{result.TargetNode})[0]
+	}
+
+	EncodeJSON(api.Backend, w, r, response)
+}
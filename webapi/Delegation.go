@@ -0,0 +1,144 @@
+/*
+File Username:  Delegation.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/blockchain"
+)
+
+// apiBlockRecordDelegation authorizes a delegate to publish records of the types in scope on behalf of the user.
+type apiBlockRecordDelegation struct {
+	Delegate string    `json:"delegate"` // Peer ID of the delegate hex encoded.
+	Expiry   time.Time `json:"expiry"`   // Expiry of the delegation.
+	Scope    []uint8   `json:"scope"`    // Record types the delegate may publish. See blockchain.RecordTypeX.
+	Active   bool      `json:"active"`   // Whether the delegation is not expired. Ignored when adding.
+}
+
+type apiDelegationList struct {
+	Status      int                        `json:"status"`      // See blockchain.StatusX.
+	Delegations []apiBlockRecordDelegation `json:"delegations"` // Delegations including expired ones.
+}
+
+type apiDelegatedPublish struct {
+	Owner   string              `json:"owner"`   // Peer ID of the blockchain owner hex encoded.
+	Records []apiBlockRecordRaw `json:"records"` // Records in encoded raw format.
+}
+
+/*
+apiDelegationList lists the delegations on the user's blockchain.
+
+Request:    GET /blockchain/delegation/list
+Result:     200 with JSON structure apiDelegationList
+*/
+func (api *WebapiInstance) apiDelegationList(w http.ResponseWriter, r *http.Request) {
+	delegations, status := api.Backend.UserBlockchain.DelegationList()
+
+	result := apiDelegationList{Status: status, Delegations: []apiBlockRecordDelegation{}}
+	for _, delegation := range delegations {
+		result.Delegations = append(result.Delegations, blockRecordDelegationToAPI(delegation))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiDelegationAdd authorizes a delegate to publish records of the types in scope on the user's blockchain until the expiry.
+
+Request:    POST /blockchain/delegation/add with JSON structure apiBlockRecordDelegation
+Result:     200 with JSON structure apiBlockchainBlockStatus. 400 if the delegate is invalid or the expiry is in the past.
+*/
+func (api *WebapiInstance) apiDelegationAdd(w http.ResponseWriter, r *http.Request) {
+	var input apiBlockRecordDelegation
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	delegate, err := core.PublicKeyFromPeerID(input.Delegate)
+	if err != nil || !input.Expiry.After(time.Now()) {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.DelegationAdd(blockchain.BlockRecordDelegation{Delegate: delegate, Expiry: input.Expiry, Scope: input.Scope})
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiDelegationRevoke deletes all delegations of the delegate. Records already published by the delegate are kept.
+
+Request:    GET /blockchain/delegation/revoke?delegate=[peer ID]
+Result:     200 with JSON structure apiBlockchainBlockStatus. 400 if the delegate is invalid.
+*/
+func (api *WebapiInstance) apiDelegationRevoke(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	delegate, err := core.PublicKeyFromPeerID(r.Form.Get("delegate"))
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.DelegationRevoke(delegate)
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiDelegatedPublish publishes records on the blockchain of another user who authorized this peer as delegate. The records are signed by this
+peer and appended by the owner, who checks the delegation. This is a low-level function for already encoded records.
+
+Request:    POST /blockchain/delegated/publish with JSON structure apiDelegatedPublish
+Result:     200 with JSON structure apiBlockchainBlockStatus as returned by the owner. 400 if the owner or records are invalid. 404 if the owner is not found. 502 if the request failed.
+*/
+func (api *WebapiInstance) apiDelegatedPublish(w http.ResponseWriter, r *http.Request) {
+	var input apiDelegatedPublish
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	owner, err := core.PublicKeyFromPeerID(input.Owner)
+	if err != nil || len(input.Records) == 0 {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	var records []blockchain.BlockRecordRaw
+	for _, record := range input.Records {
+		records = append(records, blockchain.BlockRecordRaw{Type: record.Type, Data: record.Data})
+	}
+
+	peer, err := PeerConnectPublicKey(api.Backend, owner, 10*time.Second)
+	if err != nil {
+		EncodeError(w, http.StatusNotFound, "")
+		return
+	}
+
+	newHeight, newVersion, status, err := api.Backend.DelegatedPublish(peer, records)
+	if err != nil && status == blockchain.StatusCorruptBlockRecord {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		EncodeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+func blockRecordDelegationToAPI(delegation blockchain.BlockRecordDelegation) apiBlockRecordDelegation {
+	return apiBlockRecordDelegation{
+		Delegate: hex.EncodeToString(delegation.Delegate.SerializeCompressed()),
+		Expiry:   delegation.Expiry,
+		Scope:    delegation.Scope,
+		Active:   delegation.IsActive(),
+	}
+}
@@ -0,0 +1,167 @@
+/*
+File Username:  Denial List.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/blockchain"
+)
+
+type apiDenialList struct {
+	Maintainer []byte    `json:"maintainer"` // Peer ID of the list maintainer.
+	Entries    int       `json:"entries"`    // Count of denied hashes.
+	Version    uint64    `json:"version"`    // Version of the maintainer's blockchain that was read.
+	Height     uint64    `json:"height"`     // Height of the maintainer's blockchain that was read.
+	Refreshed  time.Time `json:"refreshed"`  // Time of the last refresh. Zero if not refreshed yet.
+	Error      string    `json:"error"`      // Error of the last refresh, if any.
+}
+
+type apiDenialStatus struct {
+	Lists     []apiDenialList `json:"lists"`     // Subscribed denial lists.
+	Overrides map[string]int  `json:"overrides"` // Local overrides: Hex encoded hash and override. 1 = Allow, 2 = Deny.
+}
+
+type apiDenialCheck struct {
+	Denied     bool   `json:"denied"`     // Whether the hash is denied, including the local override.
+	Override   int    `json:"override"`   // Local override. 0 = None, 1 = Allow, 2 = Deny.
+	Maintainer []byte `json:"maintainer"` // Peer ID of the maintainer of the first list that contains the hash. Null if not listed.
+	Reason     string `json:"reason"`     // Reason provided by the maintainer.
+}
+
+type apiDenialEntry struct {
+	Hash   []byte `json:"hash"`   // Blake3 hash of the denied content.
+	Remove bool   `json:"remove"` // Whether to remove the hash from the list instead of adding it.
+	Reason string `json:"reason"` // Reason, optional.
+}
+
+type apiDenialPublish struct {
+	Entries []apiDenialEntry `json:"entries"`
+}
+
+/*
+apiDenialStatus returns the status of the subscribed denial lists and the local overrides.
+
+Request:    GET /denial/status
+Response:   200 with JSON structure apiDenialStatus
+*/
+func (api *WebapiInstance) apiDenialStatus(w http.ResponseWriter, r *http.Request) {
+	EncodeJSON(api.Backend, w, r, denialStatusToAPI(api.Backend))
+}
+
+/*
+apiDenialRefresh refreshes all subscribed denial lists and returns their status.
+
+Request:    GET /denial/refresh
+Response:   200 with JSON structure apiDenialStatus
+*/
+func (api *WebapiInstance) apiDenialRefresh(w http.ResponseWriter, r *http.Request) {
+	api.Backend.DenialListsRefresh()
+
+	EncodeJSON(api.Backend, w, r, denialStatusToAPI(api.Backend))
+}
+
+func denialStatusToAPI(backend *core.Backend) (result apiDenialStatus) {
+	result.Lists = []apiDenialList{}
+	result.Overrides = backend.DenialOverrides()
+
+	for _, list := range backend.DenialListsStatus() {
+		result.Lists = append(result.Lists, apiDenialList{Maintainer: list.Maintainer.SerializeCompressed(), Entries: list.Entries, Version: list.Version, Height: list.Height, Refreshed: list.Refreshed, Error: list.Error})
+	}
+
+	return result
+}
+
+/*
+apiDenialCheck checks a hash against the local overrides and the subscribed denial lists.
+
+Request:    GET /denial/check?hash=[blake3 hash]
+Response:   200 with JSON structure apiDenialCheck. 400 if the hash is invalid.
+*/
+func (api *WebapiInstance) apiDenialCheck(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	hash, valid := DecodeBlake3Hash(r.Form.Get("hash"))
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	check := api.Backend.DenialCheck(hash)
+
+	result := apiDenialCheck{Denied: check.Denied, Override: check.Override, Reason: check.Reason}
+	if check.Maintainer != nil {
+		result.Maintainer = check.Maintainer.SerializeCompressed()
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiDenialOverride sets the local override for a hash. It is stored in the config.
+
+The override is 0 = None (the lists apply), 1 = Allow, 2 = Deny.
+
+Request:    GET /denial/override?hash=[blake3 hash]&override=[0-2]
+Response:   204 Empty. 400 if the parameters are invalid.
+*/
+func (api *WebapiInstance) apiDenialOverride(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	hash, valid := DecodeBlake3Hash(r.Form.Get("hash"))
+	override, err := strconv.Atoi(r.Form.Get("override"))
+	if !valid || err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	if err := api.Backend.DenialOverrideSet(hash, override); err != nil {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+apiDenialPublish publishes denial list entries on the user's blockchain. This is only used by list maintainers.
+
+Request:    POST /denial/publish with JSON structure apiDenialPublish
+Response:   200 with JSON structure apiBlockchainBlockStatus. 400 if there are no entries or the hash of an entry is invalid.
+*/
+func (api *WebapiInstance) apiDenialPublish(w http.ResponseWriter, r *http.Request) {
+	var input apiDenialPublish
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	} else if len(input.Entries) == 0 {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	var denials []blockchain.BlockRecordDenial
+	now := time.Now()
+
+	for _, entry := range input.Entries {
+		if len(entry.Hash) != 32 {
+			EncodeError(w, http.StatusBadRequest, "")
+			return
+		}
+
+		denial := blockchain.BlockRecordDenial{Hash: entry.Hash, Action: blockchain.DenialAdd, Date: now, Reason: entry.Reason}
+		if entry.Remove {
+			denial.Action = blockchain.DenialRemove
+		}
+		denials = append(denials, denial)
+	}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.DenialPublish(denials)
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
@@ -0,0 +1,189 @@
+/*
+File Username:  Diagnostics.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+type apiCaptureStatus struct {
+	Active         bool      `json:"active"`         // Whether capturing is active.
+	Started        time.Time `json:"started"`        // Start of the last capture. Zero if never started.
+	Stopped        time.Time `json:"stopped"`        // End of the last capture. Zero if active or never started.
+	PeerID         []byte    `json:"peerid"`         // Peer ID of the captured peer. Empty if all peers are captured.
+	RecordsPerPeer int       `json:"recordsperpeer"` // Max count of records kept in memory per peer.
+	Folder         string    `json:"folder"`         // Folder the records are written to. Empty if not written to files.
+	Peers          int       `json:"peers"`          // Count of peers with records.
+	Records        int       `json:"records"`        // Count of records in memory.
+	Dropped        uint64    `json:"dropped"`        // Count of records dropped because a ring buffer was full or too many peers were captured.
+}
+
+type apiCaptureRecord struct {
+	Time     time.Time `json:"time"`     // Time the packet was received or sent.
+	Outgoing bool      `json:"outgoing"` // Whether the packet was sent (true) or received (false).
+	PeerID   []byte    `json:"peerid"`   // Peer ID of the remote peer.
+	Local    string    `json:"local"`    // Local address as IP:Port.
+	Remote   string    `json:"remote"`   // Remote address as IP:Port.
+	Command  uint8     `json:"command"`  // Command number.
+	Name     string    `json:"name"`     // Command name.
+	Sequence uint32    `json:"sequence"` // Sequence number.
+	Size     int       `json:"size"`     // Size of the decrypted payload.
+	SizeRaw  int       `json:"sizeraw"`  // Size of the encrypted packet on the wire.
+}
+
+/*
+apiCaptureStart starts capturing the metadata of incoming and outgoing packets. Records of a previous capture are discarded.
+If peer is set, only packets of that peer are captured. If folder is set, the records are also appended to a text file per peer in that folder.
+
+Request:    GET /diagnostics/capture/start?peer=[peer ID]&records=[max records per peer]&folder=[path]
+Response:   200 with JSON structure apiCaptureStatus. 400 if the peer ID is invalid. 409 if capturing is already active.
+*/
+func (api *WebapiInstance) apiCaptureStart(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	var options core.CaptureOptions
+	var err error
+
+	if peerID := r.Form.Get("peer"); peerID != "" {
+		if options.Peer, err = core.PublicKeyFromPeerID(peerID); err != nil {
+			EncodeError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	options.RecordsPerPeer, _ = strconv.Atoi(r.Form.Get("records"))
+	options.Folder = r.Form.Get("folder")
+
+	if err = api.Backend.CaptureStart(options); err != nil {
+		EncodeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, captureStatusToAPI(api.Backend.CaptureStatus()))
+}
+
+/*
+apiCaptureStop stops capturing packets. The records are kept until the next capture is started.
+
+Request:    GET /diagnostics/capture/stop
+Response:   200 with JSON structure apiCaptureStatus
+*/
+func (api *WebapiInstance) apiCaptureStop(w http.ResponseWriter, r *http.Request) {
+	api.Backend.CaptureStop()
+
+	EncodeJSON(api.Backend, w, r, captureStatusToAPI(api.Backend.CaptureStatus()))
+}
+
+/*
+apiCaptureStatus returns the status of the packet capture.
+
+Request:    GET /diagnostics/capture/status
+Response:   200 with JSON structure apiCaptureStatus
+*/
+func (api *WebapiInstance) apiCaptureStatus(w http.ResponseWriter, r *http.Request) {
+	EncodeJSON(api.Backend, w, r, captureStatusToAPI(api.Backend.CaptureStatus()))
+}
+
+/*
+apiCaptureDownload downloads the captured records sorted by time. If peer is set, only the records of that peer are returned.
+The format is either json (default) or text, which returns one record per line.
+
+Request:    GET /diagnostics/capture?peer=[peer ID]&format=[json or text]
+Response:   200 with JSON array apiCaptureRecord or text file. 400 if the peer ID is invalid.
+*/
+func (api *WebapiInstance) apiCaptureDownload(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	var publicKey *btcec.PublicKey
+	var err error
+
+	if peerID := r.Form.Get("peer"); peerID != "" {
+		if publicKey, err = core.PublicKeyFromPeerID(peerID); err != nil {
+			EncodeError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+
+	records := api.Backend.CaptureRecords(publicKey)
+
+	if r.Form.Get("format") == "text" {
+		var builder strings.Builder
+		for _, record := range records {
+			builder.WriteString(record.String() + "\n")
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"capture.txt\"")
+		w.Write([]byte(builder.String()))
+		return
+	}
+
+	result := []apiCaptureRecord{}
+	for _, record := range records {
+		result = append(result, apiCaptureRecord{Time: record.Time, Outgoing: record.Outgoing, PeerID: record.PublicKey.SerializeCompressed(), Local: record.Local, Remote: record.Remote, Command: record.Command, Name: protocol.CommandName(record.Command), Sequence: record.Sequence, Size: record.Size, SizeRaw: record.SizeRaw})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+type apiBenchmarkResult struct {
+	Size     uint64  `json:"size"`     // Count of bytes received.
+	Duration float64 `json:"duration"` // Duration in seconds from requesting the transfer until the last byte was received.
+	Rate     float64 `json:"rate"`     // Average rate in bytes per second.
+	Complete bool    `json:"complete"` // Whether the size announced by the remote peer was received. False if it ended the transfer early.
+}
+
+/*
+apiBenchmark measures the transfer speed from the remote peer via a benchmark transfer of generated data. No file is read from or written to a warehouse.
+The size is optional (default 8 MB); the remote peer caps it at 64 MB, the rate at 16 MB/s, and the duration at 20 seconds. The timeout is in seconds (default 30).
+
+Request:    GET /diagnostics/benchmark?peer=[peer ID]&size=[bytes]&timeout=[seconds]
+Response:   200 with JSON structure apiBenchmarkResult. 400 if the peer ID is invalid. 404 if the peer is not found. 502 if the transfer failed.
+*/
+func (api *WebapiInstance) apiBenchmark(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	publicKey, err := core.PublicKeyFromPeerID(r.Form.Get("peer"))
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+	size, _ := strconv.ParseUint(r.Form.Get("size"), 10, 64)
+	timeout, _ := strconv.Atoi(r.Form.Get("timeout"))
+	if timeout <= 0 {
+		timeout = 30
+	}
+
+	peer, err := PeerConnectPublicKey(api.Backend, publicKey, time.Duration(timeout)*time.Second)
+	if err != nil {
+		EncodeError(w, http.StatusNotFound, "")
+		return
+	}
+
+	result, err := peer.TransferBenchmark(size, time.Duration(timeout)*time.Second)
+	if err != nil {
+		EncodeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, apiBenchmarkResult{Size: result.Size, Duration: result.Duration.Seconds(), Rate: result.Rate, Complete: result.Complete})
+}
+
+func captureStatusToAPI(status core.CaptureStatus) (result apiCaptureStatus) {
+	result = apiCaptureStatus{Active: status.Active, Started: status.Started, Stopped: status.Stopped, RecordsPerPeer: status.Options.RecordsPerPeer, Folder: status.Options.Folder, Peers: status.Peers, Records: status.Records, Dropped: status.Dropped}
+	if status.Options.Peer != nil {
+		result.PeerID = status.Options.Peer.SerializeCompressed()
+	}
+
+	return result
+}
@@ -0,0 +1,206 @@
+/*
+File Username:  Download Limits.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+Per-download limits for users on metered or shared connections. Each download may have its own rate cap and a daily schedule of active hours.
+Outside the active hours the transfer is closed and resumed at the current offset once the window opens again.
+*/
+
+package webapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// downloadWaitInterval is the interval to check if a paused or scheduled download may continue.
+const downloadWaitInterval = time.Second
+
+// downloadSchedule is a daily window of active hours in local time. If the end is before the start, the window spans midnight.
+type downloadSchedule struct {
+	start, end time.Duration // Offset since midnight.
+}
+
+// parseDownloadSchedule parses a schedule in the format "HH:MM-HH:MM". An empty text returns nil, which means always active.
+func parseDownloadSchedule(text string) (schedule *downloadSchedule, err error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(text, "-")
+	if len(parts) != 2 {
+		return nil, errors.New("invalid schedule")
+	}
+
+	schedule = &downloadSchedule{}
+	for n, target := range []*time.Duration{&schedule.start, &schedule.end} {
+		clock, err := time.Parse("15:04", strings.TrimSpace(parts[n]))
+		if err != nil {
+			return nil, errors.New("invalid schedule time")
+		}
+		*target = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+	}
+
+	if schedule.start == schedule.end {
+		return nil, errors.New("empty schedule window")
+	}
+
+	return schedule, nil
+}
+
+// String returns the schedule in the format "HH:MM-HH:MM".
+func (schedule *downloadSchedule) String() string {
+	if schedule == nil {
+		return ""
+	}
+
+	clock := func(offset time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(offset.Hours()), int(offset.Minutes())%60)
+	}
+
+	return clock(schedule.start) + "-" + clock(schedule.end)
+}
+
+// isActive checks if the time is within the active hours. A nil schedule is always active.
+func (schedule *downloadSchedule) isActive(now time.Time) bool {
+	if schedule == nil {
+		return true
+	}
+
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+
+	if schedule.start < schedule.end {
+		return offset >= schedule.start && offset < schedule.end
+	}
+	return offset >= schedule.start || offset < schedule.end
+}
+
+// waitActive blocks while the download is paused by the user or outside the active hours of its schedule.
+// It returns false if the download was canceled. Waited indicates if it had to wait.
+func (info *downloadInfo) waitActive() (active, waited bool) {
+	for {
+		info.RLock()
+		status := info.status
+		schedule := info.Limits.Schedule
+		info.RUnlock()
+
+		if status == DownloadCanceled || status == DownloadFinished {
+			return false, waited
+		} else if status != DownloadPause && schedule.isActive(time.Now()) {
+			info.setWaitSchedule(false)
+			return true, waited
+		}
+
+		info.setWaitSchedule(status != DownloadPause)
+		waited = true
+		time.Sleep(downloadWaitInterval)
+	}
+}
+
+func (info *downloadInfo) setWaitSchedule(waiting bool) {
+	info.Lock()
+	info.Limits.waitSchedule = waiting
+	info.Unlock()
+}
+
+// downloadPacer paces the reading of downloaded data to stay below the rate cap. It is shared by all segments of a download.
+type downloadPacer struct {
+	start time.Time
+	bytes uint64
+	rate  uint64
+	sync.Mutex
+}
+
+// pace records the read bytes and sleeps as required by the current rate cap of the download.
+func (pacer *downloadPacer) pace(info *downloadInfo, read int) {
+	info.RLock()
+	rate := info.Limits.Rate
+	info.RUnlock()
+
+	pacer.Lock()
+
+	// Restart the measurement if the rate changed.
+	if rate != pacer.rate || pacer.start.IsZero() {
+		pacer.start = time.Now()
+		pacer.bytes = 0
+		pacer.rate = rate
+	}
+
+	pacer.bytes += uint64(read)
+
+	var ahead time.Duration
+	if rate > 0 {
+		ahead = time.Duration(float64(pacer.bytes)/float64(rate)*float64(time.Second)) - time.Since(pacer.start)
+	}
+
+	pacer.Unlock()
+
+	if ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
+
+// reset restarts the measurement, for example after the download was paused.
+func (pacer *downloadPacer) reset() {
+	pacer.Lock()
+	pacer.start = time.Now()
+	pacer.bytes = 0
+	pacer.Unlock()
+}
+
+// parseDownloadLimits parses the optional parameters rate and schedule.
+func parseDownloadLimits(r *http.Request) (rate uint64, schedule *downloadSchedule, err error) {
+	if text := r.Form.Get("rate"); text != "" {
+		if rate, err = strconv.ParseUint(text, 10, 64); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	schedule, err = parseDownloadSchedule(r.Form.Get("schedule"))
+
+	return rate, schedule, err
+}
+
+/*
+apiDownloadLimit changes the rate cap and schedule of a download. Both apply immediately.
+The rate is the max rate in bytes per second (0 = unlimited). The schedule is the daily active hours in local time as "HH:MM-HH:MM" (empty = always).
+
+Request:    GET /download/limit?id=[download ID]&rate=[bytes per second]&schedule=[HH:MM-HH:MM]
+Result:     200 with JSON structure apiResponseDownloadStatus. 400 if the parameters are invalid.
+*/
+func (api *WebapiInstance) apiDownloadLimit(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := uuid.Parse(r.Form.Get("id"))
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	rate, schedule, err := parseDownloadLimits(r)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	info := api.downloadLookup(id)
+	if info == nil {
+		EncodeJSON(api.Backend, w, r, apiResponseDownloadStatus{APIStatus: DownloadResponseIDNotFound})
+		return
+	}
+
+	info.Lock()
+	info.Limits.Rate = rate
+	info.Limits.Schedule = schedule
+	info.Unlock()
+
+	EncodeJSON(api.Backend, w, r, info.statusToAPI())
+}
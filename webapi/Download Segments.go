@@ -0,0 +1,206 @@
+/*
+File Username:  Download Segments.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+A single UDT flow is limited by its flow window to roughly one window of data per round-trip, which under-utilizes connections with high RTT.
+Large files from such peers are therefore split into disjoint segments that are downloaded in parallel, each via its own UDT transfer from the
+same peer. Each segment tracks its position, which is the resumable state of the download: When a download is paused or waits for its schedule,
+all transfers are closed and later resumed at the position of each segment. The segments write directly into the target file.
+*/
+
+package webapi
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core/udt"
+)
+
+// downloadSegmentMinFileSize is the min file size to download in multiple segments.
+const downloadSegmentMinFileSize = 16 * 1024 * 1024
+
+// downloadSegmentMinSize is the min size of each segment.
+const downloadSegmentMinSize = 4 * 1024 * 1024
+
+// downloadSegmentRTT is the RTT per additional segment. A peer with an RTT below is downloaded via a single transfer.
+const downloadSegmentRTT = 50 * time.Millisecond
+
+// downloadSegmentMax is the max count of segments downloaded in parallel.
+const downloadSegmentMax = 4
+
+// downloadReadSize is the size of each read from a transfer.
+const downloadReadSize = 4096
+
+// downloadSegment is a range of the file downloaded via a single transfer.
+type downloadSegment struct {
+	Offset   uint64 // Start offset.
+	End      uint64 // End offset (exclusive).
+	Position uint64 // Next offset to download. The segment is complete if Position equals End.
+
+	conn *udt.UDTSocket // Active transfer. Nil if none. It is used for live statistics.
+}
+
+// downloadSegmentCount returns the count of segments to download the file in parallel from a peer with the given RTT.
+func downloadSegmentCount(fileSize uint64, rtt time.Duration) (count int) {
+	if fileSize < downloadSegmentMinFileSize || rtt < downloadSegmentRTT {
+		return 1
+	}
+
+	count = 1 + int(rtt/downloadSegmentRTT)
+	if count > downloadSegmentMax {
+		count = downloadSegmentMax
+	}
+	if maxCount := int(fileSize / downloadSegmentMinSize); count > maxCount {
+		count = maxCount
+	}
+
+	return count
+}
+
+// initSegments splits the file into the given count of disjoint segments.
+func (info *downloadInfo) initSegments(fileSize uint64, count int) {
+	info.Lock()
+	defer info.Unlock()
+
+	info.Segments = nil
+	segmentSize := fileSize / uint64(count)
+
+	for n := 0; n < count; n++ {
+		segment := &downloadSegment{Offset: uint64(n) * segmentSize, End: uint64(n+1) * segmentSize}
+		if n == count-1 {
+			segment.End = fileSize
+		}
+		segment.Position = segment.Offset
+
+		info.Segments = append(info.Segments, segment)
+	}
+}
+
+// downloadSegments downloads all segments in parallel. The reader is optional and used for the first segment. It returns true if all segments are complete.
+func (info *downloadInfo) downloadSegments(reader io.ReadCloser) (complete bool) {
+	var pacer downloadPacer
+	var wg sync.WaitGroup
+
+	info.RLock()
+	segments := info.Segments
+	info.RUnlock()
+
+	for n, segment := range segments {
+		var segmentReader io.ReadCloser
+		if n == 0 {
+			segmentReader = reader
+		}
+
+		wg.Add(1)
+		go func(segment *downloadSegment, reader io.ReadCloser) {
+			defer wg.Done()
+			info.downloadSegment(segment, reader, &pacer)
+		}(segment, segmentReader)
+	}
+
+	wg.Wait()
+
+	info.RLock()
+	defer info.RUnlock()
+
+	for _, segment := range segments {
+		if segment.Position != segment.End {
+			return false
+		}
+	}
+
+	return true
+}
+
+// downloadSegment downloads the remaining data of the segment. The reader is optional; it must be positioned at the current position of the segment.
+// While the download is paused or waits for its schedule, the transfer is closed. Any error cancels the entire download.
+func (info *downloadInfo) downloadSegment(segment *downloadSegment, reader io.ReadCloser, pacer *downloadPacer) {
+	defer func() {
+		if reader != nil {
+			reader.Close()
+		}
+		info.setSegmentConn(segment, nil)
+	}()
+
+	info.setSegmentConn(segment, reader)
+
+	data := make([]byte, downloadReadSize)
+
+	for {
+		info.RLock()
+		position, end := segment.Position, segment.End
+		info.RUnlock()
+
+		if position >= end {
+			return
+		}
+
+		active, waited := info.waitActive()
+		if !active {
+			return
+		} else if waited {
+			if reader != nil {
+				reader.Close()
+				reader = nil
+				info.setSegmentConn(segment, nil)
+			}
+			pacer.reset()
+		}
+
+		if reader == nil {
+			var fileSize, transferSize uint64
+			var err error
+
+			reader, fileSize, transferSize, err = FileStartReader(info.peer, info.hash, position, end-position, nil)
+			if err != nil || fileSize != info.file.Size || transferSize != end-position {
+				info.fail()
+				return
+			}
+
+			info.setSegmentConn(segment, reader)
+		}
+
+		readSize := uint64(len(data))
+		if end-position < readSize {
+			readSize = end - position
+		}
+
+		n, err := reader.Read(data[:readSize])
+		if err != nil {
+			info.fail()
+			return
+		}
+
+		if info.storeDownloadData(data[:n], position) != DownloadResponseSuccess {
+			return
+		}
+
+		info.Lock()
+		segment.Position += uint64(n)
+		info.Unlock()
+
+		pacer.pace(info, n)
+	}
+}
+
+// setSegmentConn sets the active transfer of the segment. The reader is nil if there is none.
+func (info *downloadInfo) setSegmentConn(segment *downloadSegment, reader io.ReadCloser) {
+	conn, _ := reader.(*udt.UDTSocket)
+
+	info.Lock()
+	segment.conn = conn
+	info.Unlock()
+}
+
+// fail cancels the download due to an error.
+func (info *downloadInfo) fail() {
+	info.Lock()
+	defer info.Unlock()
+
+	if info.status < DownloadCanceled {
+		info.status = DownloadCanceled
+	}
+}
@@ -0,0 +1,349 @@
+/*
+File Username:  Download Swarm.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+Large files stored by multiple peers are downloaded from all of them at once (swarm download). The file is split into segments aligned to the
+fragments of its merkle tree, which is requested from the owner of the file. Each source downloads one segment at a time from a shared queue,
+so that peers with a lower RTT and a higher bandwidth download more segments. Sources are selected by RTT.
+
+Each segment is verified against the merkle tree before it is complete. A segment that fails the verification or stalls is requeued and
+downloaded from another source. Sources that sent invalid data or stalled repeatedly are dropped. Once all segments are complete, the hash of
+the entire file is verified. If no swarm can be formed, the file is downloaded from the owner only, see downloadSegments.
+*/
+
+package webapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/merkle"
+	"github.com/PeernetOfficial/core/udt"
+	"lukechampine.com/blake3"
+)
+
+// downloadSwarmMinFileSize is the min file size to download from multiple sources.
+const downloadSwarmMinFileSize = downloadSegmentMinFileSize
+
+// downloadSwarmMaxSources is the max count of sources to download from in parallel, including the owner.
+const downloadSwarmMaxSources = 8
+
+// downloadSwarmFindTimeout is the timeout for finding peers storing the file.
+const downloadSwarmFindTimeout = 10 * time.Second
+
+// downloadSwarmStallTimeout is the max time to wait for data from a source before the segment is requeued.
+const downloadSwarmStallTimeout = 20 * time.Second
+
+// downloadSwarmMaxStalls is the count of stalled segments after which a source is dropped.
+const downloadSwarmMaxStalls = 3
+
+// Results of downloading a segment from a source
+const (
+	swarmSegmentComplete = iota // The segment is downloaded and verified.
+	swarmSegmentStalled         // The source stalled or the transfer failed. The segment is requeued.
+	swarmSegmentInvalid         // The data does not match the merkle tree. The segment is requeued and the source dropped.
+	swarmSegmentStopped         // The download was canceled or failed.
+)
+
+// downloadSwarm is the shared state of the sources of a swarm download.
+type downloadSwarm struct {
+	tree    *merkle.MerkleTree // Merkle tree of the file to verify segments.
+	pending []*downloadSegment // Segments not assigned to a source.
+	active  int                // Count of segments currently downloaded.
+	sources int                // Count of sources still participating.
+	stopped bool               // Whether the download stopped. No more segments are assigned.
+	cond    *sync.Cond         // Signals changes to idle sources.
+	sync.Mutex
+}
+
+// joinSwarm finds the sources and requests the merkle tree for a swarm download. It returns nil if the file should be downloaded from the owner only.
+func (info *downloadInfo) joinSwarm(fileSize uint64) (tree *merkle.MerkleTree, sources []*core.PeerInfo) {
+	if fileSize < downloadSwarmMinFileSize {
+		return nil, nil
+	}
+
+	if sources = info.swarmSources(); len(sources) < 2 {
+		return nil, nil
+	}
+
+	tree, err := info.peer.MerkleTreeRequest(info.hash, fileSize)
+	if err != nil || tree.FragmentCount < 2 {
+		return nil, nil
+	}
+
+	return tree, sources
+}
+
+// swarmSources returns the connected peers storing the file including the owner, sorted by RTT.
+func (info *downloadInfo) swarmSources() (sources []*core.PeerInfo) {
+	sources = append(sources, info.peer)
+
+	storing, _ := info.backend.FindStoringPeers(info.hash, downloadSwarmFindTimeout)
+
+	for _, peer := range storing {
+		// Storing peers may be temporary structures. Only peers with an active connection are used.
+		if peer = info.backend.PeerlistLookup(peer.PublicKey); peer == nil || !peer.IsConnectionActive() || peer.PublicKey.IsEqual(info.peer.PublicKey) {
+			continue
+		}
+		sources = append(sources, peer)
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].GetRTT() < sources[j].GetRTT() })
+
+	if len(sources) > downloadSwarmMaxSources {
+		sources = sources[:downloadSwarmMaxSources]
+	}
+
+	return sources
+}
+
+// downloadSwarm downloads the file from all sources in parallel. It returns true if the file is complete and verified, otherwise the download is canceled.
+func (info *downloadInfo) downloadSwarm(tree *merkle.MerkleTree, sources []*core.PeerInfo) (complete bool) {
+	swarm := &downloadSwarm{tree: tree, sources: len(sources)}
+	swarm.cond = sync.NewCond(&swarm.Mutex)
+
+	info.Lock()
+	info.Segments = nil
+	for n := uint64(0); n < tree.FragmentCount; n++ {
+		segment := &downloadSegment{Offset: n * tree.FragmentSize, End: (n + 1) * tree.FragmentSize}
+		if segment.End > tree.FileSize {
+			segment.End = tree.FileSize
+		}
+		segment.Position = segment.Offset
+
+		info.Segments = append(info.Segments, segment)
+	}
+	swarm.pending = append(swarm.pending, info.Segments...)
+	info.Swarm.CountPeers = uint64(len(sources))
+	info.Unlock()
+
+	var pacer downloadPacer
+	var wg sync.WaitGroup
+
+	for _, peer := range sources {
+		wg.Add(1)
+		go func(peer *core.PeerInfo) {
+			defer wg.Done()
+			info.swarmWorker(swarm, peer, &pacer)
+		}(peer)
+	}
+
+	wg.Wait()
+
+	info.RLock()
+	complete = true
+	for _, segment := range info.Segments {
+		if segment.Position != segment.End {
+			complete = false
+			break
+		}
+	}
+	info.RUnlock()
+
+	if complete && !info.verifyFileHash() {
+		info.backend.LogError("downloadSwarm", "file %s does not match its hash\n", hex.EncodeToString(info.hash))
+		complete = false
+	}
+
+	if !complete {
+		info.fail()
+	}
+
+	return complete
+}
+
+// swarmWorker downloads segments from the source until all segments are assigned or the source is dropped.
+func (info *downloadInfo) swarmWorker(swarm *downloadSwarm, peer *core.PeerInfo, pacer *downloadPacer) {
+	defer swarm.leave(info)
+
+	stalls := 0
+
+	for {
+		segment := swarm.next()
+		if segment == nil {
+			return
+		}
+
+		result := info.downloadSwarmSegment(swarm.tree, segment, peer, pacer)
+		swarm.finish(segment, result)
+
+		switch result {
+		case swarmSegmentInvalid:
+			info.backend.LogError("swarmWorker", "dropping source %s: invalid data at offset %d of file %s\n", hex.EncodeToString(peer.PublicKey.SerializeCompressed()), segment.Offset, hex.EncodeToString(info.hash))
+			return
+
+		case swarmSegmentStalled:
+			if stalls++; stalls >= downloadSwarmMaxStalls {
+				return
+			}
+
+		case swarmSegmentStopped:
+			return
+		}
+	}
+}
+
+// downloadSwarmSegment downloads the segment from the source and verifies it. Incomplete segments are reset.
+func (info *downloadInfo) downloadSwarmSegment(tree *merkle.MerkleTree, segment *downloadSegment, peer *core.PeerInfo, pacer *downloadPacer) (result int) {
+	var reader io.ReadCloser
+
+	defer func() {
+		if reader != nil {
+			reader.Close()
+		}
+		info.setSegmentConn(segment, nil)
+
+		if result != swarmSegmentComplete {
+			info.resetSegment(segment)
+		}
+	}()
+
+	hasher := blake3.New(32, nil)
+	data := make([]byte, downloadReadSize)
+
+	for {
+		info.RLock()
+		position, end := segment.Position, segment.End
+		info.RUnlock()
+
+		if position >= end {
+			break
+		}
+
+		active, waited := info.waitActive()
+		if !active {
+			return swarmSegmentStopped
+		} else if waited {
+			if reader != nil {
+				reader.Close()
+				reader = nil
+				info.setSegmentConn(segment, nil)
+			}
+			pacer.reset()
+		}
+
+		if reader == nil {
+			var fileSize, transferSize uint64
+			var err error
+
+			reader, fileSize, transferSize, err = FileStartReader(peer, info.hash, position, end-position, nil)
+			if err != nil || fileSize != info.file.Size || transferSize != end-position {
+				return swarmSegmentStalled
+			}
+
+			info.setSegmentConn(segment, reader)
+		}
+
+		if conn, ok := reader.(*udt.UDTSocket); ok {
+			conn.SetReadDeadline(time.Now().Add(downloadSwarmStallTimeout))
+		}
+
+		readSize := uint64(len(data))
+		if end-position < readSize {
+			readSize = end - position
+		}
+
+		n, err := reader.Read(data[:readSize])
+		if err != nil {
+			return swarmSegmentStalled
+		}
+
+		hasher.Write(data[:n])
+
+		if info.storeDownloadData(data[:n], position) != DownloadResponseSuccess {
+			return swarmSegmentStopped
+		}
+
+		info.Lock()
+		segment.Position += uint64(n)
+		info.Unlock()
+
+		pacer.pace(info, n)
+	}
+
+	if !merkle.MerkleVerify(tree.RootHash, hasher.Sum(nil), tree.CreateVerification(segment.Offset/tree.FragmentSize)) {
+		return swarmSegmentInvalid
+	}
+
+	return swarmSegmentComplete
+}
+
+// resetSegment discards the downloaded data of the segment, so that it is downloaded again from its start.
+func (info *downloadInfo) resetSegment(segment *downloadSegment) {
+	info.Lock()
+	defer info.Unlock()
+
+	info.DiskFile.StoredSize -= segment.Position - segment.Offset
+	segment.Position = segment.Offset
+}
+
+// verifyFileHash verifies the hash of the downloaded file.
+func (info *downloadInfo) verifyFileHash() (valid bool) {
+	hasher := blake3.New(32, nil)
+	if _, err := io.Copy(hasher, io.NewSectionReader(info.DiskFile.Handle, 0, int64(info.file.Size))); err != nil {
+		return false
+	}
+
+	return bytes.Equal(hasher.Sum(nil), info.hash)
+}
+
+// next assigns the next pending segment to a source. If none is pending, it waits for segments of other sources that might be requeued.
+// It returns nil if all segments are assigned or the download stopped.
+func (swarm *downloadSwarm) next() (segment *downloadSegment) {
+	swarm.Lock()
+	defer swarm.Unlock()
+
+	for {
+		if swarm.stopped {
+			return nil
+		} else if len(swarm.pending) > 0 {
+			segment = swarm.pending[0]
+			swarm.pending = swarm.pending[1:]
+			swarm.active++
+			return segment
+		} else if swarm.active == 0 {
+			return nil
+		}
+
+		swarm.cond.Wait()
+	}
+}
+
+// finish records the result of a segment. Incomplete segments are requeued.
+func (swarm *downloadSwarm) finish(segment *downloadSegment, result int) {
+	swarm.Lock()
+	defer swarm.Unlock()
+
+	swarm.active--
+
+	if result != swarmSegmentComplete {
+		swarm.pending = append(swarm.pending, segment)
+	}
+	if result == swarmSegmentStopped {
+		swarm.stopped = true
+	}
+
+	swarm.cond.Broadcast()
+}
+
+// leave removes a source. If no source is left, the download stops.
+func (swarm *downloadSwarm) leave(info *downloadInfo) {
+	swarm.Lock()
+	swarm.sources--
+	sources := swarm.sources
+	if sources == 0 {
+		swarm.stopped = true
+	}
+	swarm.cond.Broadcast()
+	swarm.Unlock()
+
+	info.Lock()
+	info.Swarm.CountPeers = uint64(sources)
+	info.Unlock()
+}
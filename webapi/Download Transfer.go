@@ -10,9 +10,12 @@ package webapi
 
 import (
 	"bytes"
+	"encoding/hex"
 	"os"
 	"time"
 
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/btcec"
 	"github.com/PeernetOfficial/core/warehouse"
 )
 
@@ -42,56 +45,79 @@ func (info *downloadInfo) Start() {
 func (info *downloadInfo) Download() {
 	//fmt.Printf("Download start of %s\n", hex.EncodeToString(info.hash))
 
-	// try to download the entire file
-	reader, fileSize, transferSize, err := FileStartReader(info.peer, info.hash, 0, 0, nil)
-	if reader != nil {
-		defer reader.Close()
+	// Wait for the active hours before requesting the file.
+	if active, _ := info.waitActive(); !active {
+		return
 	}
+
+	// The first transfer requests the entire file to get its size. It is used for the first segment.
+	reader, fileSize, transferSize, err := FileStartReader(info.peer, info.hash, 0, 0, nil)
 	if err != nil {
+		if reader != nil {
+			reader.Close()
+		}
 		info.status = DownloadCanceled
 		return
 	} else if fileSize != transferSize {
+		reader.Close()
 		info.status = DownloadCanceled
 		return
 	}
 
 	info.file.Size = fileSize
-	info.status = DownloadActive
 
-	// download in a loop
-	var fileOffset, totalRead uint64
-	dataRemaining := fileSize
-	readSize := uint64(4096)
+	// Large files are downloaded from all peers storing them, if any. The first transfer is closed while looking for the swarm.
+	if fileSize >= downloadSwarmMinFileSize {
+		reader.Close()
+		reader = nil
 
-	for dataRemaining > 0 {
-		//fmt.Printf("data remaining:  downloaded %d from total %d   = %d %%\n", totalRead, fileSize, totalRead*100/fileSize)
-		if dataRemaining < readSize {
-			readSize = dataRemaining
+		info.Lock()
+		if info.status < DownloadCanceled {
+			info.status = DownloadWaitSwarm
 		}
+		info.Unlock()
 
-		data := make([]byte, readSize)
-		n, err := reader.Read(data)
+		if tree, sources := info.joinSwarm(fileSize); tree != nil {
+			if !info.setActive() || !info.downloadSwarm(tree, sources) {
+				return
+			}
 
-		totalRead += uint64(n)
-		dataRemaining -= uint64(n)
-		data = data[:n]
-
-		if err != nil {
-			info.status = DownloadCanceled
+			info.Finish()
+			info.DeleteDefer(time.Hour * 1) // cache the details for 1 hour before removing
 			return
 		}
+	}
 
-		info.storeDownloadData(data[:n], fileOffset)
+	if !info.setActive() {
+		return
+	}
 
-		fileOffset += uint64(n)
+	info.initSegments(fileSize, downloadSegmentCount(fileSize, info.peer.GetRTT()))
+
+	if !info.downloadSegments(reader) {
+		return
 	}
 
-	//fmt.Printf("data finished:  downloaded %d from total %d   = %d %%\n", totalRead, fileSize, totalRead*100/fileSize)
+	//fmt.Printf("data finished:  downloaded %d   = 100 %%\n", fileSize)
 
 	info.Finish()
 	info.DeleteDefer(time.Hour * 1) // cache the details for 1 hour before removing
 }
 
+// setActive changes the status to active after the file size is known. It returns false if the download was canceled meanwhile.
+func (info *downloadInfo) setActive() (active bool) {
+	info.Lock()
+	defer info.Unlock()
+
+	if info.status >= DownloadCanceled {
+		return false
+	}
+
+	info.status = DownloadActive
+
+	return true
+}
+
 // Pause pauses the download. Status is DownloadResponseX.
 func (info *downloadInfo) Pause() (status int) {
 	info.Lock()
@@ -131,6 +157,7 @@ func (info *downloadInfo) Cancel() (status int) {
 
 	info.status = DownloadCanceled
 	info.DiskFile.Handle.Close()
+	os.Remove(info.DiskFile.TempName)
 
 	return DownloadResponseSuccess
 }
@@ -147,15 +174,36 @@ func (info *downloadInfo) Finish() (status int) {
 	info.status = DownloadFinished
 	info.DiskFile.Handle.Close()
 
+	// Move the file to the target. If it fails, the file remains at the temporary location.
+	if final, err := info.backend.DownloadPlace(info.DiskFile.TempName, info.DiskFile.Name, info.DiskFile.TypeFolder); err != nil {
+		info.backend.LogError("Download.Finish", "placing download '%s' at '%s': %v\n", info.DiskFile.TempName, info.DiskFile.Name, err)
+		info.DiskFile.Name = info.DiskFile.TempName
+	} else {
+		info.DiskFile.Name = final
+	}
+
+	var peerKey *btcec.PublicKey
+	if info.peer != nil {
+		peerKey = info.peer.PublicKey
+	}
+	info.backend.SendWebhook(core.WebhookTransferComplete, peerKey, core.WebhookTransfer{Hash: hex.EncodeToString(info.hash), Size: info.file.Size, Direction: "in", NodeID: hex.EncodeToString(info.nodeID), Path: info.DiskFile.Name})
+
 	return DownloadResponseSuccess
 }
 
-// initDiskFile creates the target file
+// initDiskFile checks the target file and creates the temporary file
 func (info *downloadInfo) initDiskFile(path string) (err error) {
+	if err = info.backend.DownloadCheckTarget(path); err != nil {
+		return err
+	}
+
 	info.DiskFile.Name = path
-	info.DiskFile.Handle, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666) // 666 : All uses can read/write
+	if info.DiskFile.Handle, err = info.backend.DownloadTempFile(info.id.String(), path); err != nil {
+		return err
+	}
+	info.DiskFile.TempName = info.DiskFile.Handle.Name()
 
-	return err
+	return nil
 }
 
 // storeDownloadData stores downloaded data. It does not change the download status.
@@ -163,7 +211,7 @@ func (info *downloadInfo) storeDownloadData(data []byte, offset uint64) (status
 	info.Lock()
 	defer info.Unlock()
 
-	if info.status != DownloadActive { // The download must be active.
+	if info.status != DownloadActive && info.status != DownloadPause { // The download must be active. Data received right before pausing is stored.
 		return DownloadResponseActionInvalid
 	}
 
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/udt"
 	"github.com/google/uuid"
 )
 
@@ -23,15 +24,36 @@ type apiResponseDownloadStatus struct {
 	APIStatus      int       `json:"apistatus"`      // Status of the API call. See DownloadResponseX.
 	ID             uuid.UUID `json:"id"`             // Download ID. This can be used to query the latest status and take actions.
 	DownloadStatus int       `json:"downloadstatus"` // Status of the download. See DownloadX.
+	Path           string    `json:"path"`           // Target path on disk. Once finished, the final path according to the placement rules.
 	File           apiFile   `json:"file"`           // File information. Only available for status >= DownloadWaitSwarm.
 	Progress       struct {
 		TotalSize      uint64  `json:"totalsize"`      // Total size in bytes.
 		DownloadedSize uint64  `json:"downloadedsize"` // Count of bytes download so far.
 		Percentage     float64 `json:"percentage"`     // Percentage downloaded. Rounded to 2 decimal points. Between 0.00 and 100.00.
+		Segments       int     `json:"segments"`       // Count of segments downloaded in parallel.
 	} `json:"progress"` // Progress of the download. Only valid for status >= DownloadWaitSwarm.
 	Swarm struct {
 		CountPeers uint64 `json:"countpeers"` // Count of peers participating in the swarm.
 	} `json:"swarm"` // Information about the swarm. Only valid for status >= DownloadActive.
+	Limits struct {
+		Rate         uint64 `json:"rate"`         // Max rate in bytes per second. 0 = unlimited.
+		Schedule     string `json:"schedule"`     // Daily active hours in local time as "HH:MM-HH:MM". Empty = always active.
+		WaitSchedule bool   `json:"waitschedule"` // Whether the download waits for the active hours.
+	} `json:"limits"` // Limits of the download.
+	Transfers []apiTransferMetrics `json:"transfers"` // Live statistics of each active transfer. Only valid for status = DownloadActive.
+}
+
+// apiTransferMetrics contains live statistics of a single UDT transfer.
+type apiTransferMetrics struct {
+	Started      time.Time `json:"started"`      // When the transfer was started.
+	DataReceived uint64    `json:"datareceived"` // Payload data received in bytes.
+	Speed        float64   `json:"speed"`        // Current transfer speed in bytes per second.
+	RTT          float64   `json:"rtt"`          // Estimated round-trip time in milliseconds.
+	RTTVar       float64   `json:"rttvar"`       // Round-trip time variance in milliseconds.
+	Bandwidth    uint64    `json:"bandwidth"`    // Estimated link capacity in bytes per second.
+	PktReceived  uint64    `json:"pktreceived"`  // Count of data packets received.
+	PktLost      uint64    `json:"pktlost"`      // Count of data packets detected as lost.
+	LossRate     float64   `json:"lossrate"`     // Ratio of lost to expected data packets. Between 0 and 1.
 }
 
 const (
@@ -53,10 +75,13 @@ const (
 )
 
 /*
-apiDownloadStart starts the download of a file. The path is the full path on disk to store the file.
+apiDownloadStart starts the download of a file. The path is the full path on disk to store the file. If the path is not specified, the file is
+stored in the configured download folder using the name parameter as file name (default the hash), see core.DownloadTarget.
+The file is downloaded into a temporary file and moved to the target once complete according to the placement rules.
 The hash parameter identifies the file to download. The node ID identifies the blockchain (i.e., the "owner" of the file).
+The optional rate is the max rate in bytes per second. The optional schedule is the daily active hours in local time as "HH:MM-HH:MM".
 
-Request:    GET /download/start?path=[target path on disk]&hash=[file hash to download]&node=[node ID]
+Request:    GET /download/start?path=[target path on disk<optional>]&name=[file name<optional>]&hash=[file hash to download]&node=[node ID]&rate=[bytes per second<optional>]&schedule=[HH:MM-HH:MM<optional>]
 Result:     200 with JSON structure apiResponseDownloadStatus
 */
 func (api *WebapiInstance) apiDownloadStart(w http.ResponseWriter, r *http.Request) {
@@ -66,17 +91,34 @@ func (api *WebapiInstance) apiDownloadStart(w http.ResponseWriter, r *http.Reque
 	hash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
 	nodeID, valid2 := DecodeBlake3Hash(r.Form.Get("node"))
 	if !valid1 || !valid2 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	rate, schedule, err := parseDownloadLimits(r)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
 	filePath := r.Form.Get("path")
+	typeFolder := false
 	if filePath == "" {
-		http.Error(w, "", http.StatusBadRequest)
-		return
+		name := r.Form.Get("name")
+		if name == "" {
+			name = hex.EncodeToString(hash)
+		}
+
+		if filePath, typeFolder, err = api.Backend.DownloadTarget(name); err != nil {
+			EncodeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
 
 	info := &downloadInfo{backend: api.Backend, api: api, id: uuid.New(), created: time.Now(), hash: hash, nodeID: nodeID}
+	info.Limits.Rate = rate
+	info.Limits.Schedule = schedule
+	info.DiskFile.TypeFolder = typeFolder
 
 	api.Backend.LogError("Download.DownloadStart", "output %v", downloadInfo{backend: api.Backend, api: api, id: uuid.New(), created: time.Now(), hash: hash, nodeID: nodeID})
 
@@ -94,7 +136,7 @@ func (api *WebapiInstance) apiDownloadStart(w http.ResponseWriter, r *http.Reque
 
 	api.Backend.LogError("Download.DownloadStart", "output %v", apiResponseDownloadStatus{APIStatus: DownloadResponseSuccess, ID: info.id, DownloadStatus: DownloadWaitMetadata})
 
-	EncodeJSON(api.Backend, w, r, apiResponseDownloadStatus{APIStatus: DownloadResponseSuccess, ID: info.id, DownloadStatus: DownloadWaitMetadata})
+	EncodeJSON(api.Backend, w, r, apiResponseDownloadStatus{APIStatus: DownloadResponseSuccess, ID: info.id, DownloadStatus: DownloadWaitMetadata, Path: filePath})
 }
 
 /*
@@ -107,7 +149,7 @@ func (api *WebapiInstance) apiDownloadStatus(w http.ResponseWriter, r *http.Requ
 	r.ParseForm()
 	id, err := uuid.Parse(r.Form.Get("id"))
 	if err != nil {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -117,9 +159,19 @@ func (api *WebapiInstance) apiDownloadStatus(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	response := info.statusToAPI()
+
+	api.Backend.LogError("Download.DownloadStatus", "output %v", response)
+
+	EncodeJSON(api.Backend, w, r, response)
+}
+
+// statusToAPI returns the current status of the download.
+func (info *downloadInfo) statusToAPI() (response apiResponseDownloadStatus) {
 	info.RLock()
+	defer info.RUnlock()
 
-	response := apiResponseDownloadStatus{APIStatus: DownloadResponseSuccess, ID: info.id, DownloadStatus: info.status}
+	response = apiResponseDownloadStatus{APIStatus: DownloadResponseSuccess, ID: info.id, DownloadStatus: info.status, Path: info.DiskFile.Name}
 
 	if info.status >= DownloadWaitSwarm {
 		response.File = info.file
@@ -128,17 +180,41 @@ func (api *WebapiInstance) apiDownloadStatus(w http.ResponseWriter, r *http.Requ
 		response.Progress.DownloadedSize = info.DiskFile.StoredSize
 
 		response.Progress.Percentage = math.Round(float64(info.DiskFile.StoredSize)/float64(info.file.Size)*100*100) / 100
+		response.Progress.Segments = len(info.Segments)
 	}
 
 	if info.status >= DownloadActive {
 		response.Swarm.CountPeers = info.Swarm.CountPeers
 	}
 
-	info.RUnlock()
+	if info.status == DownloadActive {
+		for _, segment := range info.Segments {
+			if segment.conn != nil {
+				response.Transfers = append(response.Transfers, transferMetricsToAPI(segment.conn.Metrics()))
+			}
+		}
+	}
 
-	api.Backend.LogError("Download.DownloadStatus", "output %v", response)
+	response.Limits.Rate = info.Limits.Rate
+	response.Limits.Schedule = info.Limits.Schedule.String()
+	response.Limits.WaitSchedule = info.Limits.waitSchedule
 
-	EncodeJSON(api.Backend, w, r, response)
+	return response
+}
+
+// transferMetricsToAPI converts the statistics of a UDT socket.
+func transferMetricsToAPI(metrics udt.Metrics) apiTransferMetrics {
+	return apiTransferMetrics{
+		Started:      metrics.Started,
+		DataReceived: metrics.DataReceived,
+		Speed:        metrics.SpeedReceive,
+		RTT:          float64(metrics.RTT) / float64(time.Millisecond),
+		RTTVar:       float64(metrics.RTTVar) / float64(time.Millisecond),
+		Bandwidth:    metrics.Bandwidth,
+		PktReceived:  metrics.PktRecvData,
+		PktLost:      metrics.PktRcvLoss,
+		LossRate:     metrics.RecvLossRate,
+	}
 }
 
 /*
@@ -154,7 +230,7 @@ func (api *WebapiInstance) apiDownloadAction(w http.ResponseWriter, r *http.Requ
 	id, err := uuid.Parse(r.Form.Get("id"))
 	action, err2 := strconv.Atoi(r.Form.Get("action"))
 	if err != nil || err2 != nil || action < 0 || action > 2 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -198,8 +274,10 @@ type downloadInfo struct {
 	file apiFile // File metadata (only status >= DownloadWaitSwarm)
 
 	DiskFile struct { // Target file on disk to store downloaded data
-		Name       string   // File name
-		Handle     *os.File // Target file (on disk) to store downloaded data
+		Name       string   // File name of the target. Once finished, the final path.
+		TempName   string   // File name of the temporary file. The download is moved to the target once finished.
+		TypeFolder bool     // Whether the file is placed into a subfolder per file type, see core.DownloadPlace.
+		Handle     *os.File // Temporary file (on disk) to store downloaded data
 		StoredSize uint64   // Count of bytes downloaded and stored in the file
 	}
 
@@ -207,6 +285,14 @@ type downloadInfo struct {
 		CountPeers uint64 // Count of peers participating in the swarm.
 	}
 
+	Segments []*downloadSegment // Segments of the file downloaded in parallel. This is the resumable state of the download. See Download Segments.go.
+
+	Limits struct { // Limits of the download. See Download Limits.go.
+		Rate         uint64            // Max rate in bytes per second. 0 = unlimited.
+		Schedule     *downloadSchedule // Daily active hours. Nil = always active.
+		waitSchedule bool              // Whether the download waits for the active hours.
+	}
+
 	// live connections, to be changed
 	peer *core.PeerInfo
 
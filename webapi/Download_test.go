@@ -2,6 +2,7 @@ package webapi
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 )
 
@@ -11,3 +12,40 @@ func TestDecodeBlake3Hash(t *testing.T) {
 	fmt.Println(hash)
 	fmt.Println(bool)
 }
+
+func TestDownloadSwarmQueue(t *testing.T) {
+	info := &downloadInfo{}
+	segments := []*downloadSegment{{Offset: 0, End: 10}, {Offset: 10, End: 20}}
+
+	swarm := &downloadSwarm{pending: segments, sources: 2}
+	swarm.cond = sync.NewCond(&swarm.Mutex)
+
+	first := swarm.next()
+	second := swarm.next()
+	if first != segments[0] || second != segments[1] {
+		t.Fatalf("segments not assigned in order")
+	}
+
+	// A source waiting for a segment receives the requeued one.
+	requeued := make(chan *downloadSegment)
+	go func() { requeued <- swarm.next() }()
+
+	swarm.finish(first, swarmSegmentComplete)
+	swarm.finish(second, swarmSegmentStalled)
+
+	if segment := <-requeued; segment != second {
+		t.Fatalf("stalled segment not requeued")
+	}
+
+	// Once the last source leaves, no more segments are assigned.
+	swarm.finish(second, swarmSegmentStalled)
+	swarm.leave(info)
+	swarm.leave(info)
+
+	if swarm.next() != nil {
+		t.Fatalf("segment assigned after all sources left")
+	}
+	if info.Swarm.CountPeers != 0 {
+		t.Fatalf("count of peers not updated")
+	}
+}
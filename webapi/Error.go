@@ -0,0 +1,85 @@
+/*
+File Username:  Error.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+All endpoints return the same JSON error envelope if the HTTP status code indicates an error (4xx or 5xx). The code is a stable identifier
+derived from the HTTP status code, the message is a human readable description, and details may contain the underlying error text.
+*/
+
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorResponse is the JSON error envelope returned by all endpoints in case of an HTTP error status.
+type ErrorResponse struct {
+	Code      string `json:"code"`      // Error code, see ErrorCodeX.
+	Message   string `json:"message"`   // Human readable description of the error.
+	Details   string `json:"details"`   // Details such as the underlying error text. May be empty.
+	Retryable bool   `json:"retryable"` // Whether the same request may succeed if retried later.
+}
+
+// Error codes returned in ErrorResponse.
+const (
+	ErrorCodeBadRequest         = "bad_request"         // Invalid or missing input parameters.
+	ErrorCodeUnauthorized       = "unauthorized"        // Missing or invalid API key.
+	ErrorCodeForbidden          = "forbidden"           // The action is not allowed.
+	ErrorCodeNotFound           = "not_found"           // The requested resource was not found.
+	ErrorCodeConflict           = "conflict"            // The action conflicts with an operation in progress.
+	ErrorCodeTooLarge           = "too_large"           // The request body is too large.
+	ErrorCodeTooManyRequests    = "too_many_requests"   // Rate limit exceeded.
+	ErrorCodeLegalReasons       = "legal_reasons"       // Unavailable due to a denial list.
+	ErrorCodeInternal           = "internal_error"      // Internal error.
+	ErrorCodeBadGateway         = "bad_gateway"         // The remote peer could not be reached or returned an error.
+	ErrorCodeServiceUnavailable = "service_unavailable" // The required subsystem is not available.
+	ErrorCodeTimeout            = "timeout"             // The operation timed out.
+	ErrorCodeUnknown            = "unknown"             // Any other error status.
+)
+
+// errorCodes maps HTTP status codes to error codes.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:                 ErrorCodeBadRequest,
+	http.StatusUnauthorized:               ErrorCodeUnauthorized,
+	http.StatusForbidden:                  ErrorCodeForbidden,
+	http.StatusNotFound:                   ErrorCodeNotFound,
+	http.StatusConflict:                   ErrorCodeConflict,
+	http.StatusRequestEntityTooLarge:      ErrorCodeTooLarge,
+	http.StatusTooManyRequests:            ErrorCodeTooManyRequests,
+	http.StatusUnavailableForLegalReasons: ErrorCodeLegalReasons,
+	http.StatusInternalServerError:        ErrorCodeInternal,
+	http.StatusBadGateway:                 ErrorCodeBadGateway,
+	http.StatusServiceUnavailable:         ErrorCodeServiceUnavailable,
+	http.StatusGatewayTimeout:             ErrorCodeTimeout,
+	http.StatusRequestTimeout:             ErrorCodeTimeout,
+}
+
+// errorRetryable checks if a request failing with the HTTP status code may succeed if retried later.
+func errorRetryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// NewErrorResponse creates the error envelope for the HTTP status code.
+func NewErrorResponse(status int, details string) (response ErrorResponse) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = ErrorCodeUnknown
+	}
+
+	return ErrorResponse{Code: code, Message: http.StatusText(status), Details: details, Retryable: errorRetryable(status)}
+}
+
+// EncodeError sends the HTTP status code with the JSON error envelope to the client. Details may be empty.
+func EncodeError(w http.ResponseWriter, status int, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(NewErrorResponse(status, details))
+}
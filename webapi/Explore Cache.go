@@ -0,0 +1,132 @@
+/*
+File Username:  Explore Cache.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+Computing explore results reads the blockchains of all peers in the peer list. UI clients typically poll /explore, so the results are cached
+per query (file type, limit, offset, and node) as a snapshot. A snapshot younger than exploreCacheTTL is returned as is. An older one is
+still returned, but recomputed in the background for the next call. Snapshots older than exploreCacheMaxAge are recomputed before returning,
+unless a recomputation is already running. Concurrent calls of the same query share a single computation.
+*/
+
+package webapi
+
+import (
+	"sync"
+	"time"
+)
+
+// exploreCacheTTL is the time a snapshot is returned without recomputing.
+const exploreCacheTTL = 10 * time.Second
+
+// exploreCacheMaxAge is the max age of a snapshot to be returned. Older snapshots are recomputed synchronously and eventually removed.
+const exploreCacheMaxAge = 2 * time.Minute
+
+// exploreCacheMaxEntries is the max count of cached snapshots. The oldest one is removed if exceeded.
+const exploreCacheMaxEntries = 256
+
+type exploreCacheKey struct {
+	fileType int
+	limit    int
+	offset   int
+	nodeID   string // Empty if not filtered by node.
+}
+
+type exploreCacheEntry struct {
+	result     *SearchResult // Snapshot. Nil until the first computation finished.
+	created    time.Time     // When the snapshot was computed.
+	refreshing bool          // Whether the snapshot is being recomputed.
+	ready      chan struct{} // Closed when the first computation finished.
+}
+
+// exploreCache contains the snapshots of explore results. The zero value is ready to use.
+type exploreCache struct {
+	entries map[exploreCacheKey]*exploreCacheEntry
+	sync.Mutex
+}
+
+// exploreCached returns the explore results from the cache. See ExploreHelper.
+func (api *WebapiInstance) exploreCached(fileType, limit, offset int, nodeID []byte, nodeIDState bool) *SearchResult {
+	key := exploreCacheKey{fileType: fileType, limit: limit, offset: offset, nodeID: string(nodeID)}
+
+	return api.exploreCache.get(key, func() *SearchResult { return api.ExploreHelper(fileType, limit, offset, nodeID, nodeIDState) })
+}
+
+// get returns the snapshot for the query. Compute is called to create or recompute it.
+func (cache *exploreCache) get(key exploreCacheKey, compute func() *SearchResult) *SearchResult {
+	cache.Lock()
+
+	if cache.entries == nil {
+		cache.entries = make(map[exploreCacheKey]*exploreCacheEntry)
+	}
+
+	entry := cache.entries[key]
+	now := time.Now()
+
+	switch {
+	case entry == nil || (entry.result != nil && now.Sub(entry.created) >= exploreCacheMaxAge && !entry.refreshing):
+		// No usable snapshot. Compute it and let concurrent calls wait for it.
+		entry = &exploreCacheEntry{refreshing: true, ready: make(chan struct{})}
+		cache.entries[key] = entry
+		cache.removeExcess()
+		cache.Unlock()
+
+		cache.store(entry, compute())
+		close(entry.ready)
+
+	case entry.result == nil:
+		// The first snapshot is being computed.
+		cache.Unlock()
+		<-entry.ready
+
+	case now.Sub(entry.created) >= exploreCacheTTL && !entry.refreshing:
+		// Return the snapshot and recompute it in the background.
+		entry.refreshing = true
+		cache.Unlock()
+
+		go func() { cache.store(entry, compute()) }()
+
+	default:
+		cache.Unlock()
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	return entry.result
+}
+
+// store sets the snapshot of the entry.
+func (cache *exploreCache) store(entry *exploreCacheEntry, result *SearchResult) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	entry.result = result
+	entry.created = time.Now()
+	entry.refreshing = false
+}
+
+// removeExcess removes expired snapshots and, if there are still too many, the oldest ones. The cache must be locked.
+func (cache *exploreCache) removeExcess() {
+	for key, entry := range cache.entries {
+		if entry.result != nil && !entry.refreshing && time.Since(entry.created) >= exploreCacheMaxAge {
+			delete(cache.entries, key)
+		}
+	}
+
+	for len(cache.entries) > exploreCacheMaxEntries {
+		var oldestKey exploreCacheKey
+		var oldest *exploreCacheEntry
+
+		for key, entry := range cache.entries {
+			if entry.result != nil && (oldest == nil || entry.created.Before(oldest.created)) {
+				oldestKey, oldest = key, entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+
+		delete(cache.entries, oldestKey)
+	}
+}
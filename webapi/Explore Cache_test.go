@@ -0,0 +1,53 @@
+package webapi
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExploreCache(t *testing.T) {
+	var cache exploreCache
+	var computed int32
+
+	compute := func() *SearchResult {
+		atomic.AddInt32(&computed, 1)
+		time.Sleep(10 * time.Millisecond)
+		return &SearchResult{Status: 1}
+	}
+
+	key := exploreCacheKey{fileType: -1, limit: 100}
+
+	// Concurrent calls share a single computation.
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result := cache.get(key, compute); result == nil || result.Status != 1 {
+				t.Errorf("invalid result")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := atomic.LoadInt32(&computed); count != 1 {
+		t.Fatalf("computed %d times, expected once", count)
+	}
+
+	// An expired snapshot is returned and recomputed in the background.
+	cache.Lock()
+	cache.entries[key].created = time.Now().Add(-exploreCacheTTL)
+	cache.Unlock()
+
+	if cache.get(key, compute) == nil {
+		t.Fatalf("expired snapshot not returned")
+	}
+
+	for start := time.Now(); atomic.LoadInt32(&computed) != 2; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("snapshot not recomputed")
+		}
+	}
+}
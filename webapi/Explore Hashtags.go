@@ -0,0 +1,92 @@
+/*
+File Username:  Explore Hashtags.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+/explore/hashtags       List trending hashtags
+/explore/hashtag        List recent files with a hashtag
+
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core/blockchain"
+)
+
+type apiHashtag struct {
+	Tag       string    `json:"tag"`       // Hashtag in lowercase without the '#'.
+	Score     float64   `json:"score"`     // Trending score. It increases by 1 for each new file (max once per hour per peer) and halves every 12 hours.
+	Files     uint64    `json:"files"`     // Count of files seen with the hashtag.
+	FirstSeen time.Time `json:"firstseen"` // When the hashtag was first seen.
+	LastSeen  time.Time `json:"lastseen"`  // When the hashtag was last seen.
+}
+
+/*
+apiExploreHashtags returns the trending hashtags of files in recently synced blockchains, sorted by score. The limit is optional (default 50).
+
+Request:    GET /explore/hashtags?limit=[max records]
+Result:     200 with JSON array apiHashtag
+*/
+func (api *WebapiInstance) apiExploreHashtags(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	result := []apiHashtag{}
+	for _, stat := range api.Backend.HashtagsTrending(limit) {
+		result = append(result, apiHashtag{Tag: stat.Tag, Score: stat.Score, Files: stat.Files, FirstSeen: stat.FirstSeen, LastSeen: stat.LastSeen})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiExploreHashtag returns the recent files with the hashtag, newest first. The hashtag is case insensitive and the '#' is optional.
+The file type is an optional filter, see apiExplore.
+
+Request:    GET /explore/hashtag?tag=[hashtag]&limit=[max records]&type=[file type]
+Result:     200 with JSON structure SearchResult. Check the field status.
+*/
+func (api *WebapiInstance) apiExploreHashtag(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	fileType, err := strconv.Atoi(r.URL.Query().Get("type"))
+	if err != nil {
+		fileType = -1
+	}
+
+	result := SearchResult{Status: 1, Files: []apiFile{}}
+
+	for _, hashtagFile := range api.Backend.HashtagFiles(r.URL.Query().Get("tag")) {
+		blockDecoded, _, found, _ := api.Backend.ReadBlock(hashtagFile.PublicKey, hashtagFile.BlockchainVersion, hashtagFile.BlockNumber)
+		if !found {
+			continue
+		}
+
+		for _, record := range blockDecoded.RecordsDecoded {
+			if file, ok := record.(blockchain.BlockRecordFile); ok && file.ID == hashtagFile.FileID && isFileTypeMatchBlock(&file, fileType) && !file.IsExpired() && !api.Backend.IsHashDenied(file.Hash) {
+				file.Tags = append(file.Tags, blockchain.TagFromNumber(blockchain.TagSharedByCount, 1))
+				newFile := blockRecordFileToAPI(file, false)
+				api.markPlaceholder(&newFile)
+				api.markFutureDated(&newFile)
+				result.Files = append(result.Files, newFile)
+				break
+			}
+		}
+
+		if len(result.Files) >= limit {
+			break
+		}
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
@@ -13,6 +13,8 @@ import (
 	"strings"
 
 	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/warehouse"
 )
 
 // PathToExtension translates a path to a file extension, if possible. It also returns the second file extension if there is one (relevant for files like "test.tar.gz").
@@ -35,71 +37,10 @@ func PathToExtension(Path string) (extension, extension2 string, valid bool) {
 }
 
 // FileTranslateExtension translates the extension to a File Type and File Format. If invalid, types are 0.
+// It does not consider the file data or custom rules from the config, see Backend.DetectFileType.
 func FileTranslateExtension(extension string) (fileType, fileFormat uint16) {
-	switch extension {
-	case "txt", "log", "ini", "json", "md":
-		return core.TypeText, core.FormatText
-
-	case "csv", "tsv":
-		return core.TypeText, core.FormatCSV
-
-	case "html", "htm":
-		return core.TypeText, core.FormatHTML
-
-	case "doc", "docx", "rtf", "odt":
-		return core.TypeDocument, core.FormatWord
-
-	case "pdf":
-		return core.TypeDocument, core.FormatPDF
-
-	case "xls", "xlsx", "ods":
-		return core.TypeDocument, core.FormatExcel
-
-	case "gif", "jpg", "jpeg", "png", "svg", "bmp", "tif", "tiff", "jfif", "webp":
-		return core.TypePicture, core.FormatPicture
-
-	case "mp4", "flv", "avi", "mov", "mpg", "mpeg", "h264", "3g2", "3gp", "mkv", "wmv", "webm", "ts":
-		return core.TypeVideo, core.FormatVideo
-
-	case "mp3", "ogg", "flac":
-		return core.TypeAudio, core.FormatAudio
-
-	case "zip", "rar", "7z", "tar":
-		return core.TypeContainer, core.FormatContainer
-
-	case "ppt", "pptx", "odp":
-		return core.TypeDocument, core.FormatPowerpoint
-
-	case "epub", "mobi", "prc":
-		return core.TypeEbook, core.FormatEbook
-
-	case "gz", "bz", "bz2", "xz":
-		return core.TypeCompressed, core.FormatCompressed
-
-	case "sql":
-		return core.TypeText, core.FormatDatabase
-
-	case "eml", "mbox":
-		return core.TypeText, core.FormatEmail
-
-	case "exe", "sys", "dll", "cmd", "bat":
-		return core.TypeExecutable, core.FormatExecutable
-
-	case "msi":
-		return core.TypeExecutable, core.FormatInstaller
-
-	case "apk":
-		return core.TypeExecutable, core.FormatAPK
-
-	case "iso":
-		return core.TypeContainer, core.FormatISO
-
-	case "pnsearch":
-		return core.TypeText, core.FormatPeernetSearch
-
-	default:
-		return core.TypeBinary, core.FormatBinary
-	}
+	fileType, fileFormat, _ = core.FileTypeFromExtension(extension)
+	return fileType, fileFormat
 }
 
 // HTTPContentTypeToCore translates the HTTP content type to the File Type and File Format used by the core package.
@@ -175,22 +116,19 @@ func FileDataToHTTPContentType(Path string) (httpContentType string, err error)
 	return httpContentType, nil
 }
 
-// FileDetectType detects the File Type and File Format of a file. It uses the extension if available, otherwise the file data, for detection.
-func FileDetectType(Path string) (fileType, fileFormat uint16, err error) {
-	// If a file extension is available, use that to detect the file type and file format.
-	// Otherwise, use the initial file data for detection.
-	if ext1, _, valid := PathToExtension(Path); valid {
-		fileType, fileFormat = FileTranslateExtension(ext1)
-		return fileType, fileFormat, nil
+// setFileType sets the File Type and File Format of the file based on its data in the warehouse and the filename, instead of trusting the caller.
+// Directory manifests cannot be detected by their data. Their format is kept if the data is a valid manifest.
+func setFileType(backend *core.Backend, file *blockchain.BlockRecordFile, filename string) {
+	if file.Format == core.FormatDirectory {
+		if _, status, _ := backend.UserWarehouse.ReadDirectory(file.Hash); status == warehouse.StatusOK {
+			return
+		}
 	}
 
-	httpContentType, err := FileDataToHTTPContentType(Path)
-	if err != nil {
-		return core.TypeBinary, core.FormatBinary, err
+	if fileType, fileFormat, err := backend.DetectFileTypeWarehouse(file.Hash, filename); err == nil {
+		file.Type = uint8(fileType)
+		file.Format = fileFormat
 	}
-
-	fileType, fileFormat = HTTPContentTypeToCore(httpContentType)
-	return fileType, fileFormat, nil
 }
 
 type apiResponseFileFormat struct {
@@ -201,7 +139,7 @@ type apiResponseFileFormat struct {
 
 /*
 apiFileFormat detects the file type and file format of the specified file.
-It will primarily use the file extension for detection. If unavailable, it uses the first 512 bytes of the file data to detect the type.
+It uses the magic bytes of the file data and the file extension for detection. The extension is only trusted if the data matches the format.
 
 Request:    GET /file/format?path=[file path on disk]
 Result:     200 with JSON structure apiResponseFileFormat
@@ -210,11 +148,11 @@ func (api *WebapiInstance) apiFileFormat(w http.ResponseWriter, r *http.Request)
 	r.ParseForm()
 	filePath := r.Form.Get("path")
 	if filePath == "" {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
-	fileType, fileFormat, err := FileDetectType(filePath)
+	fileType, fileFormat, err := api.Backend.DetectFileTypeFile(filePath)
 	if err != nil {
 		EncodeJSON(api.Backend, w, r, apiResponseFileFormat{Status: 1})
 		return
@@ -0,0 +1,76 @@
+/*
+File Username:  File Fetch.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+)
+
+/*
+apiFileFetch downloads a remote file into the local warehouse. In metadata-only mode (config setting MetadataOnly) files in search and explore
+results are placeholders until fetched explicitly via this function. It blocks until the file is downloaded.
+Instead of providing the node ID, the peer ID is also accepted in the &node= parameter.
+
+Request:    GET /file/fetch?hash=[hash]&node=[node ID]
+
+	Optional: &size=[expected file size]
+	Optional: &timeout=[seconds] for connecting to the peer. Default 10.
+
+Response:   204 if the file is stored in the warehouse
+
+	400 if the parameters are invalid
+	404 if the file was not found or the transfer failed
+	502 if unable to find or connect to the remote peer in time
+*/
+func (api *WebapiInstance) apiFileFetch(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var err error
+
+	fileHash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
+	nodeID, valid2 := DecodeBlake3Hash(r.Form.Get("node"))
+	publicKey, err3 := core.PublicKeyFromPeerID(r.Form.Get("node"))
+	if !valid1 || (!valid2 && err3 != nil) {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	size, _ := strconv.ParseUint(r.Form.Get("size"), 10, 64)
+
+	timeoutSeconds, _ := strconv.Atoi(r.Form.Get("timeout"))
+	if timeoutSeconds == 0 {
+		timeoutSeconds = 10
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+
+	var peer *core.PeerInfo
+
+	if valid2 {
+		peer, err = PeerConnectNode(api.Backend, nodeID, timeout)
+	} else if err3 == nil {
+		peer, err = PeerConnectPublicKey(api.Backend, publicKey, timeout)
+	}
+	if err != nil {
+		EncodeError(w, http.StatusBadGateway, "")
+		return
+	}
+
+	if err := api.Backend.FetchFile(peer, fileHash, size); err != nil {
+		EncodeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// markPlaceholder sets the placeholder flag of a file in search and explore results.
+func (api *WebapiInstance) markPlaceholder(file *apiFile) {
+	file.Placeholder = api.Backend.IsPlaceholder(file.Hash)
+}
@@ -46,7 +46,7 @@ func (api *WebapiInstance) apiFileRead(w http.ResponseWriter, r *http.Request) {
 	nodeID, valid2 := DecodeBlake3Hash(r.Form.Get("node"))
 	publicKey, err3 := core.PublicKeyFromPeerID(r.Form.Get("node"))
 	if !valid1 || (!valid2 && err3 != nil) {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -62,7 +62,7 @@ func (api *WebapiInstance) apiFileRead(w http.ResponseWriter, r *http.Request) {
 	// Range header?
 	var ranges []HTTPRange
 	if ranges, err = ParseRangeHeader(r.Header.Get("Range"), -1, true); err != nil || len(ranges) > 1 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	} else if len(ranges) == 1 {
 		if ranges[0].length != -1 { // if length is not specified, limit remains 0 which is maximum
@@ -85,7 +85,7 @@ func (api *WebapiInstance) apiFileRead(w http.ResponseWriter, r *http.Request) {
 		peer, err = PeerConnectPublicKey(api.Backend, publicKey, timeout)
 	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		EncodeError(w, http.StatusBadGateway, "")
 		return
 	}
 
@@ -95,7 +95,7 @@ func (api *WebapiInstance) apiFileRead(w http.ResponseWriter, r *http.Request) {
 		defer reader.Close()
 	}
 	if err != nil || reader == nil {
-		w.WriteHeader(http.StatusNotFound)
+		EncodeError(w, http.StatusNotFound, "")
 		return
 	}
 
@@ -117,10 +117,10 @@ func serveFileFromWarehouse(backend *core.Backend, w http.ResponseWriter, fileHa
 
 	// validate offset and limit
 	if limit > 0 && offset+limit > fileSize {
-		http.Error(w, "invalid limit", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "invalid limit")
 		return true
 	} else if offset > fileSize {
-		http.Error(w, "invalid offset", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "invalid offset")
 		return true
 	} else if limit == 0 {
 		limit = fileSize - offset
@@ -162,7 +162,7 @@ func (api *WebapiInstance) apiFileView(w http.ResponseWriter, r *http.Request) {
 	nodeID, valid2 := DecodeBlake3Hash(r.Form.Get("node"))
 	publicKey, err3 := core.PublicKeyFromPeerID(r.Form.Get("node"))
 	if !valid1 || (!valid2 && err3 != nil) {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -180,7 +180,7 @@ func (api *WebapiInstance) apiFileView(w http.ResponseWriter, r *http.Request) {
 	// Range header?
 	var ranges []HTTPRange
 	if ranges, err = ParseRangeHeader(r.Header.Get("Range"), -1, true); err != nil || len(ranges) > 1 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	} else if len(ranges) == 1 {
 		if ranges[0].length != -1 { // if length is not specified, limit remains 0 which is maximum
@@ -213,7 +213,7 @@ func (api *WebapiInstance) apiFileView(w http.ResponseWriter, r *http.Request) {
 		peer, err = PeerConnectPublicKey(api.Backend, publicKey, timeout)
 	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		EncodeError(w, http.StatusBadGateway, "")
 		return
 	}
 
@@ -223,7 +223,7 @@ func (api *WebapiInstance) apiFileView(w http.ResponseWriter, r *http.Request) {
 		defer reader.Close()
 	}
 	if err != nil || reader == nil {
-		w.WriteHeader(http.StatusNotFound)
+		EncodeError(w, http.StatusNotFound, "")
 		return
 	}
 
@@ -0,0 +1,50 @@
+/*
+File Username:  File Stats.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"time"
+)
+
+type apiFileStats struct {
+	Hash               []byte    `json:"hash"`               // Hash of the file.
+	FindValueHits      uint64    `json:"findvaluehits"`      // Count of FIND_VALUE requests for the hash.
+	TransferStarts     uint64    `json:"transferstarts"`     // Count of transfers started.
+	TransfersCompleted uint64    `json:"transferscompleted"` // Count of transfers that served the entire requested range.
+	BytesServed        uint64    `json:"bytesserved"`        // Total bytes served via transfers.
+	LastAccess         time.Time `json:"lastaccess"`         // Last time the file was requested. Zero if never.
+}
+
+/*
+apiFileStats returns access statistics of a file stored in the user's Warehouse. Statistics are reset when the process restarts.
+
+Request:    GET /file/stats?hash=[hash]
+Response:   200 with JSON structure apiFileStats
+
+	400 if invalid hash
+*/
+func (api *WebapiInstance) apiFileStats(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hash, valid := DecodeBlake3Hash(r.Form.Get("hash"))
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	result := apiFileStats{Hash: hash}
+
+	if stats, found := api.Backend.FileStats(hash); found {
+		result.FindValueHits = stats.FindValueHits
+		result.TransferStarts = stats.TransferStarts
+		result.TransfersCompleted = stats.TransfersCompleted
+		result.BytesServed = stats.BytesServed
+		result.LastAccess = stats.LastAccess
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
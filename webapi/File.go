@@ -32,24 +32,40 @@ type apiFileMetadata struct {
 
 // apiFile is the metadata of a file published on the blockchain
 type apiFile struct {
-	ID          uuid.UUID         `json:"id"`          // Unique ID.
-	Hash        []byte            `json:"hash"`        // Blake3 hash of the file data
-	Type        uint8             `json:"type"`        // File Type. For example audio or document. See TypeX.
-	Format      uint16            `json:"format"`      // File Format. This is more granular, for example PDF or Word file. See FormatX.
-	Size        uint64            `json:"size"`        // Size of the file
-	Folder      string            `json:"folder"`      // Folder, optional
-	Name        string            `json:"name"`        // Name of the file
-	Description string            `json:"description"` // Description. This is expected to be multiline and contain hashtags!
-	Date        time.Time         `json:"date"`        // Date shared
-	NodeID      []byte            `json:"nodeid"`      // Node ID, owner of the file. Read only.
-	Metadata    []apiFileMetadata `json:"metadata"`    // Additional metadata.
-	Username    string            `json:"username"`    // Username of the user who uploaded the file
+	ID               uuid.UUID         `json:"id"`               // Unique ID.
+	Hash             []byte            `json:"hash"`             // Blake3 hash of the file data
+	Type             uint8             `json:"type"`             // File Type. For example audio or document. See TypeX.
+	Format           uint16            `json:"format"`           // File Format. This is more granular, for example PDF or Word file. See FormatX.
+	Size             uint64            `json:"size"`             // Size of the file
+	Folder           string            `json:"folder"`           // Folder, optional
+	Name             string            `json:"name"`             // Name of the file
+	Description      string            `json:"description"`      // Description. This is expected to be multiline and contain hashtags!
+	Date             time.Time         `json:"date"`             // Date shared
+	NodeID           []byte            `json:"nodeid"`           // Node ID, owner of the file. Read only.
+	Metadata         []apiFileMetadata `json:"metadata"`         // Additional metadata.
+	Username         string            `json:"username"`         // Username of the user who uploaded the file
+	UsernameConflict bool              `json:"usernameconflict"` // Whether other peers claim the same or a lookalike username. The uploader may impersonate another user.
+	Placeholder      bool              `json:"placeholder"`      // Metadata-only mode: The file is not stored locally. Use /file/fetch to download it.
+	FutureDated      bool              `json:"futuredated"`      // The date is later than the network time. The clock of the uploader is wrong or the date is manipulated.
+}
+
+// markFutureDated sets the future-dated flag of a file shared by a remote peer. See core.IsFutureDated.
+func (api *WebapiInstance) markFutureDated(file *apiFile) {
+	file.FutureDated = api.Backend.IsFutureDated(file.Date)
+}
+
+// sortDate returns the date used for sorting. Future-dated files are sorted as the oldest ones, so they cannot stay on top of recent files.
+func (file *apiFile) sortDate() time.Time {
+	if file.FutureDated {
+		return time.Time{}
+	}
+	return file.Date
 }
 
 // --- conversion from core to API data ---
 // Currently in a Hacky way for quick generalised filters
 func blockRecordFileToAPI(input blockchain.BlockRecordFile, localNode bool) (output apiFile) {
-	output = apiFile{ID: input.ID, Hash: input.Hash, NodeID: input.NodeID, Type: input.Type, Format: input.Format, Size: input.Size, Username: input.Username, Metadata: []apiFileMetadata{}}
+	output = apiFile{ID: input.ID, Hash: input.Hash, NodeID: input.NodeID, Type: input.Type, Format: input.Format, Size: input.Size, Username: input.Username, UsernameConflict: input.UsernameConflict, Metadata: []apiFileMetadata{}}
 
 	NumberOfNodesShared := false
 
@@ -71,6 +87,10 @@ func blockRecordFileToAPI(input blockchain.BlockRecordFile, localNode bool) (out
 			date, _ := tag.Date()
 			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Date Created", Date: date})
 
+		case blockchain.TagDateExpires:
+			date, _ := tag.Date()
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Date Expires", Date: date})
+
 		case blockchain.TagSharedByCount:
 			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Shared By Count", Number: tag.Number()})
 			// if a file has 0 peers sharing then do not add it to the list.
@@ -79,6 +99,12 @@ func blockRecordFileToAPI(input blockchain.BlockRecordFile, localNode bool) (out
 		case blockchain.TagSharedByGeoIP:
 			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Shared By GeoIP", Text: tag.Text()})
 
+		case blockchain.TagDirectory:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Directory", Blob: tag.Data})
+
+		case blockchain.TagTextExcerpt:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Text Excerpt", Text: tag.Text()})
+
 		default:
 			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Blob: tag.Data})
 		}
@@ -112,9 +138,12 @@ func blockRecordFileFromAPI(input apiFile) (output blockchain.BlockRecordFile) {
 		switch meta.Type {
 		case blockchain.TagName, blockchain.TagFolder, blockchain.TagDescription: // auto mapped tags
 
-		case blockchain.TagDateCreated:
+		case blockchain.TagDateCreated, blockchain.TagDateExpires:
 			output.Tags = append(output.Tags, blockchain.TagFromDate(meta.Type, meta.Date))
 
+		case blockchain.TagTextExcerpt:
+			output.Tags = append(output.Tags, blockchain.TagFromText(meta.Type, meta.Text))
+
 		default:
 			output.Tags = append(output.Tags, blockchain.BlockRecordFileTag{Type: meta.Type, Data: meta.Blob})
 		}
@@ -137,6 +166,7 @@ Each file must be already stored in the Warehouse (virtual folders are exempt).
 If any file is not stored in the Warehouse, the function aborts with the status code StatusNotInWarehouse.
 If the block record encoding fails for any file, this function aborts with the status code StatusCorruptBlockRecord.
 In case the function aborts, the blockchain remains unchanged.
+The file type and format are detected from the file data and name; the values provided by the caller are ignored.
 
 Request:    POST /blockchain/file/add with JSON structure apiBlockAddFiles
 Response:   200 with JSON structure apiBlockchainBlockStatus
@@ -156,7 +186,7 @@ func (api *WebapiInstance) apiBlockchainFileAdd(w http.ResponseWriter, r *http.R
 		if len(file.Hash) != protocol.HashSize {
 			api.Backend.LogError("blockchain.AddFile", "error: %v", "file length is not the same length as "+
 				"the protocol hash size.")
-			http.Error(w, "", http.StatusBadRequest)
+			EncodeError(w, http.StatusBadRequest, "")
 			return
 		}
 		if file.ID == uuid.Nil { // if the ID is not provided by the caller, set it
@@ -167,7 +197,7 @@ func (api *WebapiInstance) apiBlockchainFileAdd(w http.ResponseWriter, r *http.R
 		if !file.IsVirtualFolder() {
 			if _, err := warehouse.ValidateHash(file.Hash); err != nil {
 				api.Backend.LogError("blockchain.AddFile", "error: %v", err)
-				http.Error(w, "", http.StatusBadRequest)
+				EncodeError(w, http.StatusBadRequest, "")
 				return
 			} else if _, fileSize, status, _ := api.Backend.UserWarehouse.FileExists(file.Hash); status != warehouse.StatusOK {
 				EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
@@ -182,6 +212,12 @@ func (api *WebapiInstance) apiBlockchainFileAdd(w http.ResponseWriter, r *http.R
 
 		blockRecord := blockRecordFileFromAPI(file)
 
+		// The file type and format are detected from the file data. The text excerpt is extracted for full-text search.
+		if !file.IsVirtualFolder() {
+			setFileType(api.Backend, &blockRecord, file.Name)
+			api.Backend.SetFileTextExcerpt(&blockRecord)
+		}
+
 		// Set the merkle tree info as appropriate.
 		if !setFileMerkleInfo(api.Backend, &blockRecord) {
 			EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
@@ -282,17 +318,17 @@ func (api *WebapiInstance) apiBlockchainFileUpdate(w http.ResponseWriter, r *htt
 
 	for _, file := range input.Files {
 		if len(file.Hash) != protocol.HashSize {
-			http.Error(w, "", http.StatusBadRequest)
+			EncodeError(w, http.StatusBadRequest, "")
 			return
 		} else if file.ID == uuid.Nil { // if the ID is not provided by the caller, abort
-			http.Error(w, "", http.StatusBadRequest)
+			EncodeError(w, http.StatusBadRequest, "")
 			return
 		}
 
 		// Verify that the file exists in the warehouse. Folders are exempt from this check as they are only virtual.
 		if !file.IsVirtualFolder() {
 			if _, err := warehouse.ValidateHash(file.Hash); err != nil {
-				http.Error(w, "", http.StatusBadRequest)
+				EncodeError(w, http.StatusBadRequest, "")
 				return
 			} else if _, fileSize, status, _ := api.Backend.UserWarehouse.FileExists(file.Hash); status != warehouse.StatusOK {
 				EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
@@ -307,6 +343,12 @@ func (api *WebapiInstance) apiBlockchainFileUpdate(w http.ResponseWriter, r *htt
 
 		blockRecord := blockRecordFileFromAPI(file)
 
+		// The file type and format are detected from the file data. The text excerpt is extracted for full-text search.
+		if !file.IsVirtualFolder() {
+			setFileType(api.Backend, &blockRecord, file.Name)
+			api.Backend.SetFileTextExcerpt(&blockRecord)
+		}
+
 		// Set the merkle tree info as appropriate.
 		if !setFileMerkleInfo(api.Backend, &blockRecord) {
 			EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
@@ -0,0 +1,178 @@
+/*
+File Username:  Gateway.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+The gateway serves files by hash at /hash/[blake3] so that browsers and legacy applications can consume Peernet content directly.
+Files are served from the local warehouse if available. Otherwise the file is resolved via the DHT, downloaded from a storing peer,
+and streamed to the client. Full downloads are cached in the warehouse at the same time.
+
+The gateway does not use the API key and is intended to listen on localhost only.
+*/
+
+package webapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/protocol"
+	"github.com/PeernetOfficial/core/warehouse"
+	"github.com/gorilla/mux"
+	"lukechampine.com/blake3"
+)
+
+// GatewayLookupTimeout is the timeout for finding storing peers via the DHT.
+const GatewayLookupTimeout = 10 * time.Second
+
+// GatewayConnectTimeout is the timeout for connecting to a storing peer.
+const GatewayConnectTimeout = 10 * time.Second
+
+// GatewayMaxPeers is the maximum count of storing peers tried for a single request.
+const GatewayMaxPeers = 5
+
+var errGatewayHashMismatch = errors.New("data does not match hash")
+
+// StartGateway starts the gateway. ListenAddresses is a list of IP:Ports. The read and write timeout may be 0 for no timeout.
+func StartGateway(Backend *core.Backend, ListenAddresses []string, TimeoutRead, TimeoutWrite time.Duration) (router *mux.Router) {
+	if len(ListenAddresses) == 0 {
+		return nil
+	}
+
+	router = mux.NewRouter()
+	router.HandleFunc("/hash/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		gatewayServeHash(Backend, w, r)
+	}).Methods("GET")
+
+	for _, listen := range ListenAddresses {
+		go startWebAPI(Backend, listen, false, "", "", router, "Gateway", TimeoutRead, TimeoutWrite)
+	}
+
+	return router
+}
+
+/*
+gatewayServeHash serves the file identified by the hash. The Content-Type is detected from the data.
+This endpoint supports the Range, Content-Range and Content-Length headers. Multipart ranges are not supported and result in HTTP 400.
+Range requests are not cached in the warehouse.
+
+Request:    GET /hash/[blake3 hash]
+Response:   200 with the content
+
+	206 with partial content
+	400 if the parameters are invalid
+	404 if no peer storing the file was found or the transfer failed
+	451 if the hash is denied by a subscribed denial list
+*/
+func gatewayServeHash(backend *core.Backend, w http.ResponseWriter, r *http.Request) {
+	fileHash, valid := DecodeBlake3Hash(mux.Vars(r)["hash"])
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	} else if backend.IsHashDenied(fileHash) {
+		EncodeError(w, http.StatusUnavailableForLegalReasons, "")
+		return
+	}
+
+	ranges, err := ParseRangeHeader(r.Header.Get("Range"), -1, true)
+	if err != nil || len(ranges) > 1 {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	var offset, limit int
+	if len(ranges) == 1 {
+		if ranges[0].length != -1 {
+			limit = ranges[0].length
+		}
+		offset = ranges[0].start
+	}
+
+	if serveFileFromWarehouse(backend, w, fileHash, uint64(offset), uint64(limit), ranges) {
+		return
+	}
+
+	peers, data := backend.FindStoringPeers(fileHash, GatewayLookupTimeout)
+
+	// Small files may be returned embedded.
+	if data != nil {
+		backend.UserWarehouse.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+		if !serveFileFromWarehouse(backend, w, fileHash, uint64(offset), uint64(limit), ranges) {
+			EncodeError(w, http.StatusNotFound, "")
+		}
+		return
+	}
+
+	for n, peer := range peers {
+		if n >= GatewayMaxPeers {
+			break
+		}
+
+		// Storing peers returned by remote peers may not be connected yet.
+		if !peer.IsConnectionActive() {
+			if peer, err = PeerConnectNode(backend, peer.NodeID, GatewayConnectTimeout); err != nil {
+				continue
+			}
+		}
+
+		reader, fileSize, transferSize, err := FileStartReader(peer, fileHash, uint64(offset), uint64(limit), r.Context().Done())
+		if err != nil || reader == nil {
+			if reader != nil {
+				reader.Close()
+			}
+			continue
+		}
+
+		setContentLengthRangeHeader(w, uint64(offset), transferSize, fileSize, ranges)
+
+		if len(ranges) == 0 {
+			gatewayStreamCache(backend, w, io.LimitReader(reader, int64(transferSize)), fileHash, fileSize)
+		} else {
+			io.Copy(w, io.LimitReader(reader, int64(transferSize)))
+		}
+
+		reader.Close()
+		return
+	}
+
+	EncodeError(w, http.StatusNotFound, "")
+}
+
+// gatewayStreamCache streams the file to the client and stores it in the warehouse at the same time.
+// The data is verified against the hash before it is committed to the warehouse. Incomplete transfers are not kept.
+// If caching fails, the client still receives the data.
+func gatewayStreamCache(backend *core.Backend, w http.ResponseWriter, reader io.Reader, fileHash []byte, fileSize uint64) {
+	pipeReader, pipeWriter := io.Pipe()
+	hashWriter := blake3.New(protocol.HashSize, nil)
+
+	done := make(chan struct{})
+	go func() {
+		if _, status, err := backend.UserWarehouse.CreateFile(pipeReader, fileSize, nil); status != warehouse.StatusOK && err != io.ErrUnexpectedEOF && err != errGatewayHashMismatch {
+			backend.LogError("gatewayStreamCache", "caching file %s status %d: %v\n", hex.EncodeToString(fileHash), status, err)
+		}
+		io.Copy(io.Discard, pipeReader) // drain in case of error
+		close(done)
+	}()
+
+	written, err := io.Copy(io.MultiWriter(w, hashWriter, pipeWriter), reader)
+	if err == nil && uint64(written) != fileSize {
+		err = io.ErrUnexpectedEOF
+	} else if err == nil && !bytes.Equal(hashWriter.Sum(nil), fileHash) {
+		err = errGatewayHashMismatch
+		backend.LogError("gatewayStreamCache", "downloaded file does not match requested hash %s\n", hex.EncodeToString(fileHash))
+	}
+
+	// Closing with an error before EOF causes the warehouse to discard the temporary file.
+	if err != nil {
+		pipeWriter.CloseWithError(err)
+	} else {
+		pipeWriter.Close()
+	}
+
+	<-done
+}
@@ -0,0 +1,277 @@
+/*
+File Username:  Group.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/btcec"
+	"github.com/google/uuid"
+)
+
+// apiGroup is a group channel.
+type apiGroup struct {
+	ID      uuid.UUID `json:"id"`      // Group ID
+	Owner   string    `json:"owner"`   // Peer ID of the owner, hex encoded. Ignored for create and update.
+	Name    string    `json:"name"`    // Name of the group
+	Members []string  `json:"members"` // Peer IDs of the members, hex encoded. The owner is implicitly a member.
+	IsOwner bool      `json:"isowner"` // Whether this peer owns the group. Ignored for create and update.
+}
+
+// apiGroupList is the list of groups.
+type apiGroupList struct {
+	Groups []apiGroup `json:"groups"`
+}
+
+// apiGroupStatus is the result of creating or updating a group.
+type apiGroupStatus struct {
+	Status  int       `json:"status"`  // Status of the operation, see blockchain.StatusX. blockchain.StatusDataNotFound if updating a group that does not exist.
+	ID      uuid.UUID `json:"id"`      // Group ID
+	Height  uint64    `json:"height"`  // Height of the blockchain (number of blocks).
+	Version uint64    `json:"version"` // Version of the blockchain.
+}
+
+// apiGroupSend is the request to send a message.
+type apiGroupSend struct {
+	ID    uuid.UUID `json:"id"`    // Group ID
+	Owner string    `json:"owner"` // Peer ID of the owner, hex encoded. Empty if this peer is the owner.
+	Text  string    `json:"text"`  // Message text
+}
+
+// apiGroupMessage is a message in a group.
+type apiGroupMessage struct {
+	Index       uint64    `json:"index"`       // Sequential index of the message in the group.
+	ID          uuid.UUID `json:"id"`          // Message ID
+	Sender      string    `json:"sender"`      // Peer ID of the sender, hex encoded.
+	Date        time.Time `json:"date"`        // Date created by the sender.
+	Text        string    `json:"text"`        // Message text
+	FutureDated bool      `json:"futuredated"` // The date is later than the network time. The clock of the sender is wrong or the date is manipulated.
+}
+
+// apiGroupMessages is the result of sending or listing messages.
+type apiGroupMessages struct {
+	Status   int               `json:"status"`   // See GroupX constants.
+	Messages []apiGroupMessage `json:"messages"` // Messages
+}
+
+// Status codes for sending and listing messages
+const (
+	GroupSuccess   = 0 // Success
+	GroupNotFound  = 1 // Group not found. For groups of remote owners, the owner's blockchain might not be cached yet.
+	GroupNotMember = 2 // This peer is not a member of the group.
+	GroupError     = 3 // Other error.
+)
+
+/*
+apiGroupCreate creates a new group owned by this peer. The group is stored on the blockchain.
+
+Request:    POST /group/create with JSON structure apiGroup
+Response:   200 with JSON structure apiGroupStatus
+*/
+func (api *WebapiInstance) apiGroupCreate(w http.ResponseWriter, r *http.Request) {
+	var input apiGroup
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	record, valid := groupFromAPI(input)
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+	record.ID = uuid.New()
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.GroupWrite(record)
+
+	EncodeJSON(api.Backend, w, r, apiGroupStatus{Status: status, ID: record.ID, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiGroupUpdate updates the name and members of a group owned by this peer.
+Removed members can no longer read new messages since all members create a new sender key.
+
+Request:    POST /group/update with JSON structure apiGroup
+Response:   200 with JSON structure apiGroupStatus
+*/
+func (api *WebapiInstance) apiGroupUpdate(w http.ResponseWriter, r *http.Request) {
+	var input apiGroup
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	record, valid := groupFromAPI(input)
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	if _, status := api.Backend.UserBlockchain.GroupRead(record.ID); status != blockchain.StatusOK {
+		EncodeJSON(api.Backend, w, r, apiGroupStatus{Status: status, ID: record.ID})
+		return
+	}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.GroupWrite(record)
+
+	EncodeJSON(api.Backend, w, r, apiGroupStatus{Status: status, ID: record.ID, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiGroupDelete deletes a group. Groups owned by this peer are deleted from the blockchain. Groups of remote owners are only removed from memory.
+The owner is optional and defaults to this peer.
+
+Request:    GET /group/delete?id=[group ID]&owner=[peer ID]
+Response:   200 with JSON structure apiGroupStatus
+*/
+func (api *WebapiInstance) apiGroupDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.URL.Query().Get("id"))
+	owner, valid := api.groupOwner(r.URL.Query().Get("owner"))
+	if err != nil || !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	api.Backend.GroupForget(owner, id)
+
+	result := apiGroupStatus{Status: blockchain.StatusOK, ID: id}
+	if owner.IsEqual(api.Backend.PeerPublicKey) {
+		result.Height, result.Version, result.Status = api.Backend.UserBlockchain.GroupDelete(id)
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiGroupList lists all groups owned by this peer and all groups of remote owners that this peer received messages for.
+
+Request:    GET /group/list
+Response:   200 with JSON structure apiGroupList
+*/
+func (api *WebapiInstance) apiGroupList(w http.ResponseWriter, r *http.Request) {
+	result := apiGroupList{Groups: []apiGroup{}}
+
+	for _, group := range api.Backend.Groups() {
+		result.Groups = append(result.Groups, api.groupToAPI(group))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiGroupSend sends a message to all members of the group. Delivery happens in the background.
+
+Request:    POST /group/send with JSON structure apiGroupSend
+Response:   200 with JSON structure apiGroupMessages containing the sent message
+*/
+func (api *WebapiInstance) apiGroupSend(w http.ResponseWriter, r *http.Request) {
+	var input apiGroupSend
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	owner, valid := api.groupOwner(input.Owner)
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	message, err := api.Backend.GroupSend(owner, input.ID, input.Text)
+	result := apiGroupMessages{Status: groupErrorToStatus(err)}
+	if err == nil {
+		result.Messages = append(result.Messages, api.groupMessageToAPI(message))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiGroupMessages returns the messages of a group. Only the latest messages are kept in memory.
+The owner is optional and defaults to this peer. The offset is optional and is the first message index to return.
+
+Request:    GET /group/messages?id=[group ID]&owner=[peer ID]&offset=[index]
+Response:   200 with JSON structure apiGroupMessages
+*/
+func (api *WebapiInstance) apiGroupMessages(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := uuid.Parse(r.Form.Get("id"))
+	owner, valid := api.groupOwner(r.Form.Get("owner"))
+	if err != nil || !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+	offset, _ := strconv.ParseUint(r.Form.Get("offset"), 10, 64)
+
+	messages, err := api.Backend.GroupMessages(owner, id, offset)
+	result := apiGroupMessages{Status: groupErrorToStatus(err), Messages: []apiGroupMessage{}}
+
+	for _, message := range messages {
+		result.Messages = append(result.Messages, api.groupMessageToAPI(message))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+// groupOwner decodes the peer ID of the owner. If empty, it defaults to this peer.
+func (api *WebapiInstance) groupOwner(peerID string) (owner *btcec.PublicKey, valid bool) {
+	if peerID == "" {
+		return api.Backend.PeerPublicKey, true
+	}
+
+	owner, err := core.PublicKeyFromPeerID(peerID)
+	return owner, err == nil
+}
+
+func groupErrorToStatus(err error) (status int) {
+	switch err {
+	case nil:
+		return GroupSuccess
+	case core.ErrGroupNotFound:
+		return GroupNotFound
+	case core.ErrGroupNotMember:
+		return GroupNotMember
+	default:
+		return GroupError
+	}
+}
+
+// --- conversion from core to API data ---
+
+func (api *WebapiInstance) groupToAPI(group core.GroupInfo) (output apiGroup) {
+	output = apiGroup{ID: group.ID, Owner: hex.EncodeToString(group.Owner.SerializeCompressed()), Name: group.Name, Members: []string{}, IsOwner: group.Owner.IsEqual(api.Backend.PeerPublicKey)}
+
+	for _, member := range group.Members {
+		output.Members = append(output.Members, hex.EncodeToString(member.SerializeCompressed()))
+	}
+
+	return output
+}
+
+func groupFromAPI(input apiGroup) (output blockchain.BlockRecordGroup, valid bool) {
+	if len(input.Members) > blockchain.GroupMaxMembers {
+		return output, false
+	}
+
+	output = blockchain.BlockRecordGroup{ID: input.ID, Name: input.Name}
+
+	for _, peerID := range input.Members {
+		member, err := core.PublicKeyFromPeerID(peerID)
+		if err != nil {
+			return output, false
+		}
+		output.Members = append(output.Members, member)
+	}
+
+	return output, true
+}
+
+func (api *WebapiInstance) groupMessageToAPI(message core.GroupMessage) apiGroupMessage {
+	return apiGroupMessage{Index: message.Index, ID: message.ID, Sender: hex.EncodeToString(message.Sender.SerializeCompressed()), Date: message.Date, Text: message.Text, FutureDated: api.Backend.IsFutureDated(message.Date)}
+}
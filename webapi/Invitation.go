@@ -0,0 +1,77 @@
+/*
+File Username:  Invitation.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// apiInvitation contains an invitation blob and its decoded information.
+type apiInvitation struct {
+	Invitation string    `json:"invitation"` // Invitation blob to pass to the invited node.
+	PeerID     string    `json:"peerid"`     // Peer ID of the inviting peer.
+	Addresses  []string  `json:"addresses"`  // Addresses of the inviting peer.
+	Expires    time.Time `json:"expires"`    // Time the invitation expires.
+}
+
+// apiInvitationAccept is the request to accept an invitation.
+type apiInvitationAccept struct {
+	Invitation string `json:"invitation"` // Invitation blob.
+	Persist    bool   `json:"persist"`    // Whether to add the inviting peer to the seed list.
+}
+
+// apiInvitationAcceptResult is the result of accepting an invitation.
+type apiInvitationAcceptResult struct {
+	PeerID    string   `json:"peerid"`    // Peer ID of the inviting peer.
+	Addresses []string `json:"addresses"` // Addresses of the inviting peer.
+	Connected bool     `json:"connected"` // Whether the inviting peer is connected.
+}
+
+/*
+apiInvitationCreate creates an invitation signed by the peer's private key for bootstrapping a new node into a private swarm.
+The validity is in seconds. If 0 or not provided, it defaults to 24 hours.
+The address parameter is optional and may be repeated. If not provided, the addresses of the current networks are used.
+
+Request:    GET /invitation/create?validity=[seconds]&address=[IP:Port]
+Response:   200 with JSON structure apiInvitation. 400 if an address is invalid or no address is available.
+*/
+func (api *WebapiInstance) apiInvitationCreate(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	validity, _ := strconv.Atoi(r.Form.Get("validity"))
+
+	blob, invitation, err := api.Backend.InvitationCreate(time.Duration(validity)*time.Second, r.Form["address"])
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, apiInvitation{Invitation: blob, PeerID: invitation.PeerID, Addresses: invitation.Addresses, Expires: invitation.Expires})
+}
+
+/*
+apiInvitationAccept verifies an invitation and contacts the inviting peer. It waits up to 10 seconds for the peer to connect.
+If persist is true, the inviting peer is added to the seed list and AutoUpdateSeedList is disabled.
+
+Request:    POST /invitation/accept with JSON structure apiInvitationAccept
+Response:   200 with JSON structure apiInvitationAcceptResult. 400 if the invitation is invalid or expired.
+*/
+func (api *WebapiInstance) apiInvitationAccept(w http.ResponseWriter, r *http.Request) {
+	var input apiInvitationAccept
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	invitation, connected, err := api.Backend.InvitationAccept(input.Invitation, input.Persist)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, apiInvitationAcceptResult{PeerID: invitation.PeerID, Addresses: invitation.Addresses, Connected: connected})
+}
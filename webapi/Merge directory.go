@@ -73,6 +73,8 @@ func (api *WebapiInstance) ExploreFileSharedByNodeThatSharedSimilarFile(fileType
 			if ApiFile.NodeID == nil {
 				continue
 			}
+			api.markPlaceholder(&ApiFile)
+			api.markFutureDated(&ApiFile)
 			result.Files = append(result.Files, ApiFile)
 		}
 
@@ -0,0 +1,52 @@
+/*
+File Username:  Node State.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"io/ioutil"
+	"net/http"
+)
+
+/*
+apiNodeStateExport exports the node state relevant to connectivity (peer list, routing table, ban list, recent contacts) for migrating the node.
+
+Request:    GET /node/export
+Response:   200 with JSON structure core.NodeStateFile as file download
+*/
+func (api *WebapiInstance) apiNodeStateExport(w http.ResponseWriter, r *http.Request) {
+	data, err := api.Backend.NodeStateExport()
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="nodestate.json"`)
+	w.Write(data)
+}
+
+/*
+apiNodeStateImport imports the node state exported by another node. The peers are contacted and added to the peer list when they respond.
+
+Request:    POST /node/import with JSON structure core.NodeStateFile as body
+Response:   200 with JSON structure core.NodeStateImportResult. 400 if the document is invalid.
+*/
+func (api *WebapiInstance) apiNodeStateImport(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	result, err := api.Backend.NodeStateImport(data)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
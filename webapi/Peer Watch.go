@@ -0,0 +1,85 @@
+/*
+File Username:  Peer Watch.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+)
+
+type apiPeerWatch struct {
+	PeerID      []byte    `json:"peerid"`      // Peer ID of the watched peer.
+	Online      bool      `json:"online"`      // Whether the peer is currently online.
+	LastOnline  time.Time `json:"lastonline"`  // Last time the peer came online. Zero if not seen since it is watched.
+	LastOffline time.Time `json:"lastoffline"` // Last time the peer went offline. Zero if not seen since it is watched.
+	Added       time.Time `json:"added"`       // When the peer was added to the watch list.
+}
+
+/*
+apiPeerWatchAdd adds a peer to the watch list. If the peer is offline, it is searched in the DHT.
+When it comes online, the webhook event peer-online is sent.
+
+Request:    GET /status/watch/add?peer=[peer ID]
+Response:   204 Empty. 400 if the peer ID is invalid. 409 if too many peers are watched.
+*/
+func (api *WebapiInstance) apiPeerWatchAdd(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	publicKey, err := core.PublicKeyFromPeerID(r.Form.Get("peer"))
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	if err = api.Backend.WatchPeer(publicKey); err != nil {
+		EncodeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+apiPeerWatchRemove removes a peer from the watch list.
+
+Request:    GET /status/watch/remove?peer=[peer ID]
+Response:   204 Empty. 400 if the peer ID is invalid. 404 if the peer is not watched.
+*/
+func (api *WebapiInstance) apiPeerWatchRemove(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	publicKey, err := core.PublicKeyFromPeerID(r.Form.Get("peer"))
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	if !api.Backend.UnwatchPeer(publicKey) {
+		EncodeError(w, http.StatusNotFound, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+apiPeerWatchList returns the status of all watched peers.
+
+Request:    GET /status/watch
+Response:   200 with JSON array apiPeerWatch
+*/
+func (api *WebapiInstance) apiPeerWatchList(w http.ResponseWriter, r *http.Request) {
+	result := []apiPeerWatch{}
+
+	for _, status := range api.Backend.WatchedPeers() {
+		result = append(result, apiPeerWatch{PeerID: status.PublicKey.SerializeCompressed(), Online: status.Online, LastOnline: status.LastOnline, LastOffline: status.LastOffline, Added: status.Added})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
@@ -8,17 +8,20 @@ package webapi
 
 import (
 	"bytes"
+	"encoding/hex"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/PeernetOfficial/core/blockchain"
+	"github.com/PeernetOfficial/core/protocol"
 )
 
 // apiProfileData contains profile metadata stored on the blockchain. Any data is treated as untrusted and unverified by default.
 type apiProfileData struct {
-	Fields []apiBlockRecordProfile `json:"fields"` // All fields
-	Status int                     `json:"status"` // Status of the operation, only used when this structure is returned from the API. See blockchain.StatusX.
+	Fields       []apiBlockRecordProfile `json:"fields"`       // All fields
+	Status       int                     `json:"status"`       // Status of the operation, only used when this structure is returned from the API. See blockchain.StatusX.
+	NameConflict bool                    `json:"nameconflict"` // Whether other peers claim the same or a lookalike name. Only set by /profile/list.
 }
 
 // apiBlockRecordProfile provides information about the end user. Note that all profile data is arbitrary and shall be considered untrusted and unverified.
@@ -49,26 +52,28 @@ func (api *WebapiInstance) apiProfileList(w http.ResponseWriter, r *http.Request
 		//_, node, _ := api.Backend.FindNode(NodeID, 100)
 
 		_, peers, _ := api.Backend.FindNode(NodeID, time.Second*5)
-		// First iteration of the entire blockchain to search for the profile
-		// image and Username of the user
+		// Merge the profile records of all blocks in order to get the profile image and Username of the user.
+		if peers != nil {
+			profile := make(map[uint16][]byte)
+
+			for blockN := uint64(0); blockN < peers.BlockchainHeight; blockN++ {
+				blockDecoded, _, found, _ := api.Backend.ReadBlock(peers.PublicKey, peers.BlockchainVersion, blockN)
+				if !found {
+					continue
+				}
 
-		for blockN1 := peers.BlockchainHeight; blockN1 > 0; blockN1-- {
-			blockDecoded, _, found, _ := api.Backend.ReadBlock(peers.PublicKey, peers.BlockchainVersion, blockN1)
-			if !found {
-				continue
+				blockchain.ProfileMerge(profile, blockDecoded.Block.RecordsRaw)
 			}
 
-			profile, _ := blockchain.DecodeBlockRecordProfile(blockDecoded.Block.RecordsRaw)
 			// Adding profile image and Username to the output
-			for raw, _ := range profile {
-
-				if profile[raw].Type == blockchain.ProfileName {
-					result.Fields = append(result.Fields, blockRecordProfileToAPI(blockchain.BlockRecordProfile{Type: profile[raw].Type, Data: profile[raw].Data[:]}))
-				}
-				if profile[raw].Type == blockchain.ProfilePicture {
-					result.Fields = append(result.Fields, blockRecordProfileToAPI(blockchain.BlockRecordProfile{Type: profile[raw].Type, Data: profile[raw].Data[:]}))
+			for _, fieldType := range []uint16{blockchain.ProfileName, blockchain.ProfilePicture} {
+				if data, ok := profile[fieldType]; ok {
+					result.Fields = append(result.Fields, blockRecordProfileToAPI(blockchain.BlockRecordProfile{Type: fieldType, Data: data}))
 				}
 			}
+
+			api.Backend.DisplayNameSeen(peers.PublicKey, string(profile[blockchain.ProfileName]))
+			result.NameConflict = api.Backend.DisplayNameConflict(peers.PublicKey, string(profile[blockchain.ProfileName]))
 		}
 
 	} else {
@@ -76,6 +81,10 @@ func (api *WebapiInstance) apiProfileList(w http.ResponseWriter, r *http.Request
 		result.Status = status
 		for n := range fields {
 			result.Fields = append(result.Fields, blockRecordProfileToAPI(fields[n]))
+
+			if fields[n].Type == blockchain.ProfileName {
+				result.NameConflict = api.Backend.DisplayNameConflict(api.Backend.PeerPublicKey, fields[n].Text())
+			}
 		}
 	}
 
@@ -94,7 +103,7 @@ func (api *WebapiInstance) apiProfileRead(w http.ResponseWriter, r *http.Request
 	NodeID, valid := DecodeBlake3Hash(r.URL.Query().Get("node"))
 
 	if err1 != nil || fieldN < 0 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -119,6 +128,7 @@ func (api *WebapiInstance) apiProfileRead(w http.ResponseWriter, r *http.Request
 
 /*
 apiProfileWrite writes profile fields. See core.ProfileX for recognized fields.
+The profile picture is validated and transcoded, see core.ProfilePicturePrepare. Invalid pictures are rejected with 400.
 
 Request:    POST /profile/write with JSON structure apiProfileData
 Response:   200 with JSON structure apiBlockchainBlockStatus
@@ -130,13 +140,30 @@ func (api *WebapiInstance) apiProfileWrite(w http.ResponseWriter, r *http.Reques
 	}
 
 	var fields []blockchain.BlockRecordProfile
+	var pictureUpdates []blockchain.BlockRecordProfileUpdate
 
 	for n := range input.Fields {
-		fields = append(fields, blockRecordProfileFromAPI(input.Fields[n]))
+		field := blockRecordProfileFromAPI(input.Fields[n])
+
+		if field.Type == blockchain.ProfilePicture {
+			updates, err := api.Backend.ProfilePictureUpdates(field.Data)
+			if err != nil {
+				EncodeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			pictureUpdates = append(pictureUpdates, updates...)
+			continue
+		}
+
+		fields = append(fields, field)
 	}
 
 	newHeight, newVersion, status := api.Backend.UserBlockchain.ProfileWrite(fields)
 
+	if status == blockchain.StatusOK && len(pictureUpdates) > 0 {
+		newHeight, newVersion, status = api.Backend.UserBlockchain.ProfileUpdate(pictureUpdates)
+	}
+
 	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
 }
 
@@ -156,6 +183,11 @@ func (api *WebapiInstance) apiProfileDelete(w http.ResponseWriter, r *http.Reque
 
 	for n := range input.Fields {
 		fields = append(fields, input.Fields[n].Type)
+
+		// The reference to the original picture is deleted together with the picture.
+		if input.Fields[n].Type == blockchain.ProfilePicture {
+			fields = append(fields, blockchain.ProfilePictureOriginal)
+		}
 	}
 
 	newHeight, newVersion, status := api.Backend.UserBlockchain.ProfileDelete(fields)
@@ -163,6 +195,101 @@ func (api *WebapiInstance) apiProfileDelete(w http.ResponseWriter, r *http.Reque
 	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
 }
 
+// apiProfileUpdate contains field-level changes to the profile.
+type apiProfileUpdate struct {
+	Set    []apiBlockRecordProfile `json:"set"`    // Fields to add or replace.
+	Delete []uint16                `json:"delete"` // Types of fields to delete.
+}
+
+/*
+apiProfileUpdate appends field-level changes to the profile. Unlike /profile/write and /profile/delete, existing blocks are not rewritten.
+Only the changed fields are published; unchanged fields and blobs are not re-published. Deletions are applied before additions.
+The profile picture is handled the same as in /profile/write.
+
+Request:    POST /profile/update with JSON structure apiProfileUpdate
+Response:   200 with JSON structure apiBlockchainBlockStatus
+*/
+func (api *WebapiInstance) apiProfileUpdate(w http.ResponseWriter, r *http.Request) {
+	var input apiProfileUpdate
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	var updates []blockchain.BlockRecordProfileUpdate
+
+	for _, fieldType := range input.Delete {
+		updates = append(updates, blockchain.BlockRecordProfileUpdate{Action: blockchain.ProfileUpdateDelete, Type: fieldType})
+
+		if fieldType == blockchain.ProfilePicture {
+			updates = append(updates, blockchain.BlockRecordProfileUpdate{Action: blockchain.ProfileUpdateDelete, Type: blockchain.ProfilePictureOriginal})
+		}
+	}
+	for n := range input.Set {
+		field := blockRecordProfileFromAPI(input.Set[n])
+
+		if field.Type == blockchain.ProfilePicture {
+			pictureUpdates, err := api.Backend.ProfilePictureUpdates(field.Data)
+			if err != nil {
+				EncodeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			updates = append(updates, pictureUpdates...)
+			continue
+		}
+
+		updates = append(updates, blockchain.BlockRecordProfileUpdate{Action: blockchain.ProfileUpdateSet, Type: field.Type, Data: field.Data})
+	}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.ProfileUpdate(updates)
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+// apiProfileNameClaims contains the peers claiming a name.
+type apiProfileNameClaims struct {
+	Claims []apiProfileNameClaim `json:"claims"` // Peers claiming the name or a lookalike of it. The current user is included.
+}
+
+// apiProfileNameClaim is a name claimed by a peer.
+type apiProfileNameClaim struct {
+	PeerID   string    `json:"peerid"`   // Peer ID, hex encoded.
+	NodeID   []byte    `json:"nodeid"`   // Node ID
+	Name     string    `json:"name"`     // Name as set in the profile
+	LastSeen time.Time `json:"lastseen"` // When the name was last seen
+	IsSelf   bool      `json:"isself"`   // Whether it is the current user
+}
+
+/*
+apiProfileNameClaims returns all known peers that claim the name or a lookalike of it. Names are compared ignoring case, whitespace, punctuation,
+and common lookalike characters. Clients can use it to hint that a name is already taken, or to warn about possible impersonation.
+Only names of peers whose profiles were seen locally (via the blockchain cache or the profile API) are known.
+
+Request:    GET /profile/name/claims?name=[name]
+Response:   200 with JSON structure apiProfileNameClaims
+*/
+func (api *WebapiInstance) apiProfileNameClaims(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	name := r.Form.Get("name")
+	if name == "" {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	result := apiProfileNameClaims{Claims: []apiProfileNameClaim{}}
+
+	for _, claim := range api.Backend.DisplayNameClaims(name) {
+		result.Claims = append(result.Claims, apiProfileNameClaim{
+			PeerID:   hex.EncodeToString(claim.PublicKey.SerializeCompressed()),
+			NodeID:   protocol.PublicKey2NodeID(claim.PublicKey),
+			Name:     claim.Name,
+			LastSeen: claim.LastSeen,
+			IsSelf:   claim.PublicKey.IsEqual(api.Backend.PeerPublicKey),
+		})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
 // --- conversion from core to API data ---
 
 func blockRecordProfileToAPI(input blockchain.BlockRecordProfile) (output apiBlockRecordProfile) {
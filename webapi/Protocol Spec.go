@@ -0,0 +1,41 @@
+/*
+File Username:  Protocol Spec.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+
+	"github.com/PeernetOfficial/core/protocol"
+)
+
+// apiProtocolSpec is the wire format specification.
+type apiProtocolSpec struct {
+	Version int                   `json:"version"` // Protocol version.
+	Formats []protocol.WireFormat `json:"formats"` // Wire formats of packets, messages, structures, stream protocols, and block records.
+}
+
+/*
+apiProtocolSpec returns the wire format specification (offsets, sizes, semantics) of all packets, messages, structures, stream protocols, and block records.
+It is generated from the layouts in the code. The category parameter is optional and filters the formats: packet, message, structure, stream, record.
+
+Request:    GET /protocol/spec?category=[category]
+Response:   200 with JSON structure apiProtocolSpec
+*/
+func (api *WebapiInstance) apiProtocolSpec(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	category := r.Form.Get("category")
+
+	result := apiProtocolSpec{Version: protocol.ProtocolVersion, Formats: []protocol.WireFormat{}}
+
+	for _, format := range protocol.WireSpec() {
+		if category == "" || format.Category == category {
+			result.Formats = append(result.Formats, format)
+		}
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
@@ -31,21 +31,39 @@ func (api *WebapiInstance) dispatchSearch(input SearchRequest, NodeID []byte) (j
     return job
 }
 
+// localSearch searches the local search index. Files of peers found via the distributed keyword index are added to the local search index
+// while the search is live (see core.KeywordSearchFetch); the local search index is queried again afterwards.
 func (job *SearchJob) localSearch(api *WebapiInstance, term string) {
     if api.Backend.SearchIndex == nil {
         job.Status = SearchStatusNoIndex
         return
     }
 
+    job.mergeLocalResults(api, term)
+
+    if api.Backend.KeywordSearchFetch(term) > 0 {
+        job.mergeLocalResults(api, term)
+    }
+
+    job.ResultSync.Lock()
+    job.Status = SearchStatusTerminated
+    job.ResultSync.Unlock()
+
+    job.Terminate()
+}
+
+// mergeLocalResults adds the results from the local search index to the job. Files already in the results are skipped.
+func (job *SearchJob) mergeLocalResults(api *WebapiInstance, term string) {
     results := api.Backend.SearchIndex.Search(term)
 
     job.ResultSync.Lock()
+    defer job.ResultSync.Unlock()
 
 resultLoop:
     for _, result := range results {
 
         file, _, found, err := api.Backend.ReadFile(result.PublicKey, result.BlockchainVersion, result.BlockNumber, result.FileID)
-        if err != nil || !found {
+        if err != nil || !found || file.IsExpired() || api.Backend.IsHashDenied(file.Hash) {
             continue
         }
 
@@ -73,6 +91,8 @@ resultLoop:
 
         // new result
         newFile := blockRecordFileToAPI(file, false)
+        api.markPlaceholder(&newFile)
+        api.markFutureDated(&newFile)
 
         if newFile.NodeID != nil {
             job.Files = append(job.Files, &newFile)
@@ -81,9 +101,4 @@ resultLoop:
             job.statsAdd(&newFile)
         }
     }
-
-    job.Status = SearchStatusTerminated
-
-    job.ResultSync.Unlock()
-    job.Terminate()
 }
@@ -39,13 +39,11 @@ type SearchJob struct {
 	filtersStart   SearchFilter // Filters when starting the search. They cannot be changed later on. Any incoming file is checked against them, even if there are different runtime filters.
 	filtersRuntime SearchFilter // Runtime Filters. They allow filtering results after they were received.
 
-	// File statistics (filters are ignored) of returned results. Map value is always count of files.
+	// File statistics (filters are ignored) of returned results.
 	stats struct {
-		sync.RWMutex                   // Synced access to maps
-		date         map[time.Time]int // Files per day (rounded down to midnight)
-		fileType     map[uint8]int     // Files per File Type
-		fileFormat   map[uint16]int    // Files per File Format
-		total        int               // Total count of files
+		sync.RWMutex // Synced access to maps
+		searchStatisticCounts
+		subscribers map[*SearchStatisticSubscriber]struct{} // Subscribers to incremental statistics.
 	}
 
 	// -- result data --
@@ -90,9 +88,8 @@ func (api *WebapiInstance) CreateSearchJob(Timeout time.Duration, MaxResults int
 	job.filtersStart = Filter
 	job.filtersRuntime = Filter // initialize the runtime filters as the same
 
-	job.stats.date = make(map[time.Time]int)
-	job.stats.fileType = make(map[uint8]int)
-	job.stats.fileFormat = make(map[uint16]int)
+	job.stats.searchStatisticCounts = newSearchStatisticCounts()
+	job.stats.subscribers = make(map[*SearchStatisticSubscriber]struct{})
 
 	// add to the list of jobs
 	api.allJobsMutex.Lock()
@@ -267,16 +264,16 @@ func (job *SearchJob) isFileFiltered(file *apiFile) bool {
 func SortFiles(files []*apiFile, Sort int) (sorted []*apiFile) {
 	switch Sort {
 	case SortRelevanceAsc:
-		sort.SliceStable(files, func(i, j int) bool { return files[i].Date.Before(files[j].Date) }) // first as date for secondary sorting
+		sort.SliceStable(files, func(i, j int) bool { return files[i].sortDate().Before(files[j].sortDate()) }) // first as date for secondary sorting
 		//sort.SliceStable(files, func(i, j int) bool { return files[i].Score < files[j].Score }) // TODO
 	case SortRelevanceDec:
-		sort.SliceStable(files, func(i, j int) bool { return files[j].Date.Before(files[i].Date) }) // first as date for secondary sorting
+		sort.SliceStable(files, func(i, j int) bool { return files[j].sortDate().Before(files[i].sortDate()) }) // first as date for secondary sorting
 		//sort.SliceStable(files, func(i, j int) bool { return files[i].Score > files[j].Score }) // TODO
 
 	case SortDateAsc:
-		sort.SliceStable(files, func(i, j int) bool { return files[i].Date.Before(files[j].Date) })
+		sort.SliceStable(files, func(i, j int) bool { return files[i].sortDate().Before(files[j].sortDate()) })
 	case SortDateDesc:
-		sort.SliceStable(files, func(i, j int) bool { return files[j].Date.Before(files[i].Date) })
+		sort.SliceStable(files, func(i, j int) bool { return files[j].sortDate().Before(files[i].sortDate()) })
 
 	case SortNameAsc:
 		sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
@@ -405,56 +402,136 @@ type SearchStatisticData struct {
 	Total      int                        `json:"total"`      // Total count of files
 }
 
-// Statistics generates statistics on all results, regardless of runtime filters.
-func (job *SearchJob) Statistics() (result SearchStatisticData) {
-	job.stats.RLock()
-	defer job.stats.RUnlock()
+// searchStatisticCounts counts files per facet. Map value is always count of files.
+type searchStatisticCounts struct {
+	date       map[time.Time]int // Files per day (rounded down to midnight)
+	fileType   map[uint8]int     // Files per File Type
+	fileFormat map[uint16]int    // Files per File Format
+	total      int               // Total count of files
+}
+
+func newSearchStatisticCounts() searchStatisticCounts {
+	return searchStatisticCounts{date: make(map[time.Time]int), fileType: make(map[uint8]int), fileFormat: make(map[uint16]int)}
+}
 
-	result.Total = job.stats.total
+// add counts the file
+func (counts *searchStatisticCounts) add(file *apiFile) {
+	// Use file's Date field if available.
+	if !file.Date.IsZero() {
+		// Files per day
+		counts.date[file.Date.Truncate(24*time.Hour)]++
+	}
+
+	// File Type and Format
+	counts.fileType[file.Type]++
+	counts.fileFormat[file.Format]++
+
+	counts.total++
+}
+
+// data returns the counts as statistics
+func (counts *searchStatisticCounts) data() (result SearchStatisticData) {
+	result.Total = counts.total
 
 	// Files per date. Sort dates to date ASC.
-	for key, value := range job.stats.date {
+	for key, value := range counts.date {
 		result.Date = append(result.Date, SearchStatisticRecordDay{Date: key, Count: value})
 	}
 	sort.SliceStable(result.Date, func(i, j int) bool { return result.Date[i].Date.Before(result.Date[j].Date) })
 
 	// File Type and Format
-	for key, value := range job.stats.fileType {
+	for key, value := range counts.fileType {
 		result.FileType = append(result.FileType, SearchStatisticRecord{Key: int(key), Count: value})
 	}
-	for key, value := range job.stats.fileFormat {
+	for key, value := range counts.fileFormat {
 		result.FileFormat = append(result.FileFormat, SearchStatisticRecord{Key: int(key), Count: value})
 	}
 
 	return
 }
 
-// statsAdd counts the files in the statistics
+// Statistics generates statistics on all results, regardless of runtime filters.
+func (job *SearchJob) Statistics() (result SearchStatisticData) {
+	job.stats.RLock()
+	defer job.stats.RUnlock()
+
+	return job.stats.data()
+}
+
+// statsAdd counts the files in the statistics and in the pending changes of all subscribers.
 func (job *SearchJob) statsAdd(files ...*apiFile) {
 	job.stats.Lock()
 	defer job.stats.Unlock()
 
 	for _, file := range files {
-		// Use file's Date field if available.
-		if !file.Date.IsZero() {
-			// Files per day
-			date := file.Date.Truncate(24 * time.Hour)
-			countDate := job.stats.date[date]
-			countDate++
-			job.stats.date[date] = countDate
+		job.stats.add(file)
+
+		for subscriber := range job.stats.subscribers {
+			subscriber.pending.add(file)
 		}
+	}
 
-		// File Type and Format
-		countType := job.stats.fileType[file.Type]
-		countType++
-		job.stats.fileType[file.Type] = countType
+	for subscriber := range job.stats.subscribers {
+		select {
+		case subscriber.Changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SearchStatisticSubscriber receives incremental statistics of a search job. The counts of new results are accumulated until they are taken via Next.
+type SearchStatisticSubscriber struct {
+	job     *SearchJob
+	pending searchStatisticCounts // New results since the last call of Next.
+	Changed chan struct{}         // Signaled when new results were counted. Changes are coalesced.
+}
+
+// StatisticsSubscribe subscribes to incremental statistics. Initially all existing results are pending. Unsubscribe must be called when done.
+func (job *SearchJob) StatisticsSubscribe() (subscriber *SearchStatisticSubscriber) {
+	job.stats.Lock()
+	defer job.stats.Unlock()
+
+	subscriber = &SearchStatisticSubscriber{job: job, pending: newSearchStatisticCounts(), Changed: make(chan struct{}, 1)}
+
+	for key, value := range job.stats.date {
+		subscriber.pending.date[key] = value
+	}
+	for key, value := range job.stats.fileType {
+		subscriber.pending.fileType[key] = value
+	}
+	for key, value := range job.stats.fileFormat {
+		subscriber.pending.fileFormat[key] = value
+	}
+	subscriber.pending.total = job.stats.total
 
-		countFormat := job.stats.fileFormat[file.Format]
-		countFormat++
-		job.stats.fileFormat[file.Format] = countFormat
+	if subscriber.pending.total > 0 {
+		subscriber.Changed <- struct{}{}
 	}
 
-	job.stats.total += len(files)
+	job.stats.subscribers[subscriber] = struct{}{}
+
+	return subscriber
+}
+
+// Next returns the counts of new results per facet since the last call and the new total count of results.
+func (subscriber *SearchStatisticSubscriber) Next() (added SearchStatisticData, total int) {
+	job := subscriber.job
+	job.stats.Lock()
+	defer job.stats.Unlock()
+
+	added = subscriber.pending.data()
+	subscriber.pending = newSearchStatisticCounts()
+
+	return added, job.stats.total
+}
+
+// Unsubscribe stops the subscription.
+func (subscriber *SearchStatisticSubscriber) Unsubscribe() {
+	job := subscriber.job
+	job.stats.Lock()
+	defer job.stats.Unlock()
+
+	delete(job.stats.subscribers, subscriber)
 }
 
 // ---- actual search & retrieving results ----
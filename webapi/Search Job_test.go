@@ -0,0 +1,39 @@
+package webapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSearchStatisticSubscriber(t *testing.T) {
+	api := &WebapiInstance{allJobs: make(map[uuid.UUID]*SearchJob)}
+	job := api.CreateSearchJob(time.Second, 10, SearchFilter{})
+
+	date := time.Date(2021, 10, 4, 12, 0, 0, 0, time.UTC)
+	job.statsAdd(&apiFile{Type: 1, Format: 2, Date: date})
+
+	subscriber := job.StatisticsSubscribe()
+	defer subscriber.Unsubscribe()
+
+	// The first update contains the existing results.
+	<-subscriber.Changed
+	added, total := subscriber.Next()
+	if added.Total != 1 || total != 1 || len(added.FileType) != 1 || added.FileType[0].Count != 1 {
+		t.Fatalf("initial update: %+v, total %d", added, total)
+	}
+
+	job.statsAdd(&apiFile{Type: 1, Format: 3, Date: date}, &apiFile{Type: 4, Format: 3})
+
+	<-subscriber.Changed
+	added, total = subscriber.Next()
+	if added.Total != 2 || total != 3 || len(added.FileType) != 2 || len(added.FileFormat) != 1 || added.FileFormat[0].Count != 2 || len(added.Date) != 1 {
+		t.Fatalf("incremental update: %+v, total %d", added, total)
+	}
+
+	// No new results since the last update.
+	if added, total = subscriber.Next(); added.Total != 0 || total != 3 {
+		t.Fatalf("empty update: %+v, total %d", added, total)
+	}
+}
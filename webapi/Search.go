@@ -8,6 +8,8 @@ Author:     Peter Kleissner
 /search/terminate       Terminate a search
 /search/result/ws       Websocket to receive results as stream
 /search/statistic       Statistics about the results
+/search/statistic/ws    Websocket to receive incremental statistics as stream
+/search/suggest         Autocompletion of search terms
 
 /explore                List recently shared files
 
@@ -75,6 +77,28 @@ type SearchStatistic struct {
 	IsTerminated bool `json:"terminated"` // Whether the search is terminated, meaning that statistics won't change
 }
 
+// SearchStatisticUpdate is an incremental update of search result statistics
+type SearchStatisticUpdate struct {
+	Added        SearchStatisticData `json:"added"`      // Count of new results per facet since the previous update. Added.Total is the count of new results.
+	Total        int                 `json:"total"`      // Total count of results
+	IsTerminated bool                `json:"terminated"` // Whether the search is terminated. This is the final update.
+}
+
+// SearchSuggestResult contains completions of a search term
+type SearchSuggestResult struct {
+	Suggestions []SearchSuggestion `json:"suggestions"` // List of suggestions, ordered by relevance.
+}
+
+// SearchSuggestion is a single completion of a search term
+type SearchSuggestion struct {
+	Word     string `json:"word"`     // Suggested word (lowercase). This may be an entire file name.
+	Count    int    `json:"count"`    // Count of files in the local search index matching the word.
+	Distance int    `json:"distance"` // Edit distance to the term. 0 for completions starting with the term, otherwise a typo-tolerant match.
+}
+
+// searchStatisticStreamInterval is the min interval between statistic updates sent via websocket. Changes within are coalesced.
+const searchStatisticStreamInterval = 250 * time.Millisecond
+
 const apiDateFormat = "2006-01-02 15:04:05"
 
 /*
@@ -135,7 +159,7 @@ func (api *WebapiInstance) apiSearchResult(w http.ResponseWriter, r *http.Reques
 	r.ParseForm()
 	jobID, err := uuid.Parse(r.Form.Get("id"))
 	if err != nil {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 	limit, err := strconv.Atoi(r.Form.Get("limit"))
@@ -226,7 +250,7 @@ func (api *WebapiInstance) apiSearchResultStream(w http.ResponseWriter, r *http.
 	r.ParseForm()
 	jobID, err := uuid.Parse(r.Form.Get("id"))
 	if err != nil {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 	limit, err := strconv.Atoi(r.Form.Get("limit"))
@@ -313,14 +337,14 @@ func (api *WebapiInstance) apiSearchTerminate(w http.ResponseWriter, r *http.Req
 	r.ParseForm()
 	jobID, err := uuid.Parse(r.Form.Get("id"))
 	if err != nil {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
 	// look up the job
 	job := api.JobLookup(jobID)
 	if job == nil {
-		http.Error(w, "", http.StatusNotFound)
+		EncodeError(w, http.StatusNotFound, "")
 		return
 	}
 
@@ -341,7 +365,7 @@ func (api *WebapiInstance) apiSearchStatistic(w http.ResponseWriter, r *http.Req
 	r.ParseForm()
 	jobID, err := uuid.Parse(r.Form.Get("id"))
 	if err != nil {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -358,8 +382,124 @@ func (api *WebapiInstance) apiSearchStatistic(w http.ResponseWriter, r *http.Req
 }
 
 /*
-apiExplore returns recently shared files in Peernet. Results are returned in real-time. The file type is an optional filter. See TypeX.
+apiSearchStatisticStream provides a websocket to receive incremental search result statistics as stream.
+The first update contains the statistics of all results received so far. Each following update contains the count of new results per facet.
+Updates are only sent if there are new results, at most every 250 ms. The final update has the terminated flag set.
+
+Request:    GET /search/statistic/ws?id=[UUID]
+Result:     If successful, upgrades to a websocket and sends JSON structure SearchStatisticUpdate messages.
+
+	400 Invalid input
+	404 ID not found
+*/
+func (api *WebapiInstance) apiSearchStatisticStream(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	jobID, err := uuid.Parse(r.Form.Get("id"))
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	job := api.JobLookup(jobID)
+	if job == nil {
+		EncodeError(w, http.StatusNotFound, "")
+		return
+	}
+
+	conn, err := WSUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// gorilla will automatically respond with "400 Bad Request", no other response is therefore necessary
+		return
+	}
+
+	defer conn.Close()
+
+	subscriber := job.StatisticsSubscribe()
+	defer subscriber.Unsubscribe()
+
+	// Detect when the client closes the connection. Incoming messages are ignored.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(searchStatisticStreamInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		// The termination status is checked before taking the changes, so no results are missed in the final update.
+		terminated := job.IsTerminated()
+
+		select {
+		case <-subscriber.Changed:
+		default:
+			if !terminated {
+				continue
+			}
+		}
+
+		var update SearchStatisticUpdate
+		update.Added, update.Total = subscriber.Next()
+		update.IsTerminated = terminated
+
+		if err := conn.WriteJSON(update); err != nil || terminated {
+			return
+		}
+	}
+}
+
+/*
+apiSearchSuggest returns completions of the search term from the local search index, ordered by the count of files they match. If no word starts
+with the term, typo-tolerant matches are returned instead. The limit is optional (default 10, max 100).
+
+Request:    GET /search/suggest?term=[term]&limit=[max records]
+Result:     200 with JSON structure SearchSuggestResult
+
+	400 if the term is missing
+	503 if the search index is not available
+*/
+func (api *WebapiInstance) apiSearchSuggest(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	term := r.Form.Get("term")
+	if term == "" {
+		EncodeError(w, http.StatusBadRequest, "missing term")
+		return
+	} else if api.Backend.SearchIndex == nil {
+		EncodeError(w, http.StatusServiceUnavailable, "search index not available")
+		return
+	}
+
+	limit, err := strconv.Atoi(r.Form.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	} else if limit > 100 {
+		limit = 100
+	}
+
+	result := SearchSuggestResult{Suggestions: []SearchSuggestion{}}
+
+	for _, suggestion := range api.Backend.SearchIndex.Suggest(term, limit) {
+		result.Suggestions = append(result.Suggestions, SearchSuggestion{Word: suggestion.Word, Count: suggestion.Count, Distance: suggestion.Distance})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiExplore returns recently shared files in Peernet. The file type is an optional filter. See TypeX.
 Special type -2 = Binary, Compressed, Container, Executable. This special type includes everything except Documents, Video, Audio, Ebooks, Picture, Text.
+Results are served from a snapshot that is recomputed in the background after 10 seconds, see exploreCache.
 
 Request:    GET /explore?limit=[max records]&type=[file type]&offset=[offset]&node=[nodeID]
 Result:     200 with JSON structure SearchResult. Check the field status.
@@ -381,9 +521,9 @@ func (api *WebapiInstance) apiExplore(w http.ResponseWriter, r *http.Request) {
 
 	NodeId, valid := DecodeBlake3Hash(r.URL.Query().Get("node"))
 	if valid {
-		result = api.ExploreHelper(fileType, limit, offset, NodeId, true)
+		result = api.exploreCached(fileType, limit, offset, NodeId, true)
 	} else {
-		result = api.ExploreHelper(fileType, limit, offset, []byte{}, false)
+		result = api.exploreCached(fileType, limit, offset, []byte{}, false)
 	}
 
 	EncodeJSON(api.Backend, w, r, result)
@@ -404,6 +544,8 @@ func (api *WebapiInstance) ExploreHelper(fileType int, limit, offset int, nodeID
 				if ApiFile.NodeID == nil {
 					continue
 				}
+				api.markPlaceholder(&ApiFile)
+				api.markFutureDated(&ApiFile)
 				result.Files = append(result.Files, ApiFile)
 			}
 		} else {
@@ -411,6 +553,8 @@ func (api *WebapiInstance) ExploreHelper(fileType int, limit, offset int, nodeID
 			if ApiFile.NodeID == nil {
 				continue
 			}
+			api.markPlaceholder(&ApiFile)
+			api.markFutureDated(&ApiFile)
 			result.Files = append(result.Files, ApiFile)
 		}
 	}
@@ -43,26 +43,23 @@ func (api *WebapiInstance) queryRecentShared(backend *core.Backend, fileType int
 		}
 
 		var filesFromPeer uint64
-		var Name string
 
-		ProfileNameFound := false
+		// First iteration of the entire blockchain to merge the profile records in order and get the Username of the user
+		profile := make(map[uint16][]byte)
 
-		// First iteration of the entire blockchain to search for the profile
-		// image and Username of the user
-		for blockN1 := peer.BlockchainHeight - 1; blockN1 > 0; blockN1-- {
+		for blockN1 := uint64(0); blockN1 < peer.BlockchainHeight; blockN1++ {
 			blockDecoded, _, found, _ := backend.ReadBlock(peer.PublicKey, peer.BlockchainVersion, blockN1)
 			if !found {
 				continue
 			}
-			// Adding profile image and Username to the output
-			for raw := range blockDecoded.Block.RecordsRaw {
-				if blockDecoded.Block.RecordsRaw[raw].Type == blockchain.ProfileName && !ProfileNameFound {
-					Name = string(blockDecoded.Block.RecordsRaw[raw].Data[:])
-					ProfileNameFound = true
-				}
-			}
+
+			blockchain.ProfileMerge(profile, blockDecoded.Block.RecordsRaw)
 		}
 
+		Name := string(profile[blockchain.ProfileName])
+		backend.DisplayNameSeen(peer.PublicKey, Name)
+		nameConflict := backend.DisplayNameConflict(peer.PublicKey, Name)
+
 		// decode blocks from top down
 	blockLoop:
 		for blockN := peer.BlockchainHeight - 1; blockN > 0; blockN-- {
@@ -73,7 +70,7 @@ func (api *WebapiInstance) queryRecentShared(backend *core.Backend, fileType int
 			}
 
 			for _, record := range blockDecoded.RecordsDecoded {
-				if file, ok := record.(blockchain.BlockRecordFile); ok && isFileTypeMatchBlock(&file, fileType) {
+				if file, ok := record.(blockchain.BlockRecordFile); ok && isFileTypeMatchBlock(&file, fileType) && !file.IsExpired() && !backend.IsHashDenied(file.Hash) {
 					// add the tags 'Shared By Count' and 'Shared By GeoIP'
 					file.Tags = append(file.Tags, blockchain.TagFromNumber(blockchain.TagSharedByCount, 1))
 					if latitude, longitude, valid := api.Peer2GeoIP(peer); valid {
@@ -82,6 +79,7 @@ func (api *WebapiInstance) queryRecentShared(backend *core.Backend, fileType int
 					}
 
 					file.Username = Name
+					file.UsernameConflict = nameConflict
 
 					// found a new file! append.
 					if filesFromPeer < limitPeer {
@@ -11,6 +11,9 @@ import (
     "fmt"
     "net/http"
     "strconv"
+    "time"
+
+    "github.com/PeernetOfficial/core"
 )
 
 func apiTest(w http.ResponseWriter, r *http.Request) {
@@ -25,6 +28,10 @@ type apiResponseStatus struct {
     CountNetwork  int  `json:"countnetwork"`  // Count of total peers in the network.
     // This is usually a higher number than CountPeerList, which just represents the current number of connected peers.
     // The CountNetwork number is going to be queried from root peers which may or may not have a limited view.
+    NATType       int  `json:"nattype"`       // Detected NAT type: 0 = Unknown, 1 = None, 2 = Full cone, 3 = Restricted cone, 4 = Symmetric.
+    Observer      bool `json:"observer"`      // Whether the node runs in observer mode. It never publishes and uses an ephemeral key.
+    ClockOffset   int64 `json:"clockoffset"`  // Estimated offset of the local clock to the network in milliseconds. Positive if the local clock is behind. 0 if unknown.
+    ClockPeers    int   `json:"clockpeers"`   // Count of peers used for the clock offset estimate.
 }
 
 /*
@@ -41,6 +48,13 @@ func (api *WebapiInstance) apiStatus(w http.ResponseWriter, r *http.Request) {
     // Instead, the core should keep a count of "active peers".
     status.IsConnected = status.CountPeerList >= 2
 
+    status.NATType, _, _, _ = api.Backend.NATType()
+    status.Observer = api.Backend.Config.Observer
+
+    clock := api.Backend.NetworkClock()
+    status.ClockOffset = clock.Offset.Milliseconds()
+    status.ClockPeers = clock.Peers
+
     EncodeJSON(api.Backend, w, r, status)
 }
 
@@ -83,6 +97,115 @@ func (api *WebapiInstance) apiAccountDelete(w http.ResponseWriter, r *http.Reque
     w.WriteHeader(http.StatusOK)
 }
 
+type apiRequestMnemonic struct {
+    Mnemonic   string `json:"mnemonic"`   // Recovery phrase (24 words). Not used for export.
+    Passphrase string `json:"passphrase"` // Optional passphrase.
+}
+
+type apiRequestMnemonicConfirm struct {
+    PeerID string `json:"peerid"` // Peer ID returned by the import.
+}
+
+type apiResponseMnemonic struct {
+    Status   int    `json:"status"`   // Status: 0 = Success, 1 = Invalid recovery phrase, 2 = Recovery phrase does not match the current key, 3 = No pending import with the peer ID
+    Mnemonic string `json:"mnemonic"` // Recovery phrase. Only set for export.
+    PeerID   string `json:"peerid"`   // Peer ID derived from the recovery phrase.
+}
+
+/*
+apiAccountMnemonicExport returns the recovery phrase (BIP39 mnemonic) of the current account's private key.
+Request:    POST /account/mnemonic/export with JSON structure apiRequestMnemonic
+Result:     200 with JSON structure apiResponseMnemonic
+*/
+func (api *WebapiInstance) apiAccountMnemonicExport(w http.ResponseWriter, r *http.Request) {
+    var input apiRequestMnemonic
+    if err := DecodeJSON(w, r, &input); err != nil {
+        return
+    }
+
+    mnemonic, err := api.Backend.ExportMnemonic(input.Passphrase)
+    if err != nil {
+        EncodeError(w, http.StatusInternalServerError, "")
+        return
+    }
+
+    _, publicKey := api.Backend.ExportPrivateKey()
+
+    EncodeJSON(api.Backend, w, r, apiResponseMnemonic{Mnemonic: mnemonic, PeerID: hex.EncodeToString(publicKey.SerializeCompressed())})
+}
+
+/*
+apiAccountMnemonicVerify verifies that the recovery phrase and passphrase re-derive the current account's private key.
+Request:    POST /account/mnemonic/verify with JSON structure apiRequestMnemonic
+Result:     200 with JSON structure apiResponseMnemonic
+*/
+func (api *WebapiInstance) apiAccountMnemonicVerify(w http.ResponseWriter, r *http.Request) {
+    var input apiRequestMnemonic
+    if err := DecodeJSON(w, r, &input); err != nil {
+        return
+    }
+
+    _, publicKey, err := core.MnemonicToPrivateKey(input.Mnemonic, input.Passphrase)
+    if err != nil {
+        EncodeJSON(api.Backend, w, r, apiResponseMnemonic{Status: 1})
+        return
+    }
+
+    response := apiResponseMnemonic{PeerID: hex.EncodeToString(publicKey.SerializeCompressed())}
+    if valid, _ := api.Backend.VerifyMnemonic(input.Mnemonic, input.Passphrase); !valid {
+        response.Status = 2
+    }
+
+    EncodeJSON(api.Backend, w, r, response)
+}
+
+/*
+apiAccountMnemonicImport recovers an account from the recovery phrase. The returned peer ID must be shown to the user and confirmed via
+/account/mnemonic/confirm, since a mistyped passphrase results in a different valid key. The new private key is effective after confirmation
+and restart. The previous key and the local blockchain of the current account are then moved into a backup folder.
+Request:    POST /account/mnemonic/import with JSON structure apiRequestMnemonic
+Result:     200 with JSON structure apiResponseMnemonic
+*/
+func (api *WebapiInstance) apiAccountMnemonicImport(w http.ResponseWriter, r *http.Request) {
+    var input apiRequestMnemonic
+    if err := DecodeJSON(w, r, &input); err != nil {
+        return
+    }
+
+    publicKey, err := api.Backend.ImportMnemonic(input.Mnemonic, input.Passphrase)
+    if err != nil {
+        EncodeJSON(api.Backend, w, r, apiResponseMnemonic{Status: 1})
+        return
+    }
+
+    EncodeJSON(api.Backend, w, r, apiResponseMnemonic{PeerID: hex.EncodeToString(publicKey.SerializeCompressed())})
+}
+
+/*
+apiAccountMnemonicConfirm confirms a pending import of a recovery phrase. The peer ID must match the one returned by the import.
+Request:    POST /account/mnemonic/confirm with JSON structure apiRequestMnemonicConfirm
+Result:     200 with JSON structure apiResponseMnemonic
+*/
+func (api *WebapiInstance) apiAccountMnemonicConfirm(w http.ResponseWriter, r *http.Request) {
+    var input apiRequestMnemonicConfirm
+    if err := DecodeJSON(w, r, &input); err != nil {
+        return
+    }
+
+    publicKey, err := core.PublicKeyFromPeerID(input.PeerID)
+    if err != nil {
+        EncodeError(w, http.StatusBadRequest, "")
+        return
+    }
+
+    if err := api.Backend.ConfirmMnemonicImport(publicKey); err != nil {
+        EncodeJSON(api.Backend, w, r, apiResponseMnemonic{Status: 3, PeerID: input.PeerID})
+        return
+    }
+
+    EncodeJSON(api.Backend, w, r, apiResponseMnemonic{PeerID: input.PeerID})
+}
+
 /*
 apiStatusPeers returns the information about peers currently connected.
 The GeoIP information may not alawys be available, for example if the GeoIP file is not available or the mapping from IP address to location is not available.
@@ -105,6 +228,19 @@ func (api *WebapiInstance) apiStatusPeers(w http.ResponseWriter, r *http.Request
             BlockchainVersion: peer.BlockchainVersion,
         }
 
+        stats := peer.PathStats()
+        peerInfo.RTTP50 = stats.RTTP50.Milliseconds()
+        peerInfo.RTTP95 = stats.RTTP95.Milliseconds()
+        peerInfo.Loss = stats.Loss
+
+        sla := peer.AnnouncementSLA()
+        peerInfo.ResponseP50 = sla.P50.Milliseconds()
+        peerInfo.ResponseP95 = sla.P95.Milliseconds()
+        peerInfo.ResponseP99 = sla.P99.Milliseconds()
+        peerInfo.ResponseExpiry = sla.Expiry.Milliseconds()
+        peerInfo.ResponseLate = sla.Late
+        peerInfo.ResponseMissed = sla.Missed
+
         if latitude, longitude, valid := api.Peer2GeoIP(peer); valid {
             peerInfo.GeoIP = fmt.Sprintf("%.4f", latitude) + "," + fmt.Sprintf("%.4f", longitude)
         }
@@ -116,13 +252,22 @@ func (api *WebapiInstance) apiStatusPeers(w http.ResponseWriter, r *http.Request
 }
 
 type apiResponsePeerInfo struct {
-    PeerID            []byte `json:"peerid"`            // Peer ID. This is derived from the public in compressed form.
-    NodeID            []byte `json:"nodeid"`            // Node ID. This is the blake3 hash of the peer ID and used in the DHT.
-    GeoIP             string `json:"geoip"`             // GeoIP location as "Latitude,Longitude" CSV format. Empty if location not available.
-    UserAgent         string `json:"useragent"`         // User Agent.
-    IsRoot            bool   `json:"isroot"`            // If the peer is a root peer.
-    BlockchainHeight  uint64 `json:"blockchainheight"`  // Blockchain height
-    BlockchainVersion uint64 `json:"blockchainversion"` // Blockchain version
+    PeerID            []byte  `json:"peerid"`            // Peer ID. This is derived from the public in compressed form.
+    NodeID            []byte  `json:"nodeid"`            // Node ID. This is the blake3 hash of the peer ID and used in the DHT.
+    GeoIP             string  `json:"geoip"`             // GeoIP location as "Latitude,Longitude" CSV format. Empty if location not available.
+    UserAgent         string  `json:"useragent"`         // User Agent.
+    IsRoot            bool    `json:"isroot"`            // If the peer is a root peer.
+    BlockchainHeight  uint64  `json:"blockchainheight"`  // Blockchain height
+    BlockchainVersion uint64  `json:"blockchainversion"` // Blockchain version
+    RTTP50            int64   `json:"rttp50"`            // Median round-trip time in milliseconds. 0 if not measured yet.
+    RTTP95            int64   `json:"rttp95"`            // 95th percentile round-trip time in milliseconds. 0 if not measured yet.
+    Loss              float64 `json:"loss"`              // Ratio of recent pings that were lost, between 0 and 1.
+    ResponseP50       int64   `json:"responsep50"`       // Median time in milliseconds between sending an Announcement and receiving the Response. 0 if not measured yet.
+    ResponseP95       int64   `json:"responsep95"`       // 95th percentile Announcement response time in milliseconds.
+    ResponseP99       int64   `json:"responsep99"`       // 99th percentile Announcement response time in milliseconds.
+    ResponseExpiry    int64   `json:"responseexpiry"`    // Current expiry window in milliseconds of Announcements sent to the peer.
+    ResponseLate      uint64  `json:"responselate"`      // Count of Announcements answered after the expiry window.
+    ResponseMissed    uint64  `json:"responsemissed"`    // Count of Announcements that were not answered.
 }
 
 /*
@@ -142,3 +287,354 @@ func (api *WebapiInstance) apiStatusConfig(w http.ResponseWriter, r *http.Reques
 type apiResponseConfig struct {
     BlockSize uint64 `json:"blockSize"`
 }
+
+type apiResponseUserAgent struct {
+    UserAgent string `json:"useragent"` // User Agent. Empty if not yet reported by the peer.
+    Count     int    `json:"count"`     // Count of peers in the peer list using the User Agent.
+}
+
+/*
+apiStatusUserAgents returns aggregated statistics of User Agents reported by peers in the peer list, sorted by count descending.
+
+Request:    GET /status/useragents
+Result:     200 with JSON array apiResponseUserAgent
+*/
+func (api *WebapiInstance) apiStatusUserAgents(w http.ResponseWriter, r *http.Request) {
+    result := []apiResponseUserAgent{}
+
+    for _, stat := range api.Backend.UserAgentStatistics() {
+        result = append(result, apiResponseUserAgent{UserAgent: stat.UserAgent, Count: stat.Count})
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+type apiResponseTask struct {
+    Name         string    `json:"name"`         // Name of the task.
+    Interval     float64   `json:"interval"`     // Interval between runs in seconds.
+    Running      bool      `json:"running"`      // Whether the task is currently running.
+    RunCount     uint64    `json:"runcount"`     // Count of completed runs.
+    LastRun      time.Time `json:"lastrun"`      // Start of the last completed run. Zero if not run yet.
+    LastDuration float64   `json:"lastduration"` // Duration of the last completed run in seconds.
+    LastError    string    `json:"lasterror"`    // Error returned by the last run. Empty if successful.
+    NextRun      time.Time `json:"nextrun"`      // Scheduled start of the next run.
+}
+
+/*
+apiStatusTasks returns the status of the scheduled background tasks, sorted by name.
+
+Request:    GET /status/tasks
+Result:     200 with JSON array apiResponseTask
+*/
+func (api *WebapiInstance) apiStatusTasks(w http.ResponseWriter, r *http.Request) {
+    result := []apiResponseTask{}
+
+    for _, task := range api.Backend.Tasks() {
+        result = append(result, apiResponseTask{
+            Name:         task.Name,
+            Interval:     task.Interval.Seconds(),
+            Running:      task.Running,
+            RunCount:     task.RunCount,
+            LastRun:      task.LastRun,
+            LastDuration: task.LastDuration.Seconds(),
+            LastError:    task.LastError,
+            NextRun:      task.NextRun,
+        })
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+type apiResponseCredit struct {
+    PeerID        string    `json:"peerid"`        // Peer ID, hex encoded.
+    Served        uint64    `json:"served"`        // Bytes of file data served to the peer.
+    Consumed      uint64    `json:"consumed"`      // Bytes received from the peer via file transfers.
+    Balance       int64     `json:"balance"`       // Consumed minus served bytes. Negative if the peer downloaded more than it provided.
+    Deprioritized bool      `json:"deprioritized"` // Whether transfer requests from the peer are deprioritized by the credit policy.
+    LastUpdate    time.Time `json:"lastupdate"`    // Last time the credit changed.
+}
+
+/*
+apiStatusCredits returns the byte credit of all peers that file data was exchanged with, sorted by balance (lowest first). Credits are restored from the transfer history at startup.
+
+Request:    GET /status/credits
+Result:     200 with JSON array apiResponseCredit
+*/
+func (api *WebapiInstance) apiStatusCredits(w http.ResponseWriter, r *http.Request) {
+    result := []apiResponseCredit{}
+
+    for _, credit := range api.Backend.PeerCredits() {
+        result = append(result, apiResponseCredit{
+            PeerID:        hex.EncodeToString(credit.PublicKey.SerializeCompressed()),
+            Served:        credit.Served,
+            Consumed:      credit.Consumed,
+            Balance:       credit.Balance(),
+            Deprioritized: api.Backend.CreditDeprioritized(credit.PublicKey),
+            LastUpdate:    credit.LastUpdate,
+        })
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+type apiResponseCongestion struct {
+    Enabled   bool    `json:"enabled"`   // Whether announcement and maintenance traffic is slowed down if congested. Config setting CongestionControl.
+    Samples   uint64  `json:"samples"`   // Count of RTT samples the estimate is based on.
+    Inflation float64 `json:"inflation"` // Smoothed ratio of measured RTT to base RTT across all peers. 1 = no inflation.
+    Loss      float64 `json:"loss"`      // Smoothed ratio of unanswered pings across all peers, between 0 and 1.
+    Factor    float64 `json:"factor"`    // Factor by which announcement and maintenance intervals are stretched. 1 = not congested.
+}
+
+/*
+apiStatusCongestion returns the congestion estimate of the uplink, based on response timeouts and RTT inflation across all peers.
+
+Request:    GET /status/congestion
+Result:     200 with JSON structure apiResponseCongestion
+*/
+func (api *WebapiInstance) apiStatusCongestion(w http.ResponseWriter, r *http.Request) {
+    status := api.Backend.Congestion()
+
+    EncodeJSON(api.Backend, w, r, apiResponseCongestion{Enabled: status.Enabled, Samples: status.Samples, Inflation: status.Inflation, Loss: status.Loss, Factor: status.Factor})
+}
+
+type apiResponseNetworkSize struct {
+    Enabled  bool    `json:"enabled"`  // Whether maintenance intervals are scaled by the network size. Config setting AdaptiveIntervals.
+    Nodes    int     `json:"nodes"`    // Count of nodes in the routing table.
+    Estimate uint64  `json:"estimate"` // Estimated count of peers in the network including self.
+    Factor   float64 `json:"factor"`   // Factor by which maintenance intervals are scaled. 1 = unchanged.
+}
+
+/*
+apiStatusNetworkSize returns the network size estimated from the density of the DHT buckets.
+
+Request:    GET /status/networksize
+Result:     200 with JSON structure apiResponseNetworkSize
+*/
+func (api *WebapiInstance) apiStatusNetworkSize(w http.ResponseWriter, r *http.Request) {
+    status := api.Backend.NetworkSize()
+
+    EncodeJSON(api.Backend, w, r, apiResponseNetworkSize{Enabled: status.Enabled, Nodes: status.Nodes, Estimate: status.Estimate, Factor: status.Factor})
+}
+
+type apiResponseSupernode struct {
+    Enabled          bool `json:"enabled"`          // Whether the supernode role is enabled. Config setting Supernode.
+    Saturated        bool `json:"saturated"`        // Whether the supernode is saturated. If so, it sheds load and does not advertise the feature.
+    Transfers        int  `json:"transfers"`        // Count of active block mirror transfers.
+    MaxTransfers     int  `json:"maxtransfers"`     // Max count of concurrent block mirror transfers. Config setting SupernodeMaxTransfers.
+    InfoStoreRecords int  `json:"infostorerecords"` // Count of INFO_STORE records kept.
+}
+
+/*
+apiStatusSupernode returns the status of the supernode role.
+
+Request:    GET /status/supernode
+Result:     200 with JSON structure apiResponseSupernode
+*/
+func (api *WebapiInstance) apiStatusSupernode(w http.ResponseWriter, r *http.Request) {
+    status := api.Backend.SupernodeStatus()
+    records, _, _ := api.Backend.InfoStoreStats()
+
+    EncodeJSON(api.Backend, w, r, apiResponseSupernode{Enabled: status.Enabled, Saturated: status.Saturated, Transfers: status.Transfers, MaxTransfers: status.MaxTransfers, InfoStoreRecords: records})
+}
+
+type apiResponseInfoStore struct {
+    Records          int    `json:"records"`          // Count of INFO_STORE records kept.
+    Hashes           int    `json:"hashes"`           // Count of distinct hashes.
+    Dropped          uint64 `json:"dropped"`          // Count of records dropped due to limits or checks.
+    ProofMode        int    `json:"proofmode"`        // Config setting InfoStoreProof: 0 = Disabled, 1 = Verify, 2 = Require.
+    ProofPassed      uint64 `json:"proofpassed"`      // Count of passed challenges.
+    ProofFailed      uint64 `json:"prooffailed"`      // Count of failed challenges.
+    ProofUnsupported uint64 `json:"proofunsupported"` // Count of challenges to peers that do not support proofs.
+    ProofUnreachable uint64 `json:"proofunreachable"` // Count of challenges that could not be sent.
+    ProofPending     int    `json:"proofpending"`     // Count of records not verified yet.
+    ProofExcluded    int    `json:"proofexcluded"`    // Count of peers currently excluded for failing challenges.
+}
+
+/*
+apiStatusInfoStore returns the statistics of INFO_STORE records kept for other peers and the results of INFO_STORE proof challenges.
+
+Request:    GET /status/infostore
+Result:     200 with JSON structure apiResponseInfoStore
+*/
+func (api *WebapiInstance) apiStatusInfoStore(w http.ResponseWriter, r *http.Request) {
+    records, hashes, dropped := api.Backend.InfoStoreStats()
+    proof := api.Backend.InfoStoreProofStats()
+
+    EncodeJSON(api.Backend, w, r, apiResponseInfoStore{Records: records, Hashes: hashes, Dropped: dropped, ProofMode: proof.Mode, ProofPassed: proof.Passed, ProofFailed: proof.Failed,
+        ProofUnsupported: proof.Unsupported, ProofUnreachable: proof.Unreachable, ProofPending: proof.Pending, ProofExcluded: proof.Excluded})
+}
+
+type apiResponseTraversal struct {
+    LocalNAT  int    `json:"localnat"`  // Local NAT type at the time of the attempts: 0 = Unknown, 1 = None, 2 = Full cone, 3 = Restricted cone, 4 = Symmetric.
+    RemoteNAT int    `json:"remotenat"` // NAT type of the remote peers as derived from their feature bits. Same values as LocalNAT.
+    Strategy  string `json:"strategy"`  // Strategy: "direct", "traverse" (direct and Traverse message), or "relay" (Traverse message only).
+    Attempts  uint32 `json:"attempts"`  // Count of recent first contacts using the strategy.
+    Successes uint32 `json:"successes"` // Count of recent first contacts that succeeded.
+}
+
+/*
+apiStatusTraversal returns the success rates of the NAT traversal strategies used for first contacts, per combination of local and remote NAT type.
+
+Request:    GET /status/traversal
+Result:     200 with JSON array apiResponseTraversal
+*/
+func (api *WebapiInstance) apiStatusTraversal(w http.ResponseWriter, r *http.Request) {
+    result := []apiResponseTraversal{}
+
+    for _, stat := range api.Backend.NATTelemetry() {
+        result = append(result, apiResponseTraversal{LocalNAT: stat.LocalNAT, RemoteNAT: stat.RemoteNAT, Strategy: stat.Strategy, Attempts: stat.Attempts, Successes: stat.Successes})
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+type apiResponseHolePunch struct {
+    Relay        bool   `json:"relay"`        // Whether the traffic of failed sessions coordinated by this peer is forwarded. Config setting HolePunchRelay.
+    Attempts     uint64 `json:"attempts"`     // Count of sessions as initiator or target.
+    Succeeded    uint64 `json:"succeeded"`    // Count of sessions that established a direct connection.
+    RelayOnly    uint64 `json:"relayonly"`    // Count of sessions that fell back to relay-only mode.
+    Failed       uint64 `json:"failed"`       // Count of sessions that failed, including the ones not introduced by the relay.
+    Coordinated  uint64 `json:"coordinated"`  // Count of sessions coordinated as relay.
+    RelayedBytes uint64 `json:"relayedbytes"` // Count of bytes forwarded as relay in relay-only mode.
+}
+
+/*
+apiStatusHolePunch returns the counters of hole punching sessions between peers behind NATs, as participant and as relay.
+
+Request:    GET /status/holepunch
+Result:     200 with JSON structure apiResponseHolePunch
+*/
+func (api *WebapiInstance) apiStatusHolePunch(w http.ResponseWriter, r *http.Request) {
+    stats := api.Backend.HolePunchStats()
+
+    EncodeJSON(api.Backend, w, r, apiResponseHolePunch{
+        Relay:        api.Backend.Config.HolePunchRelay,
+        Attempts:     stats.Attempts,
+        Succeeded:    stats.Succeeded,
+        RelayOnly:    stats.RelayOnly,
+        Failed:       stats.Failed,
+        Coordinated:  stats.Coordinated,
+        RelayedBytes: stats.RelayedBytes,
+    })
+}
+
+type apiResponseTrafficRelay struct {
+    Enabled   bool                          `json:"enabled"`   // Whether this peer advertises relaying traffic. Config setting TrafficRelay.
+    RateLimit int                           `json:"ratelimit"` // Max KB/s forwarded for all peers combined.
+    RatePeer  int                           `json:"ratepeer"`  // Max KB/s forwarded per peer.
+    Forwarded uint64                        `json:"forwarded"` // Count of bytes forwarded.
+    Dropped   uint64                        `json:"dropped"`   // Count of bytes dropped due to the bandwidth caps.
+    Peers     []apiResponseTrafficRelayPeer `json:"peers"`     // Accounting per peer with recent activity.
+}
+
+type apiResponseTrafficRelayPeer struct {
+    PeerID     string    `json:"peerid"`     // Peer ID of the sender, hex encoded.
+    Forwarded  uint64    `json:"forwarded"`  // Count of bytes forwarded.
+    Dropped    uint64    `json:"dropped"`    // Count of bytes dropped due to the bandwidth caps.
+    LastActive time.Time `json:"lastactive"` // Last activity.
+}
+
+/*
+apiStatusRelay returns the accounting of traffic forwarded as relay for peers that cannot connect directly.
+
+Request:    GET /status/relay
+Result:     200 with JSON structure apiResponseTrafficRelay
+*/
+func (api *WebapiInstance) apiStatusRelay(w http.ResponseWriter, r *http.Request) {
+    stats := api.Backend.TrafficRelayStats()
+
+    result := apiResponseTrafficRelay{Enabled: stats.Enabled, RateLimit: stats.RateLimit, RatePeer: stats.RatePeer, Forwarded: stats.Forwarded, Dropped: stats.Dropped, Peers: []apiResponseTrafficRelayPeer{}}
+    for _, peer := range stats.Peers {
+        result.Peers = append(result.Peers, apiResponseTrafficRelayPeer{PeerID: hex.EncodeToString(peer.PublicKey.SerializeCompressed()), Forwarded: peer.Forwarded, Dropped: peer.Dropped, LastActive: peer.LastActive})
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+type apiResponseRelayAvailable struct {
+    PeerID    string `json:"peerid"`    // Peer ID of the relay, hex encoded.
+    UserAgent string `json:"useragent"` // User Agent.
+    RTT       int64  `json:"rtt"`       // Round-trip time in milliseconds. 0 if not measured yet.
+}
+
+/*
+apiStatusRelayList lists the connected peers that advertise relaying traffic, sorted by round-trip time with the fastest first.
+
+Request:    GET /status/relay/list
+Result:     200 with JSON array of apiResponseRelayAvailable
+*/
+func (api *WebapiInstance) apiStatusRelayList(w http.ResponseWriter, r *http.Request) {
+    result := []apiResponseRelayAvailable{}
+
+    for _, peer := range api.Backend.TrafficRelays() {
+        result = append(result, apiResponseRelayAvailable{PeerID: hex.EncodeToString(peer.PublicKey.SerializeCompressed()), UserAgent: peer.UserAgent, RTT: peer.GetRTT().Milliseconds()})
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+type apiResponseUpdate struct {
+    Enabled        bool        `json:"enabled"`        // Whether an update publisher is configured.
+    CurrentVersion string      `json:"currentversion"` // Current version, taken from the User Agent.
+    Platform       string      `json:"platform"`       // Platform of this node as GOOS/GOARCH.
+    Checked        time.Time   `json:"checked"`        // Time of the last check. Zero if not checked yet.
+    Error          string      `json:"error"`          // Error of the last check or download, if any.
+    Available      bool        `json:"available"`      // Whether a newer release is available.
+    Release        *apiRelease `json:"release"`        // Latest release for this platform. Null if none was found.
+    Downloaded     bool        `json:"downloaded"`     // Whether the release file was downloaded into the warehouse and verified.
+}
+
+type apiRelease struct {
+    Version  string    `json:"version"`  // Version
+    Platform string    `json:"platform"` // Platform as GOOS/GOARCH. Empty for any platform.
+    Hash     []byte    `json:"hash"`     // Blake3 hash of the release file
+    Size     uint64    `json:"size"`     // Size of the release file
+    Date     time.Time `json:"date"`     // Date of the release
+    Notes    string    `json:"notes"`    // Release notes
+}
+
+/*
+apiStatusUpdate returns the status of the software update channel. If check is 1, the releases of the publisher are checked first.
+
+Request:    GET /status/update?check=[0 or 1]
+Result:     200 with JSON structure apiResponseUpdate
+*/
+func (api *WebapiInstance) apiStatusUpdate(w http.ResponseWriter, r *http.Request) {
+    r.ParseForm()
+
+    status := api.Backend.UpdateStatus()
+    if r.Form.Get("check") == "1" && status.Enabled {
+        status, _ = api.Backend.UpdateCheck()
+    }
+
+    EncodeJSON(api.Backend, w, r, updateStatusToAPI(status, nil))
+}
+
+/*
+apiStatusUpdateDownload downloads the release file of the latest release found by the last check from the publisher into the warehouse.
+The file is verified against the hash of the signed release manifest. If path is set, the file is also written to the target file.
+
+Request:    GET /status/update/download?path=[target file<optional>]
+Result:     200 with JSON structure apiResponseUpdate
+*/
+func (api *WebapiInstance) apiStatusUpdateDownload(w http.ResponseWriter, r *http.Request) {
+    r.ParseForm()
+
+    status, err := api.Backend.UpdateDownload(r.Form.Get("path"))
+
+    EncodeJSON(api.Backend, w, r, updateStatusToAPI(status, err))
+}
+
+func updateStatusToAPI(status core.UpdateStatus, err error) (result apiResponseUpdate) {
+    result = apiResponseUpdate{Enabled: status.Enabled, CurrentVersion: status.CurrentVersion, Platform: status.Platform, Checked: status.Checked, Error: status.Error, Available: status.Available, Downloaded: status.Downloaded}
+
+    if err != nil {
+        result.Error = err.Error()
+    }
+
+    if release := status.Release; release != nil {
+        result.Release = &apiRelease{Version: release.Version, Platform: release.Platform, Hash: release.Hash, Size: release.Size, Date: release.Date, Notes: release.Notes}
+    }
+
+    return result
+}
@@ -0,0 +1,101 @@
+/*
+File Username:  Storage Agreement.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+)
+
+// apiStorageProofStats contains the challenge results of a storage provider.
+type apiStorageProofStats struct {
+	PeerID        string    `json:"peerid"`        // Peer ID, hex encoded.
+	Passed        uint64    `json:"passed"`        // Count of passed challenges.
+	Failed        uint64    `json:"failed"`        // Count of failed challenges.
+	Unreachable   uint64    `json:"unreachable"`   // Count of challenges that could not be sent.
+	Reliability   float64   `json:"reliability"`   // Ratio of passed challenges to all answered challenges, between 0 and 1.
+	LastChallenge time.Time `json:"lastchallenge"` // Time of the last challenge.
+	LastResult    int       `json:"lastresult"`    // Result of the last challenge: 0 = Passed, 1 = Failed, 2 = Unreachable.
+}
+
+// apiStorageProofResults is the list of challenge results.
+type apiStorageProofResults struct {
+	Peers []apiStorageProofStats `json:"peers"`
+}
+
+// apiStorageChallengeResult is the result of a challenge.
+type apiStorageChallengeResult struct {
+	Result int    `json:"result"` // Result: 0 = Passed, 1 = Failed, 2 = Unreachable, 3 = File not in local warehouse.
+	Error  string `json:"error"`  // Error message, if any.
+}
+
+/*
+apiStorageResults returns the results of proof-of-storage challenges sent to storage providers.
+
+Request:    GET /storage/results
+Response:   200 with JSON structure apiStorageProofResults
+*/
+func (api *WebapiInstance) apiStorageResults(w http.ResponseWriter, r *http.Request) {
+	result := apiStorageProofResults{Peers: []apiStorageProofStats{}}
+
+	for _, stats := range api.Backend.StorageProofResults() {
+		result.Peers = append(result.Peers, apiStorageProofStats{PeerID: stats.PeerID, Passed: stats.Passed, Failed: stats.Failed, Unreachable: stats.Unreachable, Reliability: stats.Reliability(), LastChallenge: stats.LastChallenge, LastResult: stats.LastResult})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiStorageChallenge challenges the remote peer to prove that it stores the file. The file must be in the local warehouse to verify the proof.
+The node can be a node ID or peer ID. The optional timeout in seconds to connect to the peer defaults to 10.
+
+Request:    GET /storage/challenge?node=[node ID]&hash=[file hash]&timeout=[seconds]
+Response:   200 with JSON structure apiStorageChallengeResult
+*/
+func (api *WebapiInstance) apiStorageChallenge(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
+	nodeID, valid2 := DecodeBlake3Hash(r.Form.Get("node"))
+	publicKey, err3 := core.PublicKeyFromPeerID(r.Form.Get("node"))
+	if !valid1 || (!valid2 && err3 != nil) {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	timeoutSeconds, _ := strconv.Atoi(r.Form.Get("timeout"))
+	if timeoutSeconds == 0 {
+		timeoutSeconds = 10
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+
+	var peer *core.PeerInfo
+	var err error
+
+	if valid2 {
+		peer, err = PeerConnectNode(api.Backend, nodeID, timeout)
+	} else {
+		peer, err = PeerConnectPublicKey(api.Backend, publicKey, timeout)
+	}
+	if err != nil {
+		EncodeJSON(api.Backend, w, r, apiStorageChallengeResult{Result: core.StorageChallengeUnreachable, Error: err.Error()})
+		return
+	}
+
+	output := apiStorageChallengeResult{}
+	output.Result, err = api.Backend.StorageChallenge(peer, hash)
+	if err == core.ErrStorageFileNotLocal {
+		output.Result = 3
+	}
+	if err != nil {
+		output.Error = err.Error()
+	}
+
+	EncodeJSON(api.Backend, w, r, output)
+}
@@ -0,0 +1,122 @@
+/*
+File Username:  Transfer History.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+)
+
+type apiTransferRecord struct {
+	Hash      []byte    `json:"hash"`      // File hash.
+	PeerID    string    `json:"peerid"`    // Peer ID of the remote peer, hex encoded.
+	Direction int       `json:"direction"` // 0 = Download, 1 = Upload.
+	Result    int       `json:"result"`    // 0 = Complete, 1 = Partial, 2 = Not available at the peer, 3 = Failed.
+	Bytes     uint64    `json:"bytes"`     // Bytes of file data transferred.
+	FileSize  uint64    `json:"filesize"`  // File size. 0 if unknown.
+	Offset    uint64    `json:"offset"`    // Offset of the requested range.
+	Limit     uint64    `json:"limit"`     // Limit of the requested range. 0 = until the end of the file.
+	Duration  float64   `json:"duration"`  // Duration of the transfer in seconds.
+	Ended     time.Time `json:"ended"`     // When the transfer ended.
+}
+
+type apiTransferHistory struct {
+	Records  []apiTransferRecord `json:"records"`  // Records, newest first.
+	Total    int                 `json:"total"`    // Total count of records matching the filters, regardless of offset and limit.
+	BytesIn  uint64              `json:"bytesin"`  // Total bytes downloaded in all matching records.
+	BytesOut uint64              `json:"bytesout"` // Total bytes uploaded in all matching records.
+}
+
+/*
+apiTransferHistory returns the history of file transfers, newest first. All filters are optional. The default limit is 100.
+
+Request:    GET /transfer/history?hash=[file hash]&peer=[peer ID]&direction=[0|1]&result=[result]&from=[date]&to=[date]&offset=[offset]&limit=[max records]
+Result:     200 with JSON structure apiTransferHistory
+
+	400 on invalid input
+	503 if the transfer history is disabled
+*/
+func (api *WebapiInstance) apiTransferHistory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if api.Backend.DataLayout.TransferHistory == "" {
+		EncodeError(w, http.StatusServiceUnavailable, "transfer history disabled")
+		return
+	}
+
+	filter := core.TransferHistoryFilter{Direction: -1, Result: -1, Limit: 100}
+	var valid bool
+	var err error
+
+	if text := r.Form.Get("hash"); text != "" {
+		if filter.Hash, valid = DecodeBlake3Hash(text); !valid {
+			EncodeError(w, http.StatusBadRequest, "invalid hash")
+			return
+		}
+	}
+	if text := r.Form.Get("peer"); text != "" {
+		if filter.PublicKey, err = core.PublicKeyFromPeerID(text); err != nil {
+			EncodeError(w, http.StatusBadRequest, "invalid peer ID")
+			return
+		}
+	}
+	if text := r.Form.Get("direction"); text != "" {
+		if filter.Direction, err = strconv.Atoi(text); err != nil || filter.Direction != core.DirectionIn && filter.Direction != core.DirectionOut {
+			EncodeError(w, http.StatusBadRequest, "invalid direction")
+			return
+		}
+	}
+	if text := r.Form.Get("result"); text != "" {
+		if filter.Result, err = strconv.Atoi(text); err != nil || filter.Result < 0 {
+			EncodeError(w, http.StatusBadRequest, "invalid result")
+			return
+		}
+	}
+	if text := r.Form.Get("from"); text != "" {
+		if filter.From, err = time.Parse(apiDateFormat, text); err != nil {
+			EncodeError(w, http.StatusBadRequest, "invalid date from")
+			return
+		}
+	}
+	if text := r.Form.Get("to"); text != "" {
+		if filter.To, err = time.Parse(apiDateFormat, text); err != nil {
+			EncodeError(w, http.StatusBadRequest, "invalid date to")
+			return
+		}
+	}
+	if offset, err := strconv.Atoi(r.Form.Get("offset")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+	if limit, err := strconv.Atoi(r.Form.Get("limit")); err == nil && limit > 0 {
+		filter.Limit = limit
+	}
+
+	records, summary := api.Backend.TransferHistory(filter)
+
+	result := apiTransferHistory{Records: []apiTransferRecord{}, Total: summary.Count, BytesIn: summary.BytesIn, BytesOut: summary.BytesOut}
+
+	for _, record := range records {
+		result.Records = append(result.Records, apiTransferRecord{
+			Hash:      record.Hash,
+			PeerID:    hex.EncodeToString(record.PublicKey.SerializeCompressed()),
+			Direction: record.Direction,
+			Result:    record.Result,
+			Bytes:     record.Bytes,
+			FileSize:  record.FileSize,
+			Offset:    record.Offset,
+			Limit:     record.Limit,
+			Duration:  record.Duration.Seconds(),
+			Ended:     record.Ended,
+		})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
@@ -61,12 +61,22 @@ func (uploadStatus *UploadStatus) Write(p []byte) (n int, err error) {
 	return
 }
 
+// setProgress updates the progress with the total count of bytes written so far.
+func (uploadStatus *UploadStatus) setProgress(written uint64) {
+	uploadStatus.Lock()
+	uploadStatus.Progress.UploadedSize = written
+	if uploadStatus.Progress.TotalSize > 0 {
+		uploadStatus.Progress.Percentage = math.Round(float64(written)/float64(uploadStatus.Progress.TotalSize)*100*100) / 100
+	}
+	uploadStatus.Unlock()
+}
+
 // Get information about upload file status
 func (api *WebapiInstance) apiUploadInfo(w http.ResponseWriter, r *http.Request) {
 	ID := r.URL.Query().Get("id")
 	if ID == "" {
 		api.Backend.LogError("upload.UploadInformation", "error: %v", "ID parameter not passed")
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -82,5 +92,8 @@ func (api *WebapiInstance) apiUploadInfo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	info.RLock()
+	defer info.RUnlock()
+
 	EncodeJSON(api.Backend, w, r, info)
 }
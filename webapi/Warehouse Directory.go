@@ -0,0 +1,154 @@
+/*
+File Username:  Warehouse Directory.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PeernetOfficial/core"
+)
+
+// WarehouseDirectoryResult is the response to creating or reading a directory manifest
+type WarehouseDirectoryResult struct {
+	Status  int                 `json:"status"`  // See warehouse.StatusX.
+	Hash    []byte              `json:"hash"`    // Hash of the directory manifest.
+	Size    uint64              `json:"size"`    // Total size of all files in the tree.
+	Entries []apiDirectoryEntry `json:"entries"` // Entries of the directory. Only set when reading.
+}
+
+// apiDirectoryEntry is an entry in a directory manifest.
+type apiDirectoryEntry struct {
+	Name        string `json:"name"`        // Name of the file or sub directory.
+	Hash        []byte `json:"hash"`        // Hash of the file, or of the manifest of the sub directory.
+	Size        uint64 `json:"size"`        // Size of the file, or total size of all files in the sub directory.
+	IsDirectory bool   `json:"isdirectory"` // Whether the entry is a sub directory.
+}
+
+/*
+apiWarehouseCreateDirectory stores all files of a local directory and its sub directories in the warehouse, including the directory manifests.
+The returned hash addresses the entire tree. It can be shared as file with the format FormatDirectory.
+The same warning as for /warehouse/create/path applies.
+
+Request:    GET /warehouse/create/directory?path=[directory on disk]
+Response:   200 with JSON structure WarehouseDirectoryResult
+*/
+func (api *WebapiInstance) apiWarehouseCreateDirectory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	path := r.Form.Get("path")
+	if path == "" {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	hash, size, status, err := api.Backend.UserWarehouse.CreateDirectoryFromPath(path)
+
+	if err != nil {
+		api.Backend.LogError("warehouse.CreateDirectoryFromPath", "status %d error: %v", status, err)
+	}
+
+	EncodeJSON(api.Backend, w, r, WarehouseDirectoryResult{Status: status, Hash: hash, Size: size, Entries: []apiDirectoryEntry{}})
+}
+
+/*
+apiWarehouseDirectory returns the entries of a directory manifest stored in the warehouse.
+
+Request:    GET /warehouse/directory?hash=[hash]
+Response:   200 with JSON structure WarehouseDirectoryResult
+*/
+func (api *WebapiInstance) apiWarehouseDirectory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hash, valid := DecodeBlake3Hash(r.Form.Get("hash"))
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	entries, status, _ := api.Backend.UserWarehouse.ReadDirectory(hash)
+
+	result := WarehouseDirectoryResult{Status: status, Hash: hash, Entries: []apiDirectoryEntry{}}
+	for _, entry := range entries {
+		result.Entries = append(result.Entries, apiDirectoryEntry{Name: entry.Name, Hash: entry.Hash, Size: entry.Size, IsDirectory: entry.IsDirectory})
+		result.Size += entry.Size
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiWarehouseReadDirectory writes the directory tree from the warehouse to the target directory. It fails with StatusErrorTargetExists if the target already exists.
+All files of the tree must be in the warehouse. The target directory only appears once the entire tree is written.
+
+Request:    GET /warehouse/read/directory?hash=[hash]&path=[target directory on disk]
+Response:   200 with JSON structure WarehouseResult
+*/
+func (api *WebapiInstance) apiWarehouseReadDirectory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hash, valid := DecodeBlake3Hash(r.Form.Get("hash"))
+	target := r.Form.Get("path")
+	if !valid || target == "" {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	status, err := api.Backend.UserWarehouse.ReadDirectoryToDisk(hash, target)
+
+	if err != nil {
+		api.Backend.LogError("warehouse.ReadDirectoryToDisk", "status %d error: %v", status, err)
+	}
+
+	EncodeJSON(api.Backend, w, r, WarehouseResult{Status: status, Hash: hash})
+}
+
+/*
+apiDownloadDirectory downloads a directory tree from a remote peer and writes it to the target directory. It fails with StatusErrorTargetExists if the target already exists.
+All files are downloaded into the warehouse and verified by their hash before the target directory is created. Files already in the warehouse are not downloaded again.
+The request returns once the download finished. The node can be a node ID or peer ID. The optional timeout in seconds to connect to the peer defaults to 10.
+Status 400 is returned if the parameters are invalid and 502 if unable to find or connect to the remote peer in time.
+
+Request:    GET /download/directory?hash=[hash]&node=[node ID]&path=[target directory on disk]&timeout=[seconds]
+Response:   200 with JSON structure WarehouseResult
+*/
+func (api *WebapiInstance) apiDownloadDirectory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
+	nodeID, valid2 := DecodeBlake3Hash(r.Form.Get("node"))
+	publicKey, err3 := core.PublicKeyFromPeerID(r.Form.Get("node"))
+	target := r.Form.Get("path")
+	if !valid1 || (!valid2 && err3 != nil) || target == "" {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	timeoutSeconds, _ := strconv.Atoi(r.Form.Get("timeout"))
+	if timeoutSeconds == 0 {
+		timeoutSeconds = 10
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+
+	var peer *core.PeerInfo
+	var err error
+
+	if valid2 {
+		peer, err = PeerConnectNode(api.Backend, nodeID, timeout)
+	} else {
+		peer, err = PeerConnectPublicKey(api.Backend, publicKey, timeout)
+	}
+	if err != nil {
+		EncodeError(w, http.StatusBadGateway, "")
+		return
+	}
+
+	status, err := api.Backend.DirectoryDownload(peer, hash, target)
+
+	if err != nil {
+		api.Backend.LogError("download.Directory", "status %d error: %v", status, err)
+	}
+
+	EncodeJSON(api.Backend, w, r, WarehouseResult{Status: status, Hash: hash})
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/PeernetOfficial/core/warehouse"
 )
@@ -50,19 +51,15 @@ func (api *WebapiInstance) ApiWarehouseCreateFile(w http.ResponseWriter, r *http
 
 		info := api.uploadLookup(IDUUID)
 		if info == nil {
-			var newInfo UploadStatus
-			newInfo.ID = IDUUID
-			newInfo.Progress.TotalSize = uint64(handler.Size)
-			api.Backend.LogError("warehouse.CreateFile", "%v", newInfo)
-			api.uploadAdd(&newInfo)
-			hash, status, err = api.Backend.UserWarehouse.CreateFile(file, uint64(handler.Size), &newInfo)
-		} else {
-			info.Progress.TotalSize = uint64(handler.Size)
-			api.Backend.LogError("warehouse.CreateFile", "%v", info)
-			hash, status, err = api.Backend.UserWarehouse.CreateFile(file, uint64(handler.Size), info)
+			info = &UploadStatus{ID: IDUUID}
+			api.uploadAdd(info)
 		}
 
-		api.Backend.LogError("warehouse.CreateFile", "outside Create file: %v", info)
+		info.Lock()
+		info.Progress.TotalSize = uint64(handler.Size)
+		info.Unlock()
+
+		hash, status, err = api.Backend.UserWarehouse.CreateFileStream(file, uint64(handler.Size), info.setProgress)
 
 	} else {
 		// File := r.
@@ -93,7 +90,7 @@ func (api *WebapiInstance) apiWarehouseCreateFilePath(w http.ResponseWriter, r *
 	r.ParseForm()
 	filePath := r.Form.Get("path")
 	if filePath == "" {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -122,7 +119,7 @@ func (api *WebapiInstance) apiWarehouseReadFile(w http.ResponseWriter, r *http.R
 	r.ParseForm()
 	hash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
 	if !valid1 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -133,10 +130,10 @@ func (api *WebapiInstance) apiWarehouseReadFile(w http.ResponseWriter, r *http.R
 
 	switch status {
 	case warehouse.StatusFileNotFound:
-		w.WriteHeader(http.StatusNotFound)
+		EncodeError(w, http.StatusNotFound, "")
 		return
 	case warehouse.StatusInvalidHash, warehouse.StatusErrorOpenFile, warehouse.StatusErrorSeekFile:
-		w.WriteHeader(http.StatusInternalServerError)
+		EncodeError(w, http.StatusInternalServerError, "")
 		return
 		// Cannot catch warehouse.StatusErrorReadFile since data may have been already returned.
 		// In the future a special header indicating the expected file length could be sent (would require a callback in ReadFile), although the caller should already know the file size based on metadata.
@@ -157,7 +154,7 @@ func (api *WebapiInstance) apiWarehouseDeleteFile(w http.ResponseWriter, r *http
 	r.ParseForm()
 	hash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
 	if !valid1 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 
@@ -170,6 +167,163 @@ func (api *WebapiInstance) apiWarehouseDeleteFile(w http.ResponseWriter, r *http
 	EncodeJSON(api.Backend, w, r, WarehouseResult{Status: status, Hash: hash})
 }
 
+// WarehouseGCResult is the response to the warehouse garbage collection
+type WarehouseGCResult struct {
+	Status int      `json:"status"` // See warehouse.StatusX.
+	Files  [][]byte `json:"files"`  // Hashes of deleted files, or files to be deleted in dry run mode.
+	Size   uint64   `json:"size"`   // Total size of the files.
+}
+
+/*
+apiWarehouseGC deletes all files in the warehouse that are not referenced by the user's blockchain. This includes files cached by the gateway.
+In dry run mode the files are only listed.
+
+Request:    GET /warehouse/gc?dryrun=[0 or 1]
+Response:   200 with JSON structure WarehouseGCResult
+
+	500 if the blockchain cannot be read
+*/
+func (api *WebapiInstance) apiWarehouseGC(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	dryRun, _ := strconv.ParseBool(r.Form.Get("dryrun"))
+
+	files, size, status, err := api.Backend.WarehouseGC(dryRun)
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	result := WarehouseGCResult{Status: status, Files: files, Size: size}
+	if result.Files == nil {
+		result.Files = [][]byte{}
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+// WarehouseStatsResult is the response to the warehouse statistics
+type WarehouseStatsResult struct {
+	Files        uint64    `json:"files"`        // Count of files.
+	Size         uint64    `json:"size"`         // Total size of all files in bytes.
+	MaxSize      uint64    `json:"maxsize"`      // Max size in bytes. 0 = unlimited.
+	Policy       string    `json:"policy"`       // Eviction policy: "lru" or "lfu".
+	PinnedFiles  uint64    `json:"pinnedfiles"`  // Count of pinned files in the warehouse.
+	PinnedSize   uint64    `json:"pinnedsize"`   // Total size of pinned files in bytes.
+	SharedFiles  uint64    `json:"sharedfiles"`  // Count of files shared by the user, which are not evictable.
+	SharedSize   uint64    `json:"sharedsize"`   // Total size of shared files in bytes.
+	Evictions    uint64    `json:"evictions"`    // Count of evicted files since start.
+	EvictedSize  uint64    `json:"evictedsize"`  // Total size of evicted files since start.
+	LastEviction time.Time `json:"lasteviction"` // Time of the last eviction. Zero if none.
+}
+
+/*
+apiWarehouseStats returns the disk usage of the warehouse and eviction statistics.
+
+Request:    GET /warehouse/stats
+Response:   200 with JSON structure WarehouseStatsResult
+
+	500 if the blockchain cannot be read
+*/
+func (api *WebapiInstance) apiWarehouseStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := api.Backend.WarehouseStats()
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, WarehouseStatsResult{
+		Files:        stats.Files,
+		Size:         stats.Size,
+		MaxSize:      stats.MaxSize,
+		Policy:       stats.Policy,
+		PinnedFiles:  stats.PinnedFiles,
+		PinnedSize:   stats.PinnedSize,
+		SharedFiles:  stats.SharedFiles,
+		SharedSize:   stats.SharedSize,
+		Evictions:    stats.Evictions,
+		EvictedSize:  stats.EvictedSize,
+		LastEviction: stats.LastEviction,
+	})
+}
+
+/*
+apiWarehousePin pins or unpins a file. Pinned files are never evicted nor deleted by the garbage collection. The file does not need to exist.
+
+Request:    GET /warehouse/pin?hash=[hash]&pin=[0 or 1]
+Response:   204 Empty
+
+	400 if invalid hash
+*/
+func (api *WebapiInstance) apiWarehousePin(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hash, valid := DecodeBlake3Hash(r.Form.Get("hash"))
+	if !valid {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+	pin, _ := strconv.ParseBool(r.Form.Get("pin"))
+
+	if err := api.Backend.WarehousePin(hash, pin); err != nil {
+		EncodeError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WarehouseScrubResult is the status of the warehouse scrubber
+type WarehouseScrubResult struct {
+	Running      bool      `json:"running"`      // Whether a run is in progress. The counters refer to the current run.
+	LastStart    time.Time `json:"laststart"`    // Start of the current or last run. Zero if never run.
+	LastEnd      time.Time `json:"lastend"`      // End of the last run. Zero if not yet completed.
+	FilesChecked uint64    `json:"fileschecked"` // Count of files verified.
+	BytesChecked uint64    `json:"byteschecked"` // Total size of files verified.
+	Corrupt      [][]byte  `json:"corrupt"`      // Hashes of corrupt files found. They are moved to the quarantine folder.
+	Refetched    [][]byte  `json:"refetched"`    // Hashes of corrupt files that were successfully downloaded again.
+	TotalCorrupt uint64    `json:"totalcorrupt"` // Count of corrupt files found since start.
+}
+
+/*
+apiWarehouseScrub returns the status of the warehouse scrubber, which verifies all files against their hash. If start is set, a run is started
+in the background.
+
+Request:    GET /warehouse/scrub?start=[0 or 1]
+Response:   200 with JSON structure WarehouseScrubResult
+
+	409 if start is set and a run is already in progress
+	503 if the warehouse is disabled
+*/
+func (api *WebapiInstance) apiWarehouseScrub(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if api.Backend.UserWarehouse == nil || api.Backend.UserWarehouse.Disabled {
+		EncodeError(w, http.StatusServiceUnavailable, "warehouse is disabled")
+		return
+	}
+
+	if start, _ := strconv.ParseBool(r.Form.Get("start")); start {
+		if api.Backend.WarehouseScrubStatusGet().Running {
+			EncodeError(w, http.StatusConflict, "scrub already running")
+			return
+		}
+
+		go api.Backend.WarehouseScrub()
+	}
+
+	status := api.Backend.WarehouseScrubStatusGet()
+
+	EncodeJSON(api.Backend, w, r, WarehouseScrubResult{
+		Running:      status.Running,
+		LastStart:    status.LastStart,
+		LastEnd:      status.LastEnd,
+		FilesChecked: status.FilesChecked,
+		BytesChecked: status.BytesChecked,
+		Corrupt:      status.Corrupt,
+		Refetched:    status.Refetched,
+		TotalCorrupt: status.TotalCorrupt,
+	})
+}
+
 /*
 apiWarehouseReadFilePath reads a file from the warehouse and stores it to the target file. It fails with StatusErrorTargetExists if the target file already exists.
 The path must include the full directory and file name.
@@ -184,7 +338,7 @@ func (api *WebapiInstance) apiWarehouseReadFilePath(w http.ResponseWriter, r *ht
 	r.ParseForm()
 	hash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
 	if !valid1 {
-		http.Error(w, "", http.StatusBadRequest)
+		EncodeError(w, http.StatusBadRequest, "")
 		return
 	}
 